@@ -0,0 +1,270 @@
+// -----------------------------------------------------------------------------------
+// ADMIN COMMANDS: PAUSE, RESUME AND RECONFIGURE A SERVER WHILE IT RUNS
+//
+// A server of the course runs with the capacities it was compiled with until
+// the end. With
+//     -admin localhost:6070
+// its loop also receives admin commands, on the channel adminCommands, sent
+// over HTTP: the words of a command are the path of the URL.
+//     pause              /pause              grant nothing new (exits and returns go on)
+//     resume             /resume             grant again
+//     set <name> <n>     /set/MAX/12         change a capacity, between 0 and its constant
+//     out <what> <i>     /out/office/2       take resource i out of service
+//     in <what> <i>      /in/office/2        put it back in service
+//     dump               /dump               print the state of the server
+// The commands are sent with POST, and dump also with GET, e.g. with
+//     curl -X POST localhost:6070/set/MAX/12
+//     curl localhost:6070/dump
+// or with adminctl.go:
+//     go run ../../admin/adminctl.go ../../admin/admin.go set MAX 12
+//
+// The server answers every command, with its new state or an error, and the
+// HTTP request gets the answer. A capacity can be lowered below what is taken:
+// the server admits nobody until the occupancy drops below the new limit, and
+// whoever is in stays. A resource in use when it is taken out of service is
+// taken out when it is free again.
+//
+// Without -admin adminCommands is nil and the case of the server never fires.
+//
+// Every server loop of the course has the case, except where there is no
+// server: the token ring of lab/lab4/ex1ring.go and the two gates of
+// writtenExams/09-01-2023/gates.go split the state among processes, and a
+// command would have to be agreed by all of them. With -bridge ring or
+// -road gates alone nobody receives the commands, and they are refused at the
+// end.
+//
+// Run the tests with:
+//     go test admin.go admin_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var adminFlag = flag.String("admin", "", "accept admin commands over HTTP on `addr`, e.g. localhost:6070 (see admin.go)")
+
+// Admin operations
+const (
+	ADMIN_PAUSE  = "pause"
+	ADMIN_RESUME = "resume"
+	ADMIN_SET    = "set"
+	ADMIN_OUT    = "out"
+	ADMIN_IN     = "in"
+	ADMIN_DUMP   = "dump"
+)
+
+// The commands for the server, nil without -admin
+var adminCommands chan AdminCommand
+
+// adminClosed is closed when the admin commands stop: a command that the
+// server has not received by then is refused, and one it has received but not
+// answered fails.
+var adminClosed chan struct{}
+
+// ============================================================
+//                          COMMANDS
+// ============================================================
+
+// AdminCommand is a command for the server, answered on reply.
+type AdminCommand struct {
+	Op    string // one of the ADMIN_* operations
+	Name  string // set: the capacity (MAX); out, in: the resource (office)
+	Value int    // set: the new capacity; out, in: which resource
+	reply chan adminAnswer
+}
+
+type adminAnswer struct {
+	text string
+	err  error
+}
+
+func (c AdminCommand) String() string {
+	switch c.Op {
+	case ADMIN_SET, ADMIN_OUT, ADMIN_IN:
+		return fmt.Sprintf("%s %s %d", c.Op, c.Name, c.Value)
+	}
+	return c.Op
+}
+
+// ParseAdminCommand parses the words of a command: "set MAX 12".
+func ParseAdminCommand(line string) (AdminCommand, error) {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return AdminCommand{}, errors.New("empty command")
+	}
+	c := AdminCommand{Op: words[0]}
+	args := 0
+	switch c.Op {
+	case ADMIN_PAUSE, ADMIN_RESUME, ADMIN_DUMP:
+	case ADMIN_SET, ADMIN_OUT, ADMIN_IN:
+		args = 2
+	default:
+		return c, fmt.Errorf("unknown command %q (pause, resume, set, out, in, dump)", c.Op)
+	}
+	if len(words)-1 != args {
+		return c, fmt.Errorf("%s takes %d arguments, not %d", c.Op, args, len(words)-1)
+	}
+	if args > 0 {
+		c.Name = words[1]
+		n, err := strconv.Atoi(words[2])
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("%s %s: %q is not a number >= 0", c.Op, c.Name, words[2])
+		}
+		c.Value = n
+	}
+	return c, nil
+}
+
+// Reply answers the command with text.
+func (c AdminCommand) Reply(format string, args ...any) {
+	c.answer(adminAnswer{text: fmt.Sprintf(format, args...)})
+}
+
+// Fail answers the command with an error: the server did nothing.
+func (c AdminCommand) Fail(format string, args ...any) {
+	c.answer(adminAnswer{err: fmt.Errorf(format, args...)})
+}
+
+// answer sends a, unless the command has an answer already: a replicated
+// server (see writtenExams/28-01-2022/replicas.go) may answer it twice, and
+// the first answer is the one kept.
+func (c AdminCommand) answer(a adminAnswer) {
+	select {
+	case c.reply <- a:
+	default:
+	}
+}
+
+// Capacity returns the new value of a capacity whose constant is limit, or an
+// error (already answered) if it is out of [0, limit].
+func (c AdminCommand) Capacity(limit int) (int, error) {
+	if c.Value > limit {
+		err := fmt.Errorf("%s cannot be above %d", c.Name, limit)
+		c.Fail("%v", err)
+		return 0, err
+	}
+	return c.Value, nil
+}
+
+// Resource returns the index of one of n resources, or an error (already
+// answered) if there is no such resource.
+func (c AdminCommand) Resource(n int) (int, error) {
+	if c.Value >= n {
+		err := fmt.Errorf("there is no %s %d (0-%d)", c.Name, c.Value, n-1)
+		c.Fail("%v", err)
+		return 0, err
+	}
+	return c.Value, nil
+}
+
+// Admin sends the command in line to the server and returns its answer, or an
+// error if the server ends without answering.
+func Admin(line string) (string, error) {
+	c, err := ParseAdminCommand(line)
+	if err != nil {
+		return "", err
+	}
+	if adminCommands == nil {
+		return "", errors.New("no admin commands: run with -admin")
+	}
+	c.reply = make(chan adminAnswer, 1) // the server never waits for the admin
+	select {
+	case adminCommands <- c:
+	case <-adminClosed:
+		return "", errors.New("the server is closed")
+	}
+	select {
+	case a := <-c.reply:
+		return a.text, a.err
+	case <-adminClosed:
+		// Unless it answered just before ending
+		select {
+		case a := <-c.reply:
+			return a.text, a.err
+		default:
+			return "", errors.New("the server ended without answering")
+		}
+	}
+}
+
+// ============================================================
+//                            HTTP
+// ============================================================
+
+// adminHandler runs the command in the path of the URL: /set/MAX/12. The
+// commands change the server, and are sent with POST; dump, which only reads
+// it, can also be sent with GET (e.g. from a browser).
+func adminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		line := strings.ReplaceAll(strings.Trim(r.URL.Path, "/"), "/", " ")
+		allow := http.MethodPost
+		if line == ADMIN_DUMP {
+			allow = http.MethodGet + ", " + http.MethodPost
+		}
+		if r.Method != http.MethodPost && (r.Method != http.MethodGet || line != ADMIN_DUMP) {
+			w.Header().Set("Allow", allow)
+			http.Error(w, fmt.Sprintf("%s: use %s", r.Method, allow), http.StatusMethodNotAllowed)
+			return
+		}
+		text, err := Admin(line)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, text)
+	})
+}
+
+// StartAdmin makes the channel of the admin commands and, if addr is not empty,
+// serves them over HTTP on addr. stop refuses the commands not received by the
+// server and closes the HTTP server: it is called once the server has
+// terminated.
+func StartAdmin(addr string) (stop func(), err error) {
+	adminCommands, adminClosed = make(chan AdminCommand), make(chan struct{})
+	var srv *http.Server
+	var wg sync.WaitGroup
+	if addr != "" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			adminCommands = nil
+			return nil, err
+		}
+		srv = &http.Server{Handler: adminHandler()}
+		wg.Go(func() { srv.Serve(ln) })
+		fmt.Printf("[admin] Commands on http://%s/ (pause, resume, set, out, in, dump)\n", ln.Addr())
+	}
+	return func() {
+		close(adminClosed)
+		if srv != nil {
+			srv.Close()
+			wg.Wait()
+		}
+	}, nil
+}
+
+// StartAdminFromFlags starts the admin commands of -admin, if any, and returns
+// the function that stops them.
+func StartAdminFromFlags() (stop func()) {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	if *adminFlag == "" {
+		return func() {}
+	}
+	stop, err := StartAdmin(*adminFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		os.Exit(2)
+	}
+	return stop
+}
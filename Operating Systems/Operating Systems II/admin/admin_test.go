@@ -0,0 +1,164 @@
+// Tests for the admin commands: parsing, a command answered by a small server
+// loop directly and over HTTP, the commands refused once the server is closed
+// and a command the server ends without answering.
+//
+// Run with:
+//     go test admin.go admin_test.go
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseAdminCommand(t *testing.T) {
+	for _, tc := range []struct {
+		line, want string // want: the command, or the start of the error
+	}{
+		{"pause", "pause"},
+		{"  set   MAX 12 ", "set MAX 12"},
+		{"out office 2", "out office 2"},
+		{"", "empty command"},
+		{"stop", `unknown command "stop"`},
+		{"set MAX", "set takes 2 arguments, not 1"},
+		{"dump all", "dump takes 0 arguments, not 1"},
+		{"in bike -1", `in bike: "-1" is not a number`},
+	} {
+		c, err := ParseAdminCommand(tc.line)
+		got := c.String()
+		if err != nil {
+			got = err.Error()
+		}
+		if !strings.HasPrefix(got, tc.want) {
+			t.Errorf("%q: %q, want %q", tc.line, got, tc.want)
+		}
+	}
+}
+
+// counter is the server loop of the tests: a capacity MAX of at most 5, and
+// three doors.
+func counter(quit chan bool) {
+	limit, paused := 5, false
+	var closed [3]bool
+	for {
+		select {
+		case c := <-adminCommands:
+			switch c.Op {
+			case ADMIN_PAUSE, ADMIN_RESUME:
+				paused = c.Op == ADMIN_PAUSE
+				c.Reply("paused: %v", paused)
+			case ADMIN_SET:
+				if n, err := c.Capacity(5); err == nil {
+					limit = n
+					c.Reply("MAX: %d", limit)
+				}
+			case ADMIN_OUT, ADMIN_IN:
+				if i, err := c.Resource(len(closed)); err == nil {
+					closed[i] = c.Op == ADMIN_OUT
+					c.Reply("closed: %v", closed)
+				}
+			default:
+				c.Reply("MAX: %d, paused: %v, closed: %v", limit, paused, closed)
+			}
+		case <-quit:
+			quit <- true
+			return
+		}
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	if _, err := Admin("dump"); err == nil {
+		t.Error("a command without -admin was sent")
+	}
+	stop, err := StartAdmin("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quit := make(chan bool)
+	go counter(quit)
+
+	for _, tc := range []struct {
+		line, want string
+		err        bool
+	}{
+		{"set MAX 3", "MAX: 3", false},
+		{"set MAX 6", "MAX cannot be above 5", true},
+		{"pause", "paused: true", false},
+		{"out door 1", "closed: [false true false]", false},
+		{"out door 3", "there is no door 3 (0-2)", true},
+		{"dump", "MAX: 3, paused: true, closed: [false true false]", false},
+	} {
+		got, err := Admin(tc.line)
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tc.want || (err != nil) != tc.err {
+			t.Errorf("%q: %q (error %v), want %q", tc.line, got, err != nil, tc.want)
+		}
+	}
+
+	// Over HTTP, with the path of the URL as the command
+	h := adminHandler()
+	for _, tc := range []struct {
+		path, want string
+		code       int
+	}{
+		{"/resume", "paused: false\n", http.StatusOK},
+		{"/in/door/1/", "closed: [false false false]\n", http.StatusOK},
+		{"/set/MAX/x", "set MAX: \"x\" is not a number >= 0\n", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", tc.path, nil))
+		if w.Code != tc.code || w.Body.String() != tc.want {
+			t.Errorf("%s: %d %q, want %d %q", tc.path, w.Code, w.Body.String(), tc.code, tc.want)
+		}
+	}
+
+	// Only dump can be sent with GET
+	for _, tc := range []struct {
+		method, path string
+		code         int
+		allow        string
+	}{
+		{"GET", "/dump", http.StatusOK, ""},
+		{"GET", "/pause", http.StatusMethodNotAllowed, "POST"},
+		{"GET", "/set/MAX/1", http.StatusMethodNotAllowed, "POST"},
+		{"PUT", "/resume", http.StatusMethodNotAllowed, "POST"},
+		{"DELETE", "/dump", http.StatusMethodNotAllowed, "GET, POST"},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.code || w.Header().Get("Allow") != tc.allow {
+			t.Errorf("%s %s: %d, Allow %q, want %d, Allow %q", tc.method, tc.path, w.Code, w.Header().Get("Allow"), tc.code, tc.allow)
+		}
+	}
+	if got, _ := Admin("dump"); got != "MAX: 3, paused: false, closed: [false false false]" {
+		t.Errorf("after the refused commands: %q, want them not run", got)
+	}
+
+	quit <- true
+	<-quit
+	stop()
+	if _, err := Admin("dump"); err == nil || err.Error() != "the server is closed" {
+		t.Errorf("a command after the end of the server: %v, want it refused", err)
+	}
+}
+
+func TestAdminUnanswered(t *testing.T) {
+	stop, err := StartAdmin("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A server that ends with the command received but not answered
+	go func() {
+		<-adminCommands
+		stop()
+	}()
+	if _, err := Admin("dump"); err == nil || err.Error() != "the server ended without answering" {
+		t.Errorf("a command never answered: %v, want it failed", err)
+	}
+}
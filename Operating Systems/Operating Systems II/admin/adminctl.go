@@ -0,0 +1,57 @@
+// -----------------------------------------------------------------------------------
+// ADMINCTL: SEND AN ADMIN COMMAND TO A RUNNING SERVER
+//
+// Sends a command to a scenario running with -admin (see admin.go) and prints
+// its answer; exits with status 1 if the server refused it.
+//
+// Run with:
+//     go run adminctl.go admin.go [-addr localhost:6070] command [args]
+// e.g. from writtenExams/07-01-2025, while examSolB.go runs with -admin:
+//     go run ../../admin/adminctl.go ../../admin/admin.go set MAX 12
+//     go run ../../admin/adminctl.go ../../admin/admin.go dump
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var addrFlag = flag.String("addr", "localhost:6070", "`address` of the -admin of the server")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: go run adminctl.go admin.go [-addr host:port] pause|resume|set name n|out what i|in what i|dump")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	c, err := ParseAdminCommand(strings.Join(flag.Args(), " "))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "adminctl:", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	url := "http://" + *addrFlag + "/" + strings.ReplaceAll(c.String(), " ", "/")
+	// dump only reads the state of the server, the other commands change it
+	var resp *http.Response
+	if c.Op == ADMIN_DUMP {
+		resp, err = http.Get(url)
+	} else {
+		resp, err = http.Post(url, "text/plain", nil)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "adminctl:", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Print(string(body))
+	if resp.StatusCode != http.StatusOK {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,370 @@
+// -----------------------------------------------------------------------------------
+// LOGICAL CLOCKS: CAUSALITY OF THE MESSAGES OF A SCENARIO
+//
+// The output of a scenario is the fmt.Printf lines of its goroutines in the
+// order they were printed, which is not the order of what they report: a client
+// that prints "I am in the hall" after the ack of the server may print before
+// another client that the server let out earlier. With
+//     -causal trace.jsonl
+// every goroutine of the scenario keeps a Lamport clock and a vector clock (a
+// Clock), the requests (the Request, richiesta and User structs) carry the
+// time of their send (a Stamp) and the acks carry theirs beside the channel
+// (SendOn and ReceivedOn), and every send, receive and printed line is written
+// to the trace, one JSON event per line, in the order the lines were printed.
+//
+// hb.go reads the trace:
+//   - it prints the lines with their Lamport time and the happens-before
+//     relation with the previous line: -> (the previous one happened before) or
+//     || (concurrent: they could have been printed the other way round);
+//   - with -messages, every message: send -> receive;
+//   - it finds the pairs of concurrent lines printed in a misleading order: what
+//     the later line reports happened before what the earlier one reports. A
+//     line reports the last event of its goroutine before it, and a receive
+//     reports its send: "[Visitor 3] entered the hall", printed after the ack,
+//     reports the decision of the server to send it.
+// e.g. from writtenExams/14-02-2022:
+//     go run examSol.go workload.go leaks.go causal.go admin.go -causal trace.jsonl
+//     go run ../../causal/hb.go ../../causal/causal.go trace.jsonl
+//
+// Without -causal the clocks are nil and cost nothing: Clock.Printf is
+// fmt.Printf, SendOn and ReceivedOn plain sends and receives. The stamps of the
+// acks wait in a queue per channel: a channel must be used with SendOn on all of
+// its sends, or on none.
+//
+// Run the tests with:
+//     go test causal.go causal_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var causalFlag = flag.String("causal", "", "write the logical clocks of the sends, receives and printed lines to `file` (see causal.go)")
+
+// ============================================================
+//                          CLOCKS
+// ============================================================
+
+// Stamp is the logical time of a send, carried by the message.
+type Stamp struct {
+	Msg     int // number of the message in the trace (0: no stamp)
+	Lamport int
+	Vector  map[string]int
+}
+
+// Clock is the logical clock of a goroutine. A nil *Clock (no -causal) records
+// nothing.
+type Clock struct {
+	name    string
+	lamport int
+	vector  map[string]int
+}
+
+// Event is a line of the trace.
+type Event struct {
+	Seq     int            `json:"seq"`  // order in the trace
+	Proc    string         `json:"proc"` // the goroutine
+	Kind    string         `json:"kind"` // send, receive or print
+	Label   string         `json:"label,omitempty"`
+	Msg     int            `json:"msg,omitempty"` // the message sent or received
+	Lamport int            `json:"lamport"`
+	Vector  map[string]int `json:"vector"`
+	Text    string         `json:"text,omitempty"` // the printed line
+}
+
+// trace is where the events go, with -causal.
+var trace struct {
+	sync.Mutex
+	w     *bufio.Writer
+	f     *os.File
+	seq   int
+	msgs  int
+	acks  map[any][]Stamp // stamps of the values in a channel
+	locks map[any]*sync.Mutex
+}
+
+// StartCausal opens the trace if -causal is given. main calls it before it
+// starts the goroutines, and StopCausal at the end.
+func StartCausal() {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	if *causalFlag == "" {
+		return
+	}
+	f, err := os.Create(*causalFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "causal:", err)
+		os.Exit(2)
+	}
+	trace.f, trace.w = f, bufio.NewWriter(f)
+	trace.acks = map[any][]Stamp{}
+	trace.locks = map[any]*sync.Mutex{}
+}
+
+// StopCausal writes what remains of the trace and closes it.
+func StopCausal() {
+	trace.Lock()
+	defer trace.Unlock()
+	if trace.w == nil {
+		return
+	}
+	trace.w.Flush()
+	trace.f.Close()
+	trace.w = nil
+}
+
+// NewClock returns the clock of the goroutine called name, nil without -causal.
+func NewClock(name string) *Clock {
+	trace.Lock()
+	defer trace.Unlock()
+	if trace.w == nil {
+		return nil
+	}
+	return &Clock{name: name, vector: map[string]int{}}
+}
+
+// record ticks the clock for an event and writes it; the trace is locked.
+func (c *Clock) record(kind, label string, msg int, text string) {
+	c.lamport++
+	c.vector[c.name]++
+	trace.seq++
+	e := Event{trace.seq, c.name, kind, label, msg, c.lamport, c.vector, text}
+	if trace.w != nil {
+		b, _ := json.Marshal(e)
+		trace.w.Write(append(b, '\n'))
+	}
+}
+
+// Send records the send of a message and returns the stamp it carries.
+func (c *Clock) Send(label string) Stamp {
+	if c == nil {
+		return Stamp{}
+	}
+	trace.Lock()
+	defer trace.Unlock()
+	trace.msgs++
+	c.record("send", label, trace.msgs, "")
+	return Stamp{trace.msgs, c.lamport, maps.Clone(c.vector)}
+}
+
+// Receive records the receive of a message with stamp s.
+func (c *Clock) Receive(s Stamp, label string) {
+	if c == nil {
+		return
+	}
+	trace.Lock()
+	defer trace.Unlock()
+	c.lamport = max(c.lamport, s.Lamport)
+	for p, t := range s.Vector {
+		c.vector[p] = max(c.vector[p], t)
+	}
+	c.record("receive", label, s.Msg, "")
+}
+
+// Printf prints like fmt.Printf and records the line.
+func (c *Clock) Printf(format string, args ...any) {
+	if c == nil {
+		fmt.Printf(format, args...)
+		return
+	}
+	trace.Lock()
+	defer trace.Unlock()
+	text := fmt.Sprintf(format, args...)
+	fmt.Print(text)
+	c.record("print", "", 0, strings.TrimSpace(text))
+}
+
+// SendOn sends v on ch, ch <- v, with the stamp of the send beside it: for the
+// channels of values without room for a Stamp, like the acks.
+func SendOn[T any](c *Clock, ch chan T, v T, label string) {
+	if c == nil {
+		ch <- v
+		return
+	}
+	// The senders of ch send one at a time, so the stamps are queued in the
+	// order of the values.
+	trace.Lock()
+	lock := trace.locks[ch]
+	if lock == nil {
+		lock = new(sync.Mutex)
+		trace.locks[ch] = lock
+	}
+	trace.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+	s := c.Send(label)
+	trace.Lock()
+	trace.acks[ch] = append(trace.acks[ch], s)
+	trace.Unlock()
+	ch <- v
+}
+
+// ReceivedOn records the receive of a value sent with SendOn, after v := <-ch.
+func ReceivedOn[T any](c *Clock, ch chan T, label string) {
+	if c == nil {
+		return
+	}
+	trace.Lock()
+	var s Stamp
+	if q := trace.acks[ch]; len(q) > 0 {
+		s, trace.acks[ch] = q[0], q[1:]
+	}
+	trace.Unlock()
+	c.Receive(s, label)
+}
+
+// ============================================================
+//                         ANALYSIS
+// ============================================================
+
+// ReadCausal reads a trace.
+func ReadCausal(r io.Reader) ([]Event, error) {
+	var events []Event
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<24)
+	for line := 1; sc.Scan(); line++ {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("causal: line %d: %v", line, err)
+		}
+		events = append(events, e)
+	}
+	return events, sc.Err()
+}
+
+// HappensBefore reports whether a happened before b: b knows of a, and they
+// are not the same event.
+func HappensBefore(a, b Event) bool {
+	return a.Seq != b.Seq && a.Vector[a.Proc] <= b.Vector[a.Proc]
+}
+
+// Concurrent reports whether neither of a and b happened before the other.
+func Concurrent(a, b Event) bool {
+	return a.Seq != b.Seq && !HappensBefore(a, b) && !HappensBefore(b, a)
+}
+
+// Misleading is a pair of concurrent lines printed in the order opposite to what
+// they report: Later reports Cause, which happened before Effect, reported by
+// Earlier.
+type Misleading struct {
+	Earlier, Later Event
+	Effect, Cause  Event
+}
+
+// Analysis is what a trace says.
+type Analysis struct {
+	Events     []Event
+	Procs      []string
+	Messages   [][2]Event // send, receive
+	Lines      []Event
+	Misleading []Misleading
+}
+
+// Analyze finds the messages and the lines of the trace, and the lines printed
+// in a misleading order.
+func Analyze(events []Event) Analysis {
+	a := Analysis{Events: events}
+	sends := map[int]Event{}
+	procs := map[string]bool{}
+	var reports []Event // what every line reports
+	last := map[string]Event{}
+	for _, e := range events {
+		procs[e.Proc] = true
+		switch e.Kind {
+		case "send":
+			sends[e.Msg] = e
+		case "receive":
+			if s, ok := sends[e.Msg]; ok {
+				a.Messages = append(a.Messages, [2]Event{s, e})
+			}
+		case "print":
+			a.Lines = append(a.Lines, e)
+			r, ok := last[e.Proc]
+			if !ok {
+				r = e
+			} else if s, sent := sends[r.Msg]; r.Kind == "receive" && sent {
+				r = s
+			}
+			reports = append(reports, r)
+		}
+		if e.Kind != "print" {
+			last[e.Proc] = e
+		}
+	}
+	a.Procs = slices.Sorted(maps.Keys(procs))
+
+	for i := range a.Lines {
+		for j := i + 1; j < len(a.Lines); j++ {
+			if Concurrent(a.Lines[i], a.Lines[j]) && HappensBefore(reports[j], reports[i]) {
+				a.Misleading = append(a.Misleading, Misleading{a.Lines[i], a.Lines[j], reports[i], reports[j]})
+			}
+		}
+	}
+	return a
+}
+
+// Report writes the lines with the happens-before relation between each one and
+// the previous one, the messages if messages is true, and the lines printed in
+// a misleading order.
+func (a Analysis) Report(w io.Writer, messages bool) {
+	fmt.Fprintf(w, "CAUSALITY: %d events of %d goroutines, %d messages, %d printed lines\n",
+		len(a.Events), len(a.Procs), len(a.Messages), len(a.Lines))
+
+	if messages {
+		fmt.Fprintf(w, "\nMESSAGES: send -> receive\n")
+		sort.SliceStable(a.Messages, func(i, j int) bool { return a.Messages[i][1].Seq < a.Messages[j][1].Seq })
+		for _, m := range a.Messages {
+			fmt.Fprintf(w, "  #%-5d %-14s %-28s -> #%-5d %-14s %s\n",
+				m[0].Seq, m[0].Proc, m[0].Label, m[1].Seq, m[1].Proc, m[1].Label)
+		}
+	}
+
+	fmt.Fprintf(w, "\nLINES: -> the previous line happened before, || concurrent\n")
+	for i, l := range a.Lines {
+		rel := "  "
+		if i > 0 {
+			rel = "||"
+			if HappensBefore(a.Lines[i-1], l) {
+				rel = "->"
+			}
+		}
+		fmt.Fprintf(w, "%s %5d  L=%-5d %-14s %s\n", rel, l.Seq, l.Lamport, l.Proc, l.Text)
+	}
+
+	fmt.Fprintln(w)
+	if len(a.Misleading) == 0 {
+		fmt.Fprintln(w, "No line printed in a misleading order")
+		return
+	}
+	fmt.Fprintf(w, "MISLEADING ORDER: %d pairs of concurrent lines printed in the order opposite to what they report\n", len(a.Misleading))
+	for i, m := range a.Misleading {
+		if i == 20 {
+			fmt.Fprintf(w, "  ... and %d more\n", len(a.Misleading)-i)
+			break
+		}
+		fmt.Fprintf(w, "  #%d [%s] %s\n", m.Earlier.Seq, m.Earlier.Proc, m.Earlier.Text)
+		fmt.Fprintf(w, "    printed before #%d [%s] %s\n", m.Later.Seq, m.Later.Proc, m.Later.Text)
+		fmt.Fprintf(w, "    but %s happened before %s\n", describe(m.Cause), describe(m.Effect))
+	}
+}
+
+// describe names an event of the trace.
+func describe(e Event) string {
+	if e.Kind == "print" {
+		return fmt.Sprintf("#%d (%s prints)", e.Seq, e.Proc)
+	}
+	return fmt.Sprintf("#%d (%s: %s %s)", e.Seq, e.Proc, e.Kind, e.Label)
+}
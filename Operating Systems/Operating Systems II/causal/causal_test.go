@@ -0,0 +1,160 @@
+// Tests for the logical clocks: a traced request and ack between two
+// goroutines, the happens-before relation of vector clocks, and the lines
+// printed in a misleading order in a trace built by hand.
+//
+// Run with:
+//     go test causal.go causal_test.go
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type request struct {
+	id    int
+	ack   chan int
+	stamp Stamp
+}
+
+// traced runs f with -causal writing to a temporary file and returns the
+// events of the trace.
+func traced(t *testing.T, f func()) []Event {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	*causalFlag = path
+	defer func() { *causalFlag = "" }()
+	StartCausal()
+	f()
+	StopCausal()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	events, err := ReadCausal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestNilClock(t *testing.T) {
+	var c *Clock = NewClock("nobody")
+	if c != nil {
+		t.Fatal("NewClock without -causal returned a clock")
+	}
+	if s := c.Send("x"); s.Msg != 0 || s.Vector != nil {
+		t.Errorf("stamp of a nil clock: %+v", s)
+	}
+	ch := make(chan int, 1)
+	SendOn(c, ch, 7, "ack")
+	if v := <-ch; v != 7 {
+		t.Errorf("received %d, want 7", v)
+	}
+	ReceivedOn(c, ch, "ack")
+	c.Receive(Stamp{}, "x")
+}
+
+func TestRequestAck(t *testing.T) {
+	events := traced(t, func() {
+		requests := make(chan request)
+		done := make(chan bool)
+		go func() {
+			clock := NewClock("server")
+			r := <-requests
+			clock.Receive(r.stamp, "requests")
+			SendOn(clock, r.ack, 1, "ack")
+			done <- true
+		}()
+		clock := NewClock("client")
+		r := request{id: 1, ack: make(chan int)}
+		clock.Printf("")
+		r.stamp = clock.Send("requests")
+		requests <- r
+		<-r.ack
+		ReceivedOn(clock, r.ack, "ack")
+		clock.Printf("")
+		<-done
+	})
+
+	var kinds []string
+	for _, e := range events {
+		kinds = append(kinds, e.Proc+" "+e.Kind)
+	}
+	want := "client print,client send,server receive,server send,client receive,client print"
+	if got := strings.Join(kinds, ","); got != want {
+		t.Fatalf("events %s, want %s", got, want)
+	}
+	a := Analyze(events)
+	if len(a.Messages) != 2 || len(a.Lines) != 2 || len(a.Procs) != 2 {
+		t.Errorf("%d messages, %d lines, %d goroutines: want 2, 2, 2", len(a.Messages), len(a.Lines), len(a.Procs))
+	}
+	// Every event happened before the next one, through the messages.
+	for i := 1; i < len(events); i++ {
+		if !HappensBefore(events[i-1], events[i]) || HappensBefore(events[i], events[i-1]) {
+			t.Errorf("#%d -> #%d: %v, reverse %v", i, i+1, HappensBefore(events[i-1], events[i]), HappensBefore(events[i], events[i-1]))
+		}
+		if events[i].Lamport <= events[i-1].Lamport {
+			t.Errorf("Lamport time %d after %d", events[i].Lamport, events[i-1].Lamport)
+		}
+	}
+	if got := events[4].Vector; got["client"] != 3 || got["server"] != 2 {
+		t.Errorf("vector of the receive of the ack: %v, want client 3, server 2", got)
+	}
+}
+
+// event returns an event of the trace built by hand.
+func event(seq int, proc, kind string, msg int, vector map[string]int, text string) Event {
+	return Event{Seq: seq, Proc: proc, Kind: kind, Msg: msg, Vector: vector, Text: text}
+}
+
+func TestMisleading(t *testing.T) {
+	// The server lets in a and then b; b prints first.
+	events := []Event{
+		event(1, "a", "send", 1, map[string]int{"a": 1}, ""),
+		event(2, "b", "send", 2, map[string]int{"b": 1}, ""),
+		event(3, "server", "receive", 1, map[string]int{"a": 1, "server": 1}, ""),
+		event(4, "server", "send", 3, map[string]int{"a": 1, "server": 2}, ""),
+		event(5, "server", "receive", 2, map[string]int{"a": 1, "b": 1, "server": 3}, ""),
+		event(6, "server", "send", 4, map[string]int{"a": 1, "b": 1, "server": 4}, ""),
+		event(7, "b", "receive", 4, map[string]int{"a": 1, "b": 2, "server": 4}, ""),
+		event(8, "b", "print", 0, map[string]int{"a": 1, "b": 3, "server": 4}, "b is in"),
+		event(9, "a", "receive", 3, map[string]int{"a": 2, "server": 2}, ""),
+		event(10, "a", "print", 0, map[string]int{"a": 3, "server": 2}, "a is in"),
+	}
+	if !Concurrent(events[7], events[9]) {
+		t.Fatal("the two lines are not concurrent")
+	}
+	if !HappensBefore(events[3], events[7]) || !HappensBefore(events[0], events[9]) || HappensBefore(events[9], events[7]) {
+		t.Fatal("wrong happens-before relation")
+	}
+	a := Analyze(events)
+	if len(a.Misleading) != 1 {
+		t.Fatalf("%d misleading pairs, want 1", len(a.Misleading))
+	}
+	m := a.Misleading[0]
+	if m.Earlier.Seq != 8 || m.Later.Seq != 10 || m.Effect.Seq != 6 || m.Cause.Seq != 4 {
+		t.Errorf("misleading pair %d, %d reporting %d, %d: want 8, 10 reporting 6, 4",
+			m.Earlier.Seq, m.Later.Seq, m.Effect.Seq, m.Cause.Seq)
+	}
+	var out strings.Builder
+	a.Report(&out, true)
+	for _, s := range []string{"4 messages", "|| ", "MISLEADING ORDER: 1 pairs", "#4 (server: send ) happened before #6"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("report without %q:\n%s", s, out.String())
+		}
+	}
+
+	// In the order of the server, nothing is misleading.
+	events = append(events[:6:6], events[8], events[9], events[6], events[7])
+	for i := range events {
+		events[i].Seq = i + 1
+	}
+	if a := Analyze(events); len(a.Misleading) != 0 {
+		t.Errorf("%d misleading pairs in the order of the server", len(a.Misleading))
+	}
+}
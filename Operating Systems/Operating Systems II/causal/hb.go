@@ -0,0 +1,48 @@
+// -----------------------------------------------------------------------------------
+// HB: HAPPENS-BEFORE OF A TRACE OF LOGICAL CLOCKS
+//
+// Reads the trace written by a scenario run with -causal (see causal.go) and
+// prints its printed lines with the happens-before relation between each one
+// and the previous one, the messages with -messages, and the pairs of lines
+// printed in a misleading order.
+//
+// Run with:
+//     go run hb.go causal.go [-messages] trace.jsonl
+// e.g. from writtenExams/14-02-2022:
+//     go run examSol.go workload.go leaks.go causal.go admin.go -causal trace.jsonl
+//     go run ../../causal/hb.go ../../causal/causal.go trace.jsonl
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+var messagesFlag = flag.Bool("messages", false, "list every message, send -> receive")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: go run hb.go causal.go [-messages] trace.jsonl")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	events, err := ReadCausal(f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	Analyze(events).Report(os.Stdout, *messagesFlag)
+}
@@ -0,0 +1,692 @@
+// -----------------------------------------------------------------------------------
+// CHANLINT: STATIC CHECKS FOR CHANNEL SERVERS
+//
+// The programs of the course share one architecture: a server goroutine loops on
+// a select whose cases are guarded with when(cond, ch), receives requests that
+// carry an ack channel and answers on it. A few mistakes recur in the solutions,
+// and none of them is caught by the compiler or by go vet:
+//
+//   - len() of an unbuffered channel in a guard. It is always 0, so a priority
+//     such as len(UscitaPT) == 0 would always hold (UscitaPT is unbuffered in
+//     examSolB.go). The analyzer
+//     follows the make() calls of the package, also through the size parameter
+//     of initChannels(size), and reports only channels that are unbuffered at
+//     every make.
+//   - A request received in a select case and not answered on some path. The
+//     client then waits forever for its ack, like a client of negozio() when no
+//     assistant has a free slot (the !found branch). A path answers when it sends
+//     on a channel field of the request, forwards or stores the request (or its
+//     ack) for later, or leaves the server.
+//   - A send of the server that can block it: on an unbuffered channel that is
+//     not a reply, or on a channel the server itself receives from (then even a
+//     buffer does not help when it is full), like richiestaEB in sol3.2.go.
+//     Replies are the sends on the ack of the request, on the channel of the
+//     request (the deposita handshake) and on the channels the clients wait on
+//     right after a request (risorsa[i] in ex1.go); the sends of the
+//     termination case are allowed too.
+//   - Guard helpers duplicated per element type (whenRichiesta, whenInt...):
+//     a single generic when[T any](b bool, c chan T) chan T serves them all.
+//
+// On the programs of the course it reports the FLEX case of sol3.2.go, the two
+// client cases of negozio() (22-12-2021) and the duplicated guard helpers of
+// 09-01-2023, 22-12-2021 and 26-01-2023.
+//
+// Build it and pass it to go vet:
+//     cd chanlint && go build -o /tmp/chanlint ./cmd/chanlint
+//     cd ../writtenExams/22-12-2021 && go vet -vettool=/tmp/chanlint examSol.go workload.go fairness.go leaks.go checkpoint.go admin.go
+//
+// Run the tests with:
+//     go test ./...
+// -----------------------------------------------------------------------------------
+
+package chanlint
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"slices"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+// Analyzer reports the mistakes listed above.
+var Analyzer = &analysis.Analyzer{
+	Name:     "chanlint",
+	Doc:      "reports common mistakes of select-based channel servers",
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	ins := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	b := newBuffers(pass, ins)
+	checkLen(pass, ins, b)
+	checkServers(pass, ins, b, replyChannels(pass, ins, b))
+	checkGuardHelpers(pass, ins)
+	return nil, nil
+}
+
+// ============================================================
+//                      CHANNEL BUFFERS
+// ============================================================
+
+// Buffering of a channel variable (or field, or array of channels).
+type buffering int
+
+const (
+	unknown buffering = iota
+	unbuffered
+	buffered
+)
+
+// buffers finds out which channel variables of the package are unbuffered.
+// Every variable has a list of sources: the make() calls and the other
+// variables assigned to it, including the arguments of its calls for a
+// parameter. A variable is unbuffered if all of its sources are.
+type buffers struct {
+	pass    *analysis.Pass
+	sources map[types.Object][]ast.Expr
+	calls   map[*types.Func][]*ast.CallExpr
+	memo    map[types.Object]buffering
+}
+
+func newBuffers(pass *analysis.Pass, ins *inspector.Inspector) *buffers {
+	b := &buffers{
+		pass:    pass,
+		sources: map[types.Object][]ast.Expr{},
+		calls:   map[*types.Func][]*ast.CallExpr{},
+		memo:    map[types.Object]buffering{},
+	}
+	filter := []ast.Node{(*ast.AssignStmt)(nil), (*ast.ValueSpec)(nil), (*ast.CompositeLit)(nil), (*ast.CallExpr)(nil)}
+	ins.Preorder(filter, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			if len(n.Lhs) == len(n.Rhs) {
+				for i, lhs := range n.Lhs {
+					b.add(b.root(lhs), n.Rhs[i])
+				}
+			}
+		case *ast.ValueSpec:
+			if len(n.Names) == len(n.Values) {
+				for i, name := range n.Names {
+					b.add(pass.TypesInfo.Defs[name], n.Values[i])
+				}
+			}
+		case *ast.CompositeLit:
+			b.addFields(n)
+		case *ast.CallExpr:
+			if fn, ok := callee(pass.TypesInfo, n).(*types.Func); ok {
+				b.calls[fn] = append(b.calls[fn], n)
+			}
+		}
+	})
+	// The arguments of a call are sources of the parameters.
+	for fn, calls := range b.calls {
+		sig := fn.Type().(*types.Signature)
+		for _, call := range calls {
+			for i, arg := range call.Args {
+				if i < sig.Params().Len() && !sig.Variadic() {
+					b.add(sig.Params().At(i), arg)
+				}
+			}
+		}
+	}
+	return b
+}
+
+// add records src as a source of the channel variable obj.
+func (b *buffers) add(obj types.Object, src ast.Expr) {
+	if obj != nil && isChan(obj.Type()) {
+		b.sources[obj] = append(b.sources[obj], src)
+	}
+}
+
+// addFields records the channel fields set by a struct literal.
+func (b *buffers) addFields(lit *ast.CompositeLit) {
+	st, ok := b.pass.TypesInfo.TypeOf(lit).Underlying().(*types.Struct)
+	if !ok {
+		return
+	}
+	for i, elt := range lit.Elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			if key, ok := kv.Key.(*ast.Ident); ok {
+				b.add(b.pass.TypesInfo.ObjectOf(key), kv.Value)
+			}
+		} else if i < st.NumFields() {
+			b.add(st.Field(i), elt)
+		}
+	}
+}
+
+// root returns the variable holding the channel e: the variable itself, the
+// array of c[i], the field of x.f.
+func (b *buffers) root(e ast.Expr) types.Object {
+	switch e := ast.Unparen(e).(type) {
+	case *ast.Ident:
+		return b.pass.TypesInfo.ObjectOf(e)
+	case *ast.IndexExpr:
+		return b.root(e.X)
+	case *ast.StarExpr:
+		return b.root(e.X)
+	case *ast.SelectorExpr:
+		return b.pass.TypesInfo.ObjectOf(e.Sel)
+	}
+	return nil
+}
+
+// of returns the buffering of the variable obj.
+func (b *buffers) of(obj types.Object) buffering {
+	if obj == nil {
+		return unknown
+	}
+	if v, ok := b.memo[obj]; ok {
+		return v
+	}
+	b.memo[obj] = unknown // cycles are unknown
+	v := unknown
+	for i, src := range b.sources[obj] {
+		s := b.expr(src)
+		if i > 0 && s != v {
+			s = unknown
+		}
+		if v = s; v == unknown {
+			break
+		}
+	}
+	b.memo[obj] = v
+	return v
+}
+
+// expr returns the buffering of the channel computed by e.
+func (b *buffers) expr(e ast.Expr) buffering {
+	e = ast.Unparen(e)
+	if call, ok := e.(*ast.CallExpr); ok && isBuiltin(b.pass.TypesInfo, call, "make") {
+		if len(call.Args) < 2 {
+			return unbuffered
+		}
+		return b.size(call.Args[1])
+	}
+	if _, ok := e.(*ast.CompositeLit); ok {
+		return unknown
+	}
+	return b.of(b.root(e))
+}
+
+// size returns the buffering of a channel made with the given size: a
+// constant, or a parameter receiving constants at every call.
+func (b *buffers) size(e ast.Expr) buffering {
+	if tv, ok := b.pass.TypesInfo.Types[e]; ok && tv.Value != nil {
+		if constant.Sign(tv.Value) == 0 {
+			return unbuffered
+		}
+		return buffered
+	}
+	id, ok := ast.Unparen(e).(*ast.Ident)
+	if !ok {
+		return unknown
+	}
+	param, ok := b.pass.TypesInfo.ObjectOf(id).(*types.Var)
+	if !ok {
+		return unknown
+	}
+	fn, i := b.paramOf(param)
+	if fn == nil || len(b.calls[fn]) == 0 {
+		return unknown
+	}
+	v := unknown
+	for j, call := range b.calls[fn] {
+		s := b.size(call.Args[i])
+		if j > 0 && s != v {
+			return unknown
+		}
+		v = s
+	}
+	return v
+}
+
+// paramOf returns the function having param as its i-th parameter.
+func (b *buffers) paramOf(param *types.Var) (*types.Func, int) {
+	for fn := range b.calls {
+		params := fn.Type().(*types.Signature).Params()
+		for i := range params.Len() {
+			if params.At(i) == param {
+				return fn, i
+			}
+		}
+	}
+	return nil, 0
+}
+
+// ============================================================
+//                      len() OF UNBUFFERED CHANNELS
+// ============================================================
+
+func checkLen(pass *analysis.Pass, ins *inspector.Inspector, b *buffers) {
+	ins.Preorder([]ast.Node{(*ast.CallExpr)(nil)}, func(n ast.Node) {
+		call := n.(*ast.CallExpr)
+		if !isBuiltin(pass.TypesInfo, call, "len") || len(call.Args) != 1 {
+			return
+		}
+		if _, ok := pass.TypesInfo.TypeOf(call.Args[0]).Underlying().(*types.Chan); !ok {
+			return // len() of an array of channels
+		}
+		if obj := b.root(call.Args[0]); b.of(obj) == unbuffered {
+			pass.Reportf(call.Pos(), "len(%s) is always 0: %s is unbuffered", types.ExprString(call.Args[0]), obj.Name())
+		}
+	})
+}
+
+// ============================================================
+//                          SERVERS
+// ============================================================
+
+// checkServers checks the select statements inside a loop, i.e. the ones of
+// server goroutines.
+func checkServers(pass *analysis.Pass, ins *inspector.Inspector, b *buffers, replies map[types.Object]bool) {
+	ins.WithStack([]ast.Node{(*ast.SelectStmt)(nil)}, func(n ast.Node, push bool, stack []ast.Node) bool {
+		if !push || !inLoop(stack) {
+			return true
+		}
+		sel := n.(*ast.SelectStmt)
+		fn := enclosingFunc(stack)
+		received := map[types.Object]bool{}
+		ast.Inspect(fn, func(n ast.Node) bool {
+			if cc, ok := n.(*ast.CommClause); ok {
+				if _, ch := receive(cc); ch != nil {
+					received[b.root(guarded(pass.TypesInfo, ch))] = true
+				}
+			}
+			return true
+		})
+		for _, stmt := range sel.Body.List {
+			cc := stmt.(*ast.CommClause)
+			req, ch := receive(cc)
+			var reqObj types.Object
+			if req != nil {
+				reqObj = pass.TypesInfo.ObjectOf(req)
+			}
+			if reqObj != nil && ackField(reqObj.Type()) != "" && !answers(pass.TypesInfo, reqObj, cc.Body) {
+				pass.Reportf(cc.Pos(), "request %s received from %s is not answered on every path: send on %s.%s, or store it for later",
+					req.Name, types.ExprString(guarded(pass.TypesInfo, ch)), req.Name, ackField(reqObj.Type()))
+			}
+			checkSends(pass, b, cc, reqObj, ch, received, replies)
+		}
+		return true
+	})
+}
+
+// checkSends reports the sends of a select case that can block the server.
+// The replies are allowed: on a channel field of the request, on the channel
+// of the request itself, or on a channel its clients wait on.
+func checkSends(pass *analysis.Pass, b *buffers, cc *ast.CommClause, req types.Object, ch ast.Expr, received, replies map[types.Object]bool) {
+	body := cc.Body
+	for len(body) == 1 {
+		block, ok := body[0].(*ast.BlockStmt)
+		if !ok {
+			break
+		}
+		body = block.List
+	}
+	if n := len(body); n > 0 {
+		if _, ok := body[n-1].(*ast.ReturnStmt); ok {
+			return // termination: the server leaves anyway
+		}
+	}
+	var from types.Object
+	if ch != nil {
+		from = b.root(guarded(pass.TypesInfo, ch))
+	}
+	for _, stmt := range cc.Body {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			if _, ok := n.(*ast.FuncLit); ok {
+				return false
+			}
+			send, ok := n.(*ast.SendStmt)
+			if !ok || (req != nil && uses(pass.TypesInfo, send.Chan, req)) {
+				return true
+			}
+			switch obj := b.root(send.Chan); {
+			case obj == nil || obj == from || replies[obj]:
+			case received[obj]:
+				pass.Reportf(send.Pos(), "the server sends on %s, which it receives from itself: the send blocks it forever when %s is unbuffered or full",
+					types.ExprString(send.Chan), obj.Name())
+			case b.of(obj) == unbuffered:
+				pass.Reportf(send.Pos(), "the server blocks on the unbuffered channel %s until someone receives from it",
+					types.ExprString(send.Chan))
+			}
+			return true
+		})
+	}
+}
+
+// replyChannels returns the channels on which a goroutine waits right after
+// sending a request, like risorsa in
+//
+//	richiesta <- i
+//	r := <-risorsa[i]
+func replyChannels(pass *analysis.Pass, ins *inspector.Inspector, b *buffers) map[types.Object]bool {
+	replies := map[types.Object]bool{}
+	check := func(list []ast.Stmt) {
+		for i := 1; i < len(list); i++ {
+			if _, ok := list[i-1].(*ast.SendStmt); !ok {
+				continue
+			}
+			var e ast.Expr
+			switch s := list[i].(type) {
+			case *ast.ExprStmt:
+				e = s.X
+			case *ast.AssignStmt:
+				e = s.Rhs[0]
+			}
+			if recv, ok := ast.Unparen(e).(*ast.UnaryExpr); ok && recv.Op == token.ARROW {
+				replies[b.root(recv.X)] = true
+			}
+		}
+	}
+	filter := []ast.Node{(*ast.BlockStmt)(nil), (*ast.CaseClause)(nil), (*ast.CommClause)(nil)}
+	ins.Preorder(filter, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			check(n.List)
+		case *ast.CaseClause:
+			check(n.Body)
+		case *ast.CommClause:
+			check(n.Body)
+		}
+	})
+	return replies
+}
+
+// receive returns the variable receiving a request in a select case
+// (x := <-ch, x = <-ch) and the channel expression.
+func receive(cc *ast.CommClause) (*ast.Ident, ast.Expr) {
+	var rhs ast.Expr
+	var lhs []ast.Expr
+	switch s := cc.Comm.(type) {
+	case *ast.AssignStmt:
+		rhs, lhs = s.Rhs[0], s.Lhs
+	case *ast.ExprStmt:
+		rhs = s.X
+	default:
+		return nil, nil
+	}
+	recv, ok := ast.Unparen(rhs).(*ast.UnaryExpr)
+	if !ok || recv.Op != token.ARROW {
+		return nil, nil
+	}
+	if len(lhs) > 0 {
+		if id, ok := lhs[0].(*ast.Ident); ok && id.Name != "_" {
+			return id, recv.X
+		}
+	}
+	return nil, recv.X
+}
+
+// guarded returns the channel c of a guard when(cond, c).
+func guarded(info *types.Info, e ast.Expr) ast.Expr {
+	call, ok := ast.Unparen(e).(*ast.CallExpr)
+	if !ok {
+		return e
+	}
+	for _, arg := range slices.Backward(call.Args) {
+		if isChan(info.TypeOf(arg)) {
+			return arg
+		}
+	}
+	return e
+}
+
+// ackField returns the name of the first channel field of a request type.
+func ackField(t types.Type) string {
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok {
+		return ""
+	}
+	for i := range st.NumFields() {
+		if isChan(st.Field(i).Type()) {
+			return st.Field(i).Name()
+		}
+	}
+	return ""
+}
+
+// answers reports whether every path through stmts answers the request req.
+func answers(info *types.Info, req types.Object, stmts []ast.Stmt) bool {
+	return slices.ContainsFunc(stmts, func(s ast.Stmt) bool { return answersStmt(info, req, s) })
+}
+
+func answersStmt(info *types.Info, req types.Object, s ast.Stmt) bool {
+	switch s := s.(type) {
+	case *ast.BlockStmt:
+		return answers(info, req, s.List)
+	case *ast.LabeledStmt:
+		return answersStmt(info, req, s.Stmt)
+	case *ast.ReturnStmt:
+		return true
+	case *ast.IfStmt:
+		if s.Init != nil && answersStmt(info, req, s.Init) || escapes(info, s.Cond, req) {
+			return true
+		}
+		return answers(info, req, s.Body.List) && s.Else != nil && answersStmt(info, req, s.Else)
+	case *ast.SwitchStmt:
+		return answersClauses(info, req, s.Body)
+	case *ast.TypeSwitchStmt:
+		return answersClauses(info, req, s.Body)
+	case *ast.SelectStmt:
+		return answersClauses(info, req, s.Body)
+	case *ast.ForStmt:
+		return s.Cond == nil && answers(info, req, s.Body.List)
+	case *ast.RangeStmt:
+		return false // the body may not run
+	case *ast.SendStmt:
+		return uses(info, s.Chan, req) || escapes(info, s.Value, req)
+	case *ast.ExprStmt, *ast.AssignStmt, *ast.DeclStmt, *ast.GoStmt, *ast.DeferStmt:
+		if e, ok := s.(*ast.ExprStmt); ok && isBuiltin(info, e.X, "panic") {
+			return true
+		}
+		return escapes(info, s, req)
+	}
+	return false
+}
+
+// answersClauses reports whether every clause of a switch or select answers
+// the request, and there is no missing default.
+func answersClauses(info *types.Info, req types.Object, body *ast.BlockStmt) bool {
+	def := false
+	for _, c := range body.List {
+		var list []ast.Stmt
+		switch c := c.(type) {
+		case *ast.CaseClause:
+			list, def = c.Body, def || c.List == nil
+		case *ast.CommClause:
+			list, def = c.Body, true
+		}
+		if !answers(info, req, list) {
+			return false
+		}
+	}
+	return def
+}
+
+// escapes reports whether n uses the request req, or one of its channel
+// fields, as a value, or calls one of its methods: the request is then
+// answered by the method, forwarded or stored for later.
+func escapes(info *types.Info, n ast.Node, req types.Object) bool {
+	found := false
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			if id, ok := n.X.(*ast.Ident); ok && info.ObjectOf(id) == req {
+				sel := info.Selections[n]
+				found = found || isChan(info.TypeOf(n)) || sel != nil && sel.Kind() == types.MethodVal
+				return false
+			}
+		case *ast.Ident:
+			found = found || info.ObjectOf(n) == req
+		}
+		return !found
+	})
+	return found
+}
+
+// uses reports whether e mentions req.
+func uses(info *types.Info, e ast.Expr, req types.Object) bool {
+	found := false
+	ast.Inspect(e, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && info.ObjectOf(id) == req {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// ============================================================
+//                       GUARD HELPERS
+// ============================================================
+
+// checkGuardHelpers reports the guard helpers of the package after the first
+// one: they only differ in the element type of the channel.
+func checkGuardHelpers(pass *analysis.Pass, ins *inspector.Inspector) {
+	var first *ast.FuncDecl
+	ins.Preorder([]ast.Node{(*ast.FuncDecl)(nil)}, func(n ast.Node) {
+		fd := n.(*ast.FuncDecl)
+		if !isGuardHelper(pass.TypesInfo, fd) {
+			return
+		}
+		if first == nil {
+			first = fd
+			return
+		}
+		pass.Reportf(fd.Name.Pos(), "%s duplicates %s for another element type: use a generic when[T any](b bool, c chan T) chan T",
+			fd.Name.Name, first.Name.Name)
+	})
+}
+
+// isGuardHelper reports whether fd is func(b bool, c chan T) chan T returning
+// c if b, nil otherwise.
+func isGuardHelper(info *types.Info, fd *ast.FuncDecl) bool {
+	obj, ok := info.Defs[fd.Name].(*types.Func)
+	if !ok || fd.Recv != nil || fd.Body == nil {
+		return false
+	}
+	sig := obj.Type().(*types.Signature)
+	if sig.TypeParams() != nil || sig.Params().Len() != 2 || sig.Results().Len() != 1 {
+		return false
+	}
+	b, c := sig.Params().At(0), sig.Params().At(1)
+	if !types.Identical(b.Type(), types.Typ[types.Bool]) || !isChan(c.Type()) ||
+		!types.Identical(c.Type(), sig.Results().At(0).Type()) || len(fd.Body.List) != 2 {
+		return false
+	}
+	// if !b { return nil }; return c   or   if b { return c }; return nil
+	ifs, ok := fd.Body.List[0].(*ast.IfStmt)
+	if !ok || ifs.Init != nil || ifs.Else != nil || len(ifs.Body.List) != 1 {
+		return false
+	}
+	cond, negated := ast.Unparen(ifs.Cond), false
+	if not, ok := cond.(*ast.UnaryExpr); ok && not.Op == token.NOT {
+		cond, negated = ast.Unparen(not.X), true
+	}
+	id, ok := cond.(*ast.Ident)
+	if !ok || info.ObjectOf(id) != b {
+		return false
+	}
+	inner, last := ifs.Body.List[0], fd.Body.List[1]
+	if negated {
+		inner, last = last, inner
+	}
+	return returned(info, inner) == c && isNil(info, last)
+}
+
+// returned returns the variable returned by s, if s is return v.
+func returned(info *types.Info, s ast.Stmt) types.Object {
+	ret, ok := s.(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return nil
+	}
+	if id, ok := ret.Results[0].(*ast.Ident); ok {
+		if v, ok := info.ObjectOf(id).(*types.Var); ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// ============================================================
+//                          HELPERS
+// ============================================================
+
+func isChan(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	if a, ok := t.Underlying().(*types.Array); ok {
+		t = a.Elem()
+	}
+	_, ok := t.Underlying().(*types.Chan)
+	return ok
+}
+
+func isBuiltin(info *types.Info, e ast.Expr, name string) bool {
+	call, ok := ast.Unparen(e).(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	id, ok := ast.Unparen(call.Fun).(*ast.Ident)
+	if !ok {
+		return false
+	}
+	b, ok := info.Uses[id].(*types.Builtin)
+	return ok && b.Name() == name
+}
+
+func isNil(info *types.Info, s ast.Stmt) bool {
+	ret, ok := s.(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return false
+	}
+	return info.Types[ret.Results[0]].IsNil()
+}
+
+// callee returns the function called by call, if it is declared in
+// the package (or imported): nil for builtins, conversions and closures.
+func callee(info *types.Info, call *ast.CallExpr) types.Object {
+	id, ok := ast.Unparen(call.Fun).(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	return info.Uses[id]
+}
+
+// inLoop reports whether the innermost function of stack has a loop around
+// its top node.
+func inLoop(stack []ast.Node) bool {
+	for _, n := range slices.Backward(stack[:len(stack)-1]) {
+		switch n.(type) {
+		case *ast.ForStmt, *ast.RangeStmt:
+			return true
+		case *ast.FuncDecl, *ast.FuncLit:
+			return false
+		}
+	}
+	return false
+}
+
+// enclosingFunc returns the innermost function of stack.
+func enclosingFunc(stack []ast.Node) ast.Node {
+	for _, n := range slices.Backward(stack) {
+		switch n.(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
+			return n
+		}
+	}
+	return stack[0]
+}
@@ -0,0 +1,12 @@
+package chanlint
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+// The expected diagnostics are the // want comments of testdata/src/servers.
+func TestAnalyzer(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "servers")
+}
@@ -0,0 +1,12 @@
+// chanlint runs the chanlint analyzer as a vet tool:
+//
+//	go vet -vettool=$(which chanlint) examSol.go workload.go leaks.go admin.go
+package main
+
+import (
+	"chanlint"
+
+	"golang.org/x/tools/go/analysis/unitchecker"
+)
+
+func main() { unitchecker.Main(chanlint.Analyzer) }
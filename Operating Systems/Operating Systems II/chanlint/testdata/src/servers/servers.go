@@ -0,0 +1,111 @@
+// A server with one instance of every mistake reported by chanlint, next to
+// the correct version of the same code.
+package servers
+
+type Request struct {
+	id  int
+	ack chan bool
+}
+
+const MAXBUFF = 100
+
+var (
+	enter, leave, priority chan Request
+	exit                   chan Request // unbuffered
+	signal                 chan int
+	reply                  chan int // clients wait on it after a signal
+	notify                 chan int // nobody waits on it
+	deposit                chan bool
+	done, terminate        chan bool
+)
+
+func initChannels(size int) {
+	enter = make(chan Request, size)
+	leave = make(chan Request, size)
+	priority = make(chan Request, size)
+	exit = make(chan Request)
+	signal = make(chan int, size)
+	reply = make(chan int)
+	notify = make(chan int)
+	deposit = make(chan bool)
+	done = make(chan bool)
+	terminate = make(chan bool)
+}
+
+func when(b bool, c chan Request) chan Request {
+	if !b {
+		return nil
+	}
+	return c
+}
+
+func whenInt(b bool, c chan int) chan int { // want `whenInt duplicates when for another element type`
+	if b {
+		return c
+	}
+	return nil
+}
+
+func client(id int) {
+	signal <- id
+	<-reply
+	r := Request{id, make(chan bool, MAXBUFF)}
+	enter <- r
+	<-r.ack
+}
+
+func server() {
+	inside, free := 0, 0
+	var waiting []Request
+	var slots [3]int
+	for {
+		select {
+		case r := <-when(len(priority) == 0 && len(exit) == 0, enter): // want `len\(exit\) is always 0: exit is unbuffered` `request r received from enter is not answered on every path`
+			found := false
+			for i := range slots {
+				if !found && slots[i] == 0 {
+					slots[i] = r.id
+					found = true
+					r.ack <- true
+				}
+			}
+			if !found {
+				inside++
+			}
+		case r := <-priority: // want `request r received from priority is not answered on every path`
+			if free > 0 {
+				r.ack <- true
+			} else if inside > 0 {
+				inside--
+			}
+		case r := <-leave:
+			if free > 0 {
+				r.ack <- true
+			} else {
+				waiting = append(waiting, r)
+			}
+		case r := <-exit:
+			switch {
+			case free > 0:
+				r.ack <- true
+			default:
+				enter <- r // want `the server sends on enter, which it receives from itself`
+			}
+		case id := <-whenInt(inside > 0, signal):
+			reply <- id
+			notify <- id // want `the server blocks on the unbuffered channel notify until someone receives from it`
+		case <-deposit:
+			free++
+			deposit <- true
+		case <-terminate:
+			done <- true
+			return
+		}
+	}
+}
+
+func main() {
+	initChannels(MAXBUFF)
+	go server()
+	client(0)
+}
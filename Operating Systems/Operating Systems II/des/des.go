@@ -0,0 +1,381 @@
+// -----------------------------------------------------------------------------------
+// DISCRETE-EVENT SIMULATION: THE SCENARIOS WITHOUT GOROUTINES
+//
+// A scenario runs its clients and its server as goroutines talking on channels,
+// and its time goes by with time.Sleep: a day of the consulting office takes a
+// day, or a second in a synctest bubble, but its goroutines are still scheduled
+// one by one. A discrete-event simulation runs the same logic with none of them:
+//
+//   - the clock jumps from one event to the next: the future event list is a heap
+//     of the events (what to do, and when) ordered by time, and by scheduling
+//     order at the same time;
+//   - the entities (the clients) are callbacks: what a client does after the
+//     server replies, or after a sleep, is an event scheduled then;
+//   - a Resource is a pool of identical units with a FIFO queue of the entities
+//     waiting for one (the resources of lab3/ex1.go);
+//   - a Server is the discrete-event version of a server goroutine: its channels
+//     are the FIFO queues of its Cases, each with the guard of its when(), and
+//     after the events of an instant it serves, like its select, a request of an
+//     enabled case chosen at random until no case is enabled. As in a select the
+//     guards are evaluated when the server starts to wait: a request to a case
+//     disabled then does not wake the server.
+//
+// Hundreds of thousands of clients take a few seconds. The scenarios that have a
+// discrete-event version (10-01-2022) choose it with
+//     -backend des
+// and with
+//     -backend check
+// run the same clients (the same plan of the workload, see workload.go, and the
+// same services) with both backends, and print the metrics of the runs side by
+// side: they differ only in the random choices of the servers, so a difference of
+// more than a few percent means that one of the two does not do what the other
+// does. The goroutines still take the time of the run, so -clients sets
+// how many clients arrive (default: the number of the scenario), e.g. from
+// writtenExams/10-01-2022:
+//     go run examSol.go workload.go fairness.go metrics.go leaks.go policy.go queueing.go des.go causal.go admin.go -backend des -clients 500000 -arrivals poisson:rate=0.3 -service exp:mean=15
+//     go run examSol.go workload.go fairness.go metrics.go leaks.go policy.go queueing.go des.go causal.go admin.go -backend check -arrivals poisson:rate=10 -service exp:mean=0.5
+// In the tests the goroutines run in simulated time too (TestBackends).
+//
+// Run the tests with:
+//     go test des.go des_test.go workload.go fairness.go metrics.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	backendFlag = flag.String("backend", "goroutines", "run the scenario with `goroutines`, des (discrete-event simulation) or check (both, compared)")
+	clientsFlag = flag.Int("clients", 0, "number of clients of the run (0: the number of the scenario)")
+)
+
+// Backend returns the backend chosen with -backend. On error it exits the program.
+func Backend() string {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	switch *backendFlag {
+	case "goroutines", "des", "check":
+		return *backendFlag
+	}
+	fmt.Fprintf(os.Stderr, "des: unknown backend %q (goroutines, des or check)\n", *backendFlag)
+	os.Exit(2)
+	return ""
+}
+
+// Clients returns the number of clients given with -clients, or def.
+func Clients(def int) int {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	if *clientsFlag > 0 {
+		return *clientsFlag
+	}
+	return def
+}
+
+// ============================================================
+//                          ENGINE
+// ============================================================
+
+// event is an entry of the future event list.
+type event struct {
+	at  time.Duration
+	seq int // scheduling order, for the events at the same time
+	do  func()
+}
+
+// eventList is a heap of events, the next one first.
+type eventList []*event
+
+func (l eventList) Len() int { return len(l) }
+func (l eventList) Less(i, j int) bool {
+	return l[i].at < l[j].at || (l[i].at == l[j].at && l[i].seq < l[j].seq)
+}
+func (l eventList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l *eventList) Push(x any)   { *l = append(*l, x.(*event)) }
+func (l *eventList) Pop() any {
+	old := *l
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*l = old[:len(old)-1]
+	return e
+}
+
+// Sim is a discrete-event simulation: its clock and its future event list. It
+// is not safe to use from several goroutines, and needs none.
+type Sim struct {
+	now    time.Duration // since the start
+	origin time.Time     // what Time returns at the start
+	events eventList
+	seq    int
+	done   int        // events run
+	rng    *rand.Rand // choices of the servers among their enabled cases
+}
+
+// NewSim creates a simulation at time 0; seed drives the choices of its servers.
+func NewSim(seed int64) *Sim {
+	return &Sim{origin: time.Unix(0, 0), rng: rand.New(rand.NewSource(seed))}
+}
+
+// Now returns the simulated time since the start.
+func (s *Sim) Now() time.Duration { return s.now }
+
+// Time returns the simulated time as a time.Time: it is the clock of the
+// Fairness, Usage and Policy of a simulated run.
+func (s *Sim) Time() time.Time { return s.origin.Add(s.now) }
+
+// After schedules f at d from now; with d == 0, after the events already
+// scheduled for now.
+func (s *Sim) After(d time.Duration, f func()) {
+	if d < 0 {
+		panic("des: event scheduled in the past")
+	}
+	s.seq++
+	heap.Push(&s.events, &event{s.now + d, s.seq, f})
+}
+
+// Run runs the events in order until there are none left.
+func (s *Sim) Run() {
+	for len(s.events) > 0 {
+		e := heap.Pop(&s.events).(*event)
+		s.now = e.at
+		e.do()
+		s.done++
+	}
+}
+
+// Events returns the number of events run.
+func (s *Sim) Events() int { return s.done }
+
+// NewFairness creates a Fairness (see fairness.go) that measures the waits with
+// the clock of the simulation.
+func (s *Sim) NewFairness(classes []string) *Fairness {
+	f := FairnessFromFlags(classes)
+	f.now, f.start = s.Time, s.Time()
+	return f
+}
+
+// NewUsage creates a Usage (see metrics.go) that measures with the clock of the
+// simulation.
+func (s *Sim) NewUsage(name string, capacity int) *Usage {
+	u := NewUsage(name, capacity)
+	u.now = s.Time
+	u.start, u.last = s.Time(), s.Time()
+	return u
+}
+
+// ============================================================
+//                         RESOURCES
+// ============================================================
+
+// Resource is a pool of identical units: an entity that finds them all busy
+// waits in a FIFO queue until one is released.
+type Resource struct {
+	sim      *Sim
+	capacity int
+	busy     int
+	queue    []func()
+}
+
+// NewResource creates a Resource with capacity units.
+func (s *Sim) NewResource(capacity int) *Resource {
+	return &Resource{sim: s, capacity: capacity}
+}
+
+// Acquire asks for a unit: then runs when the entity gets it, now if a unit is free.
+func (r *Resource) Acquire(then func()) {
+	if r.busy < r.capacity {
+		r.busy++
+		r.sim.After(0, then)
+		return
+	}
+	r.queue = append(r.queue, then)
+}
+
+// Release gives a unit back: the first entity waiting gets it.
+func (r *Resource) Release() {
+	if len(r.queue) == 0 {
+		r.busy--
+		return
+	}
+	next := r.queue[0]
+	r.queue = r.queue[1:]
+	r.sim.After(0, next)
+}
+
+// Busy returns the number of units assigned, Waiting the entities in the queue.
+func (r *Resource) Busy() int    { return r.busy }
+func (r *Resource) Waiting() int { return len(r.queue) }
+
+// ============================================================
+//                          SERVERS
+// ============================================================
+
+// Server is a server goroutine without the goroutine: a select whose cases are
+// Cases. As in a select, the guards are evaluated when the server starts to
+// wait: a request sent to a case enabled then wakes the server, after the events
+// of the instant, while a request sent to a disabled case (a nil channel) waits
+// in its queue until something else wakes the server.
+type Server struct {
+	sim   *Sim
+	cases []serverCase
+	awake bool // a step is scheduled
+}
+
+type serverCase interface {
+	evaluate() bool // evaluates the guard; true if a request can be served
+	serve()
+}
+
+// Case is a case of the select of a Server, `case req := <-when(guard, ch)`:
+// its requests wait in FIFO order, like in a channel, and body serves them.
+type Case[T any] struct {
+	srv   *Server
+	guard func() bool
+	body  func(T)
+	queue []T
+	open  bool // the guard, when the server last evaluated it
+}
+
+// NewServer creates a Server without cases; like a goroutine started now, it
+// evaluates the guards of its cases, added meanwhile, after the events of the
+// instant.
+func (s *Sim) NewServer() *Server {
+	srv := &Server{sim: s}
+	srv.wake()
+	return srv
+}
+
+// NewCase adds a case to srv; a nil guard is always true.
+func NewCase[T any](srv *Server, guard func() bool, body func(req T)) *Case[T] {
+	c := &Case[T]{srv: srv, guard: guard, body: body}
+	srv.cases = append(srv.cases, c)
+	return c
+}
+
+// Send queues a request, as ch <- req on a buffered channel.
+func (c *Case[T]) Send(req T) {
+	c.queue = append(c.queue, req)
+	if c.open {
+		c.srv.wake()
+	}
+}
+
+// Len returns the requests waiting, as len(ch).
+func (c *Case[T]) Len() int { return len(c.queue) }
+
+func (c *Case[T]) evaluate() bool {
+	c.open = c.guard == nil || c.guard()
+	return c.open && len(c.queue) > 0
+}
+
+func (c *Case[T]) serve() {
+	req := c.queue[0]
+	var zero T
+	c.queue[0] = zero
+	c.queue = c.queue[1:]
+	c.body(req)
+}
+
+func (s *Server) wake() {
+	if !s.awake {
+		s.awake = true
+		s.sim.After(0, s.step)
+	}
+}
+
+// step serves, as many times as select would, a request of an enabled case
+// chosen at random, evaluating the guards again before every choice.
+func (s *Server) step() {
+	s.awake = false
+	var ready []serverCase
+	for {
+		ready = ready[:0]
+		for _, c := range s.cases {
+			if c.evaluate() {
+				ready = append(ready, c)
+			}
+		}
+		if len(ready) == 0 {
+			return
+		}
+		ready[s.sim.rng.Intn(len(ready))].serve()
+	}
+}
+
+// ============================================================
+//                        CROSS-CHECK
+// ============================================================
+
+// Run is what a backend measured: the waits and the resources of WriteMetrics.
+type Run struct {
+	Fairness *Fairness
+	Usage    []*Usage
+}
+
+// Metrics returns the metrics of the run (see WriteMetrics) and the mean wait of
+// every class, mean_wait_<class>, in order.
+func (r Run) Metrics() ([]string, map[string]float64) {
+	var buf bytes.Buffer
+	writeMetrics(&buf, r.Fairness, r.Usage)
+	var keys []string
+	values := map[string]float64{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		k, v, _ := strings.Cut(line, "=")
+		x, _ := strconv.ParseFloat(v, 64)
+		keys = append(keys, k)
+		values[k] = x
+	}
+	if f := r.Fairness; f != nil {
+		f.mu.Lock()
+		for c, name := range f.classes {
+			if waits := f.waits[c]; len(waits) > 0 {
+				var sum time.Duration
+				for _, d := range waits {
+					sum += d
+				}
+				k := "mean_wait_" + name
+				keys = append(keys, k)
+				values[k] = (sum / time.Duration(len(waits))).Seconds()
+			}
+		}
+		f.mu.Unlock()
+	}
+	return keys, values
+}
+
+// Compare writes the metrics of the same clients run by the goroutines and by
+// the simulation side by side, with their relative difference.
+func Compare(w io.Writer, goroutines, des Run) {
+	keys, g := goroutines.Metrics()
+	desKeys, d := des.Metrics()
+	for _, k := range desKeys {
+		if !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	fmt.Fprintf(w, "\nBACKENDS CHECK: the same clients with the goroutines and with the discrete-event simulation\n")
+	fmt.Fprintf(w, "%-28s %12s %12s %11s\n", "", "goroutines", "des", "difference")
+	for _, k := range keys {
+		diff := "-"
+		if g[k] != 0 {
+			diff = fmt.Sprintf("%+.1f%%", 100*(d[k]-g[k])/g[k])
+		} else if d[k] == 0 {
+			diff = "0"
+		}
+		fmt.Fprintf(w, "%-28s %12.6g %12.6g %11s\n", k, g[k], d[k], diff)
+	}
+}
@@ -0,0 +1,149 @@
+// Tests for the discrete-event simulation: order of the events, queues of the
+// resources and of the servers, and an M/M/1 queue against its closed form.
+//
+// Run with:
+//     go test des.go des_test.go workload.go fairness.go metrics.go
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventOrder(t *testing.T) {
+	s := NewSim(1)
+	var got []string
+	log := func(name string) func() {
+		return func() { got = append(got, name+"@"+s.Now().String()) }
+	}
+	s.After(2*time.Second, log("c"))
+	s.After(time.Second, log("a"))
+	s.After(time.Second, func() {
+		log("b")()
+		s.After(0, log("b2")) // after the events already scheduled for now
+		s.After(time.Second, log("d"))
+	})
+	s.After(time.Second, log("a2"))
+	s.Run()
+	want := []string{"a@1s", "b@1s", "a2@1s", "b2@1s", "c@2s", "d@2s"}
+	if !slices.Equal(got, want) || s.Events() != 6 {
+		t.Errorf("events %v (%d), want %v", got, s.Events(), want)
+	}
+}
+
+func TestResource(t *testing.T) {
+	s := NewSim(1)
+	r := s.NewResource(2)
+	var got []time.Duration
+	for range 5 {
+		r.Acquire(func() {
+			got = append(got, s.Now())
+			s.After(time.Second, r.Release)
+		})
+	}
+	if r.Busy() != 2 || r.Waiting() != 3 {
+		t.Errorf("%d busy and %d waiting, want 2 and 3", r.Busy(), r.Waiting())
+	}
+	s.Run()
+	want := []time.Duration{0, 0, time.Second, time.Second, 2 * time.Second}
+	if !slices.Equal(got, want) || r.Busy() != 0 {
+		t.Errorf("units acquired at %v, %d busy at the end, want %v and 0", got, r.Busy(), want)
+	}
+}
+
+// A room for one, where the requests of a go before those of b, as with
+// when(free && len(a) == 0, b).
+func TestServer(t *testing.T) {
+	s := NewSim(1)
+	srv := s.NewServer()
+	free := true
+	var order []string
+	enter := func(name string) {
+		free = false
+		order = append(order, name)
+		s.After(time.Second, func() { free = true; srv.wake() })
+	}
+	a := NewCase(srv, func() bool { return free }, enter)
+	b := NewCase(srv, func() bool { return free && a.Len() == 0 }, enter)
+	b.Send("b1")
+	b.Send("b2")
+	a.Send("a1")
+	s.After(time.Second, func() { a.Send("a2") })
+	s.Run()
+	want := []string{"a1", "a2", "b1", "b2"}
+	if !slices.Equal(order, want) {
+		t.Errorf("served %v, want %v", order, want)
+	}
+}
+
+// A request to a case disabled when the server started to wait does not wake
+// it, as a send on the buffered channel of a nil case of select.
+func TestDisabledCase(t *testing.T) {
+	s := NewSim(1)
+	srv := s.NewServer()
+	open := false
+	var served []time.Duration
+	a := NewCase(srv, func() bool { return open }, func(int) { served = append(served, s.Now()) })
+	b := NewCase(srv, nil, func(int) {})
+	s.After(time.Second, func() { open = true; a.Send(1) })
+	s.After(2*time.Second, func() { b.Send(2) })
+	s.Run()
+	if !slices.Equal(served, []time.Duration{2 * time.Second}) {
+		t.Errorf("served at %v, want at 2s, when b wakes the server", served)
+	}
+}
+
+// An M/M/1 queue with rho = 1/2 and mu = 1: the mean wait is rho/(mu-lambda) = 1s.
+func TestMM1(t *testing.T) {
+	const n = 200000
+	rng := rand.New(rand.NewSource(1))
+	s := NewSim(1)
+	r := s.NewResource(1)
+	var wait time.Duration
+	at := time.Duration(0)
+	for range n {
+		at += time.Duration(rng.ExpFloat64() * 2 * float64(time.Second))
+		service := time.Duration(rng.ExpFloat64() * float64(time.Second))
+		s.After(at, func() {
+			arrived := s.Now()
+			r.Acquire(func() {
+				wait += s.Now() - arrived
+				s.After(service, r.Release)
+			})
+		})
+	}
+	s.Run()
+	if got := wait.Seconds() / n; math.Abs(got-1) > 0.05 {
+		t.Errorf("mean wait %.3fs, want about 1s", got)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	runs := make([]Run, 2)
+	for i, d := range []time.Duration{time.Second, 2 * time.Second} {
+		s := NewSim(1)
+		f := s.NewFairness([]string{"A"})
+		s.After(0, func() {
+			tk := f.Request(0, 0)
+			s.After(d, func() { f.Served(tk) })
+		})
+		s.Run()
+		runs[i] = Run{Fairness: f}
+	}
+	var out strings.Builder
+	Compare(&out, runs[0], runs[1])
+	var rows []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		rows = append(rows, strings.Join(strings.Fields(line), " "))
+	}
+	for _, want := range []string{"served 1 1 +0.0%", "mean_wait_A 1 2 +100.0%", "starving 0 0 0"} {
+		if !slices.Contains(rows, want) {
+			t.Errorf("no row %q in:\n%s", want, out.String())
+		}
+	}
+}
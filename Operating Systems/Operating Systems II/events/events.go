@@ -0,0 +1,247 @@
+// -----------------------------------------------------------------------------------
+// EVENT SOURCING: THE STATE OF A SERVER AS A FOLD OF ITS EVENTS
+//
+// A server keeps its state in counters it changes in place: after a run only
+// the last values are left, and what the state was when something went wrong is
+// lost. Here a server changes its state only through domain events, what
+// happened in the words of the scenario. Two servers are written this way,
+// castle() of writtenExams/09-01-2023 and waterStation() of
+// writtenExams/26-01-2023; the others keep their counters, and a scenario
+// links this file only if its server appends events:
+//     camper 4 started uphill to a MAXI spot
+//     refill started
+// An event is a DomainEvent (who, which one, what happened, when), and the state
+// is a value with a method Apply that returns the state after the event: the
+// server does
+//     s = s.Apply(eventLog.Append("camper", 4, UPHILL_MAXI))
+// and its guards read s. The state at the end of a run, or after any event, is
+// the fold of Apply over the events from the initial state.
+//
+// With
+//     -events log.jsonl
+// the events are also appended to the file, one JSON object per line, written
+// as they happen; a run starts a new log. Without -events eventLog is nil, and
+// Append only numbers nothing and returns the event to apply.
+//
+// With
+//     -replay log.jsonl [-at 12.5s]
+// the scenario does not run: it reads the log, lists its events up to the time
+// -at (all of them without -at) and prints the state rebuilt from them through
+// its projections, views of the state such as the occupancy of the road, the
+// inventory (water, parking spots) or the revenue of the water station, e.g.
+// from writtenExams/26-01-2023:
+//     go run examSol.go workload.go leaks.go events.go admin.go -events /tmp/station.jsonl
+//     go run examSol.go workload.go leaks.go events.go admin.go -replay /tmp/station.jsonl -at 20s
+//
+// Run the tests with:
+//     go test events.go events_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var eventsFlag = flag.String("events", "", "append the domain events of the server to `file` (see events.go)")
+var replayFlag = flag.String("replay", "", "rebuild the state of the server from the events in `file` instead of running the scenario")
+var atFlag = flag.Duration("at", 0, "with -replay, rebuild the state at this `time` from the start of the log (0: at the end)")
+
+// The log of the domain events of the server, nil without -events
+var eventLog *EventLog
+
+// ============================================================
+//                          EVENTS
+// ============================================================
+
+// DomainEvent is something that happened to the state of a server.
+type DomainEvent struct {
+	Seq   int           `json:"seq"`             // number of the event in the log, from 1 (0: not logged)
+	At    time.Duration `json:"at"`              // time from the start of the log
+	Actor string        `json:"actor,omitempty"` // who: "camper", "client", ... ("" for the server itself)
+	ID    int           `json:"id"`              // which one of them, -1 if there is one only
+	Kind  string        `json:"kind"`            // what happened
+}
+
+// String returns the event as a sentence: "camper 4 started uphill".
+func (e DomainEvent) String() string {
+	switch {
+	case e.Actor == "":
+		return e.Kind
+	case e.ID < 0:
+		return e.Actor + " " + e.Kind
+	}
+	return fmt.Sprintf("%s %d %s", e.Actor, e.ID, e.Kind)
+}
+
+// EventLog appends the events of a server to a file.
+type EventLog struct {
+	mu    sync.Mutex
+	f     *os.File
+	start time.Time
+	seq   int
+	err   error // first write error, returned by Close
+}
+
+// OpenEventLog starts a new log in the file path.
+func OpenEventLog(path string) (*EventLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{f: f, start: time.Now()}, nil
+}
+
+// EventLogFromFlags returns the log of -events, or nil.
+func EventLogFromFlags() *EventLog {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	if *eventsFlag == "" {
+		return nil
+	}
+	l, err := OpenEventLog(*eventsFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "events:", err)
+		os.Exit(2)
+	}
+	return l
+}
+
+// Append writes the event "actor id kind" at the end of the log and returns it,
+// to be applied to the state. On a nil EventLog it only returns the event.
+func (l *EventLog) Append(actor string, id int, kind string) DomainEvent {
+	e := DomainEvent{Actor: actor, ID: id, Kind: kind}
+	if l == nil {
+		return e
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	e.Seq, e.At = l.seq, time.Since(l.start)
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = l.f.Write(append(line, '\n'))
+	}
+	if err != nil && l.err == nil {
+		l.err = err
+	}
+	return e
+}
+
+// Close closes the log and returns the first error met writing it.
+func (l *EventLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.f.Close(); l.err == nil {
+		l.err = err
+	}
+	return l.err
+}
+
+// ReadEvents reads a log. The events must be numbered from 1 without gaps,
+// or the state rebuilt from them would be wrong.
+func ReadEvents(r io.Reader) ([]DomainEvent, error) {
+	var events []DomainEvent
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		var e DomainEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return events, fmt.Errorf("line %d: %w", line, err)
+		}
+		if e.Seq != len(events)+1 {
+			return events, fmt.Errorf("line %d: event #%d, want #%d", line, e.Seq, len(events)+1)
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// ============================================================
+//                    FOLDS AND PROJECTIONS
+// ============================================================
+
+// State is the state of a server, changed only by its events.
+type State[S any] interface {
+	Apply(e DomainEvent) S // the state after e
+}
+
+// Fold returns the state after events, from the initial state init.
+func Fold[S State[S]](init S, events []DomainEvent) S {
+	s := init
+	for _, e := range events {
+		s = s.Apply(e)
+	}
+	return s
+}
+
+// Until returns the events up to time at (all of them if at is 0).
+func Until(events []DomainEvent, at time.Duration) []DomainEvent {
+	if at <= 0 {
+		return events
+	}
+	for i, e := range events {
+		if e.At > at {
+			return events[:i]
+		}
+	}
+	return events
+}
+
+// Projection is a view of the state of a server: occupancy, inventory, revenue.
+type Projection[S any] struct {
+	Name string
+	Of   func(s S) string
+}
+
+// Replay lists the events up to time at (0: all of them) and prints the state
+// rebuilt from them through the projections.
+func Replay[S State[S]](w io.Writer, events []DomainEvent, at time.Duration, init S, projections []Projection[S]) {
+	until := Until(events, at)
+	for _, e := range until {
+		fmt.Fprintf(w, "#%-5d %10v  %s\n", e.Seq, e.At.Round(time.Millisecond), e)
+	}
+	when := "at the end"
+	if at > 0 {
+		when = fmt.Sprintf("at %v", at)
+	}
+	fmt.Fprintf(w, "\nState %s, after %d of %d events:\n", when, len(until), len(events))
+	s := Fold(init, until)
+	for _, p := range projections {
+		fmt.Fprintf(w, "  %-10s %s\n", p.Name+":", p.Of(s))
+	}
+}
+
+// ReplayFromFlags does the -replay of the log, if asked, and reports whether it
+// did: the scenario then does not run.
+func ReplayFromFlags[S State[S]](init S, projections ...Projection[S]) bool {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	if *replayFlag == "" {
+		return false
+	}
+	f, err := os.Open(*replayFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "events:", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	events, err := ReadEvents(f)
+	if err != nil {
+		// The events before the broken line still tell the state until then
+		fmt.Fprintln(os.Stderr, "events:", err)
+	}
+	Replay(os.Stdout, events, *atFlag, init, projections)
+	return true
+}
@@ -0,0 +1,142 @@
+// Tests for the event log: events written and read back, the fold of a small
+// state and its rebuild at a past time, and the logs that cannot be trusted.
+//
+// Run with:
+//     go test events.go events_test.go
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// hall is the state of a hall that visitors enter and leave.
+type hall struct {
+	Inside, Visits int
+}
+
+func (h hall) Apply(e DomainEvent) hall {
+	switch e.Kind {
+	case "entered":
+		h.Inside++
+		h.Visits++
+	case "left":
+		h.Inside--
+	}
+	return h
+}
+
+var hallProjections = []Projection[hall]{
+	{"occupancy", func(h hall) string { return strings.Repeat("v", h.Inside) }},
+	{"visits", func(h hall) string { return strings.Repeat("*", h.Visits) }},
+}
+
+// logged writes the events of f to a temporary log and reads them back.
+func logged(t *testing.T, f func(l *EventLog)) []DomainEvent {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	l, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f(l)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	events, err := ReadEvents(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestNilEventLog(t *testing.T) {
+	var l *EventLog
+	e := l.Append("visitor", 3, "entered")
+	if e.Seq != 0 || e.String() != "visitor 3 entered" {
+		t.Errorf("event of a nil log: #%d %q", e.Seq, e)
+	}
+	if h := (hall{}).Apply(e); h.Inside != 1 {
+		t.Errorf("inside after the event: %d, want 1", h.Inside)
+	}
+	if err := l.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFoldAt(t *testing.T) {
+	var events []DomainEvent
+	synctest.Test(t, func(t *testing.T) {
+		events = logged(t, func(l *EventLog) {
+			var h hall
+			for id := range 3 {
+				time.Sleep(time.Second)
+				h = h.Apply(l.Append("visitor", id, "entered"))
+			}
+			time.Sleep(time.Second)
+			h = h.Apply(l.Append("visitor", 1, "left"))
+			h = h.Apply(l.Append("", -1, "hall closing"))
+			if h.Inside != 2 || h.Visits != 3 {
+				t.Errorf("state of the server: %+v", h)
+			}
+		})
+	})
+	if len(events) != 5 || events[4].String() != "hall closing" || events[3].At != 4*time.Second {
+		t.Fatalf("read back %v", events)
+	}
+
+	for _, tc := range []struct {
+		at   time.Duration
+		want hall
+	}{
+		{0, hall{2, 3}},
+		{500 * time.Millisecond, hall{0, 0}},
+		{2 * time.Second, hall{2, 2}},
+		{3500 * time.Millisecond, hall{3, 3}},
+		{time.Hour, hall{2, 3}},
+	} {
+		if got := Fold(hall{}, Until(events, tc.at)); got != tc.want {
+			t.Errorf("at %v: %+v, want %+v", tc.at, got, tc.want)
+		}
+	}
+
+	var out bytes.Buffer
+	Replay(&out, events, 2*time.Second, hall{}, hallProjections)
+	for _, want := range []string{"visitor 1 entered", "after 2 of 5 events", "occupancy: vv", "visits:    **"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("replay at 2s does not contain %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "visitor 2") {
+		t.Errorf("replay at 2s lists a later event:\n%s", out.String())
+	}
+}
+
+func TestReadEvents(t *testing.T) {
+	for _, tc := range []struct {
+		name, log string
+		read      int
+	}{
+		{"truncated", "{\"seq\":1,\"kind\":\"entered\"}\n{\"seq\":2,\"ki", 1},
+		{"missing event", "{\"seq\":1,\"kind\":\"entered\"}\n{\"seq\":3,\"kind\":\"left\"}\n", 1},
+		{"two logs", "{\"seq\":1,\"kind\":\"entered\"}\n{\"seq\":1,\"kind\":\"entered\"}\n", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			events, err := ReadEvents(strings.NewReader(tc.log))
+			if err == nil || len(events) != tc.read {
+				t.Errorf("read %d events, error %v: want %d and an error", len(events), err, tc.read)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// -----------------------------------------------------------------------------------
+// FAIRNESS AND STARVATION OF THE REQUEST CLASSES
+//
+// The scenarios that give a strict priority to a class (ADMIN over PRIVATE_WITH in
+// 10-01-2022, ABITUALE over OCCASIONALE in negozio(), North over South in
+// lab4/ex1.go) also measure what it costs to the others: a Fairness records the
+// wait of every request from when it is sent to when it is served and reports at
+// the end of the run, per class, the mean, p95 and maximum wait, Jain's fairness
+// index of the mean waits and the starvation alarms: the requests bypassed (served
+// after a request sent later) more than K times, with
+//     -starve <K>
+// e.g. from lab/lab4:
+//     go run ex1.go workload.go fairness.go leaks.go policy.go ex1ring.go admin.go -starve 3
+//
+// Run the tests with:
+//     go test fairness.go fairness_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+var starveFlag = flag.Int("starve", 10, "starvation alarm: a waiting request bypassed more than `K` times")
+
+// Fairness records the waits of the requests of every class. A nil *Fairness
+// records nothing, so the clients can use it also when main did not create it
+// (e.g. in the tests). It is safe to use from several goroutines.
+type Fairness struct {
+	classes []string
+	k       int
+
+	mu      sync.Mutex
+	now     func() time.Time
+	start   time.Time
+	seq     int
+	waiting map[*Ticket]bool
+	waits   [][]time.Duration // waits of the served requests, by class
+	alarms  []*Ticket
+}
+
+// Ticket is a request waiting to be served.
+type Ticket struct {
+	id, class int
+	seq       int // order in which the requests were sent
+	since     time.Time
+	wait      time.Duration // set when served
+	bypassed  int
+}
+
+// NewFairness creates a Fairness for the given classes; a request bypassed more
+// than k times raises a starvation alarm.
+func NewFairness(classes []string, k int) *Fairness {
+	return &Fairness{
+		classes: classes,
+		k:       k,
+		now:     time.Now,
+		start:   time.Now(),
+		waiting: map[*Ticket]bool{},
+		waits:   make([][]time.Duration, len(classes)),
+	}
+}
+
+// FairnessFromFlags creates the Fairness of a scenario, with K from -starve.
+func FairnessFromFlags(classes []string) *Fairness {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	return NewFairness(classes, *starveFlag)
+}
+
+// Request records that client id sends a request of the given class; the
+// client passes the ticket to Served when the server answers.
+func (f *Fairness) Request(id, class int) *Ticket {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	t := &Ticket{id: id, class: class, seq: f.seq, since: f.now()}
+	f.waiting[t] = true
+	return t
+}
+
+// Served records that the request of t has been served: the requests sent
+// before it and still waiting are bypassed once more.
+func (f *Fairness) Served(t *Ticket) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.waiting, t)
+	t.wait = f.now().Sub(t.since)
+	f.waits[t.class] = append(f.waits[t.class], t.wait)
+	for u := range f.waiting {
+		if u.seq < t.seq {
+			u.bypassed++
+			if u.bypassed == f.k+1 {
+				f.alarms = append(f.alarms, u)
+			}
+		}
+	}
+}
+
+// Jain returns Jain's fairness index of x: (sum x)^2 / (n * sum x^2), from 1/n
+// (one value takes all) to 1 (all equal). It is 1 if all the values are 0.
+func Jain(x []float64) float64 {
+	sum, sq := 0.0, 0.0
+	for _, v := range x {
+		sum += v
+		sq += v * v
+	}
+	if sq == 0 {
+		return 1
+	}
+	return sum * sum / (float64(len(x)) * sq)
+}
+
+// Report writes the statistics of the waits by class, the fairness index of the
+// mean waits of the classes with served requests and the starvation alarms.
+func (f *Fairness) Report(w io.Writer) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(w, "\nFAIRNESS\n")
+	fmt.Fprintf(w, "%-16s %6s %10s %10s %10s %8s\n", "class", "served", "mean", "p95", "max", "waiting")
+	var means []float64
+	for c, name := range f.classes {
+		waits := append([]time.Duration(nil), f.waits[c]...)
+		slices.Sort(waits)
+		var sum time.Duration
+		for _, d := range waits {
+			sum += d
+		}
+		var mean, p95, max time.Duration
+		if n := len(waits); n > 0 {
+			mean = sum / time.Duration(n)
+			p95 = waits[(n*95+99)/100-1]
+			max = waits[n-1]
+			means = append(means, mean.Seconds())
+		}
+		pending := 0
+		for t := range f.waiting {
+			if t.class == c {
+				pending++
+				if d := f.now().Sub(t.since); d > max {
+					max = d
+				}
+			}
+		}
+		fmt.Fprintf(w, "%-16s %6d %10v %10v %10v %8d\n", name, len(waits),
+			mean.Round(time.Millisecond), p95.Round(time.Millisecond), max.Round(time.Millisecond), pending)
+	}
+	fmt.Fprintf(w, "Jain's index of the mean waits: %.3f\n", Jain(means))
+	if len(f.alarms) == 0 {
+		fmt.Fprintf(w, "No request bypassed more than %d times\n", f.k)
+		return
+	}
+	fmt.Fprintf(w, "STARVATION: %d requests bypassed more than %d times\n", len(f.alarms), f.k)
+	for _, t := range f.alarms {
+		state := fmt.Sprintf("waited %v", t.wait.Round(time.Millisecond))
+		if f.waiting[t] {
+			state = "still waiting"
+		}
+		fmt.Fprintf(w, "  %s %d: bypassed %d times, %s\n", f.classes[t.class], t.id, t.bypassed, state)
+	}
+}
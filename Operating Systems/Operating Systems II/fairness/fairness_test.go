@@ -0,0 +1,76 @@
+// Tests for the fairness report: the waits by class, Jain's index and the
+// starvation alarms.
+//
+// Run with:
+//     go test fairness.go fairness_test.go
+
+package main
+
+import (
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJain(t *testing.T) {
+	tests := []struct {
+		x    []float64
+		want float64
+	}{
+		{[]float64{3, 3, 3}, 1},
+		{[]float64{1, 0, 0, 0}, 0.25},
+		{[]float64{2, 6}, 0.8},
+		{[]float64{0, 0}, 1},
+	}
+	for _, tt := range tests {
+		if got := Jain(tt.x); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Jain(%v) = %v, want %v", tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestFairness(t *testing.T) {
+	f := NewFairness([]string{"A", "B"}, 1)
+	clock := time.Unix(0, 0)
+	f.now = func() time.Time { return clock }
+	at := func(s int) { clock = time.Unix(int64(s), 0) }
+
+	// B 0 waits while the two A requests sent after it are served.
+	b0 := f.Request(0, 1)
+	at(1)
+	a1 := f.Request(1, 0)
+	at(2)
+	a2 := f.Request(2, 0)
+	b3 := f.Request(3, 1)
+	at(3)
+	f.Served(a1)
+	at(4)
+	f.Served(a2)
+	at(6)
+	f.Served(b0)
+
+	var out strings.Builder
+	f.Report(&out)
+	for _, want := range []string{
+		"A                     2         2s         2s         2s        0\n",
+		"B                     1         6s         6s         6s        1\n",
+		"Jain's index of the mean waits: 0.800\n",
+		"STARVATION: 1 requests bypassed more than 1 times\n",
+		"  B 0: bypassed 2 times, waited 6s\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report:\n%s\nwant line %q", out.String(), want)
+		}
+	}
+	if b3.bypassed != 0 {
+		t.Errorf("B 3 bypassed %d times, want 0: it was sent after the served requests", b3.bypassed)
+	}
+}
+
+func TestNilFairness(t *testing.T) {
+	var f *Fairness
+	f.Served(f.Request(0, 0))
+	f.Report(io.Discard)
+}
@@ -0,0 +1,475 @@
+// -----------------------------------------------------------------------------------
+// GUARDCOV: COVERAGE OF THE GUARDS OF A CHANNEL SERVER
+//
+// go test -cover says which lines ran, but a server is one select whose cases
+// are guarded with when(cond, ch): what matters is which guards held. guardcov
+// instruments every select with at least one guarded case (a case on
+// whenX(cond, ch)), runs the program or its tests and reports, per case:
+//   - how many times it fired;
+//   - how many times it was enabled (guard true) but another case fired;
+//   - how many times it was disabled and, for every conjunct of the guard (the
+//     operands of the top-level &&), how many times it was false and how many
+//     times it was the only false one, i.e. it alone disabled the case.
+// Cases that never fired and conjuncts that never disabled a case alone are
+// flagged: the first are dead or untested paths of the server, the second are
+// conditions that the run never needed (or that are implied by the others).
+//
+// The guards are evaluated once per round, when the server enters the select,
+// and the round is recorded when a case fires. To know every conjunct the
+// instrumented guard evaluates all of them; a conjunct that panics after a
+// false one (e.g. an index guarded by a bound) is recorded as unknown and the
+// panic is dropped, as && would not have evaluated it.
+//
+// The instrumented program also answers "why am I blocked?": given a channel
+// of a pending request, guardcovWhy returns the false conjuncts of the guards
+// of its cases with their values, as of the last time the server entered the
+// select (the values are read by the server itself). With -why addr the
+// queries are served on HTTP for a client, a dashboard or curl; from a
+// debugger call guardcovWhy("entrataC_IN[SCOL]"). For 14-02-2022:
+//     $ curl 'localhost:6061/why?ch=entrataC_IN%5BSCOL%5D'
+//     entrataC_IN[SCOL] (examSol.go:151) in server(), round 19: the case is disabled
+//         `persone_in_sala+scolari <= N` is false, 27+25 > 40
+//
+// The program is not modified: the instrumented copies, the runtime
+// (runtime.go) and the table of the selects are written to a temporary
+// directory, with the lines of the original files. The report is written when
+// main returns (or when the tests end) to the standard error or to the file
+// given with -o; go test shows the standard error of the tests only with -v.
+//
+// Run with:
+//     go run guardcov.go [-o report.txt] [-why addr] run|test [go flags] files.go [args]
+// e.g. from writtenExams/09-01-2023:
+//     go run ../../guardcov/guardcov.go run examSol.go workload.go leaks.go gates.go events.go admin.go
+//     go run ../../guardcov/guardcov.go -why localhost:6061 run examSol.go workload.go leaks.go gates.go events.go admin.go
+//     go run ../../guardcov/guardcov.go -o castle.txt test -run TestCastle examSol.go workload.go leaks.go gates.go events.go admin.go servertest_test.go examSol_test.go
+//
+// Run the tests with:
+//     go test guardcov.go guardcov_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//go:embed runtime.go
+var runtimeSrc string
+
+// testRun reports the coverage at the end of the tests: the m.Run() of their
+// TestMain is replaced by guardcovRun(m).
+const testRun = `package main
+
+import "testing"
+
+func guardcovRun(m *testing.M) int {
+	code := m.Run()
+	guardcovReport()
+	return code
+}
+`
+
+// testMain is the TestMain of the tests that have none.
+const testMain = `package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(guardcovRun(m))
+}
+`
+
+// edit replaces the source in [start, end) with text.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// instrumenter collects the edits of the files and the table of the selects.
+type instrumenter struct {
+	fset    *token.FileSet
+	src     []byte
+	edits   []edit
+	table   strings.Builder
+	cases   int // cases instrumented so far, in all the files
+	selects int
+}
+
+func main() {
+	out := flag.String("o", "", "write the report to `file` instead of the standard error")
+	why := flag.String("why", "", "answer the queries on the guards on http://`addr`/why")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: guardcov [-o file] [-why addr] run|test [go flags] files.go [args]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 || (flag.Arg(0) != "run" && flag.Arg(0) != "test") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	code, err := guardcov(flag.Arg(0), flag.Args()[1:], *out, *why)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "guardcov:", err)
+		os.Exit(2)
+	}
+	os.Exit(code)
+}
+
+// guardcov instruments the .go files in args, runs go run/test (mode) on them
+// with the other arguments in place, and returns the exit code of go. The
+// report goes to out, if not empty, and the program answers the queries on
+// the guards on why, if not empty.
+func guardcov(mode string, args []string, out, why string) (int, error) {
+	first, last := -1, -1
+	for i, a := range args {
+		if strings.HasSuffix(a, ".go") {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return 0, errors.New("no .go files")
+	}
+	files := args[first : last+1]
+
+	dir, err := os.MkdirTemp("", "guardcov")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := &instrumenter{fset: token.NewFileSet()}
+	in.table.WriteString("package main\n\nvar guardcovSelects = []guardcovSelect{\n")
+	var paths []string
+	hasMain, hasTestMain := false, false
+	for _, name := range files {
+		if strings.HasPrefix(name, "-") {
+			return 0, fmt.Errorf("%s: flags go before the files", name)
+		}
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return 0, err
+		}
+		f, err := parser.ParseFile(in.fset, name, src, parser.ParseComments)
+		if err != nil {
+			return 0, err
+		}
+		if f.Name.Name != "main" {
+			return 0, fmt.Errorf("%s: package %s, want main", name, f.Name.Name)
+		}
+		in.src, in.edits = src, nil
+		for _, d := range f.Decls {
+			if fd, ok := d.(*ast.FuncDecl); ok && fd.Body != nil && fd.Recv == nil {
+				switch fd.Name.Name {
+				case "main":
+					hasMain = true
+					if mode == "run" {
+						in.insert(fd.Body.Lbrace+1, " defer guardcovReport();")
+					}
+				case "TestMain":
+					hasTestMain = true
+					if mode == "test" {
+						in.wrapRun(fd)
+					}
+				}
+			}
+			in.decl(d)
+		}
+		path := filepath.Join(dir, filepath.Base(name))
+		if err := os.WriteFile(path, in.apply(), 0o644); err != nil {
+			return 0, err
+		}
+		paths = append(paths, path)
+	}
+	in.table.WriteString("}\n")
+	if mode == "run" && !hasMain {
+		return 0, errors.New("no func main in the files")
+	}
+	if in.selects == 0 {
+		fmt.Fprintln(os.Stderr, "guardcov: no select with guarded cases")
+	}
+
+	gen := map[string]string{
+		"guardcov_runtime.go": strings.TrimPrefix(runtimeSrc, "//go:build ignore\n"),
+		"guardcov_table.go":   in.table.String(),
+	}
+	if mode == "test" {
+		gen["guardcov_run_test.go"] = testRun
+		if !hasTestMain {
+			gen["guardcov_main_test.go"] = testMain
+		}
+		// The tests run in the directory of the files: let them find testdata.
+		if abs, err := filepath.Abs(filepath.Join(filepath.Dir(files[0]), "testdata")); err == nil {
+			if _, err := os.Stat(abs); err == nil {
+				os.Symlink(abs, filepath.Join(dir, "testdata"))
+			}
+		}
+	}
+	for name, src := range gen {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			return 0, err
+		}
+		paths = append(paths, path)
+	}
+
+	cmdArgs := append([]string{mode}, args[:first]...)
+	cmdArgs = append(cmdArgs, paths...)
+	cmdArgs = append(cmdArgs, args[last+1:]...)
+	cmd := exec.Command("go", cmdArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = os.Environ()
+	if out != "" {
+		abs, err := filepath.Abs(out)
+		if err != nil {
+			return 0, err
+		}
+		cmd.Env = append(cmd.Env, "GUARDCOV_OUT="+abs)
+	}
+	if why != "" {
+		cmd.Env = append(cmd.Env, "GUARDCOV_WHY="+why)
+	}
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return exit.ExitCode(), nil
+		}
+		return 0, err
+	}
+	return 0, nil
+}
+
+// wrapRun replaces the m.Run() of the TestMain fd with guardcovRun(m), which
+// reports the coverage once the tests are done.
+func (in *instrumenter) wrapRun(fd *ast.FuncDecl) {
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && len(call.Args) == 0 {
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Run" {
+				in.replace(call.Pos(), call.End(), "guardcovRun("+in.text(sel.X)+")")
+			}
+		}
+		return true
+	})
+}
+
+// decl instruments the selects of a declaration.
+func (in *instrumenter) decl(d ast.Decl) {
+	fn := "init"
+	if fd, ok := d.(*ast.FuncDecl); ok {
+		fn = fd.Name.Name
+	}
+	ast.Inspect(d, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectStmt); ok {
+			in.selectStmt(fn, sel)
+		}
+		return true
+	})
+}
+
+// selectStmt instruments sel if at least one of its cases is guarded.
+func (in *instrumenter) selectStmt(fn string, sel *ast.SelectStmt) {
+	guards := make([]*ast.CallExpr, len(sel.Body.List))
+	guarded := false
+	for i, s := range sel.Body.List {
+		guards[i] = guard(s.(*ast.CommClause).Comm)
+		guarded = guarded || guards[i] != nil
+	}
+	if !guarded {
+		return
+	}
+	in.selects++
+	fmt.Fprintf(&in.table, "\t{%q, %q, []guardcovCase{\n", fn, in.pos(sel.Pos()))
+	for i, s := range sel.Body.List {
+		cc := s.(*ast.CommClause)
+		k := in.cases
+		in.cases++
+		ch := "default"
+		var conj, why []string
+		if g := guards[i]; g != nil {
+			ch = in.text(g.Args[1])
+			cond := g.Args[0]
+			var funcs []string
+			for j, c := range conjuncts(cond) {
+				format, leaves := explain(c, true)
+				conj = append(conj, in.text(c))
+				why = append(why, format)
+				f := fmt.Sprintf("guardcovConj{func() bool { return %s }, func() []any { return []any{%s} }}",
+					in.src[in.off(c.Pos()):in.off(c.End())], strings.Join(leaves, ", "))
+				if j > 0 {
+					// Keep the lines of the original guard.
+					f = strings.Repeat("\n", in.lines(prev(cond, c), c.Pos())) + f
+				}
+				funcs = append(funcs, f)
+			}
+			in.replace(cond.Pos(), cond.End(), fmt.Sprintf("guardcovEval(%d, %s)", k, strings.Join(funcs, ", ")))
+		} else if cc.Comm != nil {
+			ch = in.text(channel(cc.Comm))
+		}
+		if cc.Comm != nil {
+			// Record the channel, to answer the queries on its value.
+			c := channel(cc.Comm)
+			if g := guards[i]; g != nil {
+				c = g.Args[1]
+			}
+			in.insert(c.Pos(), fmt.Sprintf("guardcovChan(%d, ", k))
+			in.insert(c.End(), ")")
+		}
+		in.insert(cc.Colon+1, fmt.Sprintf(" guardcovFired(%d);", k))
+		fmt.Fprintf(&in.table, "\t\t{%q, %q, %#v, %#v},\n", in.pos(cc.Pos()), ch, conj, why)
+	}
+	in.table.WriteString("\t}},\n")
+}
+
+// guard returns the call to the guard helper of a select case, or nil.
+func guard(comm ast.Stmt) *ast.CallExpr {
+	if comm == nil {
+		return nil
+	}
+	call, ok := ast.Unparen(channel(comm)).(*ast.CallExpr)
+	if !ok || len(call.Args) != 2 {
+		return nil
+	}
+	if id, ok := call.Fun.(*ast.Ident); ok && strings.HasPrefix(id.Name, "when") {
+		return call
+	}
+	return nil
+}
+
+// channel returns the channel expression of a select case.
+func channel(comm ast.Stmt) ast.Expr {
+	var x ast.Expr
+	switch s := comm.(type) {
+	case *ast.SendStmt:
+		return s.Chan
+	case *ast.ExprStmt:
+		x = s.X
+	case *ast.AssignStmt:
+		x = s.Rhs[0]
+	}
+	if u, ok := ast.Unparen(x).(*ast.UnaryExpr); ok && u.Op == token.ARROW {
+		return u.X
+	}
+	return x
+}
+
+// conjuncts splits cond on its top-level &&, also inside parentheses.
+func conjuncts(cond ast.Expr) []ast.Expr {
+	cond = ast.Unparen(cond)
+	if b, ok := cond.(*ast.BinaryExpr); ok && b.Op == token.LAND {
+		return append(conjuncts(b.X), conjuncts(b.Y)...)
+	}
+	return []ast.Expr{cond}
+}
+
+// explain returns the format that shows the values of conjunct c and the
+// expressions of its operands (the leaves of the arithmetic, comparisons and
+// logical operators). When c is false (top), a comparison is shown negated:
+// persone_in_sala+scolari <= N as 30+25 > 40.
+func explain(c ast.Expr, top bool) (format string, leaves []string) {
+	switch x := c.(type) {
+	case *ast.ParenExpr:
+		f, l := explain(x.X, false)
+		return "(" + f + ")", l
+	case *ast.BasicLit:
+		return strings.ReplaceAll(x.Value, "%", "%%"), nil
+	case *ast.UnaryExpr:
+		if x.Op == token.NOT || x.Op == token.SUB {
+			f, l := explain(x.X, false)
+			return x.Op.String() + f, l
+		}
+	case *ast.BinaryExpr:
+		op := x.Op
+		switch {
+		case op.Precedence() >= token.ADD.Precedence():
+			fx, lx := explain(x.X, false)
+			fy, ly := explain(x.Y, false)
+			return fx + op.String() + fy, append(lx, ly...)
+		case op == token.LAND || op == token.LOR || negated[op] != 0:
+			if top && negated[op] != 0 {
+				op = negated[op]
+			}
+			fx, lx := explain(x.X, false)
+			fy, ly := explain(x.Y, false)
+			return fx + " " + op.String() + " " + fy, append(lx, ly...)
+		}
+	}
+	return "%v", []string{types.ExprString(c)}
+}
+
+// negated maps a comparison to its negation.
+var negated = map[token.Token]token.Token{
+	token.EQL: token.NEQ, token.NEQ: token.EQL,
+	token.LSS: token.GEQ, token.GEQ: token.LSS,
+	token.GTR: token.LEQ, token.LEQ: token.GTR,
+}
+
+// prev returns the end of the conjunct of cond that precedes c.
+func prev(cond ast.Expr, c ast.Expr) token.Pos {
+	end := cond.Pos()
+	for _, x := range conjuncts(cond) {
+		if x == c {
+			break
+		}
+		end = x.End()
+	}
+	return end
+}
+
+// text returns the source of x on one line.
+func (in *instrumenter) text(x ast.Expr) string {
+	return strings.Join(strings.Fields(string(in.src[in.off(x.Pos()):in.off(x.End())])), " ")
+}
+
+// pos returns file:line of p.
+func (in *instrumenter) pos(p token.Pos) string {
+	at := in.fset.Position(p)
+	return fmt.Sprintf("%s:%d", filepath.Base(at.Filename), at.Line)
+}
+
+func (in *instrumenter) off(p token.Pos) int {
+	return in.fset.Position(p).Offset
+}
+
+// lines returns the number of newlines between from and to.
+func (in *instrumenter) lines(from, to token.Pos) int {
+	return in.fset.Position(to).Line - in.fset.Position(from).Line
+}
+
+func (in *instrumenter) insert(p token.Pos, text string) {
+	in.replace(p, p, text)
+}
+
+func (in *instrumenter) replace(from, to token.Pos, text string) {
+	in.edits = append(in.edits, edit{in.off(from), in.off(to), text})
+}
+
+// apply returns the source with the edits of the file. The edits do not
+// overlap: a guard is never inside another one.
+func (in *instrumenter) apply() []byte {
+	sort.SliceStable(in.edits, func(i, j int) bool { return in.edits[i].start < in.edits[j].start })
+	var b []byte
+	at := 0
+	for _, e := range in.edits {
+		b = append(b, in.src[at:e.start]...)
+		b = append(b, e.text...)
+		at = e.end
+	}
+	return append(b, in.src[at:]...)
+}
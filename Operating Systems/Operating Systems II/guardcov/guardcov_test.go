@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The rounds of testdata/counter.go, with n and locked before each one:
+//
+//	round  n  locked  fired   inc      dec (n > 0, !locked)  reset
+//	1      0  false   inc     fired    disabled by n > 0     disabled
+//	2      1  false   lock    enabled  enabled               disabled
+//	3      1  true    lock    enabled  disabled by !locked   disabled
+//	4      1  false   dec     enabled  fired                 disabled
+//	5      0  false   lock    enabled  disabled by n > 0     disabled
+//	6      0  true    lock    enabled  disabled by both      disabled
+//	7      0  false   done    enabled  disabled by n > 0     disabled
+const counterReport = `
+GUARD COVERAGE
+
+server() select at counter.go:25: 7 rounds
+   fired not chosen disabled   case / conjunct (false, false alone)
+       1          6        0   inc (counter.go:26)
+                                  0      0   n < 2   <- never decisive
+       1          1        5   dec (counter.go:28)
+                                  4      3   n > 0
+                                  2      1   !locked
+       4          3        0   lock (counter.go:31)
+       0          0        7   reset (counter.go:33)   <- never fired
+                                  7      7   n > 5
+       1          6        0   done (counter.go:35)
+`
+
+func TestRun(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "report.txt")
+	code, err := guardcov("run", []string{filepath.Join("testdata", "counter.go")}, out, "")
+	if err != nil || code != 0 {
+		t.Fatalf("guardcov run = %d, %v", code, err)
+	}
+	report, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(report) != counterReport {
+		t.Errorf("report:\n%s\nwant:\n%s", report, counterReport)
+	}
+}
+
+// TestTest runs the tests of the counter, which have a TestMain: the report is
+// that of the same rounds.
+func TestTest(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "report.txt")
+	files := []string{filepath.Join("testdata", "counter.go"), filepath.Join("testdata", "counter_test.go")}
+	code, err := guardcov("test", append([]string{"-count=1"}, files...), out, "")
+	if err != nil || code != 0 {
+		t.Fatalf("guardcov test = %d, %v", code, err)
+	}
+	report, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(report) != counterReport {
+		t.Errorf("report:\n%s\nwant:\n%s", report, counterReport)
+	}
+}
+
+const hallWhy = `group (hall.go:34) in server(), round 31: the case is disabled
+    ` + "`persone_in_sala+scolari <= N`" + ` is false, 30+25 > 40
+    ` + "`sorveglianti > 0`" + ` is false, 0 <= 0
+`
+
+func TestWhy(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "why.txt")
+	code, err := guardcov("run", []string{filepath.Join("testdata", "hall.go"), out}, "", "")
+	if err != nil || code != 0 {
+		t.Fatalf("guardcov run = %d, %v", code, err)
+	}
+	why, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// By text, by channel, then the enabled case.
+	want := hallWhy + hallWhy + "single (hall.go:31) in server(), round 31: the case is enabled\n"
+	if string(why) != want {
+		t.Errorf("why:\n%s\nwant:\n%s", why, want)
+	}
+}
@@ -0,0 +1,306 @@
+//go:build ignore
+
+// Runtime of the guard coverage, copied by guardcov next to the instrumented
+// program (without the build tag above). The instrumented selects call
+// guardcovEval for the guard of every case when the select is entered, and
+// guardcovFired as first statement of the case that fires: at that moment
+// the guards of all the cases of the round are known. The table of the
+// selects (guardcovSelects) is generated by guardcov for every program.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// guardcovSelect describes an instrumented select.
+type guardcovSelect struct {
+	fn    string // function containing the select
+	pos   string // file:line of the select
+	cases []guardcovCase
+}
+
+// guardcovCase describes a case of an instrumented select.
+type guardcovCase struct {
+	pos  string   // file:line of the case
+	ch   string   // channel of the case ("default" for the default case)
+	conj []string // conjuncts of the guard, nil if the case is not guarded
+	why  []string // formats of the values of the conjuncts, when false
+}
+
+// guardcovConj is a conjunct of an instrumented guard: its value and the
+// values of its operands, as in guardcovCase.why.
+type guardcovConj struct {
+	eval   func() bool
+	leaves func() []any
+}
+
+// Values of an evaluated conjunct.
+const (
+	guardcovFalse   = 0
+	guardcovTrue    = 1
+	guardcovUnknown = 2 // it panicked, after a false conjunct
+)
+
+// guardcovCounts are the statistics of a case.
+type guardcovCounts struct {
+	fired      int   // rounds in which the case fired
+	notChosen  int   // rounds in which it was enabled, but another case fired
+	disabled   int   // rounds in which its guard was false
+	soleFalse  []int // rounds in which only that conjunct was false
+	falseTimes []int // rounds in which that conjunct was false
+}
+
+var (
+	guardcovMu       sync.Mutex
+	guardcovLast     [][][]int8 // last values of the conjuncts, by select and case
+	guardcovCount    [][]guardcovCounts
+	guardcovRounds   []int // rounds of every select
+	guardcovIndex    []struct{ sel, c int }
+	guardcovShown    [][][]string // values of the false conjuncts, by select and case
+	guardcovChans    [][]any      // channels of the cases, by select
+	guardcovBusy     []bool       // a case of the select is running
+	guardcovLastChan []int        // last case of the select with a channel
+)
+
+func init() {
+	for s, sel := range guardcovSelects {
+		guardcovLast = append(guardcovLast, make([][]int8, len(sel.cases)))
+		counts := make([]guardcovCounts, len(sel.cases))
+		last := 0
+		for c, cs := range sel.cases {
+			counts[c].soleFalse = make([]int, len(cs.conj))
+			counts[c].falseTimes = make([]int, len(cs.conj))
+			guardcovIndex = append(guardcovIndex, struct{ sel, c int }{s, c})
+			if cs.ch != "default" {
+				last = c
+			}
+		}
+		guardcovLastChan = append(guardcovLastChan, last)
+		guardcovCount = append(guardcovCount, counts)
+		guardcovShown = append(guardcovShown, make([][]string, len(sel.cases)))
+		guardcovChans = append(guardcovChans, make([]any, len(sel.cases)))
+	}
+	guardcovRounds = make([]int, len(guardcovSelects))
+	guardcovBusy = make([]bool, len(guardcovSelects))
+	if addr := os.Getenv("GUARDCOV_WHY"); addr != "" {
+		guardcovServe(addr)
+	}
+}
+
+// guardcovEval evaluates the conjuncts of the guard of case k and returns the
+// guard. Unlike &&, all the conjuncts are evaluated; one that panics after a
+// false conjunct is recorded as unknown, as the original guard would not
+// have evaluated it.
+func guardcovEval(k int, conj ...guardcovConj) bool {
+	at := guardcovIndex[k]
+	vals := make([]int8, len(conj))
+	shown := make([]string, len(conj))
+	guard := true
+	for i, c := range conj {
+		v, ok := guardcovTry(c.eval, guard)
+		switch {
+		case !ok:
+			vals[i] = guardcovUnknown
+		case v:
+			vals[i] = guardcovTrue
+		default:
+			vals[i] = guardcovFalse
+			guard = false
+			// The operands are read here, by the server that owns them.
+			shown[i] = guardcovValues(guardcovSelects[at.sel].cases[at.c].why[i], c.leaves)
+		}
+	}
+	guardcovMu.Lock()
+	guardcovLast[at.sel][at.c] = vals
+	guardcovShown[at.sel][at.c] = shown
+	guardcovMu.Unlock()
+	return guard
+}
+
+// guardcovValues formats the operands of a false conjunct; "?" if they
+// cannot be evaluated.
+func guardcovValues(format string, leaves func() []any) (s string) {
+	defer func() {
+		if recover() != nil {
+			s = "?"
+		}
+	}()
+	return fmt.Sprintf(format, leaves()...)
+}
+
+// guardcovChan records c as the channel of case k, which is being evaluated.
+// The cases are evaluated in order, the guard before the channel: after the
+// last channel the server is waiting in the select.
+func guardcovChan[C any](k int, c C) C {
+	at := guardcovIndex[k]
+	guardcovMu.Lock()
+	guardcovChans[at.sel][at.c] = c
+	if at.c == guardcovLastChan[at.sel] {
+		guardcovBusy[at.sel] = false
+	}
+	guardcovMu.Unlock()
+	return c
+}
+
+// guardcovTry calls c; if c panics and must is false, the panic is recovered.
+func guardcovTry(c func() bool, must bool) (v bool, ok bool) {
+	if !must {
+		defer func() {
+			if recover() != nil {
+				ok = false
+			}
+		}()
+	}
+	return c(), true
+}
+
+// guardcovFired records a round of the select of case k, which fired.
+func guardcovFired(k int) {
+	at := guardcovIndex[k]
+	guardcovMu.Lock()
+	defer guardcovMu.Unlock()
+	guardcovRounds[at.sel]++
+	guardcovBusy[at.sel] = true
+	for c, cs := range guardcovSelects[at.sel].cases {
+		counts := &guardcovCount[at.sel][c]
+		vals := guardcovLast[at.sel][c]
+		falses := 0
+		for i, v := range vals {
+			if v == guardcovFalse {
+				falses++
+				counts.falseTimes[i]++
+			}
+		}
+		switch {
+		case c == at.c:
+			counts.fired++
+		case falses > 0:
+			counts.disabled++
+			if falses == 1 {
+				for i, v := range vals {
+					if v == guardcovFalse {
+						counts.soleFalse[i]++
+					}
+				}
+			}
+		case cs.ch != "default":
+			counts.notChosen++
+		}
+	}
+}
+
+// guardcovReport writes the report to the file named by $GUARDCOV_OUT, or to
+// the standard error.
+func guardcovReport() {
+	var w io.Writer = os.Stderr
+	if name := os.Getenv("GUARDCOV_OUT"); name != "" {
+		f, err := os.Create(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "guardcov:", err)
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+	guardcovMu.Lock()
+	defer guardcovMu.Unlock()
+	fmt.Fprintf(w, "\nGUARD COVERAGE\n")
+	for s, sel := range guardcovSelects {
+		fmt.Fprintf(w, "\n%s() select at %s: %d rounds\n", sel.fn, sel.pos, guardcovRounds[s])
+		fmt.Fprintf(w, "  %6s %10s %8s   %s\n", "fired", "not chosen", "disabled", "case / conjunct (false, false alone)")
+		for c, cs := range sel.cases {
+			counts := guardcovCount[s][c]
+			note := ""
+			if counts.fired == 0 {
+				note = "   <- never fired"
+			}
+			fmt.Fprintf(w, "  %6d %10d %8d   %s (%s)%s\n", counts.fired, counts.notChosen, counts.disabled, cs.ch, cs.pos, note)
+			for i, conj := range cs.conj {
+				note := ""
+				if counts.soleFalse[i] == 0 {
+					note = "   <- never decisive"
+				}
+				fmt.Fprintf(w, "  %26s %6d %6d   %s%s\n", "", counts.falseTimes[i], counts.soleFalse[i], conj, note)
+			}
+		}
+	}
+}
+
+// guardcovWhy explains why a request on ch is not served: for every case on
+// ch, the false conjuncts of its guard with their values, as of the last time
+// the server entered the select. ch is the channel, or its text in the source
+// (e.g. "entrataC_IN[SCOL]").
+func guardcovWhy(ch any) string {
+	guardcovMu.Lock()
+	defer guardcovMu.Unlock()
+	var b strings.Builder
+	for s, sel := range guardcovSelects {
+		for c, cs := range sel.cases {
+			if name, ok := ch.(string); ok && name != cs.ch || !ok && guardcovChans[s][c] != ch {
+				continue
+			}
+			fmt.Fprintf(&b, "%s (%s) in %s(), round %d: ", cs.ch, cs.pos, sel.fn, guardcovRounds[s]+1)
+			vals := guardcovLast[s][c]
+			switch {
+			case guardcovChans[s][c] == nil && cs.ch != "default":
+				b.WriteString("the server has not entered the select yet\n")
+				continue
+			case guardcovBusy[s]:
+				b.WriteString("the server is serving a request; when it chose it, ")
+			}
+			disabled := false
+			for _, v := range vals {
+				disabled = disabled || v == guardcovFalse
+			}
+			if !disabled {
+				b.WriteString("the case is enabled\n")
+				continue
+			}
+			b.WriteString("the case is disabled\n")
+			for i, v := range vals {
+				if v == guardcovFalse {
+					fmt.Fprintf(&b, "    `%s` is false, %s\n", cs.conj[i], guardcovShown[s][c][i])
+				}
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return fmt.Sprintf("no instrumented case on %v\n", ch)
+	}
+	return b.String()
+}
+
+// guardcovServe answers the queries on http://addr/why?ch=entrataC_IN[SCOL];
+// without ch it explains all the cases.
+func guardcovServe(addr string) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "guardcov:", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "guardcov: queries on http://%s/why?ch=\n", l.Addr())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/why", func(w http.ResponseWriter, r *http.Request) {
+		if ch := r.FormValue("ch"); ch != "" {
+			io.WriteString(w, guardcovWhy(ch))
+			return
+		}
+		seen := map[string]bool{"default": true}
+		for _, sel := range guardcovSelects {
+			for _, cs := range sel.cases {
+				if !seen[cs.ch] {
+					seen[cs.ch] = true
+					io.WriteString(w, guardcovWhy(cs.ch))
+				}
+			}
+		}
+	})
+	go http.Serve(l, mux)
+}
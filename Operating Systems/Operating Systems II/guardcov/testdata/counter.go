@@ -0,0 +1,58 @@
+// Counter server for the test of guardcov: the client sends a fixed sequence
+// of requests, each enabled when it is sent, so the rounds are deterministic.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+var inc, dec, lock, reset = make(chan int), make(chan int), make(chan int), make(chan int)
+var done = make(chan bool)
+
+func when(b bool, c chan int) chan int {
+	if !b {
+		return nil
+	}
+	return c
+}
+
+func server() {
+	n, locked := 0, false
+	for {
+		select {
+		case <-when(n < 2, inc):
+			n++
+		case <-when(n > 0 &&
+			!locked, dec):
+			n--
+		case <-lock:
+			locked = !locked
+		case <-when(n > 5, reset):
+			n = 0
+		case <-done:
+			done <- true
+			return
+		}
+	}
+}
+
+func main() {
+	go server()
+	inc <- 0
+	lock <- 0
+	lock <- 0
+	dec <- 0
+	lock <- 0
+	lock <- 0
+	done <- true
+	<-done
+
+	// The instrumented copy keeps the lines of this file.
+	if _, _, line, _ := runtime.Caller(0); line != 54 {
+		fmt.Println("line", line, "want 54")
+		os.Exit(1)
+	}
+}
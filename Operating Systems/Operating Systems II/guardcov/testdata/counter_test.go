@@ -0,0 +1,17 @@
+// Tests of the counter server for the test of guardcov, with a TestMain of
+// their own, as the tests of the scenarios (see servertest_test.go).
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestCounter(t *testing.T) {
+	main()
+}
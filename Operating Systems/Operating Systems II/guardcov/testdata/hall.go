@@ -0,0 +1,58 @@
+// Hall server for the test of guardcov: 30 single visitors get in, then a
+// school group of 25 waits, as the hall holds 40 and no supervisor is in.
+// It calls guardcovWhy, so it runs only instrumented by guardcov; it writes
+// the answers to the file named by its argument.
+
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+const N = 40
+const scolari = 25
+
+var single, group = make(chan int), make(chan int)
+var ack = make(chan bool)
+
+func when(b bool, c chan int) chan int {
+	if !b {
+		return nil
+	}
+	return c
+}
+
+func server() {
+	persone_in_sala, sorveglianti, closed := 0, 0, false
+	for {
+		select {
+		case <-when(!closed && persone_in_sala < N, single):
+			persone_in_sala++
+			ack <- true
+		case <-when(!closed &&
+			persone_in_sala+scolari <= N &&
+			sorveglianti > 0, group):
+			persone_in_sala += scolari
+			ack <- true
+		}
+	}
+}
+
+func main() {
+	go server()
+	for range 30 {
+		single <- 1
+		<-ack
+	}
+	go func() { group <- 1 }()
+
+	// Wait for the server to enter the select again.
+	why := guardcovWhy("group")
+	for strings.Contains(why, "serving") {
+		time.Sleep(time.Millisecond)
+		why = guardcovWhy("group")
+	}
+	os.WriteFile(os.Args[1], []byte(why+guardcovWhy(group)+guardcovWhy(single)), 0o644)
+}
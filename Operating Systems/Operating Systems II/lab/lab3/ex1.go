@@ -6,11 +6,15 @@
    Concurrency: Clients and the server operate concurrently, using goroutines to manage parallelism.
 */
 
+// Run with:
+//     go run ex1.go workload.go leaks.go queueing.go admin.go
+
 package main
 
 import (
 	"fmt"
 	"math/rand"
+	"os"
 	"time"
 )
 
@@ -24,19 +28,37 @@ const MAXRES = 5
    Data Sharing: They allow safe and structured data sharing between goroutines.
    Concurrency Simplification: Channels reduce the complexity of managing locks or shared memory.
 */
-var richiesta = make(chan int)        //Channel to request a resource
-var rilascio = make(chan int)         //Channel to release a resource
+var richiesta chan int                //Channel to request a resource
+var rilascio chan int                 //Channel to release a resource
 var risorsa [MAXPROC]chan int         //Array of channels for each client to receive resource allocation
-var done = make(chan int)             //Channel to signal when a client finishes
-var termina = make(chan int)          //Channel to signal server termination
+var done chan int                     //Channel to signal when a client finishes
+var termina chan int                  //Channel to signal server termination
+
+var serverDelay = time.Second         //Pause at the start of every server cycle (0 in benchmarks)
+
+var service Service                   //Usage times of the resources (-service flag, see workload.go)
+var queue *Queue                      //With -queueing, the clients measured for the M/M/c check (see queueing.go)
+
+//Creates all the channels; size is the buffer of the request channel (0 = unbuffered)
+func initChannels(size int) {
+	richiesta = make(chan int, size)
+	rilascio = make(chan int)
+	done = make(chan int)
+	termina = make(chan int)
+	for i := 0; i < MAXPROC; i++ {
+		risorsa[i] = make(chan int)
+	}
+}
 
 //Function executed by each client
 func client(i int) {
+	customer, _ := queue.Arrive()       //The queue has no limit: every client is admitted
 	richiesta <- i                      //Client requests a resource by sending its ID
 	r := <-risorsa[i]                   //Wait for the server to allocate a resource
+	queue.Start(customer)
 	fmt.Printf("\n [client %d] using resource %d\n", i, r)
-	timeout := rand.Intn(3)             //Simulate resource usage time (0-2 seconds)
-	time.Sleep(time.Duration(timeout) * time.Second)
+	time.Sleep(service.Draw())          //Simulate resource usage time (0-2 seconds by default)
+	queue.Leave(customer)
 	rilascio <- r                       //Release the resource after usage
 	done <- i                           //Notify main that the client has finished
 }
@@ -48,19 +70,38 @@ func server(nris int, nproc int) {
 	var libera [MAXRES]bool              //Tracks whether each resource is free
 	var sospesi [MAXPROC]bool            //Tracks whether each client is waiting for a resource
 	var nsosp int = 0                    //Number of clients waiting for resources
+	var fuori [MAXRES]bool               //Resources out of service (admin commands, see admin.go)
+	paused := false                      //While paused no resource is allocated (admin commands)
 	for i := 0; i < nris; i++ {          //Initialize all resources as free
 		libera[i] = true
 	}
 	for i := 0; i < nproc; i++ {         //Initialize all clients as not waiting
 		sospesi[i] = false
 	}
+	//Allocates the available resources to the suspended clients, by id, after
+	//an admin command (resume, a resource back in service)
+	serviSospesi := func() {
+		for !paused && disponibili > 0 && nsosp > 0 {
+			for p = 0; p < nproc && !sospesi[p]; p++ {}
+			for i = 0; i < nris && (!libera[i] || fuori[i]); i++ {}
+			sospesi[p] = false
+			nsosp--
+			libera[i] = false
+			disponibili--
+			risorsa[p] <- i
+			fmt.Printf("[server] allocated resource %d to client %d\n", i, p)
+		}
+	}
 	for {
-		time.Sleep(time.Second * 1)      
+		time.Sleep(serverDelay)
 		fmt.Println("new server cycle")
 		select {
 		// Handle resource release
 		case res = <-rilascio:
-			if nsosp == 0 {                   //No clients are waiting
+			if fuori[res] {                   //Out of service: it is not allocated again
+				libera[res] = true
+				fmt.Printf("[server] resource %d returned, out of service\n", res)
+			} else if nsosp == 0 || paused {  //No clients are waiting (or none is served)
 				disponibili++
 				libera[res] = true        //Mark the resource as free
 				fmt.Printf("[server] resource %d returned\n", res)
@@ -72,8 +113,8 @@ func server(nris int, nproc int) {
 			}
 		// Handle resource requests
 		case p = <-richiesta:
-			if disponibili > 0 {             //Resources are available
-				for i = 0; i < nris && !libera[i]; i++ {}
+			if disponibili > 0 && !paused {  //Resources are available
+				for i = 0; i < nris && (!libera[i] || fuori[i]); i++ {}
 				libera[i] = false        //Mark the resource as allocated
 				disponibili--
 				risorsa[p] <- i          //Send the resource to the client
@@ -83,6 +124,66 @@ func server(nris int, nproc int) {
 				sospesi[p] = true
 				fmt.Printf("[server] client %d is waiting..\n", p)
 			}
+		// Handle an admin command
+		case cmd := <-adminCommands:
+			switch cmd.Op {
+			case ADMIN_PAUSE, ADMIN_RESUME:
+				paused = cmd.Op == ADMIN_PAUSE
+				fmt.Printf("[server] admin: %s\n", cmd.Op)
+				serviSospesi()
+				cmd.Reply("paused: %v", paused)
+			case ADMIN_SET:
+				cmd.Fail("no capacity to change: take resources out of service with out resource <i>")
+			case ADMIN_OUT, ADMIN_IN:
+				if cmd.Name != "resource" {
+					cmd.Fail("no %s (resource)", cmd.Name)
+					break
+				}
+				r, err := cmd.Resource(nris)
+				switch {
+				case err != nil:
+				case cmd.Op == ADMIN_OUT && fuori[r]:
+					cmd.Fail("resource %d is already out of service", r)
+				case cmd.Op == ADMIN_IN && !fuori[r]:
+					cmd.Fail("resource %d is already in service", r)
+				case cmd.Op == ADMIN_OUT:
+					fuori[r] = true
+					fmt.Printf("[server] admin: resource %d out of service\n", r)
+					if !libera[r] {          //In use: out when it is returned
+						cmd.Reply("resource %d out of service when it is returned", r)
+						break
+					}
+					disponibili--
+					cmd.Reply("resource %d out of service", r)
+				default:
+					fuori[r] = false
+					fmt.Printf("[server] admin: resource %d back in service\n", r)
+					if libera[r] {
+						disponibili++
+						serviSospesi()
+					}
+					cmd.Reply("resource %d in service", r)
+				}
+			default: // ADMIN_DUMP
+				var free, out, inUse, waiting []int
+				for r := 0; r < nris; r++ {
+					switch {
+					case fuori[r]:
+						out = append(out, r)
+					case libera[r]:
+						free = append(free, r)
+					}
+					if !libera[r] {
+						inUse = append(inUse, r)
+					}
+				}
+				for c := 0; c < nproc; c++ {
+					if sospesi[c] {
+						waiting = append(waiting, c)
+					}
+				}
+				cmd.Reply("paused: %v; free %v, out of service %v, in use %v, waiting %v", paused, free, out, inUse, waiting)
+			}
 		// Handle server termination
 		case <-termina:
 			fmt.Println("FINISHED !!!")
@@ -101,16 +202,25 @@ func main() {
 	fmt.Printf("\n quante risorse (max %d)? ", MAXRES)
 	fmt.Scanf("%d", &res)
 	fmt.Println("risorse da gestire:", res)
-	for i := 0; i < cli; i++ {                            //Initialize channels for each client
-		risorsa[i] = make(chan int)
-	}
-	for i := 0; i < cli; i++ {                            //Launch client processes as goroutines
-		go client(i)
-	}
+	initChannels(0)                                       //Initialize channels (unbuffered requests)
+	//Launch client processes as goroutines, at the arrival times of the workload
+	//(-arrivals flag, see workload.go; by default all of them at once)
+	workload := WorkloadFromFlags([]string{"client"}, "spread:max=0", "client=1")
+	service = ServiceFromFlags("uniform:min=0,max=2")
+	queue = QueueFromFlags(res, 0, workload, service)
+	workload.Spawn(cli, func(id, _ int) { client(id) })
+	stopAdmin := StartAdminFromFlags()                    //Admin commands, with -admin (see admin.go)
 	go server(res, cli)                                   //Launch the server goroutine                
 	for i := 0; i < cli; i++ {
 		<-done
 	}
 	termina <- 1                           		      // Signal the server to terminate
 	<-done                                 		      // Wait for server termination confirmation
+	stopAdmin()
+	queue.Report(os.Stdout)                               // With -queueing, compare the run with M/M/c
+
+	if err := CheckLeaks(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
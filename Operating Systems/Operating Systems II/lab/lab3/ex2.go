@@ -16,21 +16,39 @@
 5. Concurrency Synchronization:
    - Channels (`done` and `termina`) are used to synchronize the completion of goroutines and the termination of the server.
 */
+
+// Run with:
+//     go run ex2.go workload.go leaks.go admin.go
+
 package main
 
 import (
 	"fmt"
+	"os"
 	"time"
 )
 
 const MAXPROC = 100
 const MAXRES = 5
 
-var richiesta = make(chan int)    //Channel for clients to request resources
-var rilascio = make(chan int)     //Channel for clients to release resources
+var richiesta chan int            //Channel for clients to request resources
+var rilascio chan int             //Channel for clients to release resources
 var risorsa [MAXPROC]chan int     //Per-client channels to receive allocated resources
-var done = make(chan int)         //Channel to notify the main thread when a client is done
-var termina = make(chan int)      //Channel to signal the server to terminate
+var done chan int                 //Channel to notify the main thread when a client is done
+var termina chan int              //Channel to signal the server to terminate
+
+var serverDelay = time.Second     //Pause at the start of every server cycle (0 in benchmarks)
+
+//Creates all the channels; size is the buffer of the request channel (0 = unbuffered)
+func initChannels(size int) {
+	richiesta = make(chan int, size)
+	rilascio = make(chan int)
+	done = make(chan int)
+	termina = make(chan int)
+	for i := 0; i < MAXPROC; i++ {
+		risorsa[i] = make(chan int)
+	}
+}
 
 func when(b bool, c chan int) chan int {
 	if !b {
@@ -56,24 +74,80 @@ func server(nris int) {
 	var disponibili int = nris           //Number of available resources
 	var res, p, i int                    //Temporary variables for resource index, client ID, etc.
 	var libera [MAXRES]bool              //Tracks whether each resource is available
+	var fuori [MAXRES]bool               //Resources out of service (admin commands, see admin.go)
+	paused := false                      //While paused no resource is allocated (admin commands)
 	for i := 0; i < nris; i++ {
 		libera[i] = true             //Initialize all resources as available
 	}
 	for {
-		time.Sleep(time.Second * 1) 
+		time.Sleep(serverDelay)
 		fmt.Println("nuovo ciclo server")
 		select {
 	    		case res = <-rilascio:                                             //Resource release
-	    			disponibili++
+	    			if !fuori[res] {                                           //Out of service: not allocated again
+	    				disponibili++
+	    			}
 	    			libera[res] = true
 	    			fmt.Printf("[server]  restituita risorsa: %d  \n", res)
-	    		case p = <-when(disponibili > 0, richiesta):                       //Handle resource request if available
-	    			for i = 0; i < nris && !libera[i]; i++ {
+	    		case p = <-when(!paused && disponibili > 0, richiesta):            //Handle resource request if available
+	    			for i = 0; i < nris && (!libera[i] || fuori[i]); i++ {
 	    			}
 	    			libera[i] = false
 	    			disponibili--
 	    			risorsa[p] <- i                                            //Allocate resource to client
 	    			fmt.Printf("[server]  allocata risorsa %d a cliente %d \n", i, p)
+	    		case cmd := <-adminCommands:                                       //An admin command
+	    			switch cmd.Op {
+	    			case ADMIN_PAUSE, ADMIN_RESUME:
+	    				paused = cmd.Op == ADMIN_PAUSE
+	    				fmt.Printf("[server]  admin: %s\n", cmd.Op)
+	    				cmd.Reply("paused: %v", paused)
+	    			case ADMIN_SET:
+	    				cmd.Fail("no capacity to change: take resources out of service with out resource <i>")
+	    			case ADMIN_OUT, ADMIN_IN:
+	    				if cmd.Name != "resource" {
+	    					cmd.Fail("no %s (resource)", cmd.Name)
+	    					break
+	    				}
+	    				r, err := cmd.Resource(nris)
+	    				switch {
+	    				case err != nil:
+	    				case cmd.Op == ADMIN_OUT && fuori[r]:
+	    					cmd.Fail("resource %d is already out of service", r)
+	    				case cmd.Op == ADMIN_IN && !fuori[r]:
+	    					cmd.Fail("resource %d is already in service", r)
+	    				case cmd.Op == ADMIN_OUT:
+	    					fuori[r] = true
+	    					fmt.Printf("[server]  admin: risorsa %d fuori servizio\n", r)
+	    					if !libera[r] {                                    //In use: out when it is returned
+	    						cmd.Reply("resource %d out of service when it is returned", r)
+	    						break
+	    					}
+	    					disponibili--
+	    					cmd.Reply("resource %d out of service", r)
+	    				default:
+	    					fuori[r] = false
+	    					fmt.Printf("[server]  admin: risorsa %d di nuovo in servizio\n", r)
+	    					if libera[r] {
+	    						disponibili++
+	    					}
+	    					cmd.Reply("resource %d in service", r)
+	    				}
+	    			default: // ADMIN_DUMP
+	    				var free, out, inUse []int
+	    				for r := 0; r < nris; r++ {
+	    					switch {
+	    					case fuori[r]:
+	    						out = append(out, r)
+	    					case libera[r]:
+	    						free = append(free, r)
+	    					}
+	    					if !libera[r] {
+	    						inUse = append(inUse, r)
+	    					}
+	    				}
+	    				cmd.Reply("paused: %v; free %v, out of service %v, in use %v", paused, free, out, inUse)
+	    			}
 	    		case <-termina:                                                    //Terminate when signaled
 	    			fmt.Println("FINE")
 	    			done <- 1                                                  //Notify main thread of server completion
@@ -90,14 +164,14 @@ func main() {
 	fmt.Printf("\n quante risorse (max %d)? ", MAXRES)
 	fmt.Scanf("%d", &res)
 	fmt.Println("risorse da gestire:", res)
-        // Initialize client-specific channels
-	for i := 0; i < MAXPROC; i++ {
-		risorsa[i] = make(chan int)
-	}
-	// Start client and server goroutines
-	for i := 0; i < cli; i++ {
-		go client(i)
-	}
+	// Initialize channels (unbuffered requests)
+	initChannels(0)
+	// Start client and server goroutines: the clients arrive following the
+	// workload (-arrivals flag, see workload.go; by default all of them at once)
+	workload := WorkloadFromFlags([]string{"client"}, "spread:max=0", "client=1")
+	workload.Spawn(cli, func(id, _ int) { client(id) })
+	// Admin commands for the server, with -admin (see admin.go)
+	stopAdmin := StartAdminFromFlags()
 	go server(res)
 	// Wait for all clients to complete
 	for i := 0; i < cli; i++ {
@@ -105,4 +179,10 @@ func main() {
 	}
 	termina <- 1 // Signal server to terminate
 	<-done
+	stopAdmin()
+
+	if err := CheckLeaks(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,204 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR server()
+//
+// The tests feed server() scripted sequences of requests and releases under a
+// virtual clock, and check which clients are served, in which order, and that
+// every resource is free again at the end. Unlike ex1.go, a request is left in
+// the channel while no resource is free, so waiting clients are served in
+// arrival order (the order of the senders blocked on richiesta). TestAdmin sends
+// the server the commands of admin.go.
+//
+// Synthetic clients repeat the request/release cycle of client() without any
+// sleep, and the benchmark reports:
+//   - grants/s:        resources allocated per second;
+//   - p50-ns, p99-ns:  latency from `richiesta <- i` to the resource arriving on risorsa[i];
+//   - allocs/op:       allocations per granted request.
+// It runs twice: with the unbuffered request channel used by main()
+// and with a buffered one (MAXPROC slots).
+//
+// Run with:
+//     go test -race ex2.go workload.go leaks.go admin.go servertest_test.go ex2_test.go
+//     go test -run XXX -bench . ex2.go workload.go leaks.go admin.go servertest_test.go ex2_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = 20 // Synthetic clients: more than the resources, so some of them wait
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+const (
+	opRequest = iota // richiesta <- id
+	opRelease        // rilascio <- the resource obtained by id
+)
+
+var opNames = []string{"request", "release"}
+
+func TestServer(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name  string
+		nris  int
+		steps []step
+	}{
+		{"free resources are allocated at once", 2, []step{
+			{opRequest, 0, []int{0}},
+			{opRequest, 1, []int{1}},
+			{opRelease, 0, nil},
+			{opRequest, 2, []int{2}},
+			{opRelease, 1, nil},
+			{opRelease, 2, nil},
+		}},
+		{"with no free resource the request is not accepted", 1, []step{
+			{opRequest, 0, []int{0}},
+			{opRequest, 1, nil},
+			{opRelease, 0, []int{1}},
+			{opRelease, 1, nil},
+		}},
+		{"blocked requests are served in arrival order", 1, []step{
+			{opRequest, 0, []int{0}},
+			{opRequest, 3, nil},
+			{opRequest, 1, nil},
+			{opRequest, 2, nil},
+			{opRelease, 0, []int{3}},
+			{opRelease, 3, []int{1}},
+			{opRelease, 1, []int{2}},
+			{opRelease, 2, nil},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				initChannels(0)
+				go server(tt.nris)
+				s := newScript[int](t)
+				play(s, tt.steps...)
+				s.finish()
+				checkAllFree(s, tt.nris)
+				termina <- 1
+				<-done
+			})
+		})
+	}
+}
+
+// play sends the steps to the server, checking the clients served after each.
+func play(s *script[int], steps ...step) {
+	s.t.Helper()
+	for _, st := range steps {
+		switch st.op {
+		case opRequest:
+			s.call(st.id, risorsa[st.id], func() { richiesta <- st.id })
+		case opRelease:
+			r := s.last[st.id]
+			go func() { rilascio <- r }()
+		}
+		s.expect(st.String(), st.want)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		initChannels(0)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server(2)
+		s := newScript[int](t)
+
+		// Resource 1 out of service: client 1 waits, until it is back in service
+		s.admin(Admin, "out resource 1", "resource 1 out of service", nil)
+		play(s, step{opRequest, 0, []int{0}}, step{opRequest, 1, nil})
+		s.admin(Admin, "dump", "paused: false; free [], out of service [1], in use [0]", nil)
+		s.admin(Admin, "in resource 1", "resource 1 in service", []int{1})
+
+		// Out of service while in use: it leaves when it is returned
+		s.admin(Admin, "out resource 0", "resource 0 out of service when it is returned", nil)
+		play(s, step{opRelease, 0, nil}, step{opRequest, 2, nil})
+
+		// Paused: the resources come back, the requests wait for resume
+		s.admin(Admin, "pause", "paused: true", nil)
+		s.admin(Admin, "in resource 0", "resource 0 in service", nil)
+		play(s, step{opRelease, 1, nil})
+		s.admin(Admin, "resume", "paused: false", []int{2})
+
+		s.admin(Admin, "set MAXRES 3", "error: no capacity to change: take resources out of service with out resource <i>", nil)
+		s.admin(Admin, "out printer 0", "error: no printer (resource)", nil)
+		s.admin(Admin, "out resource 2", "error: there is no resource 2 (0-1)", nil)
+		s.admin(Admin, "in resource 1", "error: resource 1 is already in service", nil)
+		play(s, step{opRelease, 2, nil})
+		if got := []int{s.last[0], s.last[1], s.last[2]}; !slices.Equal(got, []int{0, 1, 0}) {
+			t.Errorf("clients 0-2 got resources %v, want [0 1 0]", got)
+		}
+		s.finish()
+		checkAllFree(s, 2)
+		termina <- 1
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// checkAllFree verifies that the nris resources are all free again:
+// as many new clients are served at once, each with a different resource.
+func checkAllFree(s *script[int], nris int) {
+	s.t.Helper()
+	for i := 0; i < nris; i++ {
+		id := MAXPROC - 1 - i
+		s.call(id, risorsa[id], func() { richiesta <- id })
+	}
+	var got []int
+	for _, id := range s.grants() {
+		got = append(got, s.last[id])
+	}
+	slices.Sort(got)
+	want := make([]int, nris)
+	for r := range want {
+		want[r] = r
+	}
+	if !slices.Equal(got, want) {
+		s.t.Errorf("final state: new clients got resources %v, want %v", got, want)
+	}
+	s.finish()
+	for _, r := range got {
+		rilascio <- r
+	}
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+func BenchmarkServer(b *testing.B) {
+	silence(b)
+	d := serverDelay
+	b.Cleanup(func() { serverDelay = d })
+	serverDelay = 0
+	for _, m := range bufferModes(MAXPROC) {
+		b.Run(m.name, func(b *testing.B) {
+			initChannels(m.size)
+			go server(MAXRES)
+			measure(b, loadClients, func(i int) time.Duration {
+				t := time.Now()
+				richiesta <- i
+				r := <-risorsa[i]
+				d := time.Since(t)
+				rilascio <- r
+				return d
+			})
+			termina <- 1
+			<-done
+		})
+	}
+}
@@ -0,0 +1 @@
+../../queueing/queueing.go
\ No newline at end of file
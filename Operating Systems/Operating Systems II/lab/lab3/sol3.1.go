@@ -37,12 +37,15 @@
 // from `done`), we send a termination message to the server (on `termina`), 
 // then wait for the final `done` from the server.
 //
+// Run with:
+//     go run sol3.1.go workload.go leaks.go admin.go
 // -----------------------------------------------------------------------------------
 
 package main
 
 import (
     "fmt"
+    "os"
     "time"
 )
 
@@ -73,20 +76,35 @@ type req struct {
 // CHANNELS:
 
 // 'richiesta': used by clients to request a bike (of type req).
-var richiesta = make(chan req)
+var richiesta chan req
 
 // 'rilascio': used by clients to return their allocated bike (of type bici).
-var rilascio = make(chan bici)
+var rilascio chan bici
 
 // 'risorsa[i]': used by the server to send an allocated bike to client i.
 var risorsa [MAXPROC]chan bici
 
 // 'done': used to synchronize the completion of both clients and the server.
-var done = make(chan int)
+var done chan int
 
 // 'termina': used by main to tell the server it can shut down 
 //            once all clients have finished.
-var termina = make(chan int)
+var termina chan int
+
+// initChannels creates all the channels above.
+// 'size' is the buffer of 'richiesta' (0 = unbuffered, as in main).
+func initChannels(size int) {
+    richiesta = make(chan req, size)
+    rilascio = make(chan bici)
+    done = make(chan int)
+    termina = make(chan int)
+    for i := 0; i < MAXPROC; i++ {
+        risorsa[i] = make(chan bici)
+    }
+}
+
+// serverDelay slows down the server loop for demonstration (0 in benchmarks).
+var serverDelay = 1 * time.Second
 
 // We keep track of whether each bike (EB or BT) is free or not using boolean arrays.
 // (In this particular solution, we also have counters dispEB and dispBT in the server,
@@ -94,6 +112,26 @@ var termina = make(chan int)
 var liberaEB [N_EB]bool
 var liberaBT [N_BT]bool
 
+// when is a helper function used for "guarded" select statements:
+// it returns 'c' if b == true, or nil if b == false, effectively blocking that case.
+func when(b bool, c chan req) chan req {
+    if !b {
+        return nil
+    }
+    return c
+}
+
+// fuoriServizio returns the numbers of the bikes out of service.
+func fuoriServizio(fuori []bool) []int {
+    numeri := []int{}
+    for i, f := range fuori {
+        if f {
+            numeri = append(numeri, i)
+        }
+    }
+    return numeri
+}
+
 // GOROUTINE: client
 // 1) Prints its request (BT, EB, or FLEX).
 // 2) Sends a request on 'richiesta'.
@@ -166,14 +204,30 @@ func server() {
         sospEB[i] = false
     }
 
+    // Admin commands (see admin.go): while paused no request is received, but
+    // the returned bikes still go to the clients already waiting. A bike taken
+    // out of service while everyone of its type is in use leaves with the
+    // next one returned ('daRitirare' of that type). The bikes of a type are
+    // not told apart: 'out eb <i>' takes an electric bike out of service, not
+    // the bike i, and i only names which of the N_EB slots is out.
+    paused := false
+    var fuoriEB [N_EB]bool
+    var fuoriBT [N_BT]bool
+    var daRitirare [2]int // indexed by BT, EB
+    nome := [2]string{BT: "traditional", EB: "electric"}
+
     for {
         // Sleep here just to slow down the server loop for demonstration
-        time.Sleep(1 * time.Second)
+        time.Sleep(serverDelay)
 
         select {
         case b = <-rilascio:
             // A bike is being returned
-            if b == EB {
+            if daRitirare[b] > 0 {
+                // It replaces a bike of its type taken out of service
+                daRitirare[b]--
+                fmt.Printf("[server] a returned %s bike goes out of service.\n", nome[b])
+            } else if b == EB {
                 // Electric bike returned
                 if nsospEB == 0 {
                     // No one is waiting for EB, increment available EB
@@ -206,8 +260,9 @@ func server() {
                         }
                     }
                 }
+            }
 
-        case r = <-richiesta:
+        case r = <-when(!paused, richiesta):
             // A new client request arrived
             switch r.tipo {
             case FLEX:
@@ -256,6 +311,79 @@ func server() {
                 }
             }
 
+        case cmd := <-adminCommands:
+            // An admin command
+            switch cmd.Op {
+            case ADMIN_PAUSE, ADMIN_RESUME:
+                paused = cmd.Op == ADMIN_PAUSE
+                fmt.Printf("[server] admin: %s.\n", cmd.Op)
+                cmd.Reply("paused: %v", paused)
+
+            case ADMIN_SET:
+                cmd.Fail("no capacity to change: take bikes out of service with out eb|bt <i>")
+
+            case ADMIN_OUT, ADMIN_IN:
+                // The bikes of the type of the command
+                var fuori []bool
+                var disp, nsosp *int
+                var sosp *[MAXPROC]bool
+                switch cmd.Name {
+                case "eb":
+                    b, fuori, disp, nsosp, sosp = EB, fuoriEB[:], &dispEB, &nsospEB, &sospEB
+                case "bt":
+                    b, fuori, disp, nsosp, sosp = BT, fuoriBT[:], &dispBT, &nsospBT, &sospBT
+                default:
+                    cmd.Fail("no bike %s (eb, bt)", cmd.Name)
+                }
+                if fuori == nil {
+                    break
+                }
+                i, err := cmd.Resource(len(fuori))
+                if err != nil {
+                    break
+                }
+                if cmd.Op == ADMIN_OUT && fuori[i] {
+                    cmd.Fail("%s %d is already out of service", cmd.Name, i)
+                    break
+                }
+                if cmd.Op == ADMIN_IN && !fuori[i] {
+                    cmd.Fail("%s %d is already in service", cmd.Name, i)
+                    break
+                }
+                fuori[i] = cmd.Op == ADMIN_OUT
+                fmt.Printf("[server] admin: %s bike %d %s of service.\n", nome[b], i, cmd.Op)
+                if fuori[i] && *disp > 0 {
+                    *disp--
+                    cmd.Reply("%s %d out of service: one %s bike fewer", cmd.Name, i, nome[b])
+                } else if fuori[i] {
+                    // Every bike of its type is in use: the next one returned leaves
+                    daRitirare[b]++
+                    cmd.Reply("%s %d out of service: the next %s bike returned", cmd.Name, i, nome[b])
+                } else {
+                    if daRitirare[b] > 0 {
+                        // Back before a bike was returned in its place
+                        daRitirare[b]--
+                    } else if *nsosp == 0 {
+                        *disp++
+                    } else {
+                        // Someone is waiting for this type, assign it immediately
+                        for j := 0; j < MAXPROC; j++ {
+                            if sosp[j] {
+                                risorsa[j] <- b
+                                *nsosp--
+                                sosp[j] = false
+                                break
+                            }
+                        }
+                    }
+                    cmd.Reply("%s %d in service", cmd.Name, i)
+                }
+
+            default: // ADMIN_DUMP
+                cmd.Reply("paused: %v; EB: %d free, out of service %v (%d still in use), %d waiting; BT: %d free, out of service %v (%d still in use), %d waiting",
+                    paused, dispEB, fuoriServizio(fuoriEB[:]), daRitirare[EB], nsospEB, dispBT, fuoriServizio(fuoriBT[:]), daRitirare[BT], nsospBT)
+            }
+
         case <-termina:
             // All clients have finished, time to end
             fmt.Println("END OF SERVER!")
@@ -268,26 +396,23 @@ func server() {
 
 func main() {
     var cli int
-    var r req
 
     // Ask how many clients to create (up to MAXPROC)
     fmt.Printf("\n How many clients (max %d)? ", MAXPROC)
     fmt.Scanf("%d", &cli)
     fmt.Println("Number of clients:", cli)
 
-    // Initialize risorsa channels: one channel per client
-    for i := 0; i < MAXPROC; i++ {
-        risorsa[i] = make(chan bici)
-    }
+    // Initialize the channels (risorsa: one channel per client)
+    initChannels(0)
 
-    // Create client goroutines
-    // We'll assign them types (BT, EB, FLEX) by taking i % 3
-    //   0 => BT, 1 => EB, 2 => FLEX
-    for i := 0; i < cli; i++ {
-        r.id = i
-        r.tipo = i % 3
-        go client(r)
-    }
+    // Create client goroutines at the arrival times of the workload (see workload.go).
+    // Their types (BT, EB, FLEX) follow the -mix flag: by default one third each,
+    // like the assignment i % 3
+    workload := WorkloadFromFlags([]string{"BT", "EB", "FLEX"}, "spread:max=0", "BT=1,EB=1,FLEX=1")
+    workload.Spawn(cli, func(id, tipo int) { client(req{id, tipo}) })
+
+    // Admin commands for the bikes, with -admin (see admin.go)
+    stopAdmin := StartAdminFromFlags()
 
     // Create the server goroutine
     go server()
@@ -302,4 +427,10 @@ func main() {
 
     // Wait for the server's final 'done'
     <-done
+    stopAdmin()
+
+    if err := CheckLeaks(); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
 }
@@ -0,0 +1,233 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR server() (BIKE RENTAL, FIRST SOLUTION)
+//
+// The tests feed server() scripted sequences of requests and returns under a
+// virtual clock, and check which clients get a bike, in which order, which
+// kind of bike, and that every bike is back at the end. TestAdmin takes the
+// electric bike out of service and pauses the server with admin commands (see
+// admin.go).
+//
+// Synthetic clients repeat the cycle of client() without any sleep: request a
+// bike on richiesta, wait for it on risorsa[id], return it on rilascio. The
+// request type is fixed per client as in main (id % 3 = BT, EB, FLEX), so the
+// clients asking for the single electric bike have to wait.
+//
+// The benchmark reports:
+//   - grants/s:        bikes assigned per second;
+//   - p50-ns, p99-ns:  latency from `richiesta <- r` to the bike arriving on risorsa[r.id];
+//   - allocs/op:       allocations per grant.
+// It runs twice: with unbuffered request channels and with buffered ones
+// (MAXPROC slots).
+//
+// Run with:
+//     go test -race sol3.1.go workload.go leaks.go admin.go servertest_test.go sol3.1_test.go
+//     go test -run XXX -bench . sol3.1.go workload.go leaks.go admin.go servertest_test.go sol3.1_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = 20 // Synthetic clients (ids 0..19)
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+const (
+	opBT      = iota // richiesta <- req{id, BT}
+	opEB             // richiesta <- req{id, EB}
+	opFLEX           // richiesta <- req{id, FLEX}
+	opRelease        // rilascio <- the bike obtained by id
+)
+
+var opNames = []string{"BT request", "EB request", "FLEX request", "release"}
+
+func TestServer(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name  string
+		steps []step
+		bikes map[int]bici // bike received by some of the clients
+	}{
+		{"FLEX gets the electric bike when it is free", []step{
+			{opFLEX, 0, []int{0}},
+			{opRelease, 0, nil},
+		}, map[int]bici{0: EB}},
+		{"FLEX falls back on a traditional bike", []step{
+			{opEB, 0, []int{0}},
+			{opFLEX, 1, []int{1}},
+			{opEB, 2, nil},
+			{opRelease, 1, nil},
+			{opRelease, 0, []int{2}},
+			{opRelease, 2, nil},
+		}, map[int]bici{0: EB, 1: BT, 2: EB}},
+		{"clients waiting for the electric bike are served by id", []step{
+			{opEB, 0, []int{0}},
+			{opEB, 5, nil},
+			{opFLEX, 3, []int{3}},
+			{opEB, 4, nil},
+			{opRelease, 0, []int{4}},
+			{opRelease, 4, []int{5}},
+			{opRelease, 5, nil},
+			{opRelease, 3, nil},
+		}, map[int]bici{3: BT, 4: EB, 5: EB}},
+		{"with no bike left FLEX waits for the electric one", slices.Concat(
+			[]step{{opEB, 0, []int{0}}},
+			each(opBT, 1, N_BT, true),
+			[]step{
+				{opFLEX, 40, nil},
+				{opBT, 41, nil},
+				{opRelease, 1, []int{41}},
+				{opRelease, 0, []int{40}},
+				{opRelease, 40, nil},
+				{opRelease, 41, nil},
+			},
+			each(opRelease, 2, N_BT, false),
+		), map[int]bici{40: EB, 41: BT}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				initChannels(0)
+				go server()
+				s := newScript[bici](t)
+				for _, st := range tt.steps {
+					if st.op == opRelease {
+						b := s.last[st.id]
+						go func() { rilascio <- b }()
+					} else {
+						r := req{st.id, st.op}
+						s.call(st.id, risorsa[st.id], func() { richiesta <- r })
+					}
+					s.expect(st.String(), st.want)
+				}
+				s.finish()
+				for id, want := range tt.bikes {
+					if got := s.last[id]; got != want {
+						t.Errorf("client %d got bike %d, want %d", id, got, want)
+					}
+				}
+				checkAllFree(s)
+				termina <- 1
+				<-done
+			})
+		})
+	}
+}
+
+// play does the steps, checking the clients granted after each of them.
+func play(s *script[bici], steps ...step) {
+	s.t.Helper()
+	for _, st := range steps {
+		if st.op == opRelease {
+			b := s.last[st.id]
+			go func() { rilascio <- b }()
+		} else {
+			r := req{st.id, st.op}
+			s.call(st.id, risorsa[st.id], func() { richiesta <- r })
+		}
+		s.expect(st.String(), st.want)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		initChannels(0)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server()
+		s := newScript[bici](t)
+
+		// The electric bike out of service: EB waits, FLEX takes a BT, and
+		// the bike back in service goes to the client waiting for it
+		s.admin(Admin, "out eb 0", "eb 0 out of service: one electric bike fewer", nil)
+		play(s, step{opEB, 0, nil}, step{opFLEX, 1, []int{1}})
+		s.admin(Admin, "dump", "paused: false; EB: 0 free, out of service [0] (0 still in use), 1 waiting; "+
+			"BT: 29 free, out of service [] (0 still in use), 0 waiting", nil)
+		s.admin(Admin, "in eb 0", "eb 0 in service", []int{0})
+
+		// Out of service while in use: it leaves when it is returned
+		s.admin(Admin, "out eb 0", "eb 0 out of service: the next electric bike returned", nil)
+		play(s, step{opEB, 2, nil}, step{opRelease, 0, nil})
+		s.admin(Admin, "in eb 0", "eb 0 in service", []int{2})
+
+		// Paused: the bikes come back, the requests wait for resume
+		s.admin(Admin, "pause", "paused: true", nil)
+		play(s, step{opEB, 3, nil}, step{opRelease, 2, nil}, step{opRelease, 1, nil})
+		s.admin(Admin, "resume", "paused: false", []int{3})
+
+		s.admin(Admin, "set N_EB 2", "error: no capacity to change: take bikes out of service with out eb|bt <i>", nil)
+		s.admin(Admin, "out car 1", "error: no bike car (eb, bt)", nil)
+		s.admin(Admin, "out bt 30", "error: there is no bt 30 (0-29)", nil)
+		s.admin(Admin, "in bt 3", "error: bt 3 is already in service", nil)
+		play(s, step{opRelease, 3, nil})
+		for id, want := range map[int]bici{0: EB, 1: BT, 2: EB, 3: EB} {
+			if got := s.last[id]; got != want {
+				t.Errorf("client %d got bike %d, want %d", id, got, want)
+			}
+		}
+		s.finish()
+		checkAllFree(s)
+		termina <- 1
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// checkAllFree verifies that every bike is back: N_EB clients asking for an
+// electric bike and N_BT asking for a traditional one are all served at once.
+func checkAllFree(s *script[bici]) {
+	s.t.Helper()
+	for i := 0; i < N_EB+N_BT; i++ {
+		r := req{MAXPROC - 1 - i, EB}
+		if i >= N_EB {
+			r.tipo = BT
+		}
+		s.call(r.id, risorsa[r.id], func() { richiesta <- r })
+	}
+	got := s.grants()
+	s.finish()
+	if len(got) != N_EB+N_BT {
+		s.t.Errorf("final state: %d bikes available, want %d", len(got), N_EB+N_BT)
+	}
+	for _, id := range got {
+		rilascio <- s.last[id]
+	}
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+func BenchmarkServer(b *testing.B) {
+	silence(b)
+	d := serverDelay
+	b.Cleanup(func() { serverDelay = d })
+	serverDelay = 0
+	for _, m := range bufferModes(MAXPROC) {
+		b.Run(m.name, func(b *testing.B) {
+			initChannels(m.size)
+			go server()
+			measure(b, loadClients, func(id int) time.Duration {
+				t := time.Now()
+				richiesta <- req{id: id, tipo: id % 3}
+				bike := <-risorsa[id]
+				d := time.Since(t)
+				rilascio <- bike
+				return d
+			})
+			termina <- 1
+			<-done
+		})
+	}
+}
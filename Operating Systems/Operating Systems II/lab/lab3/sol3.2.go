@@ -23,6 +23,8 @@
 //
 // Below is the code with inline commentary in English.
 //
+// Run with:
+//     go run sol3.2.go workload.go leaks.go admin.go
 // -----------------------------------------------------------------------------------
 
 package main
@@ -30,6 +32,7 @@ package main
 import (
     "fmt"
     "math/rand"
+    "os"
     "time"
 )
 
@@ -64,19 +67,36 @@ type req struct {
 //  - richiestaEB:   requests for an electric bike
 //  - richiestaFLEX: flexible requests (prefer EB, else BT)
 //  - rilascio:      used by clients to return a bike
-var richiestaBT = make(chan req, DIMBUF)
-var richiestaEB = make(chan req, DIMBUF)
-var richiestaFLEX = make(chan req, DIMBUF)
-var rilascio = make(chan bici, DIMBUF)
+var richiestaBT chan req
+var richiestaEB chan req
+var richiestaFLEX chan req
+var rilascio chan bici
 
 // Each client has its own 'risorsa[clientID]' channel to receive the allocated bike
 var risorsa [MAXPROC]chan bici
 
 // done is used for waiting for client completion and also for the server to signal
-var done = make(chan int)
+var done chan int
 
 // termina is sent from main to the server indicating it can shut down
-var termina = make(chan int)
+var termina chan int
+
+// initChannels creates all the channels above.
+// 'size' is the buffer of the three request channels (DIMBUF in main).
+func initChannels(size int) {
+    richiestaBT = make(chan req, size)
+    richiestaEB = make(chan req, size)
+    richiestaFLEX = make(chan req, size)
+    rilascio = make(chan bici, DIMBUF)
+    done = make(chan int)
+    termina = make(chan int)
+    for i := 0; i < MAXPROC; i++ {
+        risorsa[i] = make(chan bici, DIMBUF)
+    }
+}
+
+// serverDelay slows down the server loop for demonstration (0 in benchmarks).
+var serverDelay = time.Second * 1
 
 // when is a helper function used for "guarded" select statements:
 // it returns 'c' if b == true, or nil if b == false, effectively blocking that case.
@@ -131,13 +151,19 @@ func server() {
     var dispEB int = N_EB
     var dispBT int = N_BT
 
+    // The number of bikes of each type and the pause, changed by the admin
+    // commands (see admin.go): lowered below the bikes in use, the count goes
+    // negative and the bikes returned are retired until it is back to 0
+    maxEB, maxBT := N_EB, N_BT
+    paused := false
+
     // used for receiving a returned bike or an incoming request
     var b bici
     var r req
 
     for {
         // Slow down the loop a bit for demonstration
-        time.Sleep(time.Second * 1)
+        time.Sleep(serverDelay)
 
         select {
         case b = <-rilascio:
@@ -152,28 +178,28 @@ func server() {
             }
 
         // A request for a traditional bike (BT)
-        case r = <-when(dispBT > 0, richiestaBT):
+        case r = <-when(!paused && dispBT > 0, richiestaBT):
             dispBT--
             b = BT
             fmt.Printf("[server] assigned a traditional bike to client %d\n", r.id)
             risorsa[r.id] <- b
 
         // A request for an electric bike (EB)
-        case r = <-when(dispEB > 0, richiestaEB):
+        case r = <-when(!paused && dispEB > 0, richiestaEB):
             dispEB--
             b = EB
             fmt.Printf("[server] assigned an electric bike to client %d\n", r.id)
             risorsa[r.id] <- b
 
         // A FLEX request: if there's an EB available, assign EB first
-        case r = <-when(dispEB > 0, richiestaFLEX):
+        case r = <-when(!paused && dispEB > 0, richiestaFLEX):
             dispEB--
             b = EB
             fmt.Printf("[server] assigned an electric bike to FLEX client %d\n", r.id)
             risorsa[r.id] <- b
 
         // Another FLEX case: if no EB is left but there's a BT, assign BT
-        case r = <-when(dispEB == 0 && dispBT > 0, richiestaFLEX):
+        case r = <-when(!paused && dispEB <= 0 && dispBT > 0, richiestaFLEX):
             dispBT--
             b = BT
             fmt.Printf("[server] assigned a traditional bike to FLEX client %d\n", r.id)
@@ -181,11 +207,45 @@ func server() {
 
         // If both EB and BT are 0, we queue the FLEX request as an EB request,
         // effectively waiting for an electric bike. 
-        case r = <-when(dispEB == 0 && dispBT == 0, richiestaFLEX):
+        case r = <-when(!paused && dispEB <= 0 && dispBT <= 0, richiestaFLEX):
             fmt.Printf("[server] FLEX client %d is queued for an electric bike...\n", r.id)
             // re-send the request on richiestaEB, so the client is effectively waiting
             richiestaEB <- r
 
+        // An admin command
+        case cmd := <-adminCommands:
+            switch cmd.Op {
+            case ADMIN_PAUSE, ADMIN_RESUME:
+                paused = cmd.Op == ADMIN_PAUSE
+                fmt.Printf("[server] admin: %s.\n", cmd.Op)
+                cmd.Reply("paused: %v", paused)
+            case ADMIN_SET:
+                switch cmd.Name {
+                case "N_EB":
+                    if n, err := cmd.Capacity(N_EB); err == nil {
+                        dispEB += n - maxEB
+                        maxEB = n
+                        fmt.Printf("[server] admin: %d electric bikes.\n", maxEB)
+                        cmd.Reply("N_EB: %d, in use: %d", maxEB, maxEB-dispEB)
+                    }
+                case "N_BT":
+                    if n, err := cmd.Capacity(N_BT); err == nil {
+                        dispBT += n - maxBT
+                        maxBT = n
+                        fmt.Printf("[server] admin: %d traditional bikes.\n", maxBT)
+                        cmd.Reply("N_BT: %d, in use: %d", maxBT, maxBT-dispBT)
+                    }
+                default:
+                    cmd.Fail("no capacity %s (N_EB, N_BT)", cmd.Name)
+                }
+            case ADMIN_OUT, ADMIN_IN:
+                // The bikes are only counted, none can be told apart
+                cmd.Fail("no bike to take out of service: change their number with set N_EB|N_BT <n>")
+            default: // ADMIN_DUMP
+                cmd.Reply("paused: %v; EB: %d, in use %d, %d FLEX or EB waiting; BT: %d, in use %d, %d waiting",
+                    paused, maxEB, maxEB-dispEB, len(richiestaEB)+len(richiestaFLEX), maxBT, maxBT-dispBT, len(richiestaBT))
+            }
+
         // Termination case: all clients done
         case <-termina:
             fmt.Println("END OF SERVER!")
@@ -197,7 +257,6 @@ func server() {
 
 func main() {
     var cli int
-    var r req
 
     // Seed for random type assignments
     rand.Seed(time.Now().Unix())
@@ -207,18 +266,16 @@ func main() {
     fmt.Scanf("%d", &cli)
     fmt.Println("Number of clients:", cli)
 
-    // Initialize the channels for each client to receive a bike
-    for i := 0; i < MAXPROC; i++ {
-        risorsa[i] = make(chan bici, DIMBUF)
-    }
+    // Initialize the channels (including one per client to receive a bike)
+    initChannels(DIMBUF)
 
-    // Create client goroutines
-    // We randomly decide if each one is BT, EB, or FLEX
-    for i := 0; i < cli; i++ {
-        r.id = i
-        r.tipo = rand.Intn(3) // 0=BT, 1=EB, 2=FLEX
-        go client(r)
-    }
+    // Create client goroutines at the arrival times of the workload (see workload.go).
+    // Their types (BT, EB, FLEX) follow the -mix flag: by default one third each
+    workload := WorkloadFromFlags([]string{"BT", "EB", "FLEX"}, "spread:max=0", "BT=1,EB=1,FLEX=1")
+    workload.Spawn(cli, func(id, tipo int) { client(req{id, tipo}) })
+
+    // Admin commands for the server, with -admin (see admin.go)
+    stopAdmin := StartAdminFromFlags()
 
     // Create the server goroutine
     go server()
@@ -233,4 +290,10 @@ func main() {
 
     // Wait for the server to confirm termination
     <-done
+    stopAdmin()
+
+    if err := CheckLeaks(); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
 }
@@ -0,0 +1,264 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR server() (BIKE RENTAL, GUARDED SOLUTION)
+//
+// The tests feed server() scripted sequences of requests and returns under a
+// virtual clock, and check which clients get a bike, in which order, which
+// kind of bike, and that every bike is back at the end. TestAdmin sends the
+// server the commands of admin.go.
+//
+// Synthetic clients repeat the cycle of client() without any sleep: request a
+// bike on richiestaBT/EB/FLEX (type fixed per client), wait for it on
+// risorsa[id], return it on rilascio.
+//
+// BenchmarkServer compares the two channel configurations with BT and EB
+// clients only. FLEX requests are measured separately, with buffered channels
+// only: returned bikes can still be queued on rilascio when new requests
+// arrive, so sooner or later the server sees no bike left and re-sends a FLEX
+// request on richiestaEB from inside its own loop. With an unbuffered
+// richiestaEB nobody can ever receive that send, and the server blocks forever.
+//
+// Every benchmark reports:
+//   - grants/s:        bikes assigned per second;
+//   - p50-ns, p99-ns:  latency from the request to the bike arriving on risorsa[r.id];
+//   - allocs/op:       allocations per grant.
+// BenchmarkServer runs twice: with unbuffered request channels and with
+// buffered ones (DIMBUF slots). BenchmarkServerFlex runs once, buffered.
+//
+// Run with:
+//     go test -race sol3.2.go workload.go leaks.go admin.go servertest_test.go sol3.2_test.go
+//     go test -run XXX -bench . sol3.2.go workload.go leaks.go admin.go servertest_test.go sol3.2_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = 20 // Synthetic clients (ids 0..19)
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+// The tests use the buffered request channels of main (DIMBUF slots) but
+// unbuffered risorsa channels, so that every grant can be observed.
+
+const (
+	opBT      = iota // richiestaBT <- req{id, BT}
+	opEB             // richiestaEB <- req{id, EB}
+	opFLEX           // richiestaFLEX <- req{id, FLEX}
+	opRelease        // rilascio <- the bike obtained by id
+)
+
+var opNames = []string{"BT request", "EB request", "FLEX request", "release"}
+
+// request sends r on the channel of its type.
+func request(r req) {
+	[]chan req{richiestaBT, richiestaEB, richiestaFLEX}[r.tipo] <- r
+}
+
+func TestServer(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name  string
+		steps []step
+		bikes map[int]bici // bike received by some of the clients
+	}{
+		{"FLEX gets an electric bike when one is free", []step{
+			{opFLEX, 0, []int{0}},
+			{opRelease, 0, nil},
+		}, map[int]bici{0: EB}},
+		{"FLEX falls back on a traditional bike", slices.Concat(
+			each(opEB, 0, N_EB-1, true),
+			[]step{{opFLEX, 5, []int{5}}},
+			each(opRelease, 0, N_EB-1, false),
+			[]step{{opRelease, 5, nil}},
+		), map[int]bici{5: BT}},
+		{"clients waiting for an electric bike are served in arrival order", slices.Concat(
+			each(opEB, 0, N_EB-1, true),
+			[]step{
+				{opEB, 7, nil},
+				{opEB, 5, nil},
+				{opRelease, 0, []int{7}},
+				{opRelease, 1, []int{5}},
+			},
+			each(opRelease, 2, N_EB-1, false),
+			[]step{{opRelease, 5, nil}, {opRelease, 7, nil}},
+		), nil},
+		{"with no bike left FLEX is queued behind the electric requests", slices.Concat(
+			each(opEB, 0, N_EB-1, true),
+			each(opBT, N_EB, N_EB+N_BT-1, true),
+			[]step{
+				{opEB, 20, nil},
+				{opFLEX, 21, nil},
+				{opBT, 22, nil},
+				{opRelease, N_EB, []int{22}},
+				{opRelease, 0, []int{20}},
+				{opRelease, 1, []int{21}},
+				{opRelease, 20, nil},
+				{opRelease, 21, nil},
+				{opRelease, 22, nil},
+			},
+			each(opRelease, 2, N_EB-1, false),
+			each(opRelease, N_EB+1, N_EB+N_BT-1, false),
+		), map[int]bici{20: EB, 21: EB, 22: BT}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				initChannels(DIMBUF)
+				for i := range risorsa {
+					risorsa[i] = make(chan bici) // see the comment at the top of the tests
+				}
+				go server()
+				s := newScript[bici](t)
+				play(s, tt.steps...)
+				s.finish()
+				for id, want := range tt.bikes {
+					if got := s.last[id]; got != want {
+						t.Errorf("client %d got bike %d, want %d", id, got, want)
+					}
+				}
+				checkAllFree(s)
+				termina <- 1
+				<-done
+			})
+		})
+	}
+}
+
+// play sends the steps to the server, checking the clients served after each.
+func play(s *script[bici], steps ...step) {
+	s.t.Helper()
+	for _, st := range steps {
+		if st.op == opRelease {
+			b := s.last[st.id]
+			go func() { rilascio <- b }()
+		} else {
+			r := req{st.id, st.op}
+			s.call(st.id, risorsa[st.id], func() { request(r) })
+		}
+		s.expect(st.String(), st.want)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		initChannels(DIMBUF)
+		for i := range risorsa {
+			risorsa[i] = make(chan bici) // see the comment at the top of the tests
+		}
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server()
+		s := newScript[bici](t)
+
+		// One electric bike: client 1 waits, until there are three again
+		s.admin(Admin, "set N_EB 1", "N_EB: 1, in use: 0", nil)
+		play(s, step{opEB, 0, []int{0}}, step{opEB, 1, nil})
+		s.admin(Admin, "dump", "paused: false; EB: 1, in use 1, 1 FLEX or EB waiting; BT: 10, in use 0, 0 waiting", nil)
+		s.admin(Admin, "set N_EB 3", "N_EB: 3, in use: 1", []int{1})
+
+		// No electric bike: FLEX takes a traditional one, and the electric
+		// bike returned is retired
+		s.admin(Admin, "set N_EB 0", "N_EB: 0, in use: 2", nil)
+		play(s, step{opFLEX, 2, []int{2}}, step{opRelease, 0, nil}, step{opEB, 3, nil})
+
+		// Paused: the requests wait for resume
+		s.admin(Admin, "pause", "paused: true", nil)
+		s.admin(Admin, "set N_EB 3", "N_EB: 3, in use: 1", nil)
+		s.admin(Admin, "resume", "paused: false", []int{3})
+
+		s.admin(Admin, "set N_BT 11", "error: N_BT cannot be above 10", nil)
+		s.admin(Admin, "set MAX 1", "error: no capacity MAX (N_EB, N_BT)", nil)
+		s.admin(Admin, "out eb 0", "error: no bike to take out of service: change their number with set N_EB|N_BT <n>", nil)
+		play(s, step{opRelease, 1, nil}, step{opRelease, 2, nil}, step{opRelease, 3, nil})
+		for id, want := range map[int]bici{0: EB, 1: EB, 2: BT, 3: EB} {
+			if got := s.last[id]; got != want {
+				t.Errorf("client %d got bike %d, want %d", id, got, want)
+			}
+		}
+		s.finish()
+		checkAllFree(s)
+		termina <- 1
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// checkAllFree verifies that every bike is back: N_EB clients asking for an
+// electric bike and N_BT asking for a traditional one are all served at once.
+func checkAllFree(s *script[bici]) {
+	s.t.Helper()
+	for i := 0; i < N_EB+N_BT; i++ {
+		r := req{MAXPROC - 1 - i, EB}
+		if i >= N_EB {
+			r.tipo = BT
+		}
+		s.call(r.id, risorsa[r.id], func() { request(r) })
+	}
+	got := s.grants()
+	s.finish()
+	if len(got) != N_EB+N_BT {
+		s.t.Errorf("final state: %d bikes available, want %d", len(got), N_EB+N_BT)
+	}
+	for _, id := range got {
+		rilascio <- s.last[id]
+	}
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+func BenchmarkServer(b *testing.B) {
+	silence(b)
+	d := serverDelay
+	b.Cleanup(func() { serverDelay = d })
+	serverDelay = 0
+	for _, m := range bufferModes(DIMBUF) {
+		b.Run(m.name, func(b *testing.B) {
+			initChannels(m.size)
+			go server()
+			requests := [2]chan req{richiestaBT, richiestaEB}
+			measure(b, loadClients, func(id int) time.Duration {
+				t := time.Now()
+				requests[id%2] <- req{id: id, tipo: id % 2}
+				bike := <-risorsa[id]
+				d := time.Since(t)
+				rilascio <- bike
+				return d
+			})
+			termina <- 1
+			<-done
+		})
+	}
+}
+
+func BenchmarkServerFlex(b *testing.B) {
+	silence(b)
+	d := serverDelay
+	b.Cleanup(func() { serverDelay = d })
+	serverDelay = 0
+	initChannels(DIMBUF)
+	go server()
+	requests := [3]chan req{richiestaBT, richiestaEB, richiestaFLEX}
+	measure(b, loadClients, func(id int) time.Duration {
+		t := time.Now()
+		requests[id%3] <- req{id: id, tipo: id % 3}
+		bike := <-risorsa[id]
+		d := time.Since(t)
+		rilascio <- bike
+		return d
+	})
+	termina <- 1
+	<-done
+}
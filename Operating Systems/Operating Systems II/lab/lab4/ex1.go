@@ -17,6 +17,9 @@
 //    exit. The server goroutine manages the capacity and direction constraints.
 //  - The direction from the North is prioritized in the select statement: 
 //    vehicles from the South can only enter when contN == 0 and len(entrataN) == 0.
+//    This is the default ("strict") policy: with -policy (see policy.go) the
+//    South can also gain priority as it waits (aging), take turns with the North
+//    (wrr) or alternate batches (batch).
 //
 // Channels:
 //  - entrataN, entrataS: vehicles send their IDs here to request entry from North or 
@@ -28,7 +31,7 @@
 //  - termina: signals the server to shut down after all vehicles are done.
 //
 // Operation flow for a vehicle going North (N):
-//  1) Sleep a random time (initialization).
+//  1) Arrive at the time chosen by the workload (see main and workload.go).
 //  2) Send its ID on entrataN.
 //  3) Wait on ACK_N[myID] to be sure it can cross.
 //  4) Cross (simulate crossing by sleeping).
@@ -43,6 +46,15 @@
 // The program ends after all vehicles have finished crossing, and main sends a 
 // termina signal, at which point the server shuts down.
 //
+// With -bridge ring there is no server: the two directions pass a token along a
+// ring, and with -bridge compare the same vehicles cross both bridges (see
+// ex1ring.go).
+//
+// With -admin (see admin.go) server() can be paused and its capacity changed
+// while it runs; the ring has no server and takes no admin commands.
+//
+// Run with:
+//     go run ex1.go workload.go fairness.go leaks.go policy.go ex1ring.go admin.go
 // -----------------------------------------------------------------------------------
 
 package main
@@ -50,6 +62,7 @@ package main
 import (
     "fmt"
     "math/rand"
+    "os"
     "time"
 )
 
@@ -65,22 +78,50 @@ const N int = 0 // North
 const S int = 1 // South
 
 // Channels for synchronization
-var done = make(chan bool)     // signals a goroutine (vehicle/server) has finished
-var termina = make(chan bool)  // signals the server to terminate
+var done chan bool     // signals a goroutine (vehicle/server) has finished
+var termina chan bool  // signals the server to terminate
 
 // Channels for entering from North or South
-var entrataN = make(chan int, MAXBUFF)
-var entrataS = make(chan int, MAXBUFF)
+var entrataN chan int
+var entrataS chan int
 
 // Channels for exiting from North or South
-var uscitaN = make(chan int)
-var uscitaS = make(chan int)
+var uscitaN chan int
+var uscitaS chan int
 
 // Each vehicle from North or South has an acknowledgment channel to confirm 
 // permission to enter the bridge.
 var ACK_N [MAXPROC]chan int
 var ACK_S [MAXPROC]chan int
 
+// Waits of the vehicles to enter, by direction, reported at the end (see fairness.go)
+var fairness *Fairness
+
+// Which direction enters first (-policy flag, see policy.go); nil is the original
+// strict priority of the North
+var policy *Policy
+
+// initChannels creates all the channels above.
+// 'size' is the buffer of entrataN and entrataS (MAXBUFF in main): with size 0
+// len(entrataN) is always 0 and the North priority disappears.
+func initChannels(size int) {
+    done = make(chan bool)
+    termina = make(chan bool)
+    entrataN = make(chan int, size)
+    entrataS = make(chan int, size)
+    uscitaN = make(chan int)
+    uscitaS = make(chan int)
+    for i := 0; i < MAXPROC; i++ {
+        ACK_N[i] = make(chan int, MAXBUFF)
+        ACK_S[i] = make(chan int, MAXBUFF)
+    }
+}
+
+// queues returns how many vehicles wait to enter, by direction (N, S).
+func queues() []int {
+    return []int{len(entrataN), len(entrataS)}
+}
+
 // Helper function for "guarded" channels: returns c if b is true, or nil otherwise.
 // This effectively enables/disables a select case based on the condition b.
 func when(b bool, c chan int) chan int {
@@ -92,24 +133,23 @@ func when(b bool, c chan int) chan int {
 
 // GOROUTINE: veicolo
 // A vehicle i traveling in direction dir. 
-// 1) Sleeps a random init time.
+// 1) Is started by main at its arrival time.
 // 2) Requests entry by sending ID on entrataN or entrataS.
 // 3) Waits for ACK_N[i] or ACK_S[i] to confirm it can enter.
 // 4) Sleeps to simulate crossing.
 // 5) Signals exit via uscitaN or uscitaS.
 // 6) Sends a done signal.
 func veicolo(myid int, dir int) {
-    // Random initialization delay
     var tt int
-    tt = rand.Intn(5) + 1
-    fmt.Printf("Initializing vehicle %d direction %d in %d seconds\n", myid, dir, tt)
-    time.Sleep(time.Duration(tt) * time.Second)
+    fmt.Printf("Vehicle %d direction %d arrived\n", myid, dir)
 
     if dir == N {
         // Request to enter from North
+        ticket := fairness.Request(myid, N)
         entrataN <- myid 
         // Wait for server acknowledgment
         <-ACK_N[myid]   
+        fairness.Served(ticket)
         fmt.Printf("[vehicle %d] entered the bridge heading NORTH\n", myid)
 
         // Cross the bridge (random time)
@@ -122,9 +162,11 @@ func veicolo(myid int, dir int) {
 
     } else {
         // Request to enter from South
+        ticket := fairness.Request(myid, S)
         entrataS <- myid
         // Wait for server acknowledgment
         <-ACK_S[myid]
+        fairness.Served(ticket)
         fmt.Printf("[vehicle %d] entered the bridge heading SOUTH\n", myid)
 
         // Cross the bridge (random time)
@@ -148,20 +190,26 @@ func veicolo(myid int, dir int) {
 //
 // Priority is given to vehicles from the North. The code in select ensures that 
 // if there are vehicles from the North waiting, the South can't enter 
-// (unless contN == 0 and len(entrataN) == 0).
+// (unless contN == 0 and len(entrataN) == 0). The priority is decided by
+// policy.Allows, with the strict policy by default.
 func server() {
     var contN int = 0 // how many North vehicles are currently on the bridge
     var contS int = 0 // how many South vehicles are currently on the bridge
+    maxPonte := MAX   // capacity of the bridge, changed by the admin commands (see admin.go)
+    paused := false   // while paused nobody enters (admin commands)
 
     for {
         select {
         // 1) A North vehicle tries to enter if contN < MAX and contS == 0
-        case x := <-when((contN < MAX) && (contS == 0), entrataN):
+        case x := <-when(!paused && (contN < maxPonte) && (contS == 0) && policy.Allows(N, queues()), entrataN):
+            policy.Served(N)
             contN++
             ACK_N[x] <- 1 // allow the vehicle to enter
 
         // 2) A South vehicle tries to enter if contS < MAX, contN == 0, and no North waiting
-        case x := <-when((contS < MAX) && (contN == 0) && (len(entrataN) == 0), entrataS):
+        //    (with the strict policy)
+        case x := <-when(!paused && (contS < maxPonte) && (contN == 0) && policy.Allows(S, queues()), entrataS):
+            policy.Served(S)
             contS++
             ACK_S[x] <- 1 // allow the vehicle to enter
 
@@ -173,7 +221,29 @@ func server() {
         case <-uscitaS:
             contS--
 
-        // 5) Termination signal from main
+        // 5) An admin command
+        case cmd := <-adminCommands:
+            switch cmd.Op {
+            case ADMIN_PAUSE, ADMIN_RESUME:
+                paused = cmd.Op == ADMIN_PAUSE
+                fmt.Printf("[bridge] admin: %s.\n", cmd.Op)
+                cmd.Reply("paused: %v", paused)
+            case ADMIN_SET:
+                if cmd.Name != "MAX" {
+                    cmd.Fail("no capacity %s (MAX)", cmd.Name)
+                } else if n, err := cmd.Capacity(MAX); err == nil {
+                    maxPonte = n
+                    fmt.Printf("[bridge] admin: at most %d vehicles.\n", maxPonte)
+                    cmd.Reply("MAX: %d, on the bridge: %d", maxPonte, contN+contS)
+                }
+            case ADMIN_OUT, ADMIN_IN:
+                cmd.Fail("nothing to take out of service on the bridge")
+            default: // ADMIN_DUMP
+                cmd.Reply("paused: %v; MAX: %d; NORTH: %d on the bridge, %d waiting; SOUTH: %d on the bridge, %d waiting",
+                    paused, maxPonte, contN, len(entrataN), contS, len(entrataS))
+            }
+
+        // 6) Termination signal from main
         case <-termina:
             fmt.Println("END!!!")
             done <- true
@@ -191,41 +261,50 @@ func main() {
     fmt.Printf("\nHow many SOUTH vehicles (max %d)? ", MAXPROC)
     fmt.Scanf("%d", &VS)
 
-    // Initialize the acknowledgment channels for each North vehicle
-    for i := 0; i < VN; i++ {
-        ACK_N[i] = make(chan int, MAXBUFF)
-    }
-
-    // Initialize the acknowledgment channels for each South vehicle
-    for i := 0; i < VS; i++ {
-        ACK_S[i] = make(chan int, MAXBUFF)
-    }
-
     // Seed random generator
     rand.Seed(time.Now().Unix())
 
-    // Start the server goroutine
-    go server()
-
-    // Create SOUTH vehicle goroutines
-    for i := 0; i < VS; i++ {
-        go veicolo(i, S)
-    }
+    // SOUTH and NORTH vehicles arrive at the times of the workload (-arrivals flag,
+    // see workload.go; by default each one after 1-5 seconds)
+    workload := WorkloadFromFlags([]string{"vehicle"}, "spread:max=5", "vehicle=1")
+    planS, planN := workload.Plan(VS), workload.Plan(VN)
+    policy = PolicyFromFlags([]string{"NORTH", "SOUTH"}, "strict")
+
+    // The bridge is run by server(), by a token ring of the two directions with
+    // -bridge ring, or by both one after the other with -bridge compare (see
+    // ex1ring.go)
+    bridges := Bridges()
+
+    // Admin commands for server(), with -admin (see admin.go): with the ring
+    // alone nobody receives them, and they are refused at the end
+    stopAdmin := StartAdminFromFlags()
+    for _, bridge := range bridges {
+        fmt.Printf("\nThe bridge is run by %s\n", bridge.name)
+
+        // Initialize the channels (including the acknowledgment channel of each vehicle)
+        initChannels(MAXBUFF)
+        fairness = FairnessFromFlags([]string{"NORTH", "SOUTH"})
+
+        // Start the server goroutine (or the ring), once the policy it reads is set
+        bridge.run()
+        SpawnPlan(planS, func(id, _ int) { veicolo(id, S) })
+        SpawnPlan(planN, func(id, _ int) { veicolo(id, N) })
+
+        // Wait until all vehicles are done
+        for i := 0; i < VN+VS; i++ {
+            <-done
+        }
 
-    // Create NORTH vehicle goroutines
-    for i := 0; i < VN; i++ {
-        go veicolo(i, N)
+        // Signal the server to terminate and wait for its done
+        bridge.finish(fairness)
+        fmt.Printf("\nALL FINISHED\n")
+        fairness.Report(os.Stdout)
     }
+    stopAdmin()
+    ReportBridges(os.Stdout, bridges)
 
-    // Wait until all vehicles are done
-    for i := 0; i < VN+VS; i++ {
-        <-done
+    if err := CheckLeaks(); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
     }
-
-    // Signal the server to terminate
-    termina <- true
-
-    // Wait for the server's done
-    <-done
-    fmt.Printf("\nALL FINISHED\n")
 }
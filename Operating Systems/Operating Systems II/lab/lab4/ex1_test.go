@@ -0,0 +1,398 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR server() (ONE-WAY BRIDGE, NORTH PRIORITY)
+//
+// The tests feed server() scripted sequences of entries and exits under a
+// virtual clock, and check which vehicles are admitted, in which order, and
+// that the bridge is empty again at the end. TestAdmin sends the server the
+// commands of admin.go.
+//
+// Synthetic vehicles repeat the cycle of veicolo() without any sleep: even ids
+// travel NORTH, odd ids SOUTH. Each one requests entry on entrataN/entrataS,
+// waits for ACK_N/ACK_S, then leaves on uscitaN/uscitaS. With unbuffered entry
+// channels len(entrataN) is always 0, so the North priority disappears and the
+// two configurations actually run different policies.
+//
+// The ring of ex1ring.go is tested with synthetic vehicles crossing while
+// passes of the token are lost: the two directions never share the bridge, at
+// most MAX vehicles are on it, every lost token is made again and no token is
+// made while one is around. The rules of the probes are tested on their own,
+// with two groups probing at the same time.
+//
+// The benchmark reports:
+//   - grants/s:        vehicles admitted on the bridge per second;
+//   - p50-ns, p99-ns:  latency from the entry request to the ACK;
+//   - allocs/op:       allocations per grant.
+// It runs for server() and for the ring, with unbuffered request channels and
+// with buffered ones (MAXBUFF slots).
+//
+// Run with:
+//     go test -race ex1.go workload.go fairness.go leaks.go policy.go ex1ring.go admin.go servertest_test.go ex1_test.go
+//     go test -run XXX -bench . ex1.go workload.go fairness.go leaks.go policy.go ex1ring.go admin.go servertest_test.go ex1_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = 4 * MAX // Synthetic vehicles, half per direction
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+// The tests use the buffered entry channels of main (MAXBUFF slots), without
+// which the North priority has no effect, but unbuffered ACK channels, so
+// that every grant can be observed.
+
+const (
+	opNorth = iota // entrataN <- id
+	opSouth        // entrataS <- id
+	opExitN        // uscitaN <- id
+	opExitS        // uscitaS <- id
+)
+
+var opNames = []string{"north entry", "south entry", "north exit", "south exit"}
+
+func TestServer(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name   string
+		policy string // -policy of the server ("": the original strict priority)
+		steps  []step
+	}{
+		{"at most MAX vehicles on the bridge", "", slices.Concat(
+			each(opNorth, 0, MAX-1, true),
+			[]step{
+				{opNorth, MAX, nil},
+				{opExitN, 0, []int{MAX}},
+			},
+			each(opExitN, 1, MAX, false),
+		)},
+		{"opposite directions never share the bridge", "", []step{
+			{opNorth, 0, []int{0}},
+			{opSouth, 1, nil},
+			{opNorth, 2, []int{2}},
+			{opExitN, 0, nil},
+			{opExitN, 2, []int{1}},
+			{opExitS, 1, nil},
+		}},
+		{"a waiting northbound vehicle stops the southbound ones", "", []step{
+			{opSouth, 0, []int{0}},
+			{opSouth, 3, []int{3}},
+			// The guards are evaluated when the server enters select:
+			// the queued request counts from the next event on.
+			{opNorth, 1, nil},
+			{opExitS, 0, nil},
+			{opSouth, 2, nil},
+			{opExitS, 3, []int{1}},
+			{opExitN, 1, []int{2}},
+			{opExitS, 2, nil},
+		}},
+		{"batches of one vehicle alternate the directions", "batch:size=1", []step{
+			{opNorth, 0, []int{0}},
+			{opSouth, 1, nil},
+			// Queued after the select was entered: it is still the
+			// northbound batch.
+			{opNorth, 2, []int{2}},
+			{opNorth, 4, nil},
+			// With the strict priority vehicle 4 would enter here.
+			{opExitN, 0, nil},
+			{opExitN, 2, []int{1}},
+			{opExitS, 1, []int{4}},
+			{opExitN, 4, nil},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				policy = nil
+				if tt.policy != "" {
+					p, err := ParsePolicy(tt.policy, []string{"NORTH", "SOUTH"})
+					if err != nil {
+						t.Fatal(err)
+					}
+					policy = p
+				}
+				initChannels(MAXBUFF)
+				for i := 0; i < MAXPROC; i++ {
+					ACK_N[i] = make(chan int)
+					ACK_S[i] = make(chan int)
+				}
+				go server()
+				s := newScript[int](t)
+				for _, st := range tt.steps {
+					do(s, st)
+					s.expect(st.String(), st.want)
+				}
+				s.finish()
+				checkEmpty(s)
+				termina <- true
+				<-done
+			})
+		})
+	}
+}
+
+func do(s *script[int], st step) {
+	id := st.id
+	switch st.op {
+	case opNorth:
+		s.call(id, ACK_N[id], func() { entrataN <- id })
+	case opSouth:
+		s.call(id, ACK_S[id], func() { entrataS <- id })
+	case opExitN:
+		go func() { uscitaN <- id }()
+	case opExitS:
+		go func() { uscitaS <- id }()
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		policy = nil
+		initChannels(MAXBUFF)
+		for i := 0; i < MAXPROC; i++ {
+			ACK_N[i] = make(chan int)
+			ACK_S[i] = make(chan int)
+		}
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server()
+		s := newScript[int](t)
+		play := func(steps ...step) {
+			t.Helper()
+			for _, st := range steps {
+				do(s, st)
+				s.expect(st.String(), st.want)
+			}
+		}
+
+		// One vehicle at a time: vehicle 2 waits, until the capacity is back
+		s.admin(Admin, "set MAX 1", "MAX: 1, on the bridge: 0", nil)
+		play(step{opNorth, 0, []int{0}}, step{opNorth, 2, nil})
+		s.admin(Admin, "dump", "paused: false; MAX: 1; NORTH: 1 on the bridge, 1 waiting; SOUTH: 0 on the bridge, 0 waiting", nil)
+		s.admin(Admin, "set MAX 5", "MAX: 5, on the bridge: 1", []int{2})
+
+		// Paused: the vehicles on the bridge leave, the new ones wait for resume
+		s.admin(Admin, "pause", "paused: true", nil)
+		play(step{opNorth, 4, nil}, step{opExitN, 0, nil})
+		s.admin(Admin, "resume", "paused: false", []int{4})
+
+		s.admin(Admin, "set MAX 6", "error: MAX cannot be above 5", nil)
+		s.admin(Admin, "set N 1", "error: no capacity N (MAX)", nil)
+		s.admin(Admin, "out bridge 0", "error: nothing to take out of service on the bridge", nil)
+		play(step{opExitN, 2, nil}, step{opExitN, 4, nil})
+		s.finish()
+		checkEmpty(s)
+		termina <- true
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// checkEmpty verifies that the bridge is empty: MAX vehicles heading north
+// enter at once, and once they have left MAX heading south do the same.
+func checkEmpty(s *script[int]) {
+	s.t.Helper()
+	for _, dir := range []struct{ enter, exit int }{{opNorth, opExitN}, {opSouth, opExitS}} {
+		for i := 0; i < MAX; i++ {
+			do(s, step{op: dir.enter, id: MAXPROC - 1 - i})
+		}
+		if got := s.grants(); len(got) != MAX {
+			s.t.Errorf("final state: %d vehicles admitted by %s, want %d", len(got), opNames[dir.enter], MAX)
+		}
+		s.finish()
+		for i := 0; i < MAX; i++ {
+			do(s, step{op: dir.exit, id: MAXPROC - 1 - i})
+		}
+		s.grants()
+	}
+}
+
+// ============================================================
+//                           RING
+// ============================================================
+
+const ringCycles = 10 // crossings of every synthetic vehicle in TestRing
+
+func TestRing(t *testing.T) {
+	silence(t)
+	defer func(lost func() bool) { tokenLost = lost }(tokenLost)
+	tests := []struct {
+		name string
+		lose int64 // every lose-th pass of the token is lost (0: none)
+	}{
+		{"no token lost", 0},
+		{"every third pass lost", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				var passes atomic.Int64
+				tokenLost = func() bool { return tt.lose > 0 && passes.Add(1)%tt.lose == 0 }
+				initChannels(MAXBUFF)
+				startRing()
+
+				// Vehicles on the bridge and crossings, by direction
+				var mu sync.Mutex
+				var on, crossed [2]int
+				var wg sync.WaitGroup
+				for id := range loadClients {
+					wg.Go(func() {
+						dir, entry, exit, ack := N, entrataN, uscitaN, ACK_N[id]
+						if id%2 == S {
+							dir, entry, exit, ack = S, entrataS, uscitaS, ACK_S[id]
+						}
+						for range ringCycles {
+							time.Sleep(time.Duration(rand.Intn(3000)) * time.Millisecond)
+							entry <- id
+							<-ack
+							mu.Lock()
+							on[dir]++
+							crossed[dir]++
+							if on[1-dir] > 0 || on[dir] > MAX {
+								t.Errorf("vehicle %d entered with %v on the bridge", id, on)
+							}
+							mu.Unlock()
+							// Crossings longer than TOKEN_TIMEOUT make the
+							// other group probe while the token is held
+							time.Sleep(time.Duration(rand.Intn(6000)) * time.Millisecond)
+							mu.Lock()
+							on[dir]--
+							mu.Unlock()
+							exit <- id
+						}
+					})
+				}
+				wg.Wait()
+				stopRing()
+
+				events := takeRingEvents()
+				if want := loadClients / 2 * ringCycles; crossed != [2]int{want, want} {
+					t.Errorf("crossings %v, want %d for each direction", crossed, want)
+				}
+				lost, made := events[RING_LOST], events[RING_REGENERATED]
+				t.Logf("%d passes, %d lost, %d probes, %d tokens made", events[RING_PASS], lost, events[RING_PROBE], made)
+				if tt.lose == 0 && (lost != 0 || made != 0 || events[RING_PROBE] == 0) {
+					t.Errorf("%d tokens lost and %d made with %d probes: want 0, 0 and some probes", lost, made, events[RING_PROBE])
+				}
+				// The last token lost may not be made again before the end
+				if tt.lose > 0 && (lost == 0 || made != lost && made != lost-1) {
+					t.Errorf("%d tokens lost, %d made", lost, made)
+				}
+			})
+		})
+	}
+}
+
+// TestRingProbes hands messages to two groups that are not running, and checks
+// what they send on and whether they make a token.
+func TestRingProbes(t *testing.T) {
+	silence(t)
+	defer takeRingEvents()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	newGroups := func() (north, south *group) {
+		north = &group{dir: N, name: "NORTH", gen: 1, next: make(chan ringMsg, RING_INBOX)}
+		south = &group{dir: S, name: "SOUTH", gen: 1, next: make(chan ringMsg, RING_INBOX)}
+		return north, south
+	}
+	// deliver hands m to g and returns the message g sends on, if any.
+	deliver := func(g *group, m ringMsg) (ringMsg, bool) {
+		g.receive(m, timer)
+		select {
+		case out := <-g.next:
+			return out, true
+		default:
+			return ringMsg{}, false
+		}
+	}
+	probe := func(origin int) ringMsg { return ringMsg{kind: RING_PROBE_MSG, origin: origin} }
+
+	t.Run("the holder marks the probe", func(t *testing.T) {
+		north, _ := newGroups()
+		north.holding = true
+		if out, ok := deliver(north, probe(S)); !ok || !out.seen {
+			t.Errorf("probe sent on: %v %+v, want seen", ok, out)
+		}
+	})
+	t.Run("a lost token is made again", func(t *testing.T) {
+		north, south := newGroups()
+		north.probing = true
+		out, ok := deliver(south, probe(N))
+		if !ok || out.seen {
+			t.Fatalf("probe sent on: %v %+v, want unseen", ok, out)
+		}
+		if _, ok := deliver(north, out); ok || !north.holding || north.gen != 2 {
+			t.Errorf("holding %v, generation %d, sent on %v: want a token of generation 2", north.holding, north.gen, ok)
+		}
+	})
+	t.Run("a token on its way is not lost", func(t *testing.T) {
+		north, _ := newGroups()
+		north.probing = true
+		deliver(north, ringMsg{kind: RING_TOKEN, gen: 1})
+		north.holding = false // passed on meanwhile
+		if _, ok := deliver(north, probe(N)); ok || north.holding || north.gen != 1 {
+			t.Errorf("holding %v, generation %d: want no token made", north.holding, north.gen)
+		}
+	})
+	t.Run("two probing groups make one token", func(t *testing.T) {
+		north, south := newGroups()
+		north.probing, south.probing = true, true
+		toNorth, _ := deliver(south, probe(N))
+		toSouth, _ := deliver(north, probe(S))
+		if south.probing || !toSouth.seen || toNorth.seen {
+			t.Fatalf("SOUTH probing %v, its probe seen %v, the NORTH one %v: want false, true, false", south.probing, toSouth.seen, toNorth.seen)
+		}
+		deliver(north, toNorth)
+		deliver(south, toSouth)
+		if !north.holding || south.holding {
+			t.Errorf("NORTH holding %v, SOUTH %v: want only NORTH", north.holding, south.holding)
+		}
+	})
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+func BenchmarkServer(b *testing.B) {
+	silence(b)
+	for _, bridge := range allBridges() {
+		for _, m := range bufferModes(MAXBUFF) {
+			b.Run(bridge.name+"/"+m.name, func(b *testing.B) {
+				initChannels(m.size)
+				bridge.start()
+				measure(b, loadClients, func(id int) time.Duration {
+					t := time.Now()
+					if id%2 == N {
+						entrataN <- id
+						<-ACK_N[id]
+						d := time.Since(t)
+						uscitaN <- id
+						return d
+					}
+					entrataS <- id
+					<-ACK_S[id]
+					d := time.Since(t)
+					uscitaS <- id
+					return d
+				})
+				bridge.stop()
+				takeRingEvents()
+			})
+		}
+	}
+}
@@ -0,0 +1,404 @@
+// -----------------------------------------------------------------------------------
+// TOKEN RING: THE ONE-WAY BRIDGE WITHOUT server()
+//
+// server() sees both ends of the bridge. Here there is no server: the vehicles
+// of each direction are served by their group, NORTH (entrataN, uscitaN,
+// ACK_N) and SOUTH (entrataS, uscitaS, ACK_S), and the groups form a ring,
+// NORTH -> SOUTH -> NORTH, along which they pass a token. veicolo() does not
+// change: it sends to the same channels, and the group of its direction
+// answers it.
+//
+// Only the group holding the token lets vehicles on the bridge, which is then
+// never crossed in both directions:
+//   - with the token a group lets on the bridge up to MAX vehicles, the ones
+//     waiting and the ones arriving while the first are crossing;
+//   - once MAX vehicles have entered, or nobody else waits, it waits for its
+//     vehicles to leave the bridge and passes the token to the next group;
+//   - a group with nobody waiting keeps the token for TOKEN_HOLD, for vehicles
+//     that arrive, and then passes it, so an idle ring passes the token back
+//     and forth.
+// The directions take turns with batches of at most MAX vehicles, whoever is
+// waiting: there is no priority of the North (and -policy does not apply).
+//
+// A pass of the token can be lost (-tokenloss p loses each pass with
+// probability p), and the ring would stop. A group that has not seen the token
+// for TOKEN_TIMEOUT suspects that it is lost and sends a PROBE around the ring:
+//   - the group holding the token marks the probe as seen;
+//   - the messages of the ring keep their order, so if the token arrives at
+//     the group after its probe left, it was not lost; otherwise the probe
+//     comes back unseen only if no group held the token while it went around
+//     and no token was on its way to the group: the token is lost, and the
+//     group makes a new one (one more generation);
+//   - if two groups probe at the same time the one with the lower id wins: a
+//     probing group marks the probes of the others as seen and drops its own
+//     probe when one of a lower group passes.
+// Vehicles on the bridge with a lost token are not a problem: the group that
+// lost it passed it once they had left.
+//
+// Token passes, lost tokens, probes and regenerated tokens are counted. With
+// -bridge compare the vehicles of the same workload cross the bridge of
+// server() and then the one of the ring, and the throughput and the waits of
+// the two runs are compared.
+//
+// Run with:
+//     go run ex1.go workload.go fairness.go leaks.go policy.go ex1ring.go admin.go -bridge ring
+//     go run ex1.go workload.go fairness.go leaks.go policy.go ex1ring.go admin.go -bridge compare -tokenloss 0.2
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+var bridgeFlag = flag.String("bridge", "server", "who runs the bridge: `server` (server()), ring (a token ring of the two directions, see ex1ring.go) or compare (both, one after the other)")
+var tokenLossFlag = flag.Float64("tokenloss", 0, "probability that a pass of the token of the ring is lost")
+
+// TOKEN_HOLD is how long a group with nobody waiting keeps the token.
+const TOKEN_HOLD = 100 * time.Millisecond
+
+// TOKEN_TIMEOUT is how long a group waits for the token before it suspects
+// that it is lost: longer than a batch of vehicles usually holds it.
+const TOKEN_TIMEOUT = 5 * time.Second
+
+// RING_INBOX is the buffer of the inbox of a group: the token and a probe of
+// every group fit, so a group never blocks sending on the ring.
+const RING_INBOX = 3
+
+// tokenLost decides whether a pass of the token is lost; the tests replace it.
+var tokenLost = func() bool { return rand.Float64() < *tokenLossFlag }
+
+// ============================================================
+//                          EVENTS
+// ============================================================
+
+// Events of the ring.
+const (
+	RING_PASS        = iota // the token passed to the next group
+	RING_LOST               // a pass of the token lost
+	RING_PROBE              // a probe sent
+	RING_REGENERATED        // a new token made
+	RING_EVENTS
+)
+
+var ringEventNames = [RING_EVENTS]string{"token passes", "tokens lost", "probes", "tokens regenerated"}
+
+// ringEvents counts the events of the current run.
+var ringEvents [RING_EVENTS]atomic.Int64
+
+// count counts an event.
+func count(event int) {
+	ringEvents[event].Add(1)
+}
+
+// takeRingEvents returns the events counted and resets them.
+func takeRingEvents() (counts [RING_EVENTS]int64) {
+	for event := range counts {
+		counts[event] = ringEvents[event].Swap(0)
+	}
+	return counts
+}
+
+// ============================================================
+//                          BRIDGES
+// ============================================================
+
+// bridgeServer runs the bridge: server() or the ring.
+type bridgeServer struct {
+	name  string
+	start func() // starts its goroutines, once the channels are made
+	stop  func() // terminates them, once the vehicles are done
+
+	// Results of the run, set by finish
+	began    time.Time
+	elapsed  time.Duration
+	fairness *Fairness
+	events   [RING_EVENTS]int64
+}
+
+// Bridges returns the servers chosen with -bridge, run one after the other.
+func Bridges() []*bridgeServer {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	bridges := allBridges()
+	switch *bridgeFlag {
+	case "server":
+		return bridges[:1]
+	case "ring":
+		return bridges[1:]
+	case "compare":
+		return bridges
+	}
+	fmt.Fprintf(os.Stderr, "ring: -bridge %q: want server, ring or compare\n", *bridgeFlag)
+	os.Exit(2)
+	return nil
+}
+
+// allBridges returns server() and the ring.
+func allBridges() []*bridgeServer {
+	return []*bridgeServer{
+		{name: "server", start: func() { go server() }, stop: func() {
+			termina <- true
+			<-done
+		}},
+		{name: "ring", start: startRing, stop: stopRing},
+	}
+}
+
+// run starts the bridge, with the time of the run starting now.
+func (b *bridgeServer) run() {
+	b.began = time.Now()
+	b.start()
+}
+
+// finish stops the bridge, once the vehicles are done, and keeps the results of
+// the run.
+func (b *bridgeServer) finish(f *Fairness) {
+	b.elapsed = time.Since(b.began)
+	b.stop()
+	b.fairness = f
+	b.events = takeRingEvents()
+}
+
+// waitStats returns the waits of f: the mean by class, the maximum and how many
+// were served.
+func waitStats(f *Fairness) (means []time.Duration, longest time.Duration, served int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, waits := range f.waits {
+		var sum time.Duration
+		for _, d := range waits {
+			sum += d
+		}
+		if len(waits) > 0 {
+			sum /= time.Duration(len(waits))
+			longest = max(longest, slices.Max(waits))
+		}
+		means = append(means, sum)
+		served += len(waits)
+	}
+	return means, longest, served
+}
+
+// ReportBridges writes the throughput and the waits of the runs, with the
+// events of the ring.
+func ReportBridges(w io.Writer, bridges []*bridgeServer) {
+	fmt.Fprintf(w, "\nBRIDGES   %-10s", "")
+	for _, b := range bridges {
+		fmt.Fprintf(w, " %10s", b.name)
+	}
+	fmt.Fprintln(w)
+	row := func(name string, value func(b *bridgeServer) string) {
+		fmt.Fprintf(w, "  %-18s", name)
+		for _, b := range bridges {
+			fmt.Fprintf(w, " %10s", value(b))
+		}
+		fmt.Fprintln(w)
+	}
+	stats := func(b *bridgeServer) ([]time.Duration, time.Duration, int) {
+		if b.fairness == nil {
+			return []time.Duration{0, 0}, 0, 0
+		}
+		return waitStats(b.fairness)
+	}
+	row("vehicles", func(b *bridgeServer) string {
+		_, _, served := stats(b)
+		return fmt.Sprint(served)
+	})
+	row("elapsed", func(b *bridgeServer) string { return fmt.Sprint(b.elapsed.Round(time.Millisecond)) })
+	row("vehicles/s", func(b *bridgeServer) string {
+		_, _, served := stats(b)
+		if b.elapsed == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.3f", float64(served)/b.elapsed.Seconds())
+	})
+	for dir, name := range []string{"NORTH", "SOUTH"} {
+		row("mean wait "+name, func(b *bridgeServer) string {
+			means, _, _ := stats(b)
+			return fmt.Sprint(means[dir].Round(time.Millisecond))
+		})
+	}
+	row("max wait", func(b *bridgeServer) string {
+		_, longest, _ := stats(b)
+		return fmt.Sprint(longest.Round(time.Millisecond))
+	})
+	row("Jain's index", func(b *bridgeServer) string {
+		means, _, _ := stats(b)
+		var x []float64
+		for _, m := range means {
+			x = append(x, m.Seconds())
+		}
+		return fmt.Sprintf("%.3f", Jain(x))
+	})
+	for event, name := range ringEventNames {
+		row(name, func(b *bridgeServer) string { return fmt.Sprint(b.events[event]) })
+	}
+}
+
+// ============================================================
+//                           RING
+// ============================================================
+
+// Kinds of messages on the ring.
+const (
+	RING_TOKEN = iota
+	RING_PROBE_MSG
+)
+
+// ringMsg is a message on the ring.
+type ringMsg struct {
+	kind   int  // RING_TOKEN or RING_PROBE_MSG
+	gen    int  // token: its generation, 1 for the first token
+	origin int  // probe: the group that sent it
+	seen   bool // probe: a group held the token, or a lower one is probing
+}
+
+// group serves the vehicles of one direction.
+type group struct {
+	dir   int
+	name  string
+	entry chan int           // entrataN or entrataS
+	exit  chan int           // uscitaN or uscitaS
+	ack   *[MAXPROC]chan int // ACK_N or ACK_S
+	inbox chan ringMsg       // messages from the previous group
+	next  chan ringMsg       // inbox of the next group
+
+	holding  bool // the group has the token
+	gen      int  // generation of the last token seen
+	entered  int  // vehicles let on the bridge with the token
+	onBridge int  // vehicles of the group on the bridge
+	probing  bool // a probe of the group is going around and no token came
+}
+
+// The groups of the current run, by direction
+var groups [2]*group
+
+// startRing starts the groups, the NORTH one with the token.
+func startRing() {
+	groups = [2]*group{
+		{dir: N, name: "NORTH", entry: entrataN, exit: uscitaN, ack: &ACK_N, inbox: make(chan ringMsg, RING_INBOX)},
+		{dir: S, name: "SOUTH", entry: entrataS, exit: uscitaS, ack: &ACK_S, inbox: make(chan ringMsg, RING_INBOX)},
+	}
+	for i, g := range groups {
+		g.next = groups[(i+1)%len(groups)].inbox
+	}
+	groups[N].holding = true
+	groups[N].gen = 1
+	for _, g := range groups {
+		go g.run()
+	}
+}
+
+// stopRing terminates the groups.
+func stopRing() {
+	for range groups {
+		termina <- true
+		<-done
+	}
+}
+
+// run serves the vehicles of the group and the messages of the ring.
+func (g *group) run() {
+	// Fires when the group has waited for the token too long (not holding it),
+	// or has held it long enough with nobody waiting
+	timer := time.NewTimer(TOKEN_HOLD)
+	defer timer.Stop()
+	if !g.holding {
+		timer.Reset(TOKEN_TIMEOUT)
+	}
+	for {
+		// Done with the token: MAX vehicles entered and left, or nobody waits
+		if g.holding && g.onBridge == 0 && (g.entered == MAX || g.entered > 0 && len(g.entry) == 0) {
+			g.pass(timer)
+		}
+		select {
+		case x := <-when(g.holding && g.entered < MAX, g.entry):
+			g.entered++
+			g.onBridge++
+			g.ack[x] <- 1
+
+		case <-g.exit:
+			g.onBridge--
+
+		case m := <-g.inbox:
+			g.receive(m, timer)
+
+		case <-timer.C:
+			switch {
+			case g.holding && g.entered == 0:
+				// Nobody came
+				g.pass(timer)
+			case g.holding:
+				// Its vehicles are on the bridge: it passes the token when they leave
+			case !g.probing:
+				fmt.Printf("[ring %s] no token for %v: probing\n", g.name, TOKEN_TIMEOUT)
+				g.probing = true
+				count(RING_PROBE)
+				g.next <- ringMsg{kind: RING_PROBE_MSG, origin: g.dir}
+			}
+
+		case <-termina:
+			fmt.Printf("[ring %s] END!!!\n", g.name)
+			done <- true
+			return
+		}
+	}
+}
+
+// pass passes the token to the next group, unless the pass is lost.
+func (g *group) pass(timer *time.Timer) {
+	g.holding = false
+	g.entered = 0
+	timer.Reset(TOKEN_TIMEOUT)
+	count(RING_PASS)
+	if tokenLost() {
+		fmt.Printf("[ring %s] token #%d lost\n", g.name, g.gen)
+		count(RING_LOST)
+		return
+	}
+	g.next <- ringMsg{kind: RING_TOKEN, gen: g.gen}
+}
+
+// receive handles a message of the previous group.
+func (g *group) receive(m ringMsg, timer *time.Timer) {
+	switch {
+	case m.kind == RING_TOKEN:
+		g.holding = true
+		g.gen = m.gen
+		g.probing = false
+		timer.Reset(TOKEN_HOLD)
+
+	case m.origin != g.dir:
+		// The probe of another group goes on
+		if g.holding || g.probing && g.dir < m.origin {
+			m.seen = true
+		} else if g.probing {
+			g.probing = false
+			timer.Reset(TOKEN_TIMEOUT)
+		}
+		g.next <- m
+
+	case g.probing && !m.seen:
+		// Its probe came back, and nobody has the token
+		g.probing = false
+		g.holding = true
+		g.gen++
+		fmt.Printf("[ring %s] token lost: token #%d made\n", g.name, g.gen)
+		count(RING_REGENERATED)
+		timer.Reset(TOKEN_HOLD)
+
+	case !g.holding:
+		// Its probe came back, but the token is around
+		g.probing = false
+		timer.Reset(TOKEN_TIMEOUT)
+	}
+}
@@ -0,0 +1 @@
+../../policy/policy.go
\ No newline at end of file
@@ -29,6 +29,8 @@
 //
 // Below is the code with inline commentary.
 //
+// Run with:
+//     go run sol4.1.go workload.go leaks.go admin.go
 // -----------------------------------------------------------------------------------
 
 package main
@@ -36,6 +38,7 @@ package main
 import (
     "fmt"
     "math/rand"
+    "os"
     "time"
 )
 
@@ -61,24 +64,40 @@ const AUT int = 1 // car
 type msg_out struct{ tipo, id int }
 
 // done channel signals a goroutine is finished (client or server)
-var done = make(chan bool)
+var done chan bool
 
 // termina channel signals the server to shut down once everyone is finished
-var termina = make(chan bool)
+var termina chan bool
 
 // Channels for entry from N or S, separated by type (pedestrian or car)
-var entrataN_A = make(chan int, MAXBUFF) // North, car
-var entrataN_P = make(chan int, MAXBUFF) // North, pedestrian
-var entrataS_A = make(chan int, MAXBUFF) // South, car
-var entrataS_P = make(chan int, MAXBUFF) // South, pedestrian
+var entrataN_A chan int // North, car
+var entrataN_P chan int // North, pedestrian
+var entrataS_A chan int // South, car
+var entrataS_P chan int // South, pedestrian
 
 // Channels for exiting from North or South (send a msg_out object)
-var uscitaN = make(chan msg_out)
-var uscitaS = make(chan msg_out)
+var uscitaN chan msg_out
+var uscitaS chan msg_out
 
 // Each user has an ACK channel to synchronize entry. ACK[i] is for user i.
 var ACK [MAXPROC]chan int
 
+// initChannels creates all the channels above.
+// 'size' is the buffer of the four entry channels (MAXBUFF in main).
+func initChannels(size int) {
+    done = make(chan bool)
+    termina = make(chan bool)
+    entrataN_A = make(chan int, size)
+    entrataN_P = make(chan int, size)
+    entrataS_A = make(chan int, size)
+    entrataS_P = make(chan int, size)
+    uscitaN = make(chan msg_out)
+    uscitaS = make(chan msg_out)
+    for i := 0; i < MAXPROC; i++ {
+        ACK[i] = make(chan int, MAXBUFF)
+    }
+}
+
 // Helper function for conditional select statement: if b is false, return nil
 // so that case is effectively disabled.
 func when(b bool, c chan int) chan int {
@@ -89,26 +108,20 @@ func when(b bool, c chan int) chan int {
 }
 
 // GOROUTINE: utente (user)
-// Each user has an ID, a direction (N or S), and randomly becomes either a
-// pedestrian (PED) or a car (AUT). Once assigned a direction and type, it:
-//  1) Waits a random initialization time.
+// Each user has an ID, a direction (N or S), and a type chosen by the workload:
+// pedestrian (PED) or car (AUT). Started by main at its arrival time, it:
+//  1) Announces its arrival.
 //  2) Sends a request to the appropriate channel (e.g., entrataN_P for
 //     a pedestrian from North).
 //  3) Waits for ACK[myid] to get permission from the server.
 //  4) Sleeps a random time to simulate being on the bridge.
 //  5) Sends a msg_out to the appropriate exit channel (uscitaN or uscitaS).
 //  6) Signals done.
-func utente(myid int, dir int) {
+func utente(myid int, dir int, tipo int) {
     var m_out msg_out
     var tt int
 
-    // Randomly decide if this user is a pedestrian or a car
-    tipo := rand.Intn(2) // 0 => PED, 1 => AUT
-
-    // Prepare a random initialization sleep
-    tt = rand.Intn(5) + 1
-    fmt.Printf("Initialization user %d direction %d type %d, waiting %d seconds\n", myid, dir, tipo, tt)
-    time.Sleep(time.Duration(tt) * time.Second)
+    fmt.Printf("User %d direction %d type %d arrived\n", myid, dir, tipo)
 
     // Prepare the exit message
     m_out.tipo = tipo
@@ -183,26 +196,31 @@ func server() {
     // tot tracks the total usage of the bridge (sum in "units" - each ped = 1, car = 10).
     var tot int
 
+    // The capacity and the pause, changed by the admin commands (see admin.go):
+    // lowered below tot, nobody enters until enough units have left
+    maxPonte := MAX
+    paused := false
+
     for {
         select {
 
         // 1) Pedestrian from South can enter if capacity is not exceeded (tot < MAX) 
         //    and there are no cars from North (contN[AUT] == 0).
-        case x := <-when((tot < MAX) && (contN[AUT] == 0), entrataS_P):
+        case x := <-when(!paused && (tot < maxPonte) && (contN[AUT] == 0), entrataS_P):
             contS[PED]++
             tot++
             ACK[x] <- 1
 
         // 2) Pedestrian from North can enter if tot < MAX, no cars from South, 
         //    and there are no waiting pedestrians from South (len(entrataS_P) == 0).
-        case x := <-when((tot < MAX) && (contS[AUT] == 0) && (len(entrataS_P) == 0), entrataN_P):
+        case x := <-when(!paused && (tot < maxPonte) && (contS[AUT] == 0) && (len(entrataS_P) == 0), entrataN_P):
             contN[PED]++
             tot++
             ACK[x] <- 1
 
         // 3) Car from South can enter if tot + 10 <= MAX, no one from North is on the bridge, 
         //    and no pedestrians are waiting to enter (entrataN_P + entrataS_P == 0).
-        case x := <-when((tot+10 <= MAX) && (contN[PED]+contN[AUT] == 0) && 
+        case x := <-when(!paused && (tot+10 <= maxPonte) && (contN[PED]+contN[AUT] == 0) && 
                          (len(entrataN_P)+len(entrataS_P) == 0), entrataS_A):
             contS[AUT]++
             tot += 10
@@ -210,7 +228,7 @@ func server() {
 
         // 4) Car from North can enter if tot + 10 <= MAX, no one from South is on the bridge, 
         //    and no pedestrians from either side are waiting.
-        case x := <-when((tot+10 <= MAX) && (contS[PED]+contS[AUT] == 0) && 
+        case x := <-when(!paused && (tot+10 <= maxPonte) && (contS[PED]+contS[AUT] == 0) && 
                          (len(entrataN_P)+len(entrataS_P)+len(entrataS_A) == 0), entrataN_A):
             contN[AUT]++
             tot += 10
@@ -234,7 +252,29 @@ func server() {
                 tot -= 10
             }
 
-        // 7) Termination signal
+        // 7) An admin command
+        case cmd := <-adminCommands:
+            switch cmd.Op {
+            case ADMIN_PAUSE, ADMIN_RESUME:
+                paused = cmd.Op == ADMIN_PAUSE
+                fmt.Printf("[bridge] admin: %s.\n", cmd.Op)
+                cmd.Reply("paused: %v", paused)
+            case ADMIN_SET:
+                if cmd.Name != "MAX" {
+                    cmd.Fail("no capacity %s (MAX)", cmd.Name)
+                } else if n, err := cmd.Capacity(MAX); err == nil {
+                    maxPonte = n
+                    fmt.Printf("[bridge] admin: at most %d units.\n", maxPonte)
+                    cmd.Reply("MAX: %d, units used: %d", maxPonte, tot)
+                }
+            case ADMIN_OUT, ADMIN_IN:
+                cmd.Fail("nothing to take out of service on the bridge")
+            default: // ADMIN_DUMP
+                cmd.Reply("paused: %v; MAX: %d, units used: %d; NORTH: %d pedestrians, %d cars; SOUTH: %d pedestrians, %d cars",
+                    paused, maxPonte, tot, contN[PED], contN[AUT], contS[PED], contS[AUT])
+            }
+
+        // 8) Termination signal
         case <-termina:
             fmt.Println("BRIDGE IS CLOSING!")
             done <- true
@@ -252,24 +292,23 @@ func main() {
     fmt.Printf("\nHow many users from the SOUTH (max %d)? ", MAXPROC/2)
     fmt.Scanf("%d", &VS)
 
-    // Initialize ACK channels
-    for i := 0; i < VN+VS; i++ {
-        ACK[i] = make(chan int, MAXBUFF)
-    }
+    // Initialize the channels (including the ACK channel of each user)
+    initChannels(MAXBUFF)
 
     // Seed random
     rand.Seed(time.Now().Unix())
+    // Admin commands for the server, with -admin (see admin.go)
+    stopAdmin := StartAdminFromFlags()
     // Start the server goroutine
     go server()
 
-    // Create user goroutines for the South side first
-    for i := 0; i < VS; i++ {
-        go utente(i, S)
-    }
-    // Then create user goroutines for the North side
-    for j := VS; j < VN+VS; j++ {
-        go utente(j, N)
-    }
+    // Create user goroutines for the South side (ids 0..VS-1) and for the North
+    // side (ids VS..VN+VS-1) at the arrival times of the workload (see workload.go).
+    // By default each user arrives after 1-5 seconds and is a pedestrian or a car
+    // with the same probability
+    workload := WorkloadFromFlags([]string{"PED", "AUT"}, "spread:max=5", "PED=1,AUT=1")
+    workload.Spawn(VS, func(id, tipo int) { utente(id, S, tipo) })
+    workload.Spawn(VN, func(id, tipo int) { utente(VS+id, N, tipo) })
 
     // Wait for all users to finish
     for i := 0; i < VN+VS; i++ {
@@ -279,5 +318,11 @@ func main() {
     // Tell the server to terminate
     termina <- true
     <-done
+    stopAdmin()
     fmt.Printf("\nALL DONE\n")
+
+    if err := CheckLeaks(); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
 }
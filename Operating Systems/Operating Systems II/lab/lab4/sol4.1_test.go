@@ -0,0 +1,229 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR server() (BRIDGE FOR PEDESTRIANS AND CARS)
+//
+// The tests feed server() scripted sequences of entries and exits under a
+// virtual clock, and check which users are admitted, in which order, and that
+// the bridge is empty again at the end. TestAdmin sends the server the commands
+// of admin.go.
+//
+// Synthetic users repeat the cycle of utente() without any sleep. Direction and
+// type are fixed per user (id % 4): pedestrian from North, car from North,
+// pedestrian from South, car from South. Each one requests entry on the
+// matching entrata channel, waits for ACK[id], then leaves on uscitaN/uscitaS.
+// With buffered channels cars also wait while pedestrians are queued.
+//
+// The benchmark reports:
+//   - grants/s:        users admitted on the bridge per second;
+//   - p50-ns, p99-ns:  latency from the entry request to the ACK;
+//   - allocs/op:       allocations per grant.
+// It runs twice: with unbuffered request channels and with buffered ones
+// (MAXBUFF slots).
+//
+// Run with:
+//     go test -race sol4.1.go workload.go leaks.go admin.go servertest_test.go sol4.1_test.go
+//     go test -run XXX -bench . sol4.1.go workload.go leaks.go admin.go servertest_test.go sol4.1_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = 20 // Synthetic users (ids 0..19), 5 per direction and type
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+// The tests use the buffered entry channels of main (MAXBUFF slots), without
+// which the priorities have no effect, but unbuffered ACK channels, so that
+// every grant can be observed.
+
+const (
+	opPedN = iota // entrataN_P <- id
+	opCarN        // entrataN_A <- id
+	opPedS        // entrataS_P <- id
+	opCarS        // entrataS_A <- id
+	opExit        // uscitaN/uscitaS <- msg_out of id
+)
+
+var opNames = []string{"north pedestrian", "north car", "south pedestrian", "south car", "exit"}
+
+// bridge runs the steps of a test, remembering who is on the bridge.
+type bridge struct {
+	*script[int]
+	on map[int]int // op of every user on the bridge
+}
+
+func (b *bridge) do(st step) {
+	id := st.id
+	if st.op == opExit {
+		op := b.on[id]
+		delete(b.on, id)
+		m := msg_out{tipo: op % 2, id: id}
+		exit := []chan msg_out{uscitaN, uscitaS}[op/2]
+		go func() { exit <- m }()
+		return
+	}
+	b.on[id] = st.op
+	entry := []chan int{entrataN_P, entrataN_A, entrataS_P, entrataS_A}[st.op]
+	b.call(id, ACK[id], func() { entry <- id })
+}
+
+func TestServer(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"a car counts as 10 people", slices.Concat(
+			each(opCarS, 0, 2, true),
+			[]step{
+				{opCarS, 3, nil},
+				{opPedS, 4, []int{4}},
+				{opExit, 0, []int{3}},
+				{opExit, 4, nil},
+			},
+			each(opExit, 1, 3, false),
+		)},
+		{"pedestrians never meet a car going the other way", []step{
+			{opCarN, 0, []int{0}},
+			{opPedN, 1, []int{1}},
+			{opPedS, 2, nil},
+			{opExit, 0, []int{2}},
+			{opExit, 1, nil},
+			{opExit, 2, nil},
+		}},
+		{"south pedestrians, then north pedestrians, then south cars, then north cars", []step{
+			{opCarN, 0, []int{0}},
+			{opCarN, 5, []int{5}},
+			{opPedS, 1, nil},
+			// The guards are evaluated when the server enters select:
+			// the queued pedestrian counts from the next event on.
+			{opExit, 5, nil},
+			{opPedN, 2, nil},
+			{opCarS, 3, nil},
+			{opCarN, 4, nil},
+			{opExit, 0, []int{1, 2}},
+			{opExit, 1, nil},
+			{opExit, 2, []int{3}},
+			{opExit, 3, []int{4}},
+			{opExit, 4, nil},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				initChannels(MAXBUFF)
+				for i := range ACK {
+					ACK[i] = make(chan int)
+				}
+				go server()
+				b := &bridge{newScript[int](t), map[int]int{}}
+				for _, st := range tt.steps {
+					b.do(st)
+					b.expect(st.String(), st.want)
+				}
+				b.finish()
+				b.checkEmpty()
+				termina <- true
+				<-done
+			})
+		})
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		initChannels(MAXBUFF)
+		for i := range ACK {
+			ACK[i] = make(chan int)
+		}
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server()
+		b := &bridge{newScript[int](t), map[int]int{}}
+		play := func(steps ...step) {
+			t.Helper()
+			for _, st := range steps {
+				b.do(st)
+				b.expect(st.String(), st.want)
+			}
+		}
+
+		// Room for one car: the pedestrian waits, until the capacity is back
+		b.admin(Admin, "set MAX 10", "MAX: 10, units used: 0", nil)
+		play(step{opCarS, 0, []int{0}}, step{opPedS, 1, nil})
+		b.admin(Admin, "dump", "paused: false; MAX: 10, units used: 10; NORTH: 0 pedestrians, 0 cars; SOUTH: 0 pedestrians, 1 cars", nil)
+		b.admin(Admin, "set MAX 35", "MAX: 35, units used: 10", []int{1})
+
+		// Paused: the users on the bridge leave, the new ones wait for resume
+		b.admin(Admin, "pause", "paused: true", nil)
+		play(step{opPedS, 2, nil}, step{opExit, 0, nil})
+		b.admin(Admin, "resume", "paused: false", []int{2})
+
+		b.admin(Admin, "set MAX 36", "error: MAX cannot be above 35", nil)
+		b.admin(Admin, "set AUT 1", "error: no capacity AUT (MAX)", nil)
+		b.admin(Admin, "out bridge 0", "error: nothing to take out of service on the bridge", nil)
+		play(step{opExit, 1, nil}, step{opExit, 2, nil})
+		b.finish()
+		b.checkEmpty()
+		termina <- true
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// checkEmpty verifies that the bridge is empty: MAX pedestrians from south
+// enter at once, and once they have left MAX/10 cars from north do the same.
+func (b *bridge) checkEmpty() {
+	b.t.Helper()
+	for _, fill := range []struct{ op, n int }{{opPedS, MAX}, {opCarN, MAX / 10}} {
+		for i := 0; i < fill.n; i++ {
+			b.do(step{op: fill.op, id: MAXPROC - 1 - i})
+		}
+		if got := b.grants(); len(got) != fill.n {
+			b.t.Errorf("final state: %d users admitted as %s, want %d", len(got), opNames[fill.op], fill.n)
+		}
+		b.finish()
+		for i := 0; i < fill.n; i++ {
+			b.do(step{op: opExit, id: MAXPROC - 1 - i})
+		}
+		b.grants()
+	}
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+func BenchmarkServer(b *testing.B) {
+	silence(b)
+	for _, m := range bufferModes(MAXBUFF) {
+		b.Run(m.name, func(b *testing.B) {
+			initChannels(m.size)
+			go server()
+			entry := [2][2]chan int{{entrataN_P, entrataN_A}, {entrataS_P, entrataS_A}}
+			exit := [2]chan msg_out{uscitaN, uscitaS}
+			measure(b, loadClients, func(id int) time.Duration {
+				tipo, dir := id%2, id/2%2
+				t := time.Now()
+				entry[dir][tipo] <- id
+				<-ACK[id]
+				d := time.Since(t)
+				exit[dir] <- msg_out{tipo: tipo, id: id}
+				return d
+			})
+			termina <- true
+			<-done
+		})
+	}
+}
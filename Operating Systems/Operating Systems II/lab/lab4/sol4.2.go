@@ -40,6 +40,8 @@
 // parts are answered with -1 (termination). After all conveyors and robots exit, 
 // 'main' tells the deposit to terminate as well.
 //
+// Run with:
+//     go run sol4.2.go workload.go fairness.go metrics.go leaks.go admin.go
 // -----------------------------------------------------------------------------------
 
 package main
@@ -47,6 +49,7 @@ package main
 import (
     "fmt"
     "math/rand"
+    "os"
     "time"
 )
 
@@ -75,29 +78,60 @@ const TOT = 10
 var tipoRobot = [2]string{"Modello A", "Modello B"}
 var tipoNastro = [4]string{"pneumatico A", "pneumatico B", "cerchio A", "cerchio B"}
 
+// Buffer of the request channels (prelievo* and consegna*)
+const MAXBUFF = 100
+
+// Waits of the robots for their parts: the metrics of the run count the parts
+// picked per second (see workload.go)
+var fairness *Fairness
+
 // Channels for termination and synchronization
-var done = make(chan bool)
-var terminaDeposito = make(chan bool)
+var done chan bool
+var terminaDeposito chan bool
 
 // Channels for ROBOTS to pick up parts from the deposit
-var prelievoPA = make(chan int, 100)
-var prelievoPB = make(chan int, 100)
-var prelievoCA = make(chan int, 100)
-var prelievoCB = make(chan int, 100)
+var prelievoPA chan int
+var prelievoPB chan int
+var prelievoCA chan int
+var prelievoCB chan int
 
 // Channels for CONVEYOR BELTS to deliver parts to the deposit
-var consegnaPA = make(chan int, 100)
-var consegnaPB = make(chan int, 100)
-var consegnaCA = make(chan int, 100)
-var consegnaCB = make(chan int, 100)
+var consegnaPA chan int
+var consegnaPB chan int
+var consegnaCA chan int
+var consegnaCB chan int
 
 // Acknowledgment channels
-var ack_robotA = make(chan int)   // ack for robot A
-var ack_robotB = make(chan int)   // ack for robot B
-var ack_nastroPA = make(chan int) // ack for conveyor delivering PA
-var ack_nastroPB = make(chan int) // ack for conveyor delivering PB
-var ack_nastroCA = make(chan int) // ack for conveyor delivering CA
-var ack_nastroCB = make(chan int) // ack for conveyor delivering CB
+var ack_robotA chan int   // ack for robot A
+var ack_robotB chan int   // ack for robot B
+var ack_nastroPA chan int // ack for conveyor delivering PA
+var ack_nastroPB chan int // ack for conveyor delivering PB
+var ack_nastroCA chan int // ack for conveyor delivering CA
+var ack_nastroCB chan int // ack for conveyor delivering CB
+
+// initChannels creates all the channels above.
+// 'size' is the buffer of the request channels (MAXBUFF in main).
+func initChannels(size int) {
+    done = make(chan bool)
+    terminaDeposito = make(chan bool)
+
+    prelievoPA = make(chan int, size)
+    prelievoPB = make(chan int, size)
+    prelievoCA = make(chan int, size)
+    prelievoCB = make(chan int, size)
+
+    consegnaPA = make(chan int, size)
+    consegnaPB = make(chan int, size)
+    consegnaCA = make(chan int, size)
+    consegnaCB = make(chan int, size)
+
+    ack_robotA = make(chan int)
+    ack_robotB = make(chan int)
+    ack_nastroPA = make(chan int)
+    ack_nastroPB = make(chan int)
+    ack_nastroCA = make(chan int)
+    ack_nastroCB = make(chan int)
+}
 
 // Helper function for conditional case in select
 func when(b bool, c chan int) chan int {
@@ -124,6 +158,7 @@ func Robot(tipo int) {
         for i := 0; i < 4; i++ {
             if tipo == RobotA {
                 // 1) Pick up rim CA
+                ticket := fairness.Request(tipo, tipo)
                 prelievoCA <- tipo
                 ackVal = <-ack_robotA
                 if ackVal == -1 {
@@ -131,11 +166,13 @@ func Robot(tipo int) {
                     done <- true
                     return
                 }
+                fairness.Served(ticket)
                 fmt.Printf("[Robot %s]: picked up rim CA\n", tipoRobot[tipo])
                 tt = rand.Intn(2) + 1
                 time.Sleep(time.Duration(tt) * time.Second) // mounting time for the rim
 
                 // 2) Pick up tire PA
+                ticket = fairness.Request(tipo, tipo)
                 prelievoPA <- tipo
                 ackVal = <-ack_robotA
                 if ackVal == -1 {
@@ -143,12 +180,14 @@ func Robot(tipo int) {
                     done <- true
                     return
                 }
+                fairness.Served(ticket)
                 fmt.Printf("[Robot %s]: picked up tire PA\n", tipoRobot[tipo])
                 tt = rand.Intn(2) + 1
                 time.Sleep(time.Duration(tt) * time.Second) // mounting time for the tire
 
             } else { // RobotB
                 // 1) Pick up rim CB
+                ticket := fairness.Request(tipo, tipo)
                 prelievoCB <- tipo
                 ackVal = <-ack_robotB
                 if ackVal == -1 {
@@ -156,11 +195,13 @@ func Robot(tipo int) {
                     done <- true
                     return
                 }
+                fairness.Served(ticket)
                 fmt.Printf("[Robot %s]: picked up rim CB\n", tipoRobot[tipo])
                 tt = rand.Intn(2) + 1
                 time.Sleep(time.Duration(tt) * time.Second) // mounting time for the rim
 
                 // 2) Pick up tire PB
+                ticket = fairness.Request(tipo, tipo)
                 prelievoPB <- tipo
                 ackVal = <-ack_robotB
                 if ackVal == -1 {
@@ -168,6 +209,7 @@ func Robot(tipo int) {
                     done <- true
                     return
                 }
+                fairness.Served(ticket)
                 fmt.Printf("[Robot %s]: picked up tire PB\n", tipoRobot[tipo])
                 tt = rand.Intn(2) + 1
                 time.Sleep(time.Duration(tt) * time.Second) // mounting time for the tire
@@ -273,11 +315,17 @@ func deposito() {
 
     var fine bool = false // becomes true when TOT cars are built
 
+    // The capacities and the pause, changed by the admin commands (see
+    // admin.go): lowered below the parts in stock, no part of that kind is
+    // delivered until the robots have taken enough of them
+    capP, capC := maxP, maxC
+    paused := false
+
     for {
         select {
         // 1) Receiving cerchio A (CA)
         case <-when(
-            !fine && (totC < maxC && numCA < maxC-1) &&
+            !fine && !paused && (totC < capC && numCA < capC-1) &&
             (numAMontati < numBMontati ||
                 (numAMontati >= numBMontati && len(consegnaCB) == 0)),
             consegnaCA,
@@ -289,7 +337,7 @@ func deposito() {
 
         // 2) Receiving cerchio B (CB)
         case <-when(
-            !fine && (totC < maxC && numCB < maxC-1) &&
+            !fine && !paused && (totC < capC && numCB < capC-1) &&
             (numAMontati >= numBMontati ||
                 (numAMontati < numBMontati && len(consegnaCA) == 0)),
             consegnaCB,
@@ -301,7 +349,7 @@ func deposito() {
 
         // 3) Receiving pneumatico A (PA)
         case <-when(
-            !fine && (totP < maxP && numPA < maxP-1) &&
+            !fine && !paused && (totP < capP && numPA < capP-1) &&
             (numAMontati < numBMontati ||
                 (numAMontati >= numBMontati && len(consegnaPB) == 0)),
             consegnaPA,
@@ -313,7 +361,7 @@ func deposito() {
 
         // 4) Receiving pneumatico B (PB)
         case <-when(
-            !fine && (totP < maxP && numPB < maxP-1) &&
+            !fine && !paused && (totP < capP && numPB < capP-1) &&
             (numAMontati >= numBMontati ||
                 (numAMontati < numBMontati && len(consegnaPA) == 0)),
             consegnaPB,
@@ -325,7 +373,7 @@ func deposito() {
 
         // 5) Robot A picking up a cerchio A (CA)
         case <-when(
-            !fine && numCA > 0 &&
+            !fine && !paused && numCA > 0 &&
                 (numAMontati < numBMontati ||
                     (numAMontati >= numBMontati && len(prelievoCB) == 0)),
             prelievoCA,
@@ -338,7 +386,7 @@ func deposito() {
 
         // 6) Robot B picking up a cerchio B (CB)
         case <-when(
-            !fine && numCB > 0 &&
+            !fine && !paused && numCB > 0 &&
                 (numAMontati >= numBMontati ||
                     (numAMontati < numBMontati && len(prelievoCA) == 0)),
             prelievoCB,
@@ -351,7 +399,7 @@ func deposito() {
 
         // 7) Robot A picking up a pneumatico A (PA)
         case <-when(
-            !fine && numPA > 0 &&
+            !fine && !paused && numPA > 0 &&
                 (numAMontati < numBMontati ||
                     (numAMontati >= numBMontati && len(prelievoPB) == 0)),
             prelievoPA,
@@ -364,7 +412,7 @@ func deposito() {
 
         // 8) Robot B picking up a pneumatico B (PB)
         case <-when(
-            !fine && numPB > 0 &&
+            !fine && !paused && numPB > 0 &&
                 (numAMontati >= numBMontati ||
                     (numAMontati < numBMontati && len(prelievoPA) == 0)),
             prelievoPB,
@@ -393,7 +441,38 @@ func deposito() {
         case <-when(fine, prelievoPB):
             ack_robotB <- -1
 
-        // 10) The main function eventually sends terminaDeposito here
+        // 10) An admin command
+        case cmd := <-adminCommands:
+            switch cmd.Op {
+            case ADMIN_PAUSE, ADMIN_RESUME:
+                paused = cmd.Op == ADMIN_PAUSE
+                fmt.Printf("[deposit] admin: %s.\n", cmd.Op)
+                cmd.Reply("paused: %v", paused)
+            case ADMIN_SET:
+                switch cmd.Name {
+                case "maxP":
+                    if n, err := cmd.Capacity(maxP); err == nil {
+                        capP = n
+                        fmt.Printf("[deposit] admin: at most %d tires.\n", capP)
+                        cmd.Reply("maxP: %d, tires: %d", capP, totP)
+                    }
+                case "maxC":
+                    if n, err := cmd.Capacity(maxC); err == nil {
+                        capC = n
+                        fmt.Printf("[deposit] admin: at most %d rims.\n", capC)
+                        cmd.Reply("maxC: %d, rims: %d", capC, totC)
+                    }
+                default:
+                    cmd.Fail("no capacity %s (maxP, maxC)", cmd.Name)
+                }
+            case ADMIN_OUT, ADMIN_IN:
+                cmd.Fail("nothing to take out of service in the deposit")
+            default: // ADMIN_DUMP
+                cmd.Reply("paused: %v; maxP: %d, PA=%d, PB=%d; maxC: %d, CA=%d, CB=%d; cars built: A=%d, B=%d",
+                    paused, capP, numPA, numPB, capC, numCA, numCB, numAMontati, numBMontati)
+            }
+
+        // 11) The main function eventually sends terminaDeposito here
         case <-terminaDeposito:
             fmt.Printf("[deposit] Terminating now.\n")
             done <- true
@@ -428,6 +507,14 @@ func main() {
 
     fmt.Printf("[main] Starting 4 conveyor belts and 2 robots.\n")
 
+    // Initialize the channels
+    initChannels(MAXBUFF)
+
+    fairness = FairnessFromFlags(tipoRobot[:])
+
+    // Admin commands for the deposit, with -admin (see admin.go)
+    stopAdmin := StartAdminFromFlags()
+
     // Start the deposit goroutine
     go deposito()
 
@@ -454,6 +541,13 @@ func main() {
     // Signal the deposit to terminate
     terminaDeposito <- true
     <-done
+    stopAdmin()
 
     fmt.Printf("[main] APPLICATION FINISHED\n")
+    WriteMetrics(fairness)
+
+    if err := CheckLeaks(); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
 }
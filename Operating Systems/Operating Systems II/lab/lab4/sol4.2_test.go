@@ -0,0 +1,291 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR deposito() (CAR FACTORY)
+//
+// The tests feed deposito() scripted sequences of deliveries and picks under a
+// virtual clock, and check which belts and robots are served, in which order,
+// and the final state: an empty deposit, or everybody refused after TOT cars.
+// TestAdmin sends the deposit the commands of admin.go.
+//
+// One benchmark iteration is a whole production run: a fresh deposit, 4 synthetic
+// conveyor belts and 2 synthetic robots that repeat the cycles of nastro() and
+// Robot() without any sleep, until TOT cars have been built and the deposit
+// answers -1 to everybody.
+//
+// The benchmark reports:
+//   - grants/s:        parts accepted from the belts or handed to the robots per second;
+//   - p50-ns, p99-ns:  latency from a consegna/prelievo request to its ack;
+//   - allocs/op:       allocations per production run.
+// It runs twice: with unbuffered request channels and with buffered ones
+// (MAXBUFF slots).
+//
+// Run with:
+//     go test -race sol4.2.go workload.go fairness.go metrics.go leaks.go admin.go servertest_test.go sol4.2_test.go
+//     go test -run XXX -bench . sol4.2.go workload.go fairness.go metrics.go leaks.go admin.go servertest_test.go sol4.2_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// ============================================================
+//                     PRODUCTION RUNS
+// ============================================================
+
+// request sends one request on req and waits for the answer on ack.
+// It returns false when the deposit answers -1 (production finished).
+func request(req, ack chan int, v int, lat *[]time.Duration) bool {
+	t := time.Now()
+	req <- v
+	if <-ack == -1 {
+		return false
+	}
+	*lat = append(*lat, time.Since(t))
+	return true
+}
+
+// production runs one production run and returns the latency of every grant.
+func production() []time.Duration {
+	lat := make([][]time.Duration, 6)
+	end := make(chan bool)
+
+	go deposito()
+	belts := [4]struct{ req, ack chan int }{
+		tipoPA: {consegnaPA, ack_nastroPA},
+		tipoPB: {consegnaPB, ack_nastroPB},
+		tipoCA: {consegnaCA, ack_nastroCA},
+		tipoCB: {consegnaCB, ack_nastroCB},
+	}
+	for i, belt := range belts {
+		go func() {
+			for request(belt.req, belt.ack, 1, &lat[i]) {
+			}
+			end <- true
+		}()
+	}
+	robots := [2]struct{ rim, tire, ack chan int }{
+		RobotA: {prelievoCA, prelievoPA, ack_robotA},
+		RobotB: {prelievoCB, prelievoPB, ack_robotB},
+	}
+	for i, robot := range robots {
+		go func() {
+			for request(robot.rim, robot.ack, i, &lat[4+i]) &&
+				request(robot.tire, robot.ack, i, &lat[4+i]) {
+			}
+			end <- true
+		}()
+	}
+	for range len(belts) + len(robots) {
+		<-end
+	}
+	terminaDeposito <- true
+	<-done
+	return slices.Concat(lat...)
+}
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+// The clients are the four conveyor belts, whose id is the type of part they
+// deliver, and the two robots, numbered after them.
+
+const (
+	robotA = 4 + RobotA
+	robotB = 4 + RobotB
+)
+
+const (
+	opDeliver = iota // consegnaXX <- 1, by belt id
+	opRim            // prelievoCA/CB <- robot, by robot id
+	opTire           // prelievoPA/PB <- robot, by robot id
+)
+
+var opNames = []string{"delivery by belt", "rim pick by robot", "tire pick by robot"}
+
+func do(s *script[int], st step) {
+	if st.op == opDeliver {
+		c := []chan int{consegnaPA, consegnaPB, consegnaCA, consegnaCB}[st.id]
+		ack := []chan int{ack_nastroPA, ack_nastroPB, ack_nastroCA, ack_nastroCB}[st.id]
+		s.call(st.id, ack, func() { c <- 1 })
+		return
+	}
+	robot := st.id - robotA
+	c := [2][2]chan int{{prelievoCA, prelievoPA}, {prelievoCB, prelievoPB}}[robot][st.op-opRim]
+	ack := []chan int{ack_robotA, ack_robotB}[robot]
+	s.call(st.id, ack, func() { c <- robot })
+}
+
+// cars returns the steps building n cars one part at a time, alternating the models.
+func cars(n int) []step {
+	var steps []step
+	for car := 0; car < n; car++ {
+		robot, rim, tire := robotA, tipoCA, tipoPA
+		if car%2 == 1 {
+			robot, rim, tire = robotB, tipoCB, tipoPB
+		}
+		for w := 0; w < 4; w++ {
+			steps = append(steps,
+				step{opDeliver, rim, []int{rim}},
+				step{opRim, robot, []int{robot}},
+				step{opDeliver, tire, []int{tire}},
+				step{opTire, robot, []int{robot}})
+		}
+	}
+	return steps
+}
+
+func TestDeposito(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name    string
+		steps   []step
+		stopped bool // TOT cars have been built
+	}{
+		{"at most maxC-1 rims of the same type", []step{
+			{opDeliver, tipoCA, []int{tipoCA}},
+			{opDeliver, tipoCA, []int{tipoCA}},
+			{opDeliver, tipoCA, nil},
+			{opRim, robotA, []int{robotA, tipoCA}},
+			{opRim, robotA, []int{robotA}},
+			{opRim, robotA, []int{robotA}},
+		}, false},
+		{"with the same number of cars built, model B parts go first", []step{
+			{opDeliver, tipoCA, []int{tipoCA}},
+			{opDeliver, tipoCA, []int{tipoCA}},
+			{opDeliver, tipoCB, []int{tipoCB}},
+			{opDeliver, tipoCA, nil},
+			{opDeliver, tipoCB, nil},
+			{opRim, robotA, []int{robotA, tipoCB}},
+			{opRim, robotB, []int{robotB, tipoCA}},
+			{opRim, robotA, []int{robotA}},
+			{opRim, robotA, []int{robotA}},
+			{opRim, robotB, []int{robotB}},
+		}, false},
+		{"after TOT cars every request is refused", cars(TOT), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				initChannels(MAXBUFF)
+				go deposito()
+				s := newScript[int](t)
+				for _, st := range tt.steps {
+					do(s, st)
+					s.expect(st.String(), st.want)
+				}
+				s.finish()
+				if tt.stopped {
+					checkStopped(s)
+				} else {
+					checkEmpty(s)
+				}
+				terminaDeposito <- true
+				<-done
+			})
+		})
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		initChannels(MAXBUFF)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go deposito()
+		s := newScript[int](t)
+		play := func(steps ...step) {
+			t.Helper()
+			for _, st := range steps {
+				do(s, st)
+				s.expect(st.String(), st.want)
+			}
+		}
+
+		// Two rims at most, so one of each type: the second rim A waits
+		s.admin(Admin, "set maxC 2", "maxC: 2, rims: 0", nil)
+		play(step{opDeliver, tipoCA, []int{tipoCA}}, step{opDeliver, tipoCA, nil})
+		s.admin(Admin, "dump", "paused: false; maxP: 3, PA=0, PB=0; maxC: 2, CA=1, CB=0; cars built: A=0, B=0", nil)
+		s.admin(Admin, "set maxC 3", "maxC: 3, rims: 1", []int{tipoCA})
+
+		// Paused: the robots wait for resume
+		s.admin(Admin, "pause", "paused: true", nil)
+		play(step{opRim, robotA, nil})
+		s.admin(Admin, "resume", "paused: false", []int{robotA})
+
+		s.admin(Admin, "set maxC 4", "error: maxC cannot be above 3", nil)
+		s.admin(Admin, "set TOT 1", "error: no capacity TOT (maxP, maxC)", nil)
+		s.admin(Admin, "out belt 0", "error: nothing to take out of service in the deposit", nil)
+		play(step{opRim, robotA, []int{robotA}})
+		s.finish()
+		checkEmpty(s)
+		terminaDeposito <- true
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// checkEmpty verifies that the deposit holds no part: every pick
+// waits until the part is delivered.
+func checkEmpty(s *script[int]) {
+	s.t.Helper()
+	for _, robot := range []int{robotA, robotB} {
+		for _, op := range []int{opRim, opTire} {
+			part := []int{tipoCA, tipoPA, tipoCB, tipoPB}[2*(robot-robotA)+op-opRim]
+			do(s, step{op: op, id: robot})
+			if got := s.grants(); len(got) > 0 {
+				s.t.Errorf("final state: %s %d served with an empty deposit", opNames[op], robot)
+			}
+			do(s, step{op: opDeliver, id: part})
+			s.grants()
+			s.finish()
+		}
+	}
+}
+
+// checkStopped verifies that every belt and robot is answered -1.
+func checkStopped(s *script[int]) {
+	s.t.Helper()
+	for _, st := range []step{
+		{opDeliver, tipoPA, nil}, {opDeliver, tipoPB, nil},
+		{opDeliver, tipoCA, nil}, {opDeliver, tipoCB, nil},
+		{opRim, robotA, nil}, {opTire, robotA, nil},
+		{opRim, robotB, nil}, {opTire, robotB, nil},
+	} {
+		do(s, st)
+		if got := s.grants(); !slices.Equal(got, []int{st.id}) || s.last[st.id] != -1 {
+			s.t.Errorf("final state: %v answered %v, want -1", st, s.last[st.id])
+		}
+	}
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+func BenchmarkDeposito(b *testing.B) {
+	silence(b)
+	for _, m := range bufferModes(MAXBUFF) {
+		b.Run(m.name, func(b *testing.B) {
+			var all []time.Duration
+			b.ReportAllocs()
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				initChannels(m.size)
+				all = append(all, production()...)
+			}
+			elapsed := time.Since(start)
+			b.StopTimer()
+			report(b, all, elapsed)
+		})
+	}
+}
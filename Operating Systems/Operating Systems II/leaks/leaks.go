@@ -0,0 +1,77 @@
+// -----------------------------------------------------------------------------------
+// GOROUTINE LEAKS AT THE END OF A SCENARIO
+//
+// A scenario can end with goroutines still blocked, e.g. a supplier waiting on
+// its request channel after the warehouse has closed, or a trainer waiting for
+// an ack that never comes. At the end of a run main calls CheckLeaks, which
+// lists the goroutines of the scenario still alive and returns their stacks as
+// an error: main prints it and exits with status 1. The TestMain of the server
+// tests (servertest_test.go) does the same after the tests, which makes
+// `go test` fail.
+//
+// Run the tests with:
+//     go test leaks.go leaks_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Leaks returns the stacks of the goroutines started by the scenario (created by
+// a function of its package) that are still alive, other than the caller. It
+// waits up to grace for them to exit: at the end of a run some goroutines are
+// still printing their last message after signalling that they are done.
+func Leaks(grace time.Duration) []string {
+	deadline := time.Now().Add(grace)
+	for {
+		leaks := scenarioGoroutines()
+		if len(leaks) == 0 || !time.Now().Before(deadline) {
+			return leaks
+		}
+		time.Sleep(grace / 100)
+	}
+}
+
+// scenarioGoroutines returns the stacks of the goroutines created by the package
+// of the scenario, except the caller.
+func scenarioGoroutines() []string {
+	// In a test binary the package is not called main, but e.g.
+	// command-line-arguments: it is the package of this function.
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name()
+	created := "\ncreated by " + name[:strings.LastIndex(name, ".")+1]
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	var leaks []string
+	// The first stack is the one of the caller.
+	for _, g := range strings.Split(string(buf), "\n\n")[1:] {
+		if strings.Contains(g, created) {
+			leaks = append(leaks, strings.TrimSpace(g))
+		}
+	}
+	return leaks
+}
+
+// CheckLeaks is called at the end of main and after the tests: if goroutines of
+// the scenario are still alive one second later, it returns an error with their
+// stacks.
+func CheckLeaks() error {
+	leaks := Leaks(time.Second)
+	if len(leaks) == 0 {
+		return nil
+	}
+	return fmt.Errorf("LEAK CHECK: %d goroutines of the scenario still alive at the end of the run\n\n%s",
+		len(leaks), strings.Join(leaks, "\n\n"))
+}
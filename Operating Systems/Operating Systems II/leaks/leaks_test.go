@@ -0,0 +1,39 @@
+// Tests for the leak check: a goroutine blocked at the end is reported, with
+// its stack, until it exits.
+//
+// Run with:
+//     go test leaks.go leaks_test.go
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeaks(t *testing.T) {
+	stop := make(chan bool)
+	go func() { <-stop }()
+	leaks := Leaks(10 * time.Millisecond)
+	if len(leaks) != 1 || !strings.Contains(leaks[0], "TestLeaks.func1") {
+		t.Fatalf("leaks: %q, want the blocked goroutine", leaks)
+	}
+	close(stop)
+	if leaks := Leaks(time.Second); len(leaks) != 0 {
+		t.Errorf("leaks after it exited: %q", leaks)
+	}
+}
+
+func TestCheckLeaks(t *testing.T) {
+	if err := CheckLeaks(); err != nil {
+		t.Fatalf("CheckLeaks with no goroutine left: %v", err)
+	}
+	stop := make(chan bool)
+	go func() { <-stop }()
+	err := CheckLeaks()
+	close(stop)
+	if err == nil || !strings.Contains(err.Error(), "1 goroutines") || !strings.Contains(err.Error(), "TestCheckLeaks.func1") {
+		t.Errorf("CheckLeaks: %v, want the blocked goroutine", err)
+	}
+}
@@ -0,0 +1 @@
+../fairness/fairness.go
\ No newline at end of file
@@ -0,0 +1,125 @@
+// -----------------------------------------------------------------------------------
+// END-OF-RUN METRICS OF A SCENARIO
+//
+// With
+//     -metrics <file>
+// the scenarios that measure their waits (with a Fairness, see fairness.go) also
+// write, at the end of the run, the throughput, the mean, p95 and maximum wait
+// and the utilization of the resources measured with a Usage (e.g. the offices of
+// 10-01-2022), one name=value per line: this is what sweep.go collects in a CSV
+// for every point of a parameter grid, e.g. from writtenExams/07-01-2025:
+//     go run examSolB.go workload.go fairness.go metrics.go leaks.go admin.go -metrics /tmp/gym.txt
+//
+// Run the tests with:
+//     go test fairness.go metrics.go metrics_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+var metricsFlag = flag.String("metrics", "", "write the end-of-run metrics to `file`, one name=value per line")
+
+// Usage measures the utilization of a resource with a fixed number of units
+// (e.g. the offices): the server calls Add(1) when it assigns a unit and
+// Add(-1) when the unit is released. A nil *Usage records nothing. It is used
+// only by the server goroutine.
+type Usage struct {
+	name     string
+	capacity int
+	now      func() time.Time
+	start    time.Time
+	last     time.Time
+	busy     int
+	area     float64 // busy units × seconds until last
+}
+
+// NewUsage creates the Usage of a resource with capacity units, named in the
+// metrics utilization_<name>.
+func NewUsage(name string, capacity int) *Usage {
+	u := &Usage{name: name, capacity: capacity, now: time.Now}
+	u.start = u.now()
+	u.last = u.start
+	return u
+}
+
+// Add records that n units have been assigned (n < 0: released).
+func (u *Usage) Add(n int) {
+	if u == nil {
+		return
+	}
+	now := u.now()
+	u.area += float64(u.busy) * now.Sub(u.last).Seconds()
+	u.last = now
+	u.busy += n
+}
+
+// Utilization returns the mean fraction of the units that were busy since the
+// Usage was created.
+func (u *Usage) Utilization() float64 {
+	now := u.now()
+	elapsed := now.Sub(u.start).Seconds()
+	if elapsed == 0 || u.capacity == 0 {
+		return 0
+	}
+	area := u.area + float64(u.busy)*now.Sub(u.last).Seconds()
+	return area / (elapsed * float64(u.capacity))
+}
+
+// WriteMetrics writes the metrics of the run to the file given with -metrics,
+// if any: it is called at the end of main. The waits and the throughput are
+// those of the requests recorded by f, from when f was created; every Usage
+// adds its utilization.
+func WriteMetrics(f *Fairness, usage ...*Usage) {
+	if *metricsFlag == "" {
+		return
+	}
+	out, err := os.Create(*metricsFlag)
+	if err == nil {
+		writeMetrics(out, f, usage)
+		err = out.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "metrics:", err)
+	}
+}
+
+// writeMetrics writes the metrics to w, the times in seconds: served, elapsed,
+// throughput (served per second), mean_wait, p95_wait, max_wait, starving (the
+// starvation alarms) and utilization_<name> for every Usage.
+func writeMetrics(w io.Writer, f *Fairness, usage []*Usage) {
+	if f != nil {
+		f.mu.Lock()
+		waits := slices.Concat(f.waits...)
+		slices.Sort(waits)
+		elapsed := f.now().Sub(f.start).Seconds()
+		var sum, p95, max time.Duration
+		for _, d := range waits {
+			sum += d
+		}
+		mean, throughput := 0.0, 0.0
+		if n := len(waits); n > 0 {
+			mean = (sum / time.Duration(n)).Seconds()
+			p95 = waits[(n*95+99)/100-1]
+			max = waits[n-1]
+		}
+		if elapsed > 0 {
+			throughput = float64(len(waits)) / elapsed
+		}
+		fmt.Fprintf(w, "served=%d\nelapsed=%.6g\nthroughput=%.6g\n", len(waits), elapsed, throughput)
+		fmt.Fprintf(w, "mean_wait=%.6g\np95_wait=%.6g\nmax_wait=%.6g\nstarving=%d\n", mean, p95.Seconds(), max.Seconds(), len(f.alarms))
+		f.mu.Unlock()
+	}
+	for _, u := range usage {
+		if u != nil {
+			fmt.Fprintf(w, "utilization_%s=%.6g\n", u.name, u.Utilization())
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Tests for the end-of-run metrics: the waits, the throughput and the
+// utilization written for sweep.go.
+//
+// Run with:
+//     go test fairness.go metrics.go metrics_test.go
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	clock := time.Unix(0, 0)
+	now := func() time.Time { return clock }
+	at := func(s int) { clock = time.Unix(int64(s), 0) }
+	f := NewFairness([]string{"A", "B"}, 1)
+	f.now, f.start = now, clock
+	u := NewUsage("desks", 2)
+	u.now, u.start, u.last = now, clock, clock
+
+	a, b := f.Request(0, 0), f.Request(1, 1)
+	u.Add(1)
+	at(1)
+	f.Served(a)
+	u.Add(1)
+	at(3)
+	f.Served(b)
+	u.Add(-1)
+	at(4)
+
+	var out strings.Builder
+	writeMetrics(&out, f, []*Usage{u, nil})
+	want := "served=2\nelapsed=4\nthroughput=0.5\nmean_wait=2\np95_wait=3\nmax_wait=3\nstarving=0\nutilization_desks=0.75\n"
+	if out.String() != want {
+		t.Errorf("metrics:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,82 @@
+// -----------------------------------------------------------------------------------
+// PARALLEL MATRIX MULTIPLICATION (MPI-style)
+//
+// C = A x B with A of size ROWS x INNER and B of size INNER x COLS.
+//   1) The root scatters the rows of A (stored row-major in a flat slice, so a
+//      block of rows is a contiguous block of the slice).
+//   2) B is broadcast to every rank.
+//   3) Every rank multiplies its rows of A by B.
+//   4) The rows of C are gathered at the root, which checks them against the
+//      sequential product.
+//
+// To keep Scatter's block distribution aligned with rows, ROWS is a multiple of NP.
+//
+// Run with:
+//     go run mpi.go matmul.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+const NP = 4    // Number of ranks
+const ROWS = 8  // Rows of A and C (multiple of NP)
+const INNER = 6 // Columns of A, rows of B
+const COLS = 5  // Columns of B and C
+const ROOT = 0
+
+// multiply returns the product of the rows x INNER block a by the INNER x COLS matrix b.
+func multiply(a []float64, b []float64, rows int) []float64 {
+	c := make([]float64, rows*COLS)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < COLS; j++ {
+			for k := 0; k < INNER; k++ {
+				c[i*COLS+j] += a[i*INNER+k] * b[k*COLS+j]
+			}
+		}
+	}
+	return c
+}
+
+func main() {
+	Run(NP, func(c *Comm) {
+		var a, b []float64
+		if c.Rank() == ROOT {
+			a = make([]float64, ROWS*INNER)
+			b = make([]float64, INNER*COLS)
+			for i := range a {
+				a[i] = float64(rand.Intn(10))
+			}
+			for i := range b {
+				b[i] = float64(rand.Intn(10))
+			}
+		}
+
+		rowsA := Scatter(c, a, ROOT)
+		b = Bcast(c, b, ROOT)
+
+		myRows := len(rowsA) / INNER
+		rowsC := multiply(rowsA, b, myRows)
+		fmt.Printf("[rank %d] computed %d rows of C\n", c.Rank(), myRows)
+
+		result := Gather(c, rowsC, ROOT)
+
+		if c.Rank() == ROOT {
+			expected := multiply(a, b, ROWS)
+			ok := true
+			for i := range expected {
+				if result[i] != expected[i] {
+					ok = false
+				}
+			}
+			fmt.Printf("[rank %d] C =\n", c.Rank())
+			for i := 0; i < ROWS; i++ {
+				fmt.Println(result[i*COLS : (i+1)*COLS])
+			}
+			fmt.Printf("[rank %d] equal to the sequential product: %t\n", c.Rank(), ok)
+		}
+	})
+}
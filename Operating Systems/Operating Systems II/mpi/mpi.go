@@ -0,0 +1,328 @@
+// -----------------------------------------------------------------------------------
+// MPI-STYLE MESSAGE PASSING ON GOROUTINES
+//
+// A small runtime that mimics the MPI programming model (see oralExam/10a_MPI.md)
+// using only goroutines and channels:
+//
+//   - Run(np, body) plays the role of `mpirun -np <np>`: it starts np goroutines
+//     ("ranks"), each executing body with its own communicator, and returns when
+//     all of them have finished (MPI_Init / MPI_Finalize are implicit).
+//   - Every rank owns an inbox: a buffered channel on which the other ranks
+//     deposit messages. Messages that arrive but do not match the current Recv
+//     (wrong source or tag) are parked in a private pending list, so the usual
+//     MPI matching rules hold:
+//         * a Recv can ask for a specific source/tag or use ANY_SOURCE / ANY_TAG;
+//         * messages between the same pair of ranks with the same tag are
+//           received in the order they were sent (non-overtaking).
+//   - Collective operations (Bcast, Scatter, Gather, Reduce, Allreduce, Barrier)
+//     are built on top of Send/Recv with reserved (negative) tags, so they can
+//     never be confused with user messages. Like in MPI, every rank must call
+//     the same collectives in the same order.
+//
+// Since Go methods cannot have type parameters, the typed operations are plain
+// generic functions taking the communicator as first argument:
+//
+//     Send(c, data, dest, tag)          MPI_Send
+//     v, st := Recv[T](c, source, tag)  MPI_Recv
+//     Bcast(c, v, root)                 MPI_Bcast
+//     Scatter(c, slice, root)           MPI_Scatter  (block distribution)
+//     Gather(c, slice, root)            MPI_Gather
+//     Reduce(c, v, op, root)            MPI_Reduce
+//     Allreduce(c, v, op)               MPI_Allreduce
+//     Barrier(c)                        MPI_Barrier
+//
+// Sends are "eager": they return as soon as the message is in the destination
+// inbox. Ownership of slices passes to the receiver, so a sender must not modify
+// a slice after sending it (Scatter and Gather copy their blocks for this reason).
+//
+// The runtime has no main: compile it together with one of the example programs
+//     go run mpi.go sum.go
+//     go run mpi.go matmul.go
+//     go run mpi.go pi.go
+// and run the tests with
+//     go test mpi.go mpi_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+)
+
+// ============================================================
+//                         CONSTANTS
+// ============================================================
+
+const MAXBUFF = 1024 // Capacity of each rank's inbox
+
+// Wildcards for Recv.
+const ANY_SOURCE = -1
+const ANY_TAG = -1
+
+// Reserved tags used by the collective operations.
+// User tags must be >= 0.
+const (
+	tagBcast   = -10
+	tagScatter = -11
+	tagGather  = -12
+	tagReduce  = -13
+)
+
+// ============================================================
+//                      DATA STRUCTURES
+// ============================================================
+
+// Status describes a received message (MPI_Status).
+type Status struct {
+	Source int // Rank of the sender
+	Tag    int // Tag of the message
+}
+
+// message is what travels through an inbox.
+type message struct {
+	source int
+	tag    int
+	data   any
+}
+
+// world is shared by all the ranks of a Run.
+type world struct {
+	size  int
+	inbox []chan message
+}
+
+// Comm is the communicator of one rank (the equivalent of MPI_COMM_WORLD
+// seen from that rank). It must only be used by the goroutine it was given to.
+type Comm struct {
+	rank    int
+	w       *world
+	pending []message // received messages that did not match a Recv yet
+}
+
+// Rank returns the rank of the calling goroutine (MPI_Comm_rank).
+func (c *Comm) Rank() int { return c.rank }
+
+// Size returns the number of ranks (MPI_Comm_size).
+func (c *Comm) Size() int { return c.w.size }
+
+// ============================================================
+//                         LAUNCHER
+// ============================================================
+
+// Run starts np ranks, each executing body, and waits for all of them.
+func Run(np int, body func(c *Comm)) {
+	if np < 1 {
+		panic(fmt.Sprintf("mpi: invalid number of ranks %d", np))
+	}
+	w := &world{size: np, inbox: make([]chan message, np)}
+	for i := 0; i < np; i++ {
+		w.inbox[i] = make(chan message, MAXBUFF)
+	}
+
+	done := make(chan bool)
+	for i := 0; i < np; i++ {
+		go func(rank int) {
+			body(&Comm{rank: rank, w: w})
+			done <- true
+		}(i)
+	}
+	for i := 0; i < np; i++ {
+		<-done
+	}
+}
+
+// ============================================================
+//                    POINT-TO-POINT
+// ============================================================
+
+// Send delivers data to rank dest with the given tag (MPI_Send).
+func Send[T any](c *Comm, data T, dest, tag int) {
+	if tag < 0 {
+		panic(fmt.Sprintf("mpi: rank %d: negative tags are reserved (tag %d)", c.rank, tag))
+	}
+	c.send(data, dest, tag)
+}
+
+// Recv blocks until a message from source with the given tag arrives
+// (MPI_Recv). source may be ANY_SOURCE and tag may be ANY_TAG.
+func Recv[T any](c *Comm, source, tag int) (T, Status) {
+	m := c.recv(source, tag)
+	v, ok := m.data.(T)
+	if !ok {
+		panic(fmt.Sprintf("mpi: rank %d: message from %d (tag %d) has type %T, not %T",
+			c.rank, m.source, m.tag, m.data, v))
+	}
+	return v, Status{Source: m.source, Tag: m.tag}
+}
+
+func (c *Comm) send(data any, dest, tag int) {
+	if dest < 0 || dest >= c.w.size {
+		panic(fmt.Sprintf("mpi: rank %d: invalid destination %d", c.rank, dest))
+	}
+	c.w.inbox[dest] <- message{source: c.rank, tag: tag, data: data}
+}
+
+// matches reports whether m satisfies a Recv(source, tag).
+// ANY_TAG never matches the reserved tags of the collectives.
+func matches(m message, source, tag int) bool {
+	if source != ANY_SOURCE && m.source != source {
+		return false
+	}
+	if tag == ANY_TAG {
+		return m.tag >= 0
+	}
+	return m.tag == tag
+}
+
+func (c *Comm) recv(source, tag int) message {
+	// First look among the messages that already arrived, oldest first.
+	for i, m := range c.pending {
+		if matches(m, source, tag) {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return m
+		}
+	}
+	// Then wait on the inbox, parking what does not match.
+	for {
+		m := <-c.w.inbox[c.rank]
+		if matches(m, source, tag) {
+			return m
+		}
+		c.pending = append(c.pending, m)
+	}
+}
+
+// ============================================================
+//                       COLLECTIVES
+// ============================================================
+
+// Bcast sends v from root to every rank and returns it (MPI_Bcast).
+// The value passed by non-root ranks is ignored. Binomial tree: log2(size) steps.
+func Bcast[T any](c *Comm, v T, root int) T {
+	size := c.Size()
+	rel := (c.rank - root + size) % size // rank relative to the root
+
+	// Receive from the parent (the rank that differs in the lowest set bit).
+	mask := 1
+	for mask < size {
+		if rel&mask != 0 {
+			m := c.recv((rel-mask+root)%size, tagBcast)
+			v = m.data.(T)
+			break
+		}
+		mask <<= 1
+	}
+	// Forward to the children.
+	mask >>= 1
+	for mask > 0 {
+		if rel+mask < size {
+			c.send(v, (rel+mask+root)%size, tagBcast)
+		}
+		mask >>= 1
+	}
+	return v
+}
+
+// blockRange returns the [lo, hi) slice bounds of rank's block when n items
+// are split among size ranks: the first n%size ranks get one extra item.
+func blockRange(n, size, rank int) (int, int) {
+	q, r := n/size, n%size
+	lo := rank*q + min(rank, r)
+	hi := lo + q
+	if rank < r {
+		hi++
+	}
+	return lo, hi
+}
+
+// Scatter splits data (significant only at root) into Size() contiguous blocks
+// and returns the block of the calling rank (MPI_Scatter / MPI_Scatterv with
+// block distribution).
+func Scatter[T any](c *Comm, data []T, root int) []T {
+	if c.rank == root {
+		var mine []T
+		for r := 0; r < c.Size(); r++ {
+			lo, hi := blockRange(len(data), c.Size(), r)
+			block := append([]T(nil), data[lo:hi]...)
+			if r == root {
+				mine = block
+			} else {
+				c.send(block, r, tagScatter)
+			}
+		}
+		return mine
+	}
+	return c.recv(root, tagScatter).data.([]T)
+}
+
+// Gather concatenates the blocks of all ranks, in rank order, at root
+// (MPI_Gather / MPI_Gatherv). Non-root ranks get nil.
+func Gather[T any](c *Comm, local []T, root int) []T {
+	if c.rank != root {
+		c.send(append([]T(nil), local...), root, tagGather)
+		return nil
+	}
+	var all []T
+	for r := 0; r < c.Size(); r++ {
+		if r == root {
+			all = append(all, local...)
+		} else {
+			all = append(all, c.recv(r, tagGather).data.([]T)...)
+		}
+	}
+	return all
+}
+
+// Reduce combines the values of all ranks with op and returns the result at
+// root (MPI_Reduce); the other ranks get their partial result, which should be
+// ignored. As with MPI built-in operations, op must be associative and
+// commutative. Binomial tree: log2(size) steps.
+func Reduce[T any](c *Comm, v T, op func(T, T) T, root int) T {
+	size := c.Size()
+	rel := (c.rank - root + size) % size
+
+	for mask := 1; mask < size; mask <<= 1 {
+		if rel&mask != 0 {
+			// Send the partial result to the parent and stop.
+			c.send(v, (rel-mask+root)%size, tagReduce)
+			return v
+		}
+		if rel+mask < size {
+			m := c.recv((rel+mask+root)%size, tagReduce)
+			v = op(v, m.data.(T))
+		}
+	}
+	return v
+}
+
+// Allreduce combines the values of all ranks with op and returns the result
+// to every rank (MPI_Allreduce).
+func Allreduce[T any](c *Comm, v T, op func(T, T) T) T {
+	return Bcast(c, Reduce(c, v, op, 0), 0)
+}
+
+// Barrier blocks until all ranks have called it (MPI_Barrier).
+func Barrier(c *Comm) {
+	Allreduce(c, struct{}{}, func(a, b struct{}) struct{} { return a })
+}
+
+// ============================================================
+//                    PREDEFINED OPERATIONS
+// ============================================================
+
+// Sum, Max and Min are the equivalents of MPI_SUM, MPI_MAX and MPI_MIN.
+func Sum[T int | int64 | float64](a, b T) T { return a + b }
+
+func Max[T int | int64 | float64](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func Min[T int | int64 | float64](a, b T) T {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,273 @@
+// Tests for the MPI-style runtime: every collective is checked against the
+// equivalent sequential computation, for several numbers of ranks.
+//
+// Run with:
+//     go test -race mpi.go mpi_test.go
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+)
+
+var rankCounts = []int{1, 2, 3, 4, 7, 8}
+
+// collect runs body on np ranks and returns the value produced by each rank.
+func collect[T any](np int, body func(c *Comm) T) []T {
+	results := make([]T, np)
+	Run(np, func(c *Comm) {
+		results[c.Rank()] = body(c)
+	})
+	return results
+}
+
+func TestSendRecvTags(t *testing.T) {
+	got := collect(2, func(c *Comm) []int {
+		if c.Rank() == 0 {
+			Send(c, 1, 1, 10)
+			Send(c, 2, 1, 20)
+			Send(c, 3, 1, 10)
+			return nil
+		}
+		// Ask for tag 20 first: the two tag 10 messages must be parked
+		// and then received in the order they were sent.
+		a, st := Recv[int](c, 0, 20)
+		if st.Source != 0 || st.Tag != 20 {
+			t.Errorf("status = %+v, want {Source:0 Tag:20}", st)
+		}
+		b, _ := Recv[int](c, ANY_SOURCE, 10)
+		d, _ := Recv[int](c, 0, ANY_TAG)
+		return []int{a, b, d}
+	})
+	if want := []int{2, 1, 3}; !slices.Equal(got[1], want) {
+		t.Errorf("received %v, want %v", got[1], want)
+	}
+}
+
+func TestRecvAnySource(t *testing.T) {
+	for _, np := range rankCounts {
+		got := collect(np, func(c *Comm) int {
+			if c.Rank() != 0 {
+				Send(c, c.Rank(), 0, 0)
+				return 0
+			}
+			sum := 0
+			for i := 1; i < c.Size(); i++ {
+				v, st := Recv[int](c, ANY_SOURCE, 0)
+				if v != st.Source {
+					t.Errorf("np=%d: payload %d from rank %d", np, v, st.Source)
+				}
+				sum += v
+			}
+			return sum
+		})
+		if want := np * (np - 1) / 2; got[0] != want {
+			t.Errorf("np=%d: sum of sources = %d, want %d", np, got[0], want)
+		}
+	}
+}
+
+func TestBcast(t *testing.T) {
+	for _, np := range rankCounts {
+		for root := 0; root < np; root++ {
+			got := collect(np, func(c *Comm) string {
+				v := ""
+				if c.Rank() == root {
+					v = "hello"
+				}
+				return Bcast(c, v, root)
+			})
+			for r, v := range got {
+				if v != "hello" {
+					t.Errorf("np=%d root=%d: rank %d got %q", np, root, r, v)
+				}
+			}
+		}
+	}
+}
+
+func TestScatterGatherRoundTrip(t *testing.T) {
+	for _, np := range rankCounts {
+		for _, n := range []int{0, 1, np, 10, 101} {
+			data := rand.Perm(n)
+			root := np - 1
+			got := collect(np, func(c *Comm) []int {
+				var in []int
+				if c.Rank() == root {
+					in = data
+				}
+				block := Scatter(c, in, root)
+				lo, hi := blockRange(n, np, c.Rank())
+				if !slices.Equal(block, data[lo:hi]) {
+					t.Errorf("np=%d n=%d: rank %d got block %v, want %v", np, n, c.Rank(), block, data[lo:hi])
+				}
+				return Gather(c, block, root)
+			})
+			if !slices.Equal(got[root], data) {
+				t.Errorf("np=%d n=%d: gathered %v, want %v", np, n, got[root], data)
+			}
+		}
+	}
+}
+
+func TestReduceMatchesSequential(t *testing.T) {
+	for _, np := range rankCounts {
+		values := make([]int, np)
+		for i := range values {
+			values[i] = rand.Intn(1000) - 500
+		}
+		seqSum, seqMax, seqMin := 0, values[0], values[0]
+		for _, v := range values {
+			seqSum += v
+			seqMax = max(seqMax, v)
+			seqMin = min(seqMin, v)
+		}
+
+		for root := 0; root < np; root++ {
+			got := collect(np, func(c *Comm) [3]int {
+				v := values[c.Rank()]
+				return [3]int{
+					Reduce(c, v, Sum[int], root),
+					Reduce(c, v, Max[int], root),
+					Reduce(c, v, Min[int], root),
+				}
+			})
+			if want := [3]int{seqSum, seqMax, seqMin}; got[root] != want {
+				t.Errorf("np=%d root=%d: reduce = %v, want %v", np, root, got[root], want)
+			}
+		}
+	}
+}
+
+func TestAllreduceMatchesSequential(t *testing.T) {
+	for _, np := range rankCounts {
+		got := collect(np, func(c *Comm) float64 {
+			return Allreduce(c, float64(c.Rank())*0.5, Sum[float64])
+		})
+		want := 0.5 * float64(np*(np-1)/2)
+		for r, v := range got {
+			if v != want {
+				t.Errorf("np=%d: rank %d got %v, want %v", np, r, v, want)
+			}
+		}
+	}
+}
+
+func TestBarrier(t *testing.T) {
+	for _, np := range rankCounts {
+		var mu sync.Mutex
+		arrived := 0
+		collect(np, func(c *Comm) bool {
+			mu.Lock()
+			arrived++
+			mu.Unlock()
+
+			Barrier(c)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if arrived != np {
+				t.Errorf("np=%d: rank %d left the barrier with %d/%d arrivals", np, c.Rank(), arrived, np)
+			}
+			return true
+		})
+	}
+}
+
+// The three example programs, checked against their sequential versions.
+
+func TestParallelSum(t *testing.T) {
+	const n = 10000
+	data := make([]int, n)
+	for i := range data {
+		data[i] = rand.Intn(100)
+	}
+	sequential := 0
+	for _, x := range data {
+		sequential += x
+	}
+	for _, np := range rankCounts {
+		got := collect(np, func(c *Comm) int {
+			partial := 0
+			for _, x := range Scatter(c, data, 0) {
+				partial += x
+			}
+			return Reduce(c, partial, Sum[int], 0)
+		})
+		if got[0] != sequential {
+			t.Errorf("np=%d: parallel sum %d, sequential %d", np, got[0], sequential)
+		}
+	}
+}
+
+func TestMatrixMultiply(t *testing.T) {
+	const rows, inner, cols = 12, 5, 7
+	a := make([]int, rows*inner)
+	b := make([]int, inner*cols)
+	for i := range a {
+		a[i] = rand.Intn(10)
+	}
+	for i := range b {
+		b[i] = rand.Intn(10)
+	}
+	mul := func(a, b []int, rows int) []int {
+		c := make([]int, rows*cols)
+		for i := 0; i < rows; i++ {
+			for j := 0; j < cols; j++ {
+				for k := 0; k < inner; k++ {
+					c[i*cols+j] += a[i*inner+k] * b[k*cols+j]
+				}
+			}
+		}
+		return c
+	}
+	sequential := mul(a, b, rows)
+
+	for _, np := range []int{1, 2, 3, 4, 6, 12} { // divisors of rows
+		got := collect(np, func(c *Comm) []int {
+			myA := Scatter(c, a, 0)
+			myB := Bcast(c, b, 0)
+			return Gather(c, mul(myA, myB, len(myA)/inner), 0)
+		})
+		if !slices.Equal(got[0], sequential) {
+			t.Errorf("np=%d: parallel product differs from the sequential one", np)
+		}
+	}
+}
+
+func TestMonteCarloPi(t *testing.T) {
+	const samples = 20000
+	count := func(seed int64) int {
+		rng := rand.New(rand.NewSource(seed))
+		hits := 0
+		for i := 0; i < samples; i++ {
+			x, y := rng.Float64(), rng.Float64()
+			if x*x+y*y <= 1 {
+				hits++
+			}
+		}
+		return hits
+	}
+	for _, np := range rankCounts {
+		sequential := 0
+		for r := 0; r < np; r++ {
+			sequential += count(int64(r))
+		}
+		got := collect(np, func(c *Comm) int {
+			return Allreduce(c, count(int64(c.Rank())), Sum[int])
+		})
+		for r, v := range got {
+			if v != sequential {
+				t.Errorf("np=%d: rank %d counted %d hits, sequential %d", np, r, v, sequential)
+			}
+		}
+		pi := 4 * float64(got[0]) / float64(samples*np)
+		if math.Abs(pi-math.Pi) > 0.1 {
+			t.Errorf("np=%d: pi estimate %v too far from %v", np, pi, math.Pi)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// -----------------------------------------------------------------------------------
+// MONTE-CARLO ESTIMATE OF PI (MPI-style)
+//
+// Every rank throws SAMPLES random points in the unit square with its own random
+// generator and counts how many fall inside the quarter circle. Allreduce sums
+// the counts, so every rank can compute the same estimate 4 * hits / total.
+//
+// Run with:
+//     go run mpi.go pi.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const NP = 4            // Number of ranks
+const SAMPLES = 2000000 // Points thrown by each rank
+
+func main() {
+	seed := time.Now().UnixNano()
+
+	Run(NP, func(c *Comm) {
+		// One generator per rank: math/rand's global source would serialize the ranks.
+		rng := rand.New(rand.NewSource(seed + int64(c.Rank())))
+
+		hits := 0
+		for i := 0; i < SAMPLES; i++ {
+			x, y := rng.Float64(), rng.Float64()
+			if x*x+y*y <= 1 {
+				hits++
+			}
+		}
+		fmt.Printf("[rank %d] %d hits out of %d\n", c.Rank(), hits, SAMPLES)
+
+		total := Allreduce(c, hits, Sum[int])
+		pi := 4 * float64(total) / float64(SAMPLES*c.Size())
+
+		Barrier(c)
+		if c.Rank() == 0 {
+			fmt.Printf("[rank %d] pi ~ %.6f (error %.6f)\n", c.Rank(), pi, math.Abs(pi-math.Pi))
+		}
+	})
+}
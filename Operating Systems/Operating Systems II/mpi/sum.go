@@ -0,0 +1,54 @@
+// -----------------------------------------------------------------------------------
+// PARALLEL SUM (MPI-style)
+//
+// The root rank builds the vector 1..N, Scatter hands one block to every rank,
+// each rank sums its block and Reduce combines the partial sums at the root,
+// which compares the result with the sequential sum and with N*(N+1)/2.
+//
+// Run with:
+//     go run mpi.go sum.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+)
+
+const NP = 4      // Number of ranks
+const N = 1000000 // Length of the vector
+const ROOT = 0    // Rank that owns the data
+
+func main() {
+	Run(NP, func(c *Comm) {
+		var data []int
+		if c.Rank() == ROOT {
+			data = make([]int, N)
+			for i := range data {
+				data[i] = i + 1
+			}
+		}
+
+		// 1) Distribute the blocks
+		block := Scatter(c, data, ROOT)
+
+		// 2) Local partial sum
+		partial := 0
+		for _, x := range block {
+			partial += x
+		}
+		fmt.Printf("[rank %d] summed %d elements: partial = %d\n", c.Rank(), len(block), partial)
+
+		// 3) Combine the partial sums at the root
+		total := Reduce(c, partial, Sum[int], ROOT)
+
+		if c.Rank() == ROOT {
+			sequential := 0
+			for _, x := range data {
+				sequential += x
+			}
+			fmt.Printf("[rank %d] parallel sum = %d, sequential sum = %d, N(N+1)/2 = %d\n",
+				c.Rank(), total, sequential, N*(N+1)/2)
+		}
+	})
+}
@@ -0,0 +1,398 @@
+// -----------------------------------------------------------------------------------
+// OPENMP-LIKE PARALLEL LOOPS IN GO
+//
+// The programs of this course create one goroutine per entity (client, vehicle,
+// supplier...). This file covers the other big family of parallel programs
+// (see oralExam/10b_OpenMP.md): data parallelism, where a loop of n independent
+// iterations is split among a fixed team of workers.
+//
+//     C / OpenMP                                     Go
+//     ----------                                     --
+//     omp_set_num_threads(4);                        SetNumWorkers(4)
+//     #pragma omp parallel for schedule(static)      For(n, body, Schedule{Static, 0})
+//     #pragma omp parallel for schedule(dynamic, 4)  For(n, body, Schedule{Dynamic, 4})
+//     #pragma omp parallel for schedule(guided, 2)   For(n, body, Schedule{Guided, 2})
+//     #pragma omp parallel for reduction(+:sum)      sum, _ := Reduce(n, 0, body, add, schedule)
+//
+// Schedules (same semantics as OpenMP):
+//   - Static:  iterations are split in chunks of size Chunk assigned round-robin
+//              to the workers before the loop starts; with Chunk == 0 every worker
+//              gets one contiguous block of about n/workers iterations.
+//              No synchronization, but bad balance if iterations have different costs.
+//   - Dynamic: workers grab the next chunk of size Chunk (default 1) when they
+//              become idle. Good balance, one synchronization per chunk.
+//   - Guided:  like Dynamic, but the chunk size is proportional to the number of
+//              remaining iterations divided by the number of workers, and never
+//              smaller than Chunk (default 1). Big chunks first, small at the end.
+//
+// Every call returns a Report with the load of each worker (iterations, chunks,
+// busy time) so the effect of each schedule can be seen directly; the benchmarks
+// in parallel_test.go compare the schedules on unbalanced loops.
+//
+// The workers are long-lived goroutines (a pool), each one waiting for work on its
+// own channel, like the servers of the exam solutions wait for requests. The
+// pool runs one loop at a time: a loop of another goroutine waits for the
+// running one to end. There is no nested parallelism: a For or Reduce inside
+// the body of another loop runs in the worker that calls it.
+//
+// The runtime has no main: compile it together with one of the example programs
+//     go run parallel.go primes.go
+//     go run parallel.go pi.go
+// Run the tests and benchmarks with:
+//     go test parallel.go parallel_test.go
+//     go test -run XXX -bench . parallel.go parallel_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================================
+//                         SCHEDULES
+// ============================================================
+
+// Kind selects how iterations are assigned to the workers.
+type Kind int
+
+const (
+	Static Kind = iota
+	Dynamic
+	Guided
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Static:
+		return "static"
+	case Dynamic:
+		return "dynamic"
+	case Guided:
+		return "guided"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Schedule is the equivalent of OpenMP's schedule(kind, chunk) clause.
+type Schedule struct {
+	Kind  Kind
+	Chunk int
+}
+
+func (s Schedule) String() string {
+	if s.Chunk <= 0 {
+		return s.Kind.String()
+	}
+	return fmt.Sprintf("%s,%d", s.Kind, s.Chunk)
+}
+
+// ============================================================
+//                          REPORT
+// ============================================================
+
+// Load describes the work done by one worker during a loop.
+type Load struct {
+	Iterations int           // Iterations executed
+	Chunks     int           // Chunks taken (scheduling decisions)
+	Busy       time.Duration // Time spent inside the loop body
+}
+
+// Report describes a whole loop.
+type Report struct {
+	Schedule Schedule
+	Elapsed  time.Duration // Wall-clock time of the loop
+	Workers  []Load        // Load of each worker, indexed by worker id
+}
+
+// Imbalance returns the ratio between the busiest worker and the average
+// worker (1.0 means perfect balance, Workers means one worker did everything).
+func (r Report) Imbalance() float64 {
+	var total, busiest time.Duration
+	for _, w := range r.Workers {
+		total += w.Busy
+		busiest = max(busiest, w.Busy)
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(busiest) * float64(len(r.Workers)) / float64(total)
+}
+
+// String prints the per-worker table.
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "schedule(%s): %d workers, elapsed %v, imbalance %.2f\n",
+		r.Schedule, len(r.Workers), r.Elapsed, r.Imbalance())
+	for i, w := range r.Workers {
+		fmt.Fprintf(&b, "  [worker %d] iterations: %6d  chunks: %5d  busy: %v\n",
+			i, w.Iterations, w.Chunks, w.Busy)
+	}
+	return b.String()
+}
+
+// ============================================================
+//                        WORKER POOL
+// ============================================================
+
+// pool is a team of long-lived workers. Worker w receives the functions
+// to execute on work[w] and signals their completion on done.
+type pool struct {
+	work []chan func(worker int)
+	done chan bool
+}
+
+func newPool(workers int) *pool {
+	p := &pool{work: make([]chan func(int), workers), done: make(chan bool)}
+	started := make(chan bool)
+	for w := range p.work {
+		p.work[w] = make(chan func(int))
+		go func(w int) {
+			id := goid()
+			workerIDs.Store(id, true)
+			defer workerIDs.Delete(id)
+			started <- true
+			for f := range p.work[w] {
+				f(w)
+				p.done <- true
+			}
+		}(w)
+	}
+	for range p.work {
+		<-started
+	}
+	return p
+}
+
+// run executes f on every worker and waits for all of them
+// (a parallel region with its implicit barrier at the end).
+func (p *pool) run(f func(worker int)) {
+	for _, c := range p.work {
+		c <- f
+	}
+	for range p.work {
+		<-p.done
+	}
+}
+
+func (p *pool) close() {
+	for _, c := range p.work {
+		close(c)
+	}
+}
+
+var (
+	mu   sync.Mutex // serializes loops on the shared pool
+	team *pool
+
+	workerIDs sync.Map // goroutine id -> true, for the workers of every pool
+)
+
+// goid returns the id of the calling goroutine, from the first line of its
+// stack ("goroutine 18 [running]:"): it is how a loop tells whether it was
+// started by a worker, in the body of another loop.
+func goid() uint64 {
+	var buf [64]byte
+	line := buf[:runtime.Stack(buf[:], false)]
+	line = line[len("goroutine "):]
+	id, _ := strconv.ParseUint(string(line[:slices.Index(line, ' ')]), 10, 64)
+	return id
+}
+
+// SetNumWorkers sets the number of workers used by the following loops
+// (omp_set_num_threads). The default is runtime.GOMAXPROCS(0). Like
+// NumWorkers, it waits for the running loop: neither can be called from the
+// body of a loop.
+func SetNumWorkers(n int) {
+	if n < 1 {
+		panic(fmt.Sprintf("parallel: invalid number of workers %d", n))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if team != nil {
+		team.close()
+	}
+	team = newPool(n)
+}
+
+// NumWorkers returns the number of workers of the pool (omp_get_max_threads).
+func NumWorkers() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if team == nil {
+		return runtime.GOMAXPROCS(0)
+	}
+	return len(team.work)
+}
+
+// ============================================================
+//                          LOOPS
+// ============================================================
+
+// chunker hands out [lo, hi) ranges of the iteration space to a worker.
+// It returns ok == false when the worker has no more iterations.
+type chunker func(worker int) (lo, hi int, ok bool)
+
+// newChunker builds the iteration-assignment policy for a schedule.
+func newChunker(n, workers int, s Schedule) chunker {
+	switch s.Kind {
+	case Static:
+		if s.Chunk <= 0 {
+			// One contiguous block per worker, the first n%workers get one more.
+			taken := make([]bool, workers)
+			return func(w int) (int, int, bool) {
+				if taken[w] {
+					return 0, 0, false
+				}
+				taken[w] = true
+				q, r := n/workers, n%workers
+				lo := w*q + min(w, r)
+				hi := lo + q
+				if w < r {
+					hi++
+				}
+				return lo, hi, lo < hi
+			}
+		}
+		// Chunks assigned round-robin: worker w gets chunks w, w+workers, ...
+		next := make([]int, workers)
+		for w := range next {
+			next[w] = w * s.Chunk
+		}
+		return func(w int) (int, int, bool) {
+			lo := next[w]
+			if lo >= n {
+				return 0, 0, false
+			}
+			next[w] += workers * s.Chunk
+			return lo, min(lo+s.Chunk, n), true
+		}
+
+	case Dynamic:
+		chunk := max(s.Chunk, 1)
+		var next atomic.Int64
+		return func(int) (int, int, bool) {
+			lo := int(next.Add(int64(chunk))) - chunk
+			if lo >= n {
+				return 0, 0, false
+			}
+			return lo, min(lo+chunk, n), true
+		}
+
+	case Guided:
+		minChunk := max(s.Chunk, 1)
+		var next atomic.Int64
+		return func(int) (int, int, bool) {
+			for {
+				lo := int(next.Load())
+				if lo >= n {
+					return 0, 0, false
+				}
+				size := max((n-lo+workers-1)/workers, minChunk)
+				hi := min(lo+size, n)
+				if next.CompareAndSwap(int64(lo), int64(hi)) {
+					return lo, hi, true
+				}
+			}
+		}
+	}
+	panic(fmt.Sprintf("parallel: unknown schedule %v", s.Kind))
+}
+
+// loop runs body(worker, lo, hi) on the pool for every chunk of [0, n)
+// and fills in the report. prepare, if not nil, is called with the number
+// of workers before the loop starts (e.g. to allocate per-worker state).
+//
+// The pool runs one loop at a time, and a loop of another goroutine waits for
+// it. As in OpenMP, where nested parallelism is off by default, a loop started
+// by a worker (a For inside the body of a For) cannot wait for the pool, whose
+// workers may all be inside the outer body: it runs in that worker as a team
+// of one worker. A goroutine started by a body is not a worker, and its loops
+// wait for the outer loop to end.
+func loop(n int, s Schedule, prepare func(workers int), body func(worker, lo, hi int)) Report {
+	if _, nested := workerIDs.Load(goid()); nested {
+		return runLoop(n, s, 1, prepare, body, func(f func(int)) { f(0) })
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if team == nil {
+		team = newPool(runtime.GOMAXPROCS(0))
+	}
+	return runLoop(n, s, len(team.work), prepare, body, team.run)
+}
+
+// runLoop runs the chunks of the loop on the given number of workers, started
+// by run.
+func runLoop(n int, s Schedule, workers int, prepare func(workers int), body func(worker, lo, hi int), run func(f func(worker int))) Report {
+	if prepare != nil {
+		prepare(workers)
+	}
+
+	next := newChunker(n, workers, s)
+	loads := make([]Load, workers)
+	start := time.Now()
+	run(func(w int) {
+		for {
+			lo, hi, ok := next(w)
+			if !ok {
+				return
+			}
+			t := time.Now()
+			body(w, lo, hi)
+			loads[w].Busy += time.Since(t)
+			loads[w].Iterations += hi - lo
+			loads[w].Chunks++
+		}
+	})
+	return Report{Schedule: s, Elapsed: time.Since(start), Workers: loads}
+}
+
+// For executes body(i) for every i in [0, n) on the worker pool, distributing
+// the iterations according to s (#pragma omp parallel for schedule(...)).
+// Iterations must be independent of each other.
+func For(n int, body func(i int), s Schedule) Report {
+	return loop(n, s, nil, func(_, lo, hi int) {
+		for i := lo; i < hi; i++ {
+			body(i)
+		}
+	})
+}
+
+// Reduce computes op(identity, body(0), body(1), ..., body(n-1)) in parallel
+// (#pragma omp parallel for reduction(op:var)). Every chunk accumulates a
+// private partial result starting from identity; the partial results are then
+// combined in the order of their chunks, so that op must only be associative
+// (string concatenation is fine, not only sums) and identity neutral for op.
+func Reduce[T any](n int, identity T, body func(i int) T, op func(T, T) T, s Schedule) (T, Report) {
+	// The partial result of a chunk starting at iteration lo
+	type partial struct {
+		lo  int
+		acc T
+	}
+	var partials [][]partial // per worker, no locking
+	prepare := func(workers int) {
+		partials = make([][]partial, workers)
+	}
+	report := loop(n, s, prepare, func(w, lo, hi int) {
+		acc := identity
+		for i := lo; i < hi; i++ {
+			acc = op(acc, body(i))
+		}
+		partials[w] = append(partials[w], partial{lo, acc})
+	})
+	chunks := slices.Concat(partials...)
+	slices.SortFunc(chunks, func(a, b partial) int { return a.lo - b.lo })
+	result := identity
+	for _, c := range chunks {
+		result = op(result, c.acc)
+	}
+	return result, report
+}
@@ -0,0 +1,241 @@
+// Tests and benchmarks for the OpenMP-like loops.
+//
+// The benchmarks run the same unbalanced loops with every schedule and report,
+// besides ns/op, the "imbalance" metric (busiest worker / average worker):
+//
+//     go test -run XXX -bench . parallel.go parallel_test.go
+//
+// Add -v to the benchmark command to also print the per-worker load table.
+// The benchmarks use 4 workers: busy times are only meaningful when the
+// machine has at least 4 cores (GOMAXPROCS >= 4), otherwise the workers are
+// time-sliced and the time a worker spends descheduled counts as busy.
+
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+var schedules = []Schedule{
+	{Static, 0},
+	{Static, 1},
+	{Static, 16},
+	{Dynamic, 1},
+	{Dynamic, 16},
+	{Guided, 1},
+	{Guided, 8},
+}
+
+func TestForVisitsEveryIterationOnce(t *testing.T) {
+	for _, workers := range []int{1, 3, 4, 8} {
+		SetNumWorkers(workers)
+		for _, s := range schedules {
+			for _, n := range []int{0, 1, 7, 100, 1001} {
+				visits := make([]atomic.Int32, n)
+				report := For(n, func(i int) { visits[i].Add(1) }, s)
+
+				for i := range visits {
+					if v := visits[i].Load(); v != 1 {
+						t.Fatalf("workers=%d schedule(%s) n=%d: iteration %d executed %d times", workers, s, n, i, v)
+					}
+				}
+				total := 0
+				for _, w := range report.Workers {
+					total += w.Iterations
+				}
+				if total != n || len(report.Workers) != workers {
+					t.Errorf("workers=%d schedule(%s) n=%d: report counts %d iterations on %d workers",
+						workers, s, n, total, len(report.Workers))
+				}
+			}
+		}
+	}
+}
+
+func TestStaticAssignment(t *testing.T) {
+	SetNumWorkers(4)
+	// schedule(static) on 10 iterations: blocks of 3, 3, 2, 2.
+	report := For(10, func(int) {}, Schedule{Static, 0})
+	for w, want := range []int{3, 3, 2, 2} {
+		if got := report.Workers[w]; got.Iterations != want || got.Chunks != 1 {
+			t.Errorf("worker %d: %d iterations in %d chunks, want %d in 1", w, got.Iterations, got.Chunks, want)
+		}
+	}
+	// schedule(static, 2) on 10 iterations: chunks 0,4 | 1 | 2 | 3 -> 4, 2, 2, 2 iterations.
+	report = For(10, func(int) {}, Schedule{Static, 2})
+	for w, want := range []int{4, 2, 2, 2} {
+		if got := report.Workers[w].Iterations; got != want {
+			t.Errorf("worker %d: %d iterations, want %d", w, got, want)
+		}
+	}
+}
+
+func TestGuidedChunksShrink(t *testing.T) {
+	SetNumWorkers(1)
+	// With a single worker the chunks are n/1, so one chunk covers everything.
+	if r := For(100, func(int) {}, Schedule{Guided, 1}); r.Workers[0].Chunks != 1 {
+		t.Errorf("guided on 1 worker used %d chunks, want 1", r.Workers[0].Chunks)
+	}
+	SetNumWorkers(4)
+	// 100 iterations on 4 workers: 25, 19, 14, 11, 8, 6, ... never below the minimum chunk.
+	r := For(100, func(int) {}, Schedule{Guided, 5})
+	chunks := 0
+	for _, w := range r.Workers {
+		chunks += w.Chunks
+	}
+	if chunks < 4 || chunks > 100/5 {
+		t.Errorf("guided,5 on 100 iterations used %d chunks", chunks)
+	}
+}
+
+func TestReduceMatchesSequential(t *testing.T) {
+	const n = 5000
+	values := make([]int, n)
+	sequential := 0
+	for i := range values {
+		values[i] = rand.Intn(1000)
+		sequential += values[i]
+	}
+	for _, workers := range []int{1, 2, 5, 8} {
+		SetNumWorkers(workers)
+		for _, s := range schedules {
+			sum, _ := Reduce(n, 0, func(i int) int { return values[i] }, func(a, b int) int { return a + b }, s)
+			if sum != sequential {
+				t.Errorf("workers=%d schedule(%s): reduce = %d, want %d", workers, s, sum, sequential)
+			}
+			best, _ := Reduce(n, -1, func(i int) int { return values[i] }, func(a, b int) int { return max(a, b) }, s)
+			if want := maxOf(values); best != want {
+				t.Errorf("workers=%d schedule(%s): max = %d, want %d", workers, s, best, want)
+			}
+		}
+	}
+}
+
+// Concatenation is associative but not commutative: the chunks of a worker
+// are not next to each other with the chunked and the dynamic schedules.
+func TestReduceKeepsOrder(t *testing.T) {
+	const n = 300
+	want := ""
+	for i := range n {
+		want += strconv.Itoa(i) + " "
+	}
+	for _, workers := range []int{1, 3, 4} {
+		SetNumWorkers(workers)
+		for _, s := range schedules {
+			got, _ := Reduce(n, "", func(i int) string { return strconv.Itoa(i) + " " }, func(a, b string) string { return a + b }, s)
+			if got != want {
+				t.Errorf("workers=%d schedule(%s): concatenation out of order", workers, s)
+			}
+		}
+	}
+}
+
+func TestNestedLoops(t *testing.T) {
+	const rows, cols = 20, 30
+	SetNumWorkers(4)
+	var cells [rows][cols]atomic.Int32
+	sums := make([]int, rows)
+	report := For(rows, func(r int) {
+		For(cols, func(c int) { cells[r][c].Add(1) }, Schedule{Dynamic, 1})
+		sums[r], _ = Reduce(cols, 0, func(c int) int { return c }, func(a, b int) int { return a + b }, Schedule{Static, 0})
+	}, Schedule{Dynamic, 1})
+
+	for r := range cells {
+		for c := range cells[r] {
+			if v := cells[r][c].Load(); v != 1 {
+				t.Fatalf("cell (%d, %d) visited %d times", r, c, v)
+			}
+		}
+		if sums[r] != cols*(cols-1)/2 {
+			t.Errorf("row %d: inner reduce = %d, want %d", r, sums[r], cols*(cols-1)/2)
+		}
+	}
+	if len(report.Workers) != 4 {
+		t.Errorf("outer loop on %d workers, want 4", len(report.Workers))
+	}
+}
+
+func TestConcurrentLoops(t *testing.T) {
+	SetNumWorkers(4)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for range 20 {
+				// A loop of another goroutine waits for the pool
+				if r := For(1000, func(i int) { spin(i) }, Schedule{Dynamic, 8}); len(r.Workers) != 4 {
+					t.Errorf("loop of a concurrent goroutine on %d workers, want 4", len(r.Workers))
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func maxOf(v []int) int {
+	m := -1
+	for _, x := range v {
+		m = max(m, x)
+	}
+	return m
+}
+
+// ============================================================
+//                 BENCHMARKS ON UNBALANCED LOOPS
+// ============================================================
+
+// spin burns CPU for a number of steps proportional to cost,
+// so iterations really take different amounts of time.
+func spin(cost int) float64 {
+	x := 0.0
+	for k := 0; k < cost; k++ {
+		x += float64(k) * 0.5
+	}
+	return x
+}
+
+var sink atomic.Int64
+
+func benchmarkLoop(b *testing.B, n int, cost func(i int) int) {
+	SetNumWorkers(4)
+	for _, s := range schedules {
+		b.Run(s.String(), func(b *testing.B) {
+			var last Report
+			imbalance := 0.0
+			for b.Loop() {
+				last = For(n, func(i int) {
+					sink.Add(int64(spin(cost(i))))
+				}, s)
+				imbalance += last.Imbalance()
+			}
+			b.ReportMetric(imbalance/float64(b.N), "imbalance")
+			b.Log("\n" + last.String())
+		})
+	}
+}
+
+// Triangular loop: iteration i costs i steps (e.g. the rows of a triangular
+// matrix). schedule(static) gives the last worker almost half of the work.
+func BenchmarkTriangular(b *testing.B) {
+	benchmarkLoop(b, 2000, func(i int) int { return 20 * i })
+}
+
+// Spiky loop: one iteration in 64 is 100 times more expensive than the others,
+// and the expensive ones are all in the first quarter of the iteration space.
+func BenchmarkSpiky(b *testing.B) {
+	benchmarkLoop(b, 4096, func(i int) int {
+		if i < 1024 && i%16 == 0 {
+			return 100000
+		}
+		return 1000
+	})
+}
+
+// Balanced loop, as a reference: here static has the lowest overhead.
+func BenchmarkUniform(b *testing.B) {
+	benchmarkLoop(b, 4096, func(int) int { return 2000 })
+}
@@ -0,0 +1,44 @@
+// -----------------------------------------------------------------------------------
+// PI AS AN INTEGRAL (OpenMP-style)
+//
+// pi is the integral of 4 / (1 + x*x) between 0 and 1. The midpoint rule on
+// STEPS intervals is a reduction: every iteration computes the height of one
+// rectangle, and Reduce sums them like reduction(+:sum), which the program
+// compares with the sequential sum.
+//
+// Run with:
+//     go run parallel.go pi.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const WORKERS = 4      // Number of workers
+const STEPS = 10000000 // Intervals of [0, 1]
+
+func f(i int) float64 {
+	x := (float64(i) + 0.5) / STEPS
+	return 4 / (1 + x*x)
+}
+
+func main() {
+	SetNumWorkers(WORKERS)
+
+	start := time.Now()
+	seq := 0.0
+	for i := 0; i < STEPS; i++ {
+		seq += f(i)
+	}
+	seq /= STEPS
+	fmt.Printf("sequential: pi ~ %.12f (error %.2e) in %v\n", seq, math.Abs(seq-math.Pi), time.Since(start))
+
+	sum, report := Reduce(STEPS, 0.0, f, func(a, b float64) float64 { return a + b }, Schedule{Static, 0})
+	pi := sum / STEPS
+	fmt.Printf("parallel:   pi ~ %.12f (error %.2e) in %v\n", pi, math.Abs(pi-math.Pi), report.Elapsed)
+	fmt.Print(report)
+}
@@ -0,0 +1,47 @@
+// -----------------------------------------------------------------------------------
+// COUNTING PRIMES (OpenMP-style)
+//
+// Whether i is prime is tested by trial division, so the iterations get more
+// expensive as i grows: the loop is unbalanced. The same loop runs with every
+// schedule, and the report of each shows how the iterations and the busy time
+// were split among the workers.
+//
+// Run with:
+//     go run parallel.go primes.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+)
+
+const WORKERS = 4 // Number of workers
+const N = 2000000 // Numbers tested: [0, N)
+
+// isPrime tests n by trial division.
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for d := 2; d*d <= n; d++ {
+		if n%d == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func main() {
+	SetNumWorkers(WORKERS)
+
+	for _, s := range []Schedule{{Static, 0}, {Static, 1000}, {Dynamic, 1000}, {Guided, 100}} {
+		count, report := Reduce(N, 0, func(i int) int {
+			if isPrime(i) {
+				return 1
+			}
+			return 0
+		}, func(a, b int) int { return a + b }, s)
+		fmt.Printf("%d primes below %d\n%v\n", count, N, report)
+	}
+}
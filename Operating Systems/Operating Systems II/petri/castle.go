@@ -0,0 +1,126 @@
+// -----------------------------------------------------------------------------------
+// PETRI NET OF THE CASTLE ROAD (writtenExams/09-01-2023)
+//
+// The places are the counters of castle() and the request channels, the
+// transitions its cases and the steps of tourist() and snowplow():
+//
+//     castle()                                 net
+//     --------                                 ---
+//     freeStandardSpots, freeMaxiSpots         places of the free spots
+//     numCarsOnRoad[d], numCampersOnRoad[d]    places of the vehicles on the road
+//     snowplowActive                           the snowplow on the road, uphill
+//                                              or downhill
+//     len(startUphill[t]), len(startDownhill[t])
+//                                              places of the vehicles waiting
+//     case <-when(guard, startUphill[CAMPER])  "CAMPER starts uphill", with an
+//                                              inhibitor arc from every counter
+//                                              that must be 0
+//
+// A car takes a standard spot if there is one, a maxi spot otherwise: the case
+// is two transitions, and a car remembers its spot (the parkingType of its
+// Parking) by being in the place of cars with that spot. The sums of the guards
+// (numCampersOnRoad[DOWNHILL]+numCarsOnRoad[DOWNHILL] == 0) are inhibitor arcs
+// from every term. The times of the vehicles are not modelled: every order of
+// the steps is possible. The tourists arrive all at once, as with the default
+// workload, and the snowplow goes down and up forever: the net does not model
+// the termination (stop).
+//
+// Run with:
+//     go run petri.go castle.go
+//     go run petri.go castle.go -pnml castle.pnml -play 40
+// -----------------------------------------------------------------------------------
+
+package main
+
+// Sizes of the net: small, the reachability graph grows exponentially with them.
+const (
+	CARS           = 2
+	CAMPERS        = 2
+	STANDARD_SPOTS = 1
+	MAXI_SPOTS     = 1
+)
+
+func castleNet() *Net {
+	n := NewNet("castle")
+
+	// Vehicles waiting for the castle.
+	upCar := n.Place("startUphill[CAR]", CARS)
+	upCamper := n.Place("startUphill[CAMPER]", CAMPERS)
+	upPlow := n.Place("startUphill[SNOWPLOW]", 0)
+	downCarStd := n.Place("startDownhill[CAR] (STANDARD)", 0)
+	downCarMaxi := n.Place("startDownhill[CAR] (MAXI)", 0)
+	downCamper := n.Place("startDownhill[CAMPER]", 0)
+	downPlow := n.Place("startDownhill[SNOWPLOW]", 0)
+
+	// Counters of the castle.
+	freeStd := n.Place("freeStandardSpots", STANDARD_SPOTS)
+	freeMaxi := n.Place("freeMaxiSpots", MAXI_SPOTS)
+	carsUpStd := n.Place("numCarsOnRoad[UPHILL] (STANDARD)", 0)
+	carsUpMaxi := n.Place("numCarsOnRoad[UPHILL] (MAXI)", 0)
+	campersUp := n.Place("numCampersOnRoad[UPHILL]", 0)
+	carsDown := n.Place("numCarsOnRoad[DOWNHILL]", 0)
+	campersDown := n.Place("numCampersOnRoad[DOWNHILL]", 0)
+	plowUp := n.Place("snowplowActive (UPHILL)", 0)
+	plowDown := n.Place("snowplowActive (DOWNHILL)", 0)
+
+	// Where the vehicles are when they do not wait for the castle.
+	parkedStd := n.Place("CAR at the castle (STANDARD)", 0)
+	parkedMaxi := n.Place("CAR at the castle (MAXI)", 0)
+	camperParked := n.Place("CAMPER at the castle", 0)
+	carsGone := n.Place("CAR gone", 0)
+	campersGone := n.Place("CAMPER gone", 0)
+	plowCastle := n.Place("SNOWPLOW at the castle", 1)
+	plowValley := n.Place("SNOWPLOW in the valley", 0)
+
+	// === UPHILL REQUESTS ===
+	n.Transition("CAMPER starts uphill").
+		In(upCamper, 1).In(freeMaxi, 1).Out(campersUp, 1).
+		Zero(campersDown, carsDown, plowUp, plowDown, downCamper, downCarStd, downCarMaxi, downPlow)
+	n.Transition("CAR starts uphill (STANDARD)").
+		In(upCar, 1).In(freeStd, 1).Out(carsUpStd, 1).
+		Zero(campersDown, plowUp, plowDown, upCamper, downCamper, downCarStd, downCarMaxi, downPlow)
+	n.Transition("CAR starts uphill (MAXI)").
+		In(upCar, 1).In(freeMaxi, 1).Out(carsUpMaxi, 1).
+		Zero(freeStd, campersDown, plowUp, plowDown, upCamper, downCamper, downCarStd, downCarMaxi, downPlow)
+	n.Transition("SNOWPLOW starts uphill").
+		In(upPlow, 1).Out(plowUp, 1).
+		Zero(campersDown, carsDown, campersUp, carsUpStd, carsUpMaxi, upCamper, upCar, downCamper, downCarStd, downCarMaxi)
+
+	// === UPHILL COMPLETIONS ===
+	n.Transition("CAMPER arrives").In(campersUp, 1).Out(camperParked, 1)
+	n.Transition("CAR arrives (STANDARD)").In(carsUpStd, 1).Out(parkedStd, 1)
+	n.Transition("CAR arrives (MAXI)").In(carsUpMaxi, 1).Out(parkedMaxi, 1)
+	n.Transition("SNOWPLOW arrives").In(plowUp, 1).Out(plowCastle, 1)
+
+	// === THE VEHICLES ASK TO LEAVE ===
+	n.Transition("CAMPER asks to leave").In(camperParked, 1).Out(downCamper, 1)
+	n.Transition("CAR asks to leave (STANDARD)").In(parkedStd, 1).Out(downCarStd, 1)
+	n.Transition("CAR asks to leave (MAXI)").In(parkedMaxi, 1).Out(downCarMaxi, 1)
+	n.Transition("SNOWPLOW asks to leave").In(plowCastle, 1).Out(downPlow, 1)
+	n.Transition("SNOWPLOW asks to return").In(plowValley, 1).Out(upPlow, 1)
+
+	// === DOWNHILL REQUESTS ===
+	n.Transition("CAMPER starts downhill").
+		In(downCamper, 1).Out(campersDown, 1).Out(freeMaxi, 1).
+		Zero(campersUp, carsUpStd, carsUpMaxi, plowUp, plowDown, downPlow)
+	n.Transition("CAR starts downhill (STANDARD)").
+		In(downCarStd, 1).Out(carsDown, 1).Out(freeStd, 1).
+		Zero(campersUp, plowUp, plowDown, downPlow, downCamper)
+	n.Transition("CAR starts downhill (MAXI)").
+		In(downCarMaxi, 1).Out(carsDown, 1).Out(freeMaxi, 1).
+		Zero(campersUp, plowUp, plowDown, downPlow, downCamper)
+	n.Transition("SNOWPLOW starts downhill").
+		In(downPlow, 1).Out(plowDown, 1).
+		Zero(campersDown, carsDown, campersUp, carsUpStd, carsUpMaxi)
+
+	// === DOWNHILL COMPLETIONS ===
+	n.Transition("CAMPER exits").In(campersDown, 1).Out(campersGone, 1)
+	n.Transition("CAR exits").In(carsDown, 1).Out(carsGone, 1)
+	n.Transition("SNOWPLOW exits").In(plowDown, 1).Out(plowValley, 1)
+
+	return n
+}
+
+func main() {
+	Main(castleNet())
+}
@@ -0,0 +1,563 @@
+// -----------------------------------------------------------------------------------
+// PETRI NETS OF THE SERVERS: PNML EXPORT, TOKEN GAME, REACHABILITY
+//
+// The state of a server of the course is a few counters (freeMaxiSpots,
+// numCarsOnRoad[UPHILL], activePrel[TYPE_A], ...) and its guarded cases change
+// them: the counters are the places of a Petri net and the cases are its
+// transitions. The clients are tokens too: a client waiting in a request channel
+// is a token in the place of the channel (len(ch) is its marking), a client on
+// the road or in the warehouse a token in the place of what it is doing.
+//
+// A net is built with a few calls:
+//
+//     n := NewNet("castle")
+//     free := n.Place("freeMaxiSpots", MAXI_SPOTS)      // a place and its initial tokens
+//     up := n.Place("numCampersOnRoad[UPHILL]", 0)
+//     n.Transition("CAMPER starts uphill").
+//         In(queue, 1).In(free, 1).Out(up, 1).         // arcs and their weights
+//         Zero(down, plow)                             // guard: these places are empty
+//
+// A transition is enabled when its input places have the tokens of the arcs and
+// its inhibitor places fewer than the weight of the arc (Zero: none): guards like
+// numCarsOnRoad[DOWNHILL] == 0 or len(startUphill[CAMPER]) == 0 are inhibitor
+// arcs, guards like freeMaxiSpots > 0 are input arcs of the tokens taken. Guards
+// that compare two counters, or multiply them, are not P/T nets: the nets of the
+// scenarios (castle.go, warehouse.go) say how they model them.
+//
+// With the net, the tool:
+//   - writes it in PNML (ISO/IEC 15909-2, P/T net), to open it in a Petri net
+//     editor (PIPE, TINA, ePNK, ...); the inhibitor arcs have <type
+//     value="inhibitor"/>, as in PIPE, since P/T nets have none;
+//   - plays the token game: from the initial marking it fires, step by step, an
+//     enabled transition chosen at random, until none is enabled;
+//   - computes the reachability graph of the initial marking, breadth first up
+//     to -limit markings: the bound of every place, the markings where no
+//     transition is enabled (deadlocks, a shortest firing sequence to each) and,
+//     when the net is unbounded, the places that grow and the sequence that pumps
+//     them (a marking that covers one of its ancestors, in places without
+//     inhibitor arcs: the sequence can be fired again forever), and the dead
+//     transitions, never enabled: cases of the server that can never fire.
+// A deadlock of the net is a state where the server blocks with clients still
+// waiting, structurally: the net knows nothing of the times of the clients.
+// Keep the markings small, a few clients and spots: the graph grows
+// exponentially with them.
+//
+// The library has no main: compile it together with the net of a scenario
+//     go run petri.go castle.go [-pnml castle.pnml] [-play 50] [-seed N] [-limit 100000]
+//     go run petri.go warehouse.go -pnml -
+// and run the tests with
+//     go test petri.go petri_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ============================================================
+//                           NETS
+// ============================================================
+
+// Net is a place/transition net with inhibitor arcs.
+type Net struct {
+	Name        string
+	Places      []*Place
+	Transitions []*Transition
+}
+
+// Place is a place of a Net and its initial tokens.
+type Place struct {
+	Name    string
+	Initial int
+	index   int
+}
+
+// Transition is a transition of a Net with its arcs.
+type Transition struct {
+	Name       string
+	Inputs     []Arc // tokens taken
+	Outputs    []Arc // tokens produced
+	Inhibitors []Arc // enabled only with fewer tokens than the weight
+}
+
+// Arc joins a transition to a place, with a weight.
+type Arc struct {
+	Place  *Place
+	Weight int
+}
+
+// Marking is the number of tokens of every place, in the order of Net.Places.
+type Marking []int
+
+// NewNet creates an empty net.
+func NewNet(name string) *Net { return &Net{Name: name} }
+
+// Place adds a place with the given initial tokens.
+func (n *Net) Place(name string, initial int) *Place {
+	p := &Place{Name: name, Initial: initial, index: len(n.Places)}
+	n.Places = append(n.Places, p)
+	return p
+}
+
+// Transition adds a transition without arcs.
+func (n *Net) Transition(name string) *Transition {
+	t := &Transition{Name: name}
+	n.Transitions = append(n.Transitions, t)
+	return t
+}
+
+// In adds an input arc: firing t takes w tokens from p.
+func (t *Transition) In(p *Place, w int) *Transition {
+	t.Inputs = append(t.Inputs, Arc{p, w})
+	return t
+}
+
+// Out adds an output arc: firing t puts w tokens in p.
+func (t *Transition) Out(p *Place, w int) *Transition {
+	t.Outputs = append(t.Outputs, Arc{p, w})
+	return t
+}
+
+// Below adds an inhibitor arc: t is enabled only if p has fewer than w tokens.
+func (t *Transition) Below(p *Place, w int) *Transition {
+	t.Inhibitors = append(t.Inhibitors, Arc{p, w})
+	return t
+}
+
+// Zero adds inhibitor arcs of weight 1: t is enabled only if the places are empty.
+func (t *Transition) Zero(ps ...*Place) *Transition {
+	for _, p := range ps {
+		t.Below(p, 1)
+	}
+	return t
+}
+
+// Initial returns the initial marking.
+func (n *Net) Initial() Marking {
+	m := make(Marking, len(n.Places))
+	for i, p := range n.Places {
+		m[i] = p.Initial
+	}
+	return m
+}
+
+// Enabled reports whether t can fire in m.
+func (t *Transition) Enabled(m Marking) bool {
+	for _, a := range t.Inputs {
+		if m[a.Place.index] < a.Weight {
+			return false
+		}
+	}
+	for _, a := range t.Inhibitors {
+		if m[a.Place.index] >= a.Weight {
+			return false
+		}
+	}
+	return true
+}
+
+// Fire returns the marking after t fires in m; t must be enabled.
+func (t *Transition) Fire(m Marking) Marking {
+	next := slices.Clone(m)
+	for _, a := range t.Inputs {
+		next[a.Place.index] -= a.Weight
+	}
+	for _, a := range t.Outputs {
+		next[a.Place.index] += a.Weight
+	}
+	return next
+}
+
+// Enabled returns the transitions enabled in m, in order.
+func (n *Net) Enabled(m Marking) []*Transition {
+	var enabled []*Transition
+	for _, t := range n.Transitions {
+		if t.Enabled(m) {
+			enabled = append(enabled, t)
+		}
+	}
+	return enabled
+}
+
+// Format writes the places of m with tokens, as name=tokens.
+func (n *Net) Format(m Marking) string {
+	var parts []string
+	for i, k := range m {
+		if k != 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", n.Places[i].Name, k))
+		}
+	}
+	if len(parts) == 0 {
+		return "(empty)"
+	}
+	return strings.Join(parts, " ")
+}
+
+// ============================================================
+//                           PNML
+// ============================================================
+
+type pnmlText struct {
+	Text string `xml:"text"`
+}
+
+type pnmlPlace struct {
+	ID             string    `xml:"id,attr"`
+	Name           pnmlText  `xml:"name"`
+	InitialMarking *pnmlText `xml:"initialMarking,omitempty"`
+}
+
+type pnmlTransition struct {
+	ID   string   `xml:"id,attr"`
+	Name pnmlText `xml:"name"`
+}
+
+type pnmlType struct {
+	Value string `xml:"value,attr"`
+}
+
+type pnmlArc struct {
+	ID          string    `xml:"id,attr"`
+	Source      string    `xml:"source,attr"`
+	Target      string    `xml:"target,attr"`
+	Inscription *pnmlText `xml:"inscription,omitempty"`
+	Type        *pnmlType `xml:"type,omitempty"`
+}
+
+type pnmlDoc struct {
+	XMLName xml.Name `xml:"pnml"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Net     struct {
+		ID   string   `xml:"id,attr"`
+		Type string   `xml:"type,attr"`
+		Name pnmlText `xml:"name"`
+		Page struct {
+			ID          string           `xml:"id,attr"`
+			Places      []pnmlPlace      `xml:"place"`
+			Transitions []pnmlTransition `xml:"transition"`
+			Arcs        []pnmlArc        `xml:"arc"`
+		} `xml:"page"`
+	} `xml:"net"`
+}
+
+// WritePNML writes the net in PNML.
+func (n *Net) WritePNML(w io.Writer) error {
+	var doc pnmlDoc
+	doc.XMLNS = "http://www.pnml.org/version-2009/grammar/pnml"
+	doc.Net.ID = n.Name
+	doc.Net.Type = "http://www.pnml.org/version-2009/grammar/ptnet"
+	doc.Net.Name.Text = n.Name
+	doc.Net.Page.ID = "page0"
+	for i, p := range n.Places {
+		pp := pnmlPlace{ID: fmt.Sprintf("p%d", i), Name: pnmlText{p.Name}}
+		if p.Initial > 0 {
+			pp.InitialMarking = &pnmlText{fmt.Sprint(p.Initial)}
+		}
+		doc.Net.Page.Places = append(doc.Net.Page.Places, pp)
+	}
+	arc := func(source, target string, a Arc, kind string) {
+		pa := pnmlArc{ID: fmt.Sprintf("a%d", len(doc.Net.Page.Arcs)), Source: source, Target: target}
+		if a.Weight != 1 {
+			pa.Inscription = &pnmlText{fmt.Sprint(a.Weight)}
+		}
+		if kind != "" {
+			pa.Type = &pnmlType{kind}
+		}
+		doc.Net.Page.Arcs = append(doc.Net.Page.Arcs, pa)
+	}
+	for i, t := range n.Transitions {
+		id := fmt.Sprintf("t%d", i)
+		doc.Net.Page.Transitions = append(doc.Net.Page.Transitions, pnmlTransition{id, pnmlText{t.Name}})
+		for _, a := range t.Inputs {
+			arc(fmt.Sprintf("p%d", a.Place.index), id, a, "")
+		}
+		for _, a := range t.Outputs {
+			arc(id, fmt.Sprintf("p%d", a.Place.index), a, "")
+		}
+		for _, a := range t.Inhibitors {
+			arc(fmt.Sprintf("p%d", a.Place.index), id, a, "inhibitor")
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ============================================================
+//                        TOKEN GAME
+// ============================================================
+
+// Play fires up to steps transitions from the initial marking, each chosen at
+// random among the enabled ones, and writes them; it returns the final marking
+// and whether no transition was enabled there.
+func (n *Net) Play(w io.Writer, steps int, rng *rand.Rand) (Marking, bool) {
+	m := n.Initial()
+	fmt.Fprintf(w, "TOKEN GAME of %s\n", n.Name)
+	fmt.Fprintf(w, "%5d  %s\n", 0, n.Format(m))
+	for i := 1; i <= steps; i++ {
+		enabled := n.Enabled(m)
+		if len(enabled) == 0 {
+			fmt.Fprintf(w, "DEADLOCK after %d steps: no transition is enabled\n", i-1)
+			return m, true
+		}
+		t := enabled[rng.Intn(len(enabled))]
+		m = t.Fire(m)
+		fmt.Fprintf(w, "%5d  fire %q (%d enabled)\n       %s\n", i, t.Name, len(enabled), n.Format(m))
+	}
+	return m, len(n.Enabled(m)) == 0
+}
+
+// ============================================================
+//                        REACHABILITY
+// ============================================================
+
+// Reachability is what the exploration of the markings reachable from the
+// initial one found.
+type Reachability struct {
+	Markings, Arcs int
+	Complete       bool  // every reachable marking was explored
+	Bounds         []int // most tokens of every place in the markings explored
+	Deadlocks      []Path
+	Dead           []*Transition // never enabled in the markings explored
+
+	// If the net is unbounded: a firing sequence from From to a marking with
+	// more tokens in Grows and no fewer elsewhere.
+	Unbounded *Path
+	From      Marking
+	Grows     []*Place
+}
+
+// Path is a firing sequence and the marking it reaches.
+type Path struct {
+	Firings []*Transition
+	Marking Marking
+}
+
+// node is a marking of the reachability graph and how it was first reached.
+type node struct {
+	m      Marking
+	parent *node
+	via    *Transition
+}
+
+func (x *node) path() Path {
+	var firings []*Transition
+	for y := x; y.parent != nil; y = y.parent {
+		firings = append(firings, y.via)
+	}
+	slices.Reverse(firings)
+	return Path{firings, x.m}
+}
+
+// Reach explores, breadth first, the markings reachable from the initial one,
+// up to limit markings. It stops early when it finds that the net is unbounded;
+// the dead transitions are those never enabled in the markings explored.
+func (n *Net) Reach(limit int) (r Reachability) {
+	inhibited := make([]bool, len(n.Places))
+	for _, t := range n.Transitions {
+		for _, a := range t.Inhibitors {
+			inhibited[a.Place.index] = true
+		}
+	}
+
+	r = Reachability{Bounds: n.Initial()}
+	start := &node{m: n.Initial()}
+	seen := map[string]bool{key(start.m): true}
+	queue := []*node{start}
+	fired := map[*Transition]bool{}
+	defer func() {
+		for _, t := range n.Transitions {
+			if !fired[t] {
+				r.Dead = append(r.Dead, t)
+			}
+		}
+	}()
+	for len(queue) > 0 {
+		x := queue[0]
+		queue = queue[1:]
+		r.Markings++
+		enabled := n.Enabled(x.m)
+		if len(enabled) == 0 {
+			r.Deadlocks = append(r.Deadlocks, x.path())
+		}
+		for _, t := range enabled {
+			fired[t] = true
+			y := &node{t.Fire(x.m), x, t}
+			r.Arcs++
+			k := key(y.m)
+			if seen[k] {
+				continue
+			}
+			if from, grows := pumps(y, inhibited); grows != nil {
+				path := y.path()
+				path.Firings = path.Firings[len(from.path().Firings):]
+				r.Unbounded, r.From = &path, from.m
+				for _, i := range grows {
+					r.Grows = append(r.Grows, n.Places[i])
+				}
+				return r
+			}
+			if len(seen) >= limit {
+				return r
+			}
+			seen[k] = true
+			for i, v := range y.m {
+				r.Bounds[i] = max(r.Bounds[i], v)
+			}
+			queue = append(queue, y)
+		}
+	}
+	r.Complete = true
+	return r
+}
+
+// pumps looks for an ancestor of y that y covers with more tokens only in places
+// without inhibitor arcs: the firings from it to y can then be repeated forever,
+// and the places that grow are unbounded. It returns the ancestor and the places.
+func pumps(y *node, inhibited []bool) (*node, []int) {
+	for x := y.parent; x != nil; x = x.parent {
+		var grows []int
+		covers := true
+		for i := range y.m {
+			if y.m[i] < x.m[i] || (y.m[i] > x.m[i] && inhibited[i]) {
+				covers = false
+				break
+			}
+			if y.m[i] > x.m[i] {
+				grows = append(grows, i)
+			}
+		}
+		if covers && grows != nil {
+			return x, grows
+		}
+	}
+	return nil, nil
+}
+
+func key(m Marking) string { return fmt.Sprint([]int(m)) }
+
+// Report writes the result of the exploration.
+func (n *Net) Report(w io.Writer, r Reachability, limit int) {
+	fmt.Fprintf(w, "REACHABILITY of %s: %d places, %d transitions\n", n.Name, len(n.Places), len(n.Transitions))
+	switch {
+	case r.Unbounded != nil:
+		fmt.Fprintf(w, "%d markings explored, %d arcs: the net is UNBOUNDED\n", r.Markings, r.Arcs)
+	case r.Complete:
+		fmt.Fprintf(w, "%d reachable markings, %d arcs: the net is bounded\n", r.Markings, r.Arcs)
+	default:
+		fmt.Fprintf(w, "%d markings explored, %d arcs: stopped at -limit %d, the results are partial\n", r.Markings, r.Arcs, limit)
+	}
+
+	if r.Unbounded != nil {
+		var grows []string
+		for _, p := range r.Grows {
+			grows = append(grows, p.Name)
+		}
+		fmt.Fprintf(w, "growing places: %s\n", strings.Join(grows, ", "))
+		fmt.Fprintf(w, "  from  %s\n", n.Format(r.From))
+		fmt.Fprintf(w, "  fire  %s\n", firings(r.Unbounded.Firings))
+		fmt.Fprintf(w, "  to    %s\n", n.Format(r.Unbounded.Marking))
+		fmt.Fprintf(w, "  and again, forever\n")
+		return
+	}
+
+	fmt.Fprintf(w, "\n%-40s %6s %6s\n", "place", "start", "bound")
+	for i, p := range n.Places {
+		fmt.Fprintf(w, "%-40s %6d %6d\n", p.Name, p.Initial, r.Bounds[i])
+	}
+
+	if len(r.Dead) > 0 {
+		fmt.Fprintf(w, "\nDEAD TRANSITIONS: never enabled\n")
+		for _, t := range r.Dead {
+			fmt.Fprintf(w, "  %s\n", t.Name)
+		}
+	}
+
+	fmt.Fprintln(w)
+	if len(r.Deadlocks) == 0 {
+		fmt.Fprintln(w, "No deadlocks: some transition is enabled in every marking explored")
+		return
+	}
+	fmt.Fprintf(w, "DEADLOCKS: %d markings where no transition is enabled\n", len(r.Deadlocks))
+	for i, d := range r.Deadlocks {
+		if i == 5 {
+			fmt.Fprintf(w, "  ... and %d more\n", len(r.Deadlocks)-i)
+			break
+		}
+		fmt.Fprintf(w, "  %s\n", n.Format(d.Marking))
+		fmt.Fprintf(w, "    after %d firings: %s\n", len(d.Firings), firings(d.Firings))
+	}
+}
+
+func firings(ts []*Transition) string {
+	var names []string
+	for _, t := range ts {
+		names = append(names, t.Name)
+	}
+	return strings.Join(names, "; ")
+}
+
+// ============================================================
+//                           MAIN
+// ============================================================
+
+var (
+	pnmlFlag  = flag.String("pnml", "", "write the net in PNML to `file` (- for the standard output)")
+	playFlag  = flag.Int("play", 0, "play the token game for `n` steps")
+	seedFlag  = flag.Int64("seed", 0, "seed of the token game (0: from the clock)")
+	limitFlag = flag.Int("limit", 100000, "explore at most `n` markings")
+	reachFlag = flag.Bool("reach", true, "compute the reachability graph")
+)
+
+// Main runs what the flags ask on the net of a scenario: the main of castle.go
+// and warehouse.go.
+func Main(n *Net) {
+	flag.Parse()
+	if *pnmlFlag != "" {
+		w := os.Stdout
+		if *pnmlFlag != "-" {
+			f, err := os.Create(*pnmlFlag)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := n.WritePNML(w); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if *pnmlFlag == "-" {
+			return
+		}
+	}
+	if *playFlag > 0 {
+		seed := *seedFlag
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		n.Play(os.Stdout, *playFlag, rand.New(rand.NewSource(seed)))
+		fmt.Println()
+	}
+	if *reachFlag {
+		n.Report(os.Stdout, n.Reach(*limitFlag), *limitFlag)
+	}
+}
@@ -0,0 +1,172 @@
+// Tests for the Petri nets: firing with weights and inhibitor arcs, the
+// reachability graph of small nets with a known answer, the token game and the
+// PNML export.
+//
+// Run with:
+//     go test petri.go petri_test.go
+
+package main
+
+import (
+	"encoding/xml"
+	"math/rand"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFire(t *testing.T) {
+	n := NewNet("fire")
+	a := n.Place("a", 3)
+	b := n.Place("b", 0)
+	c := n.Place("c", 1)
+	take2 := n.Transition("take2").In(a, 2).Out(b, 1)
+	below2 := n.Transition("below2").In(a, 1).Below(b, 2).Out(b, 1)
+	empty := n.Transition("empty").In(a, 1).Zero(c)
+
+	m := n.Initial()
+	if !take2.Enabled(m) || !below2.Enabled(m) || empty.Enabled(m) {
+		t.Fatalf("in %v: take2 %v, below2 %v, empty %v; want true, true, false",
+			m, take2.Enabled(m), below2.Enabled(m), empty.Enabled(m))
+	}
+	m = take2.Fire(m)
+	if want := (Marking{1, 1, 1}); !slices.Equal(m, want) {
+		t.Fatalf("after take2: %v, want %v", m, want)
+	}
+	m = below2.Fire(m)
+	if below2.Enabled(m) || take2.Enabled(m) {
+		t.Errorf("in %v: below2 or take2 enabled", m)
+	}
+	if got := n.Format(m); got != "b=2 c=1" {
+		t.Errorf("Format: %q", got)
+	}
+}
+
+// philosophers returns the dining philosophers, each taking the left fork and
+// then the right one: they deadlock when each holds the left fork.
+func philosophers(k int) *Net {
+	n := NewNet("philosophers")
+	var forks, thinking, left, eating []*Place
+	for range k {
+		forks = append(forks, n.Place("fork", 1))
+		thinking = append(thinking, n.Place("thinking", 1))
+		left = append(left, n.Place("left", 0))
+		eating = append(eating, n.Place("eating", 0))
+	}
+	for i := range k {
+		right := forks[(i+1)%k]
+		n.Transition("take left").In(thinking[i], 1).In(forks[i], 1).Out(left[i], 1)
+		n.Transition("take right").In(left[i], 1).In(right, 1).Out(eating[i], 1)
+		n.Transition("put down").In(eating[i], 1).Out(forks[i], 1).Out(right, 1).Out(thinking[i], 1)
+	}
+	return n
+}
+
+func TestReachDeadlock(t *testing.T) {
+	n := philosophers(3)
+	r := n.Reach(1000)
+	if !r.Complete || r.Unbounded != nil {
+		t.Fatalf("complete %v, unbounded %v: want a complete exploration", r.Complete, r.Unbounded != nil)
+	}
+	if len(r.Deadlocks) != 1 {
+		t.Fatalf("%d deadlocks, want 1", len(r.Deadlocks))
+	}
+	d := r.Deadlocks[0]
+	if len(d.Firings) != 3 || n.Format(d.Marking) != "left=1 left=1 left=1" {
+		t.Errorf("deadlock %s after %d firings, want every left fork taken after 3", n.Format(d.Marking), len(d.Firings))
+	}
+	for i, b := range r.Bounds {
+		if b != 1 {
+			t.Errorf("place %d: bound %d, want 1", i, b)
+		}
+	}
+	if len(r.Dead) != 0 {
+		t.Errorf("dead transitions: %v", r.Dead)
+	}
+}
+
+func TestReachUnbounded(t *testing.T) {
+	n := NewNet("producer")
+	ready := n.Place("ready", 1)
+	buffer := n.Place("buffer", 0)
+	done := n.Place("done", 0)
+	n.Transition("produce").In(ready, 1).Out(ready, 1).Out(buffer, 1)
+	n.Transition("stop").In(ready, 1).Out(done, 1)
+	never := n.Transition("never").In(done, 1).In(buffer, 100)
+
+	r := n.Reach(1000)
+	if r.Unbounded == nil || len(r.Grows) != 1 || r.Grows[0] != buffer {
+		t.Fatalf("unbounded %v, growing %v: want buffer to grow", r.Unbounded != nil, r.Grows)
+	}
+	if len(r.Unbounded.Firings) != 1 || r.Unbounded.Firings[0].Name != "produce" {
+		t.Errorf("pumped by %v, want produce", r.Unbounded.Firings)
+	}
+	if !slices.Contains(r.Dead, never) {
+		t.Errorf("dead transitions %v, want never among them", r.Dead)
+	}
+
+	// With an inhibitor arc on the buffer the producer stops at 3 items:
+	// covering a marking does not prove that the net is unbounded.
+	n = NewNet("bounded producer")
+	ready = n.Place("ready", 1)
+	buffer = n.Place("buffer", 0)
+	n.Transition("produce").In(ready, 1).Out(ready, 1).Out(buffer, 1).Below(buffer, 3)
+	r = n.Reach(1000)
+	if !r.Complete || r.Markings != 4 || r.Bounds[buffer.index] != 3 {
+		t.Errorf("complete %v, %d markings, buffer bound %d: want true, 4, 3", r.Complete, r.Markings, r.Bounds[buffer.index])
+	}
+
+	// A limit stops the exploration of a large net.
+	r = philosophers(8).Reach(100)
+	if r.Complete || r.Markings > 100 {
+		t.Errorf("complete %v after %d markings with limit 100", r.Complete, r.Markings)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	var out strings.Builder
+	for seed := range int64(20) {
+		out.Reset()
+		m, dead := philosophers(2).Play(&out, 1000, rand.New(rand.NewSource(seed)))
+		if dead {
+			if !strings.Contains(out.String(), "DEADLOCK") || slices.Max(m) != 1 {
+				t.Errorf("seed %d: deadlock in %v:\n%s", seed, m, out.String())
+			}
+			return
+		}
+	}
+	t.Error("no seed of 20 reached the deadlock of two philosophers in 1000 steps")
+}
+
+func TestPNML(t *testing.T) {
+	n := NewNet("pnml")
+	a := n.Place("a<1>", 2)
+	b := n.Place("b", 0)
+	n.Transition("t & u").In(a, 2).Out(b, 1).Zero(b)
+
+	var out strings.Builder
+	if err := n.WritePNML(&out); err != nil {
+		t.Fatal(err)
+	}
+	var doc pnmlDoc
+	if err := xml.Unmarshal([]byte(out.String()), &doc); err != nil {
+		t.Fatalf("%v in:\n%s", err, out.String())
+	}
+	page := doc.Net.Page
+	if len(page.Places) != 2 || page.Places[0].Name.Text != "a<1>" || page.Places[0].InitialMarking.Text != "2" ||
+		page.Places[1].InitialMarking != nil {
+		t.Errorf("places %+v", page.Places)
+	}
+	if len(page.Transitions) != 1 || page.Transitions[0].Name.Text != "t & u" {
+		t.Errorf("transitions %+v", page.Transitions)
+	}
+	want := []pnmlArc{
+		{ID: "a0", Source: "p0", Target: "t0", Inscription: &pnmlText{"2"}},
+		{ID: "a1", Source: "t0", Target: "p1"},
+		{ID: "a2", Source: "p1", Target: "t0", Type: &pnmlType{"inhibitor"}},
+	}
+	if !reflect.DeepEqual(page.Arcs, want) {
+		t.Errorf("arcs %+v, want %+v", page.Arcs, want)
+	}
+}
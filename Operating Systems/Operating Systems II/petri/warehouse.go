@@ -0,0 +1,122 @@
+// -----------------------------------------------------------------------------------
+// PETRI NET OF THE WAREHOUSE (writtenExams/template.go, rendezvous/warehouse.go)
+//
+// The places are the counters of warehouse() and the request channels, the
+// transitions its cases and the steps of client() and supplier():
+//
+//     warehouse()                              net
+//     -----------                              ---
+//     activePrel[t], activeRestock[t]          places of the same name
+//     resources[t]                             free lots + activePrel[t]
+//     len(requestChan[t]), len(restockChan[t]) places of the clients and the
+//                                              suppliers waiting
+//
+// The guard of a retrieval, LOT*(activePrel[t]+1) <= resources[t], compares two
+// counters: the net counts the resources in lots, and keeps in a place the lots
+// that are not being retrieved, resources[t]/LOT - activePrel[t], so the guard
+// is an input arc from it. A retrieval takes a lot when it starts and the lot is
+// used when it ends; a restock puts the used lots back one at a time, and ends
+// when there are none. The lots of the three types differ (LOT_A, LOT_B,
+// LOT_MIX): the net has one lot per retrieval of every type, of LOTS_A and LOTS_B
+// per resource.
+//
+// The guards of the restocks also compare the resources of the two types: when
+// both suppliers wait, the one with fewer resources goes first. The net has no
+// such comparison and lets either go first: it has more behaviours than the
+// server, so a deadlock of the net may be impossible in the server, but a
+// marking that the net cannot reach the server cannot reach either. The clients
+// repeat their retrievals forever, and the suppliers their restocks.
+//
+// Run with:
+//     go run petri.go warehouse.go
+//     go run petri.go warehouse.go -pnml warehouse.pnml -play 40
+// -----------------------------------------------------------------------------------
+
+package main
+
+// Sizes of the net: small, the reachability graph grows exponentially with them.
+const (
+	CLIENTS = 2
+	LOTS_A  = 2 // lots of resource A in the full warehouse
+	LOTS_B  = 2 // lots of resource B in the full warehouse
+)
+
+const (
+	TYPE_A   = 0
+	TYPE_B   = 1
+	TYPE_MIX = 2
+)
+
+var typeNames = []string{"TYPE_A", "TYPE_B", "TYPE_MIX"}
+
+func warehouseNet() *Net {
+	n := NewNet("warehouse")
+
+	idle := n.Place("clients idle", CLIENTS)
+	var request, retrieving [3]*Place
+	for t, name := range typeNames {
+		request[t] = n.Place("requestChan["+name+"]", 0)
+		retrieving[t] = n.Place("clients retrieving "+name, 0)
+	}
+	var supplier, restock, activePrel, activeRestock, free, used [2]*Place
+	lots := [2]int{LOTS_A, LOTS_B}
+	for t, name := range typeNames[:2] {
+		supplier[t] = n.Place("supplier "+name+" idle", 1)
+		restock[t] = n.Place("restockChan["+name+"]", 0)
+		activePrel[t] = n.Place("activePrel["+name+"]", 0)
+		activeRestock[t] = n.Place("activeRestock["+name+"]", 0)
+		free[t] = n.Place("lots of "+name+" not retrieved", lots[t])
+		used[t] = n.Place("lots of "+name+" used", 0)
+	}
+
+	// The clients ask and the suppliers ask.
+	for t, name := range typeNames {
+		n.Transition("client asks "+name).In(idle, 1).Out(request[t], 1)
+	}
+	for t, name := range typeNames[:2] {
+		n.Transition("supplier asks to restock "+name).In(supplier[t], 1).Out(restock[t], 1)
+	}
+
+	// RETRIEVAL (START)
+	n.Transition("retrieval of TYPE_A starts").
+		In(request[TYPE_A], 1).In(free[TYPE_A], 1).
+		Out(retrieving[TYPE_A], 1).Out(activePrel[TYPE_A], 1).
+		Zero(activeRestock[TYPE_A], request[TYPE_MIX])
+	n.Transition("retrieval of TYPE_B starts").
+		In(request[TYPE_B], 1).In(free[TYPE_B], 1).
+		Out(retrieving[TYPE_B], 1).Out(activePrel[TYPE_B], 1).
+		Zero(activeRestock[TYPE_B], request[TYPE_MIX], request[TYPE_A])
+	n.Transition("retrieval of TYPE_MIX starts").
+		In(request[TYPE_MIX], 1).In(free[TYPE_A], 1).In(free[TYPE_B], 1).
+		Out(retrieving[TYPE_MIX], 1).Out(activePrel[TYPE_A], 1).Out(activePrel[TYPE_B], 1).
+		Zero(activeRestock[TYPE_A], activeRestock[TYPE_B])
+
+	// RETRIEVAL (END)
+	for t, name := range typeNames[:2] {
+		n.Transition("retrieval of "+name+" ends").
+			In(retrieving[t], 1).In(activePrel[t], 1).
+			Out(used[t], 1).Out(idle, 1)
+	}
+	n.Transition("retrieval of TYPE_MIX ends").
+		In(retrieving[TYPE_MIX], 1).In(activePrel[TYPE_A], 1).In(activePrel[TYPE_B], 1).
+		Out(used[TYPE_A], 1).Out(used[TYPE_B], 1).Out(idle, 1)
+
+	// RESTOCK
+	for t, name := range typeNames[:2] {
+		n.Transition("restock of "+name+" starts").
+			In(restock[t], 1).Out(activeRestock[t], 1).
+			Zero(activePrel[t])
+		n.Transition("restock of "+name+" puts a lot back").
+			In(activeRestock[t], 1).In(used[t], 1).
+			Out(activeRestock[t], 1).Out(free[t], 1)
+		n.Transition("restock of "+name+" ends").
+			In(activeRestock[t], 1).Out(supplier[t], 1).
+			Zero(used[t])
+	}
+
+	return n
+}
+
+func main() {
+	Main(warehouseNet())
+}
@@ -0,0 +1,269 @@
+// -----------------------------------------------------------------------------------
+// PRIORITY POLICIES FOR THE GUARDS
+//
+// Many servers give a class of requests a static priority over another one with a
+// conjunct of the guard:
+//
+//     case request := <-when(... && len(enterWaitingRoom[ADMIN]) == 0, enterWaitingRoom[PRIVATE_SINGLE]):
+//
+// With enough ADMIN requests, PRIVATE_SINGLE (and PRIVATE_WITH after it) are never
+// served. A Policy replaces these conjuncts with a single one,
+//
+//     case request := <-when(... && policy.Allows(PRIVATE_SINGLE, queues()), enterWaitingRoom[PRIVATE_SINGLE]):
+//
+// where queues() are the lengths of the request channels of the classes, and the
+// server calls policy.Served(class) when it serves a request. Which class may be
+// served is then decided by the policy, chosen with the flag
+//     -policy <policy>
+// without touching the guards again:
+//
+//     strict               the class with the lowest index that is waiting (the
+//                          original guards: ADMIN, then PRIVATE_SINGLE...)
+//     aging:step=S         like strict, but a class gains one level of priority
+//                          every S seconds it waits: after k*S seconds a class
+//                          overtakes the k classes above it
+//     wrr:A=3,B=1          weighted round-robin: in turn, every waiting class is
+//                          served up to its weight (missing classes: 1)
+//     batch:size=N         batch alternation: up to N requests of a class, then
+//                          the next waiting class
+//
+// The age of a class is the time it has been waiting since it was last served:
+// its oldest request has waited at least that long. The guards are evaluated when
+// the server enters the select, so a class that ages does not wake the server:
+// its new priority is seen at the next event.
+//
+// Except with strict, the class whose turn it is blocks the others until it can
+// be served (e.g. PRIVATE_WITH until two seats are free): this is what prevents
+// its starvation. A nil *Policy is strict, so the scenarios behave as before when
+// main does not create one (e.g. in the tests).
+//
+// The consulting office with aging, from writtenExams/10-01-2022:
+//     go run examSol.go workload.go fairness.go metrics.go leaks.go policy.go queueing.go des.go causal.go admin.go -policy aging:step=5
+//
+// Run the tests with:
+//     go test policy.go policy_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var policyFlag = flag.String("policy", "", "priority policy: strict, aging:step=S, wrr:CLASS=W,... or batch:size=N (default: the original one)")
+
+// Kinds of policy.
+const (
+	Strict = iota
+	Aging
+	WRR
+	Batch
+)
+
+// Policy decides which of the waiting classes of requests may be served. It is
+// used only by the server goroutine.
+type Policy struct {
+	kind    int
+	classes []string
+	step    time.Duration // aging
+	weights []int         // wrr
+	size    int           // batch
+	now     func() time.Time
+
+	since  []time.Time // aging: since when each class waits (zero: not waiting)
+	credit []int       // wrr: requests each class may still be served in this round
+	cur    int         // wrr, batch: class whose turn it is
+	count  int         // batch: requests of cur served in this batch
+}
+
+// ParsePolicy parses a policy for the given classes, indexed like the constants of
+// the scenario from the highest priority.
+func ParsePolicy(spec string, classes []string) (*Policy, error) {
+	kind, args, _ := strings.Cut(spec, ":")
+	p := &Policy{classes: classes, now: time.Now}
+	p.since = make([]time.Time, len(classes))
+	params := map[string]string{}
+	if args != "" {
+		for _, kv := range strings.Split(args, ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return nil, fmt.Errorf("policy: %q: expected key=value, got %q", spec, kv)
+			}
+			params[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	// number reads a required positive parameter.
+	number := func(key string) (float64, error) {
+		v, ok := params[key]
+		if !ok {
+			return 0, fmt.Errorf("policy: %q: missing %s", spec, key)
+		}
+		delete(params, key)
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil || x <= 0 {
+			return 0, fmt.Errorf("policy: %q: %s must be a number > 0", spec, key)
+		}
+		return x, nil
+	}
+
+	switch kind {
+	case "strict":
+		p.kind = Strict
+	case "aging":
+		p.kind = Aging
+		step, err := number("step")
+		if err != nil {
+			return nil, err
+		}
+		p.step = time.Duration(step * float64(time.Second))
+	case "wrr":
+		p.kind = WRR
+		p.weights = make([]int, len(classes))
+		for c, name := range classes {
+			p.weights[c] = 1
+			for k, v := range params {
+				if strings.EqualFold(k, name) {
+					w, err := strconv.Atoi(v)
+					if err != nil || w < 1 {
+						return nil, fmt.Errorf("policy: %q: the weight of %s must be a whole number >= 1", spec, name)
+					}
+					p.weights[c] = w
+					delete(params, k)
+				}
+			}
+		}
+		p.credit = append([]int(nil), p.weights...)
+	case "batch":
+		p.kind = Batch
+		size, err := number("size")
+		if err != nil {
+			return nil, err
+		}
+		if size != math.Trunc(size) {
+			return nil, fmt.Errorf("policy: %q: size must be a whole number", spec)
+		}
+		p.size = int(size)
+	default:
+		return nil, fmt.Errorf("policy: unknown policy %q", kind)
+	}
+	for k := range params {
+		return nil, fmt.Errorf("policy: %q: unknown parameter %s (classes: %s)", spec, k, strings.Join(classes, ", "))
+	}
+	return p, nil
+}
+
+// PolicyFromFlags creates the policy of a scenario from the -policy flag, or from
+// def if the flag is not given. On error it exits the program.
+func PolicyFromFlags(classes []string, def string) *Policy {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	spec := *policyFlag
+	if spec == "" {
+		spec = def
+	}
+	p, err := ParsePolicy(spec, classes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	return p
+}
+
+// WithClock makes p measure the waits of the classes with now instead of
+// time.Now, e.g. with the clock of a discrete-event simulation (see des.go).
+func (p *Policy) WithClock(now func() time.Time) *Policy {
+	if p != nil {
+		p.now = now
+	}
+	return p
+}
+
+// Allows reports whether a request of class c may be served now; waiting are
+// the numbers of requests waiting in every class.
+func (p *Policy) Allows(c int, waiting []int) bool {
+	t := p.turn(waiting)
+	return t < 0 || t == c
+}
+
+// Served records that the server has served a request of class c.
+func (p *Policy) Served(c int) {
+	if p == nil {
+		return
+	}
+	switch p.kind {
+	case Aging:
+		p.since[c] = p.now() // if it still waits, it does from now
+	case WRR:
+		if p.credit[c] > 0 {
+			p.credit[c]--
+		}
+		p.cur = c
+		if p.credit[c] == 0 {
+			p.cur = (c + 1) % len(p.classes)
+		}
+	case Batch:
+		if c != p.cur {
+			p.cur, p.count = c, 0
+		}
+		p.count++
+	}
+}
+
+// turn returns the class that may be served, or -1 if no class is waiting.
+func (p *Policy) turn(waiting []int) int {
+	if p == nil || p.kind == Strict {
+		for c, n := range waiting {
+			if n > 0 {
+				return c
+			}
+		}
+		return -1
+	}
+	switch p.kind {
+	case Aging:
+		best, level := -1, math.Inf(-1)
+		for c, n := range waiting {
+			if n == 0 {
+				p.since[c] = time.Time{}
+				continue
+			}
+			if p.since[c].IsZero() {
+				p.since[c] = p.now()
+			}
+			l := float64(p.now().Sub(p.since[c]))/float64(p.step) - float64(c)
+			if l > level {
+				best, level = c, l
+			}
+		}
+		return best
+	case WRR:
+		for refill := 0; refill < 2; refill++ {
+			for i := range p.classes {
+				c := (p.cur + i) % len(p.classes)
+				if waiting[c] > 0 && p.credit[c] > 0 {
+					return c
+				}
+			}
+			// No waiting class has credit left: a new round starts.
+			copy(p.credit, p.weights)
+		}
+		return -1
+	default: // Batch
+		if waiting[p.cur] > 0 && p.count < p.size {
+			return p.cur
+		}
+		for i := 1; i <= len(p.classes); i++ {
+			if c := (p.cur + i) % len(p.classes); waiting[c] > 0 {
+				return c
+			}
+		}
+		return -1
+	}
+}
@@ -0,0 +1,143 @@
+// Tests for the priority policies: parsing of the flag and order in which the
+// waiting classes are served.
+//
+// Run with:
+//     go test policy.go policy_test.go
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+var testClasses = []string{"A", "B", "C"}
+
+func newTestPolicy(t *testing.T, spec string) *Policy {
+	t.Helper()
+	p, err := ParsePolicy(spec, testClasses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// serve serves n requests, always choosing the class allowed by p, while the
+// classes keep waiting as in waiting, and returns the classes served.
+func serve(p *Policy, waiting []int, n int) []int {
+	var order []int
+	for range n {
+		for c := range waiting {
+			if waiting[c] > 0 && p.Allows(c, waiting) {
+				p.Served(c)
+				order = append(order, c)
+				break
+			}
+		}
+	}
+	return order
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Policy
+	}{
+		{"strict", Policy{kind: Strict}},
+		{"aging:step=2.5", Policy{kind: Aging, step: 2500 * time.Millisecond}},
+		{"wrr:a=3,C=2", Policy{kind: WRR, weights: []int{3, 1, 2}}},
+		{"batch:size=4", Policy{kind: Batch, size: 4}},
+	}
+	for _, tt := range tests {
+		p, err := ParsePolicy(tt.spec, testClasses)
+		if err != nil {
+			t.Errorf("ParsePolicy(%q): %v", tt.spec, err)
+			continue
+		}
+		if p.kind != tt.want.kind || p.step != tt.want.step || !slices.Equal(p.weights, tt.want.weights) || p.size != tt.want.size {
+			t.Errorf("ParsePolicy(%q) = %+v, want %+v", tt.spec, *p, tt.want)
+		}
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"fifo",
+		"strict:step=1",
+		"aging",
+		"aging:step=0",
+		"aging:step=x",
+		"wrr:A=0",
+		"wrr:D=1",
+		"wrr:A",
+		"batch:size=1.5",
+		"batch:size=2,step=1",
+	} {
+		if _, err := ParsePolicy(spec, testClasses); err == nil {
+			t.Errorf("ParsePolicy(%q): no error", spec)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	policies := map[string]*Policy{"nil": nil, "strict": newTestPolicy(t, "strict")}
+	for name, p := range policies {
+		if got := serve(p, []int{0, 1, 1}, 3); !slices.Equal(got, []int{1, 1, 1}) {
+			t.Errorf("%s: served %v, want B first while A does not wait", name, got)
+		}
+		if got := serve(p, []int{1, 1, 1}, 3); !slices.Equal(got, []int{0, 0, 0}) {
+			t.Errorf("%s: served %v, want always A", name, got)
+		}
+		if !p.Allows(2, []int{0, 0, 0}) {
+			t.Errorf("%s: nobody waits, but C is not allowed", name)
+		}
+	}
+}
+
+func TestAging(t *testing.T) {
+	p := newTestPolicy(t, "aging:step=1")
+	clock := time.Unix(0, 0)
+	p.now = func() time.Time { return clock }
+	waiting := []int{1, 1, 0}
+
+	// A is served every half second, B keeps waiting: at 2s B has gained one
+	// level over A, which has waited only 0.5s since it was served.
+	for range 4 {
+		if !p.Allows(0, waiting) || p.Allows(1, waiting) {
+			t.Fatalf("at %v: B allowed before A", clock.Unix())
+		}
+		p.Served(0)
+		clock = clock.Add(500 * time.Millisecond)
+	}
+	if p.Allows(0, waiting) || !p.Allows(1, waiting) {
+		t.Errorf("at 2s: A allowed, want B after waiting one step more than A")
+	}
+}
+
+func TestWRR(t *testing.T) {
+	p := newTestPolicy(t, "wrr:A=2,B=1,C=3")
+	got := serve(p, []int{1, 1, 1}, 12)
+	want := []int{0, 0, 1, 2, 2, 2, 0, 0, 1, 2, 2, 2}
+	if !slices.Equal(got, want) {
+		t.Errorf("served %v, want %v", got, want)
+	}
+	// Only B waits: it gets the rounds of the others.
+	if got := serve(p, []int{0, 1, 0}, 3); !slices.Equal(got, []int{1, 1, 1}) {
+		t.Errorf("served %v, want only B", got)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	p := newTestPolicy(t, "batch:size=2")
+	got := serve(p, []int{1, 1, 1}, 8)
+	want := []int{0, 0, 1, 1, 2, 2, 0, 0}
+	if !slices.Equal(got, want) {
+		t.Errorf("served %v, want %v", got, want)
+	}
+	// A has had its batch and C does not wait: B, then A again.
+	if got := serve(p, []int{1, 1, 0}, 4); !slices.Equal(got, []int{1, 1, 0, 0}) {
+		t.Errorf("served %v, want B B A A", got)
+	}
+}
@@ -0,0 +1,319 @@
+// -----------------------------------------------------------------------------------
+// QUEUEING THEORY: CROSS-CHECK OF A SERVER WITH THE M/M/c AND M/M/c/K MODELS
+//
+// With Poisson arrivals and exponential service times some servers of the course
+// are textbook queues, whose steady state queueing theory gives in closed form:
+//
+//   - the consulting office of 10-01-2022 is M/M/c/K: c = NUM_OFFICES servers and
+//     room for K = NUM_OFFICES + MAX_WAITING_ROOM users, in the offices or in the
+//     waiting room (with no accompanied owners, who take two seats);
+//   - the pool of lab3/ex1.go is M/M/c: c resources and a queue without limit.
+//
+// A Model computes, for the arrival rate lambda, the service rate mu of every
+// server and the sizes c and K:
+//
+//     utilization           mean fraction of busy servers, lambda(1-B)/(c mu)
+//     mean queue length     Lq, clients waiting to be served
+//     mean in the system    L = Lq + lambda(1-B)/mu
+//     blocking probability  B, an arrival finds K clients and is lost (M/M/c/K)
+//     P(wait)               an admitted client finds the c servers busy (Erlang C
+//                           formula for M/M/c)
+//     mean wait             Wq = Lq / (lambda(1-B)) (Little's law)
+//
+// With
+//     -queueing
+// the scenario measures the same quantities with a Queue, its clients calling
+// Arrive, Start (the service begins) and Leave (it ends), and at the end of the
+// run prints them next to the predictions. The flag requires
+//     -arrivals poisson:rate=R -service exp:mean=S
+// (see workload.go) and in M/M/c/K the users that find the office full leave, as
+// the model wants, instead of waiting outside. The time averages are taken up to
+// the last arrival, when the system is still in its steady state. The run must be
+// long for the two columns to agree: the sizes of the scenarios are small, so
+// the long runs are tests in simulated time (TestQueueing), e.g. from
+// writtenExams/10-01-2022:
+//     go test -run TestQueueing -v examSol.go examSol_test.go servertest_test.go workload.go fairness.go metrics.go leaks.go policy.go queueing.go des.go causal.go admin.go
+// and a short run shows the report:
+//     go run examSol.go workload.go fairness.go metrics.go leaks.go policy.go queueing.go des.go causal.go admin.go -queueing -arrivals poisson:rate=0.3 -service exp:mean=15 -mix ADMIN=1,PRIVATE_SINGLE=1
+// A server that does not behave as the model is seen in the measured column: e.g.
+// the pause of one second at every cycle of the server of lab3/ex1.go makes the
+// clients wait even when a resource is free.
+//
+// Run the tests with:
+//     go test queueing.go queueing_test.go workload.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+var queueingFlag = flag.Bool("queueing", false, "compare the run with the M/M/c or M/M/c/K model at the end "+
+	"(needs -arrivals poisson:rate=R and -service exp:mean=S)")
+
+// waitThreshold: a client that waits less than this was only sending its request
+// to the server, it did not queue.
+const waitThreshold = time.Millisecond
+
+// ============================================================
+//                           MODEL
+// ============================================================
+
+// Model is a queue with Poisson arrivals, Lambda per second, C servers serving
+// in exponential times, Mu clients per second each, and room for K clients in
+// the system (waiting or served; 0: no limit).
+type Model struct {
+	Lambda, Mu float64
+	C, K       int
+}
+
+// Prediction is the steady state of a Model, or what a Queue measured.
+type Prediction struct {
+	Utilization float64 // mean fraction of busy servers
+	Queue       float64 // mean number of clients waiting (Lq)
+	System      float64 // mean number of clients in the system (L)
+	Blocking    float64 // fraction of the arrivals that are lost
+	Wait        float64 // fraction of the admitted clients that wait
+	MeanWait    float64 // mean wait before the service, in seconds (Wq)
+}
+
+func (m Model) String() string {
+	if m.K == 0 {
+		return fmt.Sprintf("M/M/%d", m.C)
+	}
+	return fmt.Sprintf("M/M/%d/%d", m.C, m.K)
+}
+
+// Predict returns the steady state of the model. An M/M/c queue with
+// lambda >= c*mu has none: its queue grows without bound.
+func (m Model) Predict() (Prediction, error) {
+	if m.Lambda <= 0 || m.Mu <= 0 || m.C < 1 || (m.K != 0 && m.K < m.C) {
+		return Prediction{}, fmt.Errorf("queueing: %v: want lambda, mu > 0, c >= 1 and K = 0 or K >= c", m)
+	}
+	a := m.Lambda / m.Mu // offered load, in servers
+	rho := a / float64(m.C)
+	if m.K == 0 && rho >= 1 {
+		return Prediction{}, fmt.Errorf("queueing: %v: lambda >= c*mu, the queue grows without bound", m)
+	}
+
+	// p[n] is the probability of n clients in the system (birth-death process),
+	// up to K or, in M/M/c, up to c followed by a geometric tail of ratio rho.
+	n := m.K
+	if n == 0 {
+		n = m.C
+	}
+	p := make([]float64, n+1)
+	p[0] = 1
+	total := 1.0
+	for i := 1; i <= n; i++ {
+		p[i] = p[i-1] * a / float64(min(i, m.C))
+		total += p[i]
+	}
+	if m.K == 0 {
+		total += p[m.C] * rho / (1 - rho)
+	}
+	for i := range p {
+		p[i] /= total
+	}
+
+	var pr Prediction
+	if m.K == 0 {
+		pr.Wait = p[m.C] / (1 - rho) // Erlang C
+		pr.Queue = p[m.C] * rho / ((1 - rho) * (1 - rho))
+	} else {
+		pr.Blocking = p[m.K]
+		for i := m.C; i <= m.K; i++ {
+			pr.Queue += float64(i-m.C) * p[i]
+		}
+		for i := m.C; i < m.K; i++ {
+			pr.Wait += p[i]
+		}
+		pr.Wait /= 1 - pr.Blocking
+	}
+	admitted := m.Lambda * (1 - pr.Blocking)
+	pr.Utilization = admitted / (float64(m.C) * m.Mu)
+	pr.System = pr.Queue + admitted/m.Mu
+	pr.MeanWait = pr.Queue / admitted
+	return pr, nil
+}
+
+// ============================================================
+//                        MEASUREMENT
+// ============================================================
+
+// Queue measures the clients of a server modelled by a Model. A nil *Queue
+// measures nothing and admits every client, so the clients can use it also
+// when main did not create it. It is safe to use from several goroutines.
+type Queue struct {
+	model Model
+
+	mu                 sync.Mutex
+	now                func() time.Time
+	start, last        time.Time
+	waiting, serving   int
+	waitArea, servArea float64 // clients × seconds until last
+	arrivals, blocked  int
+	started, waited    int
+	waitSum            time.Duration
+
+	// Up to the last arrival.
+	end                      time.Time
+	endWaitArea, endServArea float64
+}
+
+// Customer is a client admitted in a Queue.
+type Customer struct {
+	since time.Time
+}
+
+// NewQueue creates the Queue of a server modelled by m.
+func NewQueue(m Model) *Queue {
+	q := &Queue{model: m, now: time.Now}
+	q.start = q.now()
+	q.last, q.end = q.start, q.start
+	return q
+}
+
+// QueueFromFlags returns the Queue of a server with c servers and room for k
+// clients (0: no limit) if -queueing is given, nil otherwise. The rates come
+// from the workload and the service times, which must be Poisson and
+// exponential; if they are not it exits the program.
+func QueueFromFlags(c, k int, w *Workload, s Service) *Queue {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	if !*queueingFlag {
+		return nil
+	}
+	arrivals, ok1 := w.process.(Poisson)
+	service, ok2 := s.(Exponential)
+	if !ok1 || !ok2 {
+		fmt.Fprintln(os.Stderr, "queueing: -queueing needs -arrivals poisson:rate=R and -service exp:mean=S")
+		os.Exit(2)
+	}
+	return NewQueue(Model{Lambda: arrivals.Rate, Mu: 1 / service.Mean.Seconds(), C: c, K: k})
+}
+
+// advance adds the clients waiting and served since the last event to the areas.
+func (q *Queue) advance() {
+	now := q.now()
+	dt := now.Sub(q.last).Seconds()
+	q.waitArea += float64(q.waiting) * dt
+	q.servArea += float64(q.serving) * dt
+	q.last = now
+}
+
+// Arrive records the arrival of a client. In M/M/c/K a client that finds K
+// clients in the system is lost: Arrive returns false and the client must leave
+// without being served.
+func (q *Queue) Arrive() (*Customer, bool) {
+	if q == nil {
+		return nil, true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.advance()
+	q.arrivals++
+	q.end, q.endWaitArea, q.endServArea = q.last, q.waitArea, q.servArea
+	if q.model.K > 0 && q.waiting+q.serving >= q.model.K {
+		q.blocked++
+		return nil, false
+	}
+	q.waiting++
+	return &Customer{since: q.last}, true
+}
+
+// Start records that the service of c begins.
+func (q *Queue) Start(c *Customer) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.advance()
+	q.waiting--
+	q.serving++
+	q.started++
+	wait := q.last.Sub(c.since)
+	q.waitSum += wait
+	if wait >= waitThreshold {
+		q.waited++
+	}
+}
+
+// Leave records that the service of c is over.
+func (q *Queue) Leave(c *Customer) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.advance()
+	q.serving--
+}
+
+// Measured returns what was measured, the time averages up to the last arrival.
+func (q *Queue) Measured() Prediction {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var m Prediction
+	if elapsed := q.end.Sub(q.start).Seconds(); elapsed > 0 {
+		m.Utilization = q.endServArea / (elapsed * float64(q.model.C))
+		m.Queue = q.endWaitArea / elapsed
+		m.System = (q.endWaitArea + q.endServArea) / elapsed
+	}
+	if q.arrivals > 0 {
+		m.Blocking = float64(q.blocked) / float64(q.arrivals)
+	}
+	if q.started > 0 {
+		m.Wait = float64(q.waited) / float64(q.started)
+		m.MeanWait = (q.waitSum / time.Duration(q.started)).Seconds()
+	}
+	return m
+}
+
+// Report writes the predictions of the model next to the measured values.
+func (q *Queue) Report(w io.Writer) {
+	if q == nil {
+		return
+	}
+	m := q.model
+	got := q.Measured()
+	want, err := m.Predict()
+	q.mu.Lock()
+	arrivals, blocked, elapsed := q.arrivals, q.blocked, q.end.Sub(q.start)
+	q.mu.Unlock()
+
+	fmt.Fprintf(w, "\nQUEUEING CHECK: %v, lambda=%.4g/s, mu=%.4g/s, offered load %.3g servers\n", m, m.Lambda, m.Mu, m.Lambda/m.Mu)
+	fmt.Fprintf(w, "%d arrivals in %v", arrivals, elapsed.Round(time.Second))
+	if m.K > 0 {
+		fmt.Fprintf(w, ", %d lost", blocked)
+	}
+	fmt.Fprintln(w)
+	if err != nil {
+		nan := math.NaN()
+		want = Prediction{nan, nan, nan, nan, nan, nan}
+		fmt.Fprintln(w, err)
+	}
+	fmt.Fprintf(w, "%-24s %10s %10s\n", "", "model", "measured")
+	row := func(name string, want, got float64) {
+		fmt.Fprintf(w, "%-24s %10.4g %10.4g\n", name, want, got)
+	}
+	row("utilization", want.Utilization, got.Utilization)
+	row("mean queue length (Lq)", want.Queue, got.Queue)
+	row("mean in the system (L)", want.System, got.System)
+	if m.K > 0 {
+		row("blocking probability", want.Blocking, got.Blocking)
+		row("P(wait)", want.Wait, got.Wait)
+	} else {
+		row("P(wait) (Erlang C)", want.Wait, got.Wait)
+	}
+	row("mean wait (Wq, s)", want.MeanWait, got.MeanWait)
+}
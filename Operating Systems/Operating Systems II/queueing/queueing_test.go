@@ -0,0 +1,101 @@
+// Tests for the queueing models: closed forms of textbook cases and the
+// measures of a Queue on a scripted run.
+//
+// Run with:
+//     go test queueing.go queueing_test.go workload.go
+
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func samePrediction(a, b Prediction) bool {
+	return near(a.Utilization, b.Utilization) && near(a.Queue, b.Queue) && near(a.System, b.System) &&
+		near(a.Blocking, b.Blocking) && near(a.Wait, b.Wait) && near(a.MeanWait, b.MeanWait)
+}
+
+func TestPredict(t *testing.T) {
+	tests := []struct {
+		model Model
+		want  Prediction
+	}{
+		// M/M/1, rho = 1/2: Lq = rho^2/(1-rho), P(wait) = rho, Wq = rho/(mu-lambda).
+		{Model{Lambda: 0.5, Mu: 1, C: 1}, Prediction{0.5, 0.5, 1, 0, 0.5, 1}},
+		// M/M/2 with one server of load: p0 = 1/3, p2 = 1/6, Erlang C = 1/3.
+		{Model{Lambda: 1, Mu: 1, C: 2}, Prediction{0.5, 1.0 / 3, 4.0 / 3, 0, 1.0 / 3, 1.0 / 3}},
+		// M/M/1/3 with lambda = mu: the four states are equally likely.
+		{Model{Lambda: 1, Mu: 1, C: 1, K: 3}, Prediction{0.75, 0.75, 1.5, 0.25, 2.0 / 3, 1}},
+		// M/M/2/2 is Erlang B: B(2, 1) = 1/5, nobody waits.
+		{Model{Lambda: 1, Mu: 1, C: 2, K: 2}, Prediction{0.4, 0, 0.8, 0.2, 0, 0}},
+	}
+	for _, tt := range tests {
+		got, err := tt.model.Predict()
+		if err != nil || !samePrediction(got, tt.want) {
+			t.Errorf("%v lambda=%v mu=%v: %+v, %v, want %+v", tt.model, tt.model.Lambda, tt.model.Mu, got, err, tt.want)
+		}
+	}
+
+	for _, m := range []Model{
+		{Lambda: 2, Mu: 1, C: 2},       // unstable
+		{Lambda: 1, Mu: 1, C: 2, K: 1}, // K < c
+		{Lambda: 0, Mu: 1, C: 1},
+	} {
+		if p, err := m.Predict(); err == nil {
+			t.Errorf("%v lambda=%v mu=%v: %+v, want an error", m, m.Lambda, m.Mu, p)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	now := time.Unix(0, 0)
+	at := func(s int) { now = time.Unix(int64(s), 0) }
+	q := NewQueue(Model{Lambda: 1, Mu: 1, C: 1, K: 2})
+	q.now = func() time.Time { return now }
+	q.start, q.last, q.end = now, now, now
+
+	a, _ := q.Arrive()
+	q.Start(a) // served at once
+	at(1)
+	b, _ := q.Arrive()
+	if _, ok := q.Arrive(); ok {
+		t.Error("third client admitted in M/M/1/2")
+	}
+	at(3)
+	q.Leave(a)
+	q.Start(b) // waited 2 seconds
+	at(4)
+	d, _ := q.Arrive() // the last arrival
+	at(5)
+	q.Start(d) // waited 1 second
+
+	// Up to 4s: b waits from 1s to 3s, a is served until 3s and b after it.
+	want := Prediction{Utilization: 1, Queue: 0.5, System: 1.5, Blocking: 0.25, Wait: 2.0 / 3, MeanWait: 1}
+	if got := q.Measured(); !samePrediction(got, want) {
+		t.Errorf("measured %+v, want %+v", got, want)
+	}
+
+	var out strings.Builder
+	q.Report(&out)
+	for _, s := range []string{"M/M/1/2", "4 arrivals in 4s, 1 lost", "blocking probability"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("report without %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestNilQueue(t *testing.T) {
+	var q *Queue
+	c, ok := q.Arrive()
+	if !ok {
+		t.Error("a nil Queue does not admit the client")
+	}
+	q.Start(c)
+	q.Leave(c)
+	q.Report(nil)
+}
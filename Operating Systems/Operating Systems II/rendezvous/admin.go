@@ -0,0 +1 @@
+../admin/admin.go
\ No newline at end of file
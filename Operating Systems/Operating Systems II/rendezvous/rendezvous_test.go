@@ -0,0 +1,139 @@
+// Tests for the rendezvous entries: a call paired with its accept, guards that
+// keep a caller blocked until they open, calls stored and answered later, and
+// the counts of the queued callers.
+//
+// Run with:
+//     go test rendezvous.go rendezvous_test.go
+
+package main
+
+import (
+	"testing"
+	"testing/synctest"
+)
+
+func TestCallAccept(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		double := NewEntry[int, int](0)
+		served := 0
+		go func() {
+			for range 3 {
+				c := <-double.Accept()
+				c.Accept(func(p int) int {
+					served++ // the caller is blocked until the body returns
+					return 2 * p
+				})
+			}
+		}()
+		for p := range 3 {
+			if got := double.Call(p + 10); got != 2*(p+10) {
+				t.Errorf("Call(%d) = %d, want %d", p+10, got, 2*(p+10))
+			}
+			if served != p+1 {
+				t.Errorf("Call(%d) returned after %d bodies, want %d", p+10, served, p+1)
+			}
+		}
+	})
+}
+
+func TestWhen(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		get := NewEntry[string, string](2)
+		open := NewEntry[bool, bool](0)
+		quit := make(chan bool)
+		go func() {
+			isOpen := false
+			for {
+				select {
+				case c := <-get.When(isOpen):
+					c.Accept(func(p string) string { return p + " served" })
+				case c := <-open.Accept():
+					c.Accept(func(p bool) bool { isOpen = p; return true })
+				case <-quit:
+					return
+				}
+			}
+		}()
+
+		got := make(chan string, 2)
+		for _, p := range []string{"a", "b"} {
+			go func() { got <- get.Call(p) }()
+		}
+		synctest.Wait()
+		if len(got) != 0 {
+			t.Fatalf("served %q behind a closed guard", <-got)
+		}
+		if n := get.Count(); n != 2 {
+			t.Errorf("Count with the guard closed: %d, want 2", n)
+		}
+
+		open.Call(true)
+		synctest.Wait()
+		if len(got) != 2 || get.Count() != 0 {
+			t.Errorf("after the guard opened: %d served, %d queued, want 2 and 0", len(got), get.Count())
+		}
+		for range 2 {
+			if r := <-got; r != "a served" && r != "b served" {
+				t.Errorf("result %q", r)
+			}
+		}
+		quit <- true
+	})
+}
+
+func TestReply(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		leave := NewEntry[int, string](0)
+		finish := NewEntry[int, bool](0)
+		go func() {
+			// A trainer asking to leave is stored, and answered only once
+			// its user has finished
+			c := <-leave.Accept()
+			(<-finish.Accept()).Reply(true)
+			c.Reply("trainer left")
+		}()
+
+		left := make(chan string, 1)
+		go func() { left <- leave.Call(0) }()
+		synctest.Wait()
+		if len(left) != 0 {
+			t.Fatal("the trainer left before its user finished")
+		}
+		finish.Call(0)
+		if got := <-left; got != "trainer left" {
+			t.Errorf("leave returned %q", got)
+		}
+	})
+}
+
+func TestFamilyCount(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		f := NewFamily[int, int](3, 5)
+		for i, n := range []int{2, 0, 3} {
+			for range n {
+				go f[i].Call(i)
+			}
+		}
+		synctest.Wait()
+		for _, tc := range []struct {
+			index []int
+			want  int
+		}{
+			{nil, 5},
+			{[]int{0}, 2},
+			{[]int{1}, 0},
+			{[]int{0, 2}, 5},
+			{[]int{2, 1}, 3},
+		} {
+			if got := f.Count(tc.index...); got != tc.want {
+				t.Errorf("Count(%v) = %d, want %d", tc.index, got, tc.want)
+			}
+		}
+		// Answer every caller, so that the bubble ends with no goroutine blocked
+		for _, e := range f {
+			for e.Count() > 0 {
+				(<-e.Accept()).Reply(0)
+			}
+		}
+	})
+}
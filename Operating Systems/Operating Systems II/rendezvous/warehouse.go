@@ -0,0 +1,385 @@
+// -----------------------------------------------------------------------------------
+// WAREHOUSE (writtenExams/template.go) REWRITTEN WITH RENDEZVOUS ENTRIES
+//
+// Same scenario and same guards as the exam template, but every
+// "send request + wait on ack" pair is replaced by an entry call:
+//
+//     template.go                                this file
+//     -----------                                ---------
+//     requestChan[r.tipo] <- r; <-r.ack          retrieve[r.tipo].Call(r)
+//     endRequest <- r; <-r.ack                   endRetrieve.Call(r)
+//     case req := <-when(cond, requestChan[A]):  case c := <-retrieve[TYPE_A].When(cond):
+//         ...; req.ack <- 1                          c.Accept(func(req Request) int { ...; return 1 })
+//     len(requestChan[TYPE_MIX]) == 0            retrieve[TYPE_MIX].Count() == 0
+//
+// Run with:
+//     go run rendezvous.go warehouse.go admin.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// ============================================================
+//                   CONSTANTS / PARAMETERS
+// ============================================================
+
+const (
+	MAXBUFFER   = 100 // Maximum number of queued calls per entry
+	MAX_CLIENTS = 20  // Maximum number of Clients/Workers that can be managed
+
+	TYPE_A   = 0 // First type of resource
+	TYPE_B   = 1 // Second type of resource
+	TYPE_MIX = 2 // "Mixed" type
+
+	MAX_A = 4000 // Max capacity for resource type A
+	MAX_B = 3000 // Max capacity for resource type B
+
+	LOT_A   = 700 // Lot for resource type A
+	LOT_B   = 300 // Lot for resource type B
+	LOT_MIX = 500 // Lot for the "mixed" resource
+)
+
+// ============================================================
+//                       DATA STRUCTURE
+// ============================================================
+
+// Request carries only the parameters of the call:
+// the ack channel is managed by the entry itself.
+type Request struct {
+	id   int // Identifier of who is making the request
+	tipo int // Indicates the type of resource involved
+}
+
+// ============================================================
+//                          ENTRIES
+// ============================================================
+
+// retrieve: entry family indexed by resource type (TYPE_A, TYPE_B, TYPE_MIX).
+// restock:  entry family indexed by resource type (TYPE_A, TYPE_B).
+// The result is 1 when the operation is granted, -1 on error.
+var retrieve = NewFamily[Request, int](3, MAXBUFFER)
+var restock = NewFamily[Request, int](2, MAXBUFFER)
+
+// endRetrieve and endRestock: entries to signal the conclusion
+// of a retrieval/restock operation.
+var endRetrieve = NewEntry[Request, int](MAXBUFFER)
+var endRestock = NewEntry[Request, int](0)
+
+// stopWarehouse: entry called by main to shut the warehouse down.
+var stopWarehouse = NewEntry[struct{}, bool](0)
+
+// Channels for process termination.
+var done = make(chan bool)
+var stopSupplier = make(chan bool)
+
+// ============================================================
+//                     SUPPORT FUNCTIONS
+// ============================================================
+
+// Waits a random amount of time (in seconds) in the range [1, max].
+func sleepRandTime(max int) {
+	if max > 0 {
+		time.Sleep(time.Duration(rand.Intn(max)+1) * time.Second)
+	}
+}
+
+// Waits a random amount of time (in seconds) in the range [min, max).
+func sleepRandTimeRange(min, max int) {
+	if min >= 0 && max > 0 && min < max {
+		time.Sleep(time.Duration(rand.Intn(max-min)+min) * time.Second)
+	}
+}
+
+// Returns a string based on the resource type (case constants are defined above).
+func getResourceName(t int) string {
+	switch t {
+	case TYPE_A:
+		return "type A"
+	case TYPE_B:
+		return "type B"
+	case TYPE_MIX:
+		return "MIXED type"
+	default:
+		return "unknown"
+	}
+}
+
+// ============================================================
+//                         GOROUTINES
+// ============================================================
+
+// client cyclically retrieves resources from the warehouse.
+// Each Call blocks until the warehouse has accepted the request.
+func client(id int) {
+	r := Request{id: id, tipo: -1}
+
+	fmt.Printf("[CLIENT %d] Started\n", id)
+	for i := 0; i < 5; i++ {
+		// Random choice of resource type (TYPE_A, TYPE_B, or TYPE_MIX).
+		tipoRand := rand.Intn(100)
+		if tipoRand >= 80 {
+			r.tipo = TYPE_MIX
+		} else {
+			r.tipo = tipoRand % 2 // 0 or 1
+		}
+
+		fmt.Printf("[CLIENT %d] Requesting resource %s\n", id, strings.ToUpper(getResourceName(r.tipo)))
+		retrieve[r.tipo].Call(r)
+
+		fmt.Printf("[CLIENT %d] Retrieving resource %s...\n", id, strings.ToUpper(getResourceName(r.tipo)))
+		sleepRandTime(3) // simulate retrieval
+
+		endRetrieve.Call(r)
+	}
+
+	done <- true
+	fmt.Printf("[CLIENT %d] Terminating\n", id)
+}
+
+// supplier cyclically restocks the warehouse with a certain type of resource.
+func supplier(resourceType int) {
+	r := Request{tipo: resourceType}
+
+	fmt.Printf("[SUPPLIER %s] Started\n", strings.ToUpper(getResourceName(resourceType)))
+	for {
+		sleepRandTimeRange(5, 10)
+
+		fmt.Printf("[SUPPLIER %s] I want to restock the warehouse\n", strings.ToUpper(getResourceName(resourceType)))
+		restock[resourceType].Call(r)
+
+		fmt.Printf("[SUPPLIER %s] Restocking in progress...\n", strings.ToUpper(getResourceName(resourceType)))
+		sleepRandTimeRange(3, 5) // simulate restocking
+
+		endRestock.Call(r)
+		fmt.Printf("[SUPPLIER %s] Restocking completed\n", strings.ToUpper(getResourceName(resourceType)))
+
+		select {
+		case <-stopSupplier:
+			fmt.Printf("[SUPPLIER %s] Terminating\n", strings.ToUpper(getResourceName(resourceType)))
+			done <- true
+			return
+		default:
+			continue
+		}
+	}
+}
+
+// warehouse is the server task: one select alternative per guarded accept.
+func warehouse() {
+	resources := [2]int{MAX_A, MAX_B}
+	activePrel := [2]int{0, 0}
+	activeRestock := [2]bool{false, false}
+
+	// Admin commands (see admin.go), as in template.go
+	paused := false
+	capacity := [2]int{MAX_A, MAX_B}
+
+	fmt.Printf("[WAREHOUSE] Started. Initial state: A: %d/%d, B: %d/%d\n",
+		resources[TYPE_A], capacity[TYPE_A], resources[TYPE_B], capacity[TYPE_B])
+
+	for {
+		select {
+		//---------------------------------------------------
+		//             RETRIEVAL (START)
+		//---------------------------------------------------
+		case c := <-retrieve[TYPE_A].When(
+			!paused &&
+				((LOT_A * (activePrel[TYPE_A] + 1)) <= resources[TYPE_A]) &&
+				(!activeRestock[TYPE_A]) &&
+				retrieve[TYPE_MIX].Count() == 0):
+			c.Accept(func(req Request) int {
+				activePrel[TYPE_A]++
+				fmt.Printf("[WAREHOUSE] Client %d begins retrieval of %d (type A)\n", req.id, LOT_A)
+				return 1
+			})
+
+		case c := <-retrieve[TYPE_B].When(
+			!paused &&
+				((LOT_B * (activePrel[TYPE_B] + 1)) <= resources[TYPE_B]) &&
+				(!activeRestock[TYPE_B]) &&
+				retrieve.Count(TYPE_MIX, TYPE_A) == 0):
+			c.Accept(func(req Request) int {
+				activePrel[TYPE_B]++
+				fmt.Printf("[WAREHOUSE] Client %d begins retrieval of %d (type B)\n", req.id, LOT_B)
+				return 1
+			})
+
+		case c := <-retrieve[TYPE_MIX].When(
+			!paused &&
+				((LOT_MIX*(activePrel[TYPE_A]+1)) <= resources[TYPE_A] &&
+					(LOT_MIX*(activePrel[TYPE_B]+1)) <= resources[TYPE_B]) &&
+				(!activeRestock[TYPE_A] && !activeRestock[TYPE_B])):
+			c.Accept(func(req Request) int {
+				activePrel[TYPE_A]++
+				activePrel[TYPE_B]++
+				fmt.Printf("[WAREHOUSE] Client %d begins MIXED retrieval of %d (A) and %d (B)\n",
+					req.id, LOT_MIX, LOT_MIX)
+				return 1
+			})
+
+		//---------------------------------------------------
+		//             RETRIEVAL (END)
+		//---------------------------------------------------
+		case c := <-endRetrieve.Accept():
+			c.Accept(func(req Request) int {
+				switch req.tipo {
+				case TYPE_A:
+					resources[TYPE_A] -= LOT_A
+					activePrel[TYPE_A]--
+				case TYPE_B:
+					resources[TYPE_B] -= LOT_B
+					activePrel[TYPE_B]--
+				case TYPE_MIX:
+					resources[TYPE_A] -= LOT_MIX
+					resources[TYPE_B] -= LOT_MIX
+					activePrel[TYPE_A]--
+					activePrel[TYPE_B]--
+				default:
+					fmt.Println("[WAREHOUSE] ERROR: invalid resource type.")
+					return -1
+				}
+				fmt.Printf("[WAREHOUSE] Client %d has finished. State: A: %d/%d, B: %d/%d\n",
+					req.id, resources[TYPE_A], capacity[TYPE_A], resources[TYPE_B], capacity[TYPE_B])
+				return 1
+			})
+
+		//---------------------------------------------------
+		//           RESTOCK (START)
+		//---------------------------------------------------
+		case c := <-restock[TYPE_A].When(
+			(activePrel[TYPE_A] == 0) &&
+				(resources[TYPE_A] <= resources[TYPE_B] || restock[TYPE_B].Count() == 0)):
+			c.Accept(func(Request) int {
+				activeRestock[TYPE_A] = true
+				fmt.Printf("[WAREHOUSE] Starting restock of A...\n")
+				return 1
+			})
+
+		case c := <-restock[TYPE_B].When(
+			(activePrel[TYPE_B] == 0) &&
+				(resources[TYPE_B] < resources[TYPE_A] || restock[TYPE_A].Count() == 0)):
+			c.Accept(func(Request) int {
+				activeRestock[TYPE_B] = true
+				fmt.Printf("[WAREHOUSE] Starting restock of B...\n")
+				return 1
+			})
+
+		//---------------------------------------------------
+		//           RESTOCK (END)
+		//---------------------------------------------------
+		case c := <-endRestock.Accept():
+			c.Accept(func(req Request) int {
+				switch req.tipo {
+				case TYPE_A:
+					resources[TYPE_A] = capacity[TYPE_A]
+					activeRestock[TYPE_A] = false
+				case TYPE_B:
+					resources[TYPE_B] = capacity[TYPE_B]
+					activeRestock[TYPE_B] = false
+				default:
+					fmt.Println("[WAREHOUSE] ERROR: invalid resource type.")
+					return -1
+				}
+				fmt.Printf("[WAREHOUSE] Finished restocking %s. A: %d/%d, B: %d/%d\n",
+					strings.ToUpper(getResourceName(req.tipo)), resources[TYPE_A], capacity[TYPE_A], resources[TYPE_B], capacity[TYPE_B])
+				return 1
+			})
+
+		//---------------------------------------------------
+		//             ADMIN
+		//---------------------------------------------------
+		case cmd := <-adminCommands:
+			switch cmd.Op {
+			case ADMIN_PAUSE, ADMIN_RESUME:
+				paused = cmd.Op == ADMIN_PAUSE
+				fmt.Printf("[WAREHOUSE] Admin: %s\n", cmd.Op)
+				cmd.Reply("paused: %v", paused)
+			case ADMIN_SET:
+				switch cmd.Name {
+				case "MAX_A":
+					if n, err := cmd.Capacity(MAX_A); err == nil {
+						capacity[TYPE_A] = n
+						fmt.Printf("[WAREHOUSE] Admin: A is restocked to %d\n", n)
+						cmd.Reply("MAX_A: %d, A: %d", n, resources[TYPE_A])
+					}
+				case "MAX_B":
+					if n, err := cmd.Capacity(MAX_B); err == nil {
+						capacity[TYPE_B] = n
+						fmt.Printf("[WAREHOUSE] Admin: B is restocked to %d\n", n)
+						cmd.Reply("MAX_B: %d, B: %d", n, resources[TYPE_B])
+					}
+				default:
+					cmd.Fail("no capacity %s (MAX_A, MAX_B)", cmd.Name)
+				}
+			case ADMIN_OUT, ADMIN_IN:
+				cmd.Fail("nothing to take out of service in the warehouse: change the capacities with set MAX_A|MAX_B <n>")
+			default: // ADMIN_DUMP
+				cmd.Reply("paused: %v; A: %d/%d, %d retrievals, restock %v; B: %d/%d, %d retrievals, restock %v",
+					paused, resources[TYPE_A], capacity[TYPE_A], activePrel[TYPE_A], activeRestock[TYPE_A],
+					resources[TYPE_B], capacity[TYPE_B], activePrel[TYPE_B], activeRestock[TYPE_B])
+			}
+
+		//---------------------------------------------------
+		//             TERMINATION
+		//---------------------------------------------------
+		case c := <-stopWarehouse.Accept():
+			c.Accept(func(struct{}) bool {
+				fmt.Printf("[WAREHOUSE] Terminating\n")
+				return true
+			})
+			return
+		}
+	}
+}
+
+// ============================================================
+//                            MAIN
+// ============================================================
+
+func main() {
+	fmt.Println("[MAIN] Start")
+	rand.Seed(time.Now().UnixNano())
+
+	nClients := 5
+	nSuppliers := 2
+
+	fmt.Printf("[MAIN] How many Clients do you want to start? (max %d): ", MAX_CLIENTS)
+	fmt.Scanf("%d\n", &nClients)
+	if nClients < 2 {
+		fmt.Printf("[MAIN] Too few clients. Using default value: 4.\n")
+		nClients = 4
+	}
+
+	// Admin commands for the warehouse, with -admin (see admin.go)
+	stopAdmin := StartAdminFromFlags()
+
+	go warehouse()
+	for i := 0; i < nSuppliers; i++ {
+		go supplier(i)
+	}
+	for i := 0; i < nClients; i++ {
+		go client(i)
+	}
+
+	for i := 0; i < nClients; i++ {
+		<-done
+	}
+	for i := 0; i < nSuppliers; i++ {
+		stopSupplier <- true
+	}
+	for i := 0; i < nSuppliers; i++ {
+		<-done
+	}
+
+	// The entry call returns only after the warehouse has accepted it.
+	stopWarehouse.Call(struct{}{})
+	stopAdmin()
+
+	fmt.Println("[MAIN] End")
+}
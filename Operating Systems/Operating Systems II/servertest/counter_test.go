@@ -0,0 +1,141 @@
+// Tests of the shared helpers on the smallest server: a counter with two
+// slots, acquired on acquire (answered on the reply channel of the client)
+// and released on release.
+//
+// Run with:
+//     go test workload.go leaks.go servertest_test.go counter_test.go
+//     go test -run XXX -bench . workload.go leaks.go servertest_test.go counter_test.go
+
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const SLOTS = 2
+
+type slotRequest struct {
+	id    int
+	reply chan bool
+}
+
+var acquire chan slotRequest
+var release chan bool
+var quit chan bool
+
+func initCounter(size int) {
+	acquire = make(chan slotRequest, size)
+	release = make(chan bool)
+	quit = make(chan bool)
+}
+
+func counter() {
+	free := SLOTS
+	for {
+		var guarded chan slotRequest
+		if free > 0 {
+			guarded = acquire
+		}
+		select {
+		case r := <-guarded:
+			free--
+			r.reply <- true
+		case <-release:
+			free++
+		case <-quit:
+			quit <- true
+			return
+		}
+	}
+}
+
+const (
+	opAcquire = iota // acquire <- id
+	opRelease        // release <- true
+)
+
+var opNames = []string{"acquire", "release"}
+
+func TestScript(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		initCounter(0)
+		go counter()
+		s := newScript[bool](t)
+		steps := slices.Concat(
+			each(opAcquire, 0, SLOTS-1, true),
+			[]step{
+				{opAcquire, 5, nil},
+				{opRelease, 0, []int{5}},
+				{opRelease, 1, nil},
+				{opRelease, 5, nil},
+			},
+		)
+		for _, st := range steps {
+			if st.op == opRelease {
+				go func() { release <- true }()
+			} else {
+				r := slotRequest{st.id, make(chan bool)}
+				s.call(r.id, r.reply, func() { acquire <- r })
+			}
+			s.expect(st.String(), st.want)
+		}
+		// An admin command that waits for a free slot (send is not Admin: the
+		// helpers do not depend on admin.go)
+		s.admin(func(line string) (string, error) {
+			r := slotRequest{9, make(chan bool)}
+			acquire <- r
+			<-r.reply
+			release <- true
+			return line + " done", nil
+		}, "take", "take done", nil)
+		s.admin(func(string) (string, error) { return "", errors.New("refused") }, "x", "error: refused", nil)
+		s.finish()
+		if len(s.last) != SLOTS+1 || !s.last[5] {
+			t.Errorf("last replies %v", s.last)
+		}
+		quit <- true
+		<-quit
+	})
+}
+
+func TestPercentile(t *testing.T) {
+	var lat []time.Duration
+	for i := 1; i <= 100; i++ {
+		lat = append(lat, time.Duration(i))
+	}
+	for _, tc := range []struct{ p, want int }{{0, 1}, {50, 50}, {99, 99}, {100, 100}} {
+		if got := percentile(lat, tc.p); got != time.Duration(tc.want) {
+			t.Errorf("percentile %d = %d, want %d", tc.p, got, tc.want)
+		}
+	}
+	if percentile(nil, 50) != 0 {
+		t.Error("percentile of no latencies")
+	}
+}
+
+func BenchmarkCounter(b *testing.B) {
+	for _, m := range bufferModes(SLOTS) {
+		b.Run(m.name, func(b *testing.B) {
+			initCounter(m.size)
+			go counter()
+			replies := make([]chan bool, 2*SLOTS)
+			for id := range replies {
+				replies[id] = make(chan bool, 1)
+			}
+			measure(b, len(replies), func(id int) time.Duration {
+				t := time.Now()
+				acquire <- slotRequest{id, replies[id]}
+				<-replies[id]
+				d := time.Since(t)
+				release <- true
+				return d
+			})
+			quit <- true
+			<-quit
+		})
+	}
+}
@@ -0,0 +1,241 @@
+// -----------------------------------------------------------------------------------
+// SHARED HELPERS OF THE SERVER TESTS AND BENCHMARKS
+//
+// The tests of every scenario feed its server scripted sequences of requests
+// under a virtual clock, and its benchmarks measure the grants of synthetic
+// clients: this file has the parts that do not depend on the scenario.
+//   - script, step, each:     the steps of a test and the clients granted after each;
+//   - bufferModes, measure:   the buffer sizes compared and the measurement of a benchmark;
+//   - silence, TestMain:      what the server prints, and the goroutines left alive.
+// A scenario defines its op* constants and their names (opNames), and how a step
+// of each op is sent to its server.
+//
+// Run the tests of the helpers with:
+//     go test workload.go leaks.go servertest_test.go counter_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// ============================================================
+//                   MEASUREMENT HELPERS
+// ============================================================
+
+// bufferMode is a size of the request channels compared by the benchmarks.
+type bufferMode struct {
+	name string
+	size int
+}
+
+// bufferModes returns the sizes compared by the benchmarks: unbuffered request
+// channels, and buffered ones of the given size (the buffer of the scenario).
+func bufferModes(size int) []bufferMode {
+	return []bufferMode{{"unbuffered", 0}, {"buffered", size}}
+}
+
+// silence discards what the server prints until the test or benchmark ends.
+func silence(tb testing.TB) {
+	stdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	tb.Cleanup(func() {
+		os.Stdout.Close()
+		os.Stdout = stdout
+	})
+}
+
+// measure runs cycle on `clients` concurrent clients until b.N grants have been
+// obtained. cycle performs one full client cycle and returns the time between
+// the request and its grant.
+func measure(b *testing.B, clients int, cycle func(id int) time.Duration) {
+	var left atomic.Int64
+	left.Store(int64(b.N))
+	lat := make([][]time.Duration, clients)
+	for id := range lat {
+		lat[id] = make([]time.Duration, 0, b.N/clients+1)
+	}
+	var wg sync.WaitGroup
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for id := 0; id < clients; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for left.Add(-1) >= 0 {
+				lat[id] = append(lat[id], cycle(id))
+			}
+		}(id)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	b.StopTimer()
+	report(b, slices.Concat(lat...), elapsed)
+}
+
+// report reports the grants per second and the latency percentiles of the
+// latencies measured in elapsed.
+func report(b *testing.B, lat []time.Duration, elapsed time.Duration) {
+	slices.Sort(lat)
+	b.ReportMetric(float64(len(lat))/elapsed.Seconds(), "grants/s")
+	b.ReportMetric(float64(percentile(lat, 50)), "p50-ns")
+	b.ReportMetric(float64(percentile(lat, 99)), "p99-ns")
+}
+
+// percentile returns the p-th percentile of an ascending slice.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+// TestMain fails the run if the tests or the benchmarks leave goroutines of the
+// scenario alive (see leaks.go).
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if code == 0 {
+		if err := CheckLeaks(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+// The tests run the server inside a synctest bubble, where time is virtual:
+// its sleeps cost nothing and synctest.Wait returns as soon as every goroutine
+// is blocked, i.e. when the server has done all it could. Requests are sent by
+// short-lived goroutines, while the replies are received by the test itself on
+// unbuffered channels: the server stops at every reply until the test has seen
+// it, so the order of the grants is exact.
+
+// script drives the server through the steps of a test.
+type script[T any] struct {
+	t       *testing.T
+	waiting map[int]chan T // reply channel of every client waiting for the server
+	last    map[int]T      // last reply received by every client
+}
+
+// newScript is called once the server has been started, and lets it reach its
+// select: the guards of the first step are then evaluated before the request
+// arrives, as for every following step.
+func newScript[T any](t *testing.T) *script[T] {
+	s := &script[T]{t: t, waiting: map[int]chan T{}, last: map[int]T{}}
+	s.grants()
+	return s
+}
+
+// call runs send in a new goroutine on behalf of client id,
+// which then waits for the reply of the server on reply.
+func (s *script[T]) call(id int, reply chan T, send func()) {
+	if _, ok := s.waiting[id]; ok {
+		s.t.Fatalf("client %d is already waiting for the server", id)
+	}
+	s.waiting[id] = reply
+	go send()
+}
+
+// grants lets the server run until it blocks and returns the clients
+// that received a reply meanwhile, in order.
+func (s *script[T]) grants() []int {
+	var got []int
+	for {
+		time.Sleep(time.Hour) // fires the timers of the server
+		synctest.Wait()
+		id, ok := s.next()
+		if !ok {
+			return got
+		}
+		got = append(got, id)
+	}
+}
+
+// next receives the reply the server is blocked on, if any.
+func (s *script[T]) next() (int, bool) {
+	for id, reply := range s.waiting {
+		select {
+		case v := <-reply:
+			delete(s.waiting, id)
+			s.last[id] = v
+			return id, true
+		default:
+		}
+	}
+	return 0, false
+}
+
+// expect checks the clients granted after an action.
+func (s *script[T]) expect(action string, want []int) {
+	s.t.Helper()
+	if got := s.grants(); !slices.Equal(got, want) {
+		s.t.Errorf("after %s: granted %v, want %v", action, got, want)
+	}
+}
+
+// admin sends an admin command with send (Admin, see admin.go: it is passed by
+// the scenarios that have admin commands) and checks the answer of the server,
+// "error: ..." if it refused the command, and the clients granted once it has
+// reacted to it.
+func (s *script[T]) admin(send func(line string) (string, error), line, answer string, want []int) {
+	s.t.Helper()
+	reply := make(chan string, 1)
+	go func() {
+		text, err := send(line)
+		if err != nil {
+			text = "error: " + err.Error()
+		}
+		reply <- text
+	}()
+	s.expect("admin "+line, want)
+	if got := <-reply; got != answer {
+		s.t.Errorf("admin %s: %q, want %q", line, got, answer)
+	}
+}
+
+// finish checks that no client is still waiting for the server.
+func (s *script[T]) finish() {
+	s.t.Helper()
+	if len(s.waiting) > 0 {
+		s.t.Errorf("clients still waiting at the end: %v", slices.Sorted(maps.Keys(s.waiting)))
+	}
+}
+
+// step is an action of a client, followed by the clients that must be
+// granted once the server has reacted to it, in order.
+type step struct {
+	op   int // one of the op* constants of the scenario
+	id   int
+	want []int
+}
+
+func (st step) String() string { return fmt.Sprintf("%s %d", opNames[st.op], st.id) }
+
+// each returns the steps performing op for clients from..to, all served at once
+// (want == true) or none of them.
+func each(op, from, to int, want bool) []step {
+	var steps []step
+	for id := from; id <= to; id++ {
+		st := step{op: op, id: id}
+		if want {
+			st.want = []int{id}
+		}
+		steps = append(steps, st)
+	}
+	return steps
+}
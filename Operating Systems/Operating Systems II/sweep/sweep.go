@@ -0,0 +1,707 @@
+// -----------------------------------------------------------------------------------
+// SWEEP: A SCENARIO OVER A GRID OF ITS PARAMETERS
+//
+// The sizes of a scenario are constants (NUM_OFFICES, MAX_WAITING_ROOM, NT, MAX...),
+// often used as array lengths: to see how the waits change with them one edits the
+// source, runs the program and copies the numbers, for every combination. sweep
+// does it for a grid of values: for every point it writes a copy of the files
+// with the constants set to the values of the point, builds it and runs main()
+// with -seed 1..N in simulated time (a testing/synctest bubble: the sleeps of the
+// scenario cost nothing), then collects the end-of-run metrics the scenario writes
+// with -metrics (see WriteMetrics in metrics.go) in a CSV, one row per run. The
+// runs with the same seed draw the same workload (see -seed in workload.go), so
+// two sweeps of a scenario that draws in a fixed order give the same rows:
+//
+//     NUM_OFFICES,MAX_WAITING_ROOM,seed,served,elapsed,throughput,mean_wait,...
+//     3,5,1,100,476,0.210084,192.62,...
+//
+// The values of a parameter are written as
+//     NAME=3..8           from 3 to 8
+//     NAME=5..20/5        from 5 to 20 in steps of 5
+//     NAME=2,4,8          the listed values
+// and the grid is the product of the parameters. The runs of the different points
+// are made in parallel (-j). A run that fails (e.g. it deadlocks, or leaks
+// goroutines) is reported on the standard error with its output and has no row;
+// sweep then exits with status 1. As in the tests, the channels must be made by
+// main (e.g. initChannels), inside the bubble: goroutines blocked on channels
+// made outside of it do not let the simulated time advance.
+//
+// Run with:
+//     go run sweep.go [-p NAME=values]... [-target cond] [-seeds N] [-o results.csv] [-j N] [-input text] files.go [program flags]
+// e.g. from writtenExams/10-01-2022 and writtenExams/07-01-2025:
+//     go run ../../sweep/sweep.go -p NUM_OFFICES=3..8 -p MAX_WAITING_ROOM=5..20/5 -o office.csv examSol.go workload.go fairness.go metrics.go leaks.go policy.go queueing.go des.go causal.go admin.go
+//     go run ../../sweep/sweep.go -p NT=3..6 -p MAX=12..24/4 -seeds 10 -o gym.csv examSolB.go workload.go fairness.go metrics.go leaks.go admin.go
+// -input is the standard input of the programs that ask for their sizes (with \n
+// between the answers), e.g. -input '5\n3\n' for lab/lab3/ex1.go.
+//
+// With -target, sweep searches the fewest resources that meet a target instead of
+// running the whole grid. The target is a metric of the run, a comparison and a
+// value, mean of the seeds: p95_wait<=3, or throughput>=orig and
+// throughput>=0.99*orig, where orig is the metric with the constants of the files.
+// The values of every parameter go from the fewest resources to the most, and the
+// target must get easier with more of each: for every combination of the others
+// the smallest value of the last parameter that meets it is found by binary
+// search. A point whose runs fail (e.g. the deposits are too small and it
+// deadlocks) does not meet it. sweep prints the point found for every
+// combination and, on the MINIMAL line, those of them no other one improves in a
+// parameter without worsening another; -o writes the runs it made. It exits with
+// status 1 if no point meets the target. E.g. from writtenExams/07-01-2025 and
+// lab/lab4:
+//     go run ../../sweep/sweep.go -p NT=1..4 -p MAX=4..12/2 -target 'p95_wait<=3' examSolA.go workload.go fairness.go metrics.go leaks.go admin.go
+//     go run ../../sweep/sweep.go -p maxP=1..5 -p maxC=1..5 -target 'throughput>=0.99*orig' sol4.2.go workload.go fairness.go metrics.go leaks.go admin.go
+//
+// Run the tests with:
+//     go test sweep.go sweep_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// harness runs main in simulated time.
+const harness = `package main
+
+import (
+	"testing"
+	"testing/synctest"
+)
+
+func TestSweep(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) { main() })
+}
+`
+
+// param is a parameter of the grid: a constant and its values, as Go literals.
+type param struct {
+	name   string
+	values []string
+}
+
+// params collects the -p flags.
+type params []param
+
+func (ps *params) String() string { return "" }
+
+func (ps *params) Set(s string) error {
+	p, err := parseParam(s)
+	if err == nil {
+		*ps = append(*ps, p)
+	}
+	return err
+}
+
+// Options of a sweep.
+type options struct {
+	params []param
+	seeds  int
+	jobs   int
+	input  string
+}
+
+// result is the outcome of the runs of a point.
+type result struct {
+	rows [][]string // one per successful run: the values of the point, the seed and the metrics
+	keys []string   // names of the metrics
+	err  error
+}
+
+func main() {
+	var ps params
+	flag.Var(&ps, "p", "a parameter and its values: `NAME=3..8`, NAME=5..20/5 or NAME=2,4,8 (repeatable)")
+	seeds := flag.Int("seeds", 5, "runs per point, with -seed 1..`N`")
+	out := flag.String("o", "", "write the CSV to `file` instead of the standard output")
+	jobs := flag.Int("j", runtime.NumCPU(), "points run in parallel")
+	input := flag.String("input", "", "standard input of the program (\\n between the lines)")
+	cond := flag.String("target", "", "search the minimal points that meet `cond`, e.g. p95_wait<=10 or throughput>=0.99*orig")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: sweep [-p NAME=values]... [-target cond] [-seeds N] [-o file] [-j N] [-input text] files.go [program flags]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if len(ps) == 0 || flag.NArg() == 0 || *seeds < 1 || *jobs < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var t target
+	if *cond != "" {
+		var err error
+		if t, err = parseTarget(*cond); err != nil {
+			fmt.Fprintln(os.Stderr, "sweep:", err)
+			os.Exit(2)
+		}
+	}
+	var w io.Writer
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "sweep:", err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		w = f
+	}
+	opts := options{ps, *seeds, *jobs, strings.ReplaceAll(*input, `\n`, "\n")}
+	if *cond != "" {
+		// The report goes to the standard output, the runs to -o if given.
+		met, err := optimize(os.Stdout, w, opts, t, flag.Args())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "sweep:", err)
+			os.Exit(2)
+		}
+		if !met {
+			os.Exit(1)
+		}
+		return
+	}
+	if w == nil {
+		w = os.Stdout
+	}
+	failed, err := sweep(w, opts, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sweep:", err)
+		os.Exit(2)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "sweep: %d runs failed\n", failed)
+		os.Exit(1)
+	}
+}
+
+// parseParam parses NAME=values.
+func parseParam(s string) (param, error) {
+	name, values, ok := strings.Cut(s, "=")
+	if !ok || !token.IsIdentifier(name) {
+		return param{}, fmt.Errorf("%q: expected NAME=values", s)
+	}
+	p := param{name: name}
+	if lo, hi, ok := strings.Cut(values, ".."); ok {
+		hi, step, _ := strings.Cut(hi, "/")
+		if step == "" {
+			step = "1"
+		}
+		from, err1 := strconv.Atoi(lo)
+		to, err2 := strconv.Atoi(hi)
+		by, err3 := strconv.Atoi(step)
+		if err := errors.Join(err1, err2, err3); err != nil || by < 1 || from > to {
+			return param{}, fmt.Errorf("%q: expected NAME=FROM..TO or NAME=FROM..TO/STEP, with FROM <= TO and STEP >= 1", s)
+		}
+		for v := from; v <= to; v += by {
+			p.values = append(p.values, strconv.Itoa(v))
+		}
+		return p, nil
+	}
+	for _, v := range strings.Split(values, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			return param{}, fmt.Errorf("%q: empty value", s)
+		}
+		p.values = append(p.values, v)
+	}
+	return p, nil
+}
+
+// grid returns the points of the product of the parameters, the last one
+// changing fastest.
+func grid(ps []param) [][]string {
+	points := [][]string{nil}
+	for _, p := range ps {
+		var next [][]string
+		for _, pt := range points {
+			for _, v := range p.values {
+				next = append(next, append(append([]string(nil), pt...), v))
+			}
+		}
+		points = next
+	}
+	return points
+}
+
+// program is the scenario to run: its files and the flags of main.
+type program struct {
+	files, flags []string
+	srcs         map[string][]byte
+}
+
+// loadProgram reads the .go files in args (the other arguments are the flags
+// of main) and checks that they declare the constants of ps.
+func loadProgram(args []string, ps []param) (*program, error) {
+	pr := &program{srcs: map[string][]byte{}}
+	for _, a := range args {
+		if strings.HasSuffix(a, ".go") && len(pr.flags) == 0 {
+			pr.files = append(pr.files, a)
+		} else {
+			pr.flags = append(pr.flags, a)
+		}
+	}
+	if len(pr.files) == 0 {
+		return nil, errors.New("no .go files")
+	}
+	for _, name := range pr.files {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		pr.srcs[name] = src
+	}
+	for _, p := range ps {
+		if _, err := setConsts(pr.srcs, pr.files, map[string]string{p.name: p.values[0]}); err != nil {
+			return nil, err
+		}
+	}
+	return pr, nil
+}
+
+// sweep runs the program made of the .go files in args (the other arguments
+// are its flags) on every point of the grid and writes the CSV to w. It
+// returns the number of failed runs.
+func sweep(w io.Writer, opts options, args []string) (int, error) {
+	pr, err := loadProgram(args, opts.params)
+	if err != nil {
+		return 0, err
+	}
+	points := grid(opts.params)
+	results := make([]result, len(points))
+	var wg sync.WaitGroup
+	sem := make(chan bool, opts.jobs)
+	for i, pt := range points {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+			results[i] = pr.run(opts, pt)
+		}()
+	}
+	wg.Wait()
+	failed := 0
+	for i, r := range results {
+		if r.err != nil {
+			failed += opts.seeds - len(r.rows)
+			fmt.Fprintf(os.Stderr, "sweep: %s: %v\n", pointName(opts.params, points[i]), r.err)
+		}
+	}
+	return failed, writeCSV(w, opts.params, results)
+}
+
+// writeCSV writes the rows of the results.
+func writeCSV(w io.Writer, ps []param, results []result) error {
+	out := csv.NewWriter(w)
+	header := false
+	for _, r := range results {
+		if !header && len(r.rows) > 0 {
+			var names []string
+			for _, p := range ps {
+				names = append(names, p.name)
+			}
+			out.Write(append(append(names, "seed"), r.keys...))
+			header = true
+		}
+		out.WriteAll(r.rows)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// pointName formats a point of the grid, e.g. NT=3 MAX=12.
+func pointName(ps []param, pt []string) string {
+	var s []string
+	for i, p := range ps {
+		s = append(s, p.name+"="+pt[i])
+	}
+	return strings.Join(s, " ")
+}
+
+// run builds the program with the values of pt and runs it once per seed.
+func (pr *program) run(opts options, pt []string) result {
+	values := map[string]string{}
+	for i, p := range opts.params {
+		values[p.name] = pt[i]
+	}
+	edited, err := setConsts(pr.srcs, pr.files, values)
+	if err != nil {
+		return result{err: err}
+	}
+	dir, err := os.MkdirTemp("", "sweep")
+	if err != nil {
+		return result{err: err}
+	}
+	defer os.RemoveAll(dir)
+	edited["sweep_main_test.go"] = []byte(harness)
+	var paths []string
+	for name, src := range edited {
+		path := filepath.Join(dir, filepath.Base(name))
+		if err := os.WriteFile(path, src, 0o644); err != nil {
+			return result{err: err}
+		}
+		paths = append(paths, path)
+	}
+	bin := filepath.Join(dir, "sweep.test")
+	build := exec.Command("go", append([]string{"test", "-c", "-o", bin}, paths...)...)
+	if out, err := build.CombinedOutput(); err != nil {
+		return result{err: fmt.Errorf("build: %v\n%s", err, out)}
+	}
+
+	var r result
+	for seed := 1; seed <= opts.seeds; seed++ {
+		metrics := filepath.Join(dir, "metrics.txt")
+		os.Remove(metrics)
+		run := exec.Command(bin, append([]string{"-test.run=^TestSweep$", "-seed", strconv.Itoa(seed), "-metrics", metrics}, pr.flags...)...)
+		// The program reads its files (e.g. traces) where it is.
+		run.Dir = filepath.Dir(pr.files[0])
+		run.Stdin = strings.NewReader(opts.input)
+		var out bytes.Buffer
+		run.Stdout, run.Stderr = &out, &out
+		if err := run.Run(); err != nil {
+			r.err = errors.Join(r.err, fmt.Errorf("seed %d: %v\n%s", seed, err, excerpt(out.String(), 20)))
+			continue
+		}
+		keys, vals, err := readMetrics(metrics)
+		if err != nil {
+			r.err = errors.Join(r.err, fmt.Errorf("seed %d: %v", seed, err))
+			continue
+		}
+		r.keys = keys
+		r.rows = append(r.rows, append(append(append([]string(nil), pt...), strconv.Itoa(seed)), vals...))
+	}
+	return r
+}
+
+// target is the condition a point must meet: the mean of a metric over the
+// seeds compared with a value or, if orig, with value times the mean of the
+// metric with the original constants.
+type target struct {
+	metric string
+	op     string
+	value  float64
+	orig   bool
+}
+
+var targetRE = regexp.MustCompile(`^(\w+)\s*(<=|>=|<|>)\s*(?:([0-9.eE+-]+)\s*\*\s*)?(orig|[0-9.eE+-]+)$`)
+
+// parseTarget parses METRIC OP VALUE, where OP is <=, >=, < or > and VALUE a
+// number, orig or K*orig.
+func parseTarget(s string) (target, error) {
+	m := targetRE.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return target{}, fmt.Errorf("target %q: expected METRIC<=VALUE (or >=, <, >), VALUE a number, orig or K*orig", s)
+	}
+	t := target{metric: m[1], op: m[2], value: 1}
+	if m[4] == "orig" {
+		t.orig = true
+	} else if m[3] != "" {
+		return target{}, fmt.Errorf("target %q: K* goes only before orig", s)
+	}
+	for _, v := range []string{m[3], m[4]} {
+		if v != "" && v != "orig" {
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return target{}, fmt.Errorf("target %q: bad number %s", s, v)
+			}
+			t.value = x
+		}
+	}
+	return t, nil
+}
+
+func (t target) String() string {
+	v := strconv.FormatFloat(t.value, 'g', -1, 64)
+	if t.orig {
+		v = "orig"
+		if t.value != 1 {
+			v = strconv.FormatFloat(t.value, 'g', -1, 64) + "*orig"
+		}
+	}
+	return t.metric + " " + t.op + " " + v
+}
+
+// holds reports whether v meets the target, whose value is bound.
+func (t target) holds(v, bound float64) bool {
+	switch t.op {
+	case "<=":
+		return v <= bound
+	case ">=":
+		return v >= bound
+	case "<":
+		return v < bound
+	default:
+		return v > bound
+	}
+}
+
+// mean returns the mean of a metric over the runs of a point, which must all
+// have succeeded.
+func mean(r result, metric string, params int) (float64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	k := slices.Index(r.keys, metric)
+	if k < 0 {
+		return 0, fmt.Errorf("no metric %s (the metrics are %s)", metric, strings.Join(r.keys, ", "))
+	}
+	sum := 0.0
+	for _, row := range r.rows {
+		v, err := strconv.ParseFloat(row[params+1+k], 64)
+		if err != nil {
+			return 0, err
+		}
+		sum += v
+	}
+	return sum / float64(len(r.rows)), nil
+}
+
+// optimize searches the minimal points of the grid that meet the target and
+// writes the report to w and, if csvOut is not nil, the runs it made. It
+// returns whether some point meets the target.
+//
+// The values of every parameter go from the fewest resources to the most, and
+// the target is assumed to get easier with more of each: for every combination
+// of the other parameters the smallest value of the last one that meets it is
+// found by binary search. The minimal points are those of them that no other
+// one improves in some parameter without worsening another.
+func optimize(w, csvOut io.Writer, opts options, t target, args []string) (bool, error) {
+	pr, err := loadProgram(args, opts.params)
+	if err != nil {
+		return false, err
+	}
+	bound := t.value
+	fmt.Fprintf(w, "TARGET %s, mean of %d seeds\n", t, opts.seeds)
+	if t.orig {
+		base := opts
+		base.params = nil
+		m, err := mean(pr.run(base, nil), t.metric, 0)
+		if err != nil {
+			return false, fmt.Errorf("with the original constants: %v", err)
+		}
+		bound *= m
+		fmt.Fprintf(w, "orig: %s = %.6g with the constants of the files\n", t.metric, m)
+	}
+
+	var mu sync.Mutex
+	runs := map[string]result{}
+	// eval runs a point and returns the mean of the metric and whether it
+	// meets the target.
+	eval := func(pt []string) (float64, bool) {
+		r := pr.run(opts, pt)
+		mu.Lock()
+		runs[strings.Join(pt, "\x00")] = r
+		mu.Unlock()
+		v, err := mean(r, t.metric, len(opts.params))
+		if err != nil {
+			// A point whose runs fail (e.g. a deadlock) does not meet it.
+			fmt.Fprintf(os.Stderr, "sweep: %s: not met: %s\n", pointName(opts.params, pt), reason(err))
+			return 0, false
+		}
+		return v, t.holds(v, bound)
+	}
+
+	last := opts.params[len(opts.params)-1]
+	others := grid(opts.params[:len(opts.params)-1])
+	found := make([]int, len(others)) // index in last.values, -1: not met
+	metric := make([]float64, len(others))
+	var wg sync.WaitGroup
+	sem := make(chan bool, opts.jobs)
+	for i, o := range others {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+			point := func(k int) []string { return append(append([]string(nil), o...), last.values[k]) }
+			lo, hi := 0, len(last.values)-1
+			v, ok := eval(point(hi))
+			if !ok {
+				found[i] = -1
+				return
+			}
+			// The target is met at hi.
+			for lo < hi {
+				mid := (lo + hi) / 2
+				if v2, ok := eval(point(mid)); ok {
+					hi, v = mid, v2
+				} else {
+					lo = mid + 1
+				}
+			}
+			found[i], metric[i] = hi, v
+		}()
+	}
+	wg.Wait()
+
+	// index returns the indexes of the values of a point in the parameters.
+	index := func(i int) []int {
+		var idx []int
+		for k, v := range others[i] {
+			idx = append(idx, slices.Index(opts.params[k].values, v))
+		}
+		return append(idx, found[i])
+	}
+	var minimal []string
+	for i, o := range others {
+		if found[i] < 0 {
+			prefix := pointName(opts.params[:len(opts.params)-1], o)
+			if prefix != "" {
+				prefix += " "
+			}
+			fmt.Fprintf(w, "%s%s=?  not met up to %s=%s\n", prefix, last.name, last.name, last.values[len(last.values)-1])
+			continue
+		}
+		pt := append(append([]string(nil), o...), last.values[found[i]])
+		fmt.Fprintf(w, "%s  %s = %.6g\n", pointName(opts.params, pt), t.metric, metric[i])
+		dominated := false
+		for j := range others {
+			if j != i && found[j] >= 0 && dominates(index(j), index(i)) {
+				dominated = true
+			}
+		}
+		if !dominated {
+			minimal = append(minimal, pointName(opts.params, pt))
+		}
+	}
+	if len(minimal) == 0 {
+		fmt.Fprintf(w, "No point of the grid meets the target\n")
+	} else {
+		fmt.Fprintf(w, "MINIMAL: %s\n", strings.Join(minimal, "; "))
+	}
+
+	if csvOut != nil {
+		var results []result
+		for _, pt := range grid(opts.params) {
+			if r, ok := runs[strings.Join(pt, "\x00")]; ok {
+				results = append(results, r)
+			}
+		}
+		if err := writeCSV(csvOut, opts.params, results); err != nil {
+			return false, err
+		}
+	}
+	return len(minimal) > 0, nil
+}
+
+// dominates reports whether the point with indexes a needs no more of any
+// resource than b, and less of one.
+func dominates(a, b []int) bool {
+	less := false
+	for k := range a {
+		if a[k] > b[k] {
+			return false
+		}
+		less = less || a[k] < b[k]
+	}
+	return less
+}
+
+// reason returns the first line of err that tells why a run failed.
+func reason(err error) string {
+	lines := strings.Split(err.Error(), "\n")
+	for _, l := range lines {
+		if strings.HasPrefix(l, "panic:") || strings.HasPrefix(l, "fatal error:") || strings.HasPrefix(l, "LEAK CHECK") {
+			return l
+		}
+	}
+	return lines[0]
+}
+
+// setConsts returns a copy of the sources with the constants in values set to
+// their values. Every constant must be declared, with an explicit value, in
+// one of the files.
+func setConsts(srcs map[string][]byte, files []string, values map[string]string) (map[string][]byte, error) {
+	edited := map[string][]byte{}
+	found := map[string]bool{}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		src := srcs[name]
+		f, err := parser.ParseFile(fset, name, src, 0)
+		if err != nil {
+			return nil, err
+		}
+		type edit struct {
+			start, end int
+			text       string
+		}
+		var edits []edit
+		for _, d := range f.Decls {
+			gd, ok := d.(*ast.GenDecl)
+			if !ok || gd.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for i, id := range vs.Names {
+					v, ok := values[id.Name]
+					if !ok {
+						continue
+					}
+					if i >= len(vs.Values) {
+						return nil, fmt.Errorf("%s: constant %s has no explicit value", fset.Position(id.Pos()), id.Name)
+					}
+					found[id.Name] = true
+					x := vs.Values[i]
+					edits = append(edits, edit{fset.Position(x.Pos()).Offset, fset.Position(x.End()).Offset, v})
+				}
+			}
+		}
+		out := append([]byte(nil), src...)
+		// The edits are in source order: apply them from the last one.
+		for i := len(edits) - 1; i >= 0; i-- {
+			e := edits[i]
+			out = append(out[:e.start:e.start], append([]byte(e.text), out[e.end:]...)...)
+		}
+		edited[name] = out
+	}
+	for name := range values {
+		if !found[name] {
+			return nil, fmt.Errorf("no constant %s in the files", name)
+		}
+	}
+	return edited, nil
+}
+
+// readMetrics reads the name=value lines written by WriteMetrics.
+func readMetrics(path string) (keys, values []string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("no metrics: does main call WriteMetrics? (%v)", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("bad metrics line %q", line)
+		}
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	return keys, values, nil
+}
+
+// excerpt returns n lines of the output of a failed run: from the first error
+// (a panic, a deadlock, a leak or a failed test), or the last ones.
+func excerpt(out string, n int) string {
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	from := max(len(lines)-n, 0)
+	for i, l := range lines {
+		if strings.HasPrefix(l, "panic:") || strings.HasPrefix(l, "fatal error:") ||
+			strings.HasPrefix(l, "LEAK CHECK") || strings.HasPrefix(l, "--- FAIL") {
+			from = i
+			break
+		}
+	}
+	return strings.Join(lines[from:min(from+n, len(lines))], "\n")
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseParam(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"N=3..6", []string{"3", "4", "5", "6"}},
+		{"N=5..20/5", []string{"5", "10", "15", "20"}},
+		{"N=5..21/5", []string{"5", "10", "15", "20"}},
+		{"N=4..4", []string{"4"}},
+		{"N=2, 4,8", []string{"2", "4", "8"}},
+		{"N=0.5", []string{"0.5"}},
+	}
+	for _, tt := range tests {
+		p, err := parseParam(tt.in)
+		if err != nil || p.name != "N" || !slices.Equal(p.values, tt.want) {
+			t.Errorf("parseParam(%q) = %v, %v, want N %v", tt.in, p, err, tt.want)
+		}
+	}
+	for _, in := range []string{"N", "3=1..2", "N=2..1", "N=1..5/0", "N=a..b", "N=1,,2"} {
+		if _, err := parseParam(in); err == nil {
+			t.Errorf("parseParam(%q): no error", in)
+		}
+	}
+}
+
+func TestGrid(t *testing.T) {
+	got := grid([]param{{"A", []string{"1", "2"}}, {"B", []string{"x", "y", "z"}}})
+	want := [][]string{{"1", "x"}, {"1", "y"}, {"1", "z"}, {"2", "x"}, {"2", "y"}, {"2", "z"}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("grid = %v, want %v", got, want)
+	}
+}
+
+func TestSetConsts(t *testing.T) {
+	srcs := map[string][]byte{"a.go": []byte("package main\n\nconst (\n\tA, B = 1, 2\n\tC = B * 3 // C\n)\n\nconst D = iota\n")}
+	edited, err := setConsts(srcs, []string{"a.go"}, map[string]string{"B": "20", "C": "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "package main\n\nconst (\n\tA, B = 1, 20\n\tC = 7 // C\n)\n\nconst D = iota\n"
+	if string(edited["a.go"]) != want {
+		t.Errorf("edited:\n%s\nwant:\n%s", edited["a.go"], want)
+	}
+	if _, err := setConsts(srcs, []string{"a.go"}, map[string]string{"E": "1"}); err == nil {
+		t.Error("missing constant: no error")
+	}
+}
+
+// In testdata/office.go the clients arrive together and keep a desk for one
+// second: with one desk they wait 0, 1, 2 and 3 seconds, with two 0, 0, 1, 1.
+const officeCSV = `SERVERS,seed,served,elapsed,throughput,mean_wait,p95_wait,max_wait,starving,utilization_desks
+1,1,4,4,1,1.5,3,3,0,1
+1,2,4,4,1,1.5,3,3,0,1
+2,1,4,2,2,0.5,1,1,0,1
+2,2,4,2,2,0.5,1,1,0,1
+`
+
+// testFiles returns the files of a program of testdata, with the shared files it
+// links.
+func testFiles(main string) []string {
+	var files []string
+	for _, f := range []string{main, "workload.go", "fairness.go", "metrics.go", "leaks.go"} {
+		files = append(files, filepath.Join("testdata", f))
+	}
+	return files
+}
+
+func TestSweep(t *testing.T) {
+	var out strings.Builder
+	opts := options{params: []param{{"SERVERS", []string{"1", "2"}}}, seeds: 2, jobs: 2}
+	files := testFiles("office.go")
+	failed, err := sweep(&out, opts, files)
+	if err != nil || failed != 0 {
+		t.Fatalf("sweep = %d failed, %v", failed, err)
+	}
+	if out.String() != officeCSV {
+		t.Errorf("CSV:\n%s\nwant:\n%s", out.String(), officeCSV)
+	}
+}
+
+// In testdata/bank.go the arrivals and the services are drawn from the seed:
+// the rows of a point change with the seed, and not from a sweep to the next.
+var bankFiles = testFiles("bank.go")
+
+func TestSweepIsReproducible(t *testing.T) {
+	opts := options{params: []param{{"SERVERS", []string{"1", "2"}}}, seeds: 3, jobs: 2}
+	var runs [2]strings.Builder
+	for i := range runs {
+		if failed, err := sweep(&runs[i], opts, bankFiles); err != nil || failed != 0 {
+			t.Fatalf("sweep = %d failed, %v", failed, err)
+		}
+	}
+	if runs[0].String() != runs[1].String() {
+		t.Fatalf("two sweeps of the same points:\n%s\n%s", runs[0].String(), runs[1].String())
+	}
+	rows := strings.Split(runs[0].String(), "\n")
+	seed1, _ := strings.CutPrefix(rows[1], "1,1,")
+	seed2, _ := strings.CutPrefix(rows[2], "1,2,")
+	if seed1 == seed2 {
+		t.Errorf("seeds 1 and 2 gave the same metrics: %s", seed1)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		in   string
+		want target
+	}{
+		{"p95_wait<=3", target{"p95_wait", "<=", 3, false}},
+		{"mean_wait < 0.5", target{"mean_wait", "<", 0.5, false}},
+		{"throughput>=orig", target{"throughput", ">=", 1, true}},
+		{"throughput>=0.99*orig", target{"throughput", ">=", 0.99, true}},
+	}
+	for _, tt := range tests {
+		if got, err := parseTarget(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseTarget(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"p95_wait", "p95_wait=3", "p95_wait<=x", "throughput>=2*3", "<=3"} {
+		if _, err := parseTarget(in); err == nil {
+			t.Errorf("parseTarget(%q): no error", in)
+		}
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	var out strings.Builder
+	opts := options{params: []param{{"SERVERS", []string{"1", "2", "3"}}}, seeds: 1, jobs: 2}
+	files := testFiles("office.go")
+	met, err := optimize(&out, nil, opts, target{"max_wait", "<=", 1, false}, files)
+	want := "TARGET max_wait <= 1, mean of 1 seeds\nSERVERS=2  max_wait = 1\nMINIMAL: SERVERS=2\n"
+	if err != nil || !met || out.String() != want {
+		t.Errorf("optimize = %v, %v, report:\n%s\nwant:\n%s", met, err, out.String(), want)
+	}
+}
+
+func TestOptimizeIsReproducible(t *testing.T) {
+	opts := options{params: []param{{"SERVERS", []string{"1", "2", "3", "4", "5", "6"}}}, seeds: 3, jobs: 2}
+	var reports [2]strings.Builder
+	for i := range reports {
+		met, err := optimize(&reports[i], nil, opts, target{"mean_wait", "<=", 1, false}, bankFiles)
+		if err != nil || !met {
+			t.Fatalf("optimize = %v, %v, report:\n%s", met, err, reports[i].String())
+		}
+	}
+	if reports[0].String() != reports[1].String() {
+		t.Errorf("two searches of the same target:\n%s\n%s", reports[0].String(), reports[1].String())
+	}
+	if !strings.Contains(reports[0].String(), "MINIMAL: SERVERS=3\n") {
+		t.Errorf("report:\n%s\nwant SERVERS=3, the first with a mean wait of at most 1s", reports[0].String())
+	}
+}
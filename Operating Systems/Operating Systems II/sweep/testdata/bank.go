@@ -0,0 +1,82 @@
+// Bank with SERVERS tellers for the tests of sweep with random runs: CLIENTS
+// clients arrive as a Poisson process and keep a teller for an exponential
+// time, drawn by main before the run. Both come from -seed, so the metrics
+// change with the seed, but not between two runs with the same seed.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const SERVERS = 1
+const CLIENTS = 20
+
+// The channels are made by main, inside the bubble of the run.
+var enter chan chan bool
+var exit, done, stop chan bool
+
+var fairness *Fairness
+var tellers *Usage
+var services []time.Duration // by client
+
+func when(b bool, c chan chan bool) chan chan bool {
+	if !b {
+		return nil
+	}
+	return c
+}
+
+func server() {
+	busy := 0
+	for {
+		select {
+		case reply := <-when(busy < SERVERS, enter):
+			busy++
+			tellers.Add(1)
+			reply <- true
+		case <-exit:
+			busy--
+			tellers.Add(-1)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func client(id, _ int) {
+	reply := make(chan bool)
+	ticket := fairness.Request(id, 0)
+	enter <- reply
+	<-reply
+	fairness.Served(ticket)
+	time.Sleep(services[id])
+	exit <- true
+	done <- true
+}
+
+func main() {
+	enter, exit = make(chan chan bool, CLIENTS), make(chan bool, CLIENTS)
+	done, stop = make(chan bool), make(chan bool)
+	workload := WorkloadFromFlags([]string{"client"}, "poisson:rate=1", "client=1")
+	service := ServiceFromFlags("exp:mean=2")
+	services = nil
+	for range CLIENTS {
+		services = append(services, service.Draw())
+	}
+	fairness = FairnessFromFlags([]string{"client"})
+	tellers = NewUsage("tellers", SERVERS)
+	go server()
+	workload.Spawn(CLIENTS, client)
+	for i := 0; i < CLIENTS; i++ {
+		<-done
+	}
+	stop <- true
+	WriteMetrics(fairness, tellers)
+	if err := CheckLeaks(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,75 @@
+// Office with SERVERS desks for the test of sweep: CLIENTS clients arrive
+// together and keep a desk for one second, so in simulated time the metrics
+// depend only on SERVERS.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const SERVERS = 1
+const CLIENTS = 4
+
+// The channels are made by main, inside the bubble of the run.
+var enter chan chan bool
+var exit, done, stop chan bool
+
+var fairness *Fairness
+var desks *Usage
+
+func when(b bool, c chan chan bool) chan chan bool {
+	if !b {
+		return nil
+	}
+	return c
+}
+
+func server() {
+	busy := 0
+	for {
+		select {
+		case reply := <-when(busy < SERVERS, enter):
+			busy++
+			desks.Add(1)
+			reply <- true
+		case <-exit:
+			busy--
+			desks.Add(-1)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func client(id, _ int) {
+	reply := make(chan bool)
+	ticket := fairness.Request(id, 0)
+	enter <- reply
+	<-reply
+	fairness.Served(ticket)
+	time.Sleep(time.Second)
+	exit <- true
+	done <- true
+}
+
+func main() {
+	enter, exit = make(chan chan bool, CLIENTS), make(chan bool, CLIENTS)
+	done, stop = make(chan bool), make(chan bool)
+	workload := WorkloadFromFlags([]string{"client"}, "spread:max=0", "client=1")
+	fairness = FairnessFromFlags([]string{"client"})
+	desks = NewUsage("desks", SERVERS)
+	go server()
+	workload.Spawn(CLIENTS, client)
+	for i := 0; i < CLIENTS; i++ {
+		<-done
+	}
+	stop <- true
+	WriteMetrics(fairness, desks)
+	if err := CheckLeaks(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
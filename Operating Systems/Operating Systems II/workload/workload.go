@@ -0,0 +1,638 @@
+// -----------------------------------------------------------------------------------
+// WORKLOAD GENERATOR FOR THE SCENARIOS
+//
+// The programs of the course decide when clients arrive and what they ask for
+// with ad-hoc code scattered in main() and in the client goroutines:
+//
+//     go client(i)                        +  sleepRandTime(5)   // rand.Intn(5)+1 seconds
+//     tipoRand := rand.Intn(100)          +  if tipoRand >= 80 { r.tipo = TYPE_MIX }
+//
+// This file replaces both with a configurable workload:
+//
+//   - an arrival process, i.e. WHEN the clients arrive:
+//         spread:max=S                    every client arrives at a random whole second in
+//                                         [1, S], independently of the others (what the
+//                                         original programs do; S = 0: all at once)
+//         poisson:rate=R                  Poisson process, R clients per second
+//         burst:size=N,every=S            groups of N clients together, one group every S
+//                                         seconds (the first group at time 0)
+//         diurnal:base=R,peak=R,period=S  Poisson process whose rate goes from base to
+//                                         peak and back once per period (a "day" of S
+//                                         seconds: night at 0, noon at S/2)
+//         trace:file=F.csv                arrivals read from a CSV file, one per line:
+//                                         seconds from the start[,class]
+//   - a class mix, i.e. WHAT they ask for: one weight per request class, by name,
+//     e.g. MIX=20,A=40,B=40. For a population of n clients the classes are assigned
+//     in exact proportion (largest remainder) and in random order; Class() draws a
+//     single class with the same weights, for clients that choose again at every cycle.
+//   - the service times, i.e. HOW LONG a client keeps what it obtained, in the
+//     scenarios that draw them from the workload (10-01-2022, lab3/ex1.go):
+//         uniform:min=A,max=B             a whole number of seconds in [A, B], all equally
+//                                         likely (what the original programs do)
+//         exp:mean=S                      exponential, S seconds on average (with Poisson
+//                                         arrivals, the M/M/c models of queueing.go)
+//
+// Every scenario keeps its original behaviour as default and accepts the flags
+//     -arrivals <process>   -mix <weights>   -seed <n>
+// and those that draw their service times from the workload also
+//     -service <times>
+// A non-zero seed makes the workload reproducible: the plan of the arrivals, the
+// classes drawn and the service times are the same in every run with the same
+// seed. It seeds math/rand too, for the draws of the scenarios (rand.Intn...):
+// since Go 1.24 rand.Seed does nothing unless randseednop=0, which the //go:debug
+// line of this file sets. Those draws repeat as long as they are made in the
+// same order, e.g. by main before the run (drawVisits in 10-01-2022), not by
+// clients that run at the same time and race for the generator.
+//
+// The flags follow the files of the scenario, e.g. from writtenExams/22-12-2021:
+//     go run examSol.go workload.go fairness.go leaks.go checkpoint.go admin.go -arrivals poisson:rate=2 -mix ABITUALE=1,OCCASIONALE=3
+//
+// Run the tests with:
+//     go test workload.go workload_test.go
+// -----------------------------------------------------------------------------------
+
+//go:debug randseednop=0
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================
+//                           FLAGS
+// ============================================================
+
+var (
+	arrivalsFlag = flag.String("arrivals", "",
+		"arrival process: spread:max=S, poisson:rate=R, burst:size=N,every=S, "+
+			"diurnal:base=R,peak=R,period=S or trace:file=F.csv (default: the original one)")
+	mixFlag     = flag.String("mix", "", "weights of the request classes, e.g. MIX=20,A=40,B=40 (default: the original mix)")
+	seedFlag    = flag.Int64("seed", 0, "random seed of the workload (0: based on the current time)")
+	serviceFlag = flag.String("service", "", "service times: uniform:min=S,max=S or exp:mean=S (default: the original ones)")
+)
+
+// ============================================================
+//                     ARRIVAL PROCESSES
+// ============================================================
+
+// Process generates the arrival times of a population of clients.
+type Process interface {
+	// Times returns the offsets from the start of the run of n arrivals.
+	Times(n int, rng *rand.Rand) []time.Duration
+}
+
+// Spread: every client arrives after rand.Intn(Max)+1 seconds (0 if Max == 0).
+type Spread struct {
+	Max int
+}
+
+// Poisson: exponential inter-arrival times, Rate clients per second on average.
+type Poisson struct {
+	Rate float64
+}
+
+// Burst: Size clients arrive together every Every, starting at time 0.
+type Burst struct {
+	Size  int
+	Every time.Duration
+}
+
+// Diurnal: non-homogeneous Poisson process whose rate goes from Base (at time 0)
+// to Peak (at Period/2) and back to Base (at Period), like the clients of a day.
+type Diurnal struct {
+	Base, Peak float64
+	Period     time.Duration
+}
+
+// Trace: arrivals recorded in a file. Class[i] is the class name of the i-th
+// arrival, or "" if the file does not say it (the mix is used instead).
+type Trace struct {
+	At    []time.Duration
+	Class []string
+}
+
+func (p Spread) Times(n int, rng *rand.Rand) []time.Duration {
+	times := make([]time.Duration, n)
+	if p.Max > 0 {
+		for i := range times {
+			times[i] = time.Duration(rng.Intn(p.Max)+1) * time.Second
+		}
+	}
+	return times
+}
+
+func (p Poisson) Times(n int, rng *rand.Rand) []time.Duration {
+	times := make([]time.Duration, n)
+	t := 0.0
+	for i := range times {
+		t += rng.ExpFloat64() / p.Rate
+		times[i] = seconds(t)
+	}
+	return times
+}
+
+func (p Burst) Times(n int, rng *rand.Rand) []time.Duration {
+	times := make([]time.Duration, n)
+	for i := range times {
+		times[i] = time.Duration(i/p.Size) * p.Every
+	}
+	return times
+}
+
+// Rate returns the arrival rate (clients per second) at time t.
+func (p Diurnal) Rate(t time.Duration) float64 {
+	phase := 2 * math.Pi * t.Seconds() / p.Period.Seconds()
+	return p.Base + (p.Peak-p.Base)*(1-math.Cos(phase))/2
+}
+
+// Times uses thinning: candidates are generated at the maximum rate and each one
+// is kept with probability Rate(t)/max.
+func (p Diurnal) Times(n int, rng *rand.Rand) []time.Duration {
+	top := math.Max(p.Base, p.Peak)
+	times := make([]time.Duration, 0, n)
+	t := 0.0
+	for len(times) < n {
+		t += rng.ExpFloat64() / top
+		if rng.Float64()*top <= p.Rate(seconds(t)) {
+			times = append(times, seconds(t))
+		}
+	}
+	return times
+}
+
+// Times exits the program if the trace has fewer than n arrivals, like the
+// errors in the flags.
+func (p *Trace) Times(n int, rng *rand.Rand) []time.Duration {
+	if n > len(p.At) {
+		flagError(fmt.Errorf("workload: the trace has %d arrivals, %d are needed", len(p.At), n))
+	}
+	return append([]time.Duration(nil), p.At[:n]...)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ============================================================
+//                       SERVICE TIMES
+// ============================================================
+
+// Service generates the durations of the services, i.e. how long a client keeps
+// the resource it obtained. It draws from math/rand, seeded by -seed.
+type Service interface {
+	Draw() time.Duration
+}
+
+// Uniform: a whole number of seconds from Min to Max, all equally likely (what
+// the original programs do, e.g. rand.Intn(30)+1 is Uniform{1, 30}).
+type Uniform struct {
+	Min, Max int
+}
+
+// Exponential: exponential durations with the given mean, as in the M/M/c
+// models of queueing theory (see queueing.go).
+type Exponential struct {
+	Mean time.Duration
+}
+
+func (s Uniform) Draw() time.Duration {
+	return time.Duration(rand.Intn(s.Max-s.Min+1)+s.Min) * time.Second
+}
+
+func (s Exponential) Draw() time.Duration {
+	return time.Duration(rand.ExpFloat64() * float64(s.Mean))
+}
+
+// ServiceFromFlags returns the service times of a scenario from the -service
+// flag, or def if it is not given: def should reproduce the original behaviour
+// of the program. On error it exits the program.
+func ServiceFromFlags(def string) Service {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	spec := *serviceFlag
+	if spec == "" {
+		spec = def
+	}
+	s, err := ParseService(spec)
+	if err != nil {
+		flagError(err)
+	}
+	return s
+}
+
+// flagError reports an error in the flags of the workload and exits the program.
+// It is a variable so that the tests can catch the errors.
+var flagError = func(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
+}
+
+// ============================================================
+//                          PARSING
+// ============================================================
+
+// spec is a specification written as kind:key=value,key=value, being parsed.
+type spec struct {
+	text, kind string
+	params     map[string]string
+	err        error // the first error
+}
+
+func parseSpec(text string) *spec {
+	s := &spec{text: text, params: map[string]string{}}
+	kind, args, _ := strings.Cut(text, ":")
+	s.kind = kind
+	if args != "" {
+		for _, kv := range strings.Split(args, ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				s.fail("expected key=value, got %q", kv)
+			}
+			s.params[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return s
+}
+
+// fail records an error, unless there is one already.
+func (s *spec) fail(format string, args ...any) {
+	if s.err == nil {
+		s.err = fmt.Errorf("workload: %q: %s", s.text, fmt.Sprintf(format, args...))
+	}
+}
+
+// number reads a required numeric parameter.
+func (s *spec) number(key string) float64 {
+	v, ok := s.params[key]
+	if !ok {
+		s.fail("missing %s", key)
+		return 0
+	}
+	delete(s.params, key)
+	x, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		s.fail("%s is not a number", key)
+	}
+	return x
+}
+
+// check returns the first error, or an error for a parameter that was not read.
+func (s *spec) check() error {
+	for k := range s.params {
+		s.fail("unknown parameter %s", k)
+	}
+	return s.err
+}
+
+// ParseProcess parses an arrival process written as kind:key=value,key=value.
+func ParseProcess(text string) (Process, error) {
+	s := parseSpec(text)
+	var p Process
+	switch s.kind {
+	case "spread":
+		max := s.number("max")
+		if max < 0 || max != math.Trunc(max) {
+			s.fail("max must be a whole number of seconds >= 0")
+		}
+		p = Spread{int(max)}
+	case "poisson":
+		rate := s.number("rate")
+		if rate <= 0 {
+			s.fail("rate must be > 0")
+		}
+		p = Poisson{rate}
+	case "burst":
+		size, every := s.number("size"), s.number("every")
+		if size < 1 || size != math.Trunc(size) || every < 0 {
+			s.fail("size must be a whole number >= 1 and every >= 0")
+		}
+		p = Burst{int(size), seconds(every)}
+	case "diurnal":
+		base, peak, period := s.number("base"), s.number("peak"), s.number("period")
+		if base < 0 || peak < 0 || base+peak == 0 || period <= 0 {
+			s.fail("rates must be >= 0 (not both 0) and period > 0")
+		}
+		p = Diurnal{base, peak, seconds(period)}
+	case "trace":
+		file, ok := s.params["file"]
+		if !ok {
+			return nil, fmt.Errorf("workload: %q: missing file", text)
+		}
+		delete(s.params, "file")
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("workload: %v", err)
+		}
+		defer f.Close()
+		if p, err = ReadTrace(f); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("workload: unknown arrival process %q", s.kind)
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ParseService parses the service times written as kind:key=value,key=value.
+func ParseService(text string) (Service, error) {
+	s := parseSpec(text)
+	var sv Service
+	switch s.kind {
+	case "uniform":
+		min, max := s.number("min"), s.number("max")
+		if min < 0 || min != math.Trunc(min) || max != math.Trunc(max) || max < min {
+			s.fail("min and max must be whole numbers of seconds, 0 <= min <= max")
+		}
+		sv = Uniform{int(min), int(max)}
+	case "exp":
+		mean := s.number("mean")
+		if mean <= 0 {
+			s.fail("mean must be > 0")
+		}
+		sv = Exponential{seconds(mean)}
+	default:
+		return nil, fmt.Errorf("workload: unknown service times %q", s.kind)
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return sv, nil
+}
+
+// ReadTrace reads a trace: one arrival per line, "seconds[,class]".
+// Empty lines, lines starting with '#' and a header line are skipped;
+// the arrivals are sorted by time.
+func ReadTrace(r io.Reader) (*Trace, error) {
+	type row struct {
+		at    time.Duration
+		class string
+	}
+	var rows []row
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		at, class, _ := strings.Cut(text, ",")
+		s, err := strconv.ParseFloat(strings.TrimSpace(at), 64)
+		if err != nil {
+			if len(rows) == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("workload: trace line %d: %q is not a time", line, at)
+		}
+		if s < 0 {
+			return nil, fmt.Errorf("workload: trace line %d: negative time", line)
+		}
+		rows = append(rows, row{seconds(s), strings.TrimSpace(class)})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("workload: %v", err)
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].at < rows[j].at })
+
+	t := &Trace{}
+	for _, r := range rows {
+		t.At = append(t.At, r.at)
+		t.Class = append(t.Class, r.class)
+	}
+	return t, nil
+}
+
+// ParseMix parses the weights of the classes written as name=weight,name=weight.
+// Names are case-insensitive, missing classes get weight 0.
+func ParseMix(spec string, classes []string) ([]float64, error) {
+	weights := make([]float64, len(classes))
+	for _, kv := range strings.Split(spec, ",") {
+		name, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("workload: mix %q: expected class=weight, got %q", spec, kv)
+		}
+		c := classIndex(classes, strings.TrimSpace(name))
+		if c < 0 {
+			return nil, fmt.Errorf("workload: mix %q: unknown class %q (classes: %s)",
+				spec, name, strings.Join(classes, ", "))
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("workload: mix %q: the weight of %s must be a number >= 0", spec, name)
+		}
+		weights[c] = w
+	}
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("workload: mix %q: all the weights are 0", spec)
+	}
+	return weights, nil
+}
+
+// classIndex returns the index of the class with the given name, or -1.
+func classIndex(classes []string, name string) int {
+	for i, c := range classes {
+		if strings.EqualFold(c, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ============================================================
+//                          WORKLOAD
+// ============================================================
+
+// Arrival is one client of the workload.
+type Arrival struct {
+	At    time.Duration // Offset from the start of the run
+	Class int           // Index of the class, as in the scenario constants
+}
+
+// Workload combines an arrival process with a class mix.
+// It is safe to use from several goroutines.
+type Workload struct {
+	classes []string
+	weights []float64
+	process Process
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewWorkload creates a workload; classes are the names of the request classes,
+// indexed like the constants of the scenario, and weights their mix.
+func NewWorkload(classes []string, process Process, weights []float64, seed int64) (*Workload, error) {
+	if len(weights) != len(classes) {
+		return nil, fmt.Errorf("workload: %d weights for %d classes", len(weights), len(classes))
+	}
+	if t, ok := process.(*Trace); ok {
+		for i, c := range t.Class {
+			if c != "" && classIndex(classes, c) < 0 {
+				return nil, fmt.Errorf("workload: trace arrival %d: unknown class %q (classes: %s)",
+					i+1, c, strings.Join(classes, ", "))
+			}
+		}
+	}
+	return &Workload{
+		classes: classes,
+		weights: weights,
+		process: process,
+		rng:     rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// WorkloadFromFlags creates the workload of a scenario from the command-line flags.
+// defArrivals and defMix are used for the flags that are not given: they should
+// reproduce the original behaviour of the program. On error it exits the program.
+func WorkloadFromFlags(classes []string, defArrivals, defMix string) *Workload {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	arrivals, mix := *arrivalsFlag, *mixFlag
+	if arrivals == "" {
+		arrivals = defArrivals
+	}
+	if mix == "" {
+		mix = defMix
+	}
+	seed := *seedFlag
+	if seed != 0 {
+		rand.Seed(seed)
+	} else {
+		seed = time.Now().UnixNano()
+	}
+
+	process, err := ParseProcess(arrivals)
+	var weights []float64
+	if err == nil {
+		weights, err = ParseMix(mix, classes)
+	}
+	var w *Workload
+	if err == nil {
+		w, err = NewWorkload(classes, process, weights, seed)
+	}
+	if err != nil {
+		flagError(err)
+	}
+	return w
+}
+
+// Plan returns the arrival time and the class of n clients, in order of arrival.
+// The classes follow the mix exactly (up to rounding) unless the trace gives them.
+func (w *Workload) Plan(n int) []Arrival {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	times := w.process.Times(n, w.rng)
+	sort.SliceStable(times, func(i, j int) bool { return times[i] < times[j] })
+	classes := w.quota(n)
+	if t, ok := w.process.(*Trace); ok {
+		for i := range classes {
+			if t.Class[i] != "" {
+				classes[i] = classIndex(w.classes, t.Class[i])
+			}
+		}
+	}
+
+	plan := make([]Arrival, n)
+	for i := range plan {
+		plan[i] = Arrival{times[i], classes[i]}
+	}
+	return plan
+}
+
+// quota assigns n classes in proportion to the weights (largest remainder
+// method) and shuffles them.
+func (w *Workload) quota(n int) []int {
+	total := 0.0
+	for _, wt := range w.weights {
+		total += wt
+	}
+	counts := make([]int, len(w.weights))
+	remainders := make([]int, len(w.weights))
+	assigned := 0
+	for c, wt := range w.weights {
+		q := float64(n) * wt / total
+		counts[c] = int(q)
+		assigned += counts[c]
+		remainders[c] = c
+	}
+	sort.SliceStable(remainders, func(i, j int) bool {
+		qi := float64(n) * w.weights[remainders[i]] / total
+		qj := float64(n) * w.weights[remainders[j]] / total
+		return qi-math.Floor(qi) > qj-math.Floor(qj)
+	})
+	for i := 0; assigned < n; i++ {
+		counts[remainders[i%len(remainders)]]++
+		assigned++
+	}
+
+	classes := make([]int, 0, n)
+	for c, k := range counts {
+		for ; k > 0; k-- {
+			classes = append(classes, c)
+		}
+	}
+	w.rng.Shuffle(len(classes), func(i, j int) { classes[i], classes[j] = classes[j], classes[i] })
+	return classes
+}
+
+// Class draws one class according to the weights of the mix.
+func (w *Workload) Class() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	total := 0.0
+	for _, wt := range w.weights {
+		total += wt
+	}
+	x := w.rng.Float64() * total
+	last := 0
+	for c, wt := range w.weights {
+		if wt == 0 {
+			continue
+		}
+		if x < wt {
+			return c
+		}
+		x -= wt
+		last = c
+	}
+	return last // only reached because of rounding
+}
+
+// Spawn starts n clients following the workload and returns immediately:
+// client id is started as start(id, class) in its own goroutine at its arrival
+// time. It replaces the loop `for i := 0; i < n; i++ { go client(i) }`.
+func (w *Workload) Spawn(n int, start func(id, class int)) {
+	SpawnPlan(w.Plan(n), start)
+}
+
+// SpawnPlan starts the clients of a plan like Spawn, e.g. the same clients that
+// a discrete-event simulation runs (see des.go).
+func SpawnPlan(plan []Arrival, start func(id, class int)) {
+	for id, a := range plan {
+		go func() {
+			time.Sleep(a.At)
+			start(id, a.Class)
+		}()
+	}
+}
@@ -0,0 +1,391 @@
+// Tests for the workload generator: parsing of the flags, statistical shape of
+// the arrival processes and of the service times and exactness of the class mix.
+//
+// Run with:
+//     go test workload.go workload_test.go
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testClasses = []string{"A", "B", "MIX"}
+
+func newTestWorkload(t *testing.T, process Process, mix string) *Workload {
+	t.Helper()
+	weights, err := ParseMix(mix, testClasses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWorkload(testClasses, process, weights, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestParseProcess(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Process
+	}{
+		{"spread:max=5", Spread{5}},
+		{"spread:max=0", Spread{0}},
+		{"poisson:rate=2.5", Poisson{2.5}},
+		{"burst:size=10,every=3", Burst{10, 3 * time.Second}},
+		{"burst: size = 4 , every = 0.5", Burst{4, 500 * time.Millisecond}},
+		{"diurnal:base=0.1,peak=2,period=60", Diurnal{0.1, 2, time.Minute}},
+	}
+	for _, tt := range tests {
+		got, err := ParseProcess(tt.spec)
+		if err != nil {
+			t.Errorf("ParseProcess(%q): %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseProcess(%q) = %#v, want %#v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseProcessErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"uniform:max=3",
+		"spread",
+		"spread:max=-1",
+		"spread:max=1.5",
+		"spread:max=x",
+		"spread:max=3,min=1",
+		"poisson:rate=0",
+		"poisson:rate",
+		"burst:size=0,every=1",
+		"burst:size=3",
+		"diurnal:base=0,peak=0,period=10",
+		"diurnal:base=1,peak=2,period=0",
+		"trace:file=does-not-exist.csv",
+	} {
+		if p, err := ParseProcess(spec); err == nil {
+			t.Errorf("ParseProcess(%q) = %#v, want an error", spec, p)
+		}
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	got, err := ParseMix("mix=20, A=40,b=40", testClasses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{40, 40, 20}; !slices.Equal(got, want) {
+		t.Errorf("weights = %v, want %v", got, want)
+	}
+	got, err = ParseMix("B=1", testClasses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{0, 1, 0}; !slices.Equal(got, want) {
+		t.Errorf("weights = %v, want %v (missing classes get 0)", got, want)
+	}
+
+	for _, spec := range []string{"C=1", "A", "A=-1", "A=x", "A=0,B=0"} {
+		if w, err := ParseMix(spec, testClasses); err == nil {
+			t.Errorf("ParseMix(%q) = %v, want an error", spec, w)
+		}
+	}
+}
+
+func TestReadTrace(t *testing.T) {
+	csv := `time,class
+# arrivals of the morning
+3.5,MIX
+
+0, A
+1.25
+`
+	tr, err := ReadTrace(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAt := []time.Duration{0, 1250 * time.Millisecond, 3500 * time.Millisecond}
+	wantClass := []string{"A", "", "MIX"}
+	if !slices.Equal(tr.At, wantAt) || !slices.Equal(tr.Class, wantClass) {
+		t.Errorf("trace = %v %q, want %v %q", tr.At, tr.Class, wantAt, wantClass)
+	}
+
+	for _, bad := range []string{"1\nx\n", "-1\n"} {
+		if _, err := ReadTrace(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadTrace(%q): want an error", bad)
+		}
+	}
+}
+
+func TestTraceClasses(t *testing.T) {
+	tr := &Trace{
+		At:    []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second},
+		Class: []string{"mix", "", "B", ""},
+	}
+	w := newTestWorkload(t, tr, "A=1")
+	plan := w.Plan(4)
+	for i, want := range []int{2, 0, 1, 0} {
+		if plan[i].At != tr.At[i] || plan[i].Class != want {
+			t.Errorf("arrival %d = %+v, want {At:%v Class:%d}", i, plan[i], tr.At[i], want)
+		}
+	}
+
+	tr.Class[3] = "C"
+	if _, err := NewWorkload(testClasses, tr, []float64{1, 0, 0}, 1); err == nil {
+		t.Errorf("NewWorkload with an unknown class in the trace: want an error")
+	}
+}
+
+func TestSpread(t *testing.T) {
+	times := Spread{3}.Times(1000, rand.New(rand.NewSource(1)))
+	seen := map[time.Duration]bool{}
+	for _, at := range times {
+		seen[at] = true
+	}
+	for s := 1; s <= 3; s++ {
+		if !seen[time.Duration(s)*time.Second] {
+			t.Errorf("no arrival at %ds", s)
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("arrivals at %v, want only 1s, 2s and 3s", seen)
+	}
+
+	for _, at := range (Spread{0}).Times(10, rand.New(rand.NewSource(1))) {
+		if at != 0 {
+			t.Fatalf("spread:max=0: arrival at %v, want 0", at)
+		}
+	}
+}
+
+func TestPoissonRate(t *testing.T) {
+	const n, rate = 20000, 4.0
+	times := Poisson{rate}.Times(n, rand.New(rand.NewSource(1)))
+	if !slices.IsSorted(times) {
+		t.Fatal("arrival times are not increasing")
+	}
+	got := n / times[n-1].Seconds()
+	if math.Abs(got-rate)/rate > 0.05 {
+		t.Errorf("measured rate %.2f/s, want about %.2f/s", got, rate)
+	}
+}
+
+func TestBurst(t *testing.T) {
+	times := Burst{3, 2 * time.Second}.Times(7, nil)
+	want := []time.Duration{0, 0, 0, 2 * time.Second, 2 * time.Second, 2 * time.Second, 4 * time.Second}
+	if !slices.Equal(times, want) {
+		t.Errorf("times = %v, want %v", times, want)
+	}
+}
+
+func TestDiurnalPeak(t *testing.T) {
+	// Over many days, the quarter of the day around noon must receive many more
+	// arrivals than the quarter around midnight (expected ratio about 3.7).
+	d := Diurnal{Base: 0.5, Peak: 5, Period: 100 * time.Second}
+	times := d.Times(20000, rand.New(rand.NewSource(1)))
+	night, noon := 0, 0
+	for _, at := range times {
+		phase := math.Mod(at.Seconds(), d.Period.Seconds()) / d.Period.Seconds()
+		switch {
+		case phase < 0.125 || phase >= 0.875:
+			night++
+		case phase >= 0.375 && phase < 0.625:
+			noon++
+		}
+	}
+	if noon < 3*night {
+		t.Errorf("%d arrivals around noon, %d around midnight: want at least 3 times as many", noon, night)
+	}
+}
+
+func TestParseService(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Service
+	}{
+		{"uniform:min=1,max=30", Uniform{1, 30}},
+		{"uniform:min=0,max=0", Uniform{0, 0}},
+		{"exp:mean=2.5", Exponential{2500 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		if got, err := ParseService(tt.spec); err != nil || got != tt.want {
+			t.Errorf("ParseService(%q) = %#v, %v, want %#v", tt.spec, got, err, tt.want)
+		}
+	}
+	for _, spec := range []string{"", "poisson:rate=1", "uniform:max=3", "uniform:min=3,max=1",
+		"uniform:min=0.5,max=1", "exp:mean=0", "exp:mean=1,max=2"} {
+		if s, err := ParseService(spec); err == nil {
+			t.Errorf("ParseService(%q) = %#v, want an error", spec, s)
+		}
+	}
+}
+
+func TestServiceDraws(t *testing.T) {
+	rand.Seed(1)
+	seen := map[time.Duration]bool{}
+	for range 1000 {
+		seen[Uniform{1, 3}.Draw()] = true
+	}
+	if len(seen) != 3 || !seen[time.Second] || !seen[3*time.Second] {
+		t.Errorf("uniform:min=1,max=3 drew %v, want only 1s, 2s and 3s", seen)
+	}
+
+	const n = 20000
+	var sum time.Duration
+	for range n {
+		sum += Exponential{2 * time.Second}.Draw()
+	}
+	if mean := sum.Seconds() / n; math.Abs(mean-2)/2 > 0.05 {
+		t.Errorf("exp:mean=2: measured mean %.2fs", mean)
+	}
+}
+
+func TestPlanFollowsMixExactly(t *testing.T) {
+	w := newTestWorkload(t, Poisson{10}, "MIX=20,A=40,B=40")
+	for _, n := range []int{0, 1, 7, 10, 101} {
+		plan := w.Plan(n)
+		if len(plan) != n {
+			t.Fatalf("Plan(%d) returned %d arrivals", n, len(plan))
+		}
+		counts := make([]int, len(testClasses))
+		for i, a := range plan {
+			counts[a.Class]++
+			if i > 0 && a.At < plan[i-1].At {
+				t.Errorf("n=%d: arrivals not sorted: %v after %v", n, a.At, plan[i-1].At)
+			}
+		}
+		for c, k := range counts {
+			exact := float64(n) * []float64{0.4, 0.4, 0.2}[c]
+			if math.Abs(float64(k)-exact) >= 1 {
+				t.Errorf("n=%d: %d clients of class %s, want %.1f rounded", n, k, testClasses[c], exact)
+			}
+		}
+	}
+}
+
+func TestPlanIsReproducible(t *testing.T) {
+	a := newTestWorkload(t, Poisson{1}, "A=1,B=1,MIX=1").Plan(50)
+	b := newTestWorkload(t, Poisson{1}, "A=1,B=1,MIX=1").Plan(50)
+	if !slices.Equal(a, b) {
+		t.Errorf("two workloads with the same seed produced different plans")
+	}
+}
+
+// runTrace creates the workload of -seed seed and returns what a run draws from
+// it and from math/rand: the plan, the classes, the service times and the
+// draws of the scenario.
+func runTrace(t *testing.T, seed int64) []string {
+	t.Helper()
+	defer func(old int64) { *seedFlag = old }(*seedFlag)
+	*seedFlag = seed
+	w := WorkloadFromFlags(testClasses, "poisson:rate=2", "A=1,B=1,MIX=1")
+	var trace []string
+	for _, a := range w.Plan(10) {
+		trace = append(trace, fmt.Sprint("arrival ", a.At, " ", a.Class))
+	}
+	for range 10 {
+		trace = append(trace, fmt.Sprint("class ", w.Class()),
+			fmt.Sprint("uniform ", Uniform{1, 30}.Draw()),
+			fmt.Sprint("exp ", Exponential{time.Second}.Draw()),
+			fmt.Sprint("intn ", rand.Intn(1000)))
+	}
+	return trace
+}
+
+func TestSeedIsReproducible(t *testing.T) {
+	a, b := runTrace(t, 42), runTrace(t, 42)
+	if !slices.Equal(a, b) {
+		t.Errorf("two runs with -seed 42 drew:\n%q\n%q", a, b)
+	}
+	if c := runTrace(t, 43); slices.Equal(a, c) {
+		t.Errorf("-seed 42 and -seed 43 drew the same: %q", a)
+	}
+}
+
+func TestTraceTooShort(t *testing.T) {
+	defer func(old func(error)) { flagError = old }(flagError)
+	flagError = func(err error) { panic(err) }
+	defer func() {
+		err, _ := recover().(error)
+		if err == nil || !strings.Contains(err.Error(), "the trace has 2 arrivals, 3 are needed") {
+			t.Errorf("Plan(3) with 2 arrivals in the trace: %v", err)
+		}
+	}()
+	tr := &Trace{At: []time.Duration{0, time.Second}, Class: []string{"", ""}}
+	newTestWorkload(t, tr, "A=1").Plan(3)
+	t.Error("Plan(3) with 2 arrivals in the trace did not fail")
+}
+
+func TestClassDraws(t *testing.T) {
+	w := newTestWorkload(t, Spread{0}, "MIX=20,A=40,B=40")
+	const n = 30000
+	counts := make([]int, len(testClasses))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for g := 0; g < 3; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n/3; i++ {
+				c := w.Class()
+				mu.Lock()
+				counts[c]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	for c, want := range []float64{0.4, 0.4, 0.2} {
+		if got := float64(counts[c]) / n; math.Abs(got-want) > 0.02 {
+			t.Errorf("class %s drawn %.3f of the times, want about %.2f", testClasses[c], got, want)
+		}
+	}
+
+	only := newTestWorkload(t, Spread{0}, "B=1")
+	for i := 0; i < 100; i++ {
+		if c := only.Class(); c != 1 {
+			t.Fatalf("mix B=1 drew class %d", c)
+		}
+	}
+}
+
+func TestSpawn(t *testing.T) {
+	w := newTestWorkload(t, Burst{2, 20 * time.Millisecond}, "A=1,B=1")
+	const n = 6
+	type start struct {
+		id, class int
+		at        time.Duration
+	}
+	started := make(chan start, n)
+	begin := time.Now()
+	w.Spawn(n, func(id, class int) {
+		started <- start{id, class, time.Since(begin)}
+	})
+
+	seen := make([]bool, n)
+	counts := make([]int, len(testClasses))
+	for i := 0; i < n; i++ {
+		s := <-started
+		if seen[s.id] {
+			t.Errorf("client %d started twice", s.id)
+		}
+		seen[s.id] = true
+		counts[s.class]++
+		if want := time.Duration(s.id/2) * 20 * time.Millisecond; s.at < want {
+			t.Errorf("client %d started at %v, before its arrival time %v", s.id, s.at, want)
+		}
+	}
+	if counts[0] != n/2 || counts[1] != n/2 {
+		t.Errorf("classes %v, want %d A and %d B", counts, n/2, n/2)
+	}
+}
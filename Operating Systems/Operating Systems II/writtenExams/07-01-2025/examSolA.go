@@ -1,8 +1,12 @@
+// Run with:
+//     go run examSolA.go workload.go fairness.go metrics.go leaks.go admin.go
+
 package main
 
 import (
     "fmt"
     "math/rand"
+    "os"
     "time"
 )
 
@@ -22,16 +26,39 @@ type Request struct {
 // Names for the two areas, used only for printing/logging
 var Area [2]string = [2]string{"FUN", "PHYSIO"}
 
+// Arrival times of the users and choice of the area (see workload.go)
+var workload *Workload
+
+// Waits of the users to enter an area and busy physiotherapists, for the
+// metrics of the run (see metrics.go)
+var fairness *Fairness
+var physiotherapists *Usage
+
 // CHANNELS:
 var userEntry [2]chan Request     // userEntry[FUN] and userEntry[PHYSIO] for entering the respective areas
-var lifeguardEntry = make(chan Request, MAXBUFF) // Lifeguards entering FUN area
+var lifeguardEntry chan Request   // Lifeguards entering FUN area
 var userExit [2]chan Request      // userExit[FUN] and userExit[PHYSIO] for exiting the respective areas
-var lifeguardExit = make(chan Request, MAXBUFF)  // Lifeguards exiting FUN area
+var lifeguardExit chan Request    // Lifeguards exiting FUN area
 
 // CHANNELS for termination:
-var done = make(chan bool)           // Signals that a goroutine (User or Lifeguard) has finished
-var terminate = make(chan bool)      // Signals to the server that the entire center should terminate
-var closeCenter = make(chan bool)    // Signals that all users are done, and we can proceed to close the center
+var done chan bool           // Signals that a goroutine (User or Lifeguard) has finished
+var terminate chan bool      // Signals to the server that the entire center should terminate
+var closeCenter chan bool    // Signals that all users are done, and we can proceed to close the center
+
+// initChannels creates all the channels above.
+// 'size' is the buffer of the request channels (MAXBUFF in main).
+func initChannels(size int) {
+    for i := 0; i < 2; i++ {
+        userEntry[i] = make(chan Request, size)
+        userExit[i] = make(chan Request, size)
+    }
+    lifeguardEntry = make(chan Request, size)
+    lifeguardExit = make(chan Request, size)
+
+    done = make(chan bool)
+    terminate = make(chan bool)
+    closeCenter = make(chan bool)
+}
 
 // when is a helper function often used in Go concurrency examples to conditionally enable or disable a case in a select statement.
 // If 'b' is false, the returned channel is nil. Reading from a nil channel blocks forever, effectively disabling that case.
@@ -57,12 +84,14 @@ func User(id int) {
     for i := 0; i < rounds; i++ {
         fmt.Printf("[User %d] Round #%d\n", id, i+1)
 
-        areaType := rand.Intn(2) // Randomly choose which area (FUN or PHYSIO) the user wants to enter
+        areaType := workload.Class() // Choose which area (FUN or PHYSIO) the user wants to enter, following the workload mix
         r := Request{id, make(chan int)}
 
         fmt.Printf("[User %d] Wants to enter area %s\n", id, Area[areaType])
+        ticket := fairness.Request(id, areaType)
         userEntry[areaType] <- r     // Send request to enter
         <-r.ack                      // Wait for acknowledgment from the server
+        fairness.Served(ticket)
 
         // User spends some time in the chosen area
         sleepRandTime(7)
@@ -127,6 +156,13 @@ func server() {
     nLifeguards := 0         // Number of lifeguards currently in the FUN area
     isClosing := false       // Whether the center is in the closing phase
 
+    // Admin commands (see admin.go): while paused no user gets in (the
+    // lifeguards come and go); the capacities can be lowered below the users
+    // inside, who stay, and nobody gets in until they drop below the new limit
+    paused := false
+    maxInside := MAX
+    maxPhysio := NT
+
     fmt.Printf("[SERVER] Starting\n")
 
     for {
@@ -145,8 +181,8 @@ func server() {
             r.ack <- 1
 
         // 2) User entering FUN area
-        //    Conditions: total inside < MAX, at least 1 lifeguard present, and no lifeguards waiting in queue
-        case r := <-when((nFUN+nPHYSIO < MAX) && (nLifeguards > 0) && (len(lifeguardEntry) == 0), userEntry[FUN]):
+        //    Conditions: not paused, total inside < MAX, at least 1 lifeguard present, and no lifeguards waiting in queue
+        case r := <-when(!paused && (nFUN+nPHYSIO < maxInside) && (nLifeguards > 0) && (len(lifeguardEntry) == 0), userEntry[FUN]):
             nFUN++
             r.ack <- 1
 
@@ -156,11 +192,12 @@ func server() {
             r.ack <- -1
 
         // 4) User entering PHYSIO area
-        //    Conditions: total inside < MAX, there is at least one free physiotherapist,
+        //    Conditions: not paused, total inside < MAX, there is at least one free physiotherapist,
         //    and no FUN users waiting in queue (trying to avoid blocking)
-        case r := <-when((nFUN+nPHYSIO < MAX) && (freePhysiotherapists > 0) && (len(userEntry[FUN]) == 0), userEntry[PHYSIO]):
+        case r := <-when(!paused && (nFUN+nPHYSIO < maxInside) && (freePhysiotherapists > 0) && (len(userEntry[FUN]) == 0), userEntry[PHYSIO]):
             nPHYSIO++
             freePhysiotherapists--
+            physiotherapists.Add(1)
             r.ack <- 1
 
         // 5) Lifeguard exiting FUN area
@@ -178,6 +215,7 @@ func server() {
         case r := <-userExit[PHYSIO]:
             nPHYSIO--
             freePhysiotherapists++
+            physiotherapists.Add(-1)
             r.ack <- 1
 
         // 8) Signal that all users have completely finished (close the center).
@@ -186,7 +224,42 @@ func server() {
             isClosing = true
             fmt.Printf("The Center is about to close...\n")
 
-        // 9) Main termination signal: the center is officially closed.
+        // 9) An admin command
+        case cmd := <-adminCommands:
+            switch cmd.Op {
+            case ADMIN_PAUSE, ADMIN_RESUME:
+                paused = cmd.Op == ADMIN_PAUSE
+                fmt.Printf("[SERVER] Admin: %s.\n", cmd.Op)
+                cmd.Reply("paused: %v", paused)
+            case ADMIN_SET:
+                switch cmd.Name {
+                case "MAX":
+                    if n, err := cmd.Capacity(MAX); err == nil {
+                        maxInside = n
+                        fmt.Printf("[SERVER] Admin: at most %d users (%d inside).\n", maxInside, nFUN+nPHYSIO)
+                        cmd.Reply("MAX: %d, users: %d", maxInside, nFUN+nPHYSIO)
+                    }
+                case "NT":
+                    if n, err := cmd.Capacity(NT); err == nil {
+                        // Below the busy ones, freePhysiotherapists goes negative
+                        freePhysiotherapists += n - maxPhysio
+                        maxPhysio = n
+                        fmt.Printf("[SERVER] Admin: %d physiotherapists (%d busy).\n", maxPhysio, nPHYSIO)
+                        cmd.Reply("NT: %d, busy: %d", maxPhysio, nPHYSIO)
+                    }
+                default:
+                    cmd.Fail("no capacity %s (MAX, NT)", cmd.Name)
+                }
+            case ADMIN_OUT, ADMIN_IN:
+                // The physiotherapists are only counted
+                cmd.Fail("nothing to take out of service in the center: change the physiotherapists with set NT <n>")
+            default: // ADMIN_DUMP
+                cmd.Reply("paused: %v, users: %d/%d, FUN: %d, PHYSIO: %d, physiotherapists: %d/%d busy, lifeguards: %d, waiting: FUN %d, PHYSIO %d",
+                    paused, nFUN+nPHYSIO, maxInside, nFUN, nPHYSIO, nPHYSIO, maxPhysio, nLifeguards,
+                    len(userEntry[FUN]), len(userEntry[PHYSIO]))
+            }
+
+        // 10) Main termination signal: the center is officially closed.
         //    When we receive this, we end the server goroutine.
         case <-terminate:
             fmt.Println("The Center is now closed!")
@@ -200,19 +273,23 @@ func main() {
     fmt.Printf("[MAIN] Starting\n\n")
     rand.Seed(time.Now().UnixNano())
 
-    // Channel initialization for 2 areas: FUN and PHYSIO
-    for i := 0; i < 2; i++ {
-        userEntry[i] = make(chan Request, MAXBUFF)
-        userExit[i] = make(chan Request, MAXBUFF)
-    }
+    // Channel initialization (2 areas: FUN and PHYSIO)
+    initChannels(MAXBUFF)
+
+    // Workload: by default all the users start at once and choose FUN or PHYSIO
+    // with the same probability (-arrivals and -mix flags, see workload.go)
+    workload = WorkloadFromFlags(Area[:], "spread:max=0", "FUN=1,PHYSIO=1")
+    fairness = FairnessFromFlags(Area[:])
+    physiotherapists = NewUsage("physiotherapists", NT)
+
+    // Admin commands for the center, with -admin (see admin.go)
+    stopAdmin := StartAdminFromFlags()
 
     // Launch server goroutine
     go server()
 
-    // Launch user goroutines
-    for i := 0; i < MAXPROC; i++ {
-        go User(i)
-    }
+    // Launch user goroutines at their arrival times
+    workload.Spawn(MAXPROC, func(id, _ int) { User(id) })
 
     // Launch lifeguard goroutines
     for i := 0; i < MAXPROC/2; i++ {
@@ -237,6 +314,13 @@ func main() {
     // Finally, tell the server to shut down the center
     terminate <- true
     <-done
+    stopAdmin()
 
     fmt.Printf("\n[MAIN] End\n")
+    WriteMetrics(fairness, physiotherapists)
+
+    if err := CheckLeaks(); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
 }
@@ -0,0 +1,280 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR server() (AQUATIC CENTER, SOLUTION A)
+//
+// The tests feed server() scripted sequences of entries and exits of users and
+// lifeguards under a virtual clock, and check who is admitted, in which order,
+// which lifeguards are refused, and that the center is empty again at the end.
+// TestAdmin sends the server the commands of admin.go.
+//
+// A synthetic lifeguard enters the FUN area at the start and stays there for the
+// whole benchmark. Synthetic users then repeat the cycle of User() without any
+// sleep: even ids use the FUN area, odd ids the PHYSIO area (enter, wait for
+// the ack, exit). At the end the lifeguard leaves and the center is closed.
+//
+// The benchmark reports:
+//   - grants/s:        users admitted in an area per second;
+//   - p50-ns, p99-ns:  latency from the userEntry request to its ack;
+//   - allocs/op:       allocations per grant.
+// It runs twice: with unbuffered request channels and with buffered ones
+// (MAXBUFF slots).
+//
+// Run with:
+//     go test -race examSolA.go workload.go fairness.go metrics.go leaks.go admin.go servertest_test.go examSolA_test.go
+//     go test -run XXX -bench . examSolA.go workload.go fairness.go metrics.go leaks.go admin.go servertest_test.go examSolA_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = 2 * MAX // Synthetic users: more than MAX, so some of them wait
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+const (
+	opFUN      = iota // userEntry[FUN] <- id
+	opPHYSIO          // userEntry[PHYSIO] <- id
+	opExit            // userExit[area of id] <- id
+	opGuardIn         // lifeguardEntry <- id
+	opGuardOut        // lifeguardExit <- id
+	opClose           // closeCenter <- true
+)
+
+var opNames = []string{"FUN entry", "PHYSIO entry", "user exit", "lifeguard entry", "lifeguard exit", "close"}
+
+// center runs the steps of a test, remembering the area of every user inside.
+type center struct {
+	*script[int]
+	area map[int]int
+}
+
+func (c *center) do(st step) {
+	r := Request{st.id, make(chan int)}
+	switch st.op {
+	case opFUN, opPHYSIO:
+		c.area[st.id] = st.op
+		c.call(r.id, r.ack, func() { userEntry[st.op] <- r })
+	case opExit:
+		area := c.area[st.id]
+		c.call(r.id, r.ack, func() { userExit[area] <- r })
+	case opGuardIn:
+		c.call(r.id, r.ack, func() { lifeguardEntry <- r })
+	case opGuardOut:
+		c.call(r.id, r.ack, func() { lifeguardExit <- r })
+	case opClose:
+		go func() { closeCenter <- true }()
+	}
+}
+
+func TestServer(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name    string
+		steps   []step
+		refused []int // clients answered -1
+		closing bool  // the center has been closed
+	}{
+		{"FUN users need a lifeguard", []step{
+			{opFUN, 0, nil},
+			{opGuardIn, 10, []int{10, 0}},
+			{opExit, 0, []int{0}},
+			{opGuardOut, 10, []int{10}},
+		}, nil, false},
+		{"PHYSIO users wait while a FUN user is queued", []step{
+			{opFUN, 0, nil},
+			// The guards are evaluated when the server enters select:
+			// the queued FUN user counts from the next event on.
+			{opPHYSIO, 1, []int{1}},
+			{opPHYSIO, 2, nil},
+			{opGuardIn, 10, []int{10, 0, 2}},
+			{opExit, 0, []int{0}},
+			{opExit, 1, []int{1}},
+			{opExit, 2, []int{2}},
+			{opGuardOut, 10, []int{10}},
+		}, nil, false},
+		{"NT physiotherapists", slices.Concat(
+			each(opPHYSIO, 0, NT-1, true),
+			[]step{
+				{opPHYSIO, NT, nil},
+				{opExit, 0, []int{0, NT}},
+			},
+			each(opExit, 1, NT, true),
+		), nil, false},
+		{"at most MAX people inside", slices.Concat(
+			[]step{{opGuardIn, 10, []int{10}}},
+			each(opFUN, 0, MAX-NT-1, true),
+			each(opPHYSIO, MAX-NT, MAX-1, true),
+			[]step{
+				{opFUN, MAX, nil},
+				{opExit, MAX - 1, []int{MAX - 1, MAX}},
+			},
+			each(opExit, 0, MAX-2, true),
+			[]step{
+				{opExit, MAX, []int{MAX}},
+				{opGuardOut, 10, []int{10}},
+			},
+		), nil, false},
+		{"the last lifeguard leaves only when FUN is empty", []step{
+			{opGuardIn, 10, []int{10}},
+			{opGuardIn, 11, []int{11}},
+			{opFUN, 0, []int{0}},
+			{opGuardOut, 10, []int{10}},
+			{opGuardOut, 11, nil},
+			{opExit, 0, []int{0, 11}},
+		}, nil, false},
+		{"lifeguards are refused once the center is closing", []step{
+			{opGuardIn, 10, []int{10}},
+			{opClose, 0, nil},
+			{opGuardIn, 11, []int{11}},
+			{opGuardOut, 10, []int{10}},
+		}, []int{11}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				initChannels(MAXBUFF)
+				go server()
+				c := &center{newScript[int](t), map[int]int{}}
+				for _, st := range tt.steps {
+					c.do(st)
+					c.expect(st.String(), st.want)
+				}
+				c.finish()
+				for id, v := range c.last {
+					if refused := slices.Contains(tt.refused, id); refused != (v == -1) {
+						t.Errorf("client %d answered %d, refused: %v", id, v, refused)
+					}
+				}
+				c.checkEmpty(tt.closing)
+				terminate <- true
+				<-done
+			})
+		})
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		initChannels(MAXBUFF)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server()
+		c := &center{newScript[int](t), map[int]int{}}
+		play := func(steps ...step) {
+			t.Helper()
+			for _, st := range steps {
+				c.do(st)
+				c.expect(st.String(), st.want)
+			}
+		}
+
+		// One physiotherapist: user 1 waits, until there are NT again
+		c.admin(Admin, "set NT 1", "NT: 1, busy: 0", nil)
+		play(step{opPHYSIO, 0, []int{0}}, step{opPHYSIO, 1, nil})
+		c.admin(Admin, "dump", "paused: false, users: 1/8, FUN: 0, PHYSIO: 1, physiotherapists: 1/1 busy, "+
+			"lifeguards: 0, waiting: FUN 0, PHYSIO 1", nil)
+		c.admin(Admin, "set NT 4", "NT: 4, busy: 1", []int{1})
+
+		// Two people inside at most: user 2 gets in when user 0 leaves
+		play(step{opGuardIn, 10, []int{10}})
+		c.admin(Admin, "set MAX 2", "MAX: 2, users: 2", nil)
+		play(step{opFUN, 2, nil}, step{opExit, 0, []int{0, 2}})
+		c.admin(Admin, "set MAX 8", "MAX: 8, users: 2", nil)
+
+		// Paused: the users wait for resume
+		c.admin(Admin, "pause", "paused: true", nil)
+		play(step{opFUN, 3, nil})
+		c.admin(Admin, "resume", "paused: false", []int{3})
+
+		c.admin(Admin, "set MAX 9", "error: MAX cannot be above 8", nil)
+		c.admin(Admin, "set NP 1", "error: no capacity NP (MAX, NT)", nil)
+		c.admin(Admin, "out physiotherapist 0",
+			"error: nothing to take out of service in the center: change the physiotherapists with set NT <n>", nil)
+		play(
+			step{opExit, 1, []int{1}},
+			step{opExit, 2, []int{2}},
+			step{opExit, 3, []int{3}},
+			step{opGuardOut, 10, []int{10}},
+		)
+		c.finish()
+		c.checkEmpty(false)
+		terminate <- true
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// checkEmpty verifies that nobody is left inside: a lifeguard (unless the
+// center is closing), MAX-NT FUN users and NT PHYSIO users all get in.
+func (c *center) checkEmpty(closing bool) {
+	c.t.Helper()
+	var steps []step
+	if !closing {
+		steps = append(steps, each(opGuardIn, 99, 99, false)...)
+		steps = append(steps, each(opFUN, 50, 50+MAX-NT-1, false)...)
+	}
+	steps = append(steps, each(opPHYSIO, 60, 60+NT-1, false)...)
+	for _, st := range steps {
+		c.do(st)
+	}
+	if got := c.grants(); len(got) != len(steps) {
+		c.t.Errorf("final state: %d of %d clients got in", len(got), len(steps))
+	}
+	c.finish()
+	for _, st := range steps {
+		if st.op != opGuardIn {
+			c.do(step{op: opExit, id: st.id})
+		}
+	}
+	c.grants()
+	if !closing {
+		c.do(step{op: opGuardOut, id: 99})
+		c.grants()
+	}
+	c.finish()
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+func BenchmarkServer(b *testing.B) {
+	silence(b)
+	for _, m := range bufferModes(MAXBUFF) {
+		b.Run(m.name, func(b *testing.B) {
+			initChannels(m.size)
+			go server()
+			guard := Request{0, make(chan int)}
+			lifeguardEntry <- guard
+			<-guard.ack
+
+			measure(b, loadClients, func(id int) time.Duration {
+				area := id % 2
+				r := Request{id, make(chan int)}
+				t := time.Now()
+				userEntry[area] <- r
+				<-r.ack
+				d := time.Since(t)
+				userExit[area] <- r
+				<-r.ack
+				return d
+			})
+
+			lifeguardExit <- guard
+			<-guard.ack
+			terminate <- true
+			<-done
+		})
+	}
+}
@@ -1,8 +1,12 @@
+// Run with:
+//     go run examSolB.go workload.go fairness.go metrics.go leaks.go admin.go
+
 package main
 
 import (
     "fmt"
     "math/rand"
+    "os"
     "strings"
     "time"
 )
@@ -44,21 +48,45 @@ type Trainer struct {
     ackUscita       chan bool
 }
 
+// Arrival times of the users and choice of the area (see workload.go)
+var workload *Workload
+
+// Waits of the users to enter an area and trainers assigned to a user, for the
+// metrics of the run (see metrics.go)
+var fairness *Fairness
+var busyTrainers *Usage
+
 // CHANNELS
 // For users entering each area:
 var IngressoArea [NumAree]chan Request
 
 // Single channel for user exit (they indicate from which area they are exiting via 'tipo')
-var Uscita = make(chan Request, MAXBUFF)
+var Uscita chan Request
 
 // For personal trainers entering (IngressoPT) and exiting (UscitaPT)
-var IngressoPT = make(chan Request, MAXBUFF)
-var UscitaPT = make(chan Request)
+var IngressoPT chan Request
+var UscitaPT chan Request
 
 // CHANNELS for termination
-var done = make(chan bool)           // Signals that a goroutine has finished
-var termina = make(chan bool)        // Signals trainers that it’s time to stop
-var terminaServer = make(chan bool)  // Signals the server that it should terminate
+var done chan bool           // Signals that a goroutine has finished
+var termina chan bool        // Signals trainers that it’s time to stop
+var terminaServer chan bool  // Signals the server that it should terminate
+
+// initChannels creates all the channels above.
+// 'size' is the buffer of the user/trainer request channels (MAXBUFF in main);
+// UscitaPT is always unbuffered.
+func initChannels(size int) {
+    for i := 0; i < len(IngressoArea); i++ {
+        IngressoArea[i] = make(chan Request, size)
+    }
+    Uscita = make(chan Request, size)
+    IngressoPT = make(chan Request, size)
+    UscitaPT = make(chan Request)
+
+    done = make(chan bool)
+    termina = make(chan bool)
+    terminaServer = make(chan bool)
+}
 
 // Helper function that returns the given channel if b is true, or nil if b is false.
 // Used in select statements to conditionally enable/disable a case.
@@ -102,13 +130,15 @@ func utente(id int) {
     cycles := rand.Intn(MAXCICLI) + 1 // up to MAXCICLI times
 
     for i := 0; i < cycles; i++ {
-        // Choose an area at random
-        tipo := rand.Intn(NumAree)
+        // Choose an area following the workload mix
+        tipo := workload.Class()
         r.tipo = tipo
 
         fmt.Printf("[USER %d] requests to enter %s\n", id, strings.ToUpper(getTipo(tipo)))
+        ticket := fairness.Request(id, tipo)
         IngressoArea[tipo] <- r     // ask to enter
         <-r.ack                     // wait for server acknowledgment
+        fairness.Served(ticket)
 
         fmt.Printf("[USER %d] training in %s...\n", id, strings.ToUpper(getTipo(tipo)))
         sleepRandTime(5)
@@ -183,23 +213,30 @@ func palestra() {
     trainerLiberi := 0   // how many trainers are free (not assigned to a user)
     trainerDentro := 0   // how many trainers are currently inside the gym
 
+    // Admin commands (see admin.go): while paused no user gets in; the
+    // capacities can be lowered below the users inside, who stay, and nobody
+    // gets in until the users drop below the new limit
+    paused := false
+    maxUtenti := MAX
+    maxPesi := NP
+
     fmt.Printf("[GYM] Opened!\n")
 
     for {
         select {
         // 1) User entering the WEIGHTS area (AREAPESI)
-        //    Condition: total users < MAX, users in weights area < NP,
+        //    Condition: not paused, total users < MAX, users in weights area < NP,
         //    and nobody is waiting to enter the courses area first
-        case r := <-when(utentiInPalestra < MAX && utentiInAP < NP && len(IngressoArea[AREACORSI]) == 0, IngressoArea[AREAPESI]):
+        case r := <-when(!paused && utentiInPalestra < maxUtenti && utentiInAP < maxPesi && len(IngressoArea[AREACORSI]) == 0, IngressoArea[AREAPESI]):
             utentiInPalestra++
             utentiInAP++
             fmt.Printf("[GYM] User %d entered the weights area.\n", r.id)
             r.ack <- true
 
         // 2) User entering the COURSES area (AREACORSI)
-        //    Condition: total users < MAX, at least 1 free trainer,
+        //    Condition: not paused, total users < MAX, at least 1 free trainer,
         //    and no trainers waiting to enter (IngressoPT) at the moment
-        case r := <-when(utentiInPalestra < MAX && trainerLiberi > 0 && len(IngressoPT) == 0, IngressoArea[AREACORSI]):
+        case r := <-when(!paused && utentiInPalestra < maxUtenti && trainerLiberi > 0 && len(IngressoPT) == 0, IngressoArea[AREACORSI]):
             utentiInPalestra++
             // Search for a free trainer
             found := false
@@ -211,6 +248,7 @@ func palestra() {
                 }
             }
             trainerLiberi--
+            busyTrainers.Add(1)
             fmt.Printf("[GYM] User %d is in the courses area, training with trainer %d.\n", r.id, i)
             r.ack <- true
 
@@ -238,6 +276,7 @@ func palestra() {
                         found = true
                         trainer[i].utenteAssegnato = -1
                         trainerLiberi++
+                        busyTrainers.Add(-1)
                         // If this trainer wanted to exit but was waiting for the user to finish:
                         if trainer[i].vuoleUscire && trainer[i].dentro {
                             fmt.Printf("[GYM] Trainer %d is now allowed to exit the gym...\n", i)
@@ -275,7 +314,46 @@ func palestra() {
                 trainer[req.id].ackUscita = req.ack
             }
 
-        // 6) The server receives a termination signal
+        // 6) An admin command
+        case cmd := <-adminCommands:
+            switch cmd.Op {
+            case ADMIN_PAUSE, ADMIN_RESUME:
+                paused = cmd.Op == ADMIN_PAUSE
+                fmt.Printf("[GYM] Admin: %s.\n", cmd.Op)
+                cmd.Reply("paused: %v", paused)
+            case ADMIN_SET:
+                switch cmd.Name {
+                case "MAX":
+                    if n, err := cmd.Capacity(MAX); err == nil {
+                        maxUtenti = n
+                        fmt.Printf("[GYM] Admin: at most %d users (%d inside).\n", maxUtenti, utentiInPalestra)
+                        cmd.Reply("MAX: %d, users: %d", maxUtenti, utentiInPalestra)
+                    }
+                case "NP":
+                    if n, err := cmd.Capacity(NP); err == nil {
+                        maxPesi = n
+                        fmt.Printf("[GYM] Admin: at most %d users in the weights area (%d inside).\n", maxPesi, utentiInAP)
+                        cmd.Reply("NP: %d, weights area: %d", maxPesi, utentiInAP)
+                    }
+                default:
+                    cmd.Fail("no capacity %s (MAX, NP)", cmd.Name)
+                }
+            case ADMIN_OUT, ADMIN_IN:
+                // The trainers come and go by themselves
+                cmd.Fail("nothing to take out of service in the gym")
+            default: // ADMIN_DUMP
+                liberi := []int{}
+                for i := 0; i < NT; i++ {
+                    if trainer[i].dentro && trainer[i].utenteAssegnato == -1 {
+                        liberi = append(liberi, i)
+                    }
+                }
+                cmd.Reply("paused: %v, users: %d/%d, weights area: %d/%d, courses area: %d, trainers inside: %d, free: %v, waiting: weights %d, courses %d",
+                    paused, utentiInPalestra, maxUtenti, utentiInAP, maxPesi, utentiInPalestra-utentiInAP,
+                    trainerDentro, liberi, len(IngressoArea[AREAPESI]), len(IngressoArea[AREACORSI]))
+            }
+
+        // 7) The server receives a termination signal
         case <-terminaServer:
             fmt.Printf("[GYM] Closing.\n")
             done <- true
@@ -293,10 +371,15 @@ func main() {
     nUtenti = 50
     nTrainer = NT
 
-    // Initialize channels for user entry in both areas
-    for i := 0; i < len(IngressoArea); i++ {
-        IngressoArea[i] = make(chan Request, MAXBUFF)
-    }
+    // Initialize channels (user entry in both areas, exits, trainers)
+    initChannels(MAXBUFF)
+
+    // Waits and busy trainers, for the metrics of the run (see metrics.go)
+    fairness = FairnessFromFlags([]string{"PESI", "CORSI"})
+    busyTrainers = NewUsage("trainers", NT)
+
+    // Admin commands for the gym, with -admin (see admin.go)
+    stopAdmin := StartAdminFromFlags()
 
     // Start the server goroutine (the gym)
     go palestra()
@@ -306,10 +389,11 @@ func main() {
         go trainer(i)
     }
 
-    // Create user goroutines
-    for i := 0; i < nUtenti; i++ {
-        go utente(i)
-    }
+    // Create user goroutines at their arrival times. By default they all start
+    // at once and choose each area with the same probability (-arrivals and -mix
+    // flags, see workload.go)
+    workload = WorkloadFromFlags([]string{"PESI", "CORSI"}, "spread:max=0", "PESI=1,CORSI=1")
+    workload.Spawn(nUtenti, func(id, _ int) { utente(id) })
 
     // Wait for all users to finish
     for i := 0; i < nUtenti; i++ {
@@ -325,6 +409,13 @@ func main() {
     // Finally, tell the server to terminate
     terminaServer <- true
     <-done
+    stopAdmin()
 
     fmt.Printf("\n\n[MAIN] The gym is closed!\n")
+    WriteMetrics(fairness, busyTrainers)
+
+    if err := CheckLeaks(); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
 }
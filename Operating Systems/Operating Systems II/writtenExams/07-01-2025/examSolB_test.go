@@ -0,0 +1,294 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR palestra() (GYM, SOLUTION B)
+//
+// The tests feed palestra() scripted sequences of entries and exits of users
+// and trainers under a virtual clock, and check who is admitted, in which
+// order, when the trainers may leave, and that the gym is empty at the end.
+// TestAdmin also lowers MAX on a full gym and pauses it with admin commands
+// (see admin.go).
+//
+// All NT synthetic trainers enter at the start and stay for the whole benchmark.
+// Synthetic users then repeat the cycle of utente() without any sleep: even ids
+// go to the weights area, odd ids to the courses area (enter, wait for the ack,
+// exit on Uscita). At the end the trainers leave on UscitaPT and the gym closes.
+//
+// The benchmark reports:
+//   - grants/s:        users admitted in an area per second;
+//   - p50-ns, p99-ns:  latency from the IngressoArea request to its ack;
+//   - allocs/op:       allocations per grant.
+// It runs twice: with unbuffered request channels and with buffered ones
+// (MAXBUFF slots).
+//
+// Run with:
+//     go test -race examSolB.go workload.go fairness.go metrics.go leaks.go admin.go servertest_test.go examSolB_test.go
+//     go test -run XXX -bench . examSolB.go workload.go fairness.go metrics.go leaks.go admin.go servertest_test.go examSolB_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = MAX + NT // Synthetic users: more than MAX, so some of them wait
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+// The users have ids from 10 on; the trainers are numbered 0..NT-1.
+
+const (
+	opPESI  = iota // IngressoArea[AREAPESI] <- id
+	opCORSI        // IngressoArea[AREACORSI] <- id
+	opExit         // Uscita <- id, with the area of id
+	opPTIn         // IngressoPT <- id
+	opPTOut        // UscitaPT <- id
+)
+
+var opNames = []string{"weights entry", "courses entry", "user exit", "trainer entry", "trainer exit"}
+
+// gym runs the steps of a test, remembering the area of every user inside.
+type gym struct {
+	*script[bool]
+	area map[int]int
+}
+
+func (g *gym) do(st step) {
+	r := Request{st.id, AREAPESI, make(chan bool)}
+	switch st.op {
+	case opPESI, opCORSI:
+		r.tipo = st.op
+		g.area[r.id] = r.tipo
+		g.call(r.id, r.ack, func() { IngressoArea[r.tipo] <- r })
+	case opExit:
+		r.tipo = g.area[r.id]
+		g.call(r.id, r.ack, func() { Uscita <- r })
+	case opPTIn:
+		g.call(r.id, r.ack, func() { IngressoPT <- r })
+	case opPTOut:
+		g.call(r.id, r.ack, func() { UscitaPT <- r })
+	}
+}
+
+func TestPalestra(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"course users need a free trainer", []step{
+			{opCORSI, 10, nil},
+			{opPTIn, 0, []int{0, 10}},
+			{opCORSI, 11, nil},
+			{opExit, 10, []int{10, 11}},
+			{opExit, 11, []int{11}},
+			{opPTOut, 0, []int{0}},
+		}},
+		{"weights users wait while a course user is queued", []step{
+			{opCORSI, 10, nil},
+			// The guards are evaluated when the server enters select:
+			// the queued course user counts from the next event on.
+			{opPESI, 11, []int{11}},
+			{opPESI, 12, nil},
+			{opPTIn, 0, []int{0, 10, 12}},
+			{opExit, 10, []int{10}},
+			{opExit, 11, []int{11}},
+			{opExit, 12, []int{12}},
+			{opPTOut, 0, []int{0}},
+		}},
+		{"a busy trainer leaves only after its user", []step{
+			{opPTIn, 0, []int{0}},
+			{opCORSI, 10, []int{10}},
+			{opPTOut, 0, nil},
+			{opExit, 10, []int{0, 10}},
+		}},
+		{"a free trainer leaves at once", []step{
+			{opPTIn, 0, []int{0}},
+			{opPTIn, 1, []int{1}},
+			{opCORSI, 10, []int{10}},
+			{opPTOut, 1, []int{1}},
+			{opExit, 10, []int{10}},
+			{opPTOut, 0, []int{0}},
+		}},
+		{"at most NP users in the weights area", slices.Concat(
+			each(opPESI, 10, 10+NP-1, true),
+			[]step{
+				{opPESI, 40, nil},
+				{opExit, 10, []int{10, 40}},
+			},
+			each(opExit, 11, 10+NP-1, true),
+			[]step{{opExit, 40, []int{40}}},
+		)},
+		{"at most MAX users in the gym", slices.Concat(
+			each(opPTIn, 0, NT-1, true),
+			each(opPESI, 10, 10+NP-1, true),
+			each(opCORSI, 30, 30+MAX-NP-1, true),
+			[]step{
+				{opCORSI, 40, nil},
+				{opExit, 10, []int{10, 40}},
+			},
+			each(opExit, 11, 10+NP-1, true),
+			each(opExit, 30, 30+MAX-NP-1, true),
+			[]step{{opExit, 40, []int{40}}},
+			each(opPTOut, 0, NT-1, true),
+		)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				initChannels(MAXBUFF)
+				go palestra()
+				g := &gym{newScript[bool](t), map[int]int{}}
+				for _, st := range tt.steps {
+					g.do(st)
+					g.expect(st.String(), st.want)
+				}
+				g.finish()
+				g.checkEmpty()
+				terminaServer <- true
+				<-done
+			})
+		})
+	}
+}
+
+// checkEmpty verifies that the gym is empty: all the NT trainers get in, and
+// then NP users in the weights area and MAX-NP in the courses area.
+func (g *gym) checkEmpty() {
+	g.t.Helper()
+	phases := [][]step{
+		each(opPTIn, 0, NT-1, false),
+		slices.Concat(each(opPESI, 50, 50+NP-1, false), each(opCORSI, 70, 70+MAX-NP-1, false)),
+	}
+	for _, steps := range phases {
+		for _, st := range steps {
+			g.do(st)
+		}
+		if got := g.grants(); len(got) != len(steps) {
+			g.t.Errorf("final state: %d of %d got in", len(got), len(steps))
+		}
+		g.finish()
+	}
+	for _, st := range phases[1] {
+		g.do(step{op: opExit, id: st.id})
+	}
+	g.grants()
+	for _, st := range phases[0] {
+		g.do(step{op: opPTOut, id: st.id})
+	}
+	g.grants()
+	g.finish()
+}
+
+// run does the steps, checking the clients granted after each of them.
+func (g *gym) run(steps ...[]step) {
+	g.t.Helper()
+	for _, st := range slices.Concat(steps...) {
+		g.do(st)
+		g.expect(st.String(), st.want)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		initChannels(MAXBUFF)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go palestra()
+		g := &gym{newScript[bool](t), map[int]int{}}
+		g.run(
+			each(opPTIn, 0, NT-1, true),
+			each(opPESI, 10, 10+NP-1, true),
+			each(opCORSI, 30, 30+MAX-NP-1, true),
+		)
+
+		// A full gym: the users inside stay, and nobody gets in until they
+		// are fewer than the new MAX
+		g.admin(Admin, "set MAX 15", "MAX: 15, users: 18", nil)
+		g.run([]step{
+			{opCORSI, 40, nil},
+			{opExit, 10, []int{10}},
+			{opExit, 11, []int{11}},
+			{opExit, 12, []int{12}},
+			{opExit, 13, []int{13, 40}},
+		})
+
+		// Paused: the exits go on, the entries wait for resume
+		g.admin(Admin, "pause", "paused: true", nil)
+		g.run([]step{
+			{opExit, 14, []int{14}},
+			{opPESI, 41, nil},
+		})
+		g.admin(Admin, "resume", "paused: false", []int{41})
+
+		g.admin(Admin, "set MAX 19", "error: MAX cannot be above 18", nil)
+		g.admin(Admin, "set NT 3", "error: no capacity NT (MAX, NP)", nil)
+		g.admin(Admin, "out trainer 0", "error: nothing to take out of service in the gym", nil)
+		g.admin(Admin, "dump", "paused: false, users: 15/15, weights area: 11/15, courses area: 4, "+
+			"trainers inside: 5, free: [4], waiting: weights 0, courses 0", nil)
+
+		// Back to MAX, everybody leaves and the gym fills up again
+		g.admin(Admin, "set MAX 18", "MAX: 18, users: 15", nil)
+		g.run(
+			each(opExit, 15, 10+NP-1, true),
+			each(opExit, 30, 30+MAX-NP-1, true),
+			each(opExit, 40, 41, true),
+			each(opPTOut, 0, NT-1, true),
+		)
+		g.finish()
+		g.checkEmpty()
+		terminaServer <- true
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+func BenchmarkPalestra(b *testing.B) {
+	silence(b)
+	for _, m := range bufferModes(MAXBUFF) {
+		b.Run(m.name, func(b *testing.B) {
+			initChannels(m.size)
+			go palestra()
+			trainers := make([]Request, NT)
+			for i := range trainers {
+				trainers[i] = Request{i, AREAPESI, make(chan bool, MAXBUFF)}
+				IngressoPT <- trainers[i]
+				<-trainers[i].ack
+			}
+			users := make([]Request, loadClients)
+			for i := range users {
+				users[i] = Request{i, i % NumAree, make(chan bool, MAXBUFF)}
+			}
+
+			measure(b, loadClients, func(id int) time.Duration {
+				r := users[id]
+				t := time.Now()
+				IngressoArea[r.tipo] <- r
+				<-r.ack
+				d := time.Since(t)
+				Uscita <- r
+				<-r.ack
+				return d
+			})
+
+			for _, r := range trainers {
+				UscitaPT <- r
+				<-r.ack
+			}
+			terminaServer <- true
+			<-done
+		})
+	}
+}
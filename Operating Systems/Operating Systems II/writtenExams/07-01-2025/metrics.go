@@ -0,0 +1 @@
+../../metrics/metrics.go
\ No newline at end of file
@@ -1,8 +1,13 @@
+// Run with:
+//     go run examSol.go workload.go leaks.go gates.go events.go admin.go
+
 package main
 
 import (
 	"fmt"
 	"math/rand"
+	"os"
+	"slices"
 	"time"
 )
 
@@ -46,14 +51,33 @@ var (
 	
 	// Acknowledgment channels
 	ACK_tourist  [NUM_TOURISTS]chan int // Per-tourist ACK channels
-	ACK_snowplow = make(chan int, MAXBUFF) // Snowplow ACK channel
+	ACK_snowplow chan int               // Snowplow ACK channel
 	
 	// Termination channels
-	done               = make(chan bool)          // Unbuffered for sync
-	terminate          = make(chan bool)          // Castle termination
-	terminateSnowplow  = make(chan bool)          // Snowplow termination
+	done               chan bool          // Unbuffered for sync
+	terminate          chan bool          // Castle termination
+	terminateSnowplow  chan bool          // Snowplow termination
 )
 
+// Creates all the channels; size is the buffer of the start/end request
+// channels (MAXBUFF in main)
+func initChannels(size int) {
+	for i := 0; i < 3; i++ {
+		startUphill[i] = make(chan int, size)
+		endUphill[i] = make(chan int, size)
+		startDownhill[i] = make(chan Parking, size)
+		endDownhill[i] = make(chan int, size)
+	}
+	for i := 0; i < NUM_TOURISTS; i++ {
+		ACK_tourist[i] = make(chan int, MAXBUFF)
+	}
+	ACK_snowplow = make(chan int, MAXBUFF)
+	
+	done = make(chan bool)
+	terminate = make(chan bool)
+	terminateSnowplow = make(chan bool)
+}
+
 // Parking struct carries parking spot information
 type Parking struct {
 	index       int // Vehicle ID
@@ -153,17 +177,138 @@ func snowplow() {
 	}
 }
 
+// ========================== STATE ==========================
+// The state of the road changes only through its events (see events.go):
+// castle() appends what happens to the log and folds it into its state, and
+// -replay rebuilds the state at any time of a logged run.
+
+// Events of the road; the actor is a vehicle type (vehicleActors)
+const (
+	UPHILL_STANDARD   = "started uphill to a standard spot" // car
+	UPHILL_MAXI       = "started uphill to a MAXI spot"     // car or camper
+	STARTED_UPHILL    = "started uphill"                    // snowplow
+	ARRIVED           = "arrived at the castle"
+	DOWNHILL_STANDARD = "started downhill from a standard spot" // car
+	DOWNHILL_MAXI     = "started downhill from a MAXI spot"     // car or camper
+	STARTED_DOWNHILL  = "started downhill"                      // snowplow
+	EXITED            = "exited the road"
+	SNOWPLOW_STOPPING = "snowplow stopping" // no more trips of the snowplow
+
+	// Admin commands (see admin.go)
+	PAUSED      = "paused by the admin"  // no tourist starts uphill
+	RESUMED     = "resumed by the admin"
+	SPOT_OPENED = "opened by the admin" // a spot of the type of the actor (spotActors)
+	SPOT_CLOSED = "closed by the admin"
+)
+
+// Actors of the events, by vehicle type
+var vehicleActors = []string{"car", "camper", "snowplow"}
+
+// Actors of the events of the admin on the spots, by spot type
+var spotActors = []string{"MAXI spot", "standard spot"}
+
+// roadState is the state of castle(), folded from its events.
+type roadState struct {
+	OnRoad            [2][3]int // vehicles on the road [UPHILL/DOWNHILL][vehicle type]
+	AtCastle          [2]int    // tourists at the castle [CAR/CAMPER]
+	FreeStandardSpots int
+	FreeMaxiSpots     int
+	Stop              bool // the snowplow may not go downhill any more
+	Trips             int  // tourists back in the valley
+
+	// Changed by the admin commands: the spots open [MAXI/STANDARD] (closed
+	// below the spots taken, the free ones go negative) and the pause
+	Spots  [2]int
+	Paused bool
+}
+
+func newRoadState() roadState {
+	return roadState{FreeStandardSpots: STANDARD_SPOTS, FreeMaxiSpots: MAXI_SPOTS, Spots: [2]int{MAXI_SPOTS, STANDARD_SPOTS}}
+}
+
+// snowplowActive reports whether the snowplow is on the road.
+func (s roadState) snowplowActive() bool {
+	return s.OnRoad[UPHILL][SNOWPLOW]+s.OnRoad[DOWNHILL][SNOWPLOW] > 0
+}
+
+// Apply returns the state after e.
+func (s roadState) Apply(e DomainEvent) roadState {
+	t := slices.Index(vehicleActors, e.Actor)
+	switch e.Kind {
+	case UPHILL_STANDARD, UPHILL_MAXI, STARTED_UPHILL:
+		s.OnRoad[UPHILL][t]++
+	case ARRIVED:
+		s.OnRoad[UPHILL][t]--
+	case DOWNHILL_STANDARD, DOWNHILL_MAXI, STARTED_DOWNHILL:
+		s.OnRoad[DOWNHILL][t]++
+	case EXITED:
+		s.OnRoad[DOWNHILL][t]--
+	case SNOWPLOW_STOPPING:
+		s.Stop = true
+	case PAUSED, RESUMED:
+		s.Paused = e.Kind == PAUSED
+	case SPOT_OPENED, SPOT_CLOSED:
+		d := 1
+		if e.Kind == SPOT_CLOSED {
+			d = -1
+		}
+		s.Spots[slices.Index(spotActors, e.Actor)] += d
+		if e.Actor == spotActors[MAXI] {
+			s.FreeMaxiSpots += d
+		} else {
+			s.FreeStandardSpots += d
+		}
+	}
+	switch e.Kind {
+	case UPHILL_STANDARD:
+		s.FreeStandardSpots--
+	case UPHILL_MAXI:
+		s.FreeMaxiSpots--
+	case DOWNHILL_STANDARD:
+		s.FreeStandardSpots++
+	case DOWNHILL_MAXI:
+		s.FreeMaxiSpots++
+	}
+	if t != SNOWPLOW {
+		switch e.Kind {
+		case ARRIVED:
+			s.AtCastle[t]++
+		case DOWNHILL_STANDARD, DOWNHILL_MAXI:
+			s.AtCastle[t]--
+		case EXITED:
+			s.Trips++
+		}
+	}
+	return s
+}
+
+// The views of the state printed by -replay
+var roadProjections = []Projection[roadState]{
+	{"occupancy", func(s roadState) string {
+		road := func(d int) string {
+			return fmt.Sprintf("%d cars, %d campers", s.OnRoad[d][CAR], s.OnRoad[d][CAMPER])
+		}
+		snowplow := "in the castle"
+		if s.OnRoad[UPHILL][SNOWPLOW] > 0 {
+			snowplow = "uphill"
+		} else if s.OnRoad[DOWNHILL][SNOWPLOW] > 0 {
+			snowplow = "downhill"
+		}
+		return fmt.Sprintf("uphill %s; downhill %s; snowplow %s; at the castle %d cars, %d campers; %d tourists back",
+			road(UPHILL), road(DOWNHILL), snowplow, s.AtCastle[CAR], s.AtCastle[CAMPER], s.Trips)
+	}},
+	{"inventory", func(s roadState) string {
+		return fmt.Sprintf("%d/%d standard spots and %d/%d MAXI spots free",
+			s.FreeStandardSpots, s.Spots[STANDARD], s.FreeMaxiSpots, s.Spots[MAXI])
+	}},
+}
+
 // Castle (central coordinator)
 func castle() {
 	var (
-		index           int
-		p               Parking
-		stop            = false
-		numCampersOnRoad= [2]int{0, 0} // [UPHILL, DOWNHILL]
-		numCarsOnRoad   = [2]int{0, 0}
-		snowplowActive  = false
-		freeStandardSpots = STANDARD_SPOTS
-		freeMaxiSpots     = MAXI_SPOTS
+		index int
+		p     Parking
+		s     = newRoadState() // Vehicles on the road, free spots, stop: see roadState
 	)
 	
 	fmt.Printf("[castle] The road is open!\n")
@@ -172,121 +317,157 @@ func castle() {
 		select {
 		// === UPHILL REQUESTS ===
 		case index = <-when(
-			freeMaxiSpots > 0 && 
-			numCampersOnRoad[DOWNHILL]+numCarsOnRoad[DOWNHILL] == 0 &&
-			!snowplowActive &&
+			!s.Paused &&
+			s.FreeMaxiSpots > 0 && 
+			s.OnRoad[DOWNHILL][CAMPER]+s.OnRoad[DOWNHILL][CAR] == 0 &&
+			!s.snowplowActive() &&
 			len(startDownhill[CAMPER])+len(startDownhill[CAR])+len(startDownhill[SNOWPLOW]) == 0, 
 			startUphill[CAMPER]):
 			// Camper entering uphill
-			freeMaxiSpots--
-			numCampersOnRoad[UPHILL]++
+			s = s.Apply(eventLog.Append("camper", index, UPHILL_MAXI))
 			fmt.Printf("[castle] CAMPER %d entered uphill\n", index)
-			ACK_tourist[index] <- MAXI
+			ackVehicle(ACK_tourist[index], MAXI)
 
 		case index = <-when(
-			(freeStandardSpots+freeMaxiSpots > 0) &&
-			numCampersOnRoad[DOWNHILL] == 0 &&
-			!snowplowActive &&
+			!s.Paused &&
+			(max(s.FreeStandardSpots, 0)+max(s.FreeMaxiSpots, 0) > 0) &&
+			s.OnRoad[DOWNHILL][CAMPER] == 0 &&
+			!s.snowplowActive() &&
 			len(startUphill[CAMPER]) == 0 &&
 			len(startDownhill[CAMPER])+len(startDownhill[CAR])+len(startDownhill[SNOWPLOW]) == 0, 
 			startUphill[CAR]):
 			// Car entering uphill
-			parkingType := STANDARD
-			if freeStandardSpots > 0 {
-				freeStandardSpots--
-			} else {
-				freeMaxiSpots--
-				parkingType = MAXI
+			parkingType, kind := STANDARD, UPHILL_STANDARD
+			if s.FreeStandardSpots <= 0 {
+				parkingType, kind = MAXI, UPHILL_MAXI
 			}
-			numCarsOnRoad[UPHILL]++
+			s = s.Apply(eventLog.Append("car", index, kind))
 			fmt.Printf("[castle] CAR %d entered uphill\n", index)
-			ACK_tourist[index] <- parkingType
+			ackVehicle(ACK_tourist[index], parkingType)
 
 		case <-when(
-			(numCampersOnRoad[DOWNHILL]+numCarsOnRoad[DOWNHILL]+numCampersOnRoad[UPHILL]+numCarsOnRoad[UPHILL] == 0) &&
+			(s.OnRoad[DOWNHILL][CAMPER]+s.OnRoad[DOWNHILL][CAR]+s.OnRoad[UPHILL][CAMPER]+s.OnRoad[UPHILL][CAR] == 0) &&
 			(len(startUphill[CAMPER])+len(startUphill[CAR]) == 0) && 
 			(len(startDownhill[CAMPER])+len(startDownhill[CAR]) == 0), 
 			startUphill[SNOWPLOW]):
 			// Snowplow entering uphill
-			snowplowActive = true
+			s = s.Apply(eventLog.Append("snowplow", -1, STARTED_UPHILL))
 			fmt.Printf("[castle] SNOWPLOW entered uphill\n")
-			ACK_snowplow <- 1
+			ackVehicle(ACK_snowplow, 1)
 
 		// === UPHILL COMPLETIONS ===
 		case index = <-endUphill[CAMPER]:
-			numCampersOnRoad[UPHILL]--
+			s = s.Apply(eventLog.Append("camper", index, ARRIVED))
 			fmt.Printf("[castle] CAMPER %d arrived\n", index)
-			ACK_tourist[index] <- 1
+			ackVehicle(ACK_tourist[index], 1)
 
 		case index = <-endUphill[CAR]:
-			numCarsOnRoad[UPHILL]--
+			s = s.Apply(eventLog.Append("car", index, ARRIVED))
 			fmt.Printf("[castle] CAR %d arrived\n", index)
-			ACK_tourist[index] <- 1
+			ackVehicle(ACK_tourist[index], 1)
 
 		case <-endUphill[SNOWPLOW]:
-			snowplowActive = false
+			s = s.Apply(eventLog.Append("snowplow", -1, ARRIVED))
 			fmt.Printf("[castle] SNOWPLOW arrived\n")
-			ACK_snowplow <- 1
+			ackVehicle(ACK_snowplow, 1)
 
 		// === DOWNHILL REQUESTS ===
 		case p = <-whenParking(
-			(numCampersOnRoad[UPHILL]+numCarsOnRoad[UPHILL] == 0) &&
-			!snowplowActive &&
+			(s.OnRoad[UPHILL][CAMPER]+s.OnRoad[UPHILL][CAR] == 0) &&
+			!s.snowplowActive() &&
 			len(startDownhill[SNOWPLOW]) == 0, 
 			startDownhill[CAMPER]):
 			// Camper leaving
-			numCampersOnRoad[DOWNHILL]++
-			freeMaxiSpots++
+			s = s.Apply(eventLog.Append("camper", p.index, DOWNHILL_MAXI))
 			fmt.Printf("[castle] CAMPER %d exiting\n", p.index)
-			ACK_tourist[p.index] <- 1
+			ackVehicle(ACK_tourist[p.index], 1)
 
 		case p = <-whenParking(
-			(numCampersOnRoad[UPHILL] == 0) &&
-			!snowplowActive &&
+			(s.OnRoad[UPHILL][CAMPER] == 0) &&
+			!s.snowplowActive() &&
 			len(startDownhill[SNOWPLOW])+len(startDownhill[CAMPER]) == 0, 
 			startDownhill[CAR]):
 			// Car leaving
-			numCarsOnRoad[DOWNHILL]++
+			kind := DOWNHILL_STANDARD
 			if p.parkingType == MAXI {
-				freeMaxiSpots++
-			} else {
-				freeStandardSpots++
+				kind = DOWNHILL_MAXI
 			}
+			s = s.Apply(eventLog.Append("car", p.index, kind))
 			fmt.Printf("[castle] CAR %d exiting\n", p.index)
-			ACK_tourist[p.index] <- 1
+			ackVehicle(ACK_tourist[p.index], 1)
 
 		case <-whenParking(
-			!stop &&
-			(numCampersOnRoad[DOWNHILL]+numCarsOnRoad[DOWNHILL]+numCampersOnRoad[UPHILL]+numCarsOnRoad[UPHILL] == 0), 
+			!s.Stop &&
+			(s.OnRoad[DOWNHILL][CAMPER]+s.OnRoad[DOWNHILL][CAR]+s.OnRoad[UPHILL][CAMPER]+s.OnRoad[UPHILL][CAR] == 0), 
 			startDownhill[SNOWPLOW]):
 			// Snowplow exiting
-			snowplowActive = true
+			s = s.Apply(eventLog.Append("snowplow", -1, STARTED_DOWNHILL))
 			fmt.Printf("[castle] SNOWPLOW exiting\n")
-			ACK_snowplow <- 1
+			ackVehicle(ACK_snowplow, 1)
 
 		// === DOWNHILL COMPLETIONS ===
 		case index = <-endDownhill[CAMPER]:
-			numCampersOnRoad[DOWNHILL]--
+			s = s.Apply(eventLog.Append("camper", index, EXITED))
 			fmt.Printf("[castle] CAMPER %d exited\n", index)
-			ACK_tourist[index] <- 1
+			ackVehicle(ACK_tourist[index], 1)
 
 		case index = <-endDownhill[CAR]:
-			numCarsOnRoad[DOWNHILL]--
+			s = s.Apply(eventLog.Append("car", index, EXITED))
 			fmt.Printf("[castle] CAR %d exited\n", index)
-			ACK_tourist[index] <- 1
+			ackVehicle(ACK_tourist[index], 1)
 
 		case <-endDownhill[SNOWPLOW]:
-			snowplowActive = false
+			s = s.Apply(eventLog.Append("snowplow", -1, EXITED))
 			fmt.Printf("[castle] SNOWPLOW exited\n")
-			ACK_snowplow <- 1
+			ackVehicle(ACK_snowplow, 1)
 
 		// === TERMINATION HANDLING ===
 		case <-terminateSnowplow:
-			stop = true
+			s = s.Apply(eventLog.Append("", -1, SNOWPLOW_STOPPING))
 			fmt.Printf("[castle] Stopping snowplow...\n")
 
-		case <-whenParking(stop, startDownhill[SNOWPLOW]):
-			ACK_snowplow <- -1
+		case <-whenParking(s.Stop, startDownhill[SNOWPLOW]):
+			ackVehicle(ACK_snowplow, -1)
+
+		// === ADMIN COMMANDS ===
+		case cmd := <-adminCommands:
+			switch cmd.Op {
+			case ADMIN_PAUSE, ADMIN_RESUME:
+				kind := RESUMED
+				if cmd.Op == ADMIN_PAUSE {
+					kind = PAUSED
+				}
+				s = s.Apply(eventLog.Append("", -1, kind))
+				fmt.Printf("[castle] Admin: %s\n", cmd.Op)
+				cmd.Reply("paused: %v", s.Paused)
+			case ADMIN_SET:
+				t, limit := MAXI, MAXI_SPOTS
+				switch cmd.Name {
+				case "MAXI_SPOTS":
+				case "STANDARD_SPOTS":
+					t, limit = STANDARD, STANDARD_SPOTS
+				default:
+					cmd.Fail("no capacity %s (STANDARD_SPOTS, MAXI_SPOTS)", cmd.Name)
+					continue
+				}
+				n, err := cmd.Capacity(limit)
+				if err != nil {
+					continue
+				}
+				// One event per spot opened or closed
+				for s.Spots[t] < n {
+					s = s.Apply(eventLog.Append(spotActors[t], -1, SPOT_OPENED))
+				}
+				for s.Spots[t] > n {
+					s = s.Apply(eventLog.Append(spotActors[t], -1, SPOT_CLOSED))
+				}
+				fmt.Printf("[castle] Admin: %d %ss\n", n, spotActors[t])
+				cmd.Reply("%s: %s", cmd.Name, roadProjections[1].Of(s))
+			case ADMIN_OUT, ADMIN_IN:
+				cmd.Fail("the spots are only counted: change them with set STANDARD_SPOTS|MAXI_SPOTS <n>")
+			default: // ADMIN_DUMP
+				cmd.Reply("paused: %v; %s; %s", s.Paused, roadProjections[0].Of(s), roadProjections[1].Of(s))
+			}
 
 		case <-terminate:
 			fmt.Printf("[castle] Terminating...\n")
@@ -300,38 +481,57 @@ func castle() {
 func main() {
 	rand.Seed(time.Now().UnixNano())
 	
-	// Channel initialization
-	for i := 0; i < 3; i++ {
-		startUphill[i] = make(chan int, MAXBUFF)
-		endUphill[i] = make(chan int, MAXBUFF)
-		startDownhill[i] = make(chan Parking, MAXBUFF)
-		endDownhill[i] = make(chan int, MAXBUFF)
+	// With -replay only rebuild the state of a logged run (see events.go)
+	if ReplayFromFlags(newRoadState(), roadProjections...) {
+		return
 	}
+	eventLog = EventLogFromFlags() // With -events, log the events of castle()
 	
-	// ACK channels initialization
-	for i := 0; i < NUM_TOURISTS; i++ {
-		ACK_tourist[i] = make(chan int, MAXBUFF)
-	}
+	// Tourists arrive following the workload (-arrivals and -mix flags, see
+	// workload.go): by default all at once, half cars and half campers
+	workload := WorkloadFromFlags([]string{"CAR", "CAMPER"}, "spread:max=0", "CAR=1,CAMPER=1")
+	plan := workload.Plan(NUM_TOURISTS)
 	
-	// Start system components
-	go castle()
-	go snowplow()
-	for i := 0; i < NUM_TOURISTS; i++ {
-		vehicleType := rand.Intn(2) // 0=car, 1=camper
-		go tourist(i, vehicleType)
-	}
+	// The road is run by castle(), by two gatekeepers with -road gates, or by
+	// both one after the other with -road compare (see gates.go)
+	roads := Roads()
 	
-	// Wait for tourists to finish
-	for i := 0; i < NUM_TOURISTS; i++ {
-		<-done
+	// Admin commands for castle(), with -admin (see admin.go): the gates take
+	// none, and with them alone the commands are refused at the end
+	stopAdmin := StartAdminFromFlags()
+	for _, road := range roads {
+		fmt.Printf("[main] The road is run by %s\n", road.name)
+		
+		// Channel initialization (including ACK channels)
+		initChannels(MAXBUFF)
+		
+		// Start system components
+		road.start()
+		go snowplow()
+		SpawnPlan(plan, tourist)
+		
+		// Wait for tourists to finish
+		for i := 0; i < NUM_TOURISTS; i++ {
+			<-done
+		}
+		
+		// Shutdown sequence
+		terminateSnowplow <- true
+		<-done       // Wait for snowplow
+		road.stop() // Signal castle, wait for it
+		road.messages = takeMessages()
 	}
-	
-	// Shutdown sequence
-	terminateSnowplow <- true
-	<-done            // Wait for snowplow
-	terminate <- true // Signal castle
-	<-done            // Wait for castle
+	stopAdmin()
 	fmt.Println("[main] All goroutines terminated")
+	if err := eventLog.Close(); err != nil {
+		fmt.Printf("[main] The event log is incomplete: %v\n", err)
+	}
+	ReportMessages(os.Stdout, roads)
+
+	if err := CheckLeaks(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
 /*
@@ -357,6 +557,9 @@ KEY SYSTEM LOGIC:
 - Tracks available STANDARD/MAXI spots
 - Dynamically assigns parking types to cars based on availability
 - Releases spots when vehicles exit
+- The state is a roadState changed only by events ("camper 4 started uphill to a MAXI spot"),
+  folded by roadState.Apply: -events logs the events of castle() (not of the gates of gates.go),
+  and -replay rebuilds the occupancy of the road and the free spots at any time (see events.go)
 
 5. Snowplow Behavior:
 - Operates in cycles: downhill -> uphill -> repeat
@@ -369,3 +572,4 @@ KEY SYSTEM LOGIC:
 3. Wait for snowplow confirmation
 4. Terminate castle
 5. Confirm full shutdown
+*/
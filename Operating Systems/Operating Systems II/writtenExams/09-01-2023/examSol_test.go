@@ -0,0 +1,569 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR castle() (CASTLE ROAD)
+//
+// The tests feed castle() scripted trips of cars, campers and the snowplow
+// under a virtual clock, and check who is let on the road, in which order, the
+// parking spots assigned, and that the road and the car park are empty at the end.
+// The same tests run on the two gates of gates.go, which must behave like
+// castle(). TestInvariants sends tourists and the snowplow up and down the road
+// at random times, and checks what they see on the road, with both.
+// TestEventLog rebuilds the state of castle() after every event of its log
+// (events.go) and checks the invariants on it. TestAdmin sends castle() the
+// commands of admin.go, which change its state through events too.
+//
+// Synthetic tourists repeat the cycle of tourist() without any sleep: even ids
+// are cars, odd ids campers. Each one asks to go uphill (startUphill, ACK with
+// the parking type), ends the uphill trip, goes downhill and ends the downhill
+// trip. The snowplow is not started. There are more tourists than parking spots
+// (STANDARD_SPOTS + MAXI_SPOTS), so some of them wait at the bottom.
+//
+// The benchmark reports:
+//   - grants/s:        tourists admitted uphill per second;
+//   - p50-ns, p99-ns:  latency from the startUphill request to the parking assignment;
+//   - allocs/op:       allocations per grant.
+// It runs for castle() and for the gates of gates.go, with unbuffered request
+// channels and with buffered ones (MAXBUFF slots).
+//
+// Run with:
+//     go test -race examSol.go workload.go leaks.go gates.go events.go admin.go servertest_test.go examSol_test.go
+//     go test -run XXX -bench . examSol.go workload.go leaks.go gates.go events.go admin.go servertest_test.go examSol_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = NUM_TOURISTS // Synthetic tourists (ids 0..NUM_TOURISTS-1)
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+// The tests use the buffered channels of main (MAXBUFF slots), without which
+// the priorities have no effect, but unbuffered ACK channels, so that every
+// grant can be observed. The snowplow is the client with id plow.
+
+const plow = NUM_TOURISTS
+
+const (
+	opCar      = iota // startUphill[CAR] <- id
+	opCamper          // startUphill[CAMPER] <- id
+	opArrive          // endUphill[kind] <- id
+	opDown            // startDownhill[kind] <- Parking{id, parking type}
+	opLeave           // endDownhill[kind] <- id
+	opStopPlow        // terminateSnowplow <- true
+)
+
+var opNames = []string{"car uphill", "camper uphill", "end uphill", "downhill", "end downhill", "stop snowplow"}
+
+// road runs the steps of a test, remembering the kind of every vehicle
+// and the parking type it got.
+type road struct {
+	*script[int]
+	kind    map[int]int
+	parking map[int]int
+}
+
+func (r *road) do(st step) {
+	id := st.id
+	ack := ACK_snowplow
+	if id != plow {
+		ack = ACK_tourist[id]
+	}
+	kind := r.kind[id]
+	switch st.op {
+	case opCar, opCamper:
+		kind = []int{CAR, CAMPER}[st.op]
+		r.kind[id] = kind
+		r.call(id, ack, func() { startUphill[kind] <- id })
+	case opArrive:
+		r.parking[id] = r.last[id]
+		r.call(id, ack, func() { endUphill[kind] <- id })
+	case opDown:
+		p := Parking{id, r.parking[id]}
+		r.call(id, ack, func() { startDownhill[kind] <- p })
+	case opLeave:
+		r.call(id, ack, func() { endDownhill[kind] <- id })
+	case opStopPlow:
+		go func() { terminateSnowplow <- true }()
+	}
+}
+
+// trips returns the steps taking vehicles from..to of the given kind (opCar or
+// opCamper) up to the castle and back, one phase at a time, with nobody waiting.
+func trips(kind, from, to int) []step {
+	return slices.Concat(
+		each(kind, from, to, true),
+		each(opArrive, from, to, true),
+		each(opDown, from, to, true),
+		each(opLeave, from, to, true),
+	)
+}
+
+func TestCastle(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name    string
+		steps   []step
+		parking map[int]int // parking type of some of the vehicles
+		refused []int       // clients answered -1
+	}{
+		{"two campers waiting, a car must not overtake them uphill", []step{
+			{opCar, 0, []int{0}},
+			{opCar, 4, []int{4}},
+			{opArrive, 0, []int{0}},
+			{opArrive, 4, []int{4}},
+			{opDown, 0, []int{0}},
+			{opDown, 4, []int{4}},
+			{opCamper, 1, nil},
+			{opCamper, 2, nil},
+			// The guards are evaluated when the server enters select:
+			// the queued campers count from the next event on.
+			{opLeave, 4, []int{4}},
+			{opCar, 3, nil},
+			{opLeave, 0, []int{0, 1, 2, 3}},
+			{opArrive, 1, []int{1}},
+			{opArrive, 2, []int{2}},
+			{opArrive, 3, []int{3}},
+			{opDown, 1, []int{1}},
+			{opDown, 2, []int{2}},
+			{opDown, 3, []int{3}},
+			{opLeave, 1, []int{1}},
+			{opLeave, 2, []int{2}},
+			{opLeave, 3, []int{3}},
+		}, map[int]int{1: MAXI, 2: MAXI, 3: STANDARD}, nil},
+		{"a camper goes downhill only when nobody is going uphill", []step{
+			{opCamper, 0, []int{0}},
+			{opCar, 1, []int{1}},
+			{opArrive, 0, []int{0}},
+			{opDown, 0, nil},
+			{opArrive, 1, []int{1, 0}},
+			{opLeave, 0, []int{0}},
+			{opDown, 1, []int{1}},
+			{opLeave, 1, []int{1}},
+		}, nil, nil},
+		{"cars take a MAXI spot when the standard ones are full",
+			trips(opCar, 0, STANDARD_SPOTS),
+			map[int]int{0: STANDARD, STANDARD_SPOTS - 1: STANDARD, STANDARD_SPOTS: MAXI}, nil},
+		{"a camper waits for a free MAXI spot", slices.Concat(
+			each(opCamper, 0, MAXI_SPOTS-1, true),
+			each(opArrive, 0, MAXI_SPOTS-1, true),
+			[]step{
+				{opCamper, MAXI_SPOTS, nil},
+				{opDown, 0, []int{0}},
+				{opLeave, 0, []int{0, MAXI_SPOTS}},
+				{opArrive, MAXI_SPOTS, []int{MAXI_SPOTS}},
+			},
+			each(opDown, 1, MAXI_SPOTS, true),
+			each(opLeave, 1, MAXI_SPOTS, true),
+		), nil, nil},
+		{"the snowplow waits for an empty road", []step{
+			{opCar, 0, []int{0}},
+			{opDown, plow, nil},
+			{opArrive, 0, []int{0, plow}},
+			{opDown, 0, nil},
+			{opLeave, plow, []int{plow, 0}},
+			{opLeave, 0, []int{0}},
+		}, nil, nil},
+		{"the snowplow is refused once stopped", []step{
+			{opStopPlow, 0, nil},
+			{opDown, plow, []int{plow}},
+		}, nil, []int{plow}},
+	}
+	for _, srv := range allRoads() {
+		for _, tt := range tests {
+			t.Run(srv.name+"/"+tt.name, func(t *testing.T) {
+				synctest.Test(t, func(t *testing.T) {
+					initChannels(MAXBUFF)
+					for i := range ACK_tourist {
+						ACK_tourist[i] = make(chan int)
+					}
+					ACK_snowplow = make(chan int)
+					srv.start()
+					r := &road{newScript[int](t), map[int]int{plow: SNOWPLOW}, map[int]int{}}
+					for _, st := range tt.steps {
+						r.do(st)
+						r.expect(st.String(), st.want)
+					}
+					r.finish()
+					for id, want := range tt.parking {
+						if got := r.parking[id]; got != want {
+							t.Errorf("vehicle %d parked in spot type %d, want %d", id, got, want)
+						}
+					}
+					for _, id := range tt.refused {
+						if r.last[id] != -1 {
+							t.Errorf("client %d answered %d, want -1", id, r.last[id])
+						}
+					}
+					r.checkEmpty()
+					srv.stop()
+				})
+			})
+		}
+	}
+}
+
+// checkEmpty verifies that the road is empty and every spot is free: MAXI_SPOTS
+// campers and STANDARD_SPOTS cars all go up to the castle and back.
+func (r *road) checkEmpty() {
+	r.t.Helper()
+	vehicles := slices.Concat(
+		each(opCamper, 0, MAXI_SPOTS-1, false),
+		each(opCar, MAXI_SPOTS, MAXI_SPOTS+STANDARD_SPOTS-1, false),
+	)
+	for _, op := range []int{-1, opArrive, opDown, opLeave} {
+		what := "uphill"
+		for _, st := range vehicles {
+			if op >= 0 {
+				st.op, what = op, opNames[op]
+			}
+			r.do(st)
+		}
+		if got := r.grants(); len(got) != len(vehicles) {
+			r.t.Errorf("final state: %d of %d vehicles served (%s)", len(got), len(vehicles), what)
+		}
+		r.finish()
+	}
+}
+
+// watch is what the vehicles see on the road. A vehicle counts itself on the
+// road from the ack of its start to the request of its end, and in a spot from
+// the ack uphill to the request downhill: within the times castle() counts it,
+// so what they see breaks the invariants only if castle() does.
+type watch struct {
+	t      *testing.T
+	mu     sync.Mutex
+	onRoad [2][3]int // [direction][vehicle type]
+	spots  [2]int    // spots taken [MAXI, STANDARD]
+	broken int
+}
+
+// change applies f and checks the invariants of the road.
+func (w *watch) change(f func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f()
+	up, down := w.onRoad[UPHILL], w.onRoad[DOWNHILL]
+	var broken string
+	switch {
+	case up[CAMPER] > 0 && down[CAMPER]+down[CAR]+down[SNOWPLOW] > 0:
+		broken = "a camper going uphill meets vehicles going downhill"
+	case down[CAMPER] > 0 && up[CAMPER]+up[CAR]+up[SNOWPLOW] > 0:
+		broken = "a camper going downhill meets vehicles going uphill"
+	case up[SNOWPLOW]+down[SNOWPLOW] > 0 && up[CAMPER]+up[CAR]+down[CAMPER]+down[CAR] > 0:
+		broken = "the snowplow shares the road"
+	case w.spots[MAXI] > MAXI_SPOTS || w.spots[STANDARD] > STANDARD_SPOTS:
+		broken = "more vehicles than spots"
+	}
+	if broken != "" && w.broken < 5 {
+		w.broken++
+		w.t.Errorf("%s: road %v, spots %v", broken, w.onRoad, w.spots)
+	}
+}
+
+// trip takes vehicle index of the given type up to the castle and back, as
+// tourist() does.
+func (w *watch) trip(index, vehicleType int) {
+	ack := ACK_tourist[index]
+	startUphill[vehicleType] <- index
+	parkingType := <-ack
+	w.change(func() { w.onRoad[UPHILL][vehicleType]++; w.spots[parkingType]++ })
+	sleepRandTime(3)
+	w.change(func() { w.onRoad[UPHILL][vehicleType]-- })
+	endUphill[vehicleType] <- index
+	<-ack
+	sleepRandTime(4)
+	w.change(func() { w.spots[parkingType]-- })
+	startDownhill[vehicleType] <- Parking{index, parkingType}
+	<-ack
+	w.change(func() { w.onRoad[DOWNHILL][vehicleType]++ })
+	sleepRandTime(2)
+	w.change(func() { w.onRoad[DOWNHILL][vehicleType]-- })
+	endDownhill[vehicleType] <- index
+	<-ack
+}
+
+// snowplow goes down and up, as snowplow() does, until it is refused.
+func (w *watch) snowplow() {
+	for {
+		sleepRandTime(4)
+		startDownhill[SNOWPLOW] <- Parking{-1, -1}
+		if <-ACK_snowplow == -1 {
+			return
+		}
+		w.change(func() { w.onRoad[DOWNHILL][SNOWPLOW]++ })
+		sleepRandTime(2)
+		w.change(func() { w.onRoad[DOWNHILL][SNOWPLOW]-- })
+		endDownhill[SNOWPLOW] <- 1
+		<-ACK_snowplow
+		sleepRandTime(8)
+		startUphill[SNOWPLOW] <- 1
+		<-ACK_snowplow
+		w.change(func() { w.onRoad[UPHILL][SNOWPLOW]++ })
+		sleepRandTime(2)
+		w.change(func() { w.onRoad[UPHILL][SNOWPLOW]-- })
+		endUphill[SNOWPLOW] <- 1
+		<-ACK_snowplow
+	}
+}
+
+func TestInvariants(t *testing.T) {
+	silence(t)
+	const trips = 4 // per tourist
+	for _, srv := range allRoads() {
+		t.Run(srv.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				w := &watch{t: t}
+				initChannels(MAXBUFF)
+				takeMessages()
+				srv.start()
+				var wg sync.WaitGroup
+				for index := range NUM_TOURISTS {
+					wg.Go(func() {
+						for range trips {
+							w.trip(index, index%2)
+						}
+					})
+				}
+				plow := make(chan bool)
+				go func() {
+					w.snowplow()
+					plow <- true
+				}()
+				wg.Wait()
+				terminateSnowplow <- true
+				<-plow
+				srv.stop()
+
+				// Every request of a vehicle is answered, and every REQUEST
+				// of a gate.
+				counts := takeMessages()
+				if want := int64(2 * 4 * trips * NUM_TOURISTS); counts[MSG_VEHICLE] < want {
+					t.Errorf("%d messages of the vehicles, want at least %d", counts[MSG_VEHICLE], want)
+				}
+				if counts[MSG_REQUEST] != counts[MSG_REPLY] {
+					t.Errorf("%d REQUEST, %d REPLY", counts[MSG_REQUEST], counts[MSG_REPLY])
+				}
+				if gates := counts[MSG_REQUEST] + counts[MSG_UPDATE]; (srv.name == "castle") != (gates == 0) {
+					t.Errorf("%d messages between the gates", gates)
+				}
+			})
+		})
+	}
+}
+
+// broken returns the invariant of the road that s breaks, or "".
+func (s roadState) broken() string {
+	up, down := s.OnRoad[UPHILL], s.OnRoad[DOWNHILL]
+	taken := s.Spots[STANDARD] - s.FreeStandardSpots + s.Spots[MAXI] - s.FreeMaxiSpots
+	switch {
+	case up[CAMPER] > 0 && down[CAMPER]+down[CAR]+down[SNOWPLOW] > 0:
+		return "a camper going uphill meets vehicles going downhill"
+	case down[CAMPER] > 0 && up[CAMPER]+up[CAR]+up[SNOWPLOW] > 0:
+		return "a camper going downhill meets vehicles going uphill"
+	case s.snowplowActive() && up[CAMPER]+up[CAR]+down[CAMPER]+down[CAR] > 0:
+		return "the snowplow shares the road"
+	case s.FreeStandardSpots < 0 || s.FreeMaxiSpots < 0:
+		return "more vehicles than spots"
+	case taken != up[CAR]+up[CAMPER]+s.AtCastle[CAR]+s.AtCastle[CAMPER]:
+		return "spots taken by nobody"
+	}
+	return ""
+}
+
+// TestEventLog runs the tourists and the snowplow of TestInvariants on castle()
+// with -events, and rebuilds the state of the road after every event of the
+// log: it must keep the invariants all along, and be empty at the end.
+func TestEventLog(t *testing.T) {
+	silence(t)
+	const trips = 2 // per tourist
+	path := filepath.Join(t.TempDir(), "road.jsonl")
+	synctest.Test(t, func(t *testing.T) {
+		var err error
+		if eventLog, err = OpenEventLog(path); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { eventLog = nil }()
+		w := &watch{t: t}
+		initChannels(MAXBUFF)
+		go castle()
+		var wg sync.WaitGroup
+		for index := range NUM_TOURISTS {
+			wg.Go(func() {
+				for range trips {
+					w.trip(index, index%2)
+				}
+			})
+		}
+		plow := make(chan bool)
+		go func() {
+			w.snowplow()
+			plow <- true
+		}()
+		wg.Wait()
+		terminateSnowplow <- true
+		<-plow
+		terminate <- true
+		<-done
+		takeMessages()
+		if err := eventLog.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	events, err := ReadEvents(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newRoadState()
+	for _, e := range events {
+		s = s.Apply(e)
+		if broken := s.broken(); broken != "" {
+			t.Fatalf("after #%d %s at %v: %s: %+v", e.Seq, e, e.At, broken, s)
+		}
+	}
+	want := newRoadState()
+	want.Stop, want.Trips = true, trips*NUM_TOURISTS
+	if s != want {
+		t.Errorf("state at the end: %+v, want %+v", s, want)
+	}
+}
+
+// TestAdmin runs castle() with -events: the spots and the pause changed by the
+// admin commands are in the state rebuilt from the log, with the road empty.
+func TestAdmin(t *testing.T) {
+	silence(t)
+	path := filepath.Join(t.TempDir(), "road.jsonl")
+	synctest.Test(t, func(t *testing.T) {
+		var err error
+		if eventLog, err = OpenEventLog(path); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { eventLog = nil }()
+		initChannels(MAXBUFF)
+		for i := range ACK_tourist {
+			ACK_tourist[i] = make(chan int)
+		}
+		ACK_snowplow = make(chan int)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go castle()
+		r := &road{newScript[int](t), map[int]int{plow: SNOWPLOW}, map[int]int{}}
+		play := func(steps ...step) {
+			t.Helper()
+			for _, st := range steps {
+				r.do(st)
+				r.expect(st.String(), st.want)
+			}
+		}
+
+		// One MAXI spot: camper 3 waits, until spots are opened again
+		r.admin(Admin, "set MAXI_SPOTS 1", "MAXI_SPOTS: 10/10 standard spots and 1/1 MAXI spots free", nil)
+		play(step{opCamper, 1, []int{1}}, step{opCamper, 3, nil})
+		r.admin(Admin, "dump", "paused: false; uphill 0 cars, 1 campers; downhill 0 cars, 0 campers; snowplow in the castle; "+
+			"at the castle 0 cars, 0 campers; 0 tourists back; 10/10 standard spots and 0/1 MAXI spots free", nil)
+		r.admin(Admin, "set MAXI_SPOTS 0", "MAXI_SPOTS: 10/10 standard spots and -1/0 MAXI spots free", nil)
+		r.admin(Admin, "set MAXI_SPOTS 5", "MAXI_SPOTS: 10/10 standard spots and 4/5 MAXI spots free", []int{3})
+
+		// Paused: the tourists on the road go on, the new ones wait for resume
+		r.admin(Admin, "pause", "paused: true", nil)
+		play(step{opCar, 0, nil}, step{opArrive, 1, []int{1}})
+		r.admin(Admin, "resume", "paused: false", []int{0})
+		r.admin(Admin, "set STANDARD_SPOTS 9", "STANDARD_SPOTS: 8/9 standard spots and 3/5 MAXI spots free", nil)
+
+		r.admin(Admin, "set STANDARD_SPOTS 11", "error: STANDARD_SPOTS cannot be above 10", nil)
+		r.admin(Admin, "set MAX 1", "error: no capacity MAX (STANDARD_SPOTS, MAXI_SPOTS)", nil)
+		r.admin(Admin, "out spot 0", "error: the spots are only counted: change them with set STANDARD_SPOTS|MAXI_SPOTS <n>", nil)
+
+		// Paused again: the tourists at the castle still come back
+		r.admin(Admin, "pause", "paused: true", nil)
+		for _, op := range []int{opArrive, opDown, opLeave} {
+			for _, id := range []int{1, 3, 0} {
+				if op != opArrive || id != 1 {
+					play(step{op, id, []int{id}})
+				}
+			}
+		}
+		r.finish()
+		for id, want := range map[int]int{0: STANDARD, 1: MAXI, 3: MAXI} {
+			if got := r.parking[id]; got != want {
+				t.Errorf("vehicle %d parked in spot type %d, want %d", id, got, want)
+			}
+		}
+		terminate <- true
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+		takeMessages()
+		if err := eventLog.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	events, err := ReadEvents(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newRoadState()
+	for _, e := range events {
+		s = s.Apply(e)
+	}
+	want := newRoadState()
+	want.Spots[STANDARD], want.FreeStandardSpots = 9, 9
+	want.Paused, want.Trips = true, 3
+	if s != want {
+		t.Errorf("state rebuilt from the log: %+v, want %+v", s, want)
+	}
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+func BenchmarkCastle(b *testing.B) {
+	silence(b)
+	for _, srv := range allRoads() {
+		for _, m := range bufferModes(MAXBUFF) {
+			b.Run(srv.name+"/"+m.name, func(b *testing.B) {
+				initChannels(m.size)
+				srv.start()
+				measure(b, loadClients, func(index int) time.Duration {
+					vehicleType := index % 2
+					t := time.Now()
+					startUphill[vehicleType] <- index
+					parkingType := <-ACK_tourist[index]
+					d := time.Since(t)
+					endUphill[vehicleType] <- index
+					<-ACK_tourist[index]
+					startDownhill[vehicleType] <- Parking{index, parkingType}
+					<-ACK_tourist[index]
+					endDownhill[vehicleType] <- index
+					<-ACK_tourist[index]
+					return d
+				})
+				srv.stop()
+			})
+		}
+	}
+}
@@ -0,0 +1,511 @@
+// -----------------------------------------------------------------------------------
+// DISTRIBUTED CASTLE ROAD: TWO GATEKEEPERS WITH RICART-AGRAWALA
+//
+// castle() sees the whole road at once. Here the road is run by two gates, each
+// owning its end of it:
+//   - the valley gate lets the vehicles on the road uphill (startUphill) and
+//     sees them leave it downhill (endDownhill);
+//   - the castle gate lets them on the road downhill (startDownhill), sees them
+//     arrive uphill (endUphill) and stops the snowplow (terminateSnowplow).
+// tourist() and snowplow() do not change: they send to the same channels, and
+// the gate at that end of the road answers them.
+//
+// The counters of castle() (its roadState) are split so that every counter has
+// one writer, the gate that sees the event:
+//     castle()                      valley gate             castle gate
+//     --------                      -----------             -----------
+//     OnRoad[UPHILL][CAR]           Entered[CAR]          - Left[CAR]
+//     OnRoad[DOWNHILL][CAR]       - Left[CAR]             + Entered[CAR]
+//     FreeStandardSpots             STANDARD_SPOTS - Standard + Standard
+//     snowplowActive()              the snowplow on the road, uphill or downhill
+//     len(startUphill[t])           Waiting[t]
+//     len(startDownhill[t])                                 Waiting[t]
+// (campers and the snowplow as the cars, and the MAXI spots as the standard
+// ones). Every message between the gates carries the counters of its sender,
+// and the channel between them keeps the order, so a gate sees the counters of
+// the other one as of its last message.
+//
+// A vehicle that reaches the end of its trip makes the road freer: the gate
+// counts it and answers at once, and the other gate lets vehicles in later than
+// it could until it knows. Letting a vehicle in is a decision on the counters of
+// both gates, and two gates letting in vehicles in opposite directions at the
+// same time would break the invariants: the gates let vehicles in only inside a
+// critical section, with the mutual exclusion of Ricart and Agrawala:
+//   - a gate with a vehicle that can start, as far as it knows, sends a REQUEST
+//     with its Lamport time;
+//   - the other gate answers with a REPLY at once, unless it is requesting too
+//     with an earlier time (then the valley gate first): it answers when its
+//     critical section ends;
+//   - with the REPLY the gate has the current counters of the other one, which
+//     lets nobody in until the next REQUEST: in the critical section it lets in
+//     the vehicles that can start, in the order of the cases of castle().
+// A gate with vehicles waiting must learn when the other gate frees the road:
+// a gate sends its counters in an UPDATE when they changed and the other gate
+// has vehicles waiting, or when its own vehicles start or stop waiting.
+//
+// The gates keep the invariants of castle(), checked by TestInvariants, and its
+// priorities, which see the vehicles waiting at the other end a message later.
+// The messages are counted: the requests of the vehicles and their acks, the
+// same for both, and the messages between the gates. With -road compare the
+// tourists of the same workload take the road of castle() and then the one of
+// the gates, and the messages of the two runs are compared. The gates take no
+// admin commands (see admin.go): only castle() can be paused or have its spots
+// changed.
+//
+// Run with:
+//     go run examSol.go workload.go leaks.go gates.go events.go admin.go -road gates
+//     go run examSol.go workload.go leaks.go gates.go events.go admin.go -road compare
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+var roadFlag = flag.String("road", "castle", "who runs the road: `castle` (castle()), gates (two gatekeepers, see gates.go) or compare (both, one after the other)")
+
+// ============================================================
+//                         MESSAGES
+// ============================================================
+
+// Kinds of messages.
+const (
+	MSG_VEHICLE = iota // a request of a vehicle or its ack
+	MSG_REQUEST        // a gate asks for the critical section
+	MSG_REPLY          // the other gate lets it in
+	MSG_UPDATE         // a gate sends its counters
+	MSG_KINDS
+)
+
+var messageNames = []string{"vehicles", "REQUEST", "REPLY", "UPDATE"}
+
+// Messages of the current run, by kind.
+var messages [MSG_KINDS]atomic.Int64
+
+// ackVehicle answers v to a vehicle, counting its request and the ack.
+func ackVehicle(ack chan int, v int) {
+	messages[MSG_VEHICLE].Add(2)
+	ack <- v
+}
+
+// takeMessages returns the messages counted since the last call.
+func takeMessages() (counts [MSG_KINDS]int64) {
+	for kind := range counts {
+		counts[kind] = messages[kind].Swap(0)
+	}
+	return counts
+}
+
+// ============================================================
+//                          ROADS
+// ============================================================
+
+// roadServer runs the road: castle() or the two gates.
+type roadServer struct {
+	name     string
+	start    func() // starts its goroutines, once the channels are made
+	stop     func() // terminates them, once the vehicles are done
+	messages [MSG_KINDS]int64
+}
+
+// Roads returns the servers chosen with -road, run one after the other.
+func Roads() []*roadServer {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	roads := allRoads()
+	switch *roadFlag {
+	case "castle":
+		return roads[:1]
+	case "gates":
+		return roads[1:]
+	case "compare":
+		return roads
+	}
+	fmt.Fprintf(os.Stderr, "gates: -road %q: want castle, gates or compare\n", *roadFlag)
+	os.Exit(2)
+	return nil
+}
+
+// allRoads returns castle() and the gates.
+func allRoads() []*roadServer {
+	return []*roadServer{
+		{name: "castle", start: func() { go castle() }, stop: func() {
+			terminate <- true
+			<-done
+		}},
+		{name: "gates", start: startGates, stop: stopGates},
+	}
+}
+
+// ReportMessages writes the messages of the runs, and the messages per request
+// of a vehicle.
+func ReportMessages(w io.Writer, roads []*roadServer) {
+	fmt.Fprintf(w, "\nMESSAGES  %-10s", "")
+	for _, r := range roads {
+		fmt.Fprintf(w, " %10s", r.name)
+	}
+	fmt.Fprintln(w)
+	var totals []int64
+	for kind, name := range messageNames {
+		fmt.Fprintf(w, "  %-18s", name)
+		for i, r := range roads {
+			if i == len(totals) {
+				totals = append(totals, 0)
+			}
+			totals[i] += r.messages[kind]
+			fmt.Fprintf(w, " %10d", r.messages[kind])
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "  %-18s", "total")
+	for _, t := range totals {
+		fmt.Fprintf(w, " %10d", t)
+	}
+	fmt.Fprintf(w, "\n  %-18s", "per request")
+	for i, r := range roads {
+		if requests := r.messages[MSG_VEHICLE] / 2; requests > 0 {
+			fmt.Fprintf(w, " %10.2f", float64(totals[i])/float64(requests))
+		} else {
+			fmt.Fprintf(w, " %10s", "-")
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// ============================================================
+//                          GATES
+// ============================================================
+
+// gateCounters are the counters written by a gate, by vehicle type.
+type gateCounters struct {
+	Entered  [3]int // vehicles let on the road at this end
+	Left     [3]int // vehicles that left the road at this end
+	Standard int    // standard spots taken (valley gate) or freed (castle gate)
+	Maxi     int    // MAXI spots taken or freed
+	Waiting  [3]int // vehicles waiting to enter the road at this end
+}
+
+// waiting reports whether vehicles wait at the gate.
+func (c gateCounters) waiting() bool {
+	return c.Waiting[CAR]+c.Waiting[CAMPER]+c.Waiting[SNOWPLOW] > 0
+}
+
+// gateMsg is a message between the gates.
+type gateMsg struct {
+	kind     int // MSG_REQUEST, MSG_REPLY or MSG_UPDATE
+	time     int // Lamport time of the send
+	from     int // UPHILL (valley gate) or DOWNHILL (castle gate)
+	counters gateCounters
+}
+
+// gate is one of the two gatekeepers.
+type gate struct {
+	name   string
+	end    int          // UPHILL: the valley gate; DOWNHILL: the castle gate
+	order  []int        // vehicle types in the order of the cases of castle()
+	mine   gateCounters // written by this gate
+	theirs gateCounters // of the other gate, as of its last message
+	sent   gateCounters // mine, as of the last message to the other gate
+	queue  [3][]Parking // vehicles waiting to enter the road, in order of arrival
+	stop   bool         // castle gate: the snowplow may not go downhill any more
+	inbox  chan gateMsg
+	peer   chan gateMsg // inbox of the other gate
+
+	// Ricart-Agrawala
+	clock     int  // Lamport clock
+	requested int  // time of the REQUEST waiting for its REPLY, 0 if none
+	deferred  bool // the other gate waits for the REPLY to its REQUEST
+}
+
+// startGates starts the two gates, once the channels are made.
+func startGates() {
+	valley := &gate{name: "valley gate", end: UPHILL, order: []int{CAMPER, CAR, SNOWPLOW}}
+	top := &gate{name: "castle gate", end: DOWNHILL, order: []int{SNOWPLOW, CAMPER, CAR}}
+	valley.inbox = make(chan gateMsg, MAXBUFF)
+	top.inbox = make(chan gateMsg, MAXBUFF)
+	valley.peer, top.peer = top.inbox, valley.inbox
+	go valleyGate(valley)
+	go castleGate(top)
+}
+
+// stopGates terminates the two gates, once the vehicles are done.
+func stopGates() {
+	for range 2 {
+		terminate <- true
+		<-done
+	}
+}
+
+// Gate in the valley: vehicles start uphill and end their trip downhill.
+func valleyGate(g *gate) {
+	fmt.Printf("[%s] The road is open!\n", g.name)
+	for {
+		g.next()
+		select {
+		case index := <-startUphill[CAMPER]:
+			g.arrive(CAMPER, Parking{index, -1})
+		case index := <-startUphill[CAR]:
+			g.arrive(CAR, Parking{index, -1})
+		case <-startUphill[SNOWPLOW]:
+			g.arrive(SNOWPLOW, Parking{-1, -1})
+
+		case index := <-endDownhill[CAMPER]:
+			g.leave(CAMPER, index, "exited")
+		case index := <-endDownhill[CAR]:
+			g.leave(CAR, index, "exited")
+		case <-endDownhill[SNOWPLOW]:
+			g.leave(SNOWPLOW, -1, "exited")
+
+		case m := <-g.inbox:
+			g.receive(m)
+
+		case <-terminate:
+			fmt.Printf("[%s] Terminating...\n", g.name)
+			done <- true
+			return
+		}
+	}
+}
+
+// Gate at the castle: vehicles start downhill and end their trip uphill.
+func castleGate(g *gate) {
+	fmt.Printf("[%s] The road is open!\n", g.name)
+	for {
+		g.next()
+		select {
+		case p := <-startDownhill[CAMPER]:
+			g.arrive(CAMPER, p)
+		case p := <-startDownhill[CAR]:
+			g.arrive(CAR, p)
+		case <-startDownhill[SNOWPLOW]:
+			if g.stop {
+				ackVehicle(ACK_snowplow, -1)
+			} else {
+				g.arrive(SNOWPLOW, Parking{-1, -1})
+			}
+
+		case index := <-endUphill[CAMPER]:
+			g.leave(CAMPER, index, "arrived")
+		case index := <-endUphill[CAR]:
+			g.leave(CAR, index, "arrived")
+		case <-endUphill[SNOWPLOW]:
+			g.leave(SNOWPLOW, -1, "arrived")
+
+		case <-terminateSnowplow:
+			g.stop = true
+			fmt.Printf("[%s] Stopping snowplow...\n", g.name)
+			for range g.queue[SNOWPLOW] {
+				ackVehicle(ACK_snowplow, -1)
+			}
+			g.queue[SNOWPLOW] = nil
+			g.mine.Waiting[SNOWPLOW] = 0
+
+		case m := <-g.inbox:
+			g.receive(m)
+
+		case <-terminate:
+			fmt.Printf("[%s] Terminating...\n", g.name)
+			done <- true
+			return
+		}
+	}
+}
+
+var vehicleNames = []string{"CAR", "CAMPER", "SNOWPLOW"}
+
+// ackOf returns the ack channel of vehicle index, -1 for the snowplow.
+func ackOf(index int) chan int {
+	if index < 0 {
+		return ACK_snowplow
+	}
+	return ACK_tourist[index]
+}
+
+// arrive queues a vehicle of type t asking to enter the road.
+func (g *gate) arrive(t int, p Parking) {
+	g.queue[t] = append(g.queue[t], p)
+	g.mine.Waiting[t]++
+}
+
+// leave counts a vehicle of type t at the end of its trip, and answers it.
+func (g *gate) leave(t, index int, what string) {
+	g.mine.Left[t]++
+	if index < 0 {
+		fmt.Printf("[%s] %s %s\n", g.name, vehicleNames[t], what)
+	} else {
+		fmt.Printf("[%s] %s %d %s\n", g.name, vehicleNames[t], index, what)
+	}
+	ackVehicle(ackOf(index), 1)
+}
+
+// next is called before the gate waits for a message: it asks for the critical
+// section if a vehicle can start, and sends the counters of the gate if the
+// other gate needs them.
+func (g *gate) next() {
+	if g.requested == 0 && g.ready() {
+		g.send(MSG_REQUEST)
+		g.requested = g.clock
+	}
+	if g.mine != g.sent && (g.theirs.waiting() || g.mine.waiting() != g.sent.waiting()) {
+		g.send(MSG_UPDATE)
+	}
+}
+
+// send sends a message with the counters of the gate to the other one.
+func (g *gate) send(kind int) {
+	g.clock++
+	g.sent = g.mine
+	messages[kind].Add(1)
+	g.peer <- gateMsg{kind, g.clock, g.end, g.mine}
+}
+
+// receive handles a message of the other gate.
+func (g *gate) receive(m gateMsg) {
+	g.clock = max(g.clock, m.time) + 1
+	g.theirs = m.counters
+	switch m.kind {
+	case MSG_REQUEST:
+		// The earlier REQUEST goes first, the one of the valley gate on a tie.
+		if g.requested != 0 && (g.requested < m.time || g.requested == m.time && g.end < m.from) {
+			g.deferred = true
+		} else {
+			g.send(MSG_REPLY)
+		}
+	case MSG_REPLY:
+		g.critical()
+		g.requested = 0
+		if g.deferred {
+			g.deferred = false
+			g.send(MSG_REPLY)
+		}
+	}
+}
+
+// critical is the critical section: the vehicles that can start enter the road.
+func (g *gate) critical() {
+	for again := true; again; {
+		again = false
+		for _, t := range g.order {
+			if len(g.queue[t]) > 0 && g.canStart(t) {
+				g.start(t)
+				again = true
+			}
+		}
+	}
+}
+
+// ready reports whether a vehicle can start, as far as the gate knows.
+func (g *gate) ready() bool {
+	for _, t := range g.order {
+		if len(g.queue[t]) > 0 && g.canStart(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// roadView is what castle() knows, from the counters of the two gates.
+type roadView struct {
+	onRoad                           [2][3]int // vehicles on the road [direction][type]
+	waiting                          [2][3]int // vehicles waiting to start [direction][type]
+	freeStandardSpots, freeMaxiSpots int
+	snowplowActive                   bool
+}
+
+func (g *gate) view() roadView {
+	valley, top := g.mine, g.theirs
+	if g.end == DOWNHILL {
+		valley, top = top, valley
+	}
+	var v roadView
+	for t := range 3 {
+		v.onRoad[UPHILL][t] = valley.Entered[t] - top.Left[t]
+		v.onRoad[DOWNHILL][t] = top.Entered[t] - valley.Left[t]
+	}
+	v.waiting = [2][3]int{valley.Waiting, top.Waiting}
+	v.freeStandardSpots = STANDARD_SPOTS - valley.Standard + top.Standard
+	v.freeMaxiSpots = MAXI_SPOTS - valley.Maxi + top.Maxi
+	v.snowplowActive = v.onRoad[UPHILL][SNOWPLOW]+v.onRoad[DOWNHILL][SNOWPLOW] > 0
+	return v
+}
+
+// canStart reports whether the first vehicle of type t waiting at the gate can
+// enter the road: the guards of castle(), on the view of the gate.
+func (g *gate) canStart(t int) bool {
+	v := g.view()
+	up, down := v.onRoad[UPHILL], v.onRoad[DOWNHILL]
+	waitingUp, waitingDown := v.waiting[UPHILL], v.waiting[DOWNHILL]
+	if g.end == UPHILL {
+		switch t {
+		case CAMPER:
+			return v.freeMaxiSpots > 0 &&
+				down[CAMPER]+down[CAR] == 0 &&
+				!v.snowplowActive &&
+				waitingDown[CAMPER]+waitingDown[CAR]+waitingDown[SNOWPLOW] == 0
+		case CAR:
+			return v.freeStandardSpots+v.freeMaxiSpots > 0 &&
+				down[CAMPER] == 0 &&
+				!v.snowplowActive &&
+				waitingUp[CAMPER] == 0 &&
+				waitingDown[CAMPER]+waitingDown[CAR]+waitingDown[SNOWPLOW] == 0
+		default:
+			return down[CAMPER]+down[CAR]+up[CAMPER]+up[CAR] == 0 &&
+				waitingUp[CAMPER]+waitingUp[CAR] == 0 &&
+				waitingDown[CAMPER]+waitingDown[CAR] == 0
+		}
+	}
+	switch t {
+	case CAMPER:
+		return up[CAMPER]+up[CAR] == 0 &&
+			!v.snowplowActive &&
+			waitingDown[SNOWPLOW] == 0
+	case CAR:
+		return up[CAMPER] == 0 &&
+			!v.snowplowActive &&
+			waitingDown[SNOWPLOW]+waitingDown[CAMPER] == 0
+	default:
+		return !g.stop && down[CAMPER]+down[CAR]+up[CAMPER]+up[CAR] == 0
+	}
+}
+
+// start lets the first vehicle of type t waiting at the gate on the road, in
+// the critical section.
+func (g *gate) start(t int) {
+	p := g.queue[t][0]
+	g.queue[t] = g.queue[t][1:]
+	g.mine.Waiting[t]--
+	g.mine.Entered[t]++
+	switch {
+	case t == SNOWPLOW:
+		fmt.Printf("[%s] SNOWPLOW entered %s\n", g.name, []string{"uphill", "downhill"}[g.end])
+		ackVehicle(ACK_snowplow, 1)
+
+	case g.end == UPHILL:
+		// A car takes a standard spot if there is one, a camper a MAXI spot
+		parkingType := MAXI
+		if t == CAR && g.view().freeStandardSpots > 0 {
+			parkingType = STANDARD
+			g.mine.Standard++
+		} else {
+			g.mine.Maxi++
+		}
+		fmt.Printf("[%s] %s %d entered uphill\n", g.name, vehicleNames[t], p.index)
+		ackVehicle(ACK_tourist[p.index], parkingType)
+
+	default:
+		// The vehicle frees its spot
+		if t == CAR && p.parkingType == STANDARD {
+			g.mine.Standard++
+		} else {
+			g.mine.Maxi++
+		}
+		fmt.Printf("[%s] %s %d exiting\n", g.name, vehicleNames[t], p.index)
+		ackVehicle(ACK_tourist[p.index], 1)
+	}
+}
@@ -0,0 +1 @@
+../../des/des.go
\ No newline at end of file
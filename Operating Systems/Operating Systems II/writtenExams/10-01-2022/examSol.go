@@ -1,8 +1,12 @@
+// Run with:
+//     go run examSol.go workload.go fairness.go metrics.go leaks.go policy.go queueing.go des.go causal.go admin.go
+
 package main
 
 import (
 	"fmt"
 	"math/rand"
+	"os"
 	"time"
 )
 
@@ -29,6 +33,7 @@ type User struct {
 	userType    int      // Type of user (administrator, private, etc.)
 	serviceType int      // Type of financial service (superbonus, other)
 	reply       chan int // Channel for user replies
+	stamp       Stamp    // Logical time of the send, with -causal (see causal.go)
 }
 
 //General communication channels
@@ -40,9 +45,67 @@ var enterWaitingRoom [USER_TYPES]chan User      //Channels for entering the wait
 var enterOffice      [FINANCE_TYPES]chan User   //Channels for entering offices based on service type
 var exitOffice chan int                         //Channel for exiting the office
 
-//Utility function: simulate random sleep
-func sleepRandom() {
-	time.Sleep(time.Duration(1e9 * ((rand.Intn(30)) + 1)))     //Random sleep between 1-30 seconds, you need the 1e9 because it's in nanosecond
+//Waits for the waiting room by user type, reported at the end (see fairness.go)
+var fairness *Fairness
+
+//Occupied offices, for the utilization in the metrics of the run (see metrics.go)
+var offices *Usage
+
+//Durations of the services in the offices (-service flag, see workload.go)
+var service Service
+
+//With -queueing, the users measured for the M/M/c/K check (see queueing.go)
+var queue *Queue
+
+//Which user type enters the waiting room first (-policy flag, see policy.go);
+//nil is the original strict priority ADMIN, PRIVATE_SINGLE, PRIVATE_WITH
+var policy *Policy
+
+//Creates all the channels; size is the buffer of the request channels (MAX_BUFFER in main)
+func initChannels(size int) {
+	terminate = make(chan bool)
+	done = make(chan bool)
+
+	for i := 0; i < USER_TYPES; i++ {
+		enterWaitingRoom[i] = make(chan User, size)
+	}
+	for i := 0; i < FINANCE_TYPES; i++ {
+		enterOffice[i] = make(chan User, size)
+	}
+	exitOffice = make(chan int, size)
+}
+
+//What a user comes for: the type of financing and the duration of the service,
+//by default between 1-30 seconds
+type visit struct {
+	serviceType int
+	duration    time.Duration
+}
+
+//Visits of the users drawn by main before the run: with -backend check the two
+//backends serve the same users in the same way (see des.go)
+var visits []visit
+
+//Utility function: draw the visits of n users
+func drawVisits(n int) []visit {
+	v := make([]visit, n)
+	for id := range v {
+		v[id] = visit{rand.Intn(FINANCE_TYPES), service.Draw()}
+	}
+	return v
+}
+
+//Utility function: the visit of user id, drawn by main or now
+func visitOf(id int) visit {
+	if id < len(visits) {
+		return visits[id]
+	}
+	return drawVisits(1)[0]
+}
+
+//Utility function: users waiting to enter the waiting room, by user type
+func waitingRoomQueues() []int {
+	return []int{len(enterWaitingRoom[ADMIN]), len(enterWaitingRoom[PRIVATE_SINGLE]), len(enterWaitingRoom[PRIVATE_WITH])}
 }
 
 //Utility function: conditional channel activation (Logic guard)
@@ -54,6 +117,7 @@ func when(condition bool, ch chan User) chan User {
 }
 
 func server() {
+	clock := NewClock("server")
 	var i int
 	waitingRoomCount := 0 // Number of people in the waiting room
 	officesOccupied := 0  // Number of occupied offices
@@ -61,132 +125,350 @@ func server() {
 	for i = 0; i < NUM_OFFICES; i++ {
 		officeOccupied[i] = false // Initialize all offices as unoccupied
 	}
-	fmt.Printf("The consulting service is open.\n\n")
+	//Admin commands (see admin.go): while paused nobody enters the waiting room or an
+	//office; a smaller waiting room admits nobody until it empties below the new size,
+	//and an office out of service is closed once its user has left
+	paused := false
+	maxWaitingRoom := MAX_WAITING_ROOM
+	var officeClosed [NUM_OFFICES]bool // Office out of service
+	officesClosed := 0                 // Number of offices out of service and free
+	clock.Printf("The consulting service is open.\n\n")
 	for {
 		select {
 		//Case 1: An administrator enters the waiting room
-		case request := <-when(waitingRoomCount < MAX_WAITING_ROOM, enterWaitingRoom[ADMIN]):
+		case request := <-when(!paused && waitingRoomCount < maxWaitingRoom && policy.Allows(ADMIN, waitingRoomQueues()), enterWaitingRoom[ADMIN]):
+			clock.Receive(request.stamp, "enterWaitingRoom[ADMIN]")
+			policy.Served(ADMIN)
 			waitingRoomCount += 1
-			fmt.Printf("SERVER: Administrator %d entered the waiting room.\n", request.id)
-			request.reply <- 1 // Notify the client that they entered successfully
+			clock.Printf("SERVER: Administrator %d entered the waiting room.\n", request.id)
+			SendOn(clock, request.reply, 1, "reply") // Notify the client that they entered successfully
 
 		//Case 2: A private individual without an accompanist enters the waiting room
-		case request := <-when(waitingRoomCount < MAX_WAITING_ROOM && len(enterWaitingRoom[ADMIN]) == 0, enterWaitingRoom[PRIVATE_SINGLE]):
+		case request := <-when(!paused && waitingRoomCount < maxWaitingRoom && policy.Allows(PRIVATE_SINGLE, waitingRoomQueues()), enterWaitingRoom[PRIVATE_SINGLE]):
+			clock.Receive(request.stamp, "enterWaitingRoom[PRIVATE_SINGLE]")
+			policy.Served(PRIVATE_SINGLE)
 			waitingRoomCount += 1
-			fmt.Printf("SERVER: Private individual (alone) %d entered the waiting room.\n", request.id)
-			request.reply <- 1
+			clock.Printf("SERVER: Private individual (alone) %d entered the waiting room.\n", request.id)
+			SendOn(clock, request.reply, 1, "reply")
 
 		//Case 3: A private individual with an accompanist enters the waiting room
-		case request := <-when(waitingRoomCount+2 <= MAX_WAITING_ROOM && len(enterWaitingRoom[ADMIN]) == 0 && len(enterWaitingRoom[PRIVATE_SINGLE]) == 0, enterWaitingRoom[PRIVATE_WITH]):
+		case request := <-when(!paused && waitingRoomCount+2 <= maxWaitingRoom && policy.Allows(PRIVATE_WITH, waitingRoomQueues()), enterWaitingRoom[PRIVATE_WITH]):
+			clock.Receive(request.stamp, "enterWaitingRoom[PRIVATE_WITH]")
+			policy.Served(PRIVATE_WITH)
 			waitingRoomCount += 2
-			fmt.Printf("SERVER: Private individual with accompanist %d entered the waiting room.\n", request.id)
-			request.reply <- 1
+			clock.Printf("SERVER: Private individual with accompanist %d entered the waiting room.\n", request.id)
+			SendOn(clock, request.reply, 1, "reply")
 
 		//Case 4: A client enters an office for a Superbonus service
-		case request := <-when(officesOccupied < NUM_OFFICES, enterOffice[SUPERBONUS]):
+		case request := <-when(!paused && officesOccupied+officesClosed < NUM_OFFICES, enterOffice[SUPERBONUS]):
+			clock.Receive(request.stamp, "enterOffice[SUPERBONUS]")
 			for i = 0; i < NUM_OFFICES; i++ { // Find the first available office
-				if !officeOccupied[i] {
+				if !officeOccupied[i] && !officeClosed[i] {
 					break
 				}
 			}
 			officeOccupied[i] = true
 			officesOccupied++
+			offices.Add(1)
 			if request.userType == PRIVATE_WITH {
 				waitingRoomCount -= 2 // Free up 2 spots in the waiting room
-				fmt.Printf("SERVER: Private individual with accompanist for Superbonus %d entered office %d.\n", request.id, i)
+				clock.Printf("SERVER: Private individual with accompanist for Superbonus %d entered office %d.\n", request.id, i)
 			} else {
 				waitingRoomCount -= 1 // Free up 1 spot in the waiting room
 				if request.userType == ADMIN {
-					fmt.Printf("SERVER: Administrator for Superbonus %d entered office %d.\n", request.id, i)
+					clock.Printf("SERVER: Administrator for Superbonus %d entered office %d.\n", request.id, i)
 				} else {
-					fmt.Printf("SERVER: Private individual (alone) for Superbonus %d entered office %d.\n", request.id, i)
+					clock.Printf("SERVER: Private individual (alone) for Superbonus %d entered office %d.\n", request.id, i)
 				}
 			}
-			request.reply <- i // Send the office number to the client
+			SendOn(clock, request.reply, i, "reply") // Send the office number to the client
 
 		//Case 5: A client enters an office for a different service
-		case request := <-when(officesOccupied < NUM_OFFICES && len(enterOffice[SUPERBONUS]) == 0, enterOffice[OTHER]):
+		case request := <-when(!paused && officesOccupied+officesClosed < NUM_OFFICES && len(enterOffice[SUPERBONUS]) == 0, enterOffice[OTHER]):
+			clock.Receive(request.stamp, "enterOffice[OTHER]")
 			for i = 0; i < NUM_OFFICES; i++ { // Find the first available office
-				if !officeOccupied[i] {
+				if !officeOccupied[i] && !officeClosed[i] {
 					break
 				}
 			}
 			officeOccupied[i] = true
 			officesOccupied++
+			offices.Add(1)
 			if request.userType == PRIVATE_WITH {
 				waitingRoomCount -= 2 // Free up 2 spots in the waiting room
-				fmt.Printf("SERVER: Private individual with accompanist for Other service %d entered office %d.\n", request.id, i)
+				clock.Printf("SERVER: Private individual with accompanist for Other service %d entered office %d.\n", request.id, i)
 			} else {
 				waitingRoomCount -= 1 // Free up 1 spot in the waiting room
 				if request.userType == ADMIN {
-					fmt.Printf("SERVER: Administrator for Other service %d entered office %d.\n", request.id, i)
+					clock.Printf("SERVER: Administrator for Other service %d entered office %d.\n", request.id, i)
 				} else {
-					fmt.Printf("SERVER: Private individual (alone) for Other service %d entered office %d.\n", request.id, i)
+					clock.Printf("SERVER: Private individual (alone) for Other service %d entered office %d.\n", request.id, i)
 				}
 			}
-			request.reply <- i // Send the office number to the client
+			SendOn(clock, request.reply, i, "reply") // Send the office number to the client
 
 		//Case 6: A client exits an office
 		case release := <-exitOffice:
+			ReceivedOn(clock, exitOffice, "exitOffice")
 			officeOccupied[release] = false // Mark the office as unoccupied
 			officesOccupied--
+			offices.Add(-1)
+			if officeClosed[release] {
+				officesClosed++
+				clock.Printf("SERVER: Office %d is now out of service.\n", release)
+			}
 
-		//Case 7: Terminate the service
+		//Case 7: An admin command
+		case cmd := <-adminCommands:
+			switch cmd.Op {
+			case ADMIN_PAUSE, ADMIN_RESUME:
+				paused = cmd.Op == ADMIN_PAUSE
+				clock.Printf("SERVER: Admin: %s.\n", cmd.Op)
+				cmd.Reply("paused: %v", paused)
+			case ADMIN_SET:
+				if cmd.Name != "MAX_WAITING_ROOM" {
+					cmd.Fail("no capacity %s (MAX_WAITING_ROOM)", cmd.Name)
+				} else if n, err := cmd.Capacity(MAX_WAITING_ROOM); err == nil {
+					maxWaitingRoom = n
+					clock.Printf("SERVER: Admin: waiting room of %d seats (%d taken).\n", maxWaitingRoom, waitingRoomCount)
+					cmd.Reply("MAX_WAITING_ROOM: %d, waiting room: %d", maxWaitingRoom, waitingRoomCount)
+				}
+			case ADMIN_OUT, ADMIN_IN:
+				if cmd.Name != "office" {
+					cmd.Fail("no %s to take out of service (office)", cmd.Name)
+					break
+				}
+				o, err := cmd.Resource(NUM_OFFICES)
+				if err != nil {
+					break
+				}
+				switch {
+				case cmd.Op == ADMIN_OUT && officeClosed[o]:
+					cmd.Fail("office %d is already out of service", o)
+				case cmd.Op == ADMIN_IN && !officeClosed[o]:
+					cmd.Fail("office %d is already in service", o)
+				case cmd.Op == ADMIN_OUT:
+					officeClosed[o] = true
+					if officeOccupied[o] {
+						clock.Printf("SERVER: Admin: office %d out of service when its user leaves.\n", o)
+						cmd.Reply("office %d out of service when its user leaves", o)
+					} else {
+						officesClosed++
+						clock.Printf("SERVER: Admin: office %d out of service.\n", o)
+						cmd.Reply("office %d out of service", o)
+					}
+				default:
+					officeClosed[o] = false
+					if !officeOccupied[o] {
+						officesClosed--
+					}
+					clock.Printf("SERVER: Admin: office %d back in service.\n", o)
+					cmd.Reply("office %d in service", o)
+				}
+			default: // ADMIN_DUMP
+				var occupied, closed []int
+				for o := 0; o < NUM_OFFICES; o++ {
+					if officeOccupied[o] {
+						occupied = append(occupied, o)
+					}
+					if officeClosed[o] {
+						closed = append(closed, o)
+					}
+				}
+				cmd.Reply("paused: %v, waiting room: %d/%d, offices occupied: %v, out of service: %v, waiting: room %v, offices %d",
+					paused, waitingRoomCount, maxWaitingRoom, occupied, closed, waitingRoomQueues(), len(enterOffice[SUPERBONUS])+len(enterOffice[OTHER]))
+			}
+
+		//Case 8: Terminate the service
 		case <-terminate:
-			fmt.Printf("The consulting service is closing.\n")
+			clock.Printf("The consulting service is closing.\n")
 			done <- true
 			return
 		}
 	}
 }
 
-func user(id int) {
-	userType := rand.Intn(USER_TYPES)   // Administrator, individual, or accompanied
-	serviceType := rand.Intn(FINANCE_TYPES) // Type of financing (Superbonus or Other)
+//Started by main at the arrival time of the user; userType (administrator, individual,
+//or accompanied) comes from the workload
+func user(id int, userType int) {
+	visit := visitOf(id)
+	serviceType := visit.serviceType // Type of financing (Superbonus or Other)
 	var ack = make(chan int)
-	var request User
-	request := User{id, userType, serviceType, ack}
+	request := User{id: id, userType: userType, serviceType: serviceType, reply: ack}
+	clock := NewClock(fmt.Sprintf("user %d", id))
+
+	//With -queueing, a user who finds the office full leaves (M/M/c/K)
+	customer, admitted := queue.Arrive()
+	if !admitted {
+		clock.Printf("User [%d]: the office is full. Terminating.\n", id)
+		done <- true
+		return
+	}
 
 	//Entering the waiting room
-	sleepRandom()
+	ticket := fairness.Request(id, userType)
+	request.stamp = clock.Send("enterWaitingRoom")
 	enterWaitingRoom[userType] <- request
 	<-request.reply
+	ReceivedOn(clock, request.reply, "reply")
+	fairness.Served(ticket)
 
 	//Entering in an office
+	request.stamp = clock.Send("enterOffice")
 	enterOffice[serviceType] <- request
 	officeAssigned := <-request.reply
-	sleepRandom()
+	ReceivedOn(clock, request.reply, "reply")
+	queue.Start(customer)
+	time.Sleep(visit.duration) // simulate the service
 	
-	exitOffice <- officeAssigned
-	fmt.Printf("User [%d]: I have exited office %d. Terminating.\n", id, officeAssigned)
+	queue.Leave(customer)
+	SendOn(clock, exitOffice, officeAssigned, "exitOffice")
+	clock.Printf("User [%d]: I have exited office %d. Terminating.\n", id, officeAssigned)
 	done <- true
 }
 
+//The request of a user to the simulated server: what the user does once served
+type simRequest struct {
+	User
+	then func(office int)
+}
+
+//Seats taken in the waiting room by a user of the given type
+func seats(userType int) int {
+	if userType == PRIVATE_WITH {
+		return 2
+	}
+	return 1
+}
+
+//Runs the users of the plan in a discrete-event simulation, without goroutines
+//(see des.go): the same guards and steps as server() and user(), with the
+//requests queued in the cases of a simulated server instead of the channels.
+//Nobody leaves because the office is full: -queueing measures the goroutines only
+func simulateOffice(plan []Arrival, userTypes []string) Run {
+	sim := NewSim(*seedFlag)
+	simFairness := sim.NewFairness(userTypes)
+	simOffices := sim.NewUsage("offices", NUM_OFFICES)
+	simPolicy := PolicyFromFlags(userTypes, "strict").WithClock(sim.Time)
+	srv := sim.NewServer()
+
+	waitingRoomCount := 0
+	officesOccupied := 0
+	var officeOccupied [NUM_OFFICES]bool
+	var room [USER_TYPES]*Case[simRequest]
+	var office [FINANCE_TYPES]*Case[simRequest]
+	queues := func() []int { return []int{room[ADMIN].Len(), room[PRIVATE_SINGLE].Len(), room[PRIVATE_WITH].Len()} }
+
+	//Cases 1-3: a user enters the waiting room
+	for userType := range USER_TYPES {
+		room[userType] = NewCase(srv, func() bool {
+			return waitingRoomCount+seats(userType) <= MAX_WAITING_ROOM && simPolicy.Allows(userType, queues())
+		}, func(request simRequest) {
+			simPolicy.Served(userType)
+			waitingRoomCount += seats(userType)
+			sim.After(0, func() { request.then(1) })
+		})
+	}
+	//Cases 4-5: a user enters the first free office, Superbonus services first
+	enter := func(request simRequest) {
+		i := 0
+		for officeOccupied[i] {
+			i++
+		}
+		officeOccupied[i] = true
+		officesOccupied++
+		simOffices.Add(1)
+		waitingRoomCount -= seats(request.userType)
+		sim.After(0, func() { request.then(i) })
+	}
+	office[SUPERBONUS] = NewCase(srv, func() bool { return officesOccupied < NUM_OFFICES }, enter)
+	office[OTHER] = NewCase(srv, func() bool { return officesOccupied < NUM_OFFICES && office[SUPERBONUS].Len() == 0 }, enter)
+	//Case 6: a user exits an office
+	exit := NewCase(srv, nil, func(release int) {
+		officeOccupied[release] = false
+		officesOccupied--
+		simOffices.Add(-1)
+	})
+
+	//The users, as in user()
+	for id, a := range plan {
+		sim.After(a.At, func() {
+			visit := visitOf(id)
+			serviceType := visit.serviceType
+			request := User{id: id, userType: a.Class, serviceType: serviceType}
+			ticket := simFairness.Request(id, a.Class)
+			room[a.Class].Send(simRequest{request, func(int) {
+				simFairness.Served(ticket)
+				office[serviceType].Send(simRequest{request, func(officeAssigned int) {
+					sim.After(visit.duration, func() { exit.Send(officeAssigned) })
+				}})
+			}})
+		})
+	}
+	sim.Run()
+	return Run{simFairness, []*Usage{simOffices}}
+}
+
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
-	//Makings channels
-	terminate = make(chan bool)
-	done = make(chan bool)
-	
-	for i := 0; i < USER_TYPES; i++ {
-		enterWaitingRoom[i] = make(chan User, MAX_BUFFER)
-	}
-	for i := 0; i < FINANCE_TYPES; i++ {
-		enterOffice[i] = make(chan User, MAX_BUFFER)
+	//Users arrive following the workload (-arrivals and -mix flags, see workload.go):
+	//by default after 1-30 seconds each, with the three user types equally likely;
+	//-clients changes their number (see des.go)
+	userTypes := []string{"ADMIN", "PRIVATE_SINGLE", "PRIVATE_WITH"}
+	workload := WorkloadFromFlags(userTypes, "spread:max=30", "ADMIN=1,PRIVATE_SINGLE=1,PRIVATE_WITH=1")
+	service = ServiceFromFlags("uniform:min=1,max=30")
+	plan := workload.Plan(Clients(NUM_USERS))
+	visits = drawVisits(len(plan))
+
+	//With -backend des the users are simulated without goroutines, with -backend
+	//check both ways and the two runs are compared (see des.go)
+	backend := Backend()
+	var simulated Run
+	if backend != "goroutines" {
+		simulated = simulateOffice(plan, userTypes)
+		if backend == "des" {
+			simulated.Fairness.Report(os.Stdout)
+			WriteMetrics(simulated.Fairness, simulated.Usage...)
+			return
+		}
 	}
-	exitOffice = make(chan int, MAX_BUFFER)
 
-	//Making goroutine
+	//Makings channels
+	initChannels(MAX_BUFFER)
+
+	fairness = FairnessFromFlags(userTypes)
+	policy = PolicyFromFlags(userTypes, "strict")
+	offices = NewUsage("offices", NUM_OFFICES)
+	queue = QueueFromFlags(NUM_OFFICES, NUM_OFFICES+MAX_WAITING_ROOM, workload, service)
+
+	//With -causal, the logical clocks of the messages go to a trace (see causal.go)
+	StartCausal()
+
+	//Admin commands for the offices, with -admin (see admin.go); the des backend has none
+	stopAdmin := StartAdminFromFlags()
+
+	//Making goroutine, once the policy and the admin commands it reads are set
 	go server()
-	for id := 0; id < NUM_USERS; id++ {
-		go user(id)
-	}
+	SpawnPlan(plan, user)
 
 	//Join goroutine
-	for id := 0; id < NUM_USERS; id++ {
+	for range plan {
 		<-done
 	}
 	terminate <- true
 	<-done
+	stopAdmin()
+	StopCausal()
+	fairness.Report(os.Stdout)
+	WriteMetrics(fairness, offices)
+	queue.Report(os.Stdout)
+	if backend == "check" {
+		Compare(os.Stdout, Run{fairness, []*Usage{offices}}, simulated)
+	}
+
+	if err := CheckLeaks(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
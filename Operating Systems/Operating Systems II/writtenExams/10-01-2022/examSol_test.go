@@ -0,0 +1,451 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR server() (CONSULTING OFFICE)
+//
+// The tests feed server() scripted sequences of users entering the waiting
+// room, the offices and leaving, under a virtual clock, and check who is
+// admitted, in which order, which office they get, and that the waiting room
+// and the offices are empty at the end. TestQueueing runs a long day of users
+// with Poisson arrivals and exponential services, and compares it with the
+// M/M/c/K model (see queueing.go). TestBackends runs the same day with the
+// goroutines and with the discrete-event simulation (see des.go) and compares
+// them. TestAdmin shrinks the waiting room, takes an office out of service
+// and pauses the server with admin commands (see admin.go).
+//
+// Synthetic users repeat the cycle of user() without any sleep: enter the
+// waiting room (enterWaitingRoom[userType]), move to an office
+// (enterOffice[serviceType]) and leave it (exitOffice). User type and service
+// type are fixed per user (id % USER_TYPES and id / USER_TYPES % FINANCE_TYPES),
+// so every combination is present. There are more users than offices and
+// waiting-room seats, so some of them wait outside.
+//
+// The benchmark reports:
+//   - grants/s:        users admitted in an office per second;
+//   - p50-ns, p99-ns:  latency from the waiting-room request to the office assignment;
+//   - allocs/op:       allocations per grant.
+// It runs twice: with unbuffered request channels and with buffered ones
+// (MAX_BUFFER slots).
+//
+// Run with:
+//     go test -race examSol.go workload.go fairness.go metrics.go leaks.go policy.go queueing.go des.go causal.go admin.go servertest_test.go examSol_test.go
+//     go test -run XXX -bench . examSol.go workload.go fairness.go metrics.go leaks.go policy.go queueing.go des.go causal.go admin.go servertest_test.go examSol_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = 2 * (NUM_OFFICES + MAX_WAITING_ROOM) // Synthetic users
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+const (
+	opAdmin  = iota // enterWaitingRoom[ADMIN] <- user
+	opSingle        // enterWaitingRoom[PRIVATE_SINGLE] <- user
+	opWith          // enterWaitingRoom[PRIVATE_WITH] <- user
+	opSuper         // enterOffice[SUPERBONUS] <- user
+	opOther         // enterOffice[OTHER] <- user
+	opExit          // exitOffice <- office of the user
+)
+
+var opNames = []string{"administrator", "single owner", "accompanied owner", "Superbonus office", "other office", "exit"}
+
+// agency runs the steps of a test, remembering every user.
+type agency struct {
+	*script[int]
+	users map[int]User
+}
+
+func (a *agency) do(st step) {
+	switch st.op {
+	case opAdmin, opSingle, opWith:
+		u := User{id: st.id, userType: st.op - opAdmin, reply: make(chan int)}
+		a.users[u.id] = u
+		a.call(u.id, u.reply, func() { enterWaitingRoom[u.userType] <- u })
+	case opSuper, opOther:
+		u := a.users[st.id]
+		u.serviceType = st.op - opSuper
+		a.call(u.id, u.reply, func() { enterOffice[u.serviceType] <- u })
+	case opExit:
+		office := a.last[st.id]
+		go func() { exitOffice <- office }()
+	}
+}
+
+// serve returns the steps taking the users in the waiting room to an office
+// for other services and out, one at a time.
+func serve(ids ...int) []step {
+	var steps []step
+	for _, id := range ids {
+		steps = append(steps, step{opOther, id, []int{id}}, step{opExit, id, nil})
+	}
+	return steps
+}
+
+func TestServer(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name   string
+		policy string // -policy of the server ("": the original strict priority)
+		steps  []step
+		office map[int]int // office assigned to some of the users
+	}{
+		{"administrators, then single owners, then accompanied owners", "", slices.Concat(
+			each(opAdmin, 0, MAX_WAITING_ROOM-1, true),
+			[]step{
+				{opWith, 21, nil},
+				{opSingle, 20, nil},
+				{opAdmin, 22, nil},
+				{opSuper, 0, []int{0, 22}},
+				{opSuper, 1, []int{1, 20}},
+				{opSuper, 2, []int{2}},
+				{opSuper, 3, []int{3, 21}},
+			},
+			each(opExit, 0, 3, false),
+			serve(4, 5, 6, 7, 8, 9, 20, 21, 22),
+		), map[int]int{0: 0, 1: 1, 2: 2, 3: 3}},
+		{"round-robin among the user types", "wrr:ADMIN=1,PRIVATE_SINGLE=1,PRIVATE_WITH=1", slices.Concat(
+			each(opAdmin, 0, MAX_WAITING_ROOM-1, true),
+			[]step{
+				{opWith, 21, nil},
+				{opSingle, 20, nil},
+				{opAdmin, 22, nil},
+				{opSuper, 0, []int{0, 20}},
+				// The accompanied owner blocks the administrator
+				// until two seats are free.
+				{opSuper, 1, []int{1}},
+				{opSuper, 2, []int{2, 21}},
+				{opSuper, 3, []int{3, 22}},
+			},
+			each(opExit, 0, 3, false),
+			serve(4, 5, 6, 7, 8, 9, 20, 21, 22),
+		), map[int]int{0: 0, 1: 1, 2: 2, 3: 3}},
+		{"an accompanied owner needs two seats", "", slices.Concat(
+			each(opSingle, 0, MAX_WAITING_ROOM-2, true),
+			[]step{
+				{opWith, 20, nil},
+				{opOther, 0, []int{0, 20}},
+				{opExit, 0, nil},
+			},
+			serve(1, 2, 3, 4, 5, 6, 7, 8, 20),
+		), nil},
+		{"Superbonus services go first, in the first free office", "", slices.Concat(
+			each(opAdmin, 0, NUM_OFFICES+1, true),
+			[]step{
+				{opOther, 0, []int{0}},
+				{opSuper, 1, []int{1}},
+				{opSuper, 2, []int{2}},
+				{opOther, 3, []int{3}},
+				{opSuper, 4, []int{4}},
+				{opOther, 5, nil},
+				{opSuper, 6, nil},
+				{opExit, 2, []int{6}},
+				{opExit, 0, []int{5}},
+			},
+			each(opExit, 1, 1, false),
+			each(opExit, 3, 6, false),
+		), map[int]int{0: 0, 1: 1, 2: 2, 4: 4, 5: 0, 6: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				policy = nil
+				if tt.policy != "" {
+					p, err := ParsePolicy(tt.policy, []string{"ADMIN", "PRIVATE_SINGLE", "PRIVATE_WITH"})
+					if err != nil {
+						t.Fatal(err)
+					}
+					policy = p
+				}
+				initChannels(MAX_BUFFER)
+				go server()
+				a := &agency{newScript[int](t), map[int]User{}}
+				for _, st := range tt.steps {
+					a.do(st)
+					a.expect(st.String(), st.want)
+				}
+				a.finish()
+				for id, want := range tt.office {
+					if got := a.last[id]; got != want {
+						t.Errorf("user %d got office %d, want %d", id, got, want)
+					}
+				}
+				a.checkEmpty()
+				terminate <- true
+				<-done
+			})
+		})
+	}
+}
+
+// checkEmpty verifies that the waiting room and the offices are empty:
+// MAX_WAITING_ROOM users sit down at once, and NUM_OFFICES of them
+// get the offices 0..NUM_OFFICES-1 at once.
+func (a *agency) checkEmpty() {
+	a.t.Helper()
+	room := each(opAdmin, 50, 50+MAX_WAITING_ROOM-1, false)
+	for _, st := range room {
+		a.do(st)
+	}
+	if got := a.grants(); len(got) != len(room) {
+		a.t.Errorf("final state: %d of %d users in the waiting room", len(got), len(room))
+	}
+	a.finish()
+	for _, group := range [][]step{room[:NUM_OFFICES], room[NUM_OFFICES:]} {
+		for _, st := range group {
+			a.do(step{op: opOther, id: st.id})
+		}
+		var offices []int
+		for _, id := range a.grants() {
+			offices = append(offices, a.last[id])
+		}
+		slices.Sort(offices)
+		want := make([]int, NUM_OFFICES)
+		for i := range want {
+			want[i] = i
+		}
+		if !slices.Equal(offices, want) {
+			a.t.Errorf("final state: offices %v assigned, want %v", offices, want)
+		}
+		a.finish()
+		for _, st := range group {
+			a.do(step{op: opExit, id: st.id})
+		}
+	}
+}
+
+// run does the steps, checking the users granted after each of them.
+func (a *agency) run(steps ...[]step) {
+	a.t.Helper()
+	for _, st := range slices.Concat(steps...) {
+		a.do(st)
+		a.expect(st.String(), st.want)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		policy = nil
+		initChannels(MAX_BUFFER)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server()
+		a := &agency{newScript[int](t), map[int]User{}}
+		a.run(each(opAdmin, 0, MAX_WAITING_ROOM-1, true))
+
+		// A full waiting room of 6 seats: nobody sits down until fewer
+		// than 6 are left
+		a.admin(Admin, "set MAX_WAITING_ROOM 6", "MAX_WAITING_ROOM: 6, waiting room: 10", nil)
+		a.run([]step{
+			{opAdmin, 20, nil},
+			{opOther, 0, []int{0}},
+			{opOther, 1, []int{1}},
+			{opOther, 2, []int{2}},
+			{opOther, 3, []int{3}},
+			{opOther, 4, []int{4, 20}},
+		})
+
+		// Office 2 closes when its user leaves, and nobody else gets it
+		a.admin(Admin, "out office 2", "office 2 out of service when its user leaves", nil)
+		a.admin(Admin, "out office 5", "error: there is no office 5 (0-4)", nil)
+		a.admin(Admin, "out desk 1", "error: no desk to take out of service (office)", nil)
+		a.run([]step{
+			{opExit, 2, nil},
+			{opOther, 5, nil},
+			{opExit, 0, []int{5}},
+		})
+
+		// Paused: the exits go on, the entries wait for resume
+		a.admin(Admin, "pause", "paused: true", nil)
+		a.run([]step{
+			{opExit, 1, nil},
+			{opOther, 6, nil},
+		})
+		a.admin(Admin, "dump", "paused: true, waiting room: 5/6, offices occupied: [0 3 4], "+
+			"out of service: [2], waiting: room [0 0 0], offices 1", nil)
+		a.admin(Admin, "resume", "paused: false", []int{6})
+
+		a.admin(Admin, "in office 2", "office 2 in service", nil)
+		a.admin(Admin, "in office 2", "error: office 2 is already in service", nil)
+		a.run([]step{{opOther, 7, []int{7}}})
+		for id, want := range map[int]int{5: 0, 6: 1, 7: 2} {
+			if got := a.last[id]; got != want {
+				t.Errorf("user %d got office %d, want %d", id, got, want)
+			}
+		}
+
+		// Back to MAX_WAITING_ROOM, everybody leaves and the office is
+		// empty again
+		a.admin(Admin, "set MAX_WAITING_ROOM 10", "MAX_WAITING_ROOM: 10, waiting room: 3", nil)
+		a.run(each(opExit, 3, 7, false), serve(8, 9, 20))
+		a.finish()
+		a.checkEmpty()
+		terminate <- true
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// TestQueueing runs a long day of the office in simulated time, with Poisson
+// arrivals, exponential services and no accompanied owners: an M/M/c/K queue
+// with c = NUM_OFFICES and K = NUM_OFFICES + MAX_WAITING_ROOM. The measured
+// values must be close to the predictions of the model (see queueing.go).
+func TestQueueing(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		const users = 50000
+		model := Model{Lambda: 0.3, Mu: 1.0 / 15, C: NUM_OFFICES, K: NUM_OFFICES + MAX_WAITING_ROOM}
+		w, err := NewWorkload([]string{"ADMIN", "PRIVATE_SINGLE", "PRIVATE_WITH"}, Poisson{model.Lambda}, []float64{1, 1, 0}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy = nil
+		service = Exponential{15 * time.Second}
+		queue = NewQueue(model)
+		defer func() { queue = nil }()
+		initChannels(MAX_BUFFER)
+		go server()
+		w.Spawn(users, user)
+		for range users {
+			<-done
+		}
+		terminate <- true
+		<-done
+		checkQueueing(t, queue)
+	})
+}
+
+// TestBackends runs the same users, with the same arrival times and classes,
+// with the goroutines (in simulated time) and with the discrete-event
+// simulation, who come for the same services: the two runs differ only in the
+// choices of the server among its enabled cases, so the waits and the
+// utilization of the offices must agree.
+func TestBackends(t *testing.T) {
+	silence(t)
+	userTypes := []string{"ADMIN", "PRIVATE_SINGLE", "PRIVATE_WITH"}
+	w, err := NewWorkload(userTypes, Poisson{0.3}, []float64{1, 1, 1}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := w.Plan(50000)
+	service = Exponential{15 * time.Second}
+	visits = drawVisits(len(plan))
+	defer func() { visits = nil }()
+	policy = nil
+
+	// The metrics of the goroutines read the clock of the bubble: they are
+	// taken inside it, before the end of the run moves on.
+	synctest.Test(t, func(t *testing.T) {
+		fairness = NewFairness(userTypes, 10)
+		offices = NewUsage("offices", NUM_OFFICES)
+		initChannels(MAX_BUFFER)
+		go server()
+		SpawnPlan(plan, user)
+		for range plan {
+			<-done
+		}
+		terminate <- true
+		<-done
+		goroutines := Run{fairness, []*Usage{offices}}
+		des := simulateOffice(plan, userTypes)
+
+		var report strings.Builder
+		Compare(&report, goroutines, des)
+		t.Log(report.String())
+		_, g := goroutines.Metrics()
+		_, d := des.Metrics()
+		for _, m := range []struct {
+			name      string
+			tolerance float64 // relative
+		}{
+			{"served", 0},
+			{"elapsed", 0.001},
+			{"utilization_offices", 0.01},
+			{"mean_wait", 0.05},
+			{"mean_wait_ADMIN", 0.1},
+			{"mean_wait_PRIVATE_SINGLE", 0.1},
+			{"mean_wait_PRIVATE_WITH", 0.1},
+		} {
+			if math.Abs(d[m.name]-g[m.name]) > m.tolerance*g[m.name] {
+				t.Errorf("%s: %.4g with the goroutines, %.4g with the simulation", m.name, g[m.name], d[m.name])
+			}
+		}
+	})
+}
+
+// checkQueueing compares what q measured with its model, and logs the report.
+func checkQueueing(t *testing.T, q *Queue) {
+	t.Helper()
+	var report strings.Builder
+	q.Report(&report)
+	t.Log(report.String())
+	want, err := q.model.Predict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := q.Measured()
+	for _, m := range []struct {
+		name      string
+		want, got float64
+		tolerance float64 // relative
+	}{
+		{"utilization", want.Utilization, got.Utilization, 0.05},
+		{"mean queue length", want.Queue, got.Queue, 0.25},
+		{"mean in the system", want.System, got.System, 0.15},
+		{"blocking probability", want.Blocking, got.Blocking, 0.25},
+		{"P(wait)", want.Wait, got.Wait, 0.1},
+		{"mean wait", want.MeanWait, got.MeanWait, 0.25},
+	} {
+		if m.want == 0 && m.got == 0 {
+			continue
+		}
+		if math.Abs(m.got-m.want) > m.tolerance*m.want {
+			t.Errorf("%s: measured %.4g, the model predicts %.4g", m.name, m.got, m.want)
+		}
+	}
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+func BenchmarkServer(b *testing.B) {
+	silence(b)
+	for _, m := range bufferModes(MAX_BUFFER) {
+		b.Run(m.name, func(b *testing.B) {
+			initChannels(m.size)
+			go server()
+			users := make([]User, loadClients)
+			for id := range users {
+				users[id] = User{id: id, userType: id % USER_TYPES, serviceType: id / USER_TYPES % FINANCE_TYPES, reply: make(chan int)}
+			}
+
+			measure(b, loadClients, func(id int) time.Duration {
+				request := users[id]
+				t := time.Now()
+				enterWaitingRoom[request.userType] <- request
+				<-request.reply
+				enterOffice[request.serviceType] <- request
+				office := <-request.reply
+				d := time.Since(t)
+				exitOffice <- office
+				return d
+			})
+
+			terminate <- true
+			<-done
+		})
+	}
+}
@@ -0,0 +1 @@
+../../leaks/leaks.go
\ No newline at end of file
@@ -1,8 +1,12 @@
+// Run with:
+//     go run examSol.go workload.go leaks.go causal.go admin.go
+
 package main
 
 import (
     "fmt"
     "math/rand"
+    "os"
     "time"
 )
 
@@ -27,10 +31,12 @@ const SORV int = 2  // supervisor
 //   - id:   ID of the request (or goroutine)
 //   - tipo: which type of entity (single, school group, or supervisor)
 //   - ack:  a channel to receive acknowledgment (server replies with an int)
+//   - stamp: logical time of the send, with -causal (see causal.go)
 type richiesta struct {
-    id   int
-    tipo int
-    ack  chan int
+    id    int
+    tipo  int
+    ack   chan int
+    stamp Stamp
 }
 
 // Channels to enter the corridor in direction IN.
@@ -40,12 +46,26 @@ var entrataC_IN [3]chan richiesta
 var entrataC_OUT [3]chan richiesta
 
 // Channels to exit the corridor (either from IN or OUT direction).
-var uscitaC_IN = make(chan richiesta, MAXBUFF)
-var uscitaC_OUT = make(chan richiesta, MAXBUFF)
+var uscitaC_IN chan richiesta
+var uscitaC_OUT chan richiesta
 
 // Channels for synchronization and termination
-var done = make(chan bool, MAXBUFF)    // Signifies that a goroutine has completed
-var termina = make(chan bool, MAXBUFF) // Tells the server to stop
+var done chan bool    // Signifies that a goroutine has completed
+var termina chan bool // Tells the server to stop
+
+// initChannels creates all the channels above.
+// 'size' is the buffer of the corridor request channels (MAXBUFF in main).
+func initChannels(size int) {
+    for i := 0; i < 3; i++ {
+        entrataC_IN[i] = make(chan richiesta, size)
+        entrataC_OUT[i] = make(chan richiesta, size)
+    }
+    uscitaC_IN = make(chan richiesta, size)
+    uscitaC_OUT = make(chan richiesta, size)
+
+    done = make(chan bool, MAXBUFF)
+    termina = make(chan bool, MAXBUFF)
+}
 
 // Utility function: prints the type of visitor/supervisor
 func printTipo(typ int) string {
@@ -79,73 +99,92 @@ func printDirezione(typ int) string {
 //  - A school group has 25 members (they enter/exit as a block).
 //  - Supervisors must be present for single visitors or school groups to enter, etc.
 func server() {
+    clock := NewClock("server")
     scolaresche_in_C := [2]int{0, 0} // number of school groups in the corridor, indexed by direction [IN, OUT]
     persone_in_C := [2]int{0, 0}     // number of people in the corridor, indexed by direction [IN, OUT]
     var persone_in_sala = 0          // how many people are currently in the hall
     var sorveglianti_in_sala = 0     // how many supervisors are currently in the hall
 
+    // Admin commands (see admin.go): while paused nobody enters the corridor
+    // IN (the others still leave); the capacities can be lowered below the
+    // people inside, who stay, and nobody else gets in until they drop below
+    // the new limit
+    paused := false
+    maxSala := N
+    maxC := NC
+    maxSorv := MaxS
+
     for {
         select {
         // -----------------------------
         // ENTRANCE: corridor direction IN
         // 1) A SUPERVISOR enters the corridor IN
         // Conditions:
+        //   - Not paused
         //   - No school groups in the OUT corridor ( scolaresche_in_C[OUT] == 0 )
         //   - Total corridor usage < NC
         //   - People in the hall < N
         //   - Supervisors in the hall < MaxS
         //   - No pending school groups or supervisors or single visitors waiting to enter the corridor OUT
         case x := <-when(
+            !paused &&
             scolaresche_in_C[OUT] == 0 &&
-            (persone_in_C[IN]+persone_in_C[OUT]) < NC &&
-            persone_in_sala < N &&
-            sorveglianti_in_sala < MaxS &&
+            (persone_in_C[IN]+persone_in_C[OUT]) < maxC &&
+            persone_in_sala < maxSala &&
+            sorveglianti_in_sala < maxSorv &&
             (len(entrataC_OUT[SCOL])+len(entrataC_OUT[SORV])+len(entrataC_OUT[SING]) == 0),
             entrataC_IN[SORV]):
             
+            clock.Receive(x.stamp, "entrataC_IN[SORV]")
             persone_in_C[IN]++
             persone_in_sala++
             sorveglianti_in_sala++
-            x.ack <- 1
+            SendOn(clock, x.ack, 1, "ack")
 
         // 2) A SINGLE VISITOR enters the corridor IN
         // Conditions:
+        //   - Not paused
         //   - No school groups in OUT corridor
         //   - Corridor usage < NC
         //   - Hall usage < N
         //   - At least 1 supervisor in hall ( sorveglianti_in_sala > 0 )
         //   - No supervisors waiting in the IN corridor or anything else in the OUT corridor with higher priority
         case x := <-when(
+            !paused &&
             scolaresche_in_C[OUT] == 0 &&
-            (persone_in_C[IN]+persone_in_C[OUT]) < NC &&
-            persone_in_sala < N &&
+            (persone_in_C[IN]+persone_in_C[OUT]) < maxC &&
+            persone_in_sala < maxSala &&
             sorveglianti_in_sala > 0 &&  
             (len(entrataC_IN[SORV])+len(entrataC_OUT[SCOL])+len(entrataC_OUT[SORV])+len(entrataC_OUT[SING]) == 0),
             entrataC_IN[SING]):
             
+            clock.Receive(x.stamp, "entrataC_IN[SING]")
             persone_in_C[IN]++
             persone_in_sala++
-            x.ack <- 1
+            SendOn(clock, x.ack, 1, "ack")
 
         // 3) A SCHOOL GROUP enters the corridor IN
         // Conditions:
+        //   - Not paused
         //   - No people in OUT corridor
         //   - Enough space in the corridor for 25 ( scolari )
         //   - Enough space in the hall for 25
         //   - At least 1 supervisor present
         //   - No one is queued in the IN corridor for supervisor/single visitor or anything in the OUT corridor
         case x := <-when(
-            persone_in_C[OUT] == 0 &&
-                (persone_in_C[IN]+persone_in_C[OUT])+scolari <= NC &&
-                persone_in_sala+scolari <= N &&
+            !paused &&
+                persone_in_C[OUT] == 0 &&
+                (persone_in_C[IN]+persone_in_C[OUT])+scolari <= maxC &&
+                persone_in_sala+scolari <= maxSala &&
                 sorveglianti_in_sala > 0 &&
                 (len(entrataC_IN[SORV])+len(entrataC_IN[SING])+len(entrataC_OUT[SCOL])+len(entrataC_OUT[SORV])+len(entrataC_OUT[SING]) == 0),
             entrataC_IN[SCOL]):
             
+            clock.Receive(x.stamp, "entrataC_IN[SCOL]")
             persone_in_C[IN] += scolari
             scolaresche_in_C[IN]++
             persone_in_sala += scolari
-            x.ack <- 1
+            SendOn(clock, x.ack, 1, "ack")
 
         // -----------------------------
         // ENTRANCE: corridor direction OUT
@@ -157,15 +196,16 @@ func server() {
         //   - No school groups or single visitors in OUT corridor
         case x := <-when(
             scolaresche_in_C[IN] == 0 &&
-                (persone_in_C[IN]+persone_in_C[OUT]) < NC &&
+                (persone_in_C[IN]+persone_in_C[OUT]) < maxC &&
                 (sorveglianti_in_sala > 1 || persone_in_sala == 1) &&
                 (len(entrataC_OUT[SCOL])+len(entrataC_OUT[SING]) == 0),
             entrataC_OUT[SORV]):
             
+            clock.Receive(x.stamp, "entrataC_OUT[SORV]")
             persone_in_C[OUT]++
             persone_in_sala--
             sorveglianti_in_sala--
-            x.ack <- 1
+            SendOn(clock, x.ack, 1, "ack")
 
         // 5) A SINGLE VISITOR enters the corridor OUT
         // Conditions:
@@ -174,13 +214,14 @@ func server() {
         //   - No school groups waiting in the OUT corridor
         case x := <-when(
             scolaresche_in_C[IN] == 0 &&
-                (persone_in_C[IN]+persone_in_C[OUT]) < NC &&
+                (persone_in_C[IN]+persone_in_C[OUT]) < maxC &&
                 (len(entrataC_OUT[SCOL]) == 0),
             entrataC_OUT[SING]):
             
+            clock.Receive(x.stamp, "entrataC_OUT[SING]")
             persone_in_C[OUT]++
             persone_in_sala--
-            x.ack <- 1
+            SendOn(clock, x.ack, 1, "ack")
 
         // 6) A SCHOOL GROUP enters the corridor OUT
         // Conditions:
@@ -188,18 +229,20 @@ func server() {
         //   - Enough space in the corridor for 25 people
         case x := <-when(
             persone_in_C[IN] == 0 &&
-                (persone_in_C[IN]+persone_in_C[OUT])+scolari <= NC,
+                (persone_in_C[IN]+persone_in_C[OUT])+scolari <= maxC,
             entrataC_OUT[SCOL]):
             
+            clock.Receive(x.stamp, "entrataC_OUT[SCOL]")
             persone_in_C[OUT] += scolari
             scolaresche_in_C[OUT]++
             persone_in_sala -= scolari
-            x.ack <- 1
+            SendOn(clock, x.ack, 1, "ack")
 
         // -----------------------------
         // EXIT from the corridor (IN or OUT direction)
         // 7) A visitor or supervisor exiting from the IN corridor
         case x := <-uscitaC_IN:
+            clock.Receive(x.stamp, "uscitaC_IN")
             if x.tipo == SCOL {
                 persone_in_C[IN] -= scolari
                 scolaresche_in_C[IN]--
@@ -207,10 +250,11 @@ func server() {
                 // single visitor or supervisor
                 persone_in_C[IN]--
             }
-            x.ack <- 1
+            SendOn(clock, x.ack, 1, "ack")
 
         // 8) A visitor or supervisor exiting from the OUT corridor
         case x := <-uscitaC_OUT:
+            clock.Receive(x.stamp, "uscitaC_OUT")
             if x.tipo == SCOL {
                 persone_in_C[OUT] -= scolari
                 scolaresche_in_C[OUT]--
@@ -218,7 +262,48 @@ func server() {
                 // single visitor or supervisor
                 persone_in_C[OUT]--
             }
-            x.ack <- 1
+            SendOn(clock, x.ack, 1, "ack")
+
+        // -----------------------------
+        // 9) An admin command
+        case cmd := <-adminCommands:
+            switch cmd.Op {
+            case ADMIN_PAUSE, ADMIN_RESUME:
+                paused = cmd.Op == ADMIN_PAUSE
+                fmt.Printf("\n[server] admin: %s.\n", cmd.Op)
+                cmd.Reply("paused: %v", paused)
+            case ADMIN_SET:
+                switch cmd.Name {
+                case "N":
+                    if n, err := cmd.Capacity(N); err == nil {
+                        maxSala = n
+                        fmt.Printf("\n[server] admin: at most %d people in the hall (%d inside).\n", maxSala, persone_in_sala)
+                        cmd.Reply("N: %d, in the hall: %d", maxSala, persone_in_sala)
+                    }
+                case "NC":
+                    if n, err := cmd.Capacity(NC); err == nil {
+                        maxC = n
+                        fmt.Printf("\n[server] admin: at most %d people in the corridor (%d inside).\n", maxC, persone_in_C[IN]+persone_in_C[OUT])
+                        cmd.Reply("NC: %d, in the corridor: %d", maxC, persone_in_C[IN]+persone_in_C[OUT])
+                    }
+                case "MaxS":
+                    if n, err := cmd.Capacity(MaxS); err == nil {
+                        maxSorv = n
+                        fmt.Printf("\n[server] admin: at most %d supervisors in the hall (%d inside).\n", maxSorv, sorveglianti_in_sala)
+                        cmd.Reply("MaxS: %d, supervisors in the hall: %d", maxSorv, sorveglianti_in_sala)
+                    }
+                default:
+                    cmd.Fail("no capacity %s (N, NC, MaxS)", cmd.Name)
+                }
+            case ADMIN_OUT, ADMIN_IN:
+                // The places of the hall and of the corridor are only counted
+                cmd.Fail("nothing to take out of service in the museum: change the capacities with set N|NC|MaxS <n>")
+            default: // ADMIN_DUMP
+                cmd.Reply("paused: %v; hall: %d/%d, supervisors %d/%d; corridor: %d/%d, IN %d (%d school groups), OUT %d (%d school groups)",
+                    paused, persone_in_sala, maxSala, sorveglianti_in_sala, maxSorv,
+                    persone_in_C[IN]+persone_in_C[OUT], maxC, persone_in_C[IN], scolaresche_in_C[IN],
+                    persone_in_C[OUT], scolaresche_in_C[OUT])
+            }
 
         // -----------------------------
         // SERVER TERMINATION
@@ -231,46 +316,53 @@ func server() {
 }
 
 // GOROUTINE: Visitor (single or school group)
+// Started by main at the arrival time chosen by the workload.
 func visitatore(id int, tipo int) {
     // 'tt' is a random time used to simulate delays
     var tt int
     var r richiesta
 
-    // Random initialization delay
-    tt = rand.Intn(2) + 1
-    fmt.Printf("\nInitializing visitor %d of type %s in %d seconds\n", id, printTipo(tipo), tt)
-    time.Sleep(time.Duration(tt) * time.Second)
+    clock := NewClock(fmt.Sprintf("visitor %d", id))
+    clock.Printf("\nVisitor %d of type %s arrived\n", id, printTipo(tipo))
 
     // Prepare the request
-    r = richiesta{id, tipo, make(chan int, MAXBUFF)}
+    r = richiesta{id: id, tipo: tipo, ack: make(chan int, MAXBUFF)}
 
     // 1) Enter corridor IN
+    r.stamp = clock.Send("entrataC_IN")
     entrataC_IN[tipo] <- r
     <-r.ack
-    fmt.Printf("\n[Visitor %d, type %s] entering corridor in direction IN\n", id, printTipo(tipo))
+    ReceivedOn(clock, r.ack, "ack")
+    clock.Printf("\n[Visitor %d, type %s] entering corridor in direction IN\n", id, printTipo(tipo))
 
     // 2) Exit corridor IN
     tt = rand.Intn(2) + 1
     time.Sleep(time.Duration(tt) * time.Second)
+    r.stamp = clock.Send("uscitaC_IN")
     uscitaC_IN <- r
     <-r.ack
-    fmt.Printf("\n[Visitor %d, type %s] entered the hall\n", id, printTipo(tipo))
+    ReceivedOn(clock, r.ack, "ack")
+    clock.Printf("\n[Visitor %d, type %s] entered the hall\n", id, printTipo(tipo))
 
     // 3) Visit/stay inside the hall
     tt = rand.Intn(5) + 1
     time.Sleep(time.Duration(tt) * time.Second)
 
     // 4) Enter corridor OUT
+    r.stamp = clock.Send("entrataC_OUT")
     entrataC_OUT[tipo] <- r
     <-r.ack
-    fmt.Printf("\n[Visitor %d, type %s] entering corridor in direction OUT\n", id, printTipo(tipo))
+    ReceivedOn(clock, r.ack, "ack")
+    clock.Printf("\n[Visitor %d, type %s] entering corridor in direction OUT\n", id, printTipo(tipo))
 
     // 5) Exit corridor OUT
     tt = rand.Intn(2) + 1
     time.Sleep(time.Duration(tt) * time.Second)
+    r.stamp = clock.Send("uscitaC_OUT")
     uscitaC_OUT <- r
     <-r.ack
-    fmt.Printf("\n[Visitor %d, type %s] left the corridor in direction OUT and is going home...\n", id, printTipo(tipo))
+    ReceivedOn(clock, r.ack, "ack")
+    clock.Printf("\n[Visitor %d, type %s] left the corridor in direction OUT and is going home...\n", id, printTipo(tipo))
 
     // Signal that this goroutine is done
     done <- true
@@ -286,49 +378,58 @@ func sorvegliante(id int) {
     // so there is always a chance for at least one supervisor present in the hall.
     // 'volte' sets how many times they loop.
     volte := 2 * MAXPROC
+    clock := NewClock(fmt.Sprintf("supervisor %d", id))
 
     tt = rand.Intn(2) + 1
-    fmt.Printf("\nInitializing supervisor %d in %d seconds...\n", id, tt)
+    clock.Printf("\nInitializing supervisor %d in %d seconds...\n", id, tt)
     time.Sleep(time.Duration(tt) * time.Second)
 
-    r = richiesta{id, tipo, make(chan int, MAXBUFF)}
+    r = richiesta{id: id, tipo: tipo, ack: make(chan int, MAXBUFF)}
 
     for i := 0; i < volte; i++ {
         // 1) Enter corridor IN
+        r.stamp = clock.Send("entrataC_IN")
         entrataC_IN[tipo] <- r
         <-r.ack
-        fmt.Printf("\n[Supervisor %d] entered corridor IN\n", id)
+        ReceivedOn(clock, r.ack, "ack")
+        clock.Printf("\n[Supervisor %d] entered corridor IN\n", id)
 
         tt = rand.Intn(2) + 1
         time.Sleep(time.Duration(tt) * time.Second)
 
         // 2) Exit corridor IN
+        r.stamp = clock.Send("uscitaC_IN")
         uscitaC_IN <- r
         <-r.ack
-        fmt.Printf("\n[Supervisor %d] is now in the hall\n", id)
+        ReceivedOn(clock, r.ack, "ack")
+        clock.Printf("\n[Supervisor %d] is now in the hall\n", id)
 
         // 3) Supervision time in the hall
         tt = rand.Intn(5) + 1
         time.Sleep(time.Duration(tt) * time.Second)
 
         // 4) Enter corridor OUT
+        r.stamp = clock.Send("entrataC_OUT")
         entrataC_OUT[tipo] <- r
         <-r.ack
-        fmt.Printf("\n[Supervisor %d] entered corridor OUT\n", id)
+        ReceivedOn(clock, r.ack, "ack")
+        clock.Printf("\n[Supervisor %d] entered corridor OUT\n", id)
 
         tt = rand.Intn(2) + 1
         time.Sleep(time.Duration(tt) * time.Second)
 
         // 5) Exit corridor OUT
+        r.stamp = clock.Send("uscitaC_OUT")
         uscitaC_OUT <- r
         <-r.ack
-        fmt.Printf("\n[Supervisor %d] left the corridor OUT\n", id)
+        ReceivedOn(clock, r.ack, "ack")
+        clock.Printf("\n[Supervisor %d] left the corridor OUT\n", id)
 
         tt = rand.Intn(1) + 1
         time.Sleep(time.Duration(tt) * time.Second)
     }
 
-    fmt.Printf("\n[Supervisor %d] done and going home...\n", id)
+    clock.Printf("\n[Supervisor %d] done and going home...\n", id)
     done <- true
 }
 
@@ -354,11 +455,14 @@ func main() {
     fmt.Printf("\n[main] How many supervisors? (max %d)\n", MAXPROC)
     fmt.Scanf("%d", &sorveglianti)
 
-    // Initialize the corridor IN/OUT channels for each of the 3 entity types (SING, SCOL, SORV)
-    for i := 0; i < 3; i++ {
-        entrataC_IN[i] = make(chan richiesta, MAXBUFF)
-        entrataC_OUT[i] = make(chan richiesta, MAXBUFF)
-    }
+    // Initialize the channels (corridor IN/OUT for each of the 3 entity types: SING, SCOL, SORV)
+    initChannels(MAXBUFF)
+
+    // With -causal, the logical clocks of the messages go to a trace (see causal.go)
+    StartCausal()
+
+    // Admin commands for the museum, with -admin (see admin.go)
+    stopAdmin := StartAdminFromFlags()
 
     // Start the server goroutine
     go server()
@@ -368,15 +472,15 @@ func main() {
         go sorvegliante(i)
     }
 
-    // Create single visitor goroutines
-    for i := 0; i < singoli; i++ {
-        go visitatore(i, SING)
-    }
-
-    // Create school group goroutines
-    for i := 0; i < scolaresche; i++ {
-        go visitatore(i, SCOL)
+    // Create single visitor and school group goroutines at their arrival times
+    // (-arrivals and -mix flags, see workload.go). By default each one arrives
+    // after 1-2 seconds and the mix gives exactly the numbers asked above
+    mix := fmt.Sprintf("SING=%d,SCOL=%d", singoli, scolaresche)
+    if singoli+scolaresche == 0 {
+        mix = "SING=1" // no visitors: the mix is not used
     }
+    workload := WorkloadFromFlags([]string{"SING", "SCOL"}, "spread:max=2", mix)
+    workload.Spawn(singoli+scolaresche, visitatore)
 
     // Wait for all visitors and supervisors to finish
     for i := 0; i < (sorveglianti + singoli + scolaresche); i++ {
@@ -389,6 +493,13 @@ func main() {
     // Signal the server to terminate
     termina <- true
     <-done  // wait for the server to confirm it has ended
+    stopAdmin()
 
     fmt.Println()
+    StopCausal()
+
+    if err := CheckLeaks(); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
 }
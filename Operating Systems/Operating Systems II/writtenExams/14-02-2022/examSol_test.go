@@ -0,0 +1,292 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR server() (MUSEUM HALL AND CORRIDOR)
+//
+// The tests feed server() scripted visits of single visitors, school groups
+// and supervisors under a virtual clock, and check who is let into the
+// corridor, in which order, and that the hall and the corridor are empty at
+// the end. TestAdmin sends the server the commands of admin.go.
+//
+// A synthetic supervisor walks into the hall at the start and stays there for
+// the whole benchmark, so visitors are always allowed in. Synthetic visitors
+// then repeat the cycle of visitatore() without any sleep: enter the corridor
+// IN, reach the hall, enter the corridor OUT, leave. One visitor in five is a
+// school group (scolari people), the others are single visitors. At the end
+// the supervisor leaves and the server is stopped.
+//
+// The benchmark reports:
+//   - grants/s:        visitors (or school groups) admitted in the corridor IN per second;
+//   - p50-ns, p99-ns:  latency from the entrataC_IN request to its ack;
+//   - allocs/op:       allocations per grant.
+// It runs twice: with unbuffered request channels and with buffered ones
+// (MAXBUFF slots).
+//
+// Run with:
+//     go test -race examSol.go workload.go leaks.go causal.go admin.go servertest_test.go examSol_test.go
+//     go test -run XXX -bench . examSol.go workload.go leaks.go causal.go admin.go servertest_test.go examSol_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = 10 // Synthetic visitors: 8 single visitors and 2 school groups
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+const (
+	opSing = iota // entrataC_IN[SING] <- r
+	opScol        // entrataC_IN[SCOL] <- r
+	opSorv        // entrataC_IN[SORV] <- r
+	opHall        // uscitaC_IN <- r
+	opOut         // entrataC_OUT[tipo] <- r
+	opGone        // uscitaC_OUT <- r
+)
+
+var opNames = []string{"single visitor in", "school group in", "supervisor in", "hall reached", "corridor out", "gone"}
+
+// museum runs the steps of a test, remembering the type of every visitor.
+type museum struct {
+	*script[int]
+	tipo map[int]int
+}
+
+func (m *museum) do(st step) {
+	if st.op <= opSorv {
+		m.tipo[st.id] = st.op - opSing
+	}
+	r := richiesta{id: st.id, tipo: m.tipo[st.id], ack: make(chan int)}
+	var c chan richiesta
+	switch st.op {
+	case opSing, opScol, opSorv:
+		c = entrataC_IN[r.tipo]
+	case opHall:
+		c = uscitaC_IN
+	case opOut:
+		c = entrataC_OUT[r.tipo]
+	case opGone:
+		c = uscitaC_OUT
+	}
+	m.call(r.id, r.ack, func() { c <- r })
+}
+
+// visit returns the steps taking the visitors through the hall and out, one
+// phase at a time, with nobody waiting.
+func visit(ids ...int) []step {
+	var steps []step
+	for _, op := range []int{opHall, opOut, opGone} {
+		for _, id := range ids {
+			steps = append(steps, step{op, id, []int{id}})
+		}
+	}
+	return steps
+}
+
+func TestServer(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"visitors need a supervisor in the hall", slices.Concat(
+			[]step{
+				{opSing, 0, nil},
+				{opSorv, 10, []int{10, 0}},
+			},
+			visit(0, 10),
+		)},
+		{"the last supervisor leaves after the visitors", []step{
+			{opSorv, 10, []int{10}},
+			{opSing, 0, []int{0}},
+			{opHall, 10, []int{10}},
+			{opHall, 0, []int{0}},
+			{opOut, 10, nil},
+			{opOut, 0, []int{0, 10}},
+			{opGone, 0, []int{0}},
+			{opGone, 10, []int{10}},
+		}},
+		{"at most MaxS supervisors in the hall", []step{
+			{opSorv, 10, []int{10}},
+			{opSorv, 11, []int{11}},
+			{opSorv, 12, []int{12}},
+			{opSorv, 13, []int{13}},
+			{opSorv, 14, nil},
+			{opHall, 10, []int{10}},
+			{opOut, 10, []int{10, 14}},
+			{opGone, 10, []int{10}},
+			{opHall, 11, []int{11}},
+			{opHall, 12, []int{12}},
+			{opHall, 13, []int{13}},
+			{opHall, 14, []int{14}},
+			{opOut, 11, []int{11}},
+			{opOut, 12, []int{12}},
+			{opOut, 13, []int{13}},
+			{opOut, 14, []int{14}},
+			{opGone, 11, []int{11}},
+			{opGone, 12, []int{12}},
+			{opGone, 13, []int{13}},
+			{opGone, 14, []int{14}},
+		}},
+		{"two school groups do not fit in the hall", []step{
+			{opSorv, 10, []int{10}},
+			{opHall, 10, []int{10}},
+			{opScol, 0, []int{0}},
+			{opHall, 0, []int{0}},
+			{opScol, 1, nil},
+			{opOut, 0, []int{0}},
+			{opGone, 0, []int{0, 1}},
+			{opHall, 1, []int{1}},
+			{opOut, 1, []int{1}},
+			{opGone, 1, []int{1}},
+			{opOut, 10, []int{10}},
+			{opGone, 10, []int{10}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				initChannels(MAXBUFF)
+				go server()
+				m := &museum{newScript[int](t), map[int]int{}}
+				for _, st := range tt.steps {
+					m.do(st)
+					m.expect(st.String(), st.want)
+				}
+				m.finish()
+				m.checkEmpty()
+				termina <- true
+				<-done
+			})
+		})
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		initChannels(MAXBUFF)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server()
+		m := &museum{newScript[int](t), map[int]int{}}
+		play := func(steps ...step) {
+			t.Helper()
+			for _, st := range steps {
+				m.do(st)
+				m.expect(st.String(), st.want)
+			}
+		}
+
+		// One supervisor in the hall: supervisor 11 waits, until there are MaxS again
+		m.admin(Admin, "set MaxS 1", "MaxS: 1, supervisors in the hall: 0", nil)
+		play(step{opSorv, 10, []int{10}}, step{opSorv, 11, nil})
+		m.admin(Admin, "dump", "paused: false; hall: 1/40, supervisors 1/1; "+
+			"corridor: 1/30, IN 1 (0 school groups), OUT 0 (0 school groups)", nil)
+		m.admin(Admin, "set MaxS 4", "MaxS: 4, supervisors in the hall: 1", []int{11})
+		play(step{opHall, 10, []int{10}}, step{opHall, 11, []int{11}})
+
+		// Paused: the visitors wait for resume
+		m.admin(Admin, "pause", "paused: true", nil)
+		play(step{opSing, 0, nil})
+		m.admin(Admin, "resume", "paused: false", []int{0})
+		play(step{opHall, 0, []int{0}})
+
+		// Three people in the hall at most: visitor 1 gets in when visitor 0 leaves it
+		m.admin(Admin, "set N 3", "N: 3, in the hall: 3", nil)
+		play(step{opSing, 1, nil}, step{opOut, 0, []int{0, 1}})
+		m.admin(Admin, "set N 40", "N: 40, in the hall: 3", nil)
+
+		// Two people in the corridor at most: visitor 2 gets in when visitor 0 leaves it
+		m.admin(Admin, "set NC 2", "NC: 2, in the corridor: 2", nil)
+		play(step{opSing, 2, nil}, step{opGone, 0, []int{0, 2}})
+		m.admin(Admin, "set NC 30", "NC: 30, in the corridor: 2", nil)
+
+		m.admin(Admin, "set N 41", "error: N cannot be above 40", nil)
+		m.admin(Admin, "set MAX 1", "error: no capacity MAX (N, NC, MaxS)", nil)
+		m.admin(Admin, "out place 0",
+			"error: nothing to take out of service in the museum: change the capacities with set N|NC|MaxS <n>", nil)
+		play(visit(1, 2)...)
+		play(
+			step{opOut, 10, []int{10}},
+			step{opGone, 10, []int{10}},
+			step{opOut, 11, []int{11}},
+			step{opGone, 11, []int{11}},
+		)
+		m.finish()
+		m.checkEmpty()
+		termina <- true
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// checkEmpty verifies that the hall and the corridor are empty: a school group
+// fits in with a supervisor, who can then leave alone after it.
+func (m *museum) checkEmpty() {
+	m.t.Helper()
+	for _, st := range slices.Concat(
+		[]step{{opSorv, 90, []int{90}}, {opHall, 90, []int{90}}, {opScol, 91, []int{91}}},
+		visit(91),
+		[]step{{opOut, 90, []int{90}}, {opGone, 90, []int{90}}},
+	) {
+		m.do(st)
+		m.expect("final state: "+st.String(), st.want)
+	}
+	m.finish()
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+// pass sends r on c and waits for the server's ack.
+func pass(c chan richiesta, r richiesta) {
+	c <- r
+	<-r.ack
+}
+
+func BenchmarkServer(b *testing.B) {
+	silence(b)
+	for _, m := range bufferModes(MAXBUFF) {
+		b.Run(m.name, func(b *testing.B) {
+			initChannels(m.size)
+			go server()
+			supervisor := richiesta{id: 0, tipo: SORV, ack: make(chan int, MAXBUFF)}
+			pass(entrataC_IN[SORV], supervisor)
+			pass(uscitaC_IN, supervisor)
+			visitors := make([]richiesta, loadClients)
+			for id := range visitors {
+				tipo := SING
+				if id%5 == 4 {
+					tipo = SCOL
+				}
+				visitors[id] = richiesta{id: id, tipo: tipo, ack: make(chan int, MAXBUFF)}
+			}
+
+			measure(b, loadClients, func(id int) time.Duration {
+				r := visitors[id]
+				t := time.Now()
+				pass(entrataC_IN[r.tipo], r)
+				d := time.Since(t)
+				pass(uscitaC_IN, r)
+				pass(entrataC_OUT[r.tipo], r)
+				pass(uscitaC_OUT, r)
+				return d
+			})
+
+			pass(entrataC_OUT[SORV], supervisor)
+			pass(uscitaC_OUT, supervisor)
+			termina <- true
+			<-done
+		})
+	}
+}
@@ -0,0 +1 @@
+../../workload/workload.go
\ No newline at end of file
@@ -0,0 +1,637 @@
+// -----------------------------------------------------------------------------------
+// CHECKPOINTS OF negozio() AND RESUME AFTER A RESTART
+//
+// With -checkpoint dir negozio() writes its state to dir every -checkpoint-every:
+// the counters, the Commesso record of every assistant (with the exits waiting
+// for the clients of the assistant, the only requests negozio() keeps pending),
+// the masks and how many requests of every client, assistant and of the
+// supplier it has applied. The files are negozio-<n>.json, written to a
+// temporary file and renamed, and the last KEEP_CHECKPOINTS are kept. The
+// pause and the capacity set by admin commands (see admin.go) are saved too.
+//
+// A restarted negozio() resumes from the latest checkpoint it can read, so what
+// it did after that checkpoint is lost. The clients must not have seen it: an
+// ack leaves only once the state it reports is in a checkpoint (the acks wait
+// in an outbox, released after every checkpoint). A client whose request got
+// no ack does not know whether it was applied, and the shop tells it:
+//   - every client, assistant and the supplier numbers its requests from 1
+//     (Richiesta.n). A client or an assistant with an odd number of requests
+//     applied is in the shop, with an even number it is out (or an assistant
+//     waits to leave);
+//   - after a restart each of them sends on riconnessioni who it is and the
+//     number of its request waiting for an ack; negozio() answers true if it
+//     has applied it, false if it must be sent again. The channel is not
+//     guarded, unlike the entries, so the answer never waits;
+//   - an assistant whose exit waits for its clients gets the ack of the exit
+//     when they have left, as before the restart;
+//   - a copy of a request still in a channel when the request is sent again is
+//     recognized by its number, not above the number of requests applied, and
+//     answered without being applied again;
+//   - the answer of an admin command waits in the outbox too. If negozio()
+//     restarts before it leaves, the command fails and must be sent again.
+//
+// A restart can be tried in two ways:
+//   - -crash d stops negozio() every d without answering anything and starts
+//     it again on the same channels: the clients keep running and reconnect;
+//   - -die d kills the process after d. Run it again with -resume, and the
+//     clients, the assistants and the supplier start where the latest
+//     checkpoint says they are: a client that has left terminates at once, one
+//     in the shop goes on to leave it.
+//
+// Run with:
+//     go run examSol.go workload.go fairness.go leaks.go checkpoint.go admin.go -checkpoint /tmp/negozio -crash 5s
+//     go run examSol.go workload.go fairness.go leaks.go checkpoint.go admin.go -checkpoint /tmp/negozio -die 10s
+//     go run examSol.go workload.go fairness.go leaks.go checkpoint.go admin.go -checkpoint /tmp/negozio -resume
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+var checkpointFlag = flag.String("checkpoint", "", "directory of the checkpoints of negozio() (none if empty)")
+var checkpointEveryFlag = flag.Duration("checkpoint-every", 500*time.Millisecond, "time between two checkpoints of negozio()")
+var resumeFlag = flag.Bool("resume", false, "resume from the latest checkpoint in the -checkpoint directory")
+var crashFlag = flag.Duration("crash", 0, "restart negozio() from its latest checkpoint at this `interval` (0: never)")
+var dieFlag = flag.Duration("die", 0, "kill the process after this `time`, to run it again with -resume (0: never)")
+
+// KEEP_CHECKPOINTS is how many checkpoint files are kept.
+const KEEP_CHECKPOINTS = 3
+
+// The checkpoints of negozio(), nil without -checkpoint
+var checkpoints *Checkpointer
+
+// The state of the shop the process resumed from, with -resume
+var ripresa *statoNegozio
+
+// ============================================================
+//                      STATE OF THE SHOP
+// ============================================================
+
+// Who sends the requests
+const (
+	CHI_CLIENTE = iota
+	CHI_COMMESSO
+	CHI_FORNITORE
+)
+
+// Richieste counts the requests applied by negozio(), by who sent them. A
+// client or an assistant is in the shop after an odd number of requests.
+type Richieste struct {
+	Clienti  map[int]int // by client id
+	Commessi []int       // by assistant id (the exit waiting for the clients counts)
+	Consegne int         // deliveries of the supplier
+}
+
+// nuoveRichieste returns the counters of a shop that has applied nothing.
+func nuoveRichieste() Richieste {
+	return Richieste{Clienti: map[int]int{}, Commessi: make([]int, N_COMMESSI)}
+}
+
+// di returns how many requests of chi id have been applied.
+func (r *Richieste) di(chi, id int) int {
+	switch chi {
+	case CHI_CLIENTE:
+		return r.Clienti[id]
+	case CHI_COMMESSO:
+		return r.Commessi[id]
+	}
+	return r.Consegne
+}
+
+// dentro reports whether a client or an assistant with n requests applied is
+// in the shop.
+func dentro(n int) bool {
+	return n%2 == 1
+}
+
+// copia reports whether request n is a copy of a request already applied, for
+// a sender with fatte requests applied.
+func copia(n, fatte int) bool {
+	return n > 0 && n <= fatte
+}
+
+// commessoSalvato is the Commesso record of a checkpoint: the ack of an exit
+// waiting for the clients is not saved, the assistant sends it again.
+type commessoSalvato struct {
+	Dentro                 bool
+	VuoleUscire            bool
+	ClientiAssegnati       [3]int
+	NumeroClientiAssegnati int
+}
+
+// statoNegozio is a checkpoint of negozio().
+type statoNegozio struct {
+	Numero         int // of the checkpoint, from 1
+	Ora            time.Time
+	ClientiDentro  int
+	CommessiDentro int
+	CommessiLiberi int
+	Mascherine     int
+	Paused         bool // by an admin command
+	Max            int  // capacity of the shop, MAX unless set by an admin command
+	Commessi       []commessoSalvato
+	Richieste      Richieste
+}
+
+// fotografia returns the state of negozio() from its variables.
+func fotografia(clientiDentro, commessiDentro, commessiLiberi, mascherine int, paused bool, max int, commessi []Commesso, richieste Richieste) *statoNegozio {
+	s := &statoNegozio{
+		ClientiDentro:  clientiDentro,
+		CommessiDentro: commessiDentro,
+		CommessiLiberi: commessiLiberi,
+		Mascherine:     mascherine,
+		Paused:         paused,
+		Max:            max,
+		Richieste:      richieste,
+	}
+	for _, c := range commessi {
+		s.Commessi = append(s.Commessi, commessoSalvato{c.dentro, c.vuoleUscire, c.clientiAssegnati, c.numeroClientiAssegnati})
+	}
+	return s
+}
+
+// ripristina sets the records of the assistants from the checkpoint.
+func (s *statoNegozio) ripristina(commessi []Commesso) {
+	for i, c := range s.Commessi {
+		commessi[i] = Commesso{
+			dentro:                 c.Dentro,
+			vuoleUscire:            c.VuoleUscire,
+			clientiAssegnati:       c.ClientiAssegnati,
+			numeroClientiAssegnati: c.NumeroClientiAssegnati,
+		}
+	}
+}
+
+// fatte returns how many requests of chi id the shop had applied at the
+// checkpoint, 0 without one.
+func (s *statoNegozio) fatte(chi, id int) int {
+	if s == nil {
+		return 0
+	}
+	return s.Richieste.di(chi, id)
+}
+
+// uscendo reports whether assistant id was waiting for its clients to leave at
+// the checkpoint.
+func (s *statoNegozio) uscendo(id int) bool {
+	return s != nil && s.Commessi[id].VuoleUscire
+}
+
+// check verifies the invariants of the shop in the checkpoint.
+func (s *statoNegozio) check() error {
+	if len(s.Commessi) != N_COMMESSI || len(s.Richieste.Commessi) != N_COMMESSI {
+		return fmt.Errorf("%d assistants, want %d", len(s.Commessi), N_COMMESSI)
+	}
+	commessiDentro, commessiLiberi, assegnati := 0, 0, 0
+	for i, c := range s.Commessi {
+		n := 0
+		for _, id := range c.ClientiAssegnati {
+			if id >= 0 {
+				n++
+				if !dentro(s.Richieste.Clienti[id]) {
+					return fmt.Errorf("assistant %d supervises client %d, which is not in the shop", i, id)
+				}
+			}
+		}
+		switch {
+		case n != c.NumeroClientiAssegnati:
+			return fmt.Errorf("assistant %d supervises %d clients, counted %d", i, n, c.NumeroClientiAssegnati)
+		case c.Dentro != (dentro(s.Richieste.Commessi[i]) || c.VuoleUscire):
+			return fmt.Errorf("assistant %d in the shop %v after %d requests", i, c.Dentro, s.Richieste.Commessi[i])
+		case !c.Dentro && n > 0:
+			return fmt.Errorf("assistant %d is out with %d clients", i, n)
+		}
+		if c.Dentro {
+			commessiDentro++
+			if n < 3 {
+				commessiLiberi++
+			}
+		}
+		assegnati += n
+	}
+	clienti := 0
+	for _, n := range s.Richieste.Clienti {
+		if dentro(n) {
+			clienti++
+		}
+	}
+	switch {
+	case commessiDentro != s.CommessiDentro || commessiLiberi != s.CommessiLiberi:
+		return fmt.Errorf("%d assistants inside and %d free, counted %d and %d", commessiDentro, commessiLiberi, s.CommessiDentro, s.CommessiLiberi)
+	case clienti != s.ClientiDentro || assegnati != clienti:
+		return fmt.Errorf("%d clients inside and %d supervised, counted %d", clienti, assegnati, s.ClientiDentro)
+	case s.ClientiDentro+s.CommessiDentro > MAX:
+		return fmt.Errorf("%d clients and %d assistants inside, more than MAX", s.ClientiDentro, s.CommessiDentro)
+	case s.Mascherine < 0:
+		return fmt.Errorf("%d masks", s.Mascherine)
+	}
+	return nil
+}
+
+// ============================================================
+//                         CHECKPOINTS
+// ============================================================
+
+// Checkpointer writes the checkpoints of negozio() to a directory and reads
+// them back.
+type Checkpointer struct {
+	dir    string
+	ticker *time.Ticker
+	resume bool   // the next negozio() resumes from the latest checkpoint
+	numero int    // of the last checkpoint written
+	last   []byte // the state in the last checkpoint, not written again
+}
+
+// NewCheckpointer writes checkpoints to dir every interval, numbered after the
+// files already there, so that the latest is always the last written. With
+// resume the first negozio() resumes from the latest checkpoint; after a
+// restart every negozio() does.
+func NewCheckpointer(dir string, every time.Duration, resume bool) (*Checkpointer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &Checkpointer{dir: dir, resume: resume}
+	files, err := c.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(file), "negozio-%d.json", &n); err == nil {
+			c.numero = max(c.numero, n)
+		}
+	}
+	c.ticker = time.NewTicker(every)
+	return c, nil
+}
+
+// CheckpointerFromFlags returns the checkpoints of -checkpoint, or nil.
+func CheckpointerFromFlags() *Checkpointer {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	if *checkpointFlag == "" {
+		if *resumeFlag || *crashFlag > 0 {
+			fmt.Fprintln(os.Stderr, "checkpoint: -resume and -crash need -checkpoint")
+			os.Exit(2)
+		}
+		return nil
+	}
+	c, err := NewCheckpointer(*checkpointFlag, *checkpointEveryFlag, *resumeFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "checkpoint:", err)
+		os.Exit(2)
+	}
+	return c
+}
+
+// Due fires when a checkpoint is due; it never fires on a nil Checkpointer.
+func (c *Checkpointer) Due() <-chan time.Time {
+	if c == nil {
+		return nil
+	}
+	return c.ticker.C
+}
+
+// Stop stops the timer of the checkpoints.
+func (c *Checkpointer) Stop() {
+	if c != nil {
+		c.ticker.Stop()
+	}
+}
+
+// path returns the file of checkpoint n.
+func (c *Checkpointer) path(n int) string {
+	return filepath.Join(c.dir, fmt.Sprintf("negozio-%06d.json", n))
+}
+
+// Save writes s as the next checkpoint, unless nothing changed since the last
+// one, and removes the old ones. It does nothing on a nil Checkpointer.
+func (c *Checkpointer) Save(s *statoNegozio) error {
+	if c == nil {
+		return nil
+	}
+	state, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if slices.Equal(state, c.last) {
+		return nil
+	}
+	s.Numero, s.Ora = c.numero+1, time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path(s.Numero) + ".tmp"
+	f, err := os.Create(tmp)
+	if err == nil {
+		_, err = f.Write(data)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, c.path(s.Numero))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	c.numero, c.last = s.Numero, state
+	os.Remove(c.path(s.Numero - KEEP_CHECKPOINTS))
+	return nil
+}
+
+// files returns the checkpoint files, the oldest first.
+func (c *Checkpointer) files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(c.dir, "negozio-*.json"))
+	slices.Sort(files) // the numbers have the same number of digits
+	return files, err
+}
+
+// Latest returns the latest checkpoint that can be read and keeps the
+// invariants of the shop, or nil if there is none.
+func (c *Checkpointer) Latest() (*statoNegozio, error) {
+	files, err := c.files()
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, file := range slices.Backward(files) {
+		data, err := os.ReadFile(file)
+		s := &statoNegozio{}
+		if err == nil {
+			err = json.Unmarshal(data, s)
+		}
+		if err == nil {
+			err = s.check()
+		}
+		if err == nil {
+			return s, errors.Join(errs...)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(file), err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Resume returns the state a starting negozio() resumes from: the latest
+// checkpoint after a restart or with -resume, nil otherwise. Later checkpoints
+// are numbered after it.
+func (c *Checkpointer) Resume() *statoNegozio {
+	if c == nil {
+		return nil
+	}
+	resume := c.resume
+	c.resume = true
+	if !resume {
+		return nil
+	}
+	s, err := c.Latest()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "checkpoint: skipped:", err)
+	}
+	if s == nil {
+		return nil
+	}
+	c.numero = max(c.numero, s.Numero)
+	c.last = nil
+	return s
+}
+
+// outbox holds the acks of negozio(), and the answers of the admin commands,
+// until a checkpoint contains the state they report. Without checkpoints it
+// sends them at once.
+type outbox struct {
+	held    []heldAck
+	answers []heldAnswer
+}
+
+type heldAck struct {
+	ack chan bool
+	v   bool
+}
+
+type heldAnswer struct {
+	cmd  AdminCommand
+	text string
+}
+
+// reply sends v on ack, now or after the next checkpoint; a nil ack (a client
+// leaving without waiting for it) is not answered.
+func (o *outbox) reply(ack chan bool, v bool) {
+	switch {
+	case ack == nil:
+	case checkpoints == nil:
+		ack <- v
+	default:
+		o.held = append(o.held, heldAck{ack, v})
+	}
+}
+
+// answer answers cmd, now or after the next checkpoint.
+func (o *outbox) answer(cmd AdminCommand, format string, args ...any) {
+	if checkpoints == nil {
+		cmd.Reply(format, args...)
+		return
+	}
+	o.answers = append(o.answers, heldAnswer{cmd, fmt.Sprintf(format, args...)})
+}
+
+// checkpoint saves s and then sends the acks and the answers held; if s cannot
+// be saved they wait for the next checkpoint.
+func (o *outbox) checkpoint(s *statoNegozio) {
+	if err := checkpoints.Save(s); err != nil {
+		fmt.Fprintln(os.Stderr, "checkpoint:", err)
+		return
+	}
+	for _, h := range o.held {
+		h.ack <- h.v
+	}
+	o.held = o.held[:0]
+	for _, a := range o.answers {
+		a.cmd.Reply("%s", a.text)
+	}
+	o.answers = o.answers[:0]
+}
+
+// crash fails the admin commands whose answers are held: the restarted
+// negozio() resumes from a checkpoint without them. The acks are lost, their
+// senders reconnect.
+func (o *outbox) crash() {
+	for _, a := range o.answers {
+		a.cmd.Fail("the shop restarted before saving it: send the command again")
+	}
+}
+
+// ============================================================
+//                    RESTARTS AND RECONNECTIONS
+// ============================================================
+
+// Riconnessione asks a restarted negozio() whether the request ric of chi has
+// been applied.
+type Riconnessione struct {
+	chi int
+	ric Richiesta
+}
+
+// Channels of the restarts, made by StartCrashes: nil, negozio() is never
+// restarted
+var riconnessioni chan Riconnessione
+var crolla chan bool
+
+// restarts is closed and made again at every restart of negozio().
+var restarts struct {
+	mu sync.Mutex
+	c  chan struct{}
+	n  int
+}
+
+// restarted returns a channel closed at the next restart.
+func restarted() chan struct{} {
+	restarts.mu.Lock()
+	defer restarts.mu.Unlock()
+	if restarts.c == nil {
+		restarts.c = make(chan struct{})
+	}
+	return restarts.c
+}
+
+// StartCrashes makes the channels of the restarts, calls start to start
+// negozio() and, every interval, stops it and calls start again. It returns a function that
+// stops the crashes and returns how many there were.
+func StartCrashes(every time.Duration, start func()) (stop func() int) {
+	riconnessioni = make(chan Riconnessione, MAXBUFF)
+	crolla = make(chan bool)
+	start()
+	quit, done := make(chan bool), make(chan bool)
+	go func() {
+		defer close(done)
+		if every <= 0 {
+			<-quit
+			return
+		}
+		for {
+			select {
+			case <-quit:
+				return
+			case <-time.After(every):
+			}
+			crolla <- true
+			start()
+			restarts.mu.Lock()
+			if restarts.c != nil {
+				close(restarts.c)
+			}
+			restarts.c = make(chan struct{})
+			restarts.n++
+			restarts.mu.Unlock()
+		}
+	}()
+	return func() int {
+		close(quit)
+		<-done
+		restarts.mu.Lock()
+		defer restarts.mu.Unlock()
+		n := restarts.n
+		restarts.c, restarts.n = nil, 0
+		return n
+	}
+}
+
+// call sends ric, request n of chi, on c and waits for its ack, if ric has an
+// ack channel. With -checkpoint every request gets a new ack channel, and if
+// negozio() restarts meanwhile the request is sent again, unless negozio()
+// says it has applied it.
+func call(chi, n int, c chan Richiesta, ric Richiesta) {
+	if checkpoints == nil {
+		c <- ric
+		if ric.ack != nil {
+			<-ric.ack
+		}
+		return
+	}
+	ric.ack, ric.n = make(chan bool, MAXBUFF), n
+	for {
+		restart := restarted()
+		select {
+		case c <- ric:
+		case <-restart:
+			if reconnect(chi, ric) {
+				return
+			}
+			continue
+		}
+		select {
+		case ok := <-ric.ack:
+			if ok {
+				return
+			}
+		case <-restart:
+			if reconnect(chi, ric) {
+				return
+			}
+		}
+	}
+}
+
+// deliver makes delivery n of the supplier on deposita, and is sent again like
+// the requests of call.
+func deliver(n int, deposita chan bool) {
+	if checkpoints == nil {
+		deposita <- true
+		<-deposita
+		return
+	}
+	ric := Richiesta{ack: make(chan bool, MAXBUFF), n: n}
+	for {
+		restart := restarted()
+		select {
+		case deposita <- true:
+		case <-restart:
+			if reconnect(CHI_FORNITORE, ric) {
+				return
+			}
+			continue
+		}
+		select {
+		case <-deposita:
+			return
+		case <-restart:
+			if reconnect(CHI_FORNITORE, ric) {
+				return
+			}
+		}
+	}
+}
+
+// reconnect asks the restarted negozio() whether the request ric of chi has
+// been applied; if negozio() restarts again before answering, it asks again.
+func reconnect(chi int, ric Richiesta) bool {
+	for {
+		restart := restarted()
+		select {
+		case riconnessioni <- Riconnessione{chi, ric}:
+		case <-restart:
+			continue
+		}
+		select {
+		case ok := <-ric.ack:
+			return ok
+		case <-restart:
+		}
+	}
+}
@@ -32,7 +32,8 @@
 //   1) Sleeps a random time, then tries to enter the shop (sending a request on
 //      either `entraClienteAbituale` or `entraClienteOccasionale`).
 //   2) Waits for acknowledgment from the shop (the 'negozio' goroutine).
-//   3) Simulates staying inside (sleep), then sends an exit request to `esciCliente`.
+//   3) Simulates staying inside (sleep), then sends an exit request to `esciCliente`
+//      (it waits for its ack only with -checkpoint).
 //   4) Signals its own termination.
 //
 // Flow of an assistant goroutine (commesso):
@@ -46,7 +47,7 @@
 //   1) Repeatedly sleeps a random time, then attempts to deliver a batch of masks
 //      by sending `true` on 'deposita'.
 //   2) Waits for the shop to consume that message and respond on the same channel
-//      (so effectively 'deposita' is used for both signal and ack).
+//      (so effectively 'deposita' is used for both signal and ack; see deliver).
 //   3) If the main program sends a termination signal (through 'terminaFornitore'),
 //      the supplier finishes.
 //
@@ -56,6 +57,13 @@
 // many are inside the shop, and so forth. The shop ends when the main function
 // sends a termination signal ('terminaNegozio').
 //
+// With -checkpoint the shop writes its state to disk periodically, and a
+// restarted shop (-crash, or a new process with -resume) resumes from the latest
+// checkpoint: the requests are sent through call(), which sends again the ones
+// the restart lost (see checkpoint.go).
+//
+// Run with:
+//     go run examSol.go workload.go fairness.go leaks.go checkpoint.go admin.go
 // -----------------------------------------------------------------------------------
 
 package main
@@ -63,6 +71,7 @@ package main
 import (
     "fmt"
     "math/rand"
+    "os"
     "time"
 )
 
@@ -80,12 +89,18 @@ const OCCASIONALE int = 1
 // An array to print the client's type in a human-readable form.
 var tipoClienteStr [2]string = [2]string{"ABITUALE", "OCCASIONALE"}
 
+// Waits of the clients to enter, by type, reported at the end (see fairness.go).
+var fairness *Fairness
+
 // Richiesta is used by both clients and assistants to request entry/exit.
 // 'id' is the ID (unique to each goroutine).
 // 'ack' is a channel on which the shop server (negozio) sends a boolean ack.
+// 'n' numbers the requests of the client or assistant from 1 with -checkpoint,
+// so that a request sent again is not applied twice (0: not numbered).
 type Richiesta struct {
     id  int
     ack chan bool
+    n   int
 }
 
 // Commesso represents the state of a shop assistant:
@@ -111,6 +126,12 @@ func whenRichiesta(b bool, c chan Richiesta) chan Richiesta {
     return c
 }
 
+// commessoValido reports whether id is one of the N_COMMESSI assistants: the
+// shop refuses the requests of any other id with a false ack.
+func commessoValido(id int) bool {
+    return id >= 0 && id < N_COMMESSI
+}
+
 // Helper function for conditional select on a channel of int.
 func whenInt(b bool, c chan int) chan int {
     if !b {
@@ -127,27 +148,35 @@ func sleepRandTime(timeLimit int) {
 }
 
 // GOROUTINE: Client (either ABITUALE or OCCASIONALE)
-func cliente(id int, tipo int, entra chan Richiesta, esci chan int, termina chan bool) {
+// Started by main at the arrival time chosen by the workload.
+func cliente(id int, tipo int, entra chan Richiesta, esci chan Richiesta, termina chan bool) {
     // Prepare a request
     var ric Richiesta
     ric.id = id
     ric.ack = make(chan bool, MAXBUFF)
 
-    // Simulate a random initialization time
-    sleepRandTime(5)
-    fmt.Printf("[CLIENT %s %d] I want to enter the shop...\n", tipoClienteStr[tipo], id)
+    // Requests the shop had applied at the checkpoint the process resumed from
+    // (-resume, see checkpoint.go): 1 if the client is inside, 2 if it has left
+    fatte := ripresa.fatte(CHI_CLIENTE, id)
 
-    // Send a request to enter
-    entra <- ric
-    <-ric.ack
-    fmt.Printf("[CLIENT %s %d] I have entered the shop...\n", tipoClienteStr[tipo], id)
+    if fatte < 1 {
+        fmt.Printf("[CLIENT %s %d] I want to enter the shop...\n", tipoClienteStr[tipo], id)
 
-    // Simulate shopping / being inside
-    sleepRandTime(7)
+        // Send a request to enter
+        ticket := fairness.Request(id, tipo)
+        call(CHI_CLIENTE, 1, entra, ric)
+        fairness.Served(ticket)
+        fmt.Printf("[CLIENT %s %d] I have entered the shop...\n", tipoClienteStr[tipo], id)
+    }
 
-    // Now exit
-    esci <- id
-    fmt.Printf("[CLIENT %s %d] I have left the shop...\n", tipoClienteStr[tipo], id)
+    if fatte < 2 {
+        // Simulate shopping / being inside
+        sleepRandTime(7)
+
+        // Now exit
+        call(CHI_CLIENTE, 2, esci, Richiesta{id: id})
+        fmt.Printf("[CLIENT %s %d] I have left the shop...\n", tipoClienteStr[tipo], id)
+    }
 
     // Signal that this client has finished
     fmt.Printf("[CLIENT %s %d] Terminating...\n", tipoClienteStr[tipo], id)
@@ -162,20 +191,30 @@ func commesso(id int, entra chan Richiesta, esci chan Richiesta, termina chan bo
     ric.id = id
     ric.ack = make(chan bool, MAXBUFF)
 
+    // Requests sent, odd while inside: with -resume, those the shop had applied
+    // at the checkpoint (see checkpoint.go). An exit that was waiting for the
+    // clients is sent again.
+    n := ripresa.fatte(CHI_COMMESSO, id)
+    if ripresa.uscendo(id) {
+        n--
+    }
+
     for {
-        sleepRandTime(5)
-        fmt.Printf("[ASSISTANT %d] I want to enter the shop...\n", id)
+        if !dentro(n) {
+            sleepRandTime(5)
+            fmt.Printf("[ASSISTANT %d] I want to enter the shop...\n", id)
 
-        // Request to enter
-        entra <- ric
-        <-ric.ack
+            // Request to enter
+            n++
+            call(CHI_COMMESSO, n, entra, ric)
 
-        fmt.Printf("[ASSISTANT %d] I have entered the shop...\n", id)
-        sleepRandTime(9)
+            fmt.Printf("[ASSISTANT %d] I have entered the shop...\n", id)
+            sleepRandTime(9)
+        }
 
         // Request to exit
-        esci <- ric
-        <-ric.ack
+        n++
+        call(CHI_COMMESSO, n, esci, ric)
         fmt.Printf("[ASSISTANT %d] I have left the shop...\n", id)
 
         // Check if we should terminate
@@ -197,13 +236,15 @@ func commesso(id int, entra chan Richiesta, esci chan Richiesta, termina chan bo
 // GOROUTINE: Supplier (fornitore)
 // Delivers NM masks every time it can, repeatedly, until it is asked to terminate.
 func fornitore(deposita chan bool, termina chan bool) {
+    // Deliveries made (with -resume, those in the checkpoint, see checkpoint.go)
+    n := ripresa.fatte(CHI_FORNITORE, 0)
     for {
         sleepRandTime(5)
         fmt.Printf("[SUPPLIER] I want to deliver a batch of masks...\n")
         
         // Send a signal that we have a batch to deposit
-        deposita <- true
-        <-deposita
+        n++
+        deliver(n, deposita)
         fmt.Printf("[SUPPLIER] Delivery completed...\n")
 
         // Check if we should terminate
@@ -235,7 +276,7 @@ func negozio(
     entraClienteAbituale chan Richiesta,
     entraClienteOccasionale chan Richiesta,
     entraCommesso chan Richiesta,
-    esciCliente chan int,
+    esciCliente chan Richiesta,
     esciCommesso chan Richiesta,
     deposita chan bool,
     termina chan bool,
@@ -265,6 +306,30 @@ func negozio(
     // Number of masks currently available
     mascherine := 0
 
+    // Requests applied, by who sent them: with -checkpoint a request can arrive
+    // again after a restart, and it is answered without being applied twice
+    richieste := nuoveRichieste()
+
+    // Acks wait for the checkpoint of the state they report (see checkpoint.go)
+    var acks outbox
+
+    // Admin commands (see admin.go): while paused nobody enters (the clients
+    // and the assistants inside still leave, the supplier still delivers);
+    // MAX can be lowered below the people inside, who stay, and nobody gets
+    // in until they drop below the new limit
+    paused := false
+    maxDentro := MAX
+
+    // After a restart, resume from the latest checkpoint
+    if stato := checkpoints.Resume(); stato != nil {
+        clientiDentro, commessiDentro = stato.ClientiDentro, stato.CommessiDentro
+        commessiLiberi, mascherine = stato.CommessiLiberi, stato.Mascherine
+        stato.ripristina(commessi)
+        richieste = stato.Richieste
+        paused, maxDentro = stato.Paused, stato.Max
+        fmt.Printf("[SHOP] Resumed from checkpoint %d...\n", stato.Numero)
+    }
+
     fmt.Printf("MAX: %d, NM: %d, N_CLIENTI: %d, N_COMMESSI: %d...\n", MAX, NM, N_CLIENTI, N_COMMESSI)
 
     // Main loop of the shop server
@@ -277,13 +342,25 @@ func negozio(
         case <-deposita:
             {
                 mascherine += NM
+                richieste.Consegne++
                 fmt.Printf("[SHOP] The supplier delivered %d masks...\n", NM)
-                deposita <- true
+                acks.reply(deposita, true)
             }
 
-        // 2) An assistant wants to enter the shop
-        case ric := <-whenRichiesta(clientiDentro+commessiDentro < MAX, entraCommesso):
+        // 2) An assistant wants to enter the shop (not paused, the shop is not full)
+        case ric := <-whenRichiesta(!paused && clientiDentro+commessiDentro < maxDentro, entraCommesso):
             {
+                if !commessoValido(ric.id) {
+                    fmt.Printf("[SHOP] There is no assistant %d, entry refused...\n", ric.id)
+                    acks.reply(ric.ack, false)
+                    break
+                }
+                if copia(ric.n, richieste.Commessi[ric.id]) {
+                    // A copy of a request already applied
+                    acks.reply(ric.ack, true)
+                    break
+                }
+                richieste.Commessi[ric.id]++
                 commessiDentro++
                 commessiLiberi++
                 commessi[ric.id].dentro = true
@@ -293,19 +370,35 @@ func negozio(
                     commessi[ric.id].clientiAssegnati[i] = -1
                 }
                 fmt.Printf("[SHOP] Assistant %d enters the shop...\n", ric.id)
-                ric.ack <- true
+                acks.reply(ric.ack, true)
             }
 
         // 3) An assistant requests to exit the shop
         case ric := <-esciCommesso:
             {
+                if !commessoValido(ric.id) {
+                    fmt.Printf("[SHOP] There is no assistant %d, exit refused...\n", ric.id)
+                    acks.reply(ric.ack, false)
+                    break
+                }
+                if copia(ric.n, richieste.Commessi[ric.id]) {
+                    // A copy of a request already applied: the assistant has
+                    // left, or still waits for its clients
+                    if ric.n == richieste.Commessi[ric.id] && commessi[ric.id].vuoleUscire {
+                        commessi[ric.id].ackUscita = ric.ack
+                    } else {
+                        acks.reply(ric.ack, true)
+                    }
+                    break
+                }
+                richieste.Commessi[ric.id]++
                 if commessi[ric.id].numeroClientiAssegnati == 0 {
                     // If the assistant has no assigned clients, they can exit immediately
                     fmt.Printf("[SHOP] Assistant %d leaves the shop...\n", ric.id)
                     commessi[ric.id].dentro = false
                     commessi[ric.id].vuoleUscire = false
                     commessi[ric.id].ackUscita = nil
-                    ric.ack <- true
+                    acks.reply(ric.ack, true)
                     commessiLiberi--
                     commessiDentro--
                 } else {
@@ -319,17 +412,23 @@ func negozio(
 
         // 4) A REGULAR client (ABITUALE) wants to enter
         //    Conditions:
+        //      - Not paused
         //      - There is at least 1 assistant inside and free
         //      - At least 1 mask available
         //      - The shop is not full
         //      - No one is queued in entraCommesso
         //      - The queue for occasional clients does not have priority
         case ric := <-whenRichiesta(
-                      commessiDentro > 0 && commessiLiberi > 0 && mascherine >= 1 &&
+                      !paused && commessiDentro > 0 && commessiLiberi > 0 && mascherine >= 1 &&
                       len(entraCommesso) == 0 &&
-                      (clientiDentro+commessiDentro < MAX),
+                      (clientiDentro+commessiDentro < maxDentro),
                       entraClienteAbituale):
             {
+                if copia(ric.n, richieste.Clienti[ric.id]) {
+                    // A copy of a request already applied
+                    acks.reply(ric.ack, true)
+                    break
+                }
                 found := false
                 // Search for a free assistant with <3 assigned clients
                 for i := 0; i < N_COMMESSI && !found; i++ {
@@ -346,7 +445,8 @@ func negozio(
                                 clientiDentro++
                                 mascherine--
                                 found = true
-                                ric.ack <- true
+                                richieste.Clienti[ric.id]++
+                                acks.reply(ric.ack, true)
                                 fmt.Printf("[SHOP] Regular client %d enters the shop...\n", ric.id)
                                 fmt.Printf("[SHOP] Assigning assistant %d to regular client %d...\n", i, ric.id)
                             }
@@ -360,17 +460,23 @@ func negozio(
 
         // 5) An OCCASIONAL client wants to enter
         //    Conditions:
+        //      - Not paused
         //      - No one is queued in entraClienteAbituale (regular clients have priority)
         //      - At least 1 free assistant
         //      - At least 1 mask available
         //      - The shop is not full
         //      - No one is queued in entraCommesso
         case ric := <-whenRichiesta(
-                      len(entraClienteAbituale) == 0 && commessiDentro > 0 && commessiLiberi > 0 && mascherine >= 1 &&
+                      !paused && len(entraClienteAbituale) == 0 && commessiDentro > 0 && commessiLiberi > 0 && mascherine >= 1 &&
                       len(entraCommesso) == 0 &&
-                      (clientiDentro+commessiDentro < MAX),
+                      (clientiDentro+commessiDentro < maxDentro),
                       entraClienteOccasionale):
             {
+                if copia(ric.n, richieste.Clienti[ric.id]) {
+                    // A copy of a request already applied
+                    acks.reply(ric.ack, true)
+                    break
+                }
                 found := false
                 // Search for a free assistant
                 for i := 0; i < N_COMMESSI && !found; i++ {
@@ -385,7 +491,8 @@ func negozio(
                                 clientiDentro++
                                 mascherine--
                                 found = true
-                                ric.ack <- true
+                                richieste.Clienti[ric.id]++
+                                acks.reply(ric.ack, true)
                                 fmt.Printf("[SHOP] Occasional client %d enters the shop...\n", ric.id)
                                 fmt.Printf("[SHOP] Assigning assistant %d to occasional client %d...\n", i, ric.id)
                             }
@@ -398,8 +505,14 @@ func negozio(
             }
 
         // 6) A client exits the shop (esciCliente)
-        case id := <-esciCliente:
+        case ric := <-esciCliente:
             {
+                id := ric.id
+                if copia(ric.n, richieste.Clienti[id]) {
+                    // A copy of a request already applied
+                    acks.reply(ric.ack, true)
+                    break
+                }
                 found := false
                 // Find which assistant was assigned to this client
                 for i := 0; i < N_COMMESSI && !found; i++ {
@@ -414,6 +527,7 @@ func negozio(
                                 commessi[i].numeroClientiAssegnati--
                                 clientiDentro--
                                 found = true
+                                richieste.Clienti[id]++
                                 fmt.Printf("[SHOP] Client %d leaves the shop...\n", id)
                                 fmt.Printf("[SHOP] Freeing assistant %d from supervising client %d...\n", i, id)
 
@@ -423,7 +537,7 @@ func negozio(
                                     fmt.Printf("[SHOP] Assistant %d leaves the shop...\n", i)
                                     commessi[i].dentro = false
                                     commessi[i].vuoleUscire = false
-                                    commessi[i].ackUscita <- true
+                                    acks.reply(commessi[i].ackUscita, true)
                                     commessi[i].ackUscita = nil
                                     // Reset the assigned clients array
                                     for j := 0; j < 3; j++ {
@@ -436,11 +550,64 @@ func negozio(
                         }
                     }
                 }
+                acks.reply(ric.ack, true)
+            }
+
+        // 7) A checkpoint is due (-checkpoint): the state is saved, and then the
+        //    acks of the requests it contains leave
+        case <-checkpoints.Due():
+            acks.checkpoint(fotografia(clientiDentro, commessiDentro, commessiLiberi, mascherine, paused, maxDentro, commessi, richieste))
+
+        // 8) After a restart, someone asks whether its request n was applied:
+        //    true if so, false if it must send it again
+        case r := <-riconnessioni:
+            {
+                fatte := richieste.di(r.chi, r.ric.id)
+                if r.chi == CHI_COMMESSO && fatte == r.ric.n && commessi[r.ric.id].vuoleUscire {
+                    // The exit waits for the clients of the assistant
+                    commessi[r.ric.id].ackUscita = r.ric.ack
+                    break
+                }
+                acks.reply(r.ric.ack, fatte >= r.ric.n)
+            }
+
+        // 9) The shop crashes (-crash): the acks not yet sent are lost
+        case <-crolla:
+            {
+                fmt.Printf("[SHOP] Crashed with %d acks not sent...\n", len(acks.held))
+                acks.crash()
+                return
+            }
+
+        // 10) An admin command: its answer
+        //     waits for the checkpoint, like the acks
+        case cmd := <-adminCommands:
+            switch cmd.Op {
+            case ADMIN_PAUSE, ADMIN_RESUME:
+                paused = cmd.Op == ADMIN_PAUSE
+                fmt.Printf("[SHOP] Admin: %s...\n", cmd.Op)
+                acks.answer(cmd, "paused: %v", paused)
+            case ADMIN_SET:
+                if cmd.Name != "MAX" {
+                    cmd.Fail("no capacity %s (MAX)", cmd.Name)
+                } else if n, err := cmd.Capacity(MAX); err == nil {
+                    maxDentro = n
+                    fmt.Printf("[SHOP] Admin: at most %d people inside (%d inside)...\n", maxDentro, clientiDentro+commessiDentro)
+                    acks.answer(cmd, "MAX: %d, inside: %d", maxDentro, clientiDentro+commessiDentro)
+                }
+            case ADMIN_OUT, ADMIN_IN:
+                // The assistants enter and leave by themselves
+                cmd.Fail("nothing to take out of service in the shop: change the capacity with set MAX <n>")
+            default: // ADMIN_DUMP
+                acks.answer(cmd, "paused: %v; MAX: %d; clients: %d, assistants: %d (%d free), masks: %d; waiting: ABITUALE %d, OCCASIONALE %d, assistants %d",
+                    paused, maxDentro, clientiDentro, commessiDentro, commessiLiberi, mascherine,
+                    len(entraClienteAbituale), len(entraClienteOccasionale), len(entraCommesso))
             }
 
-        // 7) The shop receives a termination signal
+        // 11) The shop receives a termination signal
         case <-termina:
             {
+                acks.checkpoint(fotografia(clientiDentro, commessiDentro, commessiLiberi, mascherine, paused, maxDentro, commessi, richieste))
                 fmt.Printf("[SHOP] Terminating...\n")
                 termina <- true
                 return
@@ -458,7 +625,7 @@ func main() {
 
     // Channels for assistants entering and exiting
     entraCommesso := make(chan Richiesta, MAXBUFF)
-    esciCliente := make(chan int)
+    esciCliente := make(chan Richiesta)
     esciCommesso := make(chan Richiesta)
 
     // Channel used by the supplier to deposit mask batches
@@ -474,23 +641,34 @@ func main() {
     // Seed random generator
     rand.Seed(time.Now().Unix())
 
-    // Create client goroutines
-    for i := 0; i < N_CLIENTI; i++ {
-        var tipo int
-        var entraCliente chan Richiesta
+    // Checkpoints of the shop (-checkpoint, see checkpoint.go); with -resume the
+    // clients, the assistants and the supplier go on from the latest one
+    checkpoints = CheckpointerFromFlags()
+    if *resumeFlag {
+        var err error
+        if ripresa, err = checkpoints.Latest(); err != nil {
+            fmt.Fprintln(os.Stderr, "checkpoint: skipped:", err)
+        }
+    }
+    if *dieFlag > 0 {
+        time.AfterFunc(*dieFlag, func() {
+            fmt.Printf("[MAIN] The process dies: run it again with -resume\n")
+            os.Exit(3)
+        })
+    }
 
-        // 30% chance to be regular (ABITUALE), 70% to be occasional (OCCASIONALE),
-        // based on a random threshold
-        if rand.Intn(100) > 70 {
-            tipo = ABITUALE
+    // Create client goroutines at their arrival times (-arrivals and -mix flags,
+    // see workload.go). By default each one arrives after 1-5 seconds and 30% of
+    // them are regular (ABITUALE), 70% occasional (OCCASIONALE)
+    workload := WorkloadFromFlags(tipoClienteStr[:], "spread:max=5", "ABITUALE=30,OCCASIONALE=70")
+    fairness = FairnessFromFlags(tipoClienteStr[:])
+    workload.Spawn(N_CLIENTI, func(id, tipo int) {
+        entraCliente := entraClienteOccasionale
+        if tipo == ABITUALE {
             entraCliente = entraClienteAbituale
-        } else {
-            tipo = OCCASIONALE
-            entraCliente = entraClienteOccasionale
         }
-        
-        go cliente(i, tipo, entraCliente, esciCliente, terminaCliente)
-    }
+        cliente(id, tipo, entraCliente, esciCliente, terminaCliente)
+    })
 
     // Create assistant goroutines
     for i := 0; i < N_COMMESSI; i++ {
@@ -501,16 +679,27 @@ func main() {
     // Create supplier goroutine
     go fornitore(deposita, terminaFornitore)
 
-    // Create the shop server goroutine
-    go negozio(
-        entraClienteAbituale,
-        entraClienteOccasionale,
-        entraCommesso,
-        esciCliente,
-        esciCommesso,
-        deposita,
-        terminaNegozio,
-    )
+    // Admin commands for the shop, with -admin (see admin.go)
+    stopAdmin := StartAdminFromFlags()
+
+    // Create the shop server goroutine, started again by -crash
+    avviaNegozio := func() {
+        go negozio(
+            entraClienteAbituale,
+            entraClienteOccasionale,
+            entraCommesso,
+            esciCliente,
+            esciCommesso,
+            deposita,
+            terminaNegozio,
+        )
+    }
+    stopCrashes := func() int { return 0 }
+    if checkpoints != nil {
+        stopCrashes = StartCrashes(*crashFlag, avviaNegozio)
+    } else {
+        avviaNegozio()
+    }
 
     // Wait for all clients to terminate
     for i := 0; i < N_CLIENTI; i++ {
@@ -532,8 +721,19 @@ func main() {
     }
 
     // Finally, terminate the shop
+    if n := stopCrashes(); n > 0 {
+        fmt.Printf("[MAIN] The shop restarted %d times\n", n)
+    }
     terminaNegozio <- true
     <-terminaNegozio
+    stopAdmin()
+    checkpoints.Stop()
+    fairness.Report(os.Stdout)
+
+    if err := CheckLeaks(); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
 }
 
 // -----------------------------------------------------------------------------------
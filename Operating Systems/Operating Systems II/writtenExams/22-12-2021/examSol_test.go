@@ -0,0 +1,755 @@
+// -----------------------------------------------------------------------------------
+// TESTS, FUZZ TARGET AND BENCHMARKS FOR negozio() (SHOP WITH MASKS)
+//
+// The tests feed negozio() scripted sequences of clients, assistants and mask
+// deliveries under a virtual clock, and check who gets in, in which order,
+// when the assistants may leave, and that the shop is empty at the end.
+// TestAdmin sends the shop the commands of admin.go.
+//
+// FuzzNegozio turns arbitrary bytes into legal runs of clients, assistants and
+// supplier, with requests of assistants that do not exist, and fails on a
+// broken invariant, a deadlock, an unknown assistant let in or a panic of the
+// shop. Its seed corpus is in testdata/fuzz/FuzzNegozio.
+//
+// The CHECKPOINTS tests run the clients, assistants and supplier of main with
+// checkpoints (see checkpoint.go): with negozio() crashing and resuming on the
+// same channels, and with a process that dies and is resumed past a corrupt
+// checkpoint. Every client must get in and out exactly once.
+// TestAdminCheckpoint checks that the answers of the admin commands wait for
+// the checkpoint, and that a setting survives a crash.
+//
+// negozio() receives its channels as parameters, so every benchmark creates its
+// own. Before the measurement the supplier protocol (deposita) is used to stock
+// enough masks for the whole run, and all N_COMMESSI assistants enter the shop
+// and stay there. Synthetic clients then repeat the cycle of cliente() without
+// any sleep: 3 clients in 10 are regular (ABITUALE), the others occasional.
+// At the end the assistants leave and the shop is closed.
+//
+// The benchmark reports:
+//   - grants/s:        clients admitted in the shop per second;
+//   - p50-ns, p99-ns:  latency from the entry request to its ack;
+//   - allocs/op:       allocations per grant.
+// It runs twice: with unbuffered request channels and with buffered ones
+// (MAXBUFF slots).
+//
+// Run with:
+//     go test -race examSol.go workload.go fairness.go leaks.go checkpoint.go admin.go servertest_test.go examSol_test.go
+//     go test -run XXX -fuzz FuzzNegozio examSol.go workload.go fairness.go leaks.go checkpoint.go admin.go servertest_test.go examSol_test.go
+//     go test -run XXX -bench . examSol.go workload.go fairness.go leaks.go checkpoint.go admin.go servertest_test.go examSol_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = 2 * (MAX - N_COMMESSI) // Synthetic clients: twice the room left by the assistants
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+// The assistants are numbered 0..N_COMMESSI-1, the clients from 10 on.
+
+const (
+	opRegular    = iota // entraClienteAbituale <- ric
+	opOccasional        // entraClienteOccasionale <- ric
+	opLeave             // esciCliente <- ric, without waiting for its ack
+	opIn                // entraCommesso <- ric
+	opOut               // esciCommesso <- ric
+	opDeliver           // deposita <- true, <-deposita
+)
+
+var opNames = []string{"regular client", "occasional client", "client exit", "assistant in", "assistant out", "delivery"}
+
+// shop holds the channels of a negozio() and runs the steps of a test.
+type shop struct {
+	*script[bool]
+	entraClienteAbituale, entraClienteOccasionale, entraCommesso chan Richiesta
+	esciCliente, esciCommesso                                    chan Richiesta
+	deposita, termina                                            chan bool
+}
+
+// newShop starts a negozio() with the channels made as in main.
+func newShop(t *testing.T) *shop {
+	s := &shop{
+		entraClienteAbituale:    make(chan Richiesta, MAXBUFF),
+		entraClienteOccasionale: make(chan Richiesta, MAXBUFF),
+		entraCommesso:           make(chan Richiesta, MAXBUFF),
+		esciCliente:             make(chan Richiesta),
+		esciCommesso:            make(chan Richiesta),
+		deposita:                make(chan bool),
+		termina:                 make(chan bool),
+	}
+	go negozio(s.entraClienteAbituale, s.entraClienteOccasionale, s.entraCommesso,
+		s.esciCliente, s.esciCommesso, s.deposita, s.termina)
+	s.script = newScript[bool](t)
+	return s
+}
+
+// do performs a step. A delivery is made by the test itself, since the
+// supplier receives its reply on deposita: the shop must be idle.
+func (s *shop) do(st step) {
+	ric := Richiesta{id: st.id, ack: make(chan bool)}
+	var c chan Richiesta
+	switch st.op {
+	case opRegular:
+		c = s.entraClienteAbituale
+	case opOccasional:
+		c = s.entraClienteOccasionale
+	case opIn:
+		c = s.entraCommesso
+	case opOut:
+		c = s.esciCommesso
+	case opLeave:
+		go func() { s.esciCliente <- Richiesta{id: st.id} }()
+		return
+	case opDeliver:
+		s.deposita <- true
+		<-s.deposita
+		return
+	}
+	s.call(ric.id, ric.ack, func() { c <- ric })
+}
+
+func TestNegozio(t *testing.T) {
+	silence(t)
+	var masks []step // NM clients in and out, one at a time
+	for id := 10; id < 10+NM; id++ {
+		masks = append(masks, step{opRegular, id, []int{id}}, step{opLeave, id, nil})
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"clients need an assistant and a mask", []step{
+			{opRegular, 10, nil},
+			{opIn, 0, []int{0}},
+			{opDeliver, 0, []int{10}},
+			{opLeave, 10, nil},
+			{opOut, 0, []int{0}},
+		}},
+		{"an assistant serves at most 3 clients", slices.Concat(
+			[]step{
+				{opIn, 0, []int{0}},
+				{opDeliver, 0, nil},
+			},
+			each(opRegular, 10, 12, true),
+			[]step{
+				{opRegular, 13, nil},
+				{opLeave, 10, []int{13}},
+			},
+			each(opLeave, 11, 13, false),
+			[]step{{opOut, 0, []int{0}}},
+		)},
+		{"regular clients before occasional ones", slices.Concat(
+			[]step{
+				{opIn, 0, []int{0}},
+				{opDeliver, 0, nil},
+			},
+			each(opRegular, 10, 12, true),
+			[]step{
+				{opOccasional, 20, nil},
+				{opRegular, 21, nil},
+				{opLeave, 10, []int{21}},
+				{opLeave, 11, []int{20}},
+			},
+			each(opLeave, 12, 12, false),
+			each(opLeave, 20, 21, false),
+			[]step{{opOut, 0, []int{0}}},
+		)},
+		{"an assistant leaves after the last of its clients", []step{
+			{opIn, 0, []int{0}},
+			{opDeliver, 0, nil},
+			{opRegular, 10, []int{10}},
+			{opOut, 0, nil},
+			{opLeave, 10, []int{0}},
+		}},
+		{"every client takes a mask", slices.Concat(
+			[]step{
+				{opIn, 0, []int{0}},
+				{opDeliver, 0, nil},
+			},
+			masks,
+			[]step{
+				{opRegular, 30, nil},
+				{opDeliver, 0, []int{30}},
+				{opLeave, 30, nil},
+				{opOut, 0, []int{0}},
+			},
+		)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				s := newShop(t)
+				for _, st := range tt.steps {
+					s.do(st)
+					s.expect(st.String(), st.want)
+				}
+				s.finish()
+				s.checkEmpty()
+				s.termina <- true
+				<-s.termina
+			})
+		})
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := newShop(t)
+		play := func(steps ...step) {
+			t.Helper()
+			for _, st := range steps {
+				s.do(st)
+				s.expect(st.String(), st.want)
+			}
+		}
+
+		// Two people inside at most: client 11 waits, until there is MAX again
+		s.admin(Admin, "set MAX 2", "MAX: 2, inside: 0", nil)
+		play(step{opIn, 0, []int{0}}, step{opDeliver, 0, nil}, step{opRegular, 10, []int{10}}, step{opRegular, 11, nil})
+		s.admin(Admin, "dump", "paused: false; MAX: 2; clients: 1, assistants: 1 (1 free), masks: 9; "+
+			"waiting: ABITUALE 1, OCCASIONALE 0, assistants 0", nil)
+		s.admin(Admin, "set MAX 18", "MAX: 18, inside: 2", []int{11})
+
+		// Paused: the clients wait for resume
+		s.admin(Admin, "pause", "paused: true", nil)
+		play(step{opOccasional, 12, nil})
+		s.admin(Admin, "resume", "paused: false", []int{12})
+
+		s.admin(Admin, "set MAX 19", "error: MAX cannot be above 18", nil)
+		s.admin(Admin, "set NM 5", "error: no capacity NM (MAX)", nil)
+		s.admin(Admin, "out assistant 0",
+			"error: nothing to take out of service in the shop: change the capacity with set MAX <n>", nil)
+		play(each(opLeave, 10, 12, false)...)
+		play(step{opOut, 0, []int{0}})
+		s.finish()
+		s.checkEmpty()
+		s.termina <- true
+		<-s.termina
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// checkEmpty verifies that the shop is empty: after a delivery all the
+// N_COMMESSI assistants get in, and then MAX-N_COMMESSI clients.
+func (s *shop) checkEmpty() {
+	s.t.Helper()
+	s.do(step{op: opDeliver})
+	phases := [][]step{
+		each(opIn, 0, N_COMMESSI-1, false),
+		each(opRegular, 50, 50+MAX-N_COMMESSI-1, false),
+	}
+	for _, steps := range phases {
+		for _, st := range steps {
+			s.do(st)
+		}
+		if got := s.grants(); len(got) != len(steps) {
+			s.t.Errorf("final state: %d of %d got in", len(got), len(steps))
+		}
+		s.finish()
+	}
+	for _, st := range phases[1] {
+		s.do(step{op: opLeave, id: st.id})
+	}
+	s.grants()
+	for _, st := range phases[0] {
+		s.do(step{op: opOut, id: st.id})
+	}
+	s.grants()
+	s.finish()
+}
+
+// ============================================================
+//                          FUZZING
+// ============================================================
+
+// FuzzNegozio decodes its input into actions of clients, assistants and the
+// supplier, two bytes each: the first one selects the op, the second one who
+// performs it. Actions the real goroutines could not perform, like a client
+// leaving before it entered, are skipped, so every input is a legal run of
+// the shop. The assistant ids go two past the N_COMMESSI assistants: negozio()
+// must refuse the requests of those with a false ack, not index commessi out
+// of range. The invariants of the shop are checked after every action; at the
+// end everyone must be able to get in and out, as at the end of main, and the
+// shop must be empty. A panic of negozio() crashes the test binary and is
+// reported with its input. Like the tests, it runs under the virtual clock of
+// synctest.
+//
+// The seed corpus and the failing inputs are in testdata/fuzz/FuzzNegozio,
+// where go test runs them again as regression tests.
+func FuzzNegozio(f *testing.F) {
+	silence(f)
+	f.Add([]byte{})
+	f.Add([]byte{opRegular, 0, opIn, 0, opDeliver, 0, opOut, 0, opLeave, 0})
+	f.Add([]byte{opIn, 0, opDeliver, 0, opRegular, 0, opRegular, 1, opRegular, 2, opOccasional, 3, opRegular, 4, opLeave, 0, opLeave, 1})
+	f.Add([]byte{opIn, 0, opIn, 1, opDeliver, 0, opRegular, 0, opOut, 0, opRegular, 1, opLeave, 0, opOut, 1})
+	f.Add([]byte{opIn, 8, opOut, 9, opIn, 0, opOut, 8}) // 8, 9: no assistant
+	f.Fuzz(func(t *testing.T, data []byte) {
+		synctest.Test(t, func(t *testing.T) {
+			m := &shopModel{shop: newShop(t), where: map[int]int{}}
+			t.Cleanup(func() {
+				m.termina <- true
+				<-m.termina
+			})
+			for i := 0; i+1 < len(data) && i < 2*fuzzActions; i += 2 {
+				op, who := int(data[i])%len(opNames), int(data[i+1])
+				if id, ok := m.apply(op, who); ok {
+					m.check(step{op: op, id: id}.String())
+				}
+			}
+			m.drain()
+			m.finish()
+			m.checkEmpty()
+		})
+	})
+}
+
+const (
+	fuzzActions    = 200            // Actions decoded from an input, at most
+	fuzzClients    = 2 * MAX        // Clients of the fuzz target, with ids from 10 on
+	fuzzAssistants = N_COMMESSI + 2 // Assistant ids of the fuzz target: the last two are nobody
+)
+
+// Where a client or an assistant is, as seen from the acks of negozio().
+const (
+	outside  = iota
+	entering // waiting for the ack of its entry
+	inside
+	leaving // an assistant waiting for its clients to leave
+)
+
+// shopModel follows a negozio() driven by a fuzz target.
+type shopModel struct {
+	*shop
+	where map[int]int // where every client and assistant is
+	masks int         // masks delivered and not taken yet
+}
+
+// apply performs op for client (or assistant) who, and reports its id,
+// or false if op cannot be performed by it.
+func (m *shopModel) apply(op, who int) (int, bool) {
+	id := 10 + who%fuzzClients
+	if op == opIn || op == opOut {
+		id = who % fuzzAssistants
+	}
+	switch {
+	case id >= N_COMMESSI && id < 10:
+		// Not an assistant: its request waits for its refusal
+		if m.where[id] != outside {
+			return id, false
+		}
+		m.where[id] = entering
+	case op == opRegular || op == opOccasional || op == opIn:
+		if m.where[id] != outside {
+			return id, false
+		}
+		m.where[id] = entering
+	case op == opLeave || op == opOut:
+		if m.where[id] != inside {
+			return id, false
+		}
+		m.where[id] = outside
+		if op == opOut {
+			m.where[id] = leaving
+		}
+	case op == opDeliver:
+		m.masks += NM
+	}
+	m.do(step{op: op, id: id})
+	for _, id := range m.grants() {
+		switch {
+		case id >= N_COMMESSI && id < 10:
+			if m.last[id] {
+				m.t.Fatalf("assistant %d, who does not exist, got in or out", id)
+			}
+			m.where[id] = outside
+		case m.where[id] == entering:
+			m.where[id] = inside
+			if id >= 10 {
+				m.masks--
+			}
+		case m.where[id] == leaving:
+			m.where[id] = outside
+		}
+	}
+	return id, true
+}
+
+// check verifies the invariants of the shop after action.
+func (m *shopModel) check(action string) {
+	m.t.Helper()
+	clients, assistants := 0, 0
+	for id, w := range m.where {
+		switch {
+		case w != inside && w != leaving:
+		case id < 10:
+			assistants++
+		default:
+			clients++
+		}
+	}
+	switch {
+	case clients+assistants > MAX:
+		m.t.Fatalf("after %s: %d clients and %d assistants inside, more than MAX", action, clients, assistants)
+	case clients > 3*assistants:
+		m.t.Fatalf("after %s: %d clients inside with %d assistants", action, clients, assistants)
+	case m.masks < 0:
+		m.t.Fatalf("after %s: %d clients got in without a mask", action, -m.masks)
+	}
+}
+
+// drain ends the run like main: the clients inside leave, the assistants
+// outside get in and the supplier delivers masks until nobody is waiting;
+// then all the assistants leave. It fails if negozio() stops answering with
+// someone still waiting.
+func (m *shopModel) drain() {
+	m.t.Helper()
+	for {
+		var waiting []int
+		busy := false
+		for _, id := range slices.Sorted(maps.Keys(m.where)) {
+			switch w := m.where[id]; {
+			case w == entering || w == leaving:
+				waiting = append(waiting, id)
+				busy = true
+			case w == inside && id >= 10:
+				busy = true
+			}
+		}
+		if !busy {
+			break
+		}
+		before := maps.Clone(m.where)
+		for _, id := range slices.Sorted(maps.Keys(before)) {
+			if before[id] == inside && id >= 10 {
+				m.apply(opLeave, id-10)
+				m.check("draining")
+			}
+		}
+		for id := range N_COMMESSI {
+			if before[id] == outside {
+				m.apply(opIn, id)
+				m.check("draining")
+			}
+		}
+		m.apply(opDeliver, 0)
+		if maps.Equal(before, m.where) {
+			m.t.Fatalf("deadlock: %v still waiting for negozio()", waiting)
+		}
+	}
+	for id := range N_COMMESSI {
+		if m.where[id] == inside {
+			m.apply(opOut, id)
+		}
+		if m.where[id] != outside {
+			m.t.Fatalf("deadlock: assistant %d cannot leave the empty shop", id)
+		}
+	}
+}
+
+// ============================================================
+//                         CHECKPOINTS
+// ============================================================
+
+// withCheckpoints sets checkpoints to a Checkpointer writing to dir for the rest
+// of the test; the Checkpointer must be made inside the bubble of the test.
+func withCheckpoints(t *testing.T, dir string, resume bool) *Checkpointer {
+	c, err := NewCheckpointer(dir, time.Second, resume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoints = c
+	t.Cleanup(func() {
+		c.Stop()
+		checkpoints, riconnessioni, crolla, ripresa = nil, nil, nil, nil
+	})
+	return c
+}
+
+// runShop runs the clients, the assistants and the supplier of main until every
+// client has terminated, with negozio() crashing every crashEvery (never if 0),
+// and returns the number of crashes.
+func runShop(crashEvery time.Duration) int {
+	entraClienteAbituale := make(chan Richiesta, MAXBUFF)
+	entraClienteOccasionale := make(chan Richiesta, MAXBUFF)
+	entraCommesso := make(chan Richiesta, MAXBUFF)
+	esciCliente, esciCommesso := make(chan Richiesta), make(chan Richiesta)
+	deposita, terminaNegozio := make(chan bool), make(chan bool)
+	stopCrashes := StartCrashes(crashEvery, func() {
+		go negozio(entraClienteAbituale, entraClienteOccasionale, entraCommesso,
+			esciCliente, esciCommesso, deposita, terminaNegozio)
+	})
+
+	terminaCliente, done, terminaFornitore := make(chan bool), make(chan bool), make(chan bool)
+	for id := range N_CLIENTI {
+		entra, tipo := entraClienteOccasionale, OCCASIONALE
+		if id%10 < 3 {
+			entra, tipo = entraClienteAbituale, ABITUALE
+		}
+		go func() {
+			sleepRandTime(20)
+			cliente(id, tipo, entra, esciCliente, terminaCliente)
+		}()
+	}
+	terminaCommesso := make([]chan bool, N_COMMESSI)
+	for i := range terminaCommesso {
+		terminaCommesso[i] = make(chan bool, MAXBUFF)
+		go commesso(i, entraCommesso, esciCommesso, terminaCommesso[i], done)
+	}
+	go fornitore(deposita, terminaFornitore)
+
+	for range N_CLIENTI {
+		<-terminaCliente
+	}
+	terminaFornitore <- true
+	<-terminaFornitore
+	for i := range terminaCommesso {
+		terminaCommesso[i] <- true
+	}
+	for range N_COMMESSI {
+		<-done
+	}
+	crashes := stopCrashes()
+	terminaNegozio <- true
+	<-terminaNegozio
+	return crashes
+}
+
+// checkClosed checks that the latest checkpoint of c is the empty shop after
+// every client got in and out once.
+func checkClosed(t *testing.T, c *Checkpointer) {
+	t.Helper()
+	s, err := c.Latest()
+	if s == nil {
+		t.Fatal("no checkpoint:", err)
+	}
+	if s.ClientiDentro != 0 || s.CommessiDentro != 0 {
+		t.Errorf("%d clients and %d assistants in the shop at the end", s.ClientiDentro, s.CommessiDentro)
+	}
+	for id := range N_CLIENTI {
+		if n := s.Richieste.Clienti[id]; n != 2 {
+			t.Errorf("client %d: %d requests applied, want 2", id, n)
+		}
+	}
+	for id, n := range s.Richieste.Commessi {
+		if dentro(n) {
+			t.Errorf("assistant %d: %d requests applied, want an even number", id, n)
+		}
+	}
+	if want := s.Richieste.Consegne*NM - N_CLIENTI; s.Mascherine != want {
+		t.Errorf("%d masks left, want %d", s.Mascherine, want)
+	}
+}
+
+// TestCheckpointCrashes runs the shop of main while negozio() crashes and
+// resumes from its checkpoints: every client gets in and out once.
+func TestCheckpointCrashes(t *testing.T) {
+	silence(t)
+	dir := t.TempDir()
+	synctest.Test(t, func(t *testing.T) {
+		c := withCheckpoints(t, dir, false)
+		if crashes := runShop(2500 * time.Millisecond); crashes < 10 {
+			t.Errorf("%d crashes, want at least 10", crashes)
+		}
+		checkClosed(t, c)
+	})
+}
+
+// TestCheckpointResume lets a process die with a client in the shop, the exit
+// of its assistant waiting for it, and an exit applied after the latest
+// checkpoint, then resumes it in a new process, past a corrupt checkpoint.
+func TestCheckpointResume(t *testing.T) {
+	silence(t)
+	dir := t.TempDir()
+	var last int
+	synctest.Test(t, func(t *testing.T) {
+		c := withCheckpoints(t, dir, false)
+		crolla = make(chan bool)
+		entraClienteAbituale := make(chan Richiesta, MAXBUFF)
+		entraClienteOccasionale := make(chan Richiesta, MAXBUFF)
+		entraCommesso := make(chan Richiesta, MAXBUFF)
+		esciCliente, esciCommesso := make(chan Richiesta), make(chan Richiesta)
+		deposita := make(chan bool)
+		go negozio(entraClienteAbituale, entraClienteOccasionale, entraCommesso,
+			esciCliente, esciCommesso, deposita, make(chan bool))
+
+		send := func(c chan Richiesta, id, n int) chan bool {
+			ack := make(chan bool, MAXBUFF)
+			c <- Richiesta{id: id, ack: ack, n: n}
+			return ack
+		}
+		deposita <- true
+		<-deposita
+		<-send(entraCommesso, 0, 1)
+		<-send(entraClienteAbituale, 0, 1)
+		<-send(entraClienteOccasionale, 1, 1)
+		<-send(esciCliente, 1, 2)
+		uscita := send(esciCommesso, 0, 2)
+		time.Sleep(2 * time.Second)
+
+		// Applied but not in a checkpoint: the process dies before the ack
+		uscitaCliente := send(esciCliente, 0, 2)
+		synctest.Wait()
+		crolla <- true
+		last = c.numero
+		if len(uscita) > 0 || len(uscitaCliente) > 0 {
+			t.Error("an ack left with its state not in a checkpoint")
+		}
+	})
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("negozio-%06d.json", last+1)), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	synctest.Test(t, func(t *testing.T) {
+		c := withCheckpoints(t, dir, true)
+		var err error
+		ripresa, err = c.Latest()
+		if ripresa == nil || err == nil {
+			t.Fatalf("resumed from %v, skipped %v: want the checkpoint before a corrupt one", ripresa, err)
+		}
+		if ripresa.Numero != last {
+			t.Errorf("resumed from checkpoint %d, want %d", ripresa.Numero, last)
+		}
+		if ripresa.fatte(CHI_CLIENTE, 0) != 1 || ripresa.fatte(CHI_CLIENTE, 1) != 2 || !ripresa.uscendo(0) {
+			t.Errorf("resumed with requests %+v and assistants %+v", ripresa.Richieste, ripresa.Commessi)
+		}
+		runShop(0)
+		if c.numero <= last+1 {
+			t.Errorf("new checkpoints numbered from %d, want after %d", c.numero, last+1)
+		}
+		checkClosed(t, c)
+	})
+}
+
+// TestAdminCheckpoint sends admin commands to a negozio() with checkpoints: an
+// answer leaves after the checkpoint of the change, a command not in a
+// checkpoint fails when negozio() crashes, and the restarted one keeps the
+// settings of the checkpoint.
+func TestAdminCheckpoint(t *testing.T) {
+	silence(t)
+	dir := t.TempDir()
+	synctest.Test(t, func(t *testing.T) {
+		withCheckpoints(t, dir, false)
+		crolla = make(chan bool)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := newShop(t)
+		admin := func(line string) chan string {
+			answer := make(chan string, 1)
+			go func() {
+				text, err := Admin(line)
+				if err != nil {
+					text = "error: " + err.Error()
+				}
+				answer <- text
+			}()
+			return answer
+		}
+
+		set := admin("set MAX 5")
+		synctest.Wait()
+		if len(set) > 0 {
+			t.Error("set MAX answered before its checkpoint")
+		}
+		time.Sleep(2 * time.Second)
+		if got, want := <-set, "MAX: 5, inside: 0"; got != want {
+			t.Errorf("set MAX: %q, want %q", got, want)
+		}
+
+		pause := admin("pause")
+		synctest.Wait()
+		crolla <- true
+		if got, want := <-pause, "error: the shop restarted before saving it: send the command again"; got != want {
+			t.Errorf("pause: %q, want %q", got, want)
+		}
+
+		go negozio(s.entraClienteAbituale, s.entraClienteOccasionale, s.entraCommesso,
+			s.esciCliente, s.esciCommesso, s.deposita, s.termina)
+		dump := admin("dump")
+		time.Sleep(2 * time.Second)
+		if got, want := <-dump, "paused: false; MAX: 5; clients: 0, assistants: 0 (0 free), masks: 0; "+
+			"waiting: ABITUALE 0, OCCASIONALE 0, assistants 0"; got != want {
+			t.Errorf("dump after the restart: %q, want %q", got, want)
+		}
+		s.termina <- true
+		<-s.termina
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+func BenchmarkNegozio(b *testing.B) {
+	silence(b)
+	for _, m := range bufferModes(MAXBUFF) {
+		b.Run(m.name, func(b *testing.B) {
+			entraClienteAbituale := make(chan Richiesta, m.size)
+			entraClienteOccasionale := make(chan Richiesta, m.size)
+			entraCommesso := make(chan Richiesta, m.size)
+			esciCliente := make(chan Richiesta)
+			esciCommesso := make(chan Richiesta)
+			deposita := make(chan bool)
+			termina := make(chan bool)
+			go negozio(entraClienteAbituale, entraClienteOccasionale, entraCommesso,
+				esciCliente, esciCommesso, deposita, termina)
+
+			for i := 0; i <= b.N/NM; i++ {
+				deposita <- true
+				<-deposita
+			}
+			assistants := make([]Richiesta, N_COMMESSI)
+			for i := range assistants {
+				assistants[i] = Richiesta{id: i, ack: make(chan bool, MAXBUFF)}
+				entraCommesso <- assistants[i]
+				<-assistants[i].ack
+			}
+			clients := make([]Richiesta, loadClients)
+			for id := range clients {
+				clients[id] = Richiesta{id: id, ack: make(chan bool, MAXBUFF)}
+			}
+
+			measure(b, loadClients, func(id int) time.Duration {
+				entra := entraClienteOccasionale
+				if id%10 < 3 {
+					entra = entraClienteAbituale
+				}
+				ric := clients[id]
+				t := time.Now()
+				entra <- ric
+				<-ric.ack
+				d := time.Since(t)
+				esciCliente <- Richiesta{id: id}
+				return d
+			})
+
+			for _, ric := range assistants {
+				esciCommesso <- ric
+				<-ric.ack
+			}
+			termina <- true
+			<-termina
+		})
+	}
+}
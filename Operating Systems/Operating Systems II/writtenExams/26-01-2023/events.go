@@ -0,0 +1 @@
+../../events/events.go
\ No newline at end of file
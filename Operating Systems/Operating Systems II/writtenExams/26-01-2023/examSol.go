@@ -1,8 +1,13 @@
+// Run with:
+//     go run examSol.go workload.go leaks.go events.go admin.go
+
 package main
 
 import (
 	"fmt"
 	"math/rand"
+	"os"
+	"slices"
 	"time"
 )
 
@@ -30,19 +35,35 @@ const MaxLargeCoins = 20      // Max 20-cent coins before refill
 
 // Channels for client requests
 var start_request [2]chan request // Buffered channels for starting requests (index 0: Small, 1: Large)
-var end_request = make(chan request, MAX_BUFFER) // Buffered channel for ending requests
+var end_request chan request      // Buffered channel for ending requests
 
 // Channels for operator actions
 // start refill is buffered because the operator can request multiple refills.
 // terminate Operator is not buffered to ensure synchronous handshake.
-var start_refill = make(chan int, MAX_BUFFER)    // Operator starts refill
-var end_refill = make(chan int, MAX_BUFFER)      // Operator ends refill
-var ack_operator = make(chan int, MAX_BUFFER)    // Acknowledgment for operator
+var start_refill chan int // Operator starts refill
+var end_refill chan int   // Operator ends refill
+var ack_operator chan int // Acknowledgment for operator
 
 // Termination channels (unbuffered for synchronization)
-var done = make(chan bool)              // Signals client completion
-var terminate = make(chan bool)         // Signals waterStation to terminate
-var terminateOperator = make(chan bool) // Signals operator to terminate
+var done chan bool              // Signals client completion
+var terminate chan bool         // Signals waterStation to terminate
+var terminateOperator chan bool // Signals operator to terminate
+
+// initChannels creates all the channels above.
+// 'size' is the buffer of the client/operator request channels (MAX_BUFFER in main).
+func initChannels(size int) {
+	for i := 0; i < 2; i++ {
+		start_request[i] = make(chan request, size)
+	}
+	end_request = make(chan request, size)
+	start_refill = make(chan int, size)
+	end_refill = make(chan int, size)
+	ack_operator = make(chan int, MAX_BUFFER)
+
+	done = make(chan bool)
+	terminate = make(chan bool)
+	terminateOperator = make(chan bool)
+}
 
 // Request structure for client requests
 type request struct {
@@ -75,10 +96,9 @@ func sleepRandomTime(limit int) {
 }
 
 // Client goroutine: Simulates client behavior
-func client(index int) {
-	kind := rand.Intn(2) // Randomly choose bottle type
+// Started by main at the arrival time chosen by the workload, with its bottle type
+func client(index int, kind int) {
 	r := request{index, kind, make(chan int)}
-	sleepRandomTime(2) // Simulate payment time
 
 	// Send request to appropriate channel
 	if r.kind == SmallBottle {
@@ -117,71 +137,203 @@ func operator() {
 	}
 }
 
+// ========================== STATE ==========================
+// The state of the water station changes only through its events (see
+// events.go): waterStation() appends what happens to the log and folds it into
+// its state, and -replay rebuilds the state at any time of a logged run.
+
+// Events of the water station
+const (
+	FILL_SMALL        = "started filling a small bottle" // client: 10 cents in the small coin box
+	FILL_LARGE        = "started filling a large bottle" // client: 20 cents in the large coin box
+	FILL_ENDED        = "finished filling"               // client
+	REFILL_STARTED    = "refill started"                 // the tank is full and the coin boxes empty
+	REFILL_ENDED      = "refill ended"
+	OPERATOR_STOPPING = "operator stopping"              // no more refills
+
+	// Admin commands (see admin.go)
+	PAUSED     = "paused by the admin"   // no client starts filling a bottle
+	RESUMED    = "resumed by the admin"
+	BOX_GROWN  = "enlarged by the admin" // room for one more coin in the box of the actor (boxActors)
+	BOX_SHRUNK = "shrunk by the admin"
+)
+
+// fillEvents are the events of a client starting to fill a bottle, by bottle type
+var fillEvents = [2]string{FILL_SMALL, FILL_LARGE}
+
+// Actors of the events of the admin on the coin boxes, by box
+var boxActors = [2]string{"10-cent box", "20-cent box"}
+
+// bottleCents is the price of a bottle, by bottle type
+var bottleCents = [2]int{10, 20}
+
+// stationState is the state of waterStation(), folded from its events.
+type stationState struct {
+	Water   float64 // liters left in the tank
+	Coins   [2]int  // coins in the boxes [SmallCoinsBox, LargeCoinsBox]
+	Busy    bool    // a client fills a bottle or the operator refills
+	Serving int     // the client filling a bottle, -1 for the operator
+	Bottle  int     // the bottle type of the client
+	Stop    bool    // no more refills
+	Sold    [2]int  // bottles sold since the start, by type
+	Refills int
+
+	// Changed by the admin commands: the coins a box holds before the refill
+	// (shrunk below its coins, the box is full) and the pause
+	Boxes  [2]int
+	Paused bool
+}
+
+func newStationState() stationState {
+	return stationState{Water: TankCapacity, Boxes: [2]int{MaxSmallCoins, MaxLargeCoins}}
+}
+
+// Apply returns the state after e.
+func (s stationState) Apply(e DomainEvent) stationState {
+	switch e.Kind {
+	case FILL_SMALL, FILL_LARGE:
+		kind := SmallBottle
+		if e.Kind == FILL_LARGE {
+			kind = LargeBottle
+		}
+		s.Busy, s.Serving, s.Bottle = true, e.ID, kind
+		s.Coins[kind]++ // the boxes are indexed as the bottles
+		s.Sold[kind]++
+		s.Water -= []float64{CapacitySmall, CapacityLarge}[kind]
+	case FILL_ENDED, REFILL_ENDED:
+		s.Busy = false
+	case REFILL_STARTED:
+		s.Busy, s.Serving = true, -1
+		s.Water, s.Coins = TankCapacity, [2]int{}
+		s.Refills++
+	case OPERATOR_STOPPING:
+		s.Stop = true
+	case PAUSED, RESUMED:
+		s.Paused = e.Kind == PAUSED
+	case BOX_GROWN:
+		s.Boxes[slices.Index(boxActors[:], e.Actor)]++
+	case BOX_SHRUNK:
+		s.Boxes[slices.Index(boxActors[:], e.Actor)]--
+	}
+	return s
+}
+
+// The views of the state printed by -replay
+var stationProjections = []Projection[stationState]{
+	{"occupancy", func(s stationState) string {
+		switch {
+		case !s.Busy:
+			return "free"
+		case s.Serving < 0:
+			return "the operator is refilling"
+		}
+		return fmt.Sprintf("client %d is filling a %s bottle", s.Serving, []string{"small", "large"}[s.Bottle])
+	}},
+	{"inventory", func(s stationState) string {
+		return fmt.Sprintf("%.1f of %.1f liters of water, %d/%d coins of 10 cents, %d/%d coins of 20 cents",
+			s.Water, TankCapacity, s.Coins[SmallCoinsBox], s.Boxes[SmallCoinsBox], s.Coins[LargeCoinsBox], s.Boxes[LargeCoinsBox])
+	}},
+	{"revenue", func(s stationState) string {
+		cents := s.Sold[SmallBottle]*bottleCents[SmallBottle] + s.Sold[LargeBottle]*bottleCents[LargeBottle]
+		return fmt.Sprintf("%d.%02d euros from %d small and %d large bottles, %d refills",
+			cents/100, cents%100, s.Sold[SmallBottle], s.Sold[LargeBottle], s.Refills)
+	}},
+}
+
 // Server goroutine: Manages the water station's state and coordination
 func waterStation() {
-	var currentWater = TankCapacity // Track remaining water
-	var smallCoinCount = 0          // 10-cent coins collected
-	var largeCoinCount = 0          // 20-cent coins collected
-	var busy = false                // Whether the station is busy
-	var stop = false                // Termination flag
+	s := newStationState() // Water left, coins collected, busy, stop: see stationState
 
 	fmt.Printf("[waterStation] Water station is operational!\n")
 	for {
 		select {
 		// Handle SmallBottle request if:
-		// - Not busy, enough water, small coin box not full, and 
+		// - Not paused, not busy, enough water, small coin box not full, and 
 		//   (large coin box isn't full OR no pending refill)
-		case x := <-whenRequest(!busy && currentWater >= CapacitySmall && smallCoinCount < MaxSmallCoins &&
-			(largeCoinCount < MaxLargeCoins || (largeCoinCount == MaxLargeCoins && len(start_refill) == 0)), start_request[SmallBottle]):
-			busy = true
-			smallCoinCount++                // Add 10-cent coin
-			currentWater -= CapacitySmall   // Deduct water
+		case x := <-whenRequest(!s.Paused && !s.Busy && s.Water >= CapacitySmall && s.Coins[SmallCoinsBox] < s.Boxes[SmallCoinsBox] &&
+			(s.Coins[LargeCoinsBox] < s.Boxes[LargeCoinsBox] || (s.Coins[LargeCoinsBox] >= s.Boxes[LargeCoinsBox] && len(start_refill) == 0)), start_request[SmallBottle]):
+			s = s.Apply(eventLog.Append("client", x.index, fillEvents[x.kind])) // Busy, add a 10-cent coin, deduct water
 			fmt.Printf("[waterStation] Client %d started filling a bottle of type %d\n", x.index, x.kind)
 			x.ack <- 1                      // Acknowledge client
 
 		// Handle LargeBottle request if:
-		// - Not busy, enough water, large coin box not full, no pending small requests,
+		// - Not paused, not busy, enough water, large coin box not full, no pending small requests,
 		//   and (small coin box isn't full OR no pending refill)
-		case x := <-whenRequest(!busy && currentWater >= CapacityLarge && largeCoinCount < MaxLargeCoins && 
+		case x := <-whenRequest(!s.Paused && !s.Busy && s.Water >= CapacityLarge && s.Coins[LargeCoinsBox] < s.Boxes[LargeCoinsBox] && 
 			len(start_request[SmallBottle]) == 0 &&
-			(smallCoinCount < MaxSmallCoins || (smallCoinCount == MaxSmallCoins && len(start_refill) == 0)), start_request[LargeBottle]):
-			busy = true
-			largeCoinCount++                // Add 20-cent coin
-			currentWater -= CapacityLarge   // Deduct water
+			(s.Coins[SmallCoinsBox] < s.Boxes[SmallCoinsBox] || (s.Coins[SmallCoinsBox] >= s.Boxes[SmallCoinsBox] && len(start_refill) == 0)), start_request[LargeBottle]):
+			s = s.Apply(eventLog.Append("client", x.index, fillEvents[x.kind])) // Busy, add a 20-cent coin, deduct water
 			fmt.Printf("[waterStation] Client %d started filling a bottle of type %d\n", x.index, x.kind)
 			x.ack <- 1                      // Acknowledge client
 
 		// Handle refill request from operator if:
 		// - Not stopped, not busy, and (coin boxes full/water empty OR no pending requests)
-		case <-when(!stop && !busy &&
-			((smallCoinCount == MaxSmallCoins || largeCoinCount == MaxLargeCoins || currentWater == 0) ||
+		case <-when(!s.Stop && !s.Busy &&
+			((s.Coins[SmallCoinsBox] >= s.Boxes[SmallCoinsBox] || s.Coins[LargeCoinsBox] >= s.Boxes[LargeCoinsBox] || s.Water == 0) ||
 				(len(start_request[SmallBottle])+len(start_request[LargeBottle]) == 0)), start_refill):
-			busy = true
-			currentWater = TankCapacity // Refill water
-			smallCoinCount = 0          // Reset coin counters
-			largeCoinCount = 0
+			s = s.Apply(eventLog.Append("", -1, REFILL_STARTED)) // Refill water, reset coin counters
 			fmt.Printf("[waterStation] Operator started refilling the tank and emptying coin boxes\n")
 			ack_operator <- 1           // Acknowledge operator
 
 		// Handle end of client request (bottle filled)
 		case x := <-end_request:
-			busy = false               // Free the station
+			s = s.Apply(eventLog.Append("client", x.index, FILL_ENDED)) // Free the station
 			x.ack <- 1                 // Final acknowledgment
 
 		// Handle end of refill process
 		case <-end_refill:
-			busy = false              // Free the station
+			s = s.Apply(eventLog.Append("", -1, REFILL_ENDED)) // Free the station
 			ack_operator <- 1         // Acknowledge operator
 
 		// Handle operator termination signal
 		case <-terminateOperator:
-			stop = true              // Stop further refills
+			s = s.Apply(eventLog.Append("", -1, OPERATOR_STOPPING)) // Stop further refills
 			fmt.Printf("[waterStation] All clients served, notifying operator to terminate\n")
 
 		// Handle termination of refill process
-		case <-when(stop, start_refill):
+		case <-when(s.Stop, start_refill):
 			ack_operator <- -1       // Signal operator to exit
 
+		// Handle an admin command
+		case cmd := <-adminCommands:
+			switch cmd.Op {
+			case ADMIN_PAUSE, ADMIN_RESUME:
+				kind := RESUMED
+				if cmd.Op == ADMIN_PAUSE {
+					kind = PAUSED
+				}
+				s = s.Apply(eventLog.Append("", -1, kind))
+				fmt.Printf("[waterStation] Admin: %s\n", cmd.Op)
+				cmd.Reply("paused: %v", s.Paused)
+			case ADMIN_SET:
+				box, limit := SmallCoinsBox, MaxSmallCoins
+				switch cmd.Name {
+				case "MaxSmallCoins":
+				case "MaxLargeCoins":
+					box, limit = LargeCoinsBox, MaxLargeCoins
+				default:
+					cmd.Fail("no capacity %s (MaxSmallCoins, MaxLargeCoins)", cmd.Name)
+					continue
+				}
+				n, err := cmd.Capacity(limit)
+				if err != nil {
+					continue
+				}
+				// One event per coin of room added or removed
+				for s.Boxes[box] < n {
+					s = s.Apply(eventLog.Append(boxActors[box], -1, BOX_GROWN))
+				}
+				for s.Boxes[box] > n {
+					s = s.Apply(eventLog.Append(boxActors[box], -1, BOX_SHRUNK))
+				}
+				fmt.Printf("[waterStation] Admin: the %s holds %d coins\n", boxActors[box], n)
+				cmd.Reply("%s: %s", cmd.Name, stationProjections[1].Of(s))
+			case ADMIN_OUT, ADMIN_IN:
+				cmd.Fail("nothing to take out of service in the water station: change the coin boxes with set MaxSmallCoins|MaxLargeCoins <n>")
+			default: // ADMIN_DUMP
+				cmd.Reply("paused: %v; %s; %s", s.Paused, stationProjections[0].Of(s), stationProjections[1].Of(s))
+			}
+
 		// Handle general termination
 		case <-terminate:
 			fmt.Printf("[waterStation] Shutting down!\n")
@@ -194,15 +346,22 @@ func waterStation() {
 func main() {
 	rand.Seed(time.Now().Unix()) // Seed random generator
 
-	// Initialize channels for small and large requests
-	for i := 0; i < 2; i++ {
-		start_request[i] = make(chan request, MAX_BUFFER)
+	// With -replay only rebuild the state of a logged run (see events.go)
+	if ReplayFromFlags(newStationState(), stationProjections...) {
+		return
 	}
+	eventLog = EventLogFromFlags() // With -events, log the events of waterStation()
 
-	// Start all client goroutines
-	for i := 0; i < MAX_CLIENTS; i++ {
-		go client(i)
-	}
+	// Initialize channels (small and large requests, operator, termination)
+	initChannels(MAX_BUFFER)
+
+	// Start all client goroutines at their arrival times (-arrivals and -mix flags,
+	// see workload.go): by default after 1-2 seconds, half small and half large bottles
+	workload := WorkloadFromFlags([]string{"SMALL", "LARGE"}, "spread:max=2", "SMALL=1,LARGE=1")
+	workload.Spawn(MAX_CLIENTS, client)
+
+	// Admin commands for the water station, with -admin (see admin.go)
+	stopAdmin := StartAdminFromFlags()
 
 	// Start operator and waterStation goroutines
 	go operator()
@@ -220,7 +379,16 @@ func main() {
 	<-done                // Wait for operator to exit
 	terminate <- true     // Signal waterStation to exit
 	<-done                // Wait for waterStation to exit
+	stopAdmin()
 	fmt.Printf("\n[MAIN] Water station is closed.\n")
+	if err := eventLog.Close(); err != nil {
+		fmt.Printf("[MAIN] The event log is incomplete: %v\n", err)
+	}
+
+	if err := CheckLeaks(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
 /*
@@ -246,6 +414,11 @@ KEY CONCEPTS AND LOGIC:
      - Small bottles have lower priority if large coins are full and a refill is pending.
      - Refill is triggered when coin boxes are full, water is empty, or no pending requests.
    - Ensures only one operation (client serving/refill) happens at a time via the busy flag.
+   - The state is a stationState changed only by events ("client 4 started filling a small bottle",
+     "refill started"), folded by stationState.Apply: -events logs them, and -replay rebuilds the
+     occupancy, inventory and revenue at any time of a logged run (see events.go).
+   - With -admin the station can be paused and its coin boxes resized while it runs (see admin.go):
+     the commands are events too ("10-cent box shrunk by the admin").
 
 5. Termination Sequence:
    - After all clients finish (all <-done received), main signals terminateOperator.
@@ -0,0 +1,359 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR waterStation() (WATER STATION)
+//
+// The tests feed waterStation() scripted sequences of clients and refills
+// under a virtual clock, and check who is served, in which order, when the
+// operator is refused, and that the station is idle at the end. TestEventLog
+// rebuilds the state of the station from the log of its events (events.go).
+// TestAdmin sends the station the commands of admin.go, logged as events.
+//
+// Synthetic clients repeat the cycle of client() without any sleep: even ids
+// ask for a small bottle, odd ids for a large one. A synthetic operator keeps
+// asking for refills with no pause, so the coin boxes and the tank are emptied
+// as soon as the guards allow it. At the end the operator and the station are
+// terminated with the same protocol as main().
+//
+// The benchmark reports:
+//   - grants/s:        bottles started per second;
+//   - p50-ns, p99-ns:  latency from start_request to its ack;
+//   - allocs/op:       allocations per grant.
+// It runs twice: with unbuffered request channels and with buffered ones
+// (MAX_BUFFER slots).
+//
+// Run with:
+//     go test -race examSol.go workload.go leaks.go events.go admin.go servertest_test.go examSol_test.go
+//     go test -run XXX -bench . examSol.go workload.go leaks.go events.go admin.go servertest_test.go examSol_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = 20 // Synthetic clients (the station serves one at a time)
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+// The tests use the buffered channels of main (MAX_BUFFER slots), without
+// which the priorities have no effect, but an unbuffered ack_operator, so that
+// every grant can be observed. The operator is the client with id operatorID.
+
+const operatorID = MAX_CLIENTS
+
+const (
+	opSmall    = iota // start_request[SmallBottle] <- request
+	opLarge           // start_request[LargeBottle] <- request
+	opEnd             // end_request <- request
+	opRefill          // start_refill <- 1
+	opRefilled        // end_refill <- 1
+	opStop            // terminateOperator <- true
+)
+
+var opNames = []string{"small bottle", "large bottle", "end", "refill", "end refill", "stop operator"}
+
+// station runs the steps of a test, remembering the request of every client.
+type station struct {
+	*script[int]
+	requests map[int]request
+}
+
+func (s *station) do(st step) {
+	switch st.op {
+	case opSmall, opLarge:
+		r := request{st.id, st.op - opSmall, make(chan int)}
+		s.requests[r.index] = r
+		s.call(r.index, r.ack, func() { start_request[r.kind] <- r })
+	case opEnd:
+		r := s.requests[st.id]
+		s.call(r.index, r.ack, func() { end_request <- r })
+	case opRefill:
+		s.call(operatorID, ack_operator, func() { start_refill <- 1 })
+	case opRefilled:
+		s.call(operatorID, ack_operator, func() { end_refill <- 1 })
+	case opStop:
+		go func() { terminateOperator <- true }()
+	}
+}
+
+func TestWaterStation(t *testing.T) {
+	silence(t)
+	var smalls []step // MaxSmallCoins small bottles, one at a time
+	for id := 0; id < MaxSmallCoins; id++ {
+		smalls = append(smalls, step{opSmall, id, []int{id}}, step{opEnd, id, []int{id}})
+	}
+	tests := []struct {
+		name    string
+		steps   []step
+		stopped bool // the operator has been terminated
+	}{
+		{"LargeBottle blocked while a SmallBottle request is pending", []step{
+			{opSmall, 0, []int{0}},
+			{opLarge, 1, nil},
+			{opSmall, 2, nil},
+			{opEnd, 0, []int{0, 2}},
+			{opEnd, 2, []int{2, 1}},
+			{opEnd, 1, []int{1}},
+		}, false},
+		{"the operator waits for the station to be idle", []step{
+			{opSmall, 0, []int{0}},
+			{opRefill, operatorID, nil},
+			{opEnd, 0, []int{0, operatorID}},
+			{opSmall, 1, nil},
+			{opRefilled, operatorID, []int{operatorID, 1}},
+			{opEnd, 1, []int{1}},
+		}, false},
+		{"waiting clients go before the refill", []step{
+			{opSmall, 0, []int{0}},
+			{opRefill, operatorID, nil},
+			{opSmall, 1, nil},
+			{opEnd, 0, []int{0, 1}},
+			{opEnd, 1, []int{1, operatorID}},
+			{opRefilled, operatorID, []int{operatorID}},
+		}, false},
+		{"a full coin box stops the clients until the refill", slices.Concat(
+			smalls,
+			[]step{
+				{opSmall, 30, nil},
+				{opRefill, operatorID, []int{operatorID}},
+				{opLarge, 31, nil},
+				{opRefilled, operatorID, []int{operatorID, 30}},
+				{opEnd, 30, []int{30, 31}},
+				{opEnd, 31, []int{31}},
+			},
+		), false},
+		{"the operator is refused once terminated", []step{
+			{opStop, 0, nil},
+			{opRefill, operatorID, []int{operatorID}},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				initChannels(MAX_BUFFER)
+				ack_operator = make(chan int)
+				go waterStation()
+				s := &station{newScript[int](t), map[int]request{}}
+				for _, st := range tt.steps {
+					s.do(st)
+					s.expect(st.String(), st.want)
+				}
+				s.finish()
+				s.checkIdle(tt.stopped)
+				terminate <- true
+				<-done
+			})
+		})
+	}
+}
+
+// TestEventLog runs the full coin box test with -events and rebuilds the state
+// of waterStation() from its log: at the end, and just before the refill.
+func TestEventLog(t *testing.T) {
+	silence(t)
+	path := filepath.Join(t.TempDir(), "station.jsonl")
+	synctest.Test(t, func(t *testing.T) {
+		var err error
+		if eventLog, err = OpenEventLog(path); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { eventLog = nil }()
+		initChannels(MAX_BUFFER)
+		ack_operator = make(chan int)
+		go waterStation()
+		s := &station{newScript[int](t), map[int]request{}}
+		var steps []step
+		for id := 0; id < MaxSmallCoins; id++ {
+			steps = append(steps, step{opSmall, id, nil}, step{opEnd, id, nil})
+		}
+		steps = append(steps, step{opSmall, 30, nil}, step{opRefill, operatorID, nil}, step{opLarge, 31, nil},
+			step{opRefilled, operatorID, nil}, step{opEnd, 30, nil}, step{opEnd, 31, nil})
+		for _, st := range steps {
+			s.do(st)
+			s.grants()
+		}
+		s.finish()
+		terminate <- true
+		<-done
+		if err := eventLog.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	events, err := ReadEvents(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := Fold(newStationState(), events)
+	want := stationState{Water: TankCapacity - CapacitySmall - CapacityLarge, Coins: [2]int{1, 1},
+		Serving: 31, Bottle: LargeBottle, Sold: [2]int{MaxSmallCoins + 1, 1}, Refills: 1,
+		Boxes: [2]int{MaxSmallCoins, MaxLargeCoins}}
+	if end != want {
+		t.Errorf("state at the end: %+v, want %+v", end, want)
+	}
+
+	refill := slices.IndexFunc(events, func(e DomainEvent) bool { return e.Kind == REFILL_STARTED })
+	if refill < 0 {
+		t.Fatal("no refill in the log")
+	}
+	before := Fold(newStationState(), Until(events, events[refill].At-time.Nanosecond))
+	if before.Coins[SmallCoinsBox] != MaxSmallCoins || before.Water != TankCapacity-MaxSmallCoins*CapacitySmall || before.Busy {
+		t.Errorf("state before the refill: %+v, want a full small coin box", before)
+	}
+}
+
+// TestAdmin sends the commands of admin.go to a waterStation() with -events,
+// and rebuilds its state from the log at the end.
+func TestAdmin(t *testing.T) {
+	silence(t)
+	path := filepath.Join(t.TempDir(), "station.jsonl")
+	synctest.Test(t, func(t *testing.T) {
+		var err error
+		if eventLog, err = OpenEventLog(path); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { eventLog = nil }()
+		initChannels(MAX_BUFFER)
+		ack_operator = make(chan int)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go waterStation()
+		s := &station{newScript[int](t), map[int]request{}}
+		play := func(steps ...step) {
+			t.Helper()
+			for _, st := range steps {
+				s.do(st)
+				s.expect(st.String(), st.want)
+			}
+		}
+
+		// Room for one 10-cent coin: client 1 waits, until the box is enlarged
+		s.admin(Admin, "set MaxSmallCoins 1",
+			"MaxSmallCoins: 50.0 of 50.0 liters of water, 0/1 coins of 10 cents, 0/20 coins of 20 cents", nil)
+		play(step{opSmall, 0, []int{0}}, step{opEnd, 0, []int{0}}, step{opSmall, 1, nil})
+		s.admin(Admin, "dump", "paused: false; free; 49.5 of 50.0 liters of water, 1/1 coins of 10 cents, 0/20 coins of 20 cents", nil)
+		s.admin(Admin, "set MaxSmallCoins 15",
+			"MaxSmallCoins: 49.5 of 50.0 liters of water, 1/15 coins of 10 cents, 0/20 coins of 20 cents", []int{1})
+		play(step{opEnd, 1, []int{1}})
+
+		// Paused: the operator refills, the clients wait for resume
+		s.admin(Admin, "pause", "paused: true", nil)
+		play(step{opRefill, operatorID, []int{operatorID}}, step{opSmall, 2, nil}, step{opRefilled, operatorID, []int{operatorID}})
+		s.admin(Admin, "resume", "paused: false", []int{2})
+		play(step{opEnd, 2, []int{2}})
+
+		s.admin(Admin, "set MaxSmallCoins 16", "error: MaxSmallCoins cannot be above 15", nil)
+		s.admin(Admin, "set TankCapacity 10", "error: no capacity TankCapacity (MaxSmallCoins, MaxLargeCoins)", nil)
+		s.admin(Admin, "out box 0",
+			"error: nothing to take out of service in the water station: change the coin boxes with set MaxSmallCoins|MaxLargeCoins <n>", nil)
+		s.finish()
+		s.checkIdle(false)
+		terminate <- true
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+		if err := eventLog.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	events, err := ReadEvents(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := newStationState()
+	want.Serving, want.Sold, want.Refills = -1, [2]int{3, 0}, 2
+	if end := Fold(newStationState(), events); end != want {
+		t.Errorf("state rebuilt from the log: %+v, want %+v", end, want)
+	}
+}
+
+// checkIdle verifies that the station is idle: a refill starts at once, or is
+// refused if the operator has been terminated, and nobody else was refused.
+func (s *station) checkIdle(stopped bool) {
+	s.t.Helper()
+	s.do(step{op: opRefill})
+	if got := s.grants(); !slices.Equal(got, []int{operatorID}) {
+		s.t.Fatalf("final state: refill answered %v, want the operator", got)
+	}
+	for id, v := range s.last {
+		if refused := stopped && id == operatorID; refused != (v == -1) {
+			s.t.Errorf("final state: client %d answered %d", id, v)
+		}
+	}
+	if !stopped {
+		s.do(step{op: opRefilled})
+		s.grants()
+	}
+	s.finish()
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+// benchOperator is operator() without the sleeps. It closes stopped when
+// the station tells it to exit.
+func benchOperator(stopped chan bool) {
+	for {
+		start_refill <- 1
+		if <-ack_operator == -1 {
+			close(stopped)
+			return
+		}
+		end_refill <- 1
+		<-ack_operator
+	}
+}
+
+func BenchmarkWaterStation(b *testing.B) {
+	silence(b)
+	for _, m := range bufferModes(MAX_BUFFER) {
+		b.Run(m.name, func(b *testing.B) {
+			initChannels(m.size)
+			go waterStation()
+			stopped := make(chan bool)
+			go benchOperator(stopped)
+
+			clients := make([]request, loadClients)
+			for id := range clients {
+				clients[id] = request{id, id % 2, make(chan int)}
+			}
+
+			measure(b, loadClients, func(id int) time.Duration {
+				r := clients[id]
+				t := time.Now()
+				start_request[r.kind] <- r
+				<-r.ack
+				d := time.Since(t)
+				end_request <- r
+				<-r.ack
+				return d
+			})
+
+			terminateOperator <- true
+			<-stopped
+			terminate <- true
+			<-done
+		})
+	}
+}
@@ -1,8 +1,12 @@
+// Run with:
+//     go run examSol.go workload.go leaks.go replicas.go admin.go
+
 package main
 
 import (
 	"fmt"
 	"math/rand"
+	"os"
 	"time"
 )
 
@@ -30,24 +34,47 @@ const (
 //Waiting ack (no interest for the data):     <- sender
 //Saving the information:                     variable (may be another channel) <- sender
 var (
-	doneTask            =make(chan bool)           //Signals task completion
-	doneWarehouse       =make(chan bool)           //Signals warehouse shutdown
-	closeWarehouse      =make(chan bool)           //Closes the warehouse
+	doneTask            chan bool                  //Signals task completion
+	doneWarehouse       chan bool                  //Signals warehouse shutdown
+	closeWarehouse      chan bool                  //Closes the warehouse
 
 	//Channels for workers (AR)
 	startwithdrawal     [3]chan request               //Start request channels by type
-	endwithdrawal       =make(chan request, 100)      //End of pickup notifications
+	endwithdrawal       chan request                  //End of pickup notifications
 
 	//Channels for suppliers
 	startDelivery       [2]chan request               //Start request channels by supplier
-	endDelivery         =make(chan request, 100)      //End of supply notifications
+	endDelivery         chan request                  //End of supply notifications
 )
 
+//Creates all the channels above.
+//'size' is the buffer of the worker/supplier request channels (100 in main).
+func initChannels(size int) {
+	doneTask = make(chan bool)
+	doneWarehouse = make(chan bool)
+	closeWarehouse = make(chan bool)
+
+	for i := 0; i < 3; i++ {
+		startwithdrawal[i] = make(chan request, size)
+	}
+	endwithdrawal = make(chan request, size)
+
+	for i := 0; i < 2; i++ {
+		startDelivery[i] = make(chan request, size)
+	}
+	endDelivery = make(chan request, size)
+}
+
+//Arrival times of the workers and mix of the batch types (see workload.go)
+var workload *Workload
+
 //Struct to represent a request
 type request struct {
-	id   int         //ID of the worker or supplier
-	tipo int         //Type of request (mask type or supplier type)
-	ack  chan int    //Acknowledgment channel
+	id    int        //ID of the worker or supplier
+	tipo  int        //Type of request (mask type or supplier type)
+	ack   chan int   //Acknowledgment channel
+	seq   int        //Sequence number of the request of the client (with the replicas, see replicas.go)
+	taken chan int   //Term of the leader that took the request (with the replicas)
 }
 
 //Simulates random sleep time
@@ -71,17 +98,15 @@ func when(condition bool, c chan request) chan request {
 
 //Worker function
 // Represents a worker responsible for withdrawing mask batches from the warehouse.
-// The worker randomly selects a batch type (mixed, FFP2, or surgical masks) and attempts to withdraw it.
+// The worker selects a batch type (mixed, FFP2, or surgical masks) following the workload mix and attempts to withdraw it.
 // If the requested type is unavailable, the worker waits for the replenishment.
 // The function handles the worker's withdrawal process over multiple cycles until completion.
 // Each withdrawal includes synchronization with the warehouse to ensure constraints are met.
 func AR(id int) { 
-	tipo := rand.Intn(3)                                                        
-	r := request{id, tipo, make(chan int)}
-	sleep(10)
+	r := request{id: id, tipo: -1, ack: make(chan int)}
 	cycles := rand.Intn(MAXCYCLES) + 1
 	for i := 0; i < cycles; i++ {                                               
-		tipo := rand.Intn(3)                                                      
+		tipo := workload.Class()
 		r.tipo = tipo
 		
 		if tipo == T_MIX {
@@ -92,13 +117,11 @@ func AR(id int) {
 			fmt.Printf("[Worker %d] requesting a surgical mask batch\n", id)
 		}
 		
-		startwithdrawal[tipo] <- r                                                  //Start a request and wait for the synchronization
-		<-r.ack                                                                     //Waiting for the acknowledgement 
+		call(startwithdrawal[tipo], &r)                                             //Start a request and wait for the acknowledgement (see replicas.go)
 		
 		sleep(10)                                                                   //Time to process the withdrawal
 		
-		endwithdrawal <- r                                                          //Notify end of withdrawal
-		<-r.ack
+		call(endwithdrawal, &r)                                                     //Notify end of withdrawal
 	}
 	fmt.Printf("[Worker %d] finished\n", id)
 	doneTask <- true
@@ -111,12 +134,11 @@ func AR(id int) {
 // Restocking can only begin if no workers are withdrawing from the same shelf.
 // The function also handles termination signals to stop the supplier's activity.
 func supplier(tipo int) { 
-	r := request{tipo, tipo, make(chan int)}
+	r := request{id: tipo, tipo: tipo, ack: make(chan int)}
 	for {                                                                                
 		sleep(5)
 		fmt.Printf("[Supplier %d] requesting restock for mask type %d\n", tipo, tipo)
-		startDelivery[tipo] <- r                                                            //No need for synchronization
-		flag := <-r.ack                                                                     //But need to know if he have to stop
+		flag := call(startDelivery[tipo], &r)                                               //No need for synchronization, but need to know if he have to stop
 		sleep(20)                                                                           //Time to restock the shelf
 		
 		if flag == 0 {                                                                      //Supplier needs to terminate
@@ -125,8 +147,7 @@ func supplier(tipo int) {
 			return
 		}
 		
-		endDelivery <- r
-		<-r.ack
+		call(endDelivery, &r)
 		fmt.Printf("[Supplier %d] finished restocking the shelf for mask type %d\n", tipo, tipo)
 		sleep(3)
 	}
@@ -151,10 +172,17 @@ func warehouse() {
 	var workersInSurgical = 0      //Number of workers withdrawing from the surgical shelf
 	var end = false                //Becomes true when all workers have finished
 
+	//Admin commands (see admin.go): while paused no withdrawal starts (the
+	//suppliers still restock); a shelf can be made smaller than the masks on
+	//it, which stay, and it is restocked once they drop below the new size
+	var paused = false
+	var capSM = SSM                //Size of the surgical shelf
+	var capFFP2 = SFFP2            //Size of the FFP2 shelf
+
 	for {
 		select {
 		//It works like: if the condition (first argument in the when) it's true, look at the channel and see if there is something, like an ack or a valure
-		case x := <-when(surgicalMasks >= BMM && ffp2Masks >= BMM && suppliersInSurgical == 0 && suppliersInFFP2 == 0, startwithdrawal[T_MIX]):
+		case x := <-when(!paused && surgicalMasks >= BMM && ffp2Masks >= BMM && suppliersInSurgical == 0 && suppliersInFFP2 == 0, startwithdrawal[T_MIX]):
 			workersInSurgical++
 			workersInFFP2++
 			surgicalMasks -= BMM
@@ -162,13 +190,13 @@ func warehouse() {
 			fmt.Printf("[Warehouse] Worker %d begins to withdraw a mixed batch\n", x.id)
 			x.ack <- 1
 
-		case x := <-when(ffp2Masks >= BFFP2 && suppliersInFFP2 == 0 && len(startwithdrawal[T_MIX]) == 0, startwithdrawal[T_FFP2]):
+		case x := <-when(!paused && ffp2Masks >= BFFP2 && suppliersInFFP2 == 0 && len(startwithdrawal[T_MIX]) == 0, startwithdrawal[T_FFP2]):
 			workersInFFP2++
 			ffp2Masks -= BFFP2
 			fmt.Printf("[Warehouse] Worker %d begins to withdraw an FFP2 batch\n", x.id)
 			x.ack <- 1
 
-		case x := <-when(surgicalMasks >= BSM && suppliersInSurgical == 0 && len(startwithdrawal[T_MIX]) == 0 && len(startwithdrawal[T_FFP2]) == 0, startwithdrawal[T_CHIR]):
+		case x := <-when(!paused && surgicalMasks >= BSM && suppliersInSurgical == 0 && len(startwithdrawal[T_MIX]) == 0 && len(startwithdrawal[T_FFP2]) == 0, startwithdrawal[T_CHIR]):
 			workersInSurgical++
 			surgicalMasks -= BSM
 			fmt.Printf("[Warehouse] Worker %d begins to withdraw a surgical mask batch\n", x.id)
@@ -186,13 +214,13 @@ func warehouse() {
 			fmt.Printf("[Warehouse] Worker %d has finished the withdrawal\n", x.id)
 			x.ack <- 1
 
-		case x := <-when(!end && surgicalMasks < SSM && suppliersInSurgical == 0 && workersInSurgical == 0 && ((surgicalMasks >= ffp2Masks && len(startDelivery[S_FFP2]) == 0) || surgicalMasks < ffp2Masks), startDelivery[S_SM]):
-			surgicalMasks = SSM
+		case x := <-when(!end && surgicalMasks < capSM && suppliersInSurgical == 0 && workersInSurgical == 0 && ((surgicalMasks >= ffp2Masks && len(startDelivery[S_FFP2]) == 0) || surgicalMasks < ffp2Masks), startDelivery[S_SM]):
+			surgicalMasks = capSM
 			suppliersInSurgical++
 			x.ack <- 1
 
-		case x := <-when(!end && ffp2Masks < SFFP2 && suppliersInFFP2 == 0 && workersInFFP2 == 0 && ((surgicalMasks < ffp2Masks && len(startDelivery[S_SM]) == 0) || surgicalMasks >= ffp2Masks), startDelivery[S_FFP2]):
-			ffp2Masks = SFFP2
+		case x := <-when(!end && ffp2Masks < capFFP2 && suppliersInFFP2 == 0 && workersInFFP2 == 0 && ((surgicalMasks < ffp2Masks && len(startDelivery[S_SM]) == 0) || surgicalMasks >= ffp2Masks), startDelivery[S_FFP2]):
+			ffp2Masks = capFFP2
 			suppliersInFFP2++
 			fmt.Printf("[Warehouse] Supplier %d has started restocking the shelf for type %d\n", x.id, x.tipo)
 			x.ack <- 1
@@ -215,6 +243,38 @@ func warehouse() {
 		case x := <-when(end, startDelivery[1]):
 			x.ack <- 0
 
+		//An admin command
+		case cmd := <-adminCommands:
+			switch cmd.Op {
+			case ADMIN_PAUSE, ADMIN_RESUME:
+				paused = cmd.Op == ADMIN_PAUSE
+				fmt.Printf("[Warehouse] Admin: %s\n", cmd.Op)
+				cmd.Reply("paused: %v", paused)
+			case ADMIN_SET:
+				switch cmd.Name {
+				case "SSM":
+					if n, err := cmd.Capacity(SSM); err == nil {
+						capSM = n
+						fmt.Printf("[Warehouse] Admin: the surgical shelf holds %d masks\n", capSM)
+						cmd.Reply("SSM: %d, surgical masks: %d", capSM, surgicalMasks)
+					}
+				case "SFFP2":
+					if n, err := cmd.Capacity(SFFP2); err == nil {
+						capFFP2 = n
+						fmt.Printf("[Warehouse] Admin: the FFP2 shelf holds %d masks\n", capFFP2)
+						cmd.Reply("SFFP2: %d, FFP2 masks: %d", capFFP2, ffp2Masks)
+					}
+				default:
+					cmd.Fail("no capacity %s (SSM, SFFP2)", cmd.Name)
+				}
+			case ADMIN_OUT, ADMIN_IN:
+				//The shelves are only counted
+				cmd.Fail("nothing to take out of service in the warehouse: change the shelves with set SSM|SFFP2 <n>")
+			default: //ADMIN_DUMP
+				cmd.Reply("paused: %v; surgical: %d/%d masks, %d workers, %d suppliers; FFP2: %d/%d masks, %d workers, %d suppliers",
+					paused, surgicalMasks, capSM, workersInSurgical, suppliersInSurgical, ffp2Masks, capFFP2, workersInFFP2, suppliersInFFP2)
+			}
+
 		case <-closeWarehouse:
 			end = true
 			fmt.Printf("[Warehouse] The warehouse is CLOSED!\n")
@@ -231,36 +291,40 @@ func warehouse() {
 //Ensures proper termination of the entire system.
 func main() {
 	rand.Seed(time.Now().Unix())
-	for i := 0; i < 2; i++ {
-		startDelivery[i] = make(chan request, 100)
-	}
-	for i := 0; i < 3; i++ {
-		startwithdrawal[i] = make(chan request, 100)
-	}
+	initChannels(100)
+	//Workers arrive following the workload (-arrivals and -mix flags, see workload.go):
+	//by default after 1-10 seconds each, with the three batch types equally likely
+	workload = WorkloadFromFlags([]string{"MIX", "FFP2", "CHIR"}, "spread:max=10", "MIX=1,FFP2=1,CHIR=1")
 	numWorkers := rand.Intn(MAXWORKERS) + 2              //Ensure at least 2 workers
 	fmt.Printf("Number of workers: %d\n", numWorkers)
 
-	// Launch warehouse and suppliers
-	go warehouse()
+	// Admin commands for the warehouse, with -admin (see admin.go)
+	stopAdmin := StartAdminFromFlags()
+
+	// Launch warehouse (or its replicas with -replicas, see replicas.go) and suppliers
+	startWarehouse()
 	go supplier(S_SM)
 	go supplier(S_FFP2)
 
-	// Launch workers
-	for i := 0; i < numWorkers; i++ {
-		go AR(i)
-	}
+	// Launch workers at their arrival times
+	workload.Spawn(numWorkers, func(id, _ int) { AR(id) })
 
 	// Wait for all workers to finish
 	for i := 0; i < numWorkers; i++ {
 		<-doneTask
 	}
 	fmt.Printf("[MAIN] All workers have finished!\n")
-	doneTask <- true                                     //Notify the warehouse that all workers are finished
+	workersDone()                                        //Notify the warehouse that all workers are finished
 
 	// Wait for both suppliers
 	for i := 0; i < 2; i++ {
 		<-doneTask
 	}
-	closeWarehouse <- true                               //Command the warehouse to terminate
-	<-doneWarehouse                                      //Wait for the warehouse to finish
+	stopWarehouse()                                      //Command the warehouse to terminate and wait for it
+	stopAdmin()
+
+	if err := CheckLeaks(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,737 @@
+// -----------------------------------------------------------------------------------
+// TESTS, FUZZ TARGET AND BENCHMARKS FOR warehouse() (MASK WAREHOUSE)
+//
+// The tests feed warehouse() scripted sequences of withdrawals and deliveries
+// under a virtual clock, and check who is served, in which order, when the
+// suppliers are refused, and that nobody is using the shelves at the end.
+// TestAdmin sends the warehouse the commands of admin.go.
+//
+// FuzzWarehouse turns arbitrary bytes into legal runs of workers and suppliers,
+// and fails on a broken invariant, a deadlock or a panic of the warehouse. Its
+// seed corpus is in testdata/fuzz/FuzzWarehouse.
+//
+// Synthetic workers repeat the cycle of AR() without any sleep, each one always
+// asking for the same batch type (id%3: mixed, FFP2, surgical). Two synthetic
+// suppliers restock their shelf as soon as the guards allow it. At the end the
+// warehouse is told that the workers have finished (doneTask), the suppliers
+// receive the stop flag and the warehouse is closed.
+//
+// TestReplicas and TestReplicaFailover run the replicas of replicas.go in place
+// of warehouse(), killing their leader: every withdrawal must be made once, and
+// the replicas must commit the same entries. TestReplicaAdmin sends the
+// replicas the commands of admin.go, across a new leader.
+//
+// The benchmark reports:
+//   - grants/s:        batches withdrawn per second;
+//   - p50-ns, p99-ns:  latency from startwithdrawal to its ack;
+//   - allocs/op:       allocations per grant.
+// It runs twice: with unbuffered request channels and with buffered ones
+// (100 slots).
+//
+// Run with:
+//     go test -race examSol.go workload.go leaks.go replicas.go admin.go servertest_test.go examSol_test.go
+//     go test -run XXX -fuzz FuzzWarehouse examSol.go workload.go leaks.go replicas.go admin.go servertest_test.go examSol_test.go
+//     go test -run XXX -bench . examSol.go workload.go leaks.go replicas.go admin.go servertest_test.go examSol_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"maps"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = MAXWORKERS // Synthetic workers
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+// The suppliers are the clients S_FFP2 and S_SM, the workers have ids from 10 on.
+
+const (
+	opMix       = iota // startwithdrawal[T_MIX] <- request
+	opFFP2             // startwithdrawal[T_FFP2] <- request
+	opChir             // startwithdrawal[T_CHIR] <- request
+	opEnd              // endwithdrawal <- request
+	opSupply           // startDelivery[id] <- request
+	opDelivered        // endDelivery <- request
+	opDone             // doneTask <- true
+)
+
+var opNames = []string{"mixed batch", "FFP2 batch", "surgical batch", "end", "supply", "end supply", "workers done"}
+
+// store runs the steps of a test, remembering the request of every client.
+type store struct {
+	*script[int]
+	requests map[int]request
+}
+
+func (s *store) do(st step) {
+	switch st.op {
+	case opMix, opFFP2, opChir:
+		r := request{id: st.id, tipo: st.op - opMix, ack: make(chan int)}
+		s.requests[r.id] = r
+		s.call(r.id, r.ack, func() { startwithdrawal[r.tipo] <- r })
+	case opSupply:
+		r := request{id: st.id, tipo: st.id, ack: make(chan int)}
+		s.requests[r.id] = r
+		s.call(r.id, r.ack, func() { startDelivery[r.tipo] <- r })
+	case opEnd, opDelivered:
+		r := s.requests[st.id]
+		c := endwithdrawal
+		if st.op == opDelivered {
+			c = endDelivery
+		}
+		s.call(r.id, r.ack, func() { c <- r })
+	case opDone:
+		go func() { doneTask <- true }()
+	}
+}
+
+func TestWarehouse(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name  string
+		steps []step
+		end   bool // the workers are done
+	}{
+		{"mixed batches first, then FFP2, then surgical", []step{
+			{opFFP2, 10, []int{10}},
+			{opEnd, 10, []int{10}},
+			{opSupply, S_FFP2, []int{S_FFP2}},
+			{opChir, 11, []int{11}},
+			{opMix, 12, nil},
+			{opFFP2, 13, nil},
+			// The guards are evaluated when the server enters select:
+			// the queued batches count from the next event on.
+			{opEnd, 11, []int{11}},
+			{opChir, 14, nil},
+			{opDelivered, S_FFP2, []int{S_FFP2, 12, 13, 14}},
+			{opEnd, 12, []int{12}},
+			{opEnd, 13, []int{13}},
+			{opEnd, 14, []int{14}},
+		}, false},
+		{"the supplier of the emptier shelf goes first", []step{
+			{opChir, 10, []int{10}},
+			{opEnd, 10, []int{10}},
+			{opFFP2, 11, []int{11}},
+			{opSupply, S_FFP2, nil},
+			{opFFP2, 12, []int{12}},
+			{opSupply, S_SM, nil},
+			{opEnd, 11, []int{11}},
+			{opEnd, 12, []int{12, S_FFP2, S_SM}},
+			{opDelivered, S_FFP2, []int{S_FFP2}},
+			{opDelivered, S_SM, []int{S_SM}},
+		}, false},
+		{"suppliers are refused once the workers are done", []step{
+			{opFFP2, 10, []int{10}},
+			{opEnd, 10, []int{10}},
+			{opDone, 0, nil},
+			{opSupply, S_FFP2, []int{S_FFP2}},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				initChannels(MAXWORKERS)
+				go warehouse()
+				s := &store{newScript[int](t), map[int]request{}}
+				for _, st := range tt.steps {
+					s.do(st)
+					s.expect(st.String(), st.want)
+				}
+				s.finish()
+				s.checkIdle(tt.end)
+				closeWarehouse <- true
+				<-doneWarehouse
+			})
+		})
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		initChannels(MAXWORKERS)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go warehouse()
+		s := &store{newScript[int](t), map[int]request{}}
+		play := func(steps ...step) {
+			t.Helper()
+			for _, st := range steps {
+				s.do(st)
+				s.expect(st.String(), st.want)
+			}
+		}
+
+		// An FFP2 shelf of 5 masks: restocked when they drop below 5, up to 5
+		s.admin(Admin, "set SFFP2 5", "SFFP2: 5, FFP2 masks: 10", nil)
+		play(
+			step{opFFP2, 10, []int{10}},
+			step{opEnd, 10, []int{10}},
+			step{opSupply, S_FFP2, nil},
+			step{opFFP2, 11, []int{11}},
+			step{opEnd, 11, []int{11, S_FFP2}},
+		)
+		s.admin(Admin, "dump", "paused: false; surgical: 10/10 masks, 0 workers, 0 suppliers; "+
+			"FFP2: 5/5 masks, 0 workers, 1 suppliers", nil)
+		play(step{opDelivered, S_FFP2, []int{S_FFP2}})
+		s.admin(Admin, "set SFFP2 10", "SFFP2: 10, FFP2 masks: 5", nil)
+
+		// Paused: the workers wait for resume
+		s.admin(Admin, "pause", "paused: true", nil)
+		play(step{opChir, 12, nil})
+		s.admin(Admin, "resume", "paused: false", []int{12})
+		play(step{opEnd, 12, []int{12}})
+
+		s.admin(Admin, "set SFFP2 11", "error: SFFP2 cannot be above 10", nil)
+		s.admin(Admin, "set BSM 1", "error: no capacity BSM (SSM, SFFP2)", nil)
+		s.admin(Admin, "out shelf 0",
+			"error: nothing to take out of service in the warehouse: change the shelves with set SSM|SFFP2 <n>", nil)
+		s.finish()
+		s.checkIdle(false)
+		closeWarehouse <- true
+		<-doneWarehouse
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// checkIdle verifies that nobody is using the shelves: after a mixed batch
+// both suppliers restock at once, or are refused (answer 0) if the workers
+// are done.
+func (s *store) checkIdle(end bool) {
+	s.t.Helper()
+	for _, st := range []step{
+		{opMix, 90, []int{90}},
+		{opEnd, 90, []int{90}},
+		{opSupply, S_FFP2, []int{S_FFP2}},
+		{opDelivered, S_FFP2, []int{S_FFP2}},
+		{opSupply, S_SM, []int{S_SM}},
+		{opDelivered, S_SM, []int{S_SM}},
+	} {
+		if end && st.op == opDelivered {
+			continue
+		}
+		s.do(st)
+		s.expect("final state: "+st.String(), st.want)
+		if st.op == opSupply && (s.last[st.id] == 0) != end {
+			s.t.Errorf("final state: supplier %d answered %d", st.id, s.last[st.id])
+		}
+	}
+	s.finish()
+}
+
+// ============================================================
+//                          REPLICAS
+// ============================================================
+
+const replicaCycles = 4 // Withdrawals of every synthetic worker of TestReplicas
+
+// startReplicas starts n replicas for a test, in place of warehouse().
+func startReplicas(t *testing.T, n int) *cluster {
+	initChannels(MAXWORKERS)
+	replicas = newCluster(n)
+	replicas.start()
+	t.Cleanup(func() { replicas = nil })
+	return replicas
+}
+
+// TestReplicas kills the leader every second while the synthetic workers make
+// their withdrawals, pausing between their requests, and checks that every
+// withdrawal has been made once, that the replicas committed the same entries
+// and that the invariants of the shelves held after every one of them.
+func TestReplicas(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		c := startReplicas(t, 3)
+		c.chaos(time.Second)
+		stopped := [2]chan bool{make(chan bool), make(chan bool)}
+		go benchSupplier(S_FFP2, stopped[S_FFP2])
+		go benchSupplier(S_SM, stopped[S_SM])
+
+		var wg sync.WaitGroup
+		var want [3]int
+		for id := range loadClients {
+			want[id%3] += replicaCycles
+			wg.Go(func() {
+				r := request{id: id, tipo: id % 3}
+				for range replicaCycles {
+					call(startwithdrawal[r.tipo], &r)
+					time.Sleep(time.Duration(rand.Intn(500)) * time.Millisecond)
+					call(endwithdrawal, &r)
+					time.Sleep(time.Duration(rand.Intn(500)) * time.Millisecond)
+				}
+			})
+		}
+		wg.Wait()
+		workersDone()
+		<-stopped[S_FFP2]
+		<-stopped[S_SM]
+		c.stop()
+
+		if err := c.verify(); err != nil {
+			t.Fatal(err)
+		}
+		s := c.latest().state
+		if s.withdrawn != want {
+			t.Errorf("batches withdrawn by type: %v, want %v", s.withdrawn, want)
+		}
+		for id := range loadClients {
+			if got := s.sessions[id].seq; got != 2*replicaCycles {
+				t.Errorf("worker %d: %d requests served, want %d", id, got, 2*replicaCycles)
+			}
+		}
+		if len(s.withdrawals) > 0 || !s.end {
+			t.Errorf("withdrawals in progress at the end: %v, workers done: %v", s.withdrawals, s.end)
+		}
+		if c.kills < 3 || c.leaders <= c.kills {
+			t.Errorf("%d leaders elected, %d killed: want a new leader after every one of at least 3 kills", c.leaders, c.kills)
+		}
+		t.Logf("%d leaders elected, %d killed, %d requests sent again, %d entries", c.leaders, c.kills, resent.Load(), c.latest().commit)
+	})
+}
+
+// TestReplicaFailover kills the leader with a withdrawal it cannot commit, its
+// followers being dead: the withdrawal is made once, by the next leader, whose
+// log replaces the entry of the old one when it comes back. A copy of the
+// request, sent after its answer, is answered again and not applied.
+func TestReplicaFailover(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		c := startReplicas(t, 3)
+		time.Sleep(time.Second)
+		first := c.currentLeader()
+		if first < 0 {
+			t.Fatal("no leader elected")
+		}
+		for id := range c.replicas {
+			if id != first {
+				c.kill(id)
+			}
+		}
+
+		r := request{id: 10, tipo: T_MIX}
+		answer := make(chan int)
+		go func() { answer <- call(startwithdrawal[T_MIX], &r) }()
+		time.Sleep(time.Second)
+		synctest.Wait()
+		select {
+		case v := <-answer:
+			t.Fatalf("answered %d without a majority", v)
+		default:
+		}
+		c.kill(first)
+		old := c.replicas[first]
+		if last := len(old.log) - 1; old.log[last].op != OP_START_WITHDRAWAL || old.commit == last {
+			t.Fatalf("log of the leader killed: %+v, %d entries committed", old.log, old.commit)
+		}
+
+		for id := range c.replicas {
+			if id != first {
+				c.restart(id)
+			}
+		}
+		if v := <-answer; v != 1 {
+			t.Errorf("answered %d, want 1", v)
+		}
+		c.restart(first)
+		time.Sleep(time.Second)
+		startwithdrawal[T_MIX] <- r
+		if v := <-r.ack; v != 1 {
+			t.Errorf("copy of the request answered %d, want 1", v)
+		}
+		time.Sleep(time.Second)
+		c.stop()
+
+		if err := c.verify(); err != nil {
+			t.Fatal(err)
+		}
+		for _, rep := range c.replicas {
+			withdrawals := 0
+			for _, e := range rep.log {
+				if e.op == OP_START_WITHDRAWAL {
+					withdrawals++
+				}
+			}
+			if !slices.Equal(rep.log, c.replicas[first].log) || rep.commit != len(rep.log)-1 || withdrawals != 1 {
+				t.Errorf("replica %d: %d entries, %d committed, %d withdrawals: want the log of the others with one withdrawal",
+					rep.id, len(rep.log)-1, rep.commit, withdrawals)
+			}
+			if rep.state.withdrawn != [3]int{T_MIX: 1} {
+				t.Errorf("replica %d: batches withdrawn %v", rep.id, rep.state.withdrawn)
+			}
+		}
+	})
+}
+
+// TestReplicaAdmin sends admin commands to the replicas: they are entries of the
+// log, kept by the next leader, and a command of a leader killed before it
+// could commit it fails, and can be sent again.
+func TestReplicaAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := startReplicas(t, 3)
+		admin := func(line string) chan string {
+			answer := make(chan string, 1)
+			go func() {
+				text, err := Admin(line)
+				if err != nil {
+					text = "error: " + err.Error()
+				}
+				answer <- text
+			}()
+			return answer
+		}
+		expect := func(answer chan string, line, want string) {
+			t.Helper()
+			if got := <-answer; got != want {
+				t.Errorf("admin %s: %q, want %q", line, got, want)
+			}
+		}
+		time.Sleep(time.Second)
+
+		// Paused, the withdrawal waits, also under the next leader
+		expect(admin("set SSM 4"), "set SSM 4", "SSM: 4, surgical masks: 10")
+		expect(admin("pause"), "pause", "paused: true")
+		r := request{id: 10, tipo: T_CHIR}
+		answer := make(chan int)
+		go func() { answer <- call(startwithdrawal[T_CHIR], &r) }()
+		first := c.currentLeader()
+		c.kill(first)
+		time.Sleep(time.Second)
+		synctest.Wait()
+		select {
+		case v := <-answer:
+			t.Fatalf("withdrawal answered %d while paused", v)
+		default:
+		}
+		expect(admin("dump"), "dump", "paused: true; surgical: 10/4 masks, 0 workers, 0 suppliers; FFP2: 10/10 masks, 0 workers, 0 suppliers")
+		expect(admin("resume"), "resume", "paused: false")
+		if v := <-answer; v != 1 {
+			t.Errorf("withdrawal answered %d, want 1", v)
+		}
+		expect(admin("set SSM 11"), "set SSM 11", "error: SSM cannot be above 10")
+
+		// A leader without followers cannot commit: killed, it fails the command
+		c.restart(first)
+		time.Sleep(time.Second)
+		second := c.currentLeader()
+		for id := range c.replicas {
+			if id != second {
+				c.kill(id)
+			}
+		}
+		set := admin("set SSM 10")
+		time.Sleep(time.Second)
+		synctest.Wait()
+		if len(set) > 0 {
+			t.Fatalf("set SSM answered without a majority: %q", <-set)
+		}
+		c.kill(second)
+		expect(set, "set SSM 10", "error: the leader of the warehouse changed: send the command again")
+		for id := range c.replicas {
+			c.restart(id)
+		}
+		expect(admin("set SSM 10"), "set SSM 10", "SSM: 10, surgical masks: 7")
+
+		call(endwithdrawal, &r)
+		time.Sleep(time.Second)
+		c.stop()
+		stop()
+		adminCommands, adminClosed = nil, nil
+		if err := c.verify(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// ============================================================
+//                          FUZZING
+// ============================================================
+
+// FuzzWarehouse decodes its input into actions of workers and suppliers, two
+// bytes each: the first one selects the op, the second one who performs it.
+// Actions the real goroutines could not perform, like a worker ending a
+// withdrawal it never started, are skipped, and doneTask ends the run once no
+// worker is busy, as in main: every input is a legal run of the warehouse.
+// The invariants of the shelves are checked after every action; at the end
+// every worker must be served, the suppliers must be refused once doneTask
+// has been sent, and nobody must be using the shelves (checkIdle, when they
+// hold a mixed batch). A panic of warehouse() crashes the test binary and is
+// reported with its input. Like the tests, it runs under the virtual clock of
+// synctest: waiting for the acks of an action takes no real time.
+//
+// The seed corpus and the failing inputs are in testdata/fuzz/FuzzWarehouse,
+// where go test runs them again as regression tests.
+func FuzzWarehouse(f *testing.F) {
+	silence(f)
+	f.Add([]byte{})
+	f.Add([]byte{opFFP2, 0, opEnd, 0, opSupply, S_FFP2, opChir, 1, opMix, 2, opFFP2, 3, opEnd, 1, opDelivered, S_FFP2})
+	f.Add([]byte{opChir, 0, opEnd, 0, opFFP2, 1, opSupply, S_FFP2, opSupply, S_SM, opEnd, 1, opDone, 0, opSupply, S_FFP2})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		synctest.Test(t, func(t *testing.T) {
+			initChannels(MAXWORKERS)
+			go warehouse()
+			t.Cleanup(func() {
+				closeWarehouse <- true
+				<-doneWarehouse
+			})
+			m := &warehouseModel{
+				store:   &store{newScript[int](t), map[int]request{}},
+				where:   map[int]int{},
+				shelves: [2]int{SFFP2, SSM},
+			}
+			for i := 0; i+1 < len(data) && i < 2*fuzzActions && !m.end; i += 2 {
+				op, who := int(data[i])%len(opNames), int(data[i+1])
+				if action, ok := m.apply(op, who); ok {
+					m.check(action)
+				}
+			}
+			m.drain()
+			m.finish()
+			if m.shelves[S_FFP2] >= BMM && m.shelves[S_SM] >= BMM {
+				m.checkIdle(true)
+			}
+		})
+	})
+}
+
+const fuzzActions = 200 // Actions decoded from an input, at most
+
+// Where a worker or a supplier is, as seen from the acks of warehouse().
+const (
+	outside  = iota
+	entering // waiting for the ack of its withdrawal or delivery
+	inside
+	leaving // waiting for the ack of its end
+)
+
+// Capacity of the shelves, indexed by supplier type.
+var shelfSize = [2]int{S_FFP2: SFFP2, S_SM: SSM}
+
+// Masks taken from the shelves by each batch type, indexed by supplier type.
+var batches = [3][2]int{
+	T_MIX:  {S_FFP2: BMM, S_SM: BMM},
+	T_FFP2: {S_FFP2: BFFP2},
+	T_CHIR: {S_SM: BSM},
+}
+
+// warehouseModel follows a warehouse() driven by a fuzz target.
+type warehouseModel struct {
+	*store
+	where   map[int]int // where every worker and supplier is
+	shelves [2]int      // masks on the shelves, indexed by supplier type
+	end     bool        // doneTask has been sent
+}
+
+// apply performs op for worker (or supplier) who, and describes it, or
+// reports false if op cannot be performed by it.
+func (m *warehouseModel) apply(op, who int) (string, bool) {
+	id := 10 + who%MAXWORKERS
+	if op == opSupply || op == opDelivered {
+		id = who % 2
+	}
+	action := step{op: op, id: id}.String()
+	switch op {
+	case opMix, opFFP2, opChir, opSupply:
+		if m.where[id] != outside {
+			return action, false
+		}
+		m.where[id] = entering
+	case opEnd, opDelivered:
+		if m.where[id] != inside {
+			return action, false
+		}
+		m.where[id] = leaving
+	case opDone:
+		if m.end || m.busy() {
+			return action, false
+		}
+		m.end = true
+	}
+	m.do(step{op: op, id: id})
+	for _, id := range m.grants() {
+		m.granted(action, id)
+	}
+	return action, true
+}
+
+// granted updates the model after the ack received by id.
+func (m *warehouseModel) granted(action string, id int) {
+	m.t.Helper()
+	switch {
+	case m.where[id] == leaving:
+		m.where[id] = outside
+	case id >= 10:
+		m.where[id] = inside
+		for shelf, n := range batches[m.requests[id].tipo] {
+			m.shelves[shelf] -= n
+		}
+	case m.last[id] == 0:
+		if !m.end {
+			m.t.Fatalf("after %s: supplier %d refused before doneTask", action, id)
+		}
+		m.where[id] = outside
+	case m.end:
+		m.t.Fatalf("after %s: supplier %d restocks after doneTask", action, id)
+	case m.shelves[id] == shelfSize[id]:
+		m.t.Fatalf("after %s: supplier %d restocks a full shelf", action, id)
+	default:
+		m.where[id] = inside
+		m.shelves[id] = shelfSize[id]
+	}
+}
+
+// busy reports whether some worker is withdrawing or waiting to.
+func (m *warehouseModel) busy() bool {
+	for id, w := range m.where {
+		if id >= 10 && w != outside {
+			return true
+		}
+	}
+	return false
+}
+
+// check verifies the invariants of the shelves after action.
+func (m *warehouseModel) check(action string) {
+	m.t.Helper()
+	var workers, suppliers [2]int
+	for id, w := range m.where {
+		switch {
+		case w != inside && w != leaving:
+		case id < 10:
+			suppliers[id]++
+		default:
+			for shelf, n := range batches[m.requests[id].tipo] {
+				if n > 0 {
+					workers[shelf]++
+				}
+			}
+		}
+	}
+	for shelf := range m.shelves {
+		switch {
+		case m.shelves[shelf] < 0 || m.shelves[shelf] > shelfSize[shelf]:
+			m.t.Fatalf("after %s: %d masks on shelf %d", action, m.shelves[shelf], shelf)
+		case suppliers[shelf] > 0 && workers[shelf] > 0:
+			m.t.Fatalf("after %s: %d workers on shelf %d while it is restocked", action, workers[shelf], shelf)
+		}
+	}
+}
+
+// drain ends the run like main: the workers inside end their withdrawals
+// and the suppliers keep restocking until no worker is busy; then the
+// shelves are filled up and doneTask is sent, which refuses the waiting
+// suppliers. It fails if warehouse()
+// stops answering with some worker still waiting.
+func (m *warehouseModel) drain() {
+	m.t.Helper()
+	for m.busy() {
+		before := maps.Clone(m.where)
+		for _, id := range slices.Sorted(maps.Keys(before)) {
+			switch {
+			case before[id] == inside && id >= 10:
+				m.apply(opEnd, id-10)
+			case before[id] == inside:
+				m.apply(opDelivered, id)
+			}
+			m.check("draining")
+		}
+		for id := range 2 {
+			if !m.end && before[id] == outside {
+				m.apply(opSupply, id)
+				m.check("draining")
+			}
+		}
+		if maps.Equal(before, m.where) {
+			var waiting []int
+			for _, id := range slices.Sorted(maps.Keys(m.where)) {
+				if m.where[id] == entering {
+					waiting = append(waiting, id)
+				}
+			}
+			m.t.Fatalf("deadlock: %v still waiting for warehouse()", waiting)
+		}
+	}
+	for id := range 2 { // full shelves for checkIdle
+		if m.where[id] == outside && !m.end {
+			m.apply(opSupply, id)
+		}
+		if m.where[id] == inside {
+			m.apply(opDelivered, id)
+		}
+		m.check("draining")
+	}
+	m.apply(opDone, 0)
+	for id := range 2 {
+		if m.where[id] != outside {
+			m.t.Fatalf("supplier %d still waiting after doneTask", id)
+		}
+	}
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+// benchSupplier is supplier() without the sleeps. It closes stopped when
+// the warehouse answers with the stop flag. It serves the replicas too.
+func benchSupplier(tipo int, stopped chan bool) {
+	r := request{id: tipo, tipo: tipo, ack: make(chan int)}
+	for {
+		if call(startDelivery[tipo], &r) == 0 {
+			close(stopped)
+			return
+		}
+		call(endDelivery, &r)
+	}
+}
+
+func BenchmarkWarehouse(b *testing.B) {
+	silence(b)
+	for _, m := range bufferModes(100) {
+		b.Run(m.name, func(b *testing.B) {
+			initChannels(m.size)
+			go warehouse()
+			stopped := [2]chan bool{make(chan bool), make(chan bool)}
+			go benchSupplier(S_FFP2, stopped[S_FFP2])
+			go benchSupplier(S_SM, stopped[S_SM])
+
+			workers := make([]request, loadClients)
+			for id := range workers {
+				workers[id] = request{id: id, tipo: id % 3, ack: make(chan int)}
+			}
+
+			measure(b, loadClients, func(id int) time.Duration {
+				r := workers[id]
+				t := time.Now()
+				startwithdrawal[r.tipo] <- r
+				<-r.ack
+				d := time.Since(t)
+				endwithdrawal <- r
+				<-r.ack
+				return d
+			})
+
+			doneTask <- true
+			<-stopped[S_FFP2]
+			<-stopped[S_SM]
+			closeWarehouse <- true
+			<-doneWarehouse
+		})
+	}
+}
@@ -0,0 +1,987 @@
+// -----------------------------------------------------------------------------------
+// REPLICATED WAREHOUSE: REPLICAS OF warehouse() WITH A RAFT-LITE LOG
+//
+// warehouse() keeps the shelves in the variables of one goroutine: if it dies,
+// the masks, the withdrawals in progress and the workers waiting die with it.
+// With -replicas the warehouse is run by n goroutines (3 in the tests), each one
+// with a copy of its state, kept equal through a replicated log:
+//   - the state (shelfState) is the one of warehouse(): surgicalMasks,
+//     ffp2Masks, the suppliers and the workers on every shelf and end, plus the
+//     withdrawals in progress (the batch type of every worker inside) and the
+//     last request served for every client;
+//   - it changes only by applying the entries of the log, in order: replicas
+//     that applied the same entries have the same state;
+//   - one replica is the leader, the only one reading the channels of
+//     warehouse(), with the guards of warehouse() evaluated on its state. A
+//     request it takes is appended to its log as an entry and sent to the other
+//     replicas (APPEND); once a majority of the replicas has it, the entry is
+//     committed: the leader applies it and answers the client.
+// AR() and supplier() send to the same channels as before (through call()) and
+// are answered by whoever is the leader: the channels are the address of the
+// warehouse, not of a replica. An entry keeps the request, with the channel of
+// the answer: a leader answers the entries it applies, even those appended by
+// an old leader.
+//
+// The election is the one of Raft, without snapshots and with one entry in
+// flight at a time:
+//   - the leader sends an APPEND at least every HEARTBEAT, empty if it has
+//     nothing new;
+//   - a follower that hears nothing from a leader for its election timeout
+//     (from ELECTION_TIMEOUT to twice that, at random) starts a new term and
+//     asks the others for their vote (VOTE_REQUEST);
+//   - a replica gives one vote per term, and only to a candidate whose log is
+//     at least as recent as its own: the new leader has every committed entry;
+//   - a candidate with the votes of a majority is the new leader. It appends an
+//     empty entry (NOOP) and, once that is committed, i.e. once the entries of
+//     the old leaders in its log are committed, applied and answered, it tells
+//     the clients and serves them.
+//
+// A leader killed after taking a request from a channel loses it, unless the
+// entry reached the log of the new leader. The leader that takes a request
+// tells its client, with its term: a client with no answer sends its request
+// again when the leader that took it is no longer the leader, and only then.
+// The requests still in the channels stay there for the new leader, and the
+// guards never meet a copy of a request already served, which could wait
+// there forever: the request of a withdrawal is applied, and the guard of its
+// copy becomes false. Copies can still be made (a leader that finds out that
+// it is not the leader any more lets its client send again, while the entry
+// may reach the new leader), so every request of a client has a sequence
+// number: a request already served is answered again without a new entry, and
+// an entry already applied for the same request is not applied twice. No
+// withdrawal is lost or made twice, and the clients see every answer once: the
+// answers go to a buffered channel made for every request, with a send that
+// never blocks, and the answers to the copies of a request are dropped.
+//
+// A killed replica stops answering. Its term, its vote and its log survive (they
+// would be on disk), the rest is lost: it comes back as a follower, the leader
+// repairs its log and it applies the committed entries again from the first
+// one. With -kill the leader is killed every interval, once the replica killed
+// the time before is back: the replicas survive one death at a time. At the end
+// the committed entries of the replicas are compared, and replayed checking the
+// invariants of the shelves after every one.
+//
+// The admin commands (see admin.go) are read by the leader too. dump is
+// answered at once; pause, resume and set are entries of the log, with the
+// command, answered by the leader that applies them. A leader that stops
+// being the leader with commands not applied fails them: the admin sends them
+// again, and a command applied twice changes nothing more (an answer after
+// the failure is dropped).
+//
+// Run with:
+//     go run examSol.go workload.go leaks.go replicas.go admin.go -replicas 3
+//     go run examSol.go workload.go leaks.go replicas.go admin.go -replicas 3 -kill 15s
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	replicasFlag = flag.Int("replicas", 0, "run the warehouse as `n` replicas with a replicated log (see replicas.go); 0 runs warehouse()")
+	killFlag     = flag.Duration("kill", 0, "with -replicas, kill the leader every `interval`, bringing back the replica killed before")
+)
+
+// Timing of the replicas.
+const (
+	HEARTBEAT        = 50 * time.Millisecond  // the leader sends an APPEND at least this often
+	ELECTION_TIMEOUT = 300 * time.Millisecond // a follower waits from this to twice this for the leader
+	INBOX            = 64                     // messages waiting for a replica, at most: the others are lost
+)
+
+// ============================================================
+//                          CLIENTS
+// ============================================================
+
+// The replicas running the warehouse, nil with warehouse().
+var replicas *cluster
+
+// Requests sent again, their leader being gone.
+var resent atomic.Int64
+
+// call sends r on c and returns the answer of the warehouse. With the replicas
+// every call is a new request of the client: it gets the next sequence number
+// and its own answer channel, and it is sent again if the leader that takes it
+// is no longer the leader before answering.
+func call(c chan request, r *request) int {
+	if replicas == nil {
+		c <- *r
+		return <-r.ack
+	}
+	r.seq++
+	r.ack = make(chan int, 1)
+	for {
+		r.taken = make(chan int, 1)
+		c <- *r
+		if v, ok := replicas.wait(r); ok {
+			return v
+		}
+		resent.Add(1)
+	}
+}
+
+// acknowledge answers v to the client of x, unless it has an answer already.
+func acknowledge(x request, v int) {
+	select {
+	case x.ack <- v:
+	default:
+	}
+}
+
+// take tells the client of x that the leader of term took it.
+func take(x request, term int) {
+	select {
+	case x.taken <- term:
+	default:
+	}
+}
+
+// startWarehouse starts warehouse(), or the replicas with -replicas.
+func startWarehouse() {
+	if *replicasFlag == 0 {
+		go warehouse()
+		return
+	}
+	replicas = newCluster(*replicasFlag)
+	replicas.start()
+	if *killFlag > 0 {
+		replicas.chaos(*killFlag)
+	}
+}
+
+// workersDone tells the warehouse that the workers have finished.
+func workersDone() {
+	if replicas == nil {
+		doneTask <- true
+		return
+	}
+	call(replicas.finished, &request{id: -1, tipo: -1})
+}
+
+// stopWarehouse terminates the warehouse, once the suppliers are done. The
+// replicas report the elections and the withdrawals, and the run fails if
+// their logs disagree.
+func stopWarehouse() {
+	if replicas == nil {
+		closeWarehouse <- true
+		<-doneWarehouse
+		return
+	}
+	replicas.stop()
+	replicas.report(os.Stdout)
+	if err := replicas.verify(); err != nil {
+		fmt.Fprintf(os.Stderr, "\nLOG CHECK: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ============================================================
+//                          STATE
+// ============================================================
+
+// Operations of the entries of the log.
+const (
+	OP_NOOP             = iota // appended by a new leader
+	OP_START_WITHDRAWAL        // startwithdrawal[tipo]
+	OP_END_WITHDRAWAL          // endwithdrawal
+	OP_START_DELIVERY          // startDelivery[id], refused once the workers are done
+	OP_END_DELIVERY            // endDelivery
+	OP_WORKERS_DONE            // doneTask in warehouse()
+	OP_ADMIN                   // adminCommands: pause, resume or set
+)
+
+// entry is an entry of the log: a request taken by the leader of term.
+type entry struct {
+	term int
+	op   int // one of the OP_* constants
+	req  request
+	cmd  AdminCommand // OP_ADMIN
+}
+
+// session is the last request applied for a client, and its answer.
+type session struct {
+	seq, answer int
+}
+
+// shelfState is the state of warehouse(), changed only by apply.
+type shelfState struct {
+	surgicalMasks       int
+	ffp2Masks           int
+	suppliersInFFP2     int
+	suppliersInSurgical int
+	workersInFFP2       int
+	workersInSurgical   int
+	end                 bool
+	paused              bool   // by an admin command
+	shelf               [2]int // sizes of the shelves, by supplier: SFFP2 and SSM unless set
+
+	withdrawals map[int]int     // batch type withdrawn by the workers inside
+	sessions    map[int]session // by client (see clientOf)
+	withdrawn   [3]int          // batches withdrawn, by type
+	restocks    [2]int          // restocks, by supplier
+}
+
+func newShelfState() *shelfState {
+	return &shelfState{
+		surgicalMasks: SSM,
+		ffp2Masks:     SFFP2,
+		shelf:         [2]int{S_FFP2: SFFP2, S_SM: SSM},
+		withdrawals:   map[int]int{},
+		sessions:      map[int]session{},
+	}
+}
+
+// clientOf returns the client of a request of op in the sessions: the id of a
+// worker, or a negative number for the suppliers and main.
+func clientOf(op, id int) int {
+	switch op {
+	case OP_START_DELIVERY, OP_END_DELIVERY:
+		return -1 - id
+	case OP_WORKERS_DONE:
+		return -3
+	}
+	return id
+}
+
+// served reports whether the request x of op has been applied already, and
+// its answer.
+func (s *shelfState) served(op int, x request) (int, bool) {
+	last, ok := s.sessions[clientOf(op, x.id)]
+	return last.answer, ok && x.seq <= last.seq
+}
+
+// canWithdraw is the guard of warehouse() for a batch of type tipo.
+func (s *shelfState) canWithdraw(tipo int) bool {
+	if s.paused {
+		return false
+	}
+	switch tipo {
+	case T_MIX:
+		return s.surgicalMasks >= BMM && s.ffp2Masks >= BMM && s.suppliersInSurgical == 0 && s.suppliersInFFP2 == 0
+	case T_FFP2:
+		return s.ffp2Masks >= BFFP2 && s.suppliersInFFP2 == 0 && len(startwithdrawal[T_MIX]) == 0
+	}
+	return s.surgicalMasks >= BSM && s.suppliersInSurgical == 0 && len(startwithdrawal[T_MIX]) == 0 && len(startwithdrawal[T_FFP2]) == 0
+}
+
+// canDeliver is the guard of warehouse() for a restock by supplier tipo.
+func (s *shelfState) canDeliver(tipo int) bool {
+	if tipo == S_SM {
+		return !s.end && s.surgicalMasks < s.shelf[S_SM] && s.suppliersInSurgical == 0 && s.workersInSurgical == 0 &&
+			((s.surgicalMasks >= s.ffp2Masks && len(startDelivery[S_FFP2]) == 0) || s.surgicalMasks < s.ffp2Masks)
+	}
+	return !s.end && s.ffp2Masks < s.shelf[S_FFP2] && s.suppliersInFFP2 == 0 && s.workersInFFP2 == 0 &&
+		((s.surgicalMasks < s.ffp2Masks && len(startDelivery[S_SM]) == 0) || s.surgicalMasks >= s.ffp2Masks)
+}
+
+// apply applies e, as warehouse() does with its request, and returns the
+// answer for the client and what happened. A request already applied changes
+// nothing and gets the same answer.
+func (s *shelfState) apply(e entry) (int, string) {
+	switch e.op {
+	case OP_NOOP:
+		return 0, ""
+	case OP_ADMIN:
+		// Not a client: applied again, a command changes nothing more
+		switch e.cmd.Op {
+		case ADMIN_PAUSE, ADMIN_RESUME:
+			s.paused = e.cmd.Op == ADMIN_PAUSE
+			return 0, "Admin: " + e.cmd.Op
+		case ADMIN_SET:
+			if e.cmd.Name == "SSM" {
+				s.shelf[S_SM] = e.cmd.Value
+			} else {
+				s.shelf[S_FFP2] = e.cmd.Value
+			}
+			return 0, "Admin: " + e.cmd.String()
+		}
+	}
+	x := e.req
+	if v, ok := s.served(e.op, x); ok {
+		return v, ""
+	}
+	answer, text := 1, ""
+	switch e.op {
+	case OP_START_WITHDRAWAL:
+		switch x.tipo {
+		case T_MIX:
+			s.workersInSurgical++
+			s.workersInFFP2++
+			s.surgicalMasks -= BMM
+			s.ffp2Masks -= BMM
+			text = fmt.Sprintf("Worker %d begins to withdraw a mixed batch", x.id)
+		case T_FFP2:
+			s.workersInFFP2++
+			s.ffp2Masks -= BFFP2
+			text = fmt.Sprintf("Worker %d begins to withdraw an FFP2 batch", x.id)
+		default:
+			s.workersInSurgical++
+			s.surgicalMasks -= BSM
+			text = fmt.Sprintf("Worker %d begins to withdraw a surgical mask batch", x.id)
+		}
+		s.withdrawals[x.id] = x.tipo
+		s.withdrawn[x.tipo]++
+
+	case OP_END_WITHDRAWAL:
+		switch s.withdrawals[x.id] {
+		case T_MIX:
+			s.workersInSurgical--
+			s.workersInFFP2--
+		case T_FFP2:
+			s.workersInFFP2--
+		default:
+			s.workersInSurgical--
+		}
+		delete(s.withdrawals, x.id)
+		text = fmt.Sprintf("Worker %d has finished the withdrawal", x.id)
+
+	case OP_START_DELIVERY:
+		switch {
+		case s.end:
+			answer = 0
+		case x.id == S_FFP2:
+			s.ffp2Masks = s.shelf[S_FFP2]
+			s.suppliersInFFP2++
+		default:
+			s.surgicalMasks = s.shelf[S_SM]
+			s.suppliersInSurgical++
+		}
+		if answer == 1 {
+			s.restocks[x.id]++
+			text = fmt.Sprintf("Supplier %d has started restocking the shelf for type %d", x.id, x.id)
+		}
+
+	case OP_END_DELIVERY:
+		if x.id == S_FFP2 {
+			s.suppliersInFFP2--
+		} else {
+			s.suppliersInSurgical--
+		}
+		text = fmt.Sprintf("Supplier %d has finished restocking the shelf for type %d", x.id, x.id)
+
+	case OP_WORKERS_DONE:
+		s.end = true
+		text = "The warehouse is about to close..."
+	}
+	s.sessions[clientOf(e.op, x.id)] = session{x.seq, answer}
+	return answer, text
+}
+
+// answer answers the command of an OP_ADMIN entry just applied, or a dump.
+func (s *shelfState) answer(cmd AdminCommand) {
+	switch {
+	case cmd.Op == ADMIN_PAUSE || cmd.Op == ADMIN_RESUME:
+		cmd.Reply("paused: %v", s.paused)
+	case cmd.Op == ADMIN_SET && cmd.Name == "SSM":
+		cmd.Reply("SSM: %d, surgical masks: %d", s.shelf[S_SM], s.surgicalMasks)
+	case cmd.Op == ADMIN_SET:
+		cmd.Reply("SFFP2: %d, FFP2 masks: %d", s.shelf[S_FFP2], s.ffp2Masks)
+	default: // ADMIN_DUMP
+		cmd.Reply("paused: %v; surgical: %d/%d masks, %d workers, %d suppliers; FFP2: %d/%d masks, %d workers, %d suppliers",
+			s.paused, s.surgicalMasks, s.shelf[S_SM], s.workersInSurgical, s.suppliersInSurgical,
+			s.ffp2Masks, s.shelf[S_FFP2], s.workersInFFP2, s.suppliersInFFP2)
+	}
+}
+
+// check verifies the invariants of the shelves.
+func (s *shelfState) check() error {
+	var workers [2]int
+	for _, tipo := range s.withdrawals {
+		if tipo != T_CHIR {
+			workers[S_FFP2]++
+		}
+		if tipo != T_FFP2 {
+			workers[S_SM]++
+		}
+	}
+	switch {
+	case s.surgicalMasks < 0 || s.surgicalMasks > SSM || s.ffp2Masks < 0 || s.ffp2Masks > SFFP2:
+		return fmt.Errorf("%d surgical and %d FFP2 masks on the shelves", s.surgicalMasks, s.ffp2Masks)
+	case workers[S_FFP2] != s.workersInFFP2 || workers[S_SM] != s.workersInSurgical:
+		return fmt.Errorf("%d and %d workers on the FFP2 and surgical shelves, %d withdrawals in progress",
+			s.workersInFFP2, s.workersInSurgical, len(s.withdrawals))
+	case s.suppliersInFFP2 > 0 && s.workersInFFP2 > 0 || s.suppliersInSurgical > 0 && s.workersInSurgical > 0:
+		return fmt.Errorf("a shelf restocked with workers on it")
+	case s.suppliersInFFP2 < 0 || s.suppliersInFFP2 > 1 || s.suppliersInSurgical < 0 || s.suppliersInSurgical > 1:
+		return fmt.Errorf("%d and %d suppliers on the FFP2 and surgical shelves", s.suppliersInFFP2, s.suppliersInSurgical)
+	}
+	return nil
+}
+
+// ============================================================
+//                          REPLICAS
+// ============================================================
+
+// Roles of a replica.
+const (
+	FOLLOWER = iota
+	CANDIDATE
+	LEADER
+)
+
+// Kinds of messages between the replicas.
+const (
+	MSG_APPEND       = iota // the leader sends the entries from prev+1, or none
+	MSG_APPEND_REPLY        // a follower tells whether its log matched
+	MSG_VOTE_REQUEST        // a candidate asks for a vote
+	MSG_VOTE                // the answer
+)
+
+// raftMsg is a message between the replicas.
+type raftMsg struct {
+	kind int // one of the MSG_* constants
+	term int // term of the sender
+	from int
+
+	prev     int     // APPEND: index of the entry before entries; VOTE_REQUEST: of the last one of the candidate
+	prevTerm int     // term of the entry at prev
+	entries  []entry // APPEND
+	commit   int     // APPEND: index of the last entry committed by the leader
+	match    int     // APPEND_REPLY: index of the last entry matching the leader (or of the last one, if not ok)
+	ok       bool    // APPEND_REPLY: the log matched at prev; VOTE: the vote is granted
+}
+
+// replica is a replica of the warehouse.
+type replica struct {
+	id    int
+	c     *cluster
+	inbox chan raftMsg
+	kill  chan bool
+	done  chan bool // closed when the goroutine of the replica returns
+
+	// Kept when the replica is killed
+	term     int
+	votedFor int     // -1: nobody in term
+	log      []entry // log[0] is a placeholder of term 0
+
+	// Lost when the replica is killed
+	role    int
+	commit  int // index of the last entry known to be committed
+	applied int // index of the last entry applied to state
+	state   *shelfState
+	timer   *time.Timer // election timeout, or heartbeat of the leader
+	votes   int         // votes received by a candidate
+	next    []int       // leader: index of the next entry to send to every replica
+	match   []int       // leader: index of the last entry known to be in its log
+	pending int         // leader: index of the last entry of a client, until applied
+}
+
+// electionTimeout returns a random election timeout.
+func electionTimeout() time.Duration {
+	return ELECTION_TIMEOUT + time.Duration(rand.Int63n(int64(ELECTION_TIMEOUT)))
+}
+
+// start runs the replica in a new goroutine, as a follower that has lost all
+// but its term, its vote and its log.
+func (r *replica) start() {
+	r.role, r.commit, r.applied, r.pending = FOLLOWER, 0, 0, 0
+	r.state = newShelfState()
+	r.done = make(chan bool)
+	for len(r.inbox) > 0 { // lost while the replica was dead
+		<-r.inbox
+	}
+	go r.run()
+}
+
+// run is warehouse() on the state of the replica, while it is the leader with
+// every entry applied, and the protocol of the replicas.
+func (r *replica) run() {
+	defer close(r.done)
+	r.timer = time.NewTimer(electionTimeout())
+	defer r.timer.Stop()
+	for {
+		s := r.state
+		serve := r.role == LEADER && r.applied == len(r.log)-1
+		admin := adminCommands
+		if !serve {
+			admin = nil
+		}
+		select {
+		case x := <-when(serve && s.canWithdraw(T_MIX), startwithdrawal[T_MIX]):
+			r.propose(OP_START_WITHDRAWAL, x)
+		case x := <-when(serve && s.canWithdraw(T_FFP2), startwithdrawal[T_FFP2]):
+			r.propose(OP_START_WITHDRAWAL, x)
+		case x := <-when(serve && s.canWithdraw(T_CHIR), startwithdrawal[T_CHIR]):
+			r.propose(OP_START_WITHDRAWAL, x)
+		case x := <-when(serve, endwithdrawal):
+			r.propose(OP_END_WITHDRAWAL, x)
+		case x := <-when(serve && (s.end || s.canDeliver(S_SM)), startDelivery[S_SM]):
+			r.propose(OP_START_DELIVERY, x)
+		case x := <-when(serve && (s.end || s.canDeliver(S_FFP2)), startDelivery[S_FFP2]):
+			r.propose(OP_START_DELIVERY, x)
+		case x := <-when(serve, endDelivery):
+			r.propose(OP_END_DELIVERY, x)
+		case x := <-when(serve, r.c.finished):
+			r.propose(OP_WORKERS_DONE, x)
+		case cmd := <-admin:
+			r.command(cmd)
+
+		case m := <-r.inbox:
+			r.handle(m)
+		case <-r.timer.C:
+			if r.role == LEADER {
+				r.broadcastAppend()
+				r.timer.Reset(HEARTBEAT)
+			} else {
+				r.campaign()
+			}
+		case <-r.kill:
+			fmt.Printf("[Warehouse %d] KILLED\n", r.id)
+			if r.role == LEADER {
+				r.failCommands()
+			}
+			return
+		case <-r.c.closing:
+			return
+		}
+	}
+}
+
+// propose appends the request x of op to the log of the leader, which answers
+// it once committed. A request already served is answered at once.
+func (r *replica) propose(op int, x request) {
+	take(x, r.term)
+	if v, ok := r.state.served(op, x); ok {
+		acknowledge(x, v)
+		return
+	}
+	r.log = append(r.log, entry{term: r.term, op: op, req: x})
+	r.pending = len(r.log) - 1
+	r.broadcastAppend()
+	r.advanceCommit()
+}
+
+// command takes an admin command: a dump is answered at once, a command that
+// changes the state is appended to the log, like a request.
+func (r *replica) command(cmd AdminCommand) {
+	switch cmd.Op {
+	case ADMIN_SET:
+		limit := SSM
+		switch cmd.Name {
+		case "SSM":
+		case "SFFP2":
+			limit = SFFP2
+		default:
+			cmd.Fail("no capacity %s (SSM, SFFP2)", cmd.Name)
+			return
+		}
+		if _, err := cmd.Capacity(limit); err != nil {
+			return
+		}
+	case ADMIN_OUT, ADMIN_IN:
+		cmd.Fail("nothing to take out of service in the warehouse: change the shelves with set SSM|SFFP2 <n>")
+		return
+	case ADMIN_DUMP:
+		r.state.answer(cmd)
+		return
+	}
+	r.log = append(r.log, entry{term: r.term, op: OP_ADMIN, cmd: cmd})
+	r.pending = len(r.log) - 1
+	r.broadcastAppend()
+	r.advanceCommit()
+}
+
+// failCommands fails the admin commands of the log not applied yet, when the
+// replica stops being the leader: they may be lost.
+func (r *replica) failCommands() {
+	for _, e := range r.log[r.applied+1:] {
+		if e.op == OP_ADMIN {
+			e.cmd.Fail("the leader of the warehouse changed: send the command again")
+		}
+	}
+}
+
+// applyCommitted applies the committed entries. The leader answers their
+// clients and prints those of its term; its NOOP tells the clients that it is
+// the leader.
+func (r *replica) applyCommitted() {
+	for r.applied < r.commit {
+		r.applied++
+		e := r.log[r.applied]
+		v, text := r.state.apply(e)
+		if r.role != LEADER {
+			continue
+		}
+		switch {
+		case e.op == OP_ADMIN:
+			r.state.answer(e.cmd)
+			if e.term == r.term {
+				fmt.Printf("[Warehouse %d] %s\n", r.id, text)
+			}
+		case e.op != OP_NOOP:
+			acknowledge(e.req, v)
+			if text != "" && e.term == r.term {
+				fmt.Printf("[Warehouse %d] %s\n", r.id, text)
+			}
+		case e.term == r.term:
+			r.c.announce(r.id, r.term)
+		}
+		if r.applied == r.pending {
+			r.pending = 0
+		}
+	}
+}
+
+// send sends m to replica to, unless its inbox is full.
+func (r *replica) send(to int, m raftMsg) {
+	m.term, m.from = r.term, r.id
+	select {
+	case r.c.replicas[to].inbox <- m:
+	default:
+	}
+}
+
+// sendAppend sends to replica to the entries it misses, as far as the leader
+// knows.
+func (r *replica) sendAppend(to int) {
+	prev := r.next[to] - 1
+	r.send(to, raftMsg{
+		kind:     MSG_APPEND,
+		prev:     prev,
+		prevTerm: r.log[prev].term,
+		entries:  slices.Clone(r.log[prev+1:]),
+		commit:   r.commit,
+	})
+}
+
+func (r *replica) broadcastAppend() {
+	for to := range r.c.replicas {
+		if to != r.id {
+			r.sendAppend(to)
+		}
+	}
+}
+
+// handle receives a message of another replica.
+func (r *replica) handle(m raftMsg) {
+	if m.term > r.term {
+		if r.role == LEADER {
+			r.timer.Reset(electionTimeout())
+			if r.pending > 0 { // its client may send the request again
+				r.c.wake()
+			}
+			r.failCommands()
+		}
+		r.term, r.votedFor, r.role, r.pending = m.term, -1, FOLLOWER, 0
+	}
+	switch m.kind {
+	case MSG_APPEND:
+		r.appendEntries(m)
+
+	case MSG_APPEND_REPLY:
+		if r.role != LEADER || m.term != r.term {
+			return
+		}
+		if m.ok {
+			r.match[m.from] = max(r.match[m.from], m.match)
+			r.next[m.from] = r.match[m.from] + 1
+			r.advanceCommit()
+		} else {
+			r.next[m.from] = max(1, min(r.next[m.from]-1, m.match+1))
+			r.sendAppend(m.from)
+		}
+
+	case MSG_VOTE_REQUEST:
+		last := len(r.log) - 1
+		recent := m.prevTerm > r.log[last].term || m.prevTerm == r.log[last].term && m.prev >= last
+		granted := m.term == r.term && (r.votedFor == -1 || r.votedFor == m.from) && recent
+		if granted {
+			r.votedFor = m.from
+			r.timer.Reset(electionTimeout())
+		}
+		r.send(m.from, raftMsg{kind: MSG_VOTE, ok: granted})
+
+	case MSG_VOTE:
+		if r.role == CANDIDATE && m.term == r.term && m.ok {
+			r.votes++
+			r.lead()
+		}
+	}
+}
+
+// appendEntries receives the entries of the leader, dropping the entries of the
+// log that disagree with them.
+func (r *replica) appendEntries(m raftMsg) {
+	if m.term < r.term {
+		r.send(m.from, raftMsg{kind: MSG_APPEND_REPLY, match: len(r.log) - 1})
+		return
+	}
+	r.role = FOLLOWER
+	r.timer.Reset(electionTimeout())
+	if m.prev >= len(r.log) || r.log[m.prev].term != m.prevTerm {
+		r.send(m.from, raftMsg{kind: MSG_APPEND_REPLY, match: min(len(r.log)-1, m.prev-1)})
+		return
+	}
+	for i, e := range m.entries {
+		index := m.prev + 1 + i
+		if index < len(r.log) && r.log[index].term != e.term {
+			r.log = r.log[:index]
+		}
+		if index == len(r.log) {
+			r.log = append(r.log, e)
+		}
+	}
+	match := m.prev + len(m.entries)
+	if m.commit > r.commit {
+		r.commit = min(m.commit, match)
+		r.applyCommitted()
+	}
+	r.send(m.from, raftMsg{kind: MSG_APPEND_REPLY, match: match, ok: true})
+}
+
+// campaign starts a new term and asks for the votes of the others.
+func (r *replica) campaign() {
+	r.role, r.term, r.votedFor, r.votes = CANDIDATE, r.term+1, r.id, 1
+	r.timer.Reset(electionTimeout())
+	last := len(r.log) - 1
+	for to := range r.c.replicas {
+		if to != r.id {
+			r.send(to, raftMsg{kind: MSG_VOTE_REQUEST, prev: last, prevTerm: r.log[last].term})
+		}
+	}
+	r.lead()
+}
+
+// lead makes a candidate with the votes of a majority the leader of its term.
+func (r *replica) lead() {
+	n := len(r.c.replicas)
+	if r.role != CANDIDATE || r.votes <= n/2 {
+		return
+	}
+	r.role = LEADER
+	r.log = append(r.log, entry{term: r.term, op: OP_NOOP})
+	r.next, r.match = make([]int, n), make([]int, n)
+	for id := range r.next {
+		r.next[id] = len(r.log) - 1
+	}
+	fmt.Printf("[Warehouse %d] is the leader of term %d, with %d entries in its log\n", r.id, r.term, len(r.log)-1)
+	r.broadcastAppend()
+	r.timer.Reset(HEARTBEAT)
+	r.advanceCommit()
+}
+
+// advanceCommit commits the entries of the term of the leader that a majority
+// of the replicas has, with those before them.
+func (r *replica) advanceCommit() {
+	for index := len(r.log) - 1; index > r.commit && r.log[index].term == r.term; index-- {
+		count := 1
+		for id, m := range r.match {
+			if id != r.id && m >= index {
+				count++
+			}
+		}
+		if count > len(r.c.replicas)/2 {
+			r.commit = index
+			r.applyCommitted()
+			return
+		}
+	}
+}
+
+// ============================================================
+//                          CLUSTER
+// ============================================================
+
+// cluster is the set of the replicas. kill, restart and chaos are called by
+// one goroutine at a time.
+type cluster struct {
+	replicas []*replica
+	finished chan request // main tells the leader that the workers are done
+	closing  chan bool    // closed to terminate the replicas
+	calm     chan bool    // closed to stop chaos
+	chaosEnd chan bool    // closed when chaos returns
+	kills    int
+
+	mu      sync.Mutex
+	leader  int       // the last leader announced, -1 before the first one
+	term    int       // its term
+	leaders int       // leaders announced
+	changed chan bool // closed at the next announcement, or when a leader steps down
+}
+
+func newCluster(n int) *cluster {
+	c := &cluster{
+		finished: make(chan request),
+		closing:  make(chan bool),
+		calm:     make(chan bool),
+		leader:   -1,
+		changed:  make(chan bool),
+	}
+	for id := range n {
+		c.replicas = append(c.replicas, &replica{
+			id:       id,
+			c:        c,
+			inbox:    make(chan raftMsg, INBOX),
+			kill:     make(chan bool),
+			votedFor: -1,
+			log:      []entry{{}},
+		})
+	}
+	return c
+}
+
+func (c *cluster) start() {
+	for _, r := range c.replicas {
+		r.start()
+	}
+}
+
+// wait waits for the answer to r, sent by a client. It reports false if the
+// leader that took r is not the leader any more: r may be lost, and must be
+// sent again.
+func (c *cluster) wait(r *request) (int, bool) {
+	taken := 0 // term of the leader that took r
+	for {
+		c.mu.Lock()
+		changed, term := c.changed, c.term
+		c.mu.Unlock()
+		select {
+		case v := <-r.ack:
+			return v, true
+		default:
+		}
+		if taken == 0 {
+			select {
+			case taken = <-r.taken:
+			default:
+			}
+		}
+		if taken > 0 && taken < term {
+			return 0, false
+		}
+		select {
+		case v := <-r.ack:
+			return v, true
+		case <-changed:
+		}
+	}
+}
+
+// announce tells the clients that replica id is the leader of term.
+func (c *cluster) announce(id, term int) {
+	c.mu.Lock()
+	c.leader, c.term = id, term
+	c.leaders++
+	c.mu.Unlock()
+	c.wake()
+}
+
+// wake wakes the clients waiting for an answer.
+func (c *cluster) wake() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	close(c.changed)
+	c.changed = make(chan bool)
+}
+
+// currentLeader returns the last leader announced, -1 before the first one.
+func (c *cluster) currentLeader() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leader
+}
+
+// kill kills replica id and waits for its goroutine to return.
+func (c *cluster) kill(id int) {
+	r := c.replicas[id]
+	r.kill <- true
+	<-r.done
+	c.kills++
+}
+
+// restart brings back replica id, killed before.
+func (c *cluster) restart(id int) {
+	r := c.replicas[id]
+	fmt.Printf("[Warehouse %d] is back in term %d, with %d entries in its log\n", id, r.term, len(r.log)-1)
+	r.start()
+}
+
+// chaos kills the leader every interval, once the replica killed the time
+// before is back, until stop.
+func (c *cluster) chaos(every time.Duration) {
+	c.chaosEnd = make(chan bool)
+	go func() {
+		defer close(c.chaosEnd)
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+		dead := -1
+		for {
+			select {
+			case <-tick.C:
+			case <-c.calm:
+				return
+			}
+			if dead >= 0 {
+				c.restart(dead)
+			}
+			dead = c.currentLeader()
+			if dead >= 0 {
+				c.kill(dead)
+			}
+		}
+	}()
+}
+
+// stop stops chaos and terminates the replicas alive.
+func (c *cluster) stop() {
+	close(c.calm)
+	if c.chaosEnd != nil {
+		<-c.chaosEnd
+	}
+	close(c.closing)
+	for _, r := range c.replicas {
+		<-r.done
+	}
+}
+
+// latest returns the replica with the most committed entries.
+func (c *cluster) latest() *replica {
+	latest := c.replicas[0]
+	for _, r := range c.replicas {
+		if r.commit > latest.commit {
+			latest = r
+		}
+	}
+	return latest
+}
+
+// verify checks the replicas, once stopped: they committed the same entries,
+// and the invariants of the shelves hold after every one of them.
+func (c *cluster) verify() error {
+	latest := c.latest()
+	for _, r := range c.replicas {
+		for index := 1; index <= r.commit; index++ {
+			if r.log[index] != latest.log[index] {
+				return fmt.Errorf("replicas %d and %d committed different entries at %d: %+v and %+v",
+					r.id, latest.id, index, r.log[index], latest.log[index])
+			}
+		}
+	}
+	s := newShelfState()
+	for index := 1; index <= latest.commit; index++ {
+		s.apply(latest.log[index])
+		if err := s.check(); err != nil {
+			return fmt.Errorf("entry %d (%+v): %v", index, latest.log[index], err)
+		}
+	}
+	return nil
+}
+
+// report writes the elections and the withdrawals of the run.
+func (c *cluster) report(w io.Writer) {
+	latest := c.latest()
+	s := latest.state
+	fmt.Fprintf(w, "\nREPLICAS: %d, %d leaders, %d replicas killed, %d requests sent again\n",
+		len(c.replicas), c.leaders, c.kills, resent.Load())
+	fmt.Fprintf(w, "  %d entries committed; batches withdrawn: %d mixed, %d FFP2, %d surgical; restocks: %d FFP2, %d surgical\n",
+		latest.commit, s.withdrawn[T_MIX], s.withdrawn[T_FFP2], s.withdrawn[T_CHIR], s.restocks[S_FFP2], s.restocks[S_SM])
+}
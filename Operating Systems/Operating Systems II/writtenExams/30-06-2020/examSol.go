@@ -1,8 +1,12 @@
+// Run with:
+//     go run examSol.go workload.go leaks.go admin.go
+
 package main
 
 import (
 	"fmt"
 	"math/rand"
+	"os"
 	"time"
 )
 
@@ -30,7 +34,7 @@ const northToSouth, southToNorth int = 0, 1 // Traffic directions
 /////////////////////////////////////////////////////////////////////
 var bridgeBoatCh [2]chan Request    // Boat channels [enter, exit]
 var bridgeVehicleInCh [4]chan Request // Vehicle entry channels [north, south, public_north, public_south]
-var bridgeVehicleOutCh = make(chan Request, MAXBUFF) // Vehicle exit channel
+var bridgeVehicleOutCh chan Request  // Vehicle exit channel
 
 // Channel indices
 const BOAT_ENTER, BOAT_EXIT int = 0, 1
@@ -39,8 +43,23 @@ const VEHICLE_NORTH, VEHICLE_SOUTH, PUBLIC_NORTH, PUBLIC_SOUTH int = 0, 1, 2, 3
 /////////////////////////////////////////////////////////////////////
 // Synchronization Channels
 /////////////////////////////////////////////////////////////////////
-var done = make(chan bool)       // Completion notification
-var terminate = make(chan bool)  // Termination signal
+var done chan bool       // Completion notification
+var terminate chan bool  // Termination signal
+
+// Creates all the channels above.
+// 'size' is the buffer of the boat/vehicle request channels (MAXBUFF in main).
+func initChannels(size int) {
+	for i := 0; i < 2; i++ {
+		bridgeBoatCh[i] = make(chan Request, size)
+	}
+	for i := 0; i < 4; i++ {
+		bridgeVehicleInCh[i] = make(chan Request, size)
+	}
+	bridgeVehicleOutCh = make(chan Request, size)
+
+	done = make(chan bool)
+	terminate = make(chan bool)
+}
 
 /////////////////////////////////////////////////////////////////////
 // Helper Functions
@@ -60,17 +79,11 @@ func sleepMilliseconds(t int) {
 	}
 }
 
-func sleepRandomSeconds(timeLimit int) {
-	if timeLimit > 0 {
-		time.Sleep(time.Duration(rand.Intn(timeLimit)+1) * time.Second)
-	}
-}
-
 /////////////////////////////////////////////////////////////////////
 // Goroutines
 /////////////////////////////////////////////////////////////////////
+// Vehicles and boats are started by main at the arrival times of the workload
 func vehicle(id int, vehicleType int) {
-	sleepRandomSeconds(15)
 	req := Request{id, make(chan int)}
 
 	// Request bridge access
@@ -91,7 +104,6 @@ func vehicle(id int, vehicleType int) {
 }
 
 func boat(id int) {
-	sleepRandomSeconds(15)
 	req := Request{id, make(chan int)}
 
 	// Request bridge entry
@@ -116,10 +128,17 @@ func bridgeManager() {
 	direction := northToSouth
 	vehiclesOnBridge := 0
 
+	// Admin commands (see admin.go): while paused no vehicle or boat gets on
+	// the bridge (those on it still leave); the capacity can be lowered below
+	// the vehicles on the bridge, who stay, and nobody else gets on until they
+	// drop below the new limit
+	paused := false
+	maxOnBridge := MAX_VEHICLE_CAPACITY
+
 	for {
 		select {
 		// Boat handling
-		case req := <-when(state == bridgeUp, bridgeBoatCh[BOAT_ENTER]):
+		case req := <-when(!paused && state == bridgeUp, bridgeBoatCh[BOAT_ENTER]):
 			vehiclesOnBridge++
 			fmt.Printf("\n[Bridge] Boat %d entering\tState: %d\tVehicles: %d", req.id, state, vehiclesOnBridge)
 			req.ack <- 1
@@ -136,8 +155,8 @@ func bridgeManager() {
 
 		// Vehicle handling (north to south)
 		case req := <-when(
-			state == bridgeDown && 
-			((vehiclesOnBridge > 0 && vehiclesOnBridge < MAX_VEHICLE_CAPACITY && direction == northToSouth) || 
+			!paused && state == bridgeDown && vehiclesOnBridge < maxOnBridge &&
+			((vehiclesOnBridge > 0 && direction == northToSouth) || 
 			(vehiclesOnBridge == 0 && direction == southToNorth)) && 
 			len(bridgeBoatCh[BOAT_ENTER]) == 0, 
 			bridgeVehicleInCh[PUBLIC_NORTH]):
@@ -151,8 +170,8 @@ func bridgeManager() {
 
 		// Vehicle handling (south to north)
 		case req := <-when(
-			state == bridgeDown && 
-			((vehiclesOnBridge > 0 && vehiclesOnBridge < MAX_VEHICLE_CAPACITY && direction == southToNorth) || 
+			!paused && state == bridgeDown && vehiclesOnBridge < maxOnBridge &&
+			((vehiclesOnBridge > 0 && direction == southToNorth) || 
 			(vehiclesOnBridge == 0 && direction == northToSouth)) && 
 			len(bridgeBoatCh[BOAT_ENTER]) == 0, 
 			bridgeVehicleInCh[PUBLIC_SOUTH]):
@@ -174,6 +193,30 @@ func bridgeManager() {
 				state = bridgeUp
 			}
 
+		// Admin command
+		case cmd := <-adminCommands:
+			switch cmd.Op {
+			case ADMIN_PAUSE, ADMIN_RESUME:
+				paused = cmd.Op == ADMIN_PAUSE
+				fmt.Printf("\n[Bridge] Admin: %s", cmd.Op)
+				cmd.Reply("paused: %v", paused)
+			case ADMIN_SET:
+				if cmd.Name != "MAX_VEHICLE_CAPACITY" {
+					cmd.Fail("no capacity %s (MAX_VEHICLE_CAPACITY)", cmd.Name)
+				} else if n, err := cmd.Capacity(MAX_VEHICLE_CAPACITY); err == nil {
+					maxOnBridge = n
+					fmt.Printf("\n[Bridge] Admin: at most %d vehicles\tVehicles: %d", maxOnBridge, vehiclesOnBridge)
+					cmd.Reply("MAX_VEHICLE_CAPACITY: %d, on the bridge: %d", maxOnBridge, vehiclesOnBridge)
+				}
+			case ADMIN_OUT, ADMIN_IN:
+				// There is one bridge, raised and lowered by the boats
+				cmd.Fail("nothing to take out of service on the bridge: pause it, or change the capacity with set MAX_VEHICLE_CAPACITY <n>")
+			default: // ADMIN_DUMP
+				cmd.Reply("paused: %v; bridge %s, %s; MAX_VEHICLE_CAPACITY: %d, on the bridge: %d; boats waiting: %d",
+					paused, []string{"up", "down"}[state], []string{"N->S", "S->N"}[direction], maxOnBridge, vehiclesOnBridge,
+					len(bridgeBoatCh[BOAT_ENTER]))
+			}
+
 		case <-terminate:
 			fmt.Printf("\n\n[Bridge] Terminating...")
 			done <- true
@@ -189,22 +232,28 @@ func main() {
 	rand.Seed(time.Now().Unix())
 
 	// Initialize channels
-	for i := 0; i < 2; i++ {
-		bridgeBoatCh[i] = make(chan Request, MAXBUFF)
-		bridgeVehicleInCh[i] = make(chan Request, MAXBUFF)
-	}
-	bridgeVehicleInCh[2] = make(chan Request, MAXBUFF)
-	bridgeVehicleInCh[3] = make(chan Request, MAXBUFF)
+	initChannels(MAXBUFF)
+
+	// Admin commands for the bridge, with -admin (see admin.go)
+	stopAdmin := StartAdminFromFlags()
 
 	go bridgeManager()
 
-	// Start vehicles and boats
-	for i := 0; i < MAX_VEHICLES; i++ {
-		go vehicle(i, rand.Intn(4))
-	}
-	for i := 0; i < MAX_BOATS; i++ {
-		go boat(i)
-	}
+	// Start vehicles and boats at their arrival times (-arrivals and -mix flags,
+	// see workload.go). The classes are the 4 vehicle types plus the boats: by
+	// default everyone arrives after 1-15 seconds, with MAX_VEHICLES vehicles
+	// equally split among the types and MAX_BOATS boats
+	const boatClass = 4
+	workload := WorkloadFromFlags([]string{"NORTH", "SOUTH", "PUBLIC_NORTH", "PUBLIC_SOUTH", "BOAT"}, "spread:max=15",
+		fmt.Sprintf("NORTH=%d,SOUTH=%d,PUBLIC_NORTH=%d,PUBLIC_SOUTH=%d,BOAT=%d",
+			MAX_VEHICLES/4, MAX_VEHICLES/4, MAX_VEHICLES/4, MAX_VEHICLES/4, MAX_BOATS))
+	workload.Spawn(MAX_VEHICLES+MAX_BOATS, func(id, class int) {
+		if class == boatClass {
+			boat(id)
+		} else {
+			vehicle(id, class)
+		}
+	})
 
 	// Wait for completion
 	for i := 0; i < MAX_VEHICLES+MAX_BOATS; i++ {
@@ -213,7 +262,13 @@ func main() {
 
 	terminate <- true
 	<-done
+	stopAdmin()
 	fmt.Printf("\n[Main] Simulation ended\n")
+
+	if err := CheckLeaks(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
 /*
@@ -0,0 +1,340 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR bridgeManager() (DRAWBRIDGE)
+//
+// The tests feed bridgeManager() scripted sequences of vehicles and boats
+// under a virtual clock, and check who crosses, in which order, that private
+// vehicles are never served, and that the bridge is down and empty at the end.
+// TestAdmin sends the bridge the commands of admin.go.
+//
+// Only public vehicles are used:
+//   - private vehicles (VEHICLE_NORTH, VEHICLE_SOUTH) have no case in the select
+//     and are never served;
+//   - boats are let through only when len(bridgeBoatCh[BOAT_ENTER]) > 0, which
+//     never happens with unbuffered channels, so they would wait forever.
+// An empty bridge only accepts vehicles going against the last direction, so a
+// lone vehicle may wait forever too. To keep traffic in both directions every
+// synthetic vehicle makes a round trip per cycle: south to north, then back
+// (the bridge starts in the north-to-south direction, so the first vehicle
+// must come from the south). For the same reason a vehicle cannot simply stop
+// when the measured cycles run out: the others could be left waiting on the
+// north side of an empty bridge. Every vehicle keeps crossing until b.N cycles
+// have completed, then the pending requests are abandoned.
+//
+// The benchmark reports:
+//   - grants/s:        round trips (two crossings each) per second;
+//   - p50-ns, p99-ns:  latency from the PUBLIC_SOUTH request to its ack;
+//   - allocs/op:       allocations per grant.
+// It runs twice: with unbuffered request channels and with buffered ones
+// (MAXBUFF slots).
+//
+// Run with:
+//     go test -race examSol.go workload.go leaks.go admin.go servertest_test.go examSol_test.go
+//     go test -run XXX -bench . examSol.go workload.go leaks.go admin.go servertest_test.go examSol_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// ============================================================
+//                   MEASUREMENT HELPERS
+// ============================================================
+
+const loadClients = 2 * MAX_VEHICLE_CAPACITY // Synthetic public vehicles
+
+// measureTraffic is measure (servertest_test.go) for clients that cannot stop
+// alone: cycle returns false if it was interrupted by stop, which is closed when
+// b.N cycles have completed. Clients keep running unmeasured cycles until then,
+// so the bridge never remains without traffic.
+func measureTraffic(b *testing.B, clients int, cycle func(id int, stop <-chan bool) (time.Duration, bool)) {
+	var left, completed atomic.Int64
+	left.Store(int64(b.N))
+	stop := make(chan bool)
+	lat := make([][]time.Duration, clients)
+	for id := range lat {
+		lat[id] = make([]time.Duration, 0, b.N/clients+1)
+	}
+	var wg sync.WaitGroup
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for id := 0; id < clients; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for {
+				measured := left.Add(-1) >= 0
+				d, ok := cycle(id, stop)
+				if !ok {
+					return
+				}
+				if measured {
+					lat[id] = append(lat[id], d)
+					if completed.Add(1) == int64(b.N) {
+						close(stop)
+					}
+				}
+			}
+		}(id)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	b.StopTimer()
+	report(b, slices.Concat(lat...), elapsed)
+}
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+// The vehicles have ids from 0 on, the boats from 100 on.
+
+const (
+	opNorth        = iota // bridgeVehicleInCh[PUBLIC_NORTH] <- Request
+	opSouth               // bridgeVehicleInCh[PUBLIC_SOUTH] <- Request
+	opPrivateNorth        // bridgeVehicleInCh[VEHICLE_NORTH] <- Request
+	opPrivateSouth        // bridgeVehicleInCh[VEHICLE_SOUTH] <- Request
+	opExit                // bridgeVehicleOutCh <- Request
+	opBoat                // bridgeBoatCh[BOAT_ENTER] <- Request
+	opBoatExit            // bridgeBoatCh[BOAT_EXIT] <- Request
+)
+
+var opNames = []string{"public north", "public south", "private north", "private south", "exit", "boat", "boat exit"}
+
+func do(s *script[int], st step) {
+	var c chan Request
+	switch st.op {
+	case opNorth:
+		c = bridgeVehicleInCh[PUBLIC_NORTH]
+	case opSouth:
+		c = bridgeVehicleInCh[PUBLIC_SOUTH]
+	case opPrivateNorth:
+		c = bridgeVehicleInCh[VEHICLE_NORTH]
+	case opPrivateSouth:
+		c = bridgeVehicleInCh[VEHICLE_SOUTH]
+	case opExit:
+		c = bridgeVehicleOutCh
+	case opBoat:
+		c = bridgeBoatCh[BOAT_ENTER]
+	case opBoatExit:
+		c = bridgeBoatCh[BOAT_EXIT]
+	}
+	r := Request{st.id, make(chan int)}
+	s.call(r.id, r.ack, func() { c <- r })
+}
+
+func TestBridge(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name    string
+		steps   []step
+		pending []int // clients that must never be served
+	}{
+		{"the first vehicle must come from the south", []step{
+			{opNorth, 0, nil},
+			{opSouth, 1, []int{1}},
+			{opExit, 1, []int{1, 0}},
+			{opExit, 0, []int{0}},
+		}, nil},
+		{"at most five vehicles in the same direction", []step{
+			{opSouth, 1, []int{1}},
+			{opSouth, 2, []int{2}},
+			{opSouth, 3, []int{3}},
+			{opSouth, 4, []int{4}},
+			{opSouth, 5, []int{5}},
+			{opSouth, 6, nil},
+			{opExit, 1, []int{1, 6}},
+			{opExit, 2, []int{2}},
+			{opExit, 3, []int{3}},
+			{opExit, 4, []int{4}},
+			{opExit, 5, []int{5}},
+			{opExit, 6, []int{6}},
+		}, nil},
+		{"a vehicle must not cross against the traffic", []step{
+			{opSouth, 1, []int{1}},
+			{opNorth, 2, nil},
+			{opSouth, 3, []int{3}},
+			{opExit, 1, []int{1}},
+			{opExit, 3, []int{3, 2}},
+			{opSouth, 4, nil},
+			{opExit, 2, []int{2, 4}},
+			{opExit, 4, []int{4}},
+		}, nil},
+		{"a waiting boat stops the traffic and raises the empty bridge", []step{
+			{opSouth, 1, []int{1}},
+			{opSouth, 2, []int{2}},
+			{opBoat, 100, nil},
+			// The guards are evaluated when the server enters select:
+			// the queued boat counts from the next event on.
+			{opExit, 2, []int{2}},
+			{opSouth, 3, nil},
+			{opExit, 1, []int{1, 100}},
+			{opNorth, 4, nil},
+			{opBoatExit, 100, []int{100, 4}},
+			{opExit, 4, []int{4, 3}},
+			{opExit, 3, []int{3}},
+		}, nil},
+		{"private vehicles are never served", []step{
+			{opPrivateNorth, 1, nil},
+			{opPrivateSouth, 2, nil},
+			{opSouth, 3, []int{3}},
+			{opExit, 3, []int{3}},
+		}, []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				initChannels(MAXBUFF)
+				go bridgeManager()
+				s := newScript[int](t)
+				for _, st := range tt.steps {
+					do(s, st)
+					s.expect(st.String(), st.want)
+				}
+				for _, id := range tt.pending {
+					if _, ok := s.waiting[id]; !ok {
+						t.Errorf("vehicle %d was served", id)
+					}
+					delete(s.waiting, id)
+				}
+				s.finish()
+				checkEmpty(s)
+				terminate <- true
+				<-done
+			})
+		})
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		initChannels(MAXBUFF)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go bridgeManager()
+		s := newScript[int](t)
+		play := func(steps ...step) {
+			t.Helper()
+			for _, st := range steps {
+				do(s, st)
+				s.expect(st.String(), st.want)
+			}
+		}
+
+		// One vehicle on the bridge: vehicle 2 waits, until the capacity is back
+		s.admin(Admin, "set MAX_VEHICLE_CAPACITY 1", "MAX_VEHICLE_CAPACITY: 1, on the bridge: 0", nil)
+		play(step{opSouth, 1, []int{1}}, step{opSouth, 2, nil})
+		s.admin(Admin, "dump", "paused: false; bridge down, S->N; MAX_VEHICLE_CAPACITY: 1, on the bridge: 1; boats waiting: 0", nil)
+		s.admin(Admin, "set MAX_VEHICLE_CAPACITY 5", "MAX_VEHICLE_CAPACITY: 5, on the bridge: 1", []int{2})
+
+		// Paused: the vehicles wait for resume
+		s.admin(Admin, "pause", "paused: true", nil)
+		play(step{opSouth, 3, nil})
+		s.admin(Admin, "resume", "paused: false", []int{3})
+
+		s.admin(Admin, "set MAX_VEHICLE_CAPACITY 6", "error: MAX_VEHICLE_CAPACITY cannot be above 5", nil)
+		s.admin(Admin, "set MAX_BOATS 1", "error: no capacity MAX_BOATS (MAX_VEHICLE_CAPACITY)", nil)
+		s.admin(Admin, "out bridge 0",
+			"error: nothing to take out of service on the bridge: pause it, or change the capacity with set MAX_VEHICLE_CAPACITY <n>", nil)
+		play(step{opExit, 1, []int{1}}, step{opExit, 2, []int{2}}, step{opExit, 3, []int{3}})
+		s.finish()
+		checkEmpty(s)
+		terminate <- true
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// checkEmpty verifies that the bridge is down and empty: of two vehicles in
+// opposite directions only the one reversing the traffic is let in, and the
+// other follows as soon as it exits.
+func checkEmpty(s *script[int]) {
+	s.t.Helper()
+	do(s, step{opNorth, 90, nil})
+	do(s, step{opSouth, 91, nil})
+	got := s.grants()
+	if len(got) != 1 {
+		s.t.Errorf("final state: granted %v to an empty bridge, want one vehicle", got)
+		return
+	}
+	first, other := got[0], 181-got[0]
+	for _, st := range []step{
+		{opExit, first, []int{first, other}},
+		{opExit, other, []int{other}},
+	} {
+		do(s, st)
+		s.expect("final state: "+st.String(), st.want)
+	}
+	s.finish()
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+// cross performs one crossing through the given entry channel and returns
+// the time waited for the grant, or false if stop was closed in the meantime.
+func cross(entry int, req Request, stop <-chan bool) (time.Duration, bool) {
+	t := time.Now()
+	if !call(bridgeVehicleInCh[entry], req, stop) {
+		return 0, false
+	}
+	d := time.Since(t)
+	return d, call(bridgeVehicleOutCh, req, stop)
+}
+
+// call sends req on c and waits for the ack, unless stop is closed first.
+func call(c chan Request, req Request, stop <-chan bool) bool {
+	select {
+	case c <- req:
+	case <-stop:
+		return false
+	}
+	select {
+	case <-req.ack:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func BenchmarkBridge(b *testing.B) {
+	silence(b)
+	for _, m := range bufferModes(MAXBUFF) {
+		b.Run(m.name, func(b *testing.B) {
+			initChannels(m.size)
+			go bridgeManager()
+
+			vehicles := make([]Request, loadClients)
+			for id := range vehicles {
+				// Buffered ack: the server must not block on a vehicle
+				// that abandoned its request after stop.
+				vehicles[id] = Request{id, make(chan int, 1)}
+			}
+
+			measureTraffic(b, loadClients, func(id int, stop <-chan bool) (time.Duration, bool) {
+				d, ok := cross(PUBLIC_SOUTH, vehicles[id], stop)
+				if !ok {
+					return 0, false
+				}
+				_, ok = cross(PUBLIC_NORTH, vehicles[id], stop)
+				return d, ok
+			})
+
+			terminate <- true
+			<-done
+		})
+	}
+}
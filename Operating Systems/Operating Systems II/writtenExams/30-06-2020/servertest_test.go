@@ -0,0 +1 @@
+../../servertest/servertest_test.go
\ No newline at end of file
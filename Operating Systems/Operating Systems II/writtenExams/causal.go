@@ -0,0 +1 @@
+../causal/causal.go
\ No newline at end of file
@@ -1,8 +1,12 @@
+// Run with:
+//     go run template.go workload.go leaks.go causal.go admin.go
+
 package main
 
 import (
 	"fmt"
 	"math/rand"
+	"os"
 	"strings"
 	"time"
 )
@@ -32,10 +36,11 @@ const (
 // Request represents the request that a Client/Worker or a Supplier
 // can make to the system. `ack` is the acknowledgment channel.
 type Request struct {
-	id   int      // Identifier of who is making the request
-	tipo int      // Indicates the type of resource involved
-	ack  chan int // Acknowledgment channel to signal completion of events,
-	              // possibly can be int if returning information
+	id    int      // Identifier of who is making the request
+	tipo  int      // Indicates the type of resource involved
+	ack   chan int // Acknowledgment channel to signal completion of events,
+	               // possibly can be int if returning information
+	stamp Stamp    // Logical time of the send, with -causal (see causal.go)
 }
 
 // ============================================================
@@ -60,13 +65,34 @@ var restockChan [2]chan Request
 
 // endRequest and endRestock: channels to signal the conclusion
 // of a retrieval/restock operation.
-var endRequest = make(chan Request, MAXBUFFER)
-var endRestock = make(chan Request)
+var endRequest chan Request
+var endRestock chan Request
+
+// Arrival times of the clients and mix of the resource types (see workload.go).
+var workload *Workload
 
 // Channels for process termination.
-var done = make(chan bool)
-var stopWarehouse = make(chan bool)
-var stopSupplier = make(chan bool)
+var done chan bool
+var stopWarehouse chan bool
+var stopSupplier chan bool
+
+// initChannels creates all the channels above.
+// `size` is the buffer of the client/supplier request channels (MAXBUFFER in main):
+// with size == 0 every len() in the guards is always 0.
+func initChannels(size int) {
+	for i := 0; i < len(requestChan); i++ {
+		requestChan[i] = make(chan Request, size)
+	}
+	for i := 0; i < len(restockChan); i++ {
+		restockChan[i] = make(chan Request, size)
+	}
+	endRequest = make(chan Request, size)
+	endRestock = make(chan Request)
+
+	done = make(chan bool)
+	stopWarehouse = make(chan bool)
+	stopSupplier = make(chan bool)
+}
 
 // ============================================================
 //                     SUPPORT FUNCTIONS
@@ -81,7 +107,7 @@ func when(b bool, c chan Request) chan Request {
 	return c
 }
 
-// Waits a random amount of time (in seconds) in the range [0, max).
+// Waits a random amount of time (in seconds) in the range [1, max].
 func sleepRandTime(max int) {
 	if max > 0 {
 		time.Sleep(time.Duration(rand.Intn(max)+1) * time.Second)
@@ -124,32 +150,32 @@ func debug(activeA, activeB, lenA, lenB, lenMix int) {
 func client(id int) {
 	tipo := -1
 	r := Request{id: id, tipo: tipo, ack: make(chan int)}
+	clock := NewClock(fmt.Sprintf("client %d", id))
 
-	fmt.Printf("[CLIENT %d] Started\n", id)
+	clock.Printf("[CLIENT %d] Started\n", id)
 	for i := 0; i < 5; i++ {
-		// Random choice of resource type (TYPE_A, TYPE_B, or TYPE_MIX).
-		tipoRand := rand.Intn(100)
-		if tipoRand >= 80 {
-			r.tipo = TYPE_MIX
-		} else {
-			r.tipo = tipoRand % 2 // 0 or 1
-		}
+		// Choice of resource type (TYPE_A, TYPE_B, or TYPE_MIX) following the workload mix.
+		r.tipo = workload.Class()
 
-		fmt.Printf("[CLIENT %d] Requesting resource %s\n", id, strings.ToUpper(getResourceName(r.tipo)))
+		clock.Printf("[CLIENT %d] Requesting resource %s\n", id, strings.ToUpper(getResourceName(r.tipo)))
+		r.stamp = clock.Send("requestChan")
 		requestChan[r.tipo] <- r // send request
 		<-r.ack                  // wait for start-ack
+		ReceivedOn(clock, r.ack, "ack")
 
-		fmt.Printf("[CLIENT %d] Retrieving resource %s...\n", id, strings.ToUpper(getResourceName(r.tipo)))
+		clock.Printf("[CLIENT %d] Retrieving resource %s...\n", id, strings.ToUpper(getResourceName(r.tipo)))
 		sleepRandTime(3) // simulate retrieval
 
+		r.stamp = clock.Send("endRequest")
 		endRequest <- r   // signal completion of retrieval
 		<-r.ack           // wait for the warehouse to finish the operation
+		ReceivedOn(clock, r.ack, "ack")
 	}
 
 	// After finishing all iterations, send a signal on the `done` channel
 	// to notify that this client has finished its work.
 	done <- true
-	fmt.Printf("[CLIENT %d] Terminating\n", id)
+	clock.Printf("[CLIENT %d] Terminating\n", id)
 }
 
 // supplier simulates a generic supplier that cyclically restocks
@@ -157,28 +183,33 @@ func client(id int) {
 func supplier(resourceType int) {
 	// ID not necessary here as it’s not used in the example
 	r := Request{tipo: resourceType, ack: make(chan int)}
+	clock := NewClock("supplier " + getResourceName(resourceType))
 
-	fmt.Printf("[SUPPLIER %s] Started\n", strings.ToUpper(getResourceName(resourceType)))
+	clock.Printf("[SUPPLIER %s] Started\n", strings.ToUpper(getResourceName(resourceType)))
 	for {
 		sleepRandTimeRange(5, 10)
 
-		fmt.Printf("[SUPPLIER %s] I want to restock the warehouse\n", strings.ToUpper(getResourceName(resourceType)))
+		clock.Printf("[SUPPLIER %s] I want to restock the warehouse\n", strings.ToUpper(getResourceName(resourceType)))
+		r.stamp = clock.Send("restockChan")
 		restockChan[resourceType] <- r // send restock request
 		<-r.ack                        // wait for start-ack
+		ReceivedOn(clock, r.ack, "ack")
 
-		fmt.Printf("[SUPPLIER %s] Restocking in progress...\n", strings.ToUpper(getResourceName(resourceType)))
+		clock.Printf("[SUPPLIER %s] Restocking in progress...\n", strings.ToUpper(getResourceName(resourceType)))
 		sleepRandTimeRange(3, 5) // simulate restocking
 
+		r.stamp = clock.Send("endRestock")
 		endRestock <- r    // signal completion
 		<-r.ack            // wait for the warehouse to complete the operation
-		fmt.Printf("[SUPPLIER %s] Restocking completed\n", strings.ToUpper(getResourceName(resourceType)))
+		ReceivedOn(clock, r.ack, "ack")
+		clock.Printf("[SUPPLIER %s] Restocking completed\n", strings.ToUpper(getResourceName(resourceType)))
 
 		// This select checks whether a stop signal was sent to terminate the supplier.
 		// If we don't receive anything from stopSupplier, continue the loop.
 		// Otherwise, exit the loop and terminate.
 		select {
 		case <-stopSupplier:
-			fmt.Printf("[SUPPLIER %s] Terminating\n", strings.ToUpper(getResourceName(resourceType)))
+			clock.Printf("[SUPPLIER %s] Terminating\n", strings.ToUpper(getResourceName(resourceType)))
 			done <- true
 			return
 		default:
@@ -192,6 +223,7 @@ func supplier(resourceType int) {
 // plus a “mixed” type (TYPE_MIX).
 // Insert any precedence/priority conditions required by your exam or scenario here.
 func warehouse() {
+	clock := NewClock("warehouse")
 	// How many resources are available initially (you can change the initialization logic)
 	resources := [2]int{MAX_A, MAX_B}
 
@@ -202,8 +234,14 @@ func warehouse() {
 	activePrel := [2]int{0, 0}
 	activeRestock := [2]bool{false, false}
 
-	fmt.Printf("[WAREHOUSE] Started. Initial state: A: %d/%d, B: %d/%d\n",
-		resources[TYPE_A], MAX_A, resources[TYPE_B], MAX_B)
+	// Admin commands (see admin.go): while paused no retrieval starts (the
+	// suppliers still restock); capacity holds the amount a restock brings
+	// each resource type to
+	paused := false
+	capacity := [2]int{MAX_A, MAX_B}
+
+	clock.Printf("[WAREHOUSE] Started. Initial state: A: %d/%d, B: %d/%d\n",
+		resources[TYPE_A], capacity[TYPE_A], resources[TYPE_B], capacity[TYPE_B])
 
 	for {
 		select {
@@ -212,45 +250,52 @@ func warehouse() {
 		//---------------------------------------------------
 		case req := <-when(
 			// Conditions to allow retrieval of TYPE_A
-			((LOT_A * (activePrel[TYPE_A] + 1)) <= resources[TYPE_A]) &&
+			!paused &&
+				((LOT_A * (activePrel[TYPE_A] + 1)) <= resources[TYPE_A]) &&
 				(!activeRestock[TYPE_A]) &&
 				(len(requestChan[TYPE_MIX]) == 0), // e.g., give priority to TYPE_MIX
 			requestChan[TYPE_A]):
+			clock.Receive(req.stamp, "requestChan[TYPE_A]")
 
 			activePrel[TYPE_A]++
-			fmt.Printf("[WAREHOUSE] Client %d begins retrieval of %d (type A)\n",
+			clock.Printf("[WAREHOUSE] Client %d begins retrieval of %d (type A)\n",
 				req.id, LOT_A)
-			req.ack <- 1 // unblock the client
+			SendOn(clock, req.ack, 1, "ack") // unblock the client
 
 		case req := <-when(
 			// Conditions to allow retrieval of TYPE_B
-			((LOT_B * (activePrel[TYPE_B] + 1)) <= resources[TYPE_B]) &&
+			!paused &&
+				((LOT_B * (activePrel[TYPE_B] + 1)) <= resources[TYPE_B]) &&
 				(!activeRestock[TYPE_B]) &&
 				(len(requestChan[TYPE_MIX]) == 0 && len(requestChan[TYPE_A]) == 0),
 			requestChan[TYPE_B]):
+			clock.Receive(req.stamp, "requestChan[TYPE_B]")
 
 			activePrel[TYPE_B]++
-			fmt.Printf("[WAREHOUSE] Client %d begins retrieval of %d (type B)\n",
+			clock.Printf("[WAREHOUSE] Client %d begins retrieval of %d (type B)\n",
 				req.id, LOT_B)
-			req.ack <- 1 // unblock the client
+			SendOn(clock, req.ack, 1, "ack") // unblock the client
 
 		case req := <-when(
 			// Conditions to allow retrieval of TYPE_MIX (both A and B)
-			((LOT_MIX * (activePrel[TYPE_A] + 1)) <= resources[TYPE_A] &&
+			!paused &&
+				((LOT_MIX * (activePrel[TYPE_A] + 1)) <= resources[TYPE_A] &&
 				(LOT_MIX * (activePrel[TYPE_B] + 1)) <= resources[TYPE_B]) &&
 				(!activeRestock[TYPE_A] && !activeRestock[TYPE_B]),
 			requestChan[TYPE_MIX]):
+			clock.Receive(req.stamp, "requestChan[TYPE_MIX]")
 
 			activePrel[TYPE_A]++
 			activePrel[TYPE_B]++
-			fmt.Printf("[WAREHOUSE] Client %d begins MIXED retrieval of %d (A) and %d (B)\n",
+			clock.Printf("[WAREHOUSE] Client %d begins MIXED retrieval of %d (A) and %d (B)\n",
 				req.id, LOT_MIX, LOT_MIX)
-			req.ack <- 1 // unblock the client
+			SendOn(clock, req.ack, 1, "ack") // unblock the client
 
 		//---------------------------------------------------
 		//             RETRIEVAL (END)
 		//---------------------------------------------------
 		case req := <-endRequest:
+			clock.Receive(req.stamp, "endRequest")
 			switch req.tipo {
 			case TYPE_A:
 				resources[TYPE_A] -= LOT_A
@@ -264,12 +309,12 @@ func warehouse() {
 				activePrel[TYPE_A]--
 				activePrel[TYPE_B]--
 			default:
-				fmt.Println("[WAREHOUSE] ERROR: invalid resource type.")
+				clock.Printf("[WAREHOUSE] ERROR: invalid resource type.\n")
 			}
-			fmt.Printf("[WAREHOUSE] Client %d has finished. State: A: %d/%d, B: %d/%d\n",
-				req.id, resources[TYPE_A], MAX_A, resources[TYPE_B], MAX_B)
+			clock.Printf("[WAREHOUSE] Client %d has finished. State: A: %d/%d, B: %d/%d\n",
+				req.id, resources[TYPE_A], capacity[TYPE_A], resources[TYPE_B], capacity[TYPE_B])
 			// Unblock the client if needed:
-			req.ack <- 1
+			SendOn(clock, req.ack, 1, "ack")
 
 		//---------------------------------------------------
 		//           RESTOCK (START)
@@ -279,46 +324,84 @@ func warehouse() {
 			(activePrel[TYPE_A] == 0) &&
 				(resources[TYPE_A] <= resources[TYPE_B] || len(restockChan[TYPE_B]) == 0),
 			restockChan[TYPE_A]):
+			clock.Receive(req.stamp, "restockChan[TYPE_A]")
 			activeRestock[TYPE_A] = true
-			fmt.Printf("[WAREHOUSE] Starting restock of A...\n")
-			req.ack <- 1
+			clock.Printf("[WAREHOUSE] Starting restock of A...\n")
+			SendOn(clock, req.ack, 1, "ack")
 
 		case req := <-when(
 			// Condition to restock TYPE_B
 			(activePrel[TYPE_B] == 0) &&
 				(resources[TYPE_B] < resources[TYPE_A] || len(restockChan[TYPE_A]) == 0),
 			restockChan[TYPE_B]):
+			clock.Receive(req.stamp, "restockChan[TYPE_B]")
 			activeRestock[TYPE_B] = true
-			fmt.Printf("[WAREHOUSE] Starting restock of B...\n")
-			req.ack <- 1
+			clock.Printf("[WAREHOUSE] Starting restock of B...\n")
+			SendOn(clock, req.ack, 1, "ack")
 
 		//---------------------------------------------------
 		//           RESTOCK (END)
 		//---------------------------------------------------
 		case req := <-endRestock:
+			clock.Receive(req.stamp, "endRestock")
 			switch req.tipo {
 			case TYPE_A:
-				resources[TYPE_A] = MAX_A
+				resources[TYPE_A] = capacity[TYPE_A]
 				activeRestock[TYPE_A] = false
-				fmt.Printf("[WAREHOUSE] Finished restocking A. A: %d/%d, B: %d/%d\n",
-					resources[TYPE_A], MAX_A, resources[TYPE_B], MAX_B)
-				req.ack <- 1
+				clock.Printf("[WAREHOUSE] Finished restocking A. A: %d/%d, B: %d/%d\n",
+					resources[TYPE_A], capacity[TYPE_A], resources[TYPE_B], capacity[TYPE_B])
+				SendOn(clock, req.ack, 1, "ack")
 			case TYPE_B:
-				resources[TYPE_B] = MAX_B
+				resources[TYPE_B] = capacity[TYPE_B]
 				activeRestock[TYPE_B] = false
-				fmt.Printf("[WAREHOUSE] Finished restocking B. A: %d/%d, B: %d/%d\n",
-					resources[TYPE_A], MAX_A, resources[TYPE_B], MAX_B)
-				req.ack <- 1
+				clock.Printf("[WAREHOUSE] Finished restocking B. A: %d/%d, B: %d/%d\n",
+					resources[TYPE_A], capacity[TYPE_A], resources[TYPE_B], capacity[TYPE_B])
+				SendOn(clock, req.ack, 1, "ack")
 			default:
-				fmt.Println("[WAREHOUSE] ERROR: invalid resource type.")
-				req.ack <- -1
+				clock.Printf("[WAREHOUSE] ERROR: invalid resource type.\n")
+				SendOn(clock, req.ack, -1, "ack")
+			}
+
+		//---------------------------------------------------
+		//             ADMIN
+		//---------------------------------------------------
+		case cmd := <-adminCommands:
+			switch cmd.Op {
+			case ADMIN_PAUSE, ADMIN_RESUME:
+				paused = cmd.Op == ADMIN_PAUSE
+				clock.Printf("[WAREHOUSE] Admin: %s\n", cmd.Op)
+				cmd.Reply("paused: %v", paused)
+			case ADMIN_SET:
+				switch cmd.Name {
+				case "MAX_A":
+					if n, err := cmd.Capacity(MAX_A); err == nil {
+						capacity[TYPE_A] = n
+						clock.Printf("[WAREHOUSE] Admin: A is restocked to %d\n", n)
+						cmd.Reply("MAX_A: %d, A: %d", n, resources[TYPE_A])
+					}
+				case "MAX_B":
+					if n, err := cmd.Capacity(MAX_B); err == nil {
+						capacity[TYPE_B] = n
+						clock.Printf("[WAREHOUSE] Admin: B is restocked to %d\n", n)
+						cmd.Reply("MAX_B: %d, B: %d", n, resources[TYPE_B])
+					}
+				default:
+					cmd.Fail("no capacity %s (MAX_A, MAX_B)", cmd.Name)
+				}
+			case ADMIN_OUT, ADMIN_IN:
+				// The resources are only counted
+				cmd.Fail("nothing to take out of service in the warehouse: change the capacities with set MAX_A|MAX_B <n>")
+			default: // ADMIN_DUMP
+				cmd.Reply("paused: %v; A: %d/%d, %d retrievals, restock %v; B: %d/%d, %d retrievals, restock %v",
+					paused, resources[TYPE_A], capacity[TYPE_A], activePrel[TYPE_A], activeRestock[TYPE_A],
+					resources[TYPE_B], capacity[TYPE_B], activePrel[TYPE_B], activeRestock[TYPE_B])
 			}
 
 		//---------------------------------------------------
 		//             TERMINATION
 		//---------------------------------------------------
 		case <-stopWarehouse:
-			fmt.Printf("[WAREHOUSE] Terminating\n")
+			clock.Printf("[WAREHOUSE] Terminating\n")
 			done <- true
 			return
 		}
@@ -350,12 +433,13 @@ func main() {
 	}
 
 	// Initialize main channels
-	for i := 0; i < len(requestChan); i++ {
-		requestChan[i] = make(chan Request, MAXBUFFER)
-	}
-	for i := 0; i < len(restockChan); i++ {
-		restockChan[i] = make(chan Request, MAXBUFFER)
-	}
+	initChannels(MAXBUFFER)
+
+	// With -causal, the logical clocks of the messages go to a trace (see causal.go)
+	StartCausal()
+
+	// Admin commands for the warehouse, with -admin (see admin.go)
+	stopAdmin := StartAdminFromFlags()
 
 	// Start goroutines
 	go warehouse() // resource manager
@@ -365,10 +449,11 @@ func main() {
 		go supplier(i) // i corresponds to TYPE_A or TYPE_B
 	}
 
-	// Start clients
-	for i := 0; i < nClients; i++ {
-		go client(i)
-	}
+	// Start clients at their arrival times (-arrivals and -mix flags, see workload.go).
+	// By default they all start at once and ask for TYPE_MIX 20% of the times,
+	// TYPE_A and TYPE_B 40% each
+	workload = WorkloadFromFlags([]string{"A", "B", "MIX"}, "spread:max=0", "A=40,B=40,MIX=20")
+	workload.Spawn(nClients, func(id, _ int) { client(id) })
 
 	// The `done` channel is used as a completion signal by clients and suppliers.
 	// When a client finishes, it sends `true` on the `done` channel.
@@ -391,8 +476,15 @@ func main() {
 	// Signal warehouse to terminate
 	stopWarehouse <- true
 	<-done
+	stopAdmin()
+	StopCausal()
 
 	fmt.Println("[MAIN] End")
+
+	if err := CheckLeaks(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
 // ============================================================
@@ -0,0 +1,288 @@
+// -----------------------------------------------------------------------------------
+// TESTS AND BENCHMARKS FOR warehouse() (EXAM TEMPLATE)
+//
+// The tests feed warehouse() scripted sequences of retrievals and restocks
+// under a virtual clock, and check who is served, in which order, and that
+// nobody is using the warehouse at the end.
+// TestAdmin sends the warehouse the commands of admin.go.
+//
+// Synthetic clients repeat the cycle of client() without any sleep, each one
+// always asking for the same resource type: 2 clients in 10 ask for TYPE_MIX
+// (like the 20% of tipoRand >= 80), the others alternate TYPE_A and TYPE_B.
+// Two synthetic suppliers restock as soon as the guards allow it and stop at
+// the end of the measurement, like in main().
+//
+// The benchmark reports:
+//   - grants/s:        retrievals started per second;
+//   - p50-ns, p99-ns:  latency from requestChan to its ack;
+//   - allocs/op:       allocations per grant.
+// It runs twice: with unbuffered request channels and with buffered ones
+// (MAXBUFFER slots).
+//
+// Run with:
+//     go test -race template.go workload.go leaks.go causal.go admin.go servertest_test.go template_test.go
+//     go test -run XXX -bench . template.go workload.go leaks.go causal.go admin.go servertest_test.go template_test.go
+// -----------------------------------------------------------------------------------
+
+package main
+
+import (
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+const loadClients = MAX_CLIENTS // Synthetic clients
+
+// ============================================================
+//                           TESTS
+// ============================================================
+
+// The suppliers are the clients TYPE_A and TYPE_B, the other clients have ids
+// from 10 on.
+
+const (
+	opA         = iota // requestChan[TYPE_A] <- Request
+	opB                // requestChan[TYPE_B] <- Request
+	opMix              // requestChan[TYPE_MIX] <- Request
+	opEnd              // endRequest <- Request
+	opRestock          // restockChan[id] <- Request
+	opRestocked        // endRestock <- Request
+)
+
+var opNames = []string{"retrieve A", "retrieve B", "retrieve MIX", "end", "restock", "end restock"}
+
+// depot runs the steps of a test, remembering the request of every client.
+type depot struct {
+	*script[int]
+	requests map[int]Request
+}
+
+func (s *depot) do(st step) {
+	switch st.op {
+	case opA, opB, opMix:
+		r := Request{id: st.id, tipo: st.op - opA, ack: make(chan int)}
+		s.requests[r.id] = r
+		s.call(r.id, r.ack, func() { requestChan[r.tipo] <- r })
+	case opRestock:
+		r := Request{id: st.id, tipo: st.id, ack: make(chan int)}
+		s.requests[r.id] = r
+		s.call(r.id, r.ack, func() { restockChan[r.tipo] <- r })
+	case opEnd, opRestocked:
+		r := s.requests[st.id]
+		c := endRequest
+		if st.op == opRestocked {
+			c = endRestock
+		}
+		s.call(r.id, r.ack, func() { c <- r })
+	}
+}
+
+func TestWarehouse(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"MIX first, then A, then B after a restock", []step{
+			{opB, 9, []int{9}},
+			{opRestock, TYPE_A, []int{TYPE_A}},
+			{opA, 10, nil},
+			{opMix, 11, nil},
+			// The guards are evaluated when the server enters select:
+			// the queued retrievals count from the next event on.
+			{opEnd, 9, []int{9}},
+			{opB, 12, nil},
+			{opRestocked, TYPE_A, []int{TYPE_A, 11, 10, 12}},
+			{opEnd, 10, []int{10}},
+			{opEnd, 11, []int{11}},
+			{opEnd, 12, []int{12}},
+		}},
+		{"the emptier shelf is restocked first (B)", []step{
+			{opMix, 10, []int{10}},
+			{opRestock, TYPE_A, nil},
+			{opRestock, TYPE_B, nil},
+			{opEnd, 10, []int{10, TYPE_B, TYPE_A}},
+			{opRestocked, TYPE_A, []int{TYPE_A}},
+			{opRestocked, TYPE_B, []int{TYPE_B}},
+		}},
+		{"the emptier shelf is restocked first (A)", []step{
+			{opA, 10, []int{10}},
+			{opA, 11, []int{11}},
+			{opEnd, 10, []int{10}},
+			{opEnd, 11, []int{11}},
+			{opMix, 12, []int{12}},
+			{opRestock, TYPE_B, nil},
+			{opRestock, TYPE_A, nil},
+			{opEnd, 12, []int{12, TYPE_A, TYPE_B}},
+			{opRestocked, TYPE_B, []int{TYPE_B}},
+			{opRestocked, TYPE_A, []int{TYPE_A}},
+		}},
+		{"a restock waits for the retrievals of its type", []step{
+			{opA, 10, []int{10}},
+			{opRestock, TYPE_A, nil},
+			{opB, 11, []int{11}},
+			{opEnd, 10, []int{10, TYPE_A}},
+			{opA, 12, nil},
+			{opRestocked, TYPE_A, []int{TYPE_A, 12}},
+			{opEnd, 11, []int{11}},
+			{opEnd, 12, []int{12}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				initChannels(MAXBUFFER)
+				go warehouse()
+				s := &depot{newScript[int](t), map[int]Request{}}
+				for _, st := range tt.steps {
+					s.do(st)
+					s.expect(st.String(), st.want)
+				}
+				s.finish()
+				s.checkIdle()
+				stopWarehouse <- true
+				<-done
+			})
+		})
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	silence(t)
+	synctest.Test(t, func(t *testing.T) {
+		initChannels(MAXBUFFER)
+		stop, err := StartAdmin("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go warehouse()
+		s := &depot{newScript[int](t), map[int]Request{}}
+		play := func(steps ...step) {
+			t.Helper()
+			for _, st := range steps {
+				s.do(st)
+				s.expect(st.String(), st.want)
+			}
+		}
+
+		// A restocked to 1000: one retrieval of A at a time
+		s.admin(Admin, "set MAX_A 1000", "MAX_A: 1000, A: 4000", nil)
+		play(
+			step{opRestock, TYPE_A, []int{TYPE_A}},
+			step{opRestocked, TYPE_A, []int{TYPE_A}},
+			step{opA, 10, []int{10}},
+			step{opA, 11, nil},
+		)
+		s.admin(Admin, "dump", "paused: false; A: 1000/1000, 1 retrievals, restock false; "+
+			"B: 3000/3000, 0 retrievals, restock false", nil)
+		play(
+			step{opEnd, 10, []int{10}},
+			step{opRestock, TYPE_A, []int{TYPE_A}},
+			step{opRestocked, TYPE_A, []int{TYPE_A, 11}},
+			step{opEnd, 11, []int{11}},
+		)
+		s.admin(Admin, "set MAX_A 4000", "MAX_A: 4000, A: 300", nil)
+
+		// Paused: the clients wait for resume
+		s.admin(Admin, "pause", "paused: true", nil)
+		play(step{opB, 12, nil})
+		s.admin(Admin, "resume", "paused: false", []int{12})
+		play(step{opEnd, 12, []int{12}})
+
+		s.admin(Admin, "set MAX_B 3001", "error: MAX_B cannot be above 3000", nil)
+		s.admin(Admin, "set LOT_A 1", "error: no capacity LOT_A (MAX_A, MAX_B)", nil)
+		s.admin(Admin, "out shelf 0",
+			"error: nothing to take out of service in the warehouse: change the capacities with set MAX_A|MAX_B <n>", nil)
+		s.finish()
+		s.checkIdle()
+		stopWarehouse <- true
+		<-done
+		stop()
+		adminCommands, adminClosed = nil, nil
+	})
+}
+
+// checkIdle verifies that nobody is using the warehouse: both shelves are
+// restocked at once, one after the other, and then a MIX retrieval is served.
+func (s *depot) checkIdle() {
+	s.t.Helper()
+	for _, st := range []step{
+		{opRestock, TYPE_A, []int{TYPE_A}},
+		{opRestocked, TYPE_A, []int{TYPE_A}},
+		{opRestock, TYPE_B, []int{TYPE_B}},
+		{opRestocked, TYPE_B, []int{TYPE_B}},
+		{opMix, 90, []int{90}},
+		{opEnd, 90, []int{90}},
+	} {
+		s.do(st)
+		s.expect("final state: "+st.String(), st.want)
+	}
+	s.finish()
+}
+
+// ============================================================
+//                         BENCHMARKS
+// ============================================================
+
+// benchSupplier is supplier() without the sleeps. It closes stopped
+// after receiving from stopSupplier.
+func benchSupplier(resourceType int, stopped chan bool) {
+	r := Request{tipo: resourceType, ack: make(chan int)}
+	for {
+		restockChan[resourceType] <- r
+		<-r.ack
+		endRestock <- r
+		<-r.ack
+
+		select {
+		case <-stopSupplier:
+			close(stopped)
+			return
+		default:
+		}
+	}
+}
+
+func BenchmarkWarehouse(b *testing.B) {
+	silence(b)
+	for _, m := range bufferModes(MAXBUFFER) {
+		b.Run(m.name, func(b *testing.B) {
+			initChannels(m.size)
+			go warehouse()
+			stopped := [2]chan bool{make(chan bool), make(chan bool)}
+			for t := range stopped {
+				go benchSupplier(t, stopped[t])
+			}
+
+			clients := make([]Request, loadClients)
+			for id := range clients {
+				tipo := id % 2
+				if id%10 >= 8 {
+					tipo = TYPE_MIX
+				}
+				clients[id] = Request{id: id, tipo: tipo, ack: make(chan int)}
+			}
+
+			measure(b, loadClients, func(id int) time.Duration {
+				r := clients[id]
+				t := time.Now()
+				requestChan[r.tipo] <- r
+				<-r.ack
+				d := time.Since(t)
+				endRequest <- r
+				<-r.ack
+				return d
+			})
+
+			for range stopped {
+				stopSupplier <- true
+			}
+			for _, s := range stopped {
+				<-s
+			}
+			stopWarehouse <- true
+			<-done
+		})
+	}
+}